@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/notify"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("alert-notifier", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Route sarama's internal logs into the service logs
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	// Create metrics server (next to the api-server port)
+	metricsPort := cfg.MetricsPort + 8 // Use port 2120 by default
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
+	metricsServer.Start()
+	defer metricsServer.Stop()
+
+	// Wait for Kafka, and for PostgreSQL when templates are stored in it
+	dependencies := []startup.Dependency{startup.KafkaDependency(cfg)}
+	if cfg.NotifierTemplateRefreshInterval > 0 {
+		dependencies = append(dependencies, startup.PostgresDependency(cfg))
+	}
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), dependencies...); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
+
+	// Load the destinations and create the consumer of sensor.alert
+	service, err := notify.NewService(cfg, metricsServer.Registry(), logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create alert notifier", "error", err)
+	}
+
+	// Serve the payload schemas seen by the consumer next to the metrics
+	if schemas := kafka.SchemaTrackerFromConfig(cfg, metricsServer.Registry()); schemas != nil {
+		metricsServer.Handle("/schemas", schemas)
+	}
+
+	// Serve the stored template endpoints next to the metrics
+	if service.Templates != nil {
+		metricsServer.Handle("/admin/templates", service.Templates)
+		metricsServer.Handle("/admin/templates/", service.Templates)
+	}
+
+	// Start delivering alerts
+	if err := service.Start(); err != nil {
+		logging.Fatal(logger, "Failed to start alert notifier", "error", err)
+	}
+	waiter.Ready()
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for termination signal
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	service.Stop()
+
+	logger.Info("Alert notifier shutdown complete")
+}
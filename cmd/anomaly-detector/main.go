@@ -1,228 +1,139 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/IBM/sarama"
 	"github.com/example/iot-sensor-fleet/internal/config"
 	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/detector"
 	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
 	"github.com/example/iot-sensor-fleet/internal/metrics"
 	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/soak"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+	"github.com/example/iot-sensor-fleet/internal/tracing"
 )
 
-// AnomalyDetector processes sensor readings and detects anomalies
-type AnomalyDetector struct {
-	consumer       *kafka.Consumer
-	producer       *kafka.Producer
-	dltProducer    *kafka.Producer
-	metrics        *metrics.AnomalyDetectorMetrics
-	maxTemperature float32
-	minHumidity    float32
-}
-
-// NewAnomalyDetector creates a new anomaly detector
-func NewAnomalyDetector(
-	consumer *kafka.Consumer,
-	producer *kafka.Producer,
-	dltProducer *kafka.Producer,
-	metrics *metrics.AnomalyDetectorMetrics,
-	maxTemperature float32,
-	minHumidity float32,
-) *AnomalyDetector {
-	return &AnomalyDetector{
-		consumer:       consumer,
-		producer:       producer,
-		dltProducer:    dltProducer,
-		metrics:        metrics,
-		maxTemperature: maxTemperature,
-		minHumidity:    minHumidity,
-	}
-}
-
-// Start starts the anomaly detector
-func (a *AnomalyDetector) Start() error {
-	return a.consumer.Start()
-}
-
-// Stop stops the anomaly detector
-func (a *AnomalyDetector) Stop() {
-	a.consumer.Stop()
-}
-
-// handleMessage processes a message from Kafka
-func (a *AnomalyDetector) handleMessage(message *sarama.ConsumerMessage) error {
-	startTime := time.Now()
-
-	// Update metrics
-	if a.metrics != nil {
-		a.metrics.MessagesProcessedTotal.Inc()
-	}
-
-	// Deserialize the message
-	reading, err := model.DeserializeSensorReading(message.Value)
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Printf("Error deserializing message: %v", err)
-
-		// Send to DLT
-		if a.dltProducer != nil {
-			a.dltProducer.SendMessage(message.Key, message.Value)
-			if a.metrics != nil {
-				a.metrics.DLTMessagesTotal.Inc()
-			}
-		}
-
-		return err
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Validate the reading
-	valid, reason := model.ValidateSensorReading(reading)
-	if !valid {
-		log.Printf("Anomaly detected: %s, sensor: %s, temp: %.1f°C, humidity: %.1f%%",
-			reason, reading.ID, reading.Temperature, reading.Humidity)
-
-		// Create alert
-		alert := model.NewSensorAlert(reading, reason)
-
-		// Serialize alert
-		alertData, err := model.SerializeSensorAlert(alert)
-		if err != nil {
-			log.Printf("Error serializing alert: %v", err)
-			return err
-		}
-
-		// Send alert to Kafka
-		a.producer.SendMessageWithKey(alert.SensorID, alertData)
-
-		// Update metrics
-		if a.metrics != nil {
-			a.metrics.AlertsGeneratedTotal.Inc()
-		}
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("anomaly-detector", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
 	}
 
-	// Update processing latency metric
-	if a.metrics != nil {
-		a.metrics.ProcessingLatency.Observe(time.Since(startTime).Seconds())
+	// Route sarama's internal logs into the service logs
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
 	}
 
-	return nil
-}
-
-func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig()
+	// Export traces when an OTLP endpoint is configured
+	tracerProvider, err := tracing.NewFromConfig("anomaly-detector", cfg)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		logging.Fatal(logger, "Failed to set up tracing", "error", err)
 	}
 
 	// Initialize Schema Registry client
 	model.InitSchemaRegistry(cfg.SchemaRegistryURL)
 
-	// Initialize databases (PostgreSQL and Elasticsearch)
-	log.Println("Initializing databases...")
-	if _, err := db.InitDatabases(cfg); err != nil {
-		log.Printf("Warning: Failed to initialize databases: %v", err)
-		// Continue execution even if database initialization fails
-	}
-
 	// Create metrics server (on a different port than the producer)
 	metricsPort := cfg.MetricsPort + 1 // Use port 2113 by default
-	metricsServer := metrics.NewMetricsServer(metricsPort)
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
 	metricsServer.Start()
 	defer metricsServer.Stop()
 
-	// Create anomaly detector metrics
-	anomalyMetrics := metrics.NewAnomalyDetectorMetrics(metricsServer.Registry())
+	// Wait for Kafka, and for PostgreSQL when annotations are loaded from it
+	dependencies := []startup.Dependency{startup.KafkaDependency(cfg)}
+	if cfg.AnnotationRefreshInterval > 0 {
+		dependencies = append(dependencies, startup.PostgresDependency(cfg))
+	}
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), dependencies...); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
 
-	// Create Kafka producer metrics for the alert producer
-	alertProducerMetrics := kafka.NewProducerMetrics("iot", "alert_producer", metricsServer.Registry())
+	// Initialize databases (PostgreSQL and Elasticsearch)
+	logger.Info("Initializing databases")
+	if _, err := db.InitDatabases(cfg); err != nil {
+		logger.Warn("Failed to initialize databases", "error", err)
+		// Continue execution even if database initialization fails
+	}
 
-	// Create Kafka producer metrics for the DLT producer
-	dltProducerMetrics := kafka.NewProducerMetrics("iot", "dlt_producer", metricsServer.Registry())
+	// Create the anomaly detector with its Kafka clients
+	service, err := detector.NewService(cfg, metricsServer.Registry(), nil, logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create anomaly detector", "error", err)
+	}
 
-	// Create Kafka consumer metrics
-	consumerMetrics := kafka.NewConsumerMetrics("iot", "sensor_consumer", metricsServer.Registry())
+	// Serve the payload schemas seen by the consumer next to the metrics
+	if schemas := kafka.SchemaTrackerFromConfig(cfg, metricsServer.Registry()); schemas != nil {
+		metricsServer.Handle("/schemas", schemas)
+	}
 
-	// Create Kafka alert producer
-	alertProducer, err := kafka.NewProducer(kafka.ProducerConfig{
-		Brokers:         cfg.KafkaBrokers,
-		Topic:           cfg.TopicSensorAlert,
-		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
-		ReturnSuccesses: cfg.ProducerReturnSuccess,
-		ReturnErrors:    cfg.ProducerReturnErrors,
-		Metrics:         alertProducerMetrics,
-		Version:         cfg.KafkaVersion,
-	})
-	if err != nil {
-		log.Fatalf("Failed to create alert producer: %v", err)
-	}
-	defer alertProducer.Close()
-
-	// Create Kafka DLT producer
-	dltProducer, err := kafka.NewProducer(kafka.ProducerConfig{
-		Brokers:         cfg.KafkaBrokers,
-		Topic:           cfg.TopicSensorRawDLT,
-		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
-		ReturnSuccesses: cfg.ProducerReturnSuccess,
-		ReturnErrors:    cfg.ProducerReturnErrors,
-		Metrics:         dltProducerMetrics,
-		Version:         cfg.KafkaVersion,
-	})
-	if err != nil {
-		log.Fatalf("Failed to create DLT producer: %v", err)
-	}
-	defer dltProducer.Close()
-
-	// Create anomaly detector instance
-	detector := NewAnomalyDetector(
-		nil, // Will be set after consumer creation
-		alertProducer,
-		dltProducer,
-		anomalyMetrics,
-		cfg.MaxTemperature,
-		cfg.MinHumidity,
-	)
-
-	// Create Kafka consumer
-	consumer, err := kafka.NewConsumer(
-		kafka.ConsumerConfig{
-			Brokers:         cfg.KafkaBrokers,
-			GroupID:         cfg.ConsumerGroupID,
-			Topics:          []string{cfg.TopicSensorRaw},
-			OffsetInitial:   cfg.ConsumerOffsetInitial,
-			ReturnErrors:    cfg.ConsumerReturnErrors,
-			Metrics:         consumerMetrics,
-			Version:         cfg.KafkaVersion,
-			BalanceStrategy: cfg.ConsumerBalanceStrategy,
-		},
-		detector.handleMessage,
-	)
-	if err != nil {
-		log.Fatalf("Failed to create consumer: %v", err)
+	// Serve autoscaling hints next to the metrics
+	if service.Saturation != nil {
+		metricsServer.Handle("/autoscale-hints", service.Saturation)
 	}
 
-	// Set the consumer in the detector
-	detector.consumer = consumer
+	// Serve the snapshot admin endpoints next to the metrics
+	if service.Snapshots != nil {
+		metricsServer.Handle("/admin/snapshots", service.Snapshots)
+		metricsServer.Handle("/admin/snapshots/", service.Snapshots)
+	}
 
 	// Start the anomaly detector
-	if err := detector.Start(); err != nil {
-		log.Fatalf("Failed to start anomaly detector: %v", err)
+	if err := service.Start(); err != nil {
+		logging.Fatal(logger, "Failed to start anomaly detector", "error", err)
+	}
+	waiter.Ready()
+
+	// In soak mode, sample resource usage and stop after the soak duration
+	soakRun, err := soak.NewFromConfig("anomaly-detector", cfg, logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to start soak mode", "error", err)
 	}
 
 	// Set up signal handler for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// Wait for termination signal
-	<-sigChan
-	log.Println("Received termination signal, shutting down...")
+	// Wait for termination signal, or the end of a soak run
+	select {
+	case <-sigChan:
+		logger.Info("Received termination signal, shutting down")
+	case <-soakRun.Done():
+		logger.Info("Soak duration elapsed, shutting down")
+	}
 
 	// Stop the anomaly detector
-	detector.Stop()
+	service.Stop()
+
+	// Report resource usage once everything the service started has stopped
+	if soakRun != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if _, err := soakRun.Finish(ctx); err != nil {
+			logger.Error("Failed to report soak run", "error", err)
+		}
+		cancel()
+	}
+
+	// Export the spans still buffered
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	cancel()
 
-	log.Println("Anomaly detector shutdown complete")
+	logger.Info("Anomaly detector shutdown complete")
 }
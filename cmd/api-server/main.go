@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/iot-sensor-fleet/internal/api"
+	apigrpc "github.com/example/iot-sensor-fleet/internal/api/grpc"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("api-server", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Route sarama's internal logs into the service logs
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	// Create metrics server (next to the lag exporter port)
+	metricsPort := cfg.MetricsPort + 7 // Use port 2119 by default
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
+	metricsServer.Start()
+	defer metricsServer.Stop()
+
+	// Wait for PostgreSQL, and for Kafka if live alerts are served
+	dependencies := []startup.Dependency{startup.PostgresDependency(cfg)}
+	if cfg.APIGRPCPort > 0 {
+		dependencies = append(dependencies, startup.KafkaDependency(cfg))
+	}
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), dependencies...); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
+
+	// Initialize PostgreSQL tables so queries succeed before the sinks first write
+	logger.Info("Initializing databases")
+	postgres, err := db.InitDatabases(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to initialize databases", "error", err)
+	}
+	postgres.Close()
+
+	// Create the query API
+	service, err := api.NewService(cfg, metricsServer.Registry(), logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create query API", "error", err)
+	}
+
+	// Create the live alert service
+	var alerts *apigrpc.Service
+	if cfg.APIGRPCPort > 0 {
+		alerts, err = apigrpc.NewService(cfg, metricsServer.Registry(), logger)
+		if err != nil {
+			logging.Fatal(logger, "Failed to create live alert service", "error", err)
+		}
+	}
+
+	// Start the query API and live alerts
+	if err := service.Start(); err != nil {
+		logging.Fatal(logger, "Failed to start query API", "error", err)
+	}
+	if alerts != nil {
+		if err := alerts.Start(); err != nil {
+			logging.Fatal(logger, "Failed to start live alert service", "error", err)
+		}
+	}
+	waiter.Ready()
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for termination signal
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	if alerts != nil {
+		alerts.Stop()
+	}
+	service.Stop()
+
+	logger.Info("Query API shutdown complete")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/sink"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+)
+
+func main() {
+	engineFlag := flag.String("engine", sink.EngineAthena, "query engine to write the DDL for: athena or trino")
+	tableFlag := flag.String("table", "sensor_readings", "qualified name of the table")
+	fromFlag := flag.String("from", "", "first day of projected partitions (RFC 3339 or Unix milliseconds; default the day the table was created)")
+	sinceFlag := flag.Int("since", 0, "print the ALTER statements adding the columns added since this schema ID instead of CREATE")
+	flag.Parse()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("archive-table", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	from, err := model.ParseTime(*fromFlag)
+	if err != nil {
+		logging.Fatal(logger, "Invalid -from", "error", err)
+	}
+
+	store, err := storage.NewS3StoreFromConfig(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create object store", "error", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	table, err := sink.LoadArchiveTable(ctx, store, cfg.ArchivePrefix)
+	if errors.Is(err, storage.ErrNotFound) {
+		logging.Fatal(logger, "No table metadata; the cold archiver writes it with its first batch", "key", sink.ArchiveTableKey(cfg.ArchivePrefix))
+	}
+	if err != nil {
+		logging.Fatal(logger, "Failed to load table metadata", "error", err)
+	}
+	if table.Encryption != "" && table.Encryption != encryption.ModeNone {
+		logger.Warn("Objects are encrypted and cannot be read by query engines", "encryption", table.Encryption)
+	}
+
+	if *sinceFlag > 0 {
+		statements, err := table.AlterDDL(*engineFlag, *tableFlag, *sinceFlag)
+		if err != nil {
+			logging.Fatal(logger, "Failed to write DDL", "error", err)
+		}
+		for _, statement := range statements {
+			fmt.Println(statement)
+		}
+		logger.Info("Columns added to schema", "schema_id", table.CurrentSchemaID, "columns", len(statements), "since", *sinceFlag)
+		return
+	}
+
+	ddl, err := table.CreateDDL(*engineFlag, *tableFlag, from)
+	if err != nil {
+		logging.Fatal(logger, "Failed to write DDL", "error", err)
+	}
+	fmt.Print(ddl)
+	logger.Info("Table schema", "schema_id", table.CurrentSchemaID, "location", table.Location)
+}
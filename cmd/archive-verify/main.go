@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/sink"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+)
+
+func main() {
+	fromFlag := flag.String("from", "", "verify objects with readings at or after this time (RFC 3339 or Unix milliseconds)")
+	toFlag := flag.String("to", "", "verify objects with readings before this time (RFC 3339 or Unix milliseconds)")
+	jsonFlag := flag.Bool("json", false, "print the result as JSON")
+	flag.Parse()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("archive-verify", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	from, err := model.ParseTime(*fromFlag)
+	if err != nil {
+		logging.Fatal(logger, "Invalid -from", "error", err)
+	}
+	to, err := model.ParseTime(*toFlag)
+	if err != nil {
+		logging.Fatal(logger, "Invalid -to", "error", err)
+	}
+
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to connect to PostgreSQL", "error", err)
+	}
+	defer postgres.Close()
+
+	store, err := storage.NewS3StoreFromConfig(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create object store", "error", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	result, err := sink.VerifyArchive(ctx, sink.NewPostgresArchiveCatalog(postgres.DB()), store, from, to)
+	if err != nil {
+		logging.Fatal(logger, "Verification failed", "error", err)
+	}
+
+	if *jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			logging.Fatal(logger, "Failed to encode result", "error", err)
+		}
+	} else {
+		for _, problem := range result.Missing {
+			logger.Error("Missing object", "key", problem.Key, "reason", problem.Reason)
+		}
+		for _, problem := range result.Corrupt {
+			logger.Error("Corrupt object", "key", problem.Key, "reason", problem.Reason)
+		}
+		for _, gap := range result.Gaps {
+			logger.Error("Offsets were not archived", "topic", gap.Topic, "partition", gap.Partition, "from", gap.From, "to", gap.To)
+		}
+		logger.Info("Verified archive", "objects", result.Objects, "bytes", result.Bytes, "readings", result.Readings,
+			"missing", len(result.Missing), "corrupt", len(result.Corrupt), "gaps", len(result.Gaps))
+	}
+
+	if !result.OK() {
+		os.Exit(1)
+	}
+}
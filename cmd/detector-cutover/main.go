@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/cutover"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+const usage = `usage:
+  detector-cutover prepare [-from blue] [-dry-run]
+  detector-cutover compare [-window 15m] [-settle 1m] [-max-diff 0.01] [-samples 20] [-json]
+  detector-cutover switch -to green [-lead 30s] [-sample 10s] [-wait] [-timeout 15m]
+  detector-cutover status [-json]
+every command takes [-blue-group group] [-green-group group]`
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("detector-cutover", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	// Stop waiting and reading on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	command, args := os.Args[1], os.Args[2:]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	blueGroupFlag := flags.String("blue-group", cfg.ConsumerGroupID, "consumer group of the blue detectors")
+	greenGroupFlag := flags.String("green-group", cfg.ConsumerGroupID+"-green", "consumer group of the green detectors")
+
+	switch command {
+	case "prepare":
+		fromFlag := flags.String("from", cutover.ColorBlue, "authoritative color whose offsets the other color's group starts from")
+		dryRunFlag := flags.Bool("dry-run", false, "print the offsets that would be committed without committing them")
+		flags.Parse(args)
+
+		orchestrator := newOrchestrator(cfg, *blueGroupFlag, *greenGroupFlag, logger)
+		defer orchestrator.Close()
+
+		result, err := orchestrator.Prepare(*fromFlag, *dryRunFlag)
+		if err != nil {
+			if errors.Is(err, kafka.ErrGroupActive) {
+				err = fmt.Errorf("%w: stop the %s detectors first", err, cutover.Other(*fromFlag))
+			}
+			logger.Error("Failed to prepare cutover", "error", err)
+			orchestrator.Close()
+			os.Exit(1)
+		}
+		for _, change := range result.Changes {
+			fmt.Printf("%s %s/%d: %d -> %d\n", change.Group, change.Topic, change.Partition, change.Previous, change.Offset)
+		}
+		if *dryRunFlag {
+			fmt.Printf("would copy %d offsets\n", len(result.Changes))
+		} else {
+			fmt.Printf("copied %d offsets; start the %s detectors with DETECTOR_COLOR=%s\n", len(result.Changes), cutover.Other(*fromFlag), cutover.Other(*fromFlag))
+		}
+
+	case "compare":
+		windowFlag := flags.Duration("window", 15*time.Minute, "compare alerts of readings taken within this long before -settle")
+		settleFlag := flags.Duration("settle", time.Minute, "leave out the most recent readings, which the slower detector may not have handled yet")
+		maxDiffFlag := flags.Float64("max-diff", 0.01, "exit with status 1 when a larger share of alerts is only in one stream")
+		samplesFlag := flags.Int("samples", 20, "unmatched alerts to print")
+		jsonFlag := flags.Bool("json", false, "print the report as JSON")
+		flags.Parse(args)
+
+		orchestrator := newOrchestrator(cfg, *blueGroupFlag, *greenGroupFlag, logger)
+		defer orchestrator.Close()
+
+		until := time.Now().Add(-*settleFlag)
+		report, err := orchestrator.Compare(ctx, until.Add(-*windowFlag), until, *samplesFlag)
+		if err != nil {
+			logger.Error("Failed to compare alert streams", "error", err)
+			orchestrator.Close()
+			os.Exit(1)
+		}
+		if *jsonFlag {
+			printJSON(report)
+		} else {
+			printReport(report)
+		}
+		if report.DiffRatio > *maxDiffFlag {
+			orchestrator.Close()
+			os.Exit(1)
+		}
+
+	case "switch":
+		toFlag := flags.String("to", "", "color to make authoritative")
+		leadFlag := flags.Duration("lead", 30*time.Second, "how far ahead of the raw topic's end, at its current rate, the cutover offsets are set")
+		sampleFlag := flags.Duration("sample", 10*time.Second, "how long to measure the raw topic's rate for")
+		waitFlag := flags.Bool("wait", false, "wait until the previous color passed the cutover offsets")
+		timeoutFlag := flags.Duration("timeout", 15*time.Minute, "how long -wait waits")
+		flags.Parse(args)
+
+		orchestrator := newOrchestrator(cfg, *blueGroupFlag, *greenGroupFlag, logger)
+		defer orchestrator.Close()
+
+		authority, err := orchestrator.Switch(ctx, *toFlag, *leadFlag, *sampleFlag)
+		if err != nil {
+			logger.Error("Failed to switch authority", "error", err)
+			orchestrator.Close()
+			os.Exit(1)
+		}
+		fmt.Printf("%s is authoritative from offsets %v of %s\n", authority.Color, authority.Offsets, cfg.Topic(config.TopicKeySensorRaw))
+		if !*waitFlag {
+			fmt.Printf("run status until it is complete before stopping the %s detectors\n", authority.Previous)
+			return
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, *timeoutFlag)
+		defer cancel()
+		if err := waitComplete(waitCtx, orchestrator, logger); err != nil {
+			logger.Error("Cutover did not complete", "error", err)
+			cancel()
+			orchestrator.Close()
+			os.Exit(1)
+		}
+		fmt.Printf("%s passed the cutover offsets; its detectors can be stopped\n", authority.Previous)
+
+	case "status":
+		jsonFlag := flags.Bool("json", false, "print the progress as JSON")
+		flags.Parse(args)
+
+		orchestrator := newOrchestrator(cfg, *blueGroupFlag, *greenGroupFlag, logger)
+		defer orchestrator.Close()
+
+		progress, err := orchestrator.Progress()
+		if err != nil {
+			logger.Error("Failed to read cutover progress", "error", err)
+			orchestrator.Close()
+			os.Exit(1)
+		}
+		if *jsonFlag {
+			printJSON(progress)
+		} else {
+			printProgress(progress)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// newOrchestrator creates the orchestrator or exits
+func newOrchestrator(cfg *config.Config, blueGroup, greenGroup string, logger *slog.Logger) *cutover.Orchestrator {
+	orchestrator, err := cutover.NewOrchestratorFromConfig(cfg, blueGroup, greenGroup, logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create cutover orchestrator", "error", err)
+	}
+	return orchestrator
+}
+
+// waitComplete polls the cutover progress until the previous color passed
+// every cutover offset
+func waitComplete(ctx context.Context, orchestrator *cutover.Orchestrator, logger *slog.Logger) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		progress, err := orchestrator.Progress()
+		if err != nil {
+			return err
+		}
+		if progress.Complete {
+			return nil
+		}
+		logger.Info("Waiting for the previous color to pass the cutover offsets", "previous", progress.Authority.Previous)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// printReport writes a comparison for people
+func printReport(report cutover.ComparisonReport) {
+	fmt.Printf("matched %d, only authoritative %d, only shadow %d (%.2f%% differ)\n",
+		report.Matched, report.OnlyAuthoritative, report.OnlyShadow, report.DiffRatio*100)
+	for _, rule := range report.Rules {
+		fmt.Printf("  %-24s matched %d, only authoritative %d, only shadow %d\n", rule.Rule, rule.Matched, rule.OnlyAuthoritative, rule.OnlyShadow)
+	}
+	for _, sample := range report.Samples {
+		fmt.Printf("only %s: %s at %s, %s: %s\n", sample.Stream, sample.SensorID,
+			time.UnixMilli(sample.Timestamp).UTC().Format(time.RFC3339Nano), sample.Rule, sample.Reason)
+	}
+	if report.Matched+report.OnlyAuthoritative+report.OnlyShadow == 0 {
+		fmt.Println("no alerts in the window; widen -window to compare")
+	}
+}
+
+// printProgress writes the progress of a cutover for people
+func printProgress(progress cutover.Progress) {
+	if progress.Authority == nil {
+		fmt.Println("no authority published: every detector is authoritative")
+		return
+	}
+	fmt.Printf("%s is authoritative", progress.Authority.Color)
+	if progress.Authority.Previous == "" {
+		fmt.Println()
+		return
+	}
+	fmt.Printf(" since %s, %s before the cutover offsets\n", progress.Authority.SwitchedAt.Format(time.RFC3339), progress.Authority.Previous)
+	for _, partition := range progress.Partitions {
+		fmt.Printf("  partition %d: cutover %d", partition.Partition, partition.Cutover)
+		for _, color := range []string{cutover.ColorBlue, cutover.ColorGreen} {
+			if offset, ok := partition.Committed[color]; ok {
+				fmt.Printf(", %s %d", color, offset)
+			}
+		}
+		fmt.Println()
+	}
+	if progress.Complete {
+		fmt.Printf("complete: the %s detectors can be stopped\n", progress.Authority.Previous)
+	} else {
+		fmt.Printf("in progress: %s has not passed every cutover offset\n", progress.Authority.Previous)
+	}
+}
+
+// printJSON writes v as indented JSON
+func printJSON(v any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/detector"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/sink"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func main() {
+	fromFlag := flag.String("from", "", "replay archived readings at or after this time (RFC 3339, required)")
+	toFlag := flag.String("to", "", "replay archived readings before this time (RFC 3339, defaults to now)")
+	sensorFlag := flag.String("sensor", "", "only replay the readings of this sensor")
+	jsonFlag := flag.Bool("json", false, "print the alerts as NDJSON instead of one line each")
+	flag.Parse()
+
+	// Load configuration; the detector rules come from the environment
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("detector-dryrun", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	if *fromFlag == "" {
+		logging.Fatal(logger, "-from is required")
+	}
+	from, err := time.Parse(time.RFC3339, *fromFlag)
+	if err != nil {
+		logging.Fatal(logger, "Invalid -from", "error", err)
+	}
+	to := time.Now()
+	if *toFlag != "" {
+		if to, err = time.Parse(time.RFC3339, *toFlag); err != nil {
+			logging.Fatal(logger, "Invalid -to", "error", err)
+		}
+	}
+
+	// Metrics of the checkers are registered but never served
+	anomalyDetector, err := detector.NewOfflineDetector(cfg, prometheus.NewRegistry())
+	if err != nil {
+		logging.Fatal(logger, "Failed to create detector", "error", err)
+	}
+
+	store, err := storage.NewS3StoreFromConfig(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create object store", "error", err)
+	}
+	encryptor, err := encryption.NewEncryptorFromConfig(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create archive encryptor", "error", err)
+	}
+	reader, err := sink.NewArchiveReader(store, encryptor, cfg.ArchivePrefix, cfg.ArchiveDefaultTenant)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create archive reader", "error", err)
+	}
+	defer reader.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	encoder := json.NewEncoder(os.Stdout)
+	readings, alerts := 0, 0
+	rules := make(map[string]int)
+	objects, err := reader.Read(ctx, from, to, func(reading *model.SensorReading) error {
+		if *sensorFlag != "" && reading.ID != *sensorFlag {
+			return nil
+		}
+		readings++
+
+		alert := anomalyDetector.Evaluate(reading)
+		if alert == nil {
+			return nil
+		}
+		alerts++
+		rules[alert.Rule]++
+		if *jsonFlag {
+			return encoder.Encode(alert)
+		}
+		fmt.Printf("%s %s %s: %s\n", time.UnixMilli(alert.Timestamp).UTC().Format(time.RFC3339), alert.SensorID, alert.Rule, alert.Reason)
+		return nil
+	})
+	if err != nil {
+		logging.Fatal(logger, "Dry run failed", "objects", objects, "error", err)
+	}
+
+	names := make([]string, 0, len(rules))
+	for rule := range rules {
+		names = append(names, rule)
+	}
+	sort.Strings(names)
+	for _, rule := range names {
+		logger.Info("Rule alerts", "rule", rule, "alerts", rules[rule])
+	}
+	logger.Info("Replayed readings", "readings", readings, "objects", objects, "alerts", alerts)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/heartbeat"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("detector-monitor", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Route sarama's internal logs into the service logs
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	// Create metrics server (next to the CoAP ingest port)
+	metricsPort := cfg.MetricsPort + 10 // Use port 2122 by default
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
+	metricsServer.Start()
+	defer metricsServer.Stop()
+
+	// Wait for Kafka
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), startup.KafkaDependency(cfg)); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
+
+	// Create the monitor of the replicas' heartbeats
+	monitor, err := heartbeat.NewMonitorFromConfig(cfg, metricsServer.Registry(), logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create detector monitor", "error", err)
+	}
+	metricsServer.Handle("/leases", monitor)
+
+	// Start following the heartbeats
+	if err := monitor.Start(); err != nil {
+		logging.Fatal(logger, "Failed to start detector monitor", "error", err)
+	}
+	waiter.Ready()
+	logger.Info("Monitoring detector replicas", "topic", cfg.Topic(config.TopicKeyHeartbeat),
+		"lease_ttl", cfg.HeartbeatLeaseTTL, "max_lag", cfg.HeartbeatMaxLag)
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for termination signal
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	monitor.Stop()
+
+	logger.Info("Detector monitor shutdown complete")
+}
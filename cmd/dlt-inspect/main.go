@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/dlt"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("dlt-inspect", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	topicFlag := flag.String("topic", strings.Join(deadLetterTopics(cfg), ","), "comma-separated dead-letter topics to inspect")
+	fromFlag := flag.String("from", "", "inspect messages dead-lettered at or after this time (RFC 3339 or Unix milliseconds)")
+	toFlag := flag.String("to", "", "inspect messages dead-lettered before this time (RFC 3339 or Unix milliseconds)")
+	reasonFlag := flag.String("reason", "", "inspect messages whose dead-letter reason contains this text")
+	limitFlag := flag.Int("n", 0, "stop after classifying this many messages (0 is unlimited)")
+	jsonFlag := flag.Bool("json", false, "print the report as JSON")
+	verboseFlag := flag.Bool("v", false, "print the classification of every message")
+	flag.Parse()
+
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	// Check Confluent schema IDs against the registry when one is configured
+	model.InitSchemaRegistry(cfg.SchemaRegistryURL)
+
+	filter := dlt.Filter{Reason: *reasonFlag}
+	if filter.Since, err = model.ParseTime(*fromFlag); err != nil {
+		logging.Fatal(logger, "Invalid -from", "error", err)
+	}
+	if filter.Until, err = model.ParseTime(*toFlag); err != nil {
+		logging.Fatal(logger, "Invalid -to", "error", err)
+	}
+
+	var topics []string
+	for _, topic := range strings.Split(*topicFlag, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+
+	decoder, err := model.NewReadingDecoder(cfg.TopicFormats())
+	if err != nil {
+		logging.Fatal(logger, "Invalid topic formats", "error", err)
+	}
+
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		logging.Fatal(logger, "Invalid Kafka security settings", "error", err)
+	}
+	opts := append([]kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}, security...)
+
+	inspector, err := dlt.NewInspector(dlt.InspectorConfig{
+		Brokers: cfg.KafkaBrokers,
+		Topics:  topics,
+		Filter:  filter,
+		Limit:   *limitFlag,
+		Logger:  logger,
+	}, decoder, opts...)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create inspector", "error", err)
+	}
+	defer inspector.Close()
+
+	if *verboseFlag {
+		inspector.OnMessage = printMessage
+	}
+
+	// Stop between messages on interrupt and report what was read so far
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	report, runErr := inspector.Run(ctx)
+	if *jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			logger.Error("Failed to print report", "error", err)
+		}
+	} else {
+		printReport(report)
+	}
+	if runErr != nil {
+		logger.Error("Inspection failed", "error", runErr)
+		os.Exit(1)
+	}
+}
+
+// deadLetterTopics returns the dead-letter topics paired with the configured topics
+func deadLetterTopics(cfg *config.Config) []string {
+	var topics []string
+	for _, key := range cfg.TopicKeys() {
+		if dltTopic := cfg.TopicDLT(key); dltTopic != "" {
+			topics = append(topics, dltTopic)
+		}
+	}
+	return topics
+}
+
+// printMessage writes the classification of one message
+func printMessage(message *sarama.ConsumerMessage, c dlt.Classification) {
+	fmt.Printf("%s/%d@%d key=%s class=%s (%s)", message.Topic, message.Partition, message.Offset, message.Key, c.Class, c.Source)
+	if c.SchemaID != nil {
+		fmt.Printf(" schema_id=%d", *c.SchemaID)
+	}
+	if c.Error != "" {
+		fmt.Printf(": %s", c.Error)
+	}
+	fmt.Println()
+}
+
+// printReport writes the counts per class with their sources and suggestion
+func printReport(report dlt.Report) {
+	fmt.Printf("Classified %d of %d messages\n", report.Scanned-report.Filtered, report.Scanned)
+	for _, class := range report.Classes {
+		fmt.Printf("\n%s: %d\n", class.Class, class.Count)
+		if class.Example != "" {
+			fmt.Printf("  example: %s\n", class.Example)
+		}
+		for _, source := range class.Sources {
+			fmt.Printf("  %6d  %s\n", source.Count, source.Source)
+		}
+		if len(class.SchemaIDs) > 0 {
+			ids := make([]int32, 0, len(class.SchemaIDs))
+			for id := range class.SchemaIDs {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+			fmt.Print("  schema IDs:")
+			for _, id := range ids {
+				fmt.Printf(" %d (%d)", id, class.SchemaIDs[id])
+			}
+			fmt.Println()
+		}
+		fmt.Printf("  suggestion: %s\n", class.Suggestion)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/dlt"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("dlt-replayer", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	sourceFlag := flag.String("source", cfg.TopicDLT(config.TopicKeySensorRaw), "dead-letter topic to replay")
+	targetFlag := flag.String("target", cfg.Topic(config.TopicKeySensorRaw), "topic to republish messages to")
+	groupFlag := flag.String("group", "dlt-replayer", "consumer group committing replay progress (empty reads without committing)")
+	fromBeginningFlag := flag.Bool("from-beginning", false, "ignore committed progress and read from the oldest retained message")
+	fromFlag := flag.String("from", "", "replay messages dead-lettered at or after this time (RFC 3339 or Unix milliseconds)")
+	toFlag := flag.String("to", "", "replay messages dead-lettered before this time (RFC 3339 or Unix milliseconds)")
+	reasonFlag := flag.String("reason", "", "replay messages whose dead-letter reason contains this text")
+	maxReplaysFlag := flag.Int("max-replays", 3, "leave messages replayed this many times in the dead-letter topic")
+	limitFlag := flag.Int("n", 0, "stop after replaying this many messages (0 is unlimited)")
+	dryRunFlag := flag.Bool("dry-run", false, "print what would be replayed without sending or committing")
+	flag.Parse()
+
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	filter := dlt.Filter{Reason: *reasonFlag}
+	if filter.Since, err = model.ParseTime(*fromFlag); err != nil {
+		logging.Fatal(logger, "Invalid -from", "error", err)
+	}
+	if filter.Until, err = model.ParseTime(*toFlag); err != nil {
+		logging.Fatal(logger, "Invalid -to", "error", err)
+	}
+
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		logging.Fatal(logger, "Invalid Kafka security settings", "error", err)
+	}
+	opts := append([]kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}, security...)
+
+	var producer *kafka.Producer
+	if !*dryRunFlag {
+		producer, err = kafka.NewProducer(kafka.ProducerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			Topic:           *targetFlag,
+			RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+			Idempotent:      cfg.ProducerIdempotent,
+			ReturnSuccesses: cfg.ProducerReturnSuccess,
+			ReturnErrors:    cfg.ProducerReturnErrors,
+			Version:         cfg.KafkaVersion,
+			SendTimeout:     cfg.ProducerSendTimeout,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentDLTReplayer),
+		})
+		if err != nil {
+			logging.Fatal(logger, "Failed to create producer", "error", err)
+		}
+	}
+
+	replayer, err := dlt.NewReplayer(dlt.Config{
+		Brokers:       cfg.KafkaBrokers,
+		Source:        *sourceFlag,
+		Group:         *groupFlag,
+		FromBeginning: *fromBeginningFlag,
+		Filter:        filter,
+		MaxReplays:    *maxReplaysFlag,
+		Limit:         *limitFlag,
+		DryRun:        *dryRunFlag,
+	}, producer, opts...)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create replayer", "error", err)
+	}
+	defer replayer.Close()
+
+	// Stop between messages on interrupt; progress so far is committed
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	result, runErr := replayer.Run(ctx)
+	if producer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ProducerShutdownTimeout)
+		if err := producer.GracefulShutdown(shutdownCtx); err != nil {
+			logger.Error("Error during producer shutdown", "error", err)
+		}
+		cancel()
+	}
+
+	logger.Info("Replay finished", "scanned", result.Scanned, "replayed", result.Replayed, "target", *targetFlag,
+		"filtered", result.Filtered, "exhausted", result.Exhausted)
+	if runErr != nil {
+		logger.Error("Replay failed", "error", runErr)
+		os.Exit(1)
+	}
+}
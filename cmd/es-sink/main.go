@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/sink"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+	"github.com/example/iot-sensor-fleet/internal/tracing"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("es-sink", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Route sarama's internal logs into the service logs
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	// Export traces when an OTLP endpoint is configured
+	tracerProvider, err := tracing.NewFromConfig("es-sink", cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to set up tracing", "error", err)
+	}
+
+	// Initialize Schema Registry client
+	model.InitSchemaRegistry(cfg.SchemaRegistryURL)
+
+	// Create metrics server (next to the producer, detector, registry and postgres-sink ports)
+	metricsPort := cfg.MetricsPort + 4 // Use port 2116 by default
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
+	metricsServer.Start()
+	defer metricsServer.Stop()
+
+	// Wait for Kafka and Elasticsearch
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), startup.KafkaDependency(cfg), startup.ElasticsearchDependency(cfg)); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
+
+	// Create the sink with its Kafka consumer; this also creates the indexes
+	service, err := sink.NewElasticsearchService(cfg, metricsServer.Registry(), logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create Elasticsearch sink", "error", err)
+	}
+
+	// Serve the payload schemas seen by the consumer next to the metrics
+	if schemas := kafka.SchemaTrackerFromConfig(cfg, metricsServer.Registry()); schemas != nil {
+		metricsServer.Handle("/schemas", schemas)
+	}
+
+	// Start the sink
+	if err := service.Start(); err != nil {
+		logging.Fatal(logger, "Failed to start Elasticsearch sink", "error", err)
+	}
+	waiter.Ready()
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for termination signal
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	service.Stop()
+
+	// Export the spans still buffered
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	cancel()
+
+	logger.Info("Elasticsearch sink shutdown complete")
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/aggregate"
+	"github.com/example/iot-sensor-fleet/internal/api"
+	apigrpc "github.com/example/iot-sensor-fleet/internal/api/grpc"
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/detector"
+	"github.com/example/iot-sensor-fleet/internal/incident"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/registry"
+	"github.com/example/iot-sensor-fleet/internal/simulator"
+	"github.com/example/iot-sensor-fleet/internal/sink"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+	"github.com/example/iot-sensor-fleet/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Component is a runnable part of the pipeline hosted by the fleet binary
+type Component interface {
+	Start() error
+	Stop()
+}
+
+// componentFactory builds a component from the shared configuration, metrics registry, bus and logger
+type componentFactory func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error)
+
+// components lists every component the fleet binary can run, keyed by flag name
+var components = map[string]componentFactory{
+	"api": func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return newAPIComponent(cfg, registry, logger)
+	},
+	"cold_archiver": func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return sink.NewArchiveService(cfg, registry, logger)
+	},
+	"es_sink": func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return sink.NewElasticsearchService(cfg, registry, logger)
+	},
+	"postgres_sink": func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return sink.NewService(cfg, registry, logger)
+	},
+	"aggregator": func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return aggregate.NewService(cfg, registry, eventBus, logger)
+	},
+	"detector": func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return detector.NewService(cfg, registry, eventBus, logger)
+	},
+	"correlator": func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return incident.NewService(cfg, registry, eventBus, logger)
+	},
+	"producer": func(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return simulator.NewFleet(cfg, registry, logger)
+	},
+	"registry": func(cfg *config.Config, reg prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (Component, error) {
+		return registry.NewService(cfg, reg, logger)
+	},
+}
+
+// startOrder starts consumers before the producers feeding them; components stop in reverse
+var startOrder = []string{"registry", "api", "postgres_sink", "es_sink", "cold_archiver", "aggregator", "correlator", "detector", "producer"}
+
+func main() {
+	componentsFlag := flag.String("components", "producer,detector", "comma-separated components to run ("+strings.Join(componentNames(), ", ")+")")
+	metricsPortFlag := flag.Int("metrics-port", 0, "port for the shared metrics server (defaults to METRICS_PORT)")
+	flag.Parse()
+
+	selected, err := parseComponents(*componentsFlag)
+	if err != nil {
+		log.Fatalf("Invalid -components: %v", err)
+	}
+
+	// Seed the random number generator
+	rand.Seed(time.Now().UnixNano())
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("fleet", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Route sarama's internal logs into the service logs
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	// Export traces when an OTLP endpoint is configured
+	tracerProvider, err := tracing.NewFromConfig("fleet", cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to set up tracing", "error", err)
+	}
+
+	// Initialize Schema Registry client
+	model.InitSchemaRegistry(cfg.SchemaRegistryURL)
+
+	// Create the shared metrics server
+	metricsPort := cfg.MetricsPort
+	if *metricsPortFlag != 0 {
+		metricsPort = *metricsPortFlag
+	}
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
+	metricsServer.Start()
+	defer metricsServer.Stop()
+
+	// Wait for the dependencies of the selected components
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), dependencies(cfg, selected)...); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
+
+	// Initialize databases (PostgreSQL and Elasticsearch)
+	logger.Info("Initializing databases")
+	if _, err := db.InitDatabases(cfg); err != nil {
+		logger.Warn("Failed to initialize databases", "error", err)
+		// Continue execution even if database initialization fails
+	}
+
+	// Share Kafka clients between the components' producers and consumers
+	kafka.ShareClients()
+
+	// Create the in-process bus shared by all components
+	eventBus := bus.New(bus.NewMetrics("iot", "bus", metricsServer.Registry()))
+	defer eventBus.Close()
+
+	// Serve the payload schemas seen by the components' consumers next to the metrics
+	if schemas := kafka.SchemaTrackerFromConfig(cfg, metricsServer.Registry()); schemas != nil {
+		metricsServer.Handle("/schemas", schemas)
+	}
+
+	// Create and start the selected components
+	var running []Component
+	for _, name := range startOrder {
+		if !selected[name] {
+			continue
+		}
+
+		component, err := components[name](cfg, metricsServer.Registry(), eventBus, logger)
+		if err != nil {
+			stopAll(running)
+			logging.Fatal(logger, "Failed to create component", "component", name, "error", err)
+		}
+		// Serve the detector's autoscaling hints and snapshot endpoints next to the metrics
+		if service, ok := component.(*detector.Service); ok {
+			if service.Saturation != nil {
+				metricsServer.Handle("/autoscale-hints", service.Saturation)
+			}
+			if service.Snapshots != nil {
+				metricsServer.Handle("/admin/snapshots", service.Snapshots)
+				metricsServer.Handle("/admin/snapshots/", service.Snapshots)
+			}
+		}
+		if err := component.Start(); err != nil {
+			stopAll(running)
+			logging.Fatal(logger, "Failed to start component", "component", name, "error", err)
+		}
+
+		logger.Info("Started component", "component", name)
+		running = append(running, component)
+	}
+	waiter.Ready()
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for termination signal
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	stopAll(running)
+
+	// Export the spans still buffered
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	cancel()
+
+	logger.Info("Fleet shutdown complete")
+}
+
+// apiComponent is the query API and, when API_GRPC_PORT is set, the live
+// alert service, as the api-server binary runs them
+type apiComponent struct {
+	query  *api.Service
+	alerts *apigrpc.Service
+}
+
+// newAPIComponent creates the query API and the live alert service
+func newAPIComponent(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*apiComponent, error) {
+	query, err := api.NewService(cfg, registry, logger)
+	if err != nil {
+		return nil, err
+	}
+	c := &apiComponent{query: query}
+	if cfg.APIGRPCPort > 0 {
+		if c.alerts, err = apigrpc.NewService(cfg, registry, logger); err != nil {
+			query.Stop()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Start starts the query API and the live alert service
+func (c *apiComponent) Start() error {
+	if err := c.query.Start(); err != nil {
+		return err
+	}
+	if c.alerts != nil {
+		if err := c.alerts.Start(); err != nil {
+			c.query.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops the live alert service and the query API
+func (c *apiComponent) Stop() {
+	if c.alerts != nil {
+		c.alerts.Stop()
+	}
+	c.query.Stop()
+}
+
+// stopAll stops components in reverse start order
+func stopAll(running []Component) {
+	for i := len(running) - 1; i >= 0; i-- {
+		running[i].Stop()
+	}
+}
+
+// dependencies returns the external services the selected components need at startup
+func dependencies(cfg *config.Config, selected map[string]bool) []startup.Dependency {
+	var deps []startup.Dependency
+	if selected["aggregator"] || selected["correlator"] || selected["detector"] || selected["producer"] ||
+		selected["postgres_sink"] || selected["es_sink"] || selected["cold_archiver"] ||
+		(selected["api"] && cfg.APIGRPCPort > 0) {
+		deps = append(deps, startup.KafkaDependency(cfg))
+	}
+	if selected["registry"] || selected["api"] || selected["postgres_sink"] ||
+		(selected["cold_archiver"] && cfg.ArchiveCatalogEnabled) ||
+		(selected["detector"] && cfg.AnnotationRefreshInterval > 0) {
+		deps = append(deps, startup.PostgresDependency(cfg))
+	}
+	if selected["es_sink"] {
+		deps = append(deps, startup.ElasticsearchDependency(cfg))
+	}
+	// An in-process registry is started before the producer
+	if selected["producer"] && cfg.SimulatorRotationInterval > 0 && !selected["registry"] {
+		deps = append(deps, startup.RegistryDependency(cfg))
+	}
+	return deps
+}
+
+// parseComponents parses the -components flag into a set of names
+func parseComponents(value string) (map[string]bool, error) {
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := components[name]; !ok {
+			return nil, fmt.Errorf("unknown component %q", name)
+		}
+		selected[name] = true
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no components selected")
+	}
+	return selected, nil
+}
+
+// componentNames returns the sorted list of known components
+func componentNames() []string {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
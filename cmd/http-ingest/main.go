@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/ingest"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("http-ingest", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Route sarama's internal logs into the service logs
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	// Initialize Schema Registry client
+	model.InitSchemaRegistry(cfg.SchemaRegistryURL)
+
+	// Create metrics server (next to the detector monitor port)
+	metricsPort := cfg.MetricsPort + 11 // Use port 2123 by default
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
+	metricsServer.Start()
+	defer metricsServer.Stop()
+
+	// Wait for Kafka, and for PostgreSQL if it stores idempotency keys
+	dependencies := []startup.Dependency{startup.KafkaDependency(cfg)}
+	if cfg.IdempotencyStore == ingest.IdempotencyStorePostgres {
+		dependencies = append(dependencies, startup.PostgresDependency(cfg))
+	}
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), dependencies...); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
+
+	// Create the idempotency key table
+	if cfg.IdempotencyStore == ingest.IdempotencyStorePostgres {
+		logger.Info("Initializing databases")
+		postgres, err := db.InitDatabases(cfg)
+		if err != nil {
+			logging.Fatal(logger, "Failed to initialize databases", "error", err)
+		}
+		postgres.Close()
+	}
+
+	// Create the HTTP gateway and the producer of sensor.raw
+	server, err := ingest.NewHTTPServerFromConfig(cfg, metricsServer.Registry(), logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create HTTP ingest", "error", err)
+	}
+
+	// Start accepting readings
+	if err := server.Start(); err != nil {
+		logging.Fatal(logger, "Failed to start HTTP ingest", "error", err)
+	}
+	waiter.Ready()
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for termination signal
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	server.Stop()
+
+	logger.Info("HTTP ingest shutdown complete")
+}
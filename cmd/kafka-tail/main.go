@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("kafka-tail", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	topicFlag := flag.String("topic", cfg.Topic(config.TopicKeySensorAlert), "topic to tail")
+	fromBeginningFlag := flag.Bool("from-beginning", false, "start from the oldest retained offset instead of the newest")
+	countFlag := flag.Int("n", 0, "exit after this many messages (0 tails until interrupted)")
+	valuesFlag := flag.Bool("values", false, "print message values")
+	flag.Parse()
+
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		logging.Fatal(logger, "Invalid Kafka security settings", "error", err)
+	}
+	saramaConfig := sarama.NewConfig()
+	for _, opt := range append([]kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}, security...) {
+		opt(saramaConfig)
+	}
+
+	consumer, err := sarama.NewConsumer(cfg.KafkaBrokers, saramaConfig)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create consumer", "error", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := consumer.Partitions(*topicFlag)
+	if err != nil {
+		logging.Fatal(logger, "Failed to list partitions", "topic", *topicFlag, "error", err)
+	}
+
+	offset := sarama.OffsetNewest
+	if *fromBeginningFlag {
+		offset = sarama.OffsetOldest
+	}
+
+	// Fan every partition into one channel so output is not interleaved mid-line
+	messages := make(chan *sarama.ConsumerMessage)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, partition := range partitions {
+		pc, err := consumer.ConsumePartition(*topicFlag, partition, offset)
+		if err != nil {
+			logging.Fatal(logger, "Failed to consume partition", "partition", partition, "error", err)
+		}
+		defer pc.Close()
+
+		wg.Add(1)
+		go func(pc sarama.PartitionConsumer) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				case msg, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					select {
+					case messages <- msg:
+					case <-done:
+						return
+					}
+				}
+			}
+		}(pc)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	logger.Info("Tailing topic", "topic", *topicFlag, "partitions", len(partitions))
+tail:
+	for seen := 0; *countFlag == 0 || seen < *countFlag; seen++ {
+		select {
+		case <-sigChan:
+			break tail
+		case msg := <-messages:
+			printMessage(msg, *valuesFlag)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+// printMessage writes one message with its decoded hops
+func printMessage(msg *sarama.ConsumerMessage, values bool) {
+	fmt.Printf("%s/%d@%d key=%s", msg.Topic, msg.Partition, msg.Offset, msg.Key)
+	if traceID, ok := kafka.TraceID(msg); ok {
+		fmt.Printf(" trace=%s", traceID)
+	}
+	if hops := kafka.Hops(msg); len(hops) > 0 {
+		fmt.Printf(" hops: %s", kafka.FormatHops(hops))
+	} else {
+		fmt.Print(" hops: none")
+	}
+	fmt.Println()
+
+	if values {
+		fmt.Printf("  %s\n", msg.Value)
+	}
+}
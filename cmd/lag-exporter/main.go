@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("lag-exporter", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Route sarama's internal logs into the service logs
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	groups, err := kafka.LagGroupsFromConfig(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Invalid LAG_EXPORTER_GROUPS", "error", err)
+	}
+
+	// Create metrics server (next to the service ports up to the cold archiver)
+	metricsPort := cfg.MetricsPort + 6 // Use port 2118 by default
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
+	metricsServer.Start()
+	defer metricsServer.Stop()
+
+	// Wait for Kafka
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), startup.KafkaDependency(cfg)); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
+
+	// Create the exporter; the series are named like kafka_exporter's
+	opts := []kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		logging.Fatal(logger, "Invalid Kafka security settings", "error", err)
+	}
+	exporter, err := kafka.NewLagExporter(
+		cfg.KafkaBrokers,
+		groups,
+		cfg.LagExporterInterval,
+		cfg.ConsumerOffsetInitial,
+		kafka.NewLagMetrics("kafka", "consumergroup", metricsServer.Registry()),
+		logger,
+		append(opts, security...)...,
+	)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create lag exporter", "error", err)
+	}
+	metricsServer.Handle("/lag", exporter)
+
+	// Start the exporter
+	exporter.Start()
+	waiter.Ready()
+	logger.Info("Exporting consumer group lag", "groups", len(groups), "interval", cfg.LagExporterInterval)
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for termination signal
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	exporter.Stop()
+
+	logger.Info("Lag exporter shutdown complete")
+}
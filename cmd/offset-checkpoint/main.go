@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+const usage = `usage:
+  offset-checkpoint export [-groups group=topic|topic,...] [-o file]
+  offset-checkpoint import [-i file] [-groups group,...] [-rename-groups old=new,...] [-rename-topics old=new,...] [-dry-run]`
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("offset-checkpoint", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		logging.Fatal(logger, "Invalid Kafka security settings", "error", err)
+	}
+	opts := append([]kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}, security...)
+
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "export":
+		flags := flag.NewFlagSet("export", flag.ExitOnError)
+		groupsFlag := flags.String("groups", "", "groups and topics to export as group=topic|topic,... (default: the groups of the lag exporter)")
+		outFlag := flags.String("o", "-", "file to write the checkpoint to (- is stdout)")
+		flags.Parse(args)
+
+		groups, err := exportGroups(cfg, *groupsFlag)
+		if err != nil {
+			logging.Fatal(logger, "Invalid -groups", "error", err)
+		}
+
+		checkpointer, err := kafka.NewOffsetCheckpointer(cfg.KafkaBrokers, logger, opts...)
+		if err != nil {
+			logging.Fatal(logger, "Failed to create offset checkpointer", "error", err)
+		}
+		defer checkpointer.Close()
+
+		checkpoint, err := checkpointer.Export(groups)
+		if err != nil {
+			logging.Fatal(logger, "Failed to export offsets", "error", err)
+		}
+		if err := writeFile(*outFlag, checkpoint); err != nil {
+			logging.Fatal(logger, "Failed to write checkpoint", "error", err)
+		}
+
+	case "import":
+		flags := flag.NewFlagSet("import", flag.ExitOnError)
+		inFlag := flags.String("i", "-", "file to read the checkpoint from (- is stdin)")
+		groupsFlag := flags.String("groups", "", "comma-separated groups of the checkpoint to import (default: all)")
+		renameGroupsFlag := flags.String("rename-groups", "", "import groups under other names, as old=new,...")
+		renameTopicsFlag := flags.String("rename-topics", "", "import topics under other names, as old=new,...")
+		dryRunFlag := flags.Bool("dry-run", false, "print the offsets that would be committed without committing them")
+		flags.Parse(args)
+
+		importOpts := kafka.ImportOptions{DryRun: *dryRunFlag}
+		for _, group := range strings.Split(*groupsFlag, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				importOpts.Groups = append(importOpts.Groups, group)
+			}
+		}
+		if importOpts.RenameGroups, err = kafka.ParseRenames(*renameGroupsFlag); err != nil {
+			logging.Fatal(logger, "Invalid -rename-groups", "error", err)
+		}
+		if importOpts.RenameTopics, err = kafka.ParseRenames(*renameTopicsFlag); err != nil {
+			logging.Fatal(logger, "Invalid -rename-topics", "error", err)
+		}
+
+		checkpoint, err := readFile(*inFlag)
+		if err != nil {
+			logging.Fatal(logger, "Failed to read checkpoint", "error", err)
+		}
+
+		checkpointer, err := kafka.NewOffsetCheckpointer(cfg.KafkaBrokers, logger, opts...)
+		if err != nil {
+			logging.Fatal(logger, "Failed to create offset checkpointer", "error", err)
+		}
+		defer checkpointer.Close()
+
+		result, err := checkpointer.Import(checkpoint, importOpts)
+		printResult(result, *dryRunFlag)
+		if err != nil {
+			logger.Error("Failed to import offsets", "error", err)
+			checkpointer.Close()
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// exportGroups returns the groups of spec, or the lag exporter's when it is empty
+func exportGroups(cfg *config.Config, spec string) ([]kafka.LagGroup, error) {
+	if spec == "" {
+		return kafka.LagGroupsFromConfig(cfg)
+	}
+	return kafka.ParseLagGroups(spec)
+}
+
+// writeFile writes a checkpoint to path, or to stdout for "-"
+func writeFile(path string, checkpoint kafka.OffsetCheckpoint) error {
+	if path == "-" {
+		return kafka.WriteOffsetCheckpoint(os.Stdout, checkpoint)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := kafka.WriteOffsetCheckpoint(file, checkpoint); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readFile reads a checkpoint from path, or from stdin for "-"
+func readFile(path string) (kafka.OffsetCheckpoint, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return kafka.OffsetCheckpoint{}, err
+		}
+		defer file.Close()
+		r = file
+	}
+	return kafka.ReadOffsetCheckpoint(r)
+}
+
+// printResult writes the offsets an import committed or would commit
+func printResult(result kafka.ImportResult, dryRun bool) {
+	verb := "committed"
+	if dryRun {
+		verb = "would commit"
+	}
+	for _, change := range result.Changes {
+		fmt.Printf("%s %s/%d: %d -> %d", change.Group, change.Topic, change.Partition, change.Previous, change.Offset)
+		if change.Clamped {
+			fmt.Print(" (clamped)")
+		}
+		fmt.Println()
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Printf("%s: skipped, partition does not exist\n", skipped)
+	}
+	fmt.Printf("%s %d offsets, skipped %d\n", verb, len(result.Changes), len(result.Skipped))
+}
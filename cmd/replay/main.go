@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/replay"
+	"github.com/example/iot-sensor-fleet/internal/sink"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("replay", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	fromFlag := flag.String("from", "", "replay archived readings at or after this time (RFC 3339, required)")
+	toFlag := flag.String("to", "", "replay archived readings before this time (RFC 3339, defaults to now)")
+	targetFlag := flag.String("target", cfg.Topic(config.TopicKeySensorRaw), "topic to republish readings to")
+	sensorFlag := flag.String("sensor", "", "only replay the readings of this sensor")
+	pacedFlag := flag.Bool("paced", false, "send readings as far apart as their timestamps instead of at full speed")
+	speedFlag := flag.Float64("speed", 1, "with -paced, replay this many times faster than the readings were taken")
+	limitFlag := flag.Int("n", 0, "stop after replaying this many readings (0 is unlimited)")
+	maxRateFlag := flag.Float64("max-rate", 0, "send at most this many readings per second (0 is unlimited)")
+	maxBytesFlag := flag.Float64("max-bytes-rate", 0, "send at most this many payload bytes per second (0 is unlimited)")
+	dryRunFlag := flag.Bool("dry-run", false, "read the archive and count the readings without sending them")
+	flag.Parse()
+
+	if *fromFlag == "" {
+		logging.Fatal(logger, "-from is required")
+	}
+	from, err := time.Parse(time.RFC3339, *fromFlag)
+	if err != nil {
+		logging.Fatal(logger, "Invalid -from", "error", err)
+	}
+	to := time.Now()
+	if *toFlag != "" {
+		if to, err = time.Parse(time.RFC3339, *toFlag); err != nil {
+			logging.Fatal(logger, "Invalid -to", "error", err)
+		}
+	}
+	if *speedFlag <= 0 {
+		logging.Fatal(logger, "-speed must be positive", "speed", *speedFlag)
+	}
+	if *maxRateFlag < 0 || *maxBytesFlag < 0 {
+		logging.Fatal(logger, "-max-rate and -max-bytes-rate must not be negative")
+	}
+	speed := 0.0
+	if *pacedFlag {
+		speed = *speedFlag
+	}
+
+	if err := kafka.ConfigureSaramaLogging(logger, cfg.SaramaLogLevel); err != nil {
+		logging.Fatal(logger, "Failed to configure Kafka client logging", "error", err)
+	}
+
+	store, err := storage.NewS3StoreFromConfig(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create object store", "error", err)
+	}
+	encryptor, err := encryption.NewEncryptorFromConfig(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create archive encryptor", "error", err)
+	}
+	reader, err := sink.NewArchiveReader(store, encryptor, cfg.ArchivePrefix, cfg.ArchiveDefaultTenant)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create archive reader", "error", err)
+	}
+	defer reader.Close()
+
+	var producer *kafka.Producer
+	if !*dryRunFlag {
+		producer, err = kafka.NewProducer(kafka.ProducerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			Topic:           *targetFlag,
+			RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+			Idempotent:      cfg.ProducerIdempotent,
+			ReturnSuccesses: cfg.ProducerReturnSuccess,
+			ReturnErrors:    cfg.ProducerReturnErrors,
+			Version:         cfg.KafkaVersion,
+			SendTimeout:     cfg.ProducerSendTimeout,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentReplay),
+		}, kafka.WithMaxMessagesPerSecond(*maxRateFlag), kafka.WithMaxBytesPerSecond(*maxBytesFlag))
+		if err != nil {
+			logging.Fatal(logger, "Failed to create producer", "error", err)
+		}
+	}
+
+	replayer, err := replay.NewReplayer(replay.Config{
+		From:     from,
+		To:       to,
+		SensorID: *sensorFlag,
+		Speed:    speed,
+		Limit:    *limitFlag,
+		DryRun:   *dryRunFlag,
+		Logger:   logger,
+	}, reader, producer)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create replayer", "error", err)
+	}
+
+	// Stop between readings on interrupt; readings sent so far stay sent
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	logger.Info("Replaying archived readings", "from", from.UTC().Format(time.RFC3339), "to", to.UTC().Format(time.RFC3339),
+		"target", *targetFlag, "speed", speed, "dry_run", *dryRunFlag)
+	started := time.Now()
+	result, runErr := replayer.Run(ctx)
+	if producer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ProducerShutdownTimeout)
+		if err := producer.GracefulShutdown(shutdownCtx); err != nil {
+			logger.Error("Error during producer shutdown", "error", err)
+		}
+		cancel()
+	}
+
+	attrs := []any{"replayed", result.Replayed, "objects", result.Objects, "skipped", result.Skipped,
+		"target", *targetFlag, "elapsed", time.Since(started).Round(time.Millisecond)}
+	if result.Replayed > 0 {
+		attrs = append(attrs, "first", result.First.UTC().Format(time.RFC3339), "last", result.Last.UTC().Format(time.RFC3339))
+	}
+	logger.Info("Replay finished", attrs...)
+	if runErr != nil {
+		logger.Error("Replay failed", "error", runErr)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/retention"
+	"github.com/example/iot-sensor-fleet/internal/startup"
+)
+
+func main() {
+	onceFlag := flag.Bool("once", false, "run every retention job once and exit, non-zero if one failed")
+	dryRunFlag := flag.Bool("dry-run", false, "count expired rows and documents without deleting them (overrides RETENTION_DRY_RUN)")
+	flag.Parse()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *dryRunFlag {
+		cfg.RetentionDryRun = true
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("retention", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Create metrics server (after the aggregator port)
+	metricsPort := cfg.MetricsPort + 13 // Use port 2125 by default
+	metricsServer := metrics.NewMetricsServer(metricsPort, logger)
+	if !*onceFlag {
+		metricsServer.Start()
+		defer metricsServer.Stop()
+	}
+
+	// Wait for PostgreSQL, and Elasticsearch if its documents expire
+	dependencies := []startup.Dependency{startup.PostgresDependency(cfg)}
+	if cfg.RetentionESReadingsTTL > 0 || cfg.RetentionESAlertsTTL > 0 {
+		dependencies = append(dependencies, startup.ElasticsearchDependency(cfg))
+	}
+	waiter := startup.NewWaiterFromConfig(cfg, metricsServer.Registry(), logger)
+	if err := waiter.Wait(context.Background(), dependencies...); err != nil {
+		logging.Fatal(logger, "Dependencies not ready", "error", err)
+	}
+
+	// Initialize PostgreSQL tables, so every expiring table exists
+	logger.Info("Initializing databases")
+	postgres, err := db.InitDatabases(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to initialize databases", "error", err)
+	}
+	postgres.Close()
+
+	// Create the retention jobs
+	service, err := retention.NewService(cfg, metricsServer.Registry(), logger)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create retention jobs", "error", err)
+	}
+
+	if *onceFlag {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		ok := service.RunOnce(ctx)
+		cancel()
+		service.Stop()
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Start the retention jobs
+	service.Start()
+	waiter.Ready()
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for termination signal
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	service.Stop()
+
+	logger.Info("Retention shutdown complete")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/aggregate"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/example/iot-sensor-fleet/internal/whatif"
+)
+
+func main() {
+	sinceFlag := flag.Duration("since", 24*time.Hour, "replay readings from this long ago (ignored when -from is set)")
+	fromFlag := flag.String("from", "", "start of the replay range (RFC 3339)")
+	toFlag := flag.String("to", "", "end of the replay range (RFC 3339, defaults to now)")
+	maxTemperatureFlag := flag.Float64("max-temperature", 0, "proposed maximum temperature (defaults to MAX_TEMPERATURE)")
+	minHumidityFlag := flag.Float64("min-humidity", 0, "proposed minimum humidity (defaults to MIN_HUMIDITY)")
+	siteRulesFlag := flag.String("site-rules", "", "proposed site rules (defaults to SITE_RULES)")
+	topFlag := flag.Int("top", 20, "number of sensors in the per-sensor breakdown")
+	listenFlag := flag.String("listen", "", "serve the what-if API on this address instead of running once")
+	flag.Parse()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Log at LOG_LEVEL, as JSON when LOG_FORMAT is json
+	logger, err := logging.NewFromConfig("whatif", cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// The baseline is the configuration currently deployed
+	rules, err := aggregate.ParseSiteRules(cfg.SiteRules)
+	if err != nil {
+		logging.Fatal(logger, "Invalid SITE_RULES", "error", err)
+	}
+	baseline := whatif.Scenario{
+		MaxTemperature: cfg.MaxTemperature,
+		MinHumidity:    cfg.MinHumidity,
+		SiteRules:      rules,
+	}
+
+	columns, err := encryption.NewColumnsFromConfig(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to load column encryption keys", "error", err)
+	}
+
+	postgres, err := db.NewPostgresDBWithReplicas(cfg, nil)
+	if err != nil {
+		logging.Fatal(logger, "Failed to connect to PostgreSQL", "error", err)
+	}
+	defer postgres.Close()
+
+	analyzer := whatif.NewAnalyzer(whatif.NewPostgresSource(postgres, columns), cfg.AggregateWindow, *topFlag)
+
+	if *listenFlag != "" {
+		serve(*listenFlag, whatif.NewHandler(analyzer, baseline, logger), logger)
+		return
+	}
+
+	// Only flags given on the command line override the baseline
+	var req whatif.Request
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max-temperature":
+			v := float32(*maxTemperatureFlag)
+			req.MaxTemperature = &v
+		case "min-humidity":
+			v := float32(*minHumidityFlag)
+			req.MinHumidity = &v
+		case "site-rules":
+			req.SiteRules = siteRulesFlag
+		}
+	})
+	proposed, err := req.Scenario(baseline)
+	if err != nil {
+		logging.Fatal(logger, "Invalid -site-rules", "error", err)
+	}
+
+	to := time.Now()
+	if *toFlag != "" {
+		if to, err = time.Parse(time.RFC3339, *toFlag); err != nil {
+			logging.Fatal(logger, "Invalid -to", "error", err)
+		}
+	}
+	from := to.Add(-*sinceFlag)
+	if *fromFlag != "" {
+		if from, err = time.Parse(time.RFC3339, *fromFlag); err != nil {
+			logging.Fatal(logger, "Invalid -from", "error", err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	logger.Info("Replaying readings", "from", from.Format(time.RFC3339), "to", to.Format(time.RFC3339))
+	report, err := analyzer.Analyze(ctx, from, to, baseline, proposed)
+	if err != nil {
+		logging.Fatal(logger, "What-if analysis failed", "error", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		logging.Fatal(logger, "Failed to write report", "error", err)
+	}
+}
+
+// serve runs the what-if API until a termination signal arrives
+func serve(addr string, handler *whatif.Handler, logger *slog.Logger) {
+	mux := http.NewServeMux()
+	handler.Register(mux)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		logger.Info("Starting what-if API", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(logger, "What-if API failed", "error", err)
+		}
+	}()
+
+	// Set up signal handler for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	logger.Info("Received termination signal, shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+}
@@ -1,10 +1,16 @@
 -- Create sensor_readings table if it doesn't exist
 CREATE TABLE IF NOT EXISTS sensor_readings (
-  id VARCHAR(36) PRIMARY KEY,
+  sensor_id VARCHAR(36) NOT NULL,
   ts BIGINT NOT NULL,
   temperature REAL NOT NULL,
   humidity REAL NOT NULL,
-  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+  site TEXT,
+  zone TEXT,
+  latitude DOUBLE PRECISION,
+  longitude DOUBLE PRECISION,
+  location_enc TEXT,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (sensor_id, ts)
 );
 
 -- Create sensor_alerts table if it doesn't exist
@@ -14,10 +20,208 @@ CREATE TABLE IF NOT EXISTS sensor_alerts (
   reason TEXT NOT NULL,
   temperature REAL NOT NULL,
   humidity REAL NOT NULL,
+  site TEXT,
+  zone TEXT,
+  latitude DOUBLE PRECISION,
+  longitude DOUBLE PRECISION,
+  location_enc TEXT,
   created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
   PRIMARY KEY (sensor_id, ts)
 );
 
+-- Announce inserted alerts to the API instances listening on sensor_alerts
+CREATE OR REPLACE FUNCTION notify_sensor_alert() RETURNS TRIGGER
+LANGUAGE plpgsql AS $$
+BEGIN
+  PERFORM pg_notify('sensor_alerts', json_build_object(
+    'sensor_id', NEW.sensor_id, 'ts', NEW.ts, 'reason', NEW.reason,
+    'temperature', NEW.temperature, 'humidity', NEW.humidity, 'site', NEW.site, 'zone', NEW.zone,
+    'latitude', NEW.latitude, 'longitude', NEW.longitude, 'location_enc', NEW.location_enc)::TEXT);
+  RETURN NULL;
+END
+$$;
+
+DROP TRIGGER IF EXISTS sensor_alerts_notify ON sensor_alerts;
+CREATE TRIGGER sensor_alerts_notify AFTER INSERT ON sensor_alerts
+  FOR EACH ROW EXECUTE FUNCTION notify_sensor_alert();
+
+-- Create ingest_idempotency_keys table if it doesn't exist
+CREATE TABLE IF NOT EXISTS ingest_idempotency_keys (
+  key VARCHAR(320) PRIMARY KEY,
+  status_code INTEGER,
+  content_type TEXT,
+  body BYTEA,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create sensor registry tables if they don't exist
+CREATE TABLE IF NOT EXISTS provisioning_tokens (
+  id BIGSERIAL PRIMARY KEY,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  label TEXT NOT NULL DEFAULT '',
+  max_claims INTEGER NOT NULL,
+  claims INTEGER NOT NULL DEFAULT 0,
+  revoked BOOLEAN NOT NULL DEFAULT FALSE,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sensors (
+  id VARCHAR(36) PRIMARY KEY,
+  hardware_id TEXT NOT NULL UNIQUE,
+  firmware_version TEXT,
+  provisioning_token_id BIGINT REFERENCES provisioning_tokens (id),
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  site TEXT,
+  zone TEXT,
+  tags TEXT[] NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS sensor_credentials (
+  id BIGSERIAL PRIMARY KEY,
+  sensor_id VARCHAR(36) NOT NULL REFERENCES sensors (id) ON DELETE CASCADE,
+  api_key_hash CHAR(64) NOT NULL UNIQUE,
+  mqtt_username TEXT NOT NULL,
+  mqtt_password_hash CHAR(64) NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create incident tables if they don't exist
+CREATE TABLE IF NOT EXISTS incidents (
+  id VARCHAR(36) PRIMARY KEY,
+  site TEXT NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  opened_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  resolved_at TIMESTAMP WITH TIME ZONE,
+  alert_count INTEGER NOT NULL,
+  summary TEXT NOT NULL
+);
+
+-- Member alerts reference sensor_alerts by (sensor_id, ts)
+CREATE TABLE IF NOT EXISTS incident_alerts (
+  incident_id VARCHAR(36) NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+  sensor_id VARCHAR(36) NOT NULL,
+  ts BIGINT NOT NULL,
+  reason TEXT NOT NULL,
+  PRIMARY KEY (incident_id, sensor_id, ts)
+);
+
+-- Create annotations table if it doesn't exist
+CREATE TABLE IF NOT EXISTS annotations (
+  scope VARCHAR(16) NOT NULL,
+  target TEXT NOT NULL,
+  runbook_url TEXT,
+  annotations JSONB,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (scope, target)
+);
+
+-- Create threshold tables for detector thresholds managed over the API
+CREATE TABLE IF NOT EXISTS thresholds (
+  scope VARCHAR(16) NOT NULL,
+  target TEXT NOT NULL,
+  max_temperature REAL,
+  min_humidity REAL,
+  min_battery_pct REAL,
+  min_rssi INTEGER,
+  updated_by TEXT NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (scope, target)
+);
+
+CREATE TABLE IF NOT EXISTS threshold_changes (
+  id BIGSERIAL PRIMARY KEY,
+  scope VARCHAR(16) NOT NULL,
+  target TEXT NOT NULL,
+  action VARCHAR(16) NOT NULL,
+  old_values JSONB,
+  new_values JSONB,
+  changed_by TEXT NOT NULL,
+  reason TEXT,
+  changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create notification_templates table for templates managed over the notifier's API
+CREATE TABLE IF NOT EXISTS notification_templates (
+  name VARCHAR(64) NOT NULL,
+  version INTEGER NOT NULL,
+  format VARCHAR(16) NOT NULL,
+  body TEXT NOT NULL,
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (name, version)
+);
+
+-- Create partition_sites table for the site-affinity balance strategy
+CREATE TABLE IF NOT EXISTS partition_sites (
+  topic TEXT NOT NULL,
+  partition INTEGER NOT NULL,
+  site TEXT NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (topic, partition)
+);
+
+-- Create archive catalog tables for verifying and locating cold storage
+CREATE TABLE IF NOT EXISTS archive_catalog (
+  object_key TEXT PRIMARY KEY,
+  manifest_key TEXT NOT NULL,
+  readings INTEGER NOT NULL,
+  bytes BIGINT NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  min_ts BIGINT NOT NULL,
+  max_ts BIGINT NOT NULL,
+  compression TEXT NOT NULL,
+  encryption TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS archive_catalog_offsets (
+  manifest_key TEXT NOT NULL,
+  topic TEXT NOT NULL,
+  partition INTEGER NOT NULL,
+  readings INTEGER NOT NULL,
+  min_offset BIGINT NOT NULL,
+  max_offset BIGINT NOT NULL,
+  PRIMARY KEY (manifest_key, topic, partition)
+);
+
+CREATE TABLE IF NOT EXISTS archive_catalog_sensors (
+  object_key TEXT NOT NULL,
+  sensor_id TEXT NOT NULL,
+  PRIMARY KEY (sensor_id, object_key)
+);
+
+-- Create sensor_aggregates table if it doesn't exist
+CREATE TABLE IF NOT EXISTS sensor_aggregates (
+  sensor_id VARCHAR(36) NOT NULL,
+  site TEXT,
+  window_start BIGINT NOT NULL,
+  window_end BIGINT NOT NULL,
+  readings INTEGER NOT NULL,
+  min_temperature DOUBLE PRECISION NOT NULL,
+  max_temperature DOUBLE PRECISION NOT NULL,
+  avg_temperature DOUBLE PRECISION NOT NULL,
+  min_humidity DOUBLE PRECISION NOT NULL,
+  max_humidity DOUBLE PRECISION NOT NULL,
+  avg_humidity DOUBLE PRECISION NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (sensor_id, window_start)
+);
+
 -- Create indexes for better query performance
 CREATE INDEX IF NOT EXISTS idx_sensor_readings_ts ON sensor_readings (ts);
-CREATE INDEX IF NOT EXISTS idx_sensor_alerts_ts ON sensor_alerts (ts);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_sensor_alerts_ts ON sensor_alerts (ts);
+CREATE INDEX IF NOT EXISTS idx_sensor_alerts_location ON sensor_alerts (latitude, longitude);
+CREATE INDEX IF NOT EXISTS idx_ingest_idempotency_keys_expires_at ON ingest_idempotency_keys (expires_at);
+CREATE INDEX IF NOT EXISTS idx_sensors_tags ON sensors USING GIN (tags);
+CREATE INDEX IF NOT EXISTS idx_sensor_credentials_sensor_id ON sensor_credentials (sensor_id);
+CREATE INDEX IF NOT EXISTS idx_sensor_credentials_mqtt_username ON sensor_credentials (mqtt_username);
+CREATE INDEX IF NOT EXISTS idx_incidents_site_status ON incidents (site, status);
+CREATE INDEX IF NOT EXISTS idx_incident_alerts_sensor_ts ON incident_alerts (sensor_id, ts);
+CREATE INDEX IF NOT EXISTS idx_archive_catalog_ts ON archive_catalog (min_ts, max_ts);
+CREATE INDEX IF NOT EXISTS idx_archive_catalog_offsets_partition ON archive_catalog_offsets (topic, partition, min_offset);
+CREATE INDEX IF NOT EXISTS idx_threshold_changes_target ON threshold_changes (scope, target, id);
+CREATE INDEX IF NOT EXISTS idx_sensor_aggregates_window_start ON sensor_aggregates (window_start);
\ No newline at end of file
@@ -0,0 +1,256 @@
+package aggregate
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// subscriptionBuffer is the bus buffer for readings and alerts; aggregation
+// tolerates occasional drops but should not lose whole bursts
+const subscriptionBuffer = 4096
+
+// AggregatorMetrics holds Prometheus metrics for site aggregation
+type AggregatorMetrics struct {
+	Windows        prometheus.Counter
+	Sites          prometheus.Gauge
+	Unattributed   prometheus.Counter
+	AggregatedRows prometheus.Counter
+}
+
+// NewAggregatorMetrics creates a new set of aggregator metrics
+func NewAggregatorMetrics(namespace, subsystem string, registry prometheus.Registerer) *AggregatorMetrics {
+	metrics := &AggregatorMetrics{
+		Windows: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "windows_total",
+			Help:      "Total number of aggregation windows flushed",
+		}),
+		Sites: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "sites",
+			Help:      "Number of sites with readings in the last window",
+		}),
+		Unattributed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "unattributed_readings_total",
+			Help:      "Total number of readings without a site that were not aggregated",
+		}),
+		AggregatedRows: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "aggregates_total",
+			Help:      "Total number of site aggregates emitted",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Windows,
+		metrics.Sites,
+		metrics.Unattributed,
+		metrics.AggregatedRows,
+	)
+
+	return metrics
+}
+
+// siteWindow accumulates one site's readings within the current window
+type siteWindow struct {
+	readings    int
+	sumTemp     float64
+	maxTemp     float64
+	sumHumidity float64
+	minHumidity float64
+	sensors     map[string]struct{}
+	alerting    map[string]struct{}
+}
+
+func newSiteWindow() *siteWindow {
+	return &siteWindow{
+		maxTemp:     math.Inf(-1),
+		minHumidity: math.Inf(1),
+		sensors:     make(map[string]struct{}),
+		alerting:    make(map[string]struct{}),
+	}
+}
+
+// Accumulator folds readings and alerts into per-site aggregates for one window.
+// It is not safe for concurrent use; the live Aggregator and offline replays
+// such as threshold backtests both drive it from a single goroutine.
+type Accumulator struct {
+	sites map[string]*siteWindow
+}
+
+// NewAccumulator creates an empty accumulator
+func NewAccumulator() *Accumulator {
+	return &Accumulator{sites: make(map[string]*siteWindow)}
+}
+
+// AddReading folds a reading into its site's window.
+// It returns false for readings without a site, which cannot be aggregated.
+func (acc *Accumulator) AddReading(reading *model.SensorReading) bool {
+	if reading.Site == "" {
+		return false
+	}
+
+	w := acc.site(reading.Site)
+	w.readings++
+	w.sumTemp += float64(reading.Temperature)
+	w.maxTemp = math.Max(w.maxTemp, float64(reading.Temperature))
+	w.sumHumidity += float64(reading.Humidity)
+	w.minHumidity = math.Min(w.minHumidity, float64(reading.Humidity))
+	w.sensors[reading.ID] = struct{}{}
+	return true
+}
+
+// AddAlert marks the alerting sensor in its site's window
+func (acc *Accumulator) AddAlert(alert *model.SensorAlert) {
+	if alert.Site == "" {
+		return
+	}
+
+	acc.site(alert.Site).alerting[alert.SensorID] = struct{}{}
+}
+
+// Flush returns the aggregates of the window [start, end) sorted by site and resets the accumulator
+func (acc *Accumulator) Flush(start, end time.Time) []*model.SiteAggregate {
+	sites := acc.sites
+	acc.sites = make(map[string]*siteWindow)
+
+	aggregates := make([]*model.SiteAggregate, 0, len(sites))
+	for name, w := range sites {
+		if w.readings == 0 {
+			continue
+		}
+		aggregate := &model.SiteAggregate{
+			Site:            name,
+			WindowStart:     start.UnixMilli(),
+			WindowEnd:       end.UnixMilli(),
+			Readings:        w.readings,
+			Sensors:         len(w.sensors),
+			AlertingSensors: len(w.alerting),
+			AvgTemperature:  w.sumTemp / float64(w.readings),
+			MaxTemperature:  w.maxTemp,
+			AvgHumidity:     w.sumHumidity / float64(w.readings),
+			MinHumidity:     w.minHumidity,
+		}
+		aggregate.AlertingFraction = math.Min(1, float64(aggregate.AlertingSensors)/float64(aggregate.Sensors))
+		aggregates = append(aggregates, aggregate)
+	}
+	sort.Slice(aggregates, func(i, j int) bool { return aggregates[i].Site < aggregates[j].Site })
+	return aggregates
+}
+
+// site returns the window for a site, creating it if needed
+func (acc *Accumulator) site(name string) *siteWindow {
+	w, ok := acc.sites[name]
+	if !ok {
+		w = newSiteWindow()
+		acc.sites[name] = w
+	}
+	return w
+}
+
+// Aggregator reduces the readings and alerts published on the bus into
+// per-site tumbling-window aggregates, which it publishes on
+// bus.TopicSiteAggregates. Readings without a site are ignored.
+type Aggregator struct {
+	window   time.Duration
+	bus      *bus.Bus
+	metrics  *AggregatorMetrics
+	readings *bus.Subscription
+	alerts   *bus.Subscription
+
+	// acc and windowStart are only touched by the run goroutine
+	acc         *Accumulator
+	windowStart time.Time
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewAggregator creates a new aggregator over the given bus; metrics may be nil
+func NewAggregator(eventBus *bus.Bus, window time.Duration, metrics *AggregatorMetrics) *Aggregator {
+	return &Aggregator{
+		window:      window,
+		bus:         eventBus,
+		metrics:     metrics,
+		acc:         NewAccumulator(),
+		windowStart: time.Now(),
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// Start subscribes to readings and alerts and begins flushing windows
+func (a *Aggregator) Start() {
+	a.readings = a.bus.Subscribe(bus.TopicReadings, subscriptionBuffer)
+	a.alerts = a.bus.Subscribe(bus.TopicAlerts, subscriptionBuffer)
+	a.windowStart = time.Now()
+
+	a.wg.Add(1)
+	go a.run()
+}
+
+// Stop unsubscribes and stops flushing; the partial window is discarded
+func (a *Aggregator) Stop() {
+	close(a.stopCh)
+	a.wg.Wait()
+	a.readings.Unsubscribe()
+	a.alerts.Unsubscribe()
+}
+
+// run consumes events and flushes on every window boundary
+func (a *Aggregator) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case event, ok := <-a.readings.C():
+			if !ok {
+				return
+			}
+			if reading, ok := event.Payload.(*model.SensorReading); ok {
+				if !a.acc.AddReading(reading) && a.metrics != nil {
+					a.metrics.Unattributed.Inc()
+				}
+			}
+		case event, ok := <-a.alerts.C():
+			if !ok {
+				return
+			}
+			if alert, ok := event.Payload.(*model.SensorAlert); ok {
+				a.acc.AddAlert(alert)
+			}
+		case now := <-ticker.C:
+			for _, aggregate := range a.flush(now) {
+				a.bus.Publish(bus.TopicSiteAggregates, aggregate.Site, aggregate)
+			}
+		}
+	}
+}
+
+// flush closes the current window and returns its aggregates sorted by site
+func (a *Aggregator) flush(now time.Time) []*model.SiteAggregate {
+	aggregates := a.acc.Flush(a.windowStart, now)
+	a.windowStart = now
+
+	if a.metrics != nil {
+		a.metrics.Windows.Inc()
+		a.metrics.Sites.Set(float64(len(aggregates)))
+		a.metrics.AggregatedRows.Add(float64(len(aggregates)))
+	}
+	return aggregates
+}
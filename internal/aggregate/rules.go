@@ -0,0 +1,347 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ruleOperators lists supported comparison operators, longest first for parsing
+var ruleOperators = []string{">=", "<=", ">", "<"}
+
+// SiteRule raises a site alert when an aggregate metric breaches a threshold
+// for at least For, e.g. "average site temperature > 40 for 10 minutes"
+type SiteRule struct {
+	Name      string
+	Metric    string
+	Operator  string
+	Threshold float64
+	For       time.Duration
+}
+
+// Breached reports whether a value breaches the rule threshold
+func (r SiteRule) Breached(value float64) bool {
+	switch r.Operator {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	default:
+		return false
+	}
+}
+
+// String renders the rule in the form accepted by ParseSiteRules
+func (r SiteRule) String() string {
+	s := fmt.Sprintf("%s=%s%s%g", r.Name, r.Metric, r.Operator, r.Threshold)
+	if r.For > 0 {
+		s += "@" + r.For.String()
+	}
+	return s
+}
+
+// ParseSiteRules parses a "name=metric>threshold@duration;..." rule list.
+// The @duration suffix is optional and defaults to a single window.
+func ParseSiteRules(spec string) ([]SiteRule, error) {
+	var rules []SiteRule
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, expr, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid site rule %q: expected name=expression", entry)
+		}
+
+		rule := SiteRule{Name: strings.TrimSpace(name)}
+		expr, duration, hasDuration := strings.Cut(expr, "@")
+		if hasDuration {
+			d, err := time.ParseDuration(strings.TrimSpace(duration))
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration in site rule %s: %w", rule.Name, err)
+			}
+			rule.For = d
+		}
+
+		for _, op := range ruleOperators {
+			if metric, threshold, ok := strings.Cut(expr, op); ok {
+				rule.Metric = strings.TrimSpace(metric)
+				rule.Operator = op
+				value, err := strconv.ParseFloat(strings.TrimSpace(threshold), 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid threshold in site rule %s: %w", rule.Name, err)
+				}
+				rule.Threshold = value
+				break
+			}
+		}
+		if rule.Operator == "" {
+			return nil, fmt.Errorf("invalid site rule %s: missing comparison operator", rule.Name)
+		}
+		if _, ok := (&model.SiteAggregate{}).Metric(rule.Metric); !ok {
+			return nil, fmt.Errorf("invalid site rule %s: unknown metric %q", rule.Name, rule.Metric)
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// RuleMetrics holds Prometheus metrics for site rule evaluation
+type RuleMetrics struct {
+	Evaluations *prometheus.CounterVec
+	Alerts      *prometheus.CounterVec
+	Breaching   *prometheus.GaugeVec
+}
+
+// NewRuleMetrics creates a new set of site rule metrics
+func NewRuleMetrics(namespace, subsystem string, registry prometheus.Registerer) *RuleMetrics {
+	metrics := &RuleMetrics{
+		Evaluations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "rule_evaluations_total",
+			Help:      "Total number of site rule evaluations by rule",
+		}, []string{"rule"}),
+		Alerts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "site_alerts_total",
+			Help:      "Total number of site alerts raised by rule",
+		}, []string{"rule"}),
+		Breaching: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "rule_breaching_sites",
+			Help:      "Number of sites currently breaching each rule",
+		}, []string{"rule"}),
+	}
+
+	registry.MustRegister(metrics.Evaluations, metrics.Alerts, metrics.Breaching)
+
+	return metrics
+}
+
+// breach tracks a rule breach for one site
+type breach struct {
+	since  int64
+	firing bool
+
+	// last is the window end of the site's latest breaching aggregate
+	last int64
+}
+
+// RuleEngine evaluates site rules against the aggregates published on the bus
+// and emits a site alert once per continuous breach
+type RuleEngine struct {
+	rules    []SiteRule
+	producer *kafka.Producer
+	metrics  *RuleMetrics
+	logger   *slog.Logger
+	bus      *bus.Bus
+	sub      *bus.Subscription
+
+	// breaches is keyed by rule name then site
+	breaches map[string]map[string]*breach
+	// window is the latest window start evaluated
+	window int64
+
+	// annotator optionally attaches runbook links and annotations to site alerts
+	annotator model.Annotator
+
+	// dryRun evaluates rules without logging or publishing alerts
+	dryRun bool
+
+	// ctx is cancelled on Stop so in-flight alert sends are abandoned
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRuleEngine creates a rule engine; producer, metrics and logger may be nil
+func NewRuleEngine(eventBus *bus.Bus, rules []SiteRule, producer *kafka.Producer, metrics *RuleMetrics, logger *slog.Logger) *RuleEngine {
+	breaches := make(map[string]map[string]*breach, len(rules))
+	for _, rule := range rules {
+		breaches[rule.Name] = make(map[string]*breach)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RuleEngine{
+		rules:    rules,
+		producer: producer,
+		metrics:  metrics,
+		logger:   logging.OrDefault(logger),
+		bus:      eventBus,
+		breaches: breaches,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// NewDryRunEngine creates a rule engine that only returns the alerts it would raise,
+// for backtesting rules against historical aggregates
+func NewDryRunEngine(rules []SiteRule) *RuleEngine {
+	e := NewRuleEngine(nil, rules, nil, nil, nil)
+	e.dryRun = true
+	return e
+}
+
+// SetAnnotator sets the source of runbook links and annotations for site alerts
+func (e *RuleEngine) SetAnnotator(annotator model.Annotator) {
+	e.annotator = annotator
+}
+
+// Start subscribes to site aggregates and begins evaluating rules
+func (e *RuleEngine) Start() {
+	e.sub = e.bus.Subscribe(bus.TopicSiteAggregates, bus.DefaultBufferSize)
+
+	e.wg.Add(1)
+	go e.run()
+}
+
+// Stop stops evaluating rules
+func (e *RuleEngine) Stop() {
+	e.cancel()
+	e.wg.Wait()
+	e.sub.Unsubscribe()
+}
+
+func (e *RuleEngine) run() {
+	defer e.wg.Done()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case event, ok := <-e.sub.C():
+			if !ok {
+				return
+			}
+			if aggregate, ok := event.Payload.(*model.SiteAggregate); ok {
+				e.Evaluate(e.ctx, aggregate)
+			}
+		}
+	}
+}
+
+// Evaluate checks every rule against an aggregate and returns the alerts raised;
+// ctx bounds publishing them to Kafka
+func (e *RuleEngine) Evaluate(ctx context.Context, aggregate *model.SiteAggregate) []*model.SiteAlert {
+	if aggregate.WindowStart > e.window {
+		e.expire(aggregate.WindowStart)
+		e.window = aggregate.WindowStart
+	}
+
+	var alerts []*model.SiteAlert
+	for _, rule := range e.rules {
+		value, _ := aggregate.Metric(rule.Metric)
+		if e.metrics != nil {
+			e.metrics.Evaluations.WithLabelValues(rule.Name).Inc()
+		}
+
+		sites := e.breaches[rule.Name]
+		if !rule.Breached(value) {
+			delete(sites, aggregate.Site)
+			e.setBreaching(rule.Name, len(sites))
+			continue
+		}
+
+		b, ok := sites[aggregate.Site]
+		if !ok {
+			b = &breach{since: aggregate.WindowStart}
+			sites[aggregate.Site] = b
+			e.setBreaching(rule.Name, len(sites))
+		}
+		b.last = aggregate.WindowEnd
+
+		duration := time.Duration(aggregate.WindowEnd-b.since) * time.Millisecond
+		if b.firing || duration < rule.For {
+			continue
+		}
+		b.firing = true
+
+		alert := &model.SiteAlert{
+			Rule:      rule.Name,
+			Site:      aggregate.Site,
+			Timestamp: aggregate.WindowEnd,
+			Since:     b.since,
+			Reason: fmt.Sprintf("%s %s %g at site %s for %s (value %.2f)",
+				rule.Metric, rule.Operator, rule.Threshold, aggregate.Site, duration.Round(time.Second), value),
+			Metric:    rule.Metric,
+			Operator:  rule.Operator,
+			Threshold: rule.Threshold,
+			Value:     value,
+		}
+		if e.annotator != nil {
+			alert.RunbookURL, alert.Annotations = e.annotator.Annotate(rule.Name, aggregate.Site)
+		}
+		e.emit(ctx, alert)
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// emit publishes a site alert to Kafka and the bus
+func (e *RuleEngine) emit(ctx context.Context, alert *model.SiteAlert) {
+	if e.dryRun {
+		return
+	}
+	e.logger.Info("Site alert", "rule", alert.Rule, "site", alert.Site, "reason", alert.Reason)
+
+	if e.metrics != nil {
+		e.metrics.Alerts.WithLabelValues(alert.Rule).Inc()
+	}
+	e.bus.Publish(bus.TopicSiteAlerts, alert.Site, alert)
+
+	if e.producer == nil {
+		return
+	}
+	data, err := model.SerializeSiteAlert(alert)
+	if err != nil {
+		e.logger.Error("Error serializing site alert", "error", err)
+		return
+	}
+	if err := e.producer.SendMessageWithKey(ctx, alert.Site, data); err != nil {
+		e.logger.Error("Error sending site alert", "error", err)
+	}
+}
+
+// expire forgets the breaches of sites without an aggregate in the window
+// ending at start, so a site that stopped reporting is no longer counted as
+// breaching and a breach only spans consecutive windows
+func (e *RuleEngine) expire(start int64) {
+	for rule, sites := range e.breaches {
+		expired := false
+		for site, b := range sites {
+			if b.last < start {
+				delete(sites, site)
+				expired = true
+			}
+		}
+		if expired {
+			e.setBreaching(rule, len(sites))
+		}
+	}
+}
+
+func (e *RuleEngine) setBreaching(rule string, sites int) {
+	if e.metrics != nil {
+		e.metrics.Breaching.WithLabelValues(rule).Set(float64(sites))
+	}
+}
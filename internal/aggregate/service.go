@@ -0,0 +1,97 @@
+package aggregate
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	sensorregistry "github.com/example/iot-sensor-fleet/internal/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Service aggregates readings per site and evaluates site rules on the result
+type Service struct {
+	Aggregator  *Aggregator
+	Rules       *RuleEngine
+	producer    *kafka.Producer
+	annotations *sensorregistry.AnnotationCache
+}
+
+// NewService creates the site aggregator and rule engine from configuration.
+// Readings and alerts are taken from eventBus, so the detector must run in the
+// same process. Metrics are registered on registry.
+func NewService(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "aggregator")
+	if eventBus == nil {
+		return nil, fmt.Errorf("site aggregation requires an in-process bus")
+	}
+
+	rules, err := ParseSiteRules(cfg.SiteRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SITE_RULES: %w", err)
+	}
+
+	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:         cfg.KafkaBrokers,
+		Topic:           cfg.Topic(config.TopicKeySiteAlert),
+		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+		Idempotent:      cfg.ProducerIdempotent,
+		ReturnSuccesses: cfg.ProducerReturnSuccess,
+		ReturnErrors:    cfg.ProducerReturnErrors,
+		Metrics:         kafka.NewProducerMetrics("iot", "site_alert_producer", registry),
+		Version:         cfg.KafkaVersion,
+		SendTimeout:     cfg.ProducerSendTimeout,
+		HopHeaders:      cfg.HopHeaders,
+		Security:        kafka.SecurityFromConfig(cfg),
+		Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentAggregator),
+		Logger:          logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create site alert producer: %w", err)
+	}
+
+	for _, rule := range rules {
+		logger.Info("Loaded site rule", "rule", rule.String())
+	}
+
+	s := &Service{
+		Aggregator: NewAggregator(eventBus, cfg.AggregateWindow, NewAggregatorMetrics("iot", "aggregator", registry)),
+		Rules:      NewRuleEngine(eventBus, rules, producer, NewRuleMetrics("iot", "aggregator", registry), logger),
+		producer:   producer,
+	}
+
+	// Attach runbook links and annotations from the sensor registry
+	annotations, err := sensorregistry.NewAnnotationCacheFromConfig(cfg, logger)
+	if err != nil {
+		logger.Warn("Site alerts will not be annotated", "error", err)
+	} else if annotations != nil {
+		s.annotations = annotations
+		s.Rules.SetAnnotator(annotations)
+	}
+
+	return s, nil
+}
+
+// Start starts the rule engine before the aggregator feeding it
+func (s *Service) Start() error {
+	if s.annotations != nil {
+		s.annotations.Start()
+	}
+	s.Rules.Start()
+	s.Aggregator.Start()
+	return nil
+}
+
+// Stop stops aggregation and rule evaluation and closes the producer
+func (s *Service) Stop() {
+	s.Aggregator.Stop()
+	s.Rules.Stop()
+	s.producer.Close()
+	if s.annotations != nil {
+		s.annotations.Stop()
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ArchiveObject is an object of the cold-storage archive recorded in the
+// archive catalog: compressed NDJSON readings of one batch and hour
+type ArchiveObject struct {
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	ManifestKey string `json:"manifest_key"`
+	Readings    int    `json:"readings"`
+	// Sensors is the number of sensors with readings in the object
+	Sensors int    `json:"sensors"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+	// MinTimestamp and MaxTimestamp bound the readings' timestamps in Unix ms
+	MinTimestamp int64  `json:"min_ts"`
+	MaxTimestamp int64  `json:"max_ts"`
+	Compression  string `json:"compression"`
+	Encryption   string `json:"encryption"`
+}
+
+// ListArchiveObjects returns the archived objects matching a query, newest
+// first by their oldest reading. An object matches a time range when any of
+// its readings may fall in it, and a sensor when the object holds readings
+// of that sensor. Bucket is left for the caller to set.
+func (s *Store) ListArchiveObjects(ctx context.Context, query Query) ([]*ArchiveObject, error) {
+	var conditions []string
+	var args []interface{}
+	if query.SensorID != "" {
+		conditions = append(conditions, "object_key IN (SELECT object_key FROM archive_catalog_sensors WHERE sensor_id = "+
+			bind(&args, query.SensorID)+")")
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "max_ts >= "+bind(&args, query.From.UnixMilli()))
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "min_ts < "+bind(&args, query.To.UnixMilli()))
+	}
+	if query.After != nil {
+		ts, key := bind(&args, query.After.Timestamp), bind(&args, query.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(min_ts < %s OR (min_ts = %s AND object_key > %s))", ts, ts, key))
+	}
+
+	statement := `
+		SELECT object_key, manifest_key, readings,
+			(SELECT COUNT(*) FROM archive_catalog_sensors s WHERE s.object_key = c.object_key),
+			bytes, sha256, min_ts, max_ts, compression, encryption
+		FROM archive_catalog c`
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	statement += " ORDER BY min_ts DESC, object_key"
+	if query.Limit > 0 {
+		statement += " LIMIT " + bind(&args, query.Limit)
+	}
+	if query.Offset > 0 {
+		statement += " OFFSET " + bind(&args, query.Offset)
+	}
+
+	rows, err := s.postgres.ReadDB().QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archive catalog: %w", err)
+	}
+	defer rows.Close()
+
+	objects := []*ArchiveObject{}
+	for rows.Next() {
+		var object ArchiveObject
+		if err := rows.Scan(&object.Key, &object.ManifestKey, &object.Readings, &object.Sensors, &object.Bytes,
+			&object.SHA256, &object.MinTimestamp, &object.MaxTimestamp, &object.Compression, &object.Encryption); err != nil {
+			return nil, fmt.Errorf("failed to scan archive object: %w", err)
+		}
+		objects = append(objects, &object)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive catalog: %w", err)
+	}
+	return objects, nil
+}
+
+func archiveObjectCursor(object *ArchiveObject) Cursor {
+	return Cursor{Timestamp: object.MinTimestamp, ID: object.Key}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CacheMetrics holds Prometheus metrics for the query cache
+type CacheMetrics struct {
+	Lookups *prometheus.CounterVec
+}
+
+// NewCacheMetrics creates a new set of query cache metrics
+func NewCacheMetrics(namespace, subsystem string, registry prometheus.Registerer) *CacheMetrics {
+	metrics := &CacheMetrics{
+		Lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "cache_lookups_total",
+			Help:      "Total number of cached query lookups by query and result (hit, miss, shared)",
+		}, []string{"query", "result"}),
+	}
+
+	registry.MustRegister(metrics.Lookups)
+
+	return metrics
+}
+
+// cacheEntry is a query result, or a load in progress until done is closed
+type cacheEntry struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	until time.Time
+}
+
+// Cache holds query results for a short TTL. Concurrent misses for the same
+// key share a single load, so a burst of dashboard polls after an entry
+// expires costs one database query. Failed loads are not cached.
+type Cache struct {
+	ttl     time.Duration
+	timeout time.Duration
+	metrics *CacheMetrics
+
+	mu        sync.Mutex
+	entries   map[string]*cacheEntry
+	lastSweep time.Time
+}
+
+// NewCache creates a new cache; a zero ttl disables caching but still shares
+// concurrent loads. Loads are bounded by timeout. metrics may be nil.
+func NewCache(ttl, timeout time.Duration, metrics *CacheMetrics) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		timeout: timeout,
+		metrics: metrics,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// get returns the cached result of query for key, or loads it. The load runs
+// detached from ctx so that a caller giving up does not fail the callers
+// sharing it; ctx only bounds how long this caller waits.
+func (c *Cache) get(ctx context.Context, query, key string, load func(context.Context) (interface{}, error)) (interface{}, error) {
+	key = query + "\x00" + key
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		select {
+		case <-entry.done:
+			if now.After(entry.until) {
+				ok = false
+			}
+		default:
+			// A load is in progress; wait for it below
+		}
+	}
+	if ok {
+		c.mu.Unlock()
+		return c.wait(ctx, query, entry)
+	}
+
+	entry = &cacheEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.sweep(now)
+	c.mu.Unlock()
+	c.observe(query, "miss")
+
+	go func() {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+		defer cancel()
+		value, err := load(loadCtx)
+
+		c.mu.Lock()
+		entry.value, entry.err = value, err
+		entry.until = time.Now().Add(c.ttl)
+		if err != nil && c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+		close(entry.done)
+		c.mu.Unlock()
+	}()
+
+	select {
+	case <-entry.done:
+		return entry.value, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// wait returns a cached entry, waiting for it if it is still loading
+func (c *Cache) wait(ctx context.Context, query string, entry *cacheEntry) (interface{}, error) {
+	select {
+	case <-entry.done:
+		c.observe(query, "hit")
+		return entry.value, entry.err
+	default:
+	}
+
+	c.observe(query, "shared")
+	select {
+	case <-entry.done:
+		return entry.value, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sweep drops expired entries at most once per TTL so that keys which are
+// no longer requested do not accumulate. It must be called with mu held.
+func (c *Cache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for key, entry := range c.entries {
+		select {
+		case <-entry.done:
+			if now.After(entry.until) {
+				delete(c.entries, key)
+			}
+		default:
+		}
+	}
+}
+
+func (c *Cache) observe(query, result string) {
+	if c.metrics != nil {
+		c.metrics.Lookups.WithLabelValues(query, result).Inc()
+	}
+}
+
+// cached returns the cached result of a typed query
+func cached[T any](ctx context.Context, c *Cache, query, key string, load func(context.Context) (T, error)) (T, error) {
+	value, err := c.get(ctx, query, key, func(ctx context.Context) (interface{}, error) {
+		return load(ctx)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return value.(T), nil
+}
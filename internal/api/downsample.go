@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Point is the aggregate of the readings in one downsampling bucket
+type Point struct {
+	// Timestamp is the start of the bucket in Unix milliseconds
+	Timestamp      int64   `json:"ts"`
+	Count          int64   `json:"count"`
+	Temperature    float64 `json:"temperature"`
+	MinTemperature float64 `json:"min_temperature"`
+	MaxTemperature float64 `json:"max_temperature"`
+	Humidity       float64 `json:"humidity"`
+	MinHumidity    float64 `json:"min_humidity"`
+	MaxHumidity    float64 `json:"max_humidity"`
+}
+
+// Series is a sensor's readings downsampled to equal-width buckets.
+// Buckets without readings are omitted.
+type Series struct {
+	SensorID string    `json:"sensor_id"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	// BucketMillis is the width of each bucket
+	BucketMillis int64   `json:"bucket_ms"`
+	Points       []Point `json:"points"`
+}
+
+// DownsampleReadings averages a sensor's readings in [query.From, query.To)
+// into at most points buckets, keeping each bucket's minimum and maximum so
+// that charts still show spikes. The aggregation runs in the database, so
+// the cost does not depend on how many readings the range holds. Open range
+// ends are set to the sensor's first and last reading.
+func (s *Store) DownsampleReadings(ctx context.Context, query Query, points int) (*Series, error) {
+	series := &Series{SensorID: query.SensorID, From: query.From, To: query.To, Points: []Point{}}
+
+	from, to := query.From.UnixMilli(), query.To.UnixMilli()
+	if query.From.IsZero() || query.To.IsZero() {
+		var first, last sql.NullInt64
+		err := s.postgres.ReadDB().QueryRowContext(ctx, `SELECT MIN(ts), MAX(ts) FROM sensor_readings WHERE sensor_id = $1`, query.SensorID).Scan(&first, &last)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query reading range: %w", err)
+		}
+		if !first.Valid {
+			return series, nil
+		}
+		if query.From.IsZero() {
+			from = first.Int64
+			series.From = time.UnixMilli(from)
+		}
+		if query.To.IsZero() {
+			to = last.Int64 + 1
+			series.To = time.UnixMilli(to)
+		}
+	}
+	if from >= to {
+		return series, nil
+	}
+
+	// Round the width up so that the range fits in the requested points
+	series.BucketMillis = (to - from + int64(points) - 1) / int64(points)
+
+	rows, err := s.postgres.ReadDB().QueryContext(ctx, `
+		SELECT $2::BIGINT + (ts - $2::BIGINT) / $4::BIGINT * $4::BIGINT AS bucket, COUNT(*),
+			AVG(temperature), MIN(temperature), MAX(temperature),
+			AVG(humidity), MIN(humidity), MAX(humidity)
+		FROM sensor_readings
+		WHERE sensor_id = $1 AND ts >= $2 AND ts < $3
+		GROUP BY bucket
+		ORDER BY bucket
+	`, query.SensorID, from, to, series.BucketMillis)
+	if err != nil {
+		return nil, fmt.Errorf("failed to downsample readings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p Point
+		if err := rows.Scan(&p.Timestamp, &p.Count,
+			&p.Temperature, &p.MinTemperature, &p.MaxTemperature,
+			&p.Humidity, &p.MinHumidity, &p.MaxHumidity); err != nil {
+			return nil, fmt.Errorf("failed to scan downsampled reading: %w", err)
+		}
+		series.Points = append(series.Points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read downsampled readings: %w", err)
+	}
+	return series, nil
+}
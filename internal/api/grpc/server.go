@@ -0,0 +1,190 @@
+package grpc
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/example/iot-sensor-fleet/internal/api/grpc/alertspb"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics holds Prometheus metrics for live alert subscriptions
+type Metrics struct {
+	Subscribers prometheus.Gauge
+	Sent        prometheus.Counter
+	Dropped     prometheus.Counter
+}
+
+// NewMetrics creates a new set of alert subscription metrics
+func NewMetrics(namespace, subsystem string, registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		Subscribers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "alert_subscribers",
+			Help:      "Number of connected live alert subscribers",
+		}),
+		Sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "alerts_sent_total",
+			Help:      "Total number of alerts sent to subscribers",
+		}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "alerts_dropped_total",
+			Help:      "Total number of alerts skipped for subscribers too slow to keep up",
+		}),
+	}
+
+	registry.MustRegister(metrics.Subscribers, metrics.Sent, metrics.Dropped)
+
+	return metrics
+}
+
+// subscriber is one SubscribeAlerts call with its filter
+type subscriber struct {
+	sensors map[string]bool
+	reason  string
+	alerts  chan *alertspb.Alert
+}
+
+// matches reports whether an alert passes the subscriber's filter
+func (s *subscriber) matches(alert *model.SensorAlert) bool {
+	if len(s.sensors) > 0 && !s.sensors[alert.SensorID] {
+		return false
+	}
+	return s.reason == "" || strings.Contains(strings.ToLower(alert.Reason), s.reason)
+}
+
+// Server fans published alerts out to SubscribeAlerts streams. Each stream
+// has a bounded buffer; alerts that do not fit are skipped for that stream
+// rather than holding up the others.
+type Server struct {
+	alertspb.UnimplementedAlertServiceServer
+
+	bufferSize int
+	metrics    *Metrics
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      chan struct{}
+}
+
+// NewServer creates a new alert server buffering up to bufferSize alerts per
+// stream; metrics may be nil
+func NewServer(bufferSize int, metrics *Metrics) *Server {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	return &Server{
+		bufferSize:  bufferSize,
+		metrics:     metrics,
+		subscribers: make(map[*subscriber]struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// SubscribeAlerts streams matching alerts until the client cancels or the server closes
+func (s *Server) SubscribeAlerts(req *alertspb.SubscribeAlertsRequest, stream alertspb.AlertService_SubscribeAlertsServer) error {
+	sub := &subscriber{
+		reason: strings.ToLower(req.GetReason()),
+		alerts: make(chan *alertspb.Alert, s.bufferSize),
+	}
+	if ids := req.GetSensorIds(); len(ids) > 0 {
+		sub.sensors = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			sub.sensors[id] = true
+		}
+	}
+
+	s.subscribe(sub)
+	defer s.unsubscribe(sub)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.closed:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case alert := <-sub.alerts:
+			if err := stream.Send(alert); err != nil {
+				return err
+			}
+			if s.metrics != nil {
+				s.metrics.Sent.Inc()
+			}
+		}
+	}
+}
+
+// Publish offers an alert to every subscriber whose filter it matches
+func (s *Server) Publish(alert *model.SensorAlert) {
+	var message *alertspb.Alert
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscribers {
+		if !sub.matches(alert) {
+			continue
+		}
+		if message == nil {
+			message = toProto(alert)
+		}
+		select {
+		case sub.alerts <- message:
+		default:
+			if s.metrics != nil {
+				s.metrics.Dropped.Inc()
+			}
+		}
+	}
+}
+
+// Close ends every stream so that the gRPC server can stop gracefully
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+}
+
+func (s *Server) subscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[sub] = struct{}{}
+	if s.metrics != nil {
+		s.metrics.Subscribers.Set(float64(len(s.subscribers)))
+	}
+}
+
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, sub)
+	if s.metrics != nil {
+		s.metrics.Subscribers.Set(float64(len(s.subscribers)))
+	}
+}
+
+// toProto converts an alert to its wire message
+func toProto(alert *model.SensorAlert) *alertspb.Alert {
+	return &alertspb.Alert{
+		SensorId:    alert.SensorID,
+		Ts:          alert.Timestamp,
+		Reason:      alert.Reason,
+		Temperature: alert.Temperature,
+		Humidity:    alert.Humidity,
+		Site:        alert.Site,
+		Rule:        alert.Rule,
+		RunbookUrl:  alert.RunbookURL,
+		Annotations: alert.Annotations,
+	}
+}
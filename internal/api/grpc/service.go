@@ -0,0 +1,78 @@
+package grpc
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/example/iot-sensor-fleet/internal/api/grpc/alertspb"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// Service serves live alerts over gRPC from a tail of the alert topic
+type Service struct {
+	addr   string
+	alerts *Server
+	tail   *Tail
+	server *grpc.Server
+	logger *slog.Logger
+}
+
+// NewService prepares the gRPC server and the alert topic tail.
+// Metrics are registered on registry.
+func NewService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "grpc")
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Kafka security settings: %w", err)
+	}
+
+	alerts := NewServer(cfg.APIGRPCBufferSize, NewMetrics("iot", "api", registry))
+	opts := append([]kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}, security...)
+	tail, err := NewTail(cfg.KafkaBrokers, cfg.Topic(config.TopicKeySensorAlert), alerts.Publish, logger, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	server := grpc.NewServer()
+	alertspb.RegisterAlertServiceServer(server, alerts)
+
+	return &Service{
+		addr:   fmt.Sprintf(":%d", cfg.APIGRPCPort),
+		alerts: alerts,
+		tail:   tail,
+		server: server,
+		logger: logger,
+	}, nil
+}
+
+// Start starts tailing alerts and serving subscriptions
+func (s *Service) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	if err := s.tail.Start(); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		s.logger.Info("Starting live alert gRPC server", "addr", s.addr)
+		if err := s.server.Serve(listener); err != nil {
+			logging.Fatal(s.logger, "Error serving live alerts", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Stop ends the subscriptions, stops the server and stops tailing
+func (s *Service) Stop() {
+	s.alerts.Close()
+	s.server.GracefulStop()
+	s.tail.Stop()
+}
@@ -0,0 +1,109 @@
+package grpc
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Tail reads every partition of the alert topic from its newest offset and
+// publishes the decoded alerts. It uses no consumer group, so every API
+// instance sees every alert.
+type Tail struct {
+	topic    string
+	consumer sarama.Consumer
+	publish  func(*model.SensorAlert)
+	logger   *slog.Logger
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewTail creates a tail of topic calling publish for each alert; logger may
+// be nil
+func NewTail(brokers []string, topic string, publish func(*model.SensorAlert), logger *slog.Logger, opts ...kafka.OptionFunc) (*Tail, error) {
+	saramaConfig := sarama.NewConfig()
+	// Alerts of aborted detector transactions never reach subscribers
+	saramaConfig.Consumer.IsolationLevel = sarama.ReadCommitted
+	for _, opt := range opts {
+		opt(saramaConfig)
+	}
+	consumer, err := sarama.NewConsumer(brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	return &Tail{
+		topic:    topic,
+		consumer: consumer,
+		publish:  publish,
+		logger:   logging.OrDefault(logger).With("topic", topic),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start starts reading the partitions the topic has now
+func (t *Tail) Start() error {
+	partitions, err := t.consumer.Partitions(t.topic)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %w", t.topic, err)
+	}
+
+	for _, partition := range partitions {
+		pc, err := t.consumer.ConsumePartition(t.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			t.Stop()
+			return fmt.Errorf("failed to consume %s/%d: %w", t.topic, partition, err)
+		}
+
+		t.wg.Add(1)
+		go t.read(pc)
+	}
+	t.logger.Info("Tailing alerts for subscribers", "partitions", len(partitions))
+	return nil
+}
+
+// Stop stops reading and closes the consumer
+func (t *Tail) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		t.wg.Wait()
+		if err := t.consumer.Close(); err != nil {
+			t.logger.Error("Failed to close alert consumer", "error", err)
+		}
+	})
+}
+
+// read publishes the alerts of one partition until Stop is called
+func (t *Tail) read(pc sarama.PartitionConsumer) {
+	defer t.wg.Done()
+	defer pc.Close()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case err, ok := <-pc.Errors():
+			if !ok {
+				return
+			}
+			t.logger.Error("Error reading alerts", "error", err)
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				return
+			}
+			alert, err := model.DeserializeSensorAlert(msg.Value)
+			if err != nil {
+				t.logger.Warn("Skipping undecodable alert", "partition", msg.Partition, "offset", msg.Offset, "error", err)
+				continue
+			}
+			t.publish(alert)
+		}
+	}
+}
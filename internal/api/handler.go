@@ -0,0 +1,511 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Page is one page of a list response. NextCursor, and NextOffset unless the
+// page was requested by cursor, are set when more items follow.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextOffset *int   `json:"next_offset,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ndjsonContentType is the media type of streamed responses
+const ndjsonContentType = "application/x-ndjson"
+
+// streamFlushRows is the number of streamed rows between flushes to the client
+const streamFlushRows = 1000
+
+// Cached query names, used as the query label of the cache metrics
+const (
+	queryFleetSummary  = "fleet_summary"
+	queryLatestReading = "latest_reading"
+	queryActiveAlerts  = "active_alerts"
+)
+
+// HandlerConfig configures the API handler
+type HandlerConfig struct {
+	// DefaultPageSize is the page size of lists when the request sets no
+	// limit, which may not exceed MaxPageSize
+	DefaultPageSize int
+	MaxPageSize     int
+	// SummaryWindow is the window the fleet summary covers
+	SummaryWindow time.Duration
+	// ActiveAlertWindow is how long an alert counts as active
+	ActiveAlertWindow time.Duration
+	// ValidateResponses checks responses against the API specification
+	ValidateResponses bool
+	// ArchiveBucket is the bucket archived objects are listed in
+	ArchiveBucket string
+	// Alerts serves GET /api/v1/alerts/stream (optional)
+	Alerts *AlertStream
+	// Logger receives failed queries and response violations (optional)
+	Logger *slog.Logger
+}
+
+// Handler exposes readings and alerts over HTTP. The queries dashboards poll
+// are served through the cache. Requests are validated against the
+// operations the handler describes in its OpenAPI document.
+type Handler struct {
+	store      *Store
+	cache      *Cache
+	config     HandlerConfig
+	validation *ValidationMetrics
+}
+
+// NewHandler creates a new HTTP handler. Validation metrics may be nil.
+func NewHandler(store *Store, cache *Cache, config HandlerConfig, validation *ValidationMetrics) *Handler {
+	maxPageSize, defaultPageSize := config.MaxPageSize, config.DefaultPageSize
+	if maxPageSize <= 0 {
+		maxPageSize = 1000
+	}
+	if defaultPageSize <= 0 || defaultPageSize > maxPageSize {
+		defaultPageSize = maxPageSize
+	}
+	config.MaxPageSize, config.DefaultPageSize = maxPageSize, defaultPageSize
+	config.Logger = logging.OrDefault(config.Logger)
+	return &Handler{
+		store:      store,
+		cache:      cache,
+		config:     config,
+		validation: validation,
+	}
+}
+
+// Register mounts the API routes on a mux, each behind request validation
+func (h *Handler) Register(mux *http.ServeMux) {
+	for _, op := range h.Operations() {
+		mux.HandleFunc(op.Method+" "+op.Path, validate(op, h.config.ValidateResponses, h.validation, h.config.Logger))
+	}
+}
+
+// Operations describes the API routes. It is the single source of the
+// OpenAPI document and of request validation, so a route added here is
+// documented and validated; a route registered anywhere else is neither.
+func (h *Handler) Operations() []Operation {
+	sensorID := Param{Name: "id", In: "path", Type: paramString, Description: "Sensor ID"}
+	from := Param{Name: "from", In: "query", Type: paramString, Format: formatTimestamp,
+		Description: "Start of the range, inclusive, as RFC 3339 or Unix milliseconds"}
+	to := Param{Name: "to", In: "query", Type: paramString, Format: formatTimestamp,
+		Description: "End of the range, exclusive, as RFC 3339 or Unix milliseconds"}
+	limit := Param{Name: "limit", In: "query", Type: paramInteger, Minimum: intPtr(1), Maximum: intPtr(h.config.MaxPageSize),
+		Description: fmt.Sprintf("Page size, %d by default", h.config.DefaultPageSize)}
+	offset := Param{Name: "offset", In: "query", Type: paramInteger, Minimum: intPtr(0),
+		Description: "Number of items to skip; prefer cursor for deep pages"}
+	cursor := Param{Name: "cursor", In: "query", Type: paramString,
+		Description: "next_cursor of the previous page; cannot be combined with offset"}
+
+	badRequest := jsonResponse("Invalid parameters", map[string]string{})
+	failed := jsonResponse("Query failed", map[string]string{})
+
+	operations := []Operation{
+		{
+			Method: http.MethodGet, Path: "/api/v1/fleet/summary", ID: "getFleetSummary",
+			Summary: "Summarize the fleet over the summary window",
+			Responses: map[int]Response{
+				http.StatusOK:                  jsonResponse("Fleet summary", &FleetSummary{}),
+				http.StatusInternalServerError: failed,
+			},
+			handler: h.fleetSummary,
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/sensors/{id}/readings", ID: "listReadings",
+			Summary: "List, stream or downsample a sensor's readings, newest first",
+			Params: []Param{sensorID, from, to, limit, offset, cursor,
+				{Name: "format", In: "query", Type: paramString, Enum: []string{"ndjson"},
+					Description: "Stream every matching reading as NDJSON instead of a page"},
+				{Name: "downsample", In: "query", Type: paramInteger, Minimum: intPtr(1), Maximum: intPtr(h.config.MaxPageSize),
+					Description: "Average the readings into at most this many buckets; cannot be combined with limit, offset or cursor"},
+			},
+			Responses: map[int]Response{
+				http.StatusOK: {
+					Description: "Page of readings, NDJSON stream of readings, or downsampled series",
+					Content: map[string]interface{}{
+						"application/json": Page[*model.SensorReading]{},
+						ndjsonContentType:  &model.SensorReading{},
+					},
+				},
+				http.StatusBadRequest:          badRequest,
+				http.StatusInternalServerError: failed,
+			},
+			handler: h.listReadings,
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/sensors/{id}/readings/latest", ID: "getLatestReading",
+			Summary: "Get a sensor's most recent reading",
+			Params:  []Param{sensorID},
+			Responses: map[int]Response{
+				http.StatusOK:                  jsonResponse("Latest reading", &model.SensorReading{}),
+				http.StatusNotFound:            jsonResponse("Sensor has no readings", map[string]string{}),
+				http.StatusInternalServerError: failed,
+			},
+			handler: h.latestReading,
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/alerts", ID: "listAlerts",
+			Summary: "List alerts, newest first",
+			Params: []Param{
+				{Name: "sensor_id", In: "query", Type: paramString, Description: "Only list alerts of this sensor"},
+				{Name: "bbox", In: "query", Type: paramString, Description: "Only list alerts located in the box min_lon,min_lat,max_lon,max_lat"},
+				{Name: "tag", In: "query", Type: paramString, Description: "Only list alerts of sensors carrying this tag"},
+				from, to, limit, offset, cursor,
+			},
+			Responses: map[int]Response{
+				http.StatusOK:                  jsonResponse("Page of alerts", Page[*model.SensorAlert]{}),
+				http.StatusBadRequest:          badRequest,
+				http.StatusInternalServerError: failed,
+			},
+			handler: h.listAlerts,
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/alerts/active", ID: "listActiveAlerts",
+			Summary: "List the alerts raised within the active alert window",
+			Responses: map[int]Response{
+				http.StatusOK:                  jsonResponse("Active alerts", []*model.SensorAlert{}),
+				http.StatusInternalServerError: failed,
+			},
+			handler: h.activeAlerts,
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/archive/objects", ID: "listArchiveObjects",
+			Summary: "List the cold-storage objects holding readings of a sensor or time range, newest first",
+			Params: []Param{
+				{Name: "sensor_id", In: "query", Type: paramString, Description: "Only list objects with readings of this sensor"},
+				from, to, limit, offset, cursor,
+			},
+			Responses: map[int]Response{
+				http.StatusOK:                  jsonResponse("Page of archived objects", Page[*ArchiveObject]{}),
+				http.StatusBadRequest:          badRequest,
+				http.StatusInternalServerError: failed,
+			},
+			handler: h.listArchiveObjects,
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/openapi.json", ID: "getOpenAPI",
+			Summary: "Get this OpenAPI document",
+			Responses: map[int]Response{
+				http.StatusOK: jsonResponse("OpenAPI 3 document", map[string]interface{}{}),
+			},
+			handler: h.openAPI,
+		},
+		{
+			Method: http.MethodGet, Path: "/healthz", ID: "healthz",
+			Summary: "Report that the API is serving requests",
+			Responses: map[int]Response{
+				http.StatusOK: jsonResponse("Serving", map[string]string{}),
+			},
+			handler: h.healthz,
+		},
+	}
+	if h.config.Alerts != nil {
+		operations = append(operations, Operation{
+			Method: http.MethodGet, Path: "/api/v1/alerts/stream", ID: "streamAlerts",
+			Summary: "Stream alerts as server-sent events as they are stored",
+			Params: []Param{
+				{Name: "sensor_id", In: "query", Type: paramString, Description: "Only stream alerts of this sensor"},
+				{Name: "reason", In: "query", Type: paramString, Description: "Only stream alerts whose reason contains this text, ignoring case"},
+			},
+			Responses: map[int]Response{
+				http.StatusOK: {
+					Description: "Stream of alert events, each with one alert as JSON",
+					Content:     map[string]interface{}{eventStreamContentType: &model.SensorAlert{}},
+				},
+			},
+			handler: h.streamAlerts,
+		})
+	}
+	return operations
+}
+
+// healthz reports that the API is serving requests
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// openAPI returns the OpenAPI document of the API
+func (h *Handler) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Spec(h.Operations()))
+}
+
+// fleetSummary returns the fleet summary over the summary window
+func (h *Handler) fleetSummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := cached(r.Context(), h.cache, queryFleetSummary, "", func(ctx context.Context) (*FleetSummary, error) {
+		return h.store.FleetSummary(ctx, time.Now().Add(-h.config.SummaryWindow))
+	})
+	if err != nil {
+		h.config.Logger.Error("Failed to summarize fleet", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to summarize fleet")
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// latestReading returns the most recent reading of a sensor
+func (h *Handler) latestReading(w http.ResponseWriter, r *http.Request) {
+	sensorID := r.PathValue("id")
+	reading, err := cached(r.Context(), h.cache, queryLatestReading, sensorID, func(ctx context.Context) (*model.SensorReading, error) {
+		return h.store.LatestReading(ctx, sensorID)
+	})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "sensor has no readings")
+	case err != nil:
+		h.config.Logger.Error("Failed to load latest reading", "sensor_id", sensorID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load latest reading")
+	default:
+		writeJSON(w, http.StatusOK, reading)
+	}
+}
+
+// activeAlerts returns the alerts raised within the active alert window,
+// newest first and at most one page
+func (h *Handler) activeAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts, err := cached(r.Context(), h.cache, queryActiveAlerts, "", func(ctx context.Context) ([]*model.SensorAlert, error) {
+		return h.store.ListAlerts(ctx, Query{
+			From:  time.Now().Add(-h.config.ActiveAlertWindow),
+			Limit: h.config.MaxPageSize,
+		})
+	})
+	if err != nil {
+		h.config.Logger.Error("Failed to list active alerts", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list active alerts")
+		return
+	}
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+// listReadings returns a page of a sensor's readings
+func (h *Handler) listReadings(w http.ResponseWriter, r *http.Request) {
+	query, err := h.parseQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.SensorID = r.PathValue("id")
+
+	if values := r.URL.Query(); values.Has("downsample") {
+		h.downsampleReadings(w, r, values, query)
+		return
+	}
+
+	if wantsNDJSON(r) {
+		// Streams are unbounded unless the request sets a limit
+		if !r.URL.Query().Has("limit") {
+			query.Limit = 0
+		}
+		h.streamReadings(w, r, query)
+		return
+	}
+
+	// Fetch one extra row to tell whether another page follows
+	query.Limit++
+	readings, err := h.store.ListReadings(r.Context(), query)
+	query.Limit--
+	if err != nil {
+		h.config.Logger.Error("Failed to list readings", "sensor_id", query.SensorID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list readings")
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(readings, query, readingCursor))
+}
+
+// downsampleReadings returns a sensor's readings averaged into at most
+// downsample buckets. Pages do not apply to downsampled series.
+func (h *Handler) downsampleReadings(w http.ResponseWriter, r *http.Request, values url.Values, query Query) {
+	points, err := strconv.Atoi(values.Get("downsample"))
+	if err != nil || points <= 0 || points > h.config.MaxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("downsample must be between 1 and %d", h.config.MaxPageSize))
+		return
+	}
+	if values.Has("limit") || values.Has("offset") || values.Has("cursor") {
+		writeError(w, http.StatusBadRequest, "downsample cannot be combined with limit, offset or cursor")
+		return
+	}
+
+	series, err := h.store.DownsampleReadings(r.Context(), query, points)
+	if err != nil {
+		h.config.Logger.Error("Failed to downsample readings", "sensor_id", query.SensorID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to downsample readings")
+		return
+	}
+	writeJSON(w, http.StatusOK, series)
+}
+
+// streamReadings writes every reading matching a query as one JSON object per
+// line while it is read from the database, so no page is held in memory. A
+// failure after the first line can only be signalled by ending the stream early.
+func (h *Handler) streamReadings(w http.ResponseWriter, r *http.Request, query Query) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	controller := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+
+	rows := 0
+	err := h.store.ScanReadings(r.Context(), query, func(reading *model.SensorReading) error {
+		if err := encoder.Encode(reading); err != nil {
+			return err
+		}
+		rows++
+		if rows%streamFlushRows == 0 {
+			return controller.Flush()
+		}
+		return nil
+	})
+	switch {
+	case err != nil && rows == 0:
+		h.config.Logger.Error("Failed to stream readings", "sensor_id", query.SensorID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list readings")
+	case err != nil:
+		h.config.Logger.Warn("Stream of readings ended early", "sensor_id", query.SensorID, "rows", rows, "error", err)
+	}
+}
+
+// listAlerts returns a page of alerts, optionally for one sensor, area or tag
+func (h *Handler) listAlerts(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query, err := h.parseQuery(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.SensorID = values.Get("sensor_id")
+	if values.Has("bbox") {
+		if query.Within, err = ParseBoundingBox(values.Get("bbox")); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	query.Tag = strings.ToLower(values.Get("tag"))
+
+	query.Limit++
+	alerts, err := h.store.ListAlerts(r.Context(), query)
+	query.Limit--
+	if err != nil {
+		h.config.Logger.Error("Failed to list alerts", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list alerts")
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(alerts, query, alertCursor))
+}
+
+// listArchiveObjects returns a page of the archive catalog, optionally for
+// one sensor
+func (h *Handler) listArchiveObjects(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query, err := h.parseQuery(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.SensorID = values.Get("sensor_id")
+
+	query.Limit++
+	objects, err := h.store.ListArchiveObjects(r.Context(), query)
+	query.Limit--
+	if err != nil {
+		h.config.Logger.Error("Failed to list archive objects", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list archive objects")
+		return
+	}
+	for _, object := range objects {
+		object.Bucket = h.config.ArchiveBucket
+	}
+	writeJSON(w, http.StatusOK, newPage(objects, query, archiveObjectCursor))
+}
+
+// parseQuery reads the from, to, limit, offset and cursor parameters
+func (h *Handler) parseQuery(values url.Values) (Query, error) {
+	query := Query{Limit: h.config.DefaultPageSize}
+
+	var err error
+	if query.From, err = model.ParseTime(values.Get("from")); err != nil {
+		return query, fmt.Errorf("invalid from: %w", err)
+	}
+	if query.To, err = model.ParseTime(values.Get("to")); err != nil {
+		return query, fmt.Errorf("invalid to: %w", err)
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return query, fmt.Errorf("from must be before to")
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit <= 0 || query.Limit > h.config.MaxPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", h.config.MaxPageSize)
+		}
+	}
+	if offset := values.Get("offset"); offset != "" {
+		query.Offset, err = strconv.Atoi(offset)
+		if err != nil || query.Offset < 0 {
+			return query, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		if query.Offset > 0 {
+			return query, fmt.Errorf("cursor and offset cannot be combined")
+		}
+		if query.After, err = ParseCursor(cursor); err != nil {
+			return query, err
+		}
+	}
+	return query, nil
+}
+
+// newPage trims the extra row fetched beyond the limit and, if it was there,
+// points to the next page from the position of the last item
+func newPage[T any](items []T, query Query, position func(T) Cursor) Page[T] {
+	page := Page[T]{Items: items, Limit: query.Limit, Offset: query.Offset}
+	if len(items) > query.Limit {
+		page.Items = items[:query.Limit]
+		page.NextCursor = position(page.Items[len(page.Items)-1]).String()
+		if query.After == nil {
+			next := query.Offset + query.Limit
+			page.NextOffset = &next
+		}
+	}
+	return page
+}
+
+func readingCursor(reading *model.SensorReading) Cursor {
+	return Cursor{Timestamp: reading.Timestamp, ID: reading.ID}
+}
+
+func alertCursor(alert *model.SensorAlert) Cursor {
+	return Cursor{Timestamp: alert.Timestamp, ID: alert.SensorID}
+}
+
+// wantsNDJSON reports whether a request asks for a streamed response, with
+// format=ndjson or an Accept header of application/x-ndjson
+func wantsNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ndjson" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
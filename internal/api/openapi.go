@@ -0,0 +1,225 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parameter types and formats understood by the request validator
+const (
+	paramString  = "string"
+	paramInteger = "integer"
+	// formatTimestamp is an RFC 3339 time or a Unix timestamp in milliseconds
+	formatTimestamp = "timestamp"
+)
+
+// Param describes a path or query parameter of an operation
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Type        string
+	Format      string
+	Minimum     *int
+	Maximum     *int
+	Enum        []string
+	Required    bool
+}
+
+// Response describes a response of an operation. Content maps each media
+// type the response may have to a value of the type it encodes, from which
+// its schema is derived; responses without content have no body.
+type Response struct {
+	Description string
+	Content     map[string]interface{}
+}
+
+// jsonResponse describes a response encoding body as JSON
+func jsonResponse(description string, body interface{}) Response {
+	return Response{Description: description, Content: map[string]interface{}{"application/json": body}}
+}
+
+// Operation is a route of the API with everything needed to validate its
+// requests and describe it in the OpenAPI document
+type Operation struct {
+	Method    string
+	Path      string
+	ID        string
+	Summary   string
+	Params    []Param
+	Responses map[int]Response
+
+	handler http.HandlerFunc
+}
+
+// Spec builds the OpenAPI 3 document describing operations
+func Spec(operations []Operation) map[string]interface{} {
+	schemas := &schemaSet{components: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+
+	for _, op := range operations {
+		params := make([]interface{}, 0, len(op.Params))
+		for _, p := range op.Params {
+			params = append(params, p.spec())
+		}
+
+		responses := make(map[string]interface{}, len(op.Responses))
+		for status, response := range op.Responses {
+			spec := map[string]interface{}{"description": response.Description}
+			if len(response.Content) > 0 {
+				content := make(map[string]interface{}, len(response.Content))
+				for mediaType, body := range response.Content {
+					content[mediaType] = map[string]interface{}{
+						"schema": schemas.schema(reflect.TypeOf(body)),
+					}
+				}
+				spec["content"] = content
+			}
+			responses[strconv.Itoa(status)] = spec
+		}
+
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"parameters":  params,
+			"responses":   responses,
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "IoT Sensor Fleet API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+		},
+	}
+}
+
+// spec returns the OpenAPI parameter object
+func (p Param) spec() map[string]interface{} {
+	schema := map[string]interface{}{"type": p.Type}
+	if p.Format != "" {
+		schema["format"] = p.Format
+	}
+	if p.Minimum != nil {
+		schema["minimum"] = *p.Minimum
+	}
+	if p.Maximum != nil {
+		schema["maximum"] = *p.Maximum
+	}
+	if len(p.Enum) > 0 {
+		schema["enum"] = p.Enum
+	}
+	return map[string]interface{}{
+		"name":        p.Name,
+		"in":          p.In,
+		"description": p.Description,
+		"required":    p.Required || p.In == "path",
+		"schema":      schema,
+	}
+}
+
+// schemaSet derives JSON schemas from Go types, collecting named structs as
+// components referenced by name
+type schemaSet struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s *schemaSet) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() == reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		name := schemaName(t)
+		if _, ok := s.components[name]; !ok {
+			// Reserve the name first so recursive types terminate
+			s.components[name] = nil
+			s.components[name] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// object derives the schema of a struct from its JSON field tags
+func (s *schemaSet) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// schemaName names the component of a struct; instances of generic types
+// are named after their type argument, e.g. SensorReadingPage
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	base, argument, generic := strings.Cut(name, "[")
+	if !generic {
+		return name
+	}
+	argument = strings.TrimSuffix(argument, "]")
+	argument = argument[strings.LastIndex(argument, ".")+1:]
+	return argument + base
+}
+
+// intPtr returns a pointer to an int, for parameter bounds
+func intPtr(v int) *int {
+	return &v
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Service serves the read-only query API over the PostgreSQL tables, and
+// pushes alerts to event stream clients as they are stored
+type Service struct {
+	postgres *db.PostgresDB
+	alerts   *AlertStream
+	listener *db.AlertListener
+	server   *http.Server
+	logger   *slog.Logger
+}
+
+// NewService connects to PostgreSQL and prepares the API HTTP server.
+// Metrics are registered on registry.
+func NewService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "api")
+	columns, err := encryption.NewColumnsFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load column encryption keys: %w", err)
+	}
+
+	postgres, err := db.NewPostgresDBWithReplicas(cfg, db.NewReplicaMetrics("iot", "api", registry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect API database: %w", err)
+	}
+
+	// Every instance listens, so its clients get every stored alert
+	// whichever service stored it
+	var alerts *AlertStream
+	var listener *db.AlertListener
+	if cfg.APIAlertStream {
+		alerts = NewAlertStream(cfg.APIAlertStreamBufferSize, NewAlertStreamMetrics("iot", "api", registry))
+		listener = db.NewAlertListener(cfg, columns, alerts.Publish, logger)
+	}
+
+	mux := http.NewServeMux()
+	cache := NewCache(cfg.APICacheTTL, cfg.StoreTimeout, NewCacheMetrics("iot", "api", registry))
+	NewHandler(NewStore(postgres, columns), cache, HandlerConfig{
+		DefaultPageSize:   cfg.APIDefaultPageSize,
+		MaxPageSize:       cfg.APIMaxPageSize,
+		SummaryWindow:     cfg.APISummaryWindow,
+		ActiveAlertWindow: cfg.APIActiveAlertWindow,
+		ValidateResponses: cfg.APIValidateResponses,
+		ArchiveBucket:     cfg.MinioBucket,
+		Alerts:            alerts,
+		Logger:            logger,
+	}, NewValidationMetrics("iot", "api", registry)).Register(mux)
+
+	return &Service{
+		postgres: postgres,
+		alerts:   alerts,
+		listener: listener,
+		server: &http.Server{
+			Addr:              fmt.Sprintf(":%d", cfg.APIPort),
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		logger: logger,
+	}, nil
+}
+
+// Start starts listening for stored alerts and serving the API
+func (s *Service) Start() error {
+	if s.listener != nil {
+		if err := s.listener.Start(); err != nil {
+			return err
+		}
+	}
+	go func() {
+		s.logger.Info("Starting query API", "addr", s.server.Addr)
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(s.logger, "Error starting query API", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Stop ends the event streams, gracefully stops the server, stops listening
+// and closes the database connection
+func (s *Service) Stop() {
+	if s.alerts != nil {
+		s.alerts.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.logger.Error("Failed to shut down query API", "error", err)
+	}
+	if s.listener != nil {
+		s.listener.Stop()
+	}
+	if err := s.postgres.Close(); err != nil {
+		s.logger.Error("Failed to close API database", "error", err)
+	}
+}
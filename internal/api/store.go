@@ -0,0 +1,332 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+)
+
+// ErrNotFound is returned when a sensor has no stored readings
+var ErrNotFound = errors.New("not found")
+
+// FleetSummary describes the fleet over a recent window
+type FleetSummary struct {
+	Since          time.Time `json:"since"`
+	Sensors        int64     `json:"sensors"`
+	Readings       int64     `json:"readings"`
+	Alerts         int64     `json:"alerts"`
+	AvgTemperature float64   `json:"avg_temperature"`
+	AvgHumidity    float64   `json:"avg_humidity"`
+}
+
+// Query selects a page of rows; zero times leave the range open
+type Query struct {
+	// SensorID restricts rows to one sensor (empty matches every sensor)
+	SensorID string
+	// From and To bound the reading timestamp to [From, To)
+	From time.Time
+	To   time.Time
+	// Limit and Offset select the page, newest rows first; a zero limit
+	// selects every row
+	Limit  int
+	Offset int
+	// After continues from the last row of the previous page instead of
+	// skipping Offset rows
+	After *Cursor
+	// Within restricts rows to those located inside the box (nil matches
+	// every row, including unlocated ones)
+	Within *BoundingBox
+	// Tag restricts rows to sensors carrying the tag in the registry (empty
+	// matches every sensor)
+	Tag string
+}
+
+// BoundingBox is an area between two longitudes and two latitudes in
+// degrees. A box whose MinLon is greater than its MaxLon crosses the
+// antimeridian.
+type BoundingBox struct {
+	MinLon, MinLat float64
+	MaxLon, MaxLat float64
+}
+
+// ParseBoundingBox parses a box in the GeoJSON order
+// "min_lon,min_lat,max_lon,max_lat"
+func ParseBoundingBox(value string) (*BoundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox must be min_lon,min_lat,max_lon,max_lat")
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		coord, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bbox must be min_lon,min_lat,max_lon,max_lat")
+		}
+		coords[i] = coord
+	}
+
+	box := &BoundingBox{MinLon: coords[0], MinLat: coords[1], MaxLon: coords[2], MaxLat: coords[3]}
+	if box.MinLon < -180 || box.MaxLon > 180 || box.MaxLon < -180 || box.MinLon > 180 {
+		return nil, fmt.Errorf("bbox longitudes must be between -180 and 180")
+	}
+	if box.MinLat < -90 || box.MaxLat > 90 || box.MinLat > box.MaxLat {
+		return nil, fmt.Errorf("bbox latitudes must be between -90 and 90, min first")
+	}
+	return box, nil
+}
+
+// condition returns the SQL condition selecting rows inside the box
+func (b *BoundingBox) condition(args *[]interface{}) string {
+	latitude := fmt.Sprintf("latitude BETWEEN %s AND %s", bind(args, b.MinLat), bind(args, b.MaxLat))
+	minLon, maxLon := bind(args, b.MinLon), bind(args, b.MaxLon)
+	if b.MinLon > b.MaxLon {
+		return fmt.Sprintf("%s AND (longitude >= %s OR longitude <= %s)", latitude, minLon, maxLon)
+	}
+	return fmt.Sprintf("%s AND longitude BETWEEN %s AND %s", latitude, minLon, maxLon)
+}
+
+// Cursor is the position of a row in the newest-first order of its table,
+// which sorts rows by timestamp and then sensor ID
+type Cursor struct {
+	Timestamp int64
+	ID        string
+}
+
+// String encodes the cursor as an opaque token
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Timestamp, 10) + ":" + c.ID))
+}
+
+// ParseCursor decodes a token returned by Cursor.String
+func ParseCursor(token string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &Cursor{Timestamp: timestamp, ID: id}, nil
+}
+
+// Store reads readings and alerts from the PostgreSQL tables, decrypting
+// their encrypted columns. Queries go to a read replica when one is healthy.
+type Store struct {
+	postgres *db.PostgresDB
+	columns  *encryption.Columns
+}
+
+// NewStore creates a new store; columns may be nil when no column keys are
+// configured
+func NewStore(postgres *db.PostgresDB, columns *encryption.Columns) *Store {
+	return &Store{postgres: postgres, columns: columns}
+}
+
+// ListReadings returns the readings matching a query, newest first
+func (s *Store) ListReadings(ctx context.Context, query Query) ([]*model.SensorReading, error) {
+	readings := []*model.SensorReading{}
+	err := s.ScanReadings(ctx, query, func(reading *model.SensorReading) error {
+		readings = append(readings, reading)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// ScanReadings calls fn for every reading matching a query, newest first,
+// as rows arrive from the database. A zero limit scans every reading.
+func (s *Store) ScanReadings(ctx context.Context, query Query, fn func(*model.SensorReading) error) error {
+	statement, args := query.statement(`
+		SELECT sensor_id, ts, temperature, humidity, COALESCE(site, ''), COALESCE(zone, ''), latitude, longitude,
+			COALESCE(location_enc, '')
+		FROM sensor_readings`, "sensor_id")
+	rows, err := s.postgres.ReadDB().QueryContext(ctx, statement, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query readings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reading model.SensorReading
+		var latitude, longitude sql.NullFloat64
+		var location string
+		if err := rows.Scan(&reading.ID, &reading.Timestamp, &reading.Temperature, &reading.Humidity, &reading.Site,
+			&reading.Zone, &latitude, &longitude, &location); err != nil {
+			return fmt.Errorf("failed to scan reading: %w", err)
+		}
+		if err := s.open(&reading.Site, &reading.Zone, &reading.Location, latitude, longitude, location); err != nil {
+			return fmt.Errorf("failed to decrypt reading: %w", err)
+		}
+		if err := fn(&reading); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read readings: %w", err)
+	}
+	return nil
+}
+
+// LatestReading returns the most recent reading of a sensor
+func (s *Store) LatestReading(ctx context.Context, sensorID string) (*model.SensorReading, error) {
+	var reading model.SensorReading
+	var latitude, longitude sql.NullFloat64
+	var location string
+	err := s.postgres.ReadDB().QueryRowContext(ctx, `
+		SELECT sensor_id, ts, temperature, humidity, COALESCE(site, ''), COALESCE(zone, ''), latitude, longitude,
+			COALESCE(location_enc, '')
+		FROM sensor_readings
+		WHERE sensor_id = $1
+		ORDER BY ts DESC
+		LIMIT 1
+	`, sensorID).Scan(&reading.ID, &reading.Timestamp, &reading.Temperature, &reading.Humidity, &reading.Site,
+		&reading.Zone, &latitude, &longitude, &location)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest reading: %w", err)
+	}
+	if err := s.open(&reading.Site, &reading.Zone, &reading.Location, latitude, longitude, location); err != nil {
+		return nil, fmt.Errorf("failed to decrypt reading: %w", err)
+	}
+	return &reading, nil
+}
+
+// FleetSummary counts the sensors, readings and alerts since a time and
+// averages the readings
+func (s *Store) FleetSummary(ctx context.Context, since time.Time) (*FleetSummary, error) {
+	summary := &FleetSummary{Since: since}
+	err := s.postgres.ReadDB().QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT sensor_id), COUNT(*), COALESCE(AVG(temperature), 0), COALESCE(AVG(humidity), 0)
+		FROM sensor_readings
+		WHERE ts >= $1
+	`, since.UnixMilli()).Scan(&summary.Sensors, &summary.Readings, &summary.AvgTemperature, &summary.AvgHumidity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize readings: %w", err)
+	}
+
+	err = s.postgres.ReadDB().QueryRowContext(ctx, `SELECT COUNT(*) FROM sensor_alerts WHERE ts >= $1`, since.UnixMilli()).Scan(&summary.Alerts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count alerts: %w", err)
+	}
+	return summary, nil
+}
+
+// ListAlerts returns the alerts matching a query, newest first
+func (s *Store) ListAlerts(ctx context.Context, query Query) ([]*model.SensorAlert, error) {
+	statement, args := query.statement(`
+		SELECT sensor_id, ts, reason, temperature, humidity, COALESCE(site, ''), COALESCE(zone, ''), latitude, longitude,
+			COALESCE(location_enc, '')
+		FROM sensor_alerts`, "sensor_id")
+	rows, err := s.postgres.ReadDB().QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []*model.SensorAlert{}
+	for rows.Next() {
+		var alert model.SensorAlert
+		var latitude, longitude sql.NullFloat64
+		var sealed string
+		if err := rows.Scan(&alert.SensorID, &alert.Timestamp, &alert.Reason, &alert.Temperature, &alert.Humidity,
+			&alert.Site, &alert.Zone, &latitude, &longitude, &sealed); err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		if err := s.open(&alert.Site, &alert.Zone, &alert.Location, latitude, longitude, sealed); err != nil {
+			return nil, fmt.Errorf("failed to decrypt alert: %w", err)
+		}
+		alerts = append(alerts, &alert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// statement completes a SELECT with the query's filters, order and page.
+// Rows are ordered newest first by ts and then sensorColumn, which holds the
+// sensor ID that SensorID, After and Tag match.
+func (q Query) statement(selectFrom, sensorColumn string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if q.SensorID != "" {
+		conditions = append(conditions, sensorColumn+" = "+bind(&args, q.SensorID))
+	}
+	if !q.From.IsZero() {
+		conditions = append(conditions, "ts >= "+bind(&args, q.From.UnixMilli()))
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "ts < "+bind(&args, q.To.UnixMilli()))
+	}
+	if q.After != nil {
+		ts, id := bind(&args, q.After.Timestamp), bind(&args, q.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(ts < %s OR (ts = %s AND %s > %s))", ts, ts, sensorColumn, id))
+	}
+	if q.Within != nil {
+		conditions = append(conditions, q.Within.condition(&args))
+	}
+	if q.Tag != "" {
+		conditions = append(conditions, sensorColumn+" IN (SELECT id FROM sensors WHERE tags @> ARRAY["+bind(&args, q.Tag)+"::TEXT])")
+	}
+
+	statement := selectFrom
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	statement += " ORDER BY ts DESC, " + sensorColumn
+	if q.Limit > 0 {
+		statement += " LIMIT " + bind(&args, q.Limit)
+	}
+	if q.Offset > 0 {
+		statement += " OFFSET " + bind(&args, q.Offset)
+	}
+	return statement, args
+}
+
+// bind appends a bind parameter and returns its placeholder
+func bind(args *[]interface{}, value interface{}) string {
+	*args = append(*args, value)
+	return fmt.Sprintf("$%d", len(*args))
+}
+
+// open decrypts the site and zone of a row in place and sets its location
+// from location_enc when encrypted, or else from its latitude and longitude
+func (s *Store) open(site, zone *string, location **model.GeoPoint, latitude, longitude sql.NullFloat64, sealed string) error {
+	var err error
+	if *site, err = s.columns.Open(encryption.ColumnSite, *site); err != nil {
+		return err
+	}
+	if *zone, err = s.columns.Open(encryption.ColumnZone, *zone); err != nil {
+		return err
+	}
+	if sealed != "" {
+		*location, err = s.columns.OpenLocation(sealed)
+		return err
+	}
+	*location = geoPoint(latitude, longitude)
+	return nil
+}
+
+// geoPoint returns the location stored in a row's latitude and longitude, or
+// nil if the row has none
+func geoPoint(latitude, longitude sql.NullFloat64) *model.GeoPoint {
+	if !latitude.Valid || !longitude.Valid {
+		return nil
+	}
+	return &model.GeoPoint{Lat: latitude.Float64, Lon: longitude.Float64}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventStreamContentType is the media type of server-sent event responses
+const eventStreamContentType = "text/event-stream"
+
+// streamKeepAlive is how often an idle event stream gets a comment, so
+// proxies do not close it
+const streamKeepAlive = 15 * time.Second
+
+// AlertStreamMetrics holds Prometheus metrics for the live alert event stream
+type AlertStreamMetrics struct {
+	Clients prometheus.Gauge
+	Sent    prometheus.Counter
+	Dropped prometheus.Counter
+}
+
+// NewAlertStreamMetrics creates a new set of alert event stream metrics
+func NewAlertStreamMetrics(namespace, subsystem string, registry prometheus.Registerer) *AlertStreamMetrics {
+	metrics := &AlertStreamMetrics{
+		Clients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "alert_stream_clients",
+			Help:      "Number of clients connected to the alert event stream",
+		}),
+		Sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "alert_stream_events_total",
+			Help:      "Total number of alerts sent to event stream clients",
+		}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "alert_stream_dropped_total",
+			Help:      "Total number of alerts skipped for event stream clients too slow to keep up",
+		}),
+	}
+
+	registry.MustRegister(metrics.Clients, metrics.Sent, metrics.Dropped)
+
+	return metrics
+}
+
+// streamClient is one connected event stream with its filter
+type streamClient struct {
+	sensorID string
+	reason   string
+	alerts   chan *model.SensorAlert
+}
+
+// matches reports whether an alert passes the client's filter
+func (c *streamClient) matches(alert *model.SensorAlert) bool {
+	if c.sensorID != "" && alert.SensorID != c.sensorID {
+		return false
+	}
+	return c.reason == "" || strings.Contains(strings.ToLower(alert.Reason), c.reason)
+}
+
+// AlertStream fans published alerts out to connected event stream clients.
+// Each client has a bounded buffer; alerts that do not fit are skipped for
+// that client rather than holding up the others.
+type AlertStream struct {
+	bufferSize int
+	metrics    *AlertStreamMetrics
+
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+	closed  chan struct{}
+}
+
+// NewAlertStream creates a new alert stream buffering up to bufferSize alerts
+// per client; metrics may be nil
+func NewAlertStream(bufferSize int, metrics *AlertStreamMetrics) *AlertStream {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	return &AlertStream{
+		bufferSize: bufferSize,
+		metrics:    metrics,
+		clients:    make(map[*streamClient]struct{}),
+		closed:     make(chan struct{}),
+	}
+}
+
+// Publish offers an alert to every client whose filter it matches
+func (s *AlertStream) Publish(alert *model.SensorAlert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		if !client.matches(alert) {
+			continue
+		}
+		select {
+		case client.alerts <- alert:
+		default:
+			if s.metrics != nil {
+				s.metrics.Dropped.Inc()
+			}
+		}
+	}
+}
+
+// Close ends every event stream so that the HTTP server can shut down
+func (s *AlertStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+}
+
+func (s *AlertStream) subscribe(client *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[client] = struct{}{}
+	if s.metrics != nil {
+		s.metrics.Clients.Set(float64(len(s.clients)))
+	}
+}
+
+func (s *AlertStream) unsubscribe(client *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, client)
+	if s.metrics != nil {
+		s.metrics.Clients.Set(float64(len(s.clients)))
+	}
+}
+
+// streamAlerts sends matching alerts as server-sent events, one alert event
+// with a JSON alert per insert into sensor_alerts, until the client
+// disconnects or the API stops
+func (h *Handler) streamAlerts(w http.ResponseWriter, r *http.Request) {
+	stream := h.config.Alerts
+	values := r.URL.Query()
+	client := &streamClient{
+		sensorID: values.Get("sensor_id"),
+		reason:   strings.ToLower(values.Get("reason")),
+		alerts:   make(chan *model.SensorAlert, stream.bufferSize),
+	}
+	stream.subscribe(client)
+	defer stream.unsubscribe(client)
+
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stream.closed:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case alert := <-client.alerts:
+			data, err := json.Marshal(alert)
+			if err != nil {
+				h.config.Logger.Warn("Failed to encode streamed alert", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: alert\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			if stream.metrics != nil {
+				stream.metrics.Sent.Inc()
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
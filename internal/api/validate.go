@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ValidationMetrics holds Prometheus metrics for request and response validation
+type ValidationMetrics struct {
+	RejectedRequests   *prometheus.CounterVec
+	ResponseViolations *prometheus.CounterVec
+}
+
+// NewValidationMetrics creates a new set of validation metrics
+func NewValidationMetrics(namespace, subsystem string, registry prometheus.Registerer) *ValidationMetrics {
+	metrics := &ValidationMetrics{
+		RejectedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_rejected_total",
+			Help:      "Total number of requests rejected for not matching the API specification, by operation",
+		}, []string{"operation"}),
+		ResponseViolations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "response_violations_total",
+			Help:      "Total number of responses with an undocumented status or content type, by operation",
+		}, []string{"operation"}),
+	}
+
+	registry.MustRegister(metrics.RejectedRequests, metrics.ResponseViolations)
+
+	return metrics
+}
+
+// validate wraps an operation's handler so that requests whose parameters do
+// not match its specification are rejected with 400 before reaching it. When
+// checkResponses is set, responses with a status or content type the
+// operation does not document are logged and counted; they are still sent.
+func validate(op Operation, checkResponses bool, metrics *ValidationMetrics, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := op.validateRequest(r); err != nil {
+			if metrics != nil {
+				metrics.RejectedRequests.WithLabelValues(op.ID).Inc()
+			}
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !checkResponses {
+			op.handler(w, r)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		op.handler(recorder, r)
+		if err := op.validateResponse(recorder.status, recorder.Header().Get("Content-Type")); err != nil {
+			logger.Warn("Response does not match the API specification", "method", r.Method, "path", r.URL.Path, "error", err)
+			if metrics != nil {
+				metrics.ResponseViolations.WithLabelValues(op.ID).Inc()
+			}
+		}
+	}
+}
+
+// validateRequest checks the request's parameters against the operation
+func (op Operation) validateRequest(r *http.Request) error {
+	query := r.URL.Query()
+	for _, p := range op.Params {
+		var value string
+		var present bool
+		if p.In == "path" {
+			value = r.PathValue(p.Name)
+			present = value != ""
+		} else {
+			value = query.Get(p.Name)
+			present = query.Has(p.Name)
+		}
+
+		if !present {
+			if p.Required || p.In == "path" {
+				return fmt.Errorf("missing %s", p.Name)
+			}
+			continue
+		}
+		if err := p.validate(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a parameter value against the parameter's schema
+func (p Param) validate(value string) error {
+	if p.Format == formatTimestamp {
+		_, err := model.ParseTime(value)
+		return err
+	}
+	if len(p.Enum) > 0 && !slices.Contains(p.Enum, value) {
+		return fmt.Errorf("must be one of %v", p.Enum)
+	}
+	if p.Type != paramInteger {
+		return nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("must be an integer")
+	}
+	if p.Minimum != nil && n < *p.Minimum {
+		return fmt.Errorf("must be at least %d", *p.Minimum)
+	}
+	if p.Maximum != nil && n > *p.Maximum {
+		return fmt.Errorf("must be at most %d", *p.Maximum)
+	}
+	return nil
+}
+
+// validateResponse checks a response status and content type against the operation
+func (op Operation) validateResponse(status int, contentType string) error {
+	response, ok := op.Responses[status]
+	if !ok {
+		return fmt.Errorf("undocumented status %d", status)
+	}
+	if len(response.Content) == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if _, ok := response.Content[mediaType]; !ok {
+		return fmt.Errorf("status %d has undocumented content type %q", status, contentType)
+	}
+	return nil
+}
+
+// responseRecorder remembers the status of a response as it is written
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streamed responses can still be flushed
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,221 @@
+package bus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Well-known bus topics shared by in-process components
+const (
+	TopicReadings    = "readings"
+	TopicAlerts      = "alerts"
+	TopicFleetAlerts = "fleet-alerts"
+
+	TopicSiteAggregates = "site-aggregates"
+	TopicSiteAlerts     = "site-alerts"
+	TopicIncidents      = "incidents"
+)
+
+// DefaultBufferSize is the per-subscriber channel capacity used when none is given
+const DefaultBufferSize = 256
+
+// Event is a message delivered on the bus
+type Event struct {
+	Topic     string
+	Key       string
+	Payload   interface{}
+	Timestamp time.Time
+}
+
+// Metrics holds Prometheus metrics for the bus
+type Metrics struct {
+	Published   *prometheus.CounterVec
+	Delivered   *prometheus.CounterVec
+	Dropped     *prometheus.CounterVec
+	Subscribers *prometheus.GaugeVec
+}
+
+// NewMetrics creates a new set of bus metrics
+func NewMetrics(namespace, subsystem string, registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		Published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "events_published_total",
+			Help:      "Total number of events published",
+		}, []string{"topic"}),
+		Delivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "events_delivered_total",
+			Help:      "Total number of events delivered to subscribers",
+		}, []string{"topic"}),
+		Dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "events_dropped_total",
+			Help:      "Total number of events dropped because a subscriber was full",
+		}, []string{"topic"}),
+		Subscribers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "subscribers",
+			Help:      "Number of active subscribers",
+		}, []string{"topic"}),
+	}
+
+	registry.MustRegister(
+		metrics.Published,
+		metrics.Delivered,
+		metrics.Dropped,
+		metrics.Subscribers,
+	)
+
+	return metrics
+}
+
+// Bus is a lightweight in-process publish/subscribe hub.
+// Publishing never blocks: events are dropped for subscribers whose buffer is full.
+type Bus struct {
+	mu      sync.RWMutex
+	subs    map[string]map[*Subscription]struct{}
+	metrics *Metrics
+	closed  bool
+}
+
+// Subscription receives events for a single topic
+type Subscription struct {
+	topic string
+	ch    chan Event
+	bus   *Bus
+	once  sync.Once
+}
+
+// New creates a new bus; metrics may be nil
+func New(metrics *Metrics) *Bus {
+	return &Bus{
+		subs:    make(map[string]map[*Subscription]struct{}),
+		metrics: metrics,
+	}
+}
+
+// Subscribe registers a subscriber for a topic with the given buffer size
+func (b *Bus) Subscribe(topic string, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBufferSize
+	}
+
+	sub := &Subscription{
+		topic: topic,
+		ch:    make(chan Event, buffer),
+		bus:   b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(sub.ch)
+		return sub
+	}
+
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[*Subscription]struct{})
+	}
+	b.subs[topic][sub] = struct{}{}
+
+	if b.metrics != nil {
+		b.metrics.Subscribers.WithLabelValues(topic).Set(float64(len(b.subs[topic])))
+	}
+
+	return sub
+}
+
+// Publish delivers an event to every subscriber of the topic and returns
+// the number of subscribers that received it
+func (b *Bus) Publish(topic, key string, payload interface{}) int {
+	event := Event{
+		Topic:     topic,
+		Key:       key,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return 0
+	}
+
+	if b.metrics != nil {
+		b.metrics.Published.WithLabelValues(topic).Inc()
+	}
+
+	delivered := 0
+	for sub := range b.subs[topic] {
+		select {
+		case sub.ch <- event:
+			delivered++
+		default:
+			if b.metrics != nil {
+				b.metrics.Dropped.WithLabelValues(topic).Inc()
+			}
+		}
+	}
+
+	if b.metrics != nil && delivered > 0 {
+		b.metrics.Delivered.WithLabelValues(topic).Add(float64(delivered))
+	}
+
+	return delivered
+}
+
+// Close closes every subscription; subsequent publishes are ignored
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+
+	for topic, subs := range b.subs {
+		for sub := range subs {
+			sub.once.Do(func() { close(sub.ch) })
+		}
+		delete(b.subs, topic)
+		if b.metrics != nil {
+			b.metrics.Subscribers.WithLabelValues(topic).Set(0)
+		}
+	}
+}
+
+// C returns the channel events are delivered on; it is closed on unsubscribe
+func (s *Subscription) C() <-chan Event {
+	return s.ch
+}
+
+// Topic returns the subscribed topic
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// Unsubscribe removes the subscription and closes its channel
+func (s *Subscription) Unsubscribe() {
+	b := s.bus
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if subs, ok := b.subs[s.topic]; ok {
+		delete(subs, s)
+		if b.metrics != nil {
+			b.metrics.Subscribers.WithLabelValues(s.topic).Set(float64(len(subs)))
+		}
+	}
+	s.once.Do(func() { close(s.ch) })
+}
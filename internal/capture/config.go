@@ -0,0 +1,67 @@
+package capture
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewSamplerFromConfig creates a sampler from the capture settings, or returns
+// nil when capture is disabled. Metrics are registered on registry.
+func NewSamplerFromConfig(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Sampler, error) {
+	if cfg.CaptureRate <= 0 {
+		return nil, nil
+	}
+	if cfg.CaptureRate > 1 {
+		return nil, fmt.Errorf("capture rate must be at most 1, got %v", cfg.CaptureRate)
+	}
+
+	redactor, err := NewRedactor(cfg.CaptureRedactSalt, strings.Split(cfg.CaptureRedactFields, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	var destination Destination
+	switch cfg.CaptureDestination {
+	case DestinationTopic:
+		producer, err := kafka.NewProducer(kafka.ProducerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			Topic:           cfg.Topic(config.TopicKeyCapture),
+			RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+			Idempotent:      cfg.ProducerIdempotent,
+			ReturnSuccesses: cfg.ProducerReturnSuccess,
+			ReturnErrors:    cfg.ProducerReturnErrors,
+			Metrics:         kafka.NewProducerMetrics("iot", "capture_producer", registry),
+			Version:         cfg.KafkaVersion,
+			SendTimeout:     cfg.ProducerSendTimeout,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentCapture),
+			Logger:          logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create capture producer: %w", err)
+		}
+		destination = NewTopicDestination(producer)
+	case DestinationMinio:
+		store, err := storage.NewS3StoreFromConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		destination = NewObjectDestination(store, cfg.CapturePrefix)
+	default:
+		return nil, fmt.Errorf("unknown capture destination %q (expected %s or %s)",
+			cfg.CaptureDestination, DestinationTopic, DestinationMinio)
+	}
+
+	return NewSampler(Config{
+		Rate:           cfg.CaptureRate,
+		UndecodableRaw: cfg.CaptureUndecodableRaw,
+		Logger:         logger,
+	}, redactor, destination, NewMetrics("iot", "capture", registry)), nil
+}
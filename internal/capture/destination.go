@@ -0,0 +1,73 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+)
+
+// Capture destinations
+const (
+	DestinationTopic = "topic"
+	DestinationMinio = "minio"
+)
+
+// TopicDestination writes captures as JSON to a Kafka topic, keyed by the
+// pseudonymized message key
+type TopicDestination struct {
+	producer *kafka.Producer
+}
+
+// NewTopicDestination creates a destination writing to producer's topic
+func NewTopicDestination(producer *kafka.Producer) *TopicDestination {
+	return &TopicDestination{producer: producer}
+}
+
+// Write sends one capture
+func (d *TopicDestination) Write(ctx context.Context, sample *Sample) error {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capture: %w", err)
+	}
+	return d.producer.SendMessageWithKey(ctx, sample.Key, data)
+}
+
+// Close closes the producer
+func (d *TopicDestination) Close() error {
+	return d.producer.Close()
+}
+
+// ObjectDestination writes each capture as a JSON object under a prefix,
+// partitioned by capture date: <prefix>/YYYY/MM/DD/<topic>-<partition>-<offset>.json
+type ObjectDestination struct {
+	store  storage.ObjectStore
+	prefix string
+}
+
+// NewObjectDestination creates a destination writing objects under prefix
+func NewObjectDestination(store storage.ObjectStore, prefix string) *ObjectDestination {
+	return &ObjectDestination{store: store, prefix: prefix}
+}
+
+// Write uploads one capture
+func (d *ObjectDestination) Write(ctx context.Context, sample *Sample) error {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capture: %w", err)
+	}
+
+	capturedAt := time.UnixMilli(sample.CapturedAt).UTC()
+	key := path.Join(d.prefix, capturedAt.Format("2006/01/02"),
+		fmt.Sprintf("%s-%d-%d.json", sample.Topic, sample.Partition, sample.Offset))
+	return d.store.Put(ctx, key, data, map[string]string{"Content-Type": "application/json"})
+}
+
+// Close is a no-op; the object store holds no connections
+func (d *ObjectDestination) Close() error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// pseudonymPrefix marks values replaced by the redactor
+const pseudonymPrefix = "redacted:"
+
+// DefaultRedactFields are the payload fields identifying a device or its location
+var DefaultRedactFields = []string{"id", "sensor_id", "site"}
+
+// Redactor replaces identifying values with keyed pseudonyms. The same value
+// always maps to the same pseudonym for a given salt, so captured messages can
+// still be correlated with each other without revealing the original value.
+type Redactor struct {
+	key    []byte
+	fields map[string]bool
+}
+
+// NewRedactor creates a redactor for the given JSON field names (case-insensitive).
+// An empty salt uses a random key, making pseudonyms stable only within the process.
+func NewRedactor(salt string, fields []string) (*Redactor, error) {
+	key := []byte(salt)
+	if salt == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate redaction key: %w", err)
+		}
+	}
+
+	r := &Redactor{key: key, fields: make(map[string]bool, len(fields))}
+	for _, field := range fields {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			r.fields[field] = true
+		}
+	}
+	return r, nil
+}
+
+// Pseudonym returns the pseudonym of a value; empty values stay empty
+func (r *Redactor) Pseudonym(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Reading returns a copy of a reading with its sensor ID and site pseudonymized
+func (r *Redactor) Reading(reading *model.SensorReading) *model.SensorReading {
+	redacted := *reading
+	redacted.ID = r.Pseudonym(reading.ID)
+	redacted.Site = r.Pseudonym(reading.Site)
+	return &redacted
+}
+
+// Payload returns a redacted copy of a raw payload in its original wire format,
+// or false when the payload cannot be redacted. Decoded binary payloads are
+// re-encoded from the redacted reading; JSON payloads keep their structure,
+// including unknown fields, with the configured fields pseudonymized.
+func (r *Redactor) Payload(format string, data []byte, reading *model.SensorReading) ([]byte, bool) {
+	switch {
+	case reading != nil && format == model.FormatAvro:
+		encoded, err := model.SerializeSensorReadingAvro(r.Reading(reading))
+		return encoded, err == nil
+	case reading != nil && format == model.FormatConfluent:
+		encoded, err := model.SerializeSensorReadingAvro(r.Reading(reading))
+		if err != nil || len(data) < 5 {
+			return nil, false
+		}
+		return append(append([]byte{}, data[:5]...), encoded...), true
+	default:
+		// JSON readings, and undecodable payloads that are still valid JSON
+		return r.JSON(data)
+	}
+}
+
+// JSON pseudonymizes the configured fields anywhere in a JSON document.
+// It returns false when data is not valid JSON.
+func (r *Redactor) JSON(data []byte) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, false
+	}
+
+	redacted, err := json.Marshal(r.walk(doc))
+	if err != nil {
+		return nil, false
+	}
+	return redacted, true
+}
+
+func (r *Redactor) walk(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if r.fields[strings.ToLower(key)] {
+				if field != nil {
+					v[key] = r.Pseudonym(fmt.Sprint(field))
+				}
+				continue
+			}
+			v[key] = r.walk(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.walk(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,252 @@
+package capture
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Capture kinds
+const (
+	KindDecoded     = "decoded"
+	KindUndecodable = "undecodable"
+)
+
+// defaultQueueSize bounds the captures waiting to be written
+const defaultQueueSize = 256
+
+// writeTimeout bounds writing one capture to its destination
+const writeTimeout = 10 * time.Second
+
+// keptHeaders are the only message headers copied into a capture
+var keptHeaders = map[string]bool{
+	kafka.HeaderSentAt: true,
+	kafka.HeaderHop:    true,
+}
+
+// Sample is a captured message with identifying values redacted
+type Sample struct {
+	Kind       string              `json:"kind"`
+	CapturedAt int64               `json:"captured_at"`
+	Topic      string              `json:"topic"`
+	Partition  int32               `json:"partition"`
+	Offset     int64               `json:"offset"`
+	Timestamp  int64               `json:"timestamp"`
+	Key        string              `json:"key,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Format     string              `json:"format,omitempty"`
+	Error      string              `json:"error,omitempty"`
+
+	// Raw is the redacted payload in its original wire format; it is omitted
+	// when the payload could not be redacted
+	Raw     []byte               `json:"raw,omitempty"`
+	RawSize int                  `json:"raw_size"`
+	Decoded *model.SensorReading `json:"decoded,omitempty"`
+}
+
+// Destination stores captured samples
+type Destination interface {
+	Write(ctx context.Context, sample *Sample) error
+	Close() error
+}
+
+// Config configures a sampler
+type Config struct {
+	// Rate is the fraction of messages captured, e.g. 0.001 for 0.1%
+	Rate float64
+	// UndecodableRaw keeps undecodable payloads that cannot be redacted verbatim.
+	// They may contain identifying values, so this is off by default.
+	UndecodableRaw bool
+	// QueueSize bounds the captures waiting to be written (0 uses the default)
+	QueueSize int
+	// Logger receives the sampler's logs (nil uses the default logger)
+	Logger *slog.Logger
+}
+
+// Metrics holds Prometheus metrics for the sampler
+type Metrics struct {
+	Captured *prometheus.CounterVec
+	Dropped  prometheus.Counter
+	Errors   prometheus.Counter
+}
+
+// NewMetrics creates a new set of capture metrics
+func NewMetrics(namespace, subsystem string, registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		Captured: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "captured_total",
+			Help:      "Total number of messages captured by kind",
+		}, []string{"kind"}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dropped_total",
+			Help:      "Total number of sampled messages dropped because the capture queue was full",
+		}),
+		Errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Total number of captures that failed to be written",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Captured,
+		metrics.Dropped,
+		metrics.Errors,
+	)
+
+	return metrics
+}
+
+// Sampler copies a random fraction of consumed messages, raw and decoded, to a
+// destination for offline debugging. Captures are written in the background so
+// message handling never waits on the destination; when the queue is full the
+// capture is dropped.
+type Sampler struct {
+	config      Config
+	redactor    *Redactor
+	destination Destination
+	metrics     *Metrics
+	queue       chan *Sample
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// NewSampler creates a new sampler; metrics may be nil
+func NewSampler(config Config, redactor *Redactor, destination Destination, metrics *Metrics) *Sampler {
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	config.Logger = logging.OrDefault(config.Logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Sampler{
+		config:      config,
+		redactor:    redactor,
+		destination: destination,
+		metrics:     metrics,
+		queue:       make(chan *Sample, config.QueueSize),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// Start starts writing captures
+func (s *Sampler) Start() {
+	go s.run()
+}
+
+// Stop writes the queued captures and closes the destination
+func (s *Sampler) Stop() {
+	s.cancel()
+	<-s.done
+	if err := s.destination.Close(); err != nil {
+		s.config.Logger.Error("Failed to close capture destination", "error", err)
+	}
+}
+
+// Capture samples a consumed message. reading and format are the decoding
+// result, or nil and empty with decodeErr set when decoding failed.
+func (s *Sampler) Capture(message *sarama.ConsumerMessage, reading *model.SensorReading, format string, decodeErr error) {
+	if rand.Float64() >= s.config.Rate {
+		return
+	}
+
+	sample := s.sample(message, reading, format, decodeErr)
+	select {
+	case s.queue <- sample:
+	default:
+		if s.metrics != nil {
+			s.metrics.Dropped.Inc()
+		}
+	}
+}
+
+// sample builds the redacted capture of a message
+func (s *Sampler) sample(message *sarama.ConsumerMessage, reading *model.SensorReading, format string, decodeErr error) *Sample {
+	sample := &Sample{
+		Kind:       KindDecoded,
+		CapturedAt: time.Now().UnixMilli(),
+		Topic:      message.Topic,
+		Partition:  message.Partition,
+		Offset:     message.Offset,
+		Timestamp:  message.Timestamp.UnixMilli(),
+		Key:        s.redactor.Pseudonym(string(message.Key)),
+		Format:     format,
+		RawSize:    len(message.Value),
+	}
+	if decodeErr != nil {
+		sample.Kind = KindUndecodable
+		sample.Error = decodeErr.Error()
+	}
+
+	for _, header := range message.Headers {
+		if header != nil && keptHeaders[string(header.Key)] {
+			if sample.Headers == nil {
+				sample.Headers = make(map[string][]string)
+			}
+			sample.Headers[string(header.Key)] = append(sample.Headers[string(header.Key)], string(header.Value))
+		}
+	}
+
+	if reading != nil {
+		sample.Decoded = s.redactor.Reading(reading)
+	}
+	if raw, ok := s.redactor.Payload(format, message.Value, reading); ok {
+		sample.Raw = raw
+	} else if decodeErr != nil && s.config.UndecodableRaw {
+		sample.Raw = message.Value
+	}
+	return sample
+}
+
+func (s *Sampler) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case sample := <-s.queue:
+			s.write(sample)
+		case <-s.ctx.Done():
+			// Write what was sampled before the stop
+			for {
+				select {
+				case sample := <-s.queue:
+					s.write(sample)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Sampler) write(sample *Sample) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	if err := s.destination.Write(ctx, sample); err != nil {
+		s.config.Logger.Warn("Failed to write capture", "topic", sample.Topic, "partition", sample.Partition,
+			"offset", sample.Offset, "error", err)
+		if s.metrics != nil {
+			s.metrics.Errors.Inc()
+		}
+		return
+	}
+	if s.metrics != nil {
+		s.metrics.Captured.WithLabelValues(sample.Kind).Inc()
+	}
+}
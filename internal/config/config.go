@@ -12,37 +12,248 @@ import (
 
 // Config holds the application configuration
 type Config struct {
+	// Profile is the APP_ENV profile the defaults were taken from (empty for none)
+	Profile string
+
+	// Service logs: the minimum level (debug, info, warn, error, off) and the
+	// output format (text or json)
+	LogLevel  string
+	LogFormat string
+
 	// Kafka configuration
 	KafkaBrokers      []string
 	KafkaVersion      string
 	SchemaRegistryURL string
+	SaramaLogLevel    string
 
-	// Topics
-	TopicSensorRaw    string
-	TopicSensorAlert  string
-	TopicSensorRawDLT string
+	// Kafka connection security
+	KafkaTLSEnabled            bool
+	KafkaTLSCertFile           string
+	KafkaTLSKeyFile            string
+	KafkaTLSCAFile             string
+	KafkaTLSInsecureSkipVerify bool
+	KafkaSASLMechanism         string
+	KafkaSASLUsername          string
+	KafkaSASLPassword          string
+
+	// Topics by key (see TopicKeySensorRaw and friends)
+	Topics map[string]TopicConfig
+	// KafkaCreateTopics creates missing topics while waiting for Kafka at startup
+	KafkaCreateTopics bool
 
 	// Producer configuration
 	ProducerRequiredAcks  int
 	ProducerReturnSuccess bool
 	ProducerReturnErrors  bool
+	ProducerSendTimeout   time.Duration
+	// ProducerShutdownTimeout bounds waiting for in-flight sends on shutdown
+	ProducerShutdownTimeout time.Duration
+	// ProducerIdempotent writes every message once per partition despite
+	// retries, which requires acks from all in-sync replicas
+	ProducerIdempotent bool
+	// Retries of sends, with overrides by component (see ProducerRetryFor)
+	ProducerRetry   RetryConfig
+	ProducerRetries map[string]RetryConfig
 
 	// Consumer configuration
 	ConsumerGroupID         string
 	ConsumerOffsetInitial   int64
 	ConsumerReturnErrors    bool
 	ConsumerBalanceStrategy string
+	ConsumerHandlerTimeout  time.Duration
+	ConsumerDrainTimeout    time.Duration
+	// Cap on the estimated memory of consumed messages not yet handled, raw
+	// and decoded, shared by the consumers of a process (0 disables)
+	ConsumerMaxInflightBytes int64
+	// How often consumers publish the lag of their assigned partitions (0 disables)
+	ConsumerLagInterval time.Duration
+	// Retries of handler attempts, with overrides by component (see ConsumerRetryFor)
+	ConsumerRetry   RetryConfig
+	ConsumerRetries map[string]RetryConfig
+	// Send messages whose handler failed every attempt to the DLT paired
+	// with their topic instead of skipping them
+	ConsumerDeadLetter bool
+
+	// Readings of PrioritySensors are produced to the priority topic, which
+	// consumers of readings take PriorityWeight workers from for every one
+	// of the raw topic while both are backlogged (no sensors disables it)
+	PrioritySensors []string
+	PriorityWeight  int
 
 	// Sensor simulation configuration
 	SensorCount    int
 	SensorInterval time.Duration
+	SensorSites    int
+	SensorFormat   string
+	// Zones the sensors of each site are spread over (0 sends no zone)
+	SensorZones int
+
+	// Positions of virtual sensors: fixed, drifting or none, placed in the
+	// "lat,lon,radius_km" area, drifting at the speed in m/s
+	SimulatorPositions  string
+	SimulatorArea       string
+	SimulatorDriftSpeed float64
+
+	// Reading profiles of virtual sensors (see simulator.ParseProfile)
+	SensorProfile  string
+	SensorProfiles string
+
+	// Simulator credential rotation exercise (0 interval disables)
+	SimulatorRegistryURL       string
+	SimulatorProvisioningToken string
+	SimulatorRotationInterval  time.Duration
+	SimulatorRotationSensors   int
+
+	// Scenario file of timed events played by the simulator (empty disables)
+	SimulatorScenario string
+
+	// Injected anomalies: the probability per reading and the comma-separated types
+	SimulatorAnomalyRate  float64
+	SimulatorAnomalyTypes string
+
+	// Caps on the readings and bytes the simulator sends per second, so it
+	// cannot overwhelm a small cluster (0 is unlimited)
+	SimulatorMaxMessagesPerSecond float64
+	SimulatorMaxBytesPerSecond    float64
+
+	// Simulator scheduling: the goroutines the sensors are spread over, the
+	// readings sent at once, and how many times the sensor interval may be
+	// stretched while the producer reports errors (1 never stretches it)
+	SimulatorShards      int
+	SimulatorMaxInFlight int
+	SimulatorMaxBackoff  float64
 
 	// HTTP server configuration
 	MetricsPort int
 
+	// Startup dependency wait (0 timeout checks a dependency once)
+	StartupKafkaTimeout         time.Duration
+	StartupPostgresTimeout      time.Duration
+	StartupRegistryTimeout      time.Duration
+	StartupElasticsearchTimeout time.Duration
+	StartupBackoffInitial       time.Duration
+	StartupBackoffMax           time.Duration
+
+	// Cluster telemetry configuration (0 disables the collector)
+	ClusterMetricsInterval time.Duration
+
+	// Lag exporter configuration: the scrape interval and the groups to export
+	// as "group=topic|topic,..." (empty exports the detector and sink groups)
+	LagExporterInterval time.Duration
+	LagExporterGroups   string
+
+	// Autoscaling hints: the window rates are computed over (0 disables), the
+	// messages per second one partition can be handled at, and the saturation
+	// detector replicas are scaled to
+	AutoscaleWindow            time.Duration
+	AutoscalePartitionCapacity float64
+	AutoscaleTargetUtilization float64
+
+	// Detector state snapshots: the admin token guarding the snapshot endpoints
+	// (empty disables them), the key prefix in MINIO_BUCKET and the snapshot to
+	// restore at startup (empty starts fresh)
+	DetectorAdminToken      string
+	DetectorSnapshotPrefix  string
+	DetectorRestoreSnapshot string
+	// DetectorTransactionalID makes the detector handle each reading in a
+	// Kafka transaction with this ID prefix, so its alerts, DLT entries and
+	// consumer offsets are committed together (empty disables)
+	DetectorTransactionalID string
+	// DetectorColor is the deployment color of a blue/green detector, blue
+	// or green; the authority record decides which color's alerts reach the
+	// alert topic (empty disables blue/green deployment)
+	DetectorColor string
+	// DetectorReplicaID names the replica in its heartbeats (empty uses the
+	// hostname)
+	DetectorReplicaID string
+	// HeartbeatInterval is how often each detector replica publishes its
+	// partitions and progress to the heartbeat topic (0 disables)
+	HeartbeatInterval time.Duration
+	// HeartbeatLeaseTTL is how long the detector monitor counts a replica
+	// as owning its partitions after its last heartbeat, and HeartbeatMaxLag
+	// how long its backlog may take to clear at its rate before it is lagging
+	HeartbeatLeaseTTL time.Duration
+	HeartbeatMaxLag   time.Duration
+	// Workers of the detector's decode, validate/detect and emit stages and
+	// the queue capacity of each stage (0 uses the defaults)
+	DetectorDecodeWorkers int
+	DetectorDetectWorkers int
+	DetectorEmitWorkers   int
+	DetectorStageBuffer   int
+
+	// Clock diagnostics embed send timestamps in message headers
+	ClockDiagnostics bool
+
+	// Hop headers record the pipeline stages each message passed through
+	HopHeaders bool
+
+	// Schema tracking counts the payload formats, schema versions and schema
+	// IDs each consumer group sees on each topic
+	SchemaTracking bool
+
+	// OpenTelemetry spans are exported over OTLP/HTTP when an endpoint is set
+	OTLPEndpoint string
+	// Fraction of the traces started by this process that are sampled;
+	// traces continued from a message follow their producer's decision
+	TracingSampleRatio float64
+
+	// Payload capture copies a sample of consumed messages for debugging (rate 0 disables)
+	CaptureRate           float64
+	CaptureDestination    string
+	CapturePrefix         string
+	CaptureRedactSalt     string
+	CaptureRedactFields   string
+	CaptureUndecodableRaw bool
+
+	// Detector decision log: the fraction of readings whose checks and
+	// outcome are recorded (0 disables), the comma-separated sensors to
+	// record (empty records all), and the destination, topic or file
+	DecisionLogRate        float64
+	DecisionLogSensors     string
+	DecisionLogDestination string
+	DecisionLogFile        string
+
 	// Anomaly detector configuration
 	MaxTemperature float32
 	MinHumidity    float32
+	// Minimum battery level in % and signal strength in dBm of readings
+	// that report them (0 disables)
+	MinBatteryPct float32
+	MinRSSI       int32
+
+	// Per-sensor threshold overrides: "sensor=max_temperature:45,min_humidity:5;..."
+	ThresholdOverrides string
+	// Interval the detector applies thresholds stored through the registry
+	// API at (0 disables)
+	ThresholdRefreshInterval time.Duration
+
+	// Per-sensor z-score anomaly detection over the last StatsWindow readings
+	// (0 window disables)
+	StatsWindow     int
+	StatsSigmas     float64
+	StatsWarmup     int
+	StatsMaxSensors int
+
+	// Fleet-level ingest rate anomaly detection (0 window disables)
+	FleetRateWindow     time.Duration
+	FleetRateAlpha      float64
+	FleetRateDropRatio  float64
+	FleetRateSurgeRatio float64
+	FleetRateWarmup     int
+
+	// Site aggregation and site-level alert rules
+	AggregateWindow time.Duration
+	SiteRules       string
+
+	// Alert correlation into site incidents
+	CorrelationGroupWait   time.Duration
+	CorrelationMinSensors  int
+	CorrelationQuietPeriod time.Duration
+
+	// Alert volume budgets (alerts per hour; 0 disables)
+	AlertBudgetGlobal          int
+	AlertBudgetPerSite         int
+	AlertBudgetSummaryInterval time.Duration
 
 	// PostgreSQL configuration
 	PostgresHost     string
@@ -50,16 +261,192 @@ type Config struct {
 	PostgresUser     string
 	PostgresPassword string
 	PostgresDB       string
+	// PostgresReplicaHosts are read replicas, as host or host:port, that
+	// read-only queries are routed to while their replication lag stays
+	// within PostgresReplicaMaxLag, checked every PostgresReplicaCheckInterval
+	PostgresReplicaHosts         []string
+	PostgresReplicaMaxLag        time.Duration
+	PostgresReplicaCheckInterval time.Duration
+
+	// TimescaleDB mode: sensor_readings becomes a hypertable chunked by
+	// reading time, compressed with age and summarized by continuous
+	// aggregates named by their bucket
+	PostgresTimescale                  bool
+	PostgresTimescaleChunkInterval     time.Duration
+	PostgresTimescaleCompressAfter     time.Duration
+	PostgresTimescaleAggregates        []string
+	PostgresTimescaleAggregateLookback time.Duration
+
+	// StoreTimeout bounds each database call made outside a request
+	StoreTimeout time.Duration
+
+	// PostgreSQL sink configuration
+	PostgresSinkGroupID       string
+	PostgresSinkBatchSize     int
+	PostgresSinkFlushInterval time.Duration
+	// PostgresSinkInsertMethod is how batches are written: copy or values
+	PostgresSinkInsertMethod string
+	// PostgresSinkAlerts makes the PostgreSQL sink also store the alert topic
+	// in sensor_alerts, encrypting their columns like those of readings
+	PostgresSinkAlerts bool
 
 	// Elasticsearch configuration
-	ElasticsearchURL   string
-	ElasticsearchIndex string
+	ElasticsearchURL        string
+	ElasticsearchIndex      string
+	ElasticsearchAlertIndex string
+
+	// Elasticsearch sink configuration
+	ESSinkGroupID       string
+	ESSinkBatchSize     int
+	ESSinkFlushInterval time.Duration
+
+	// Soak mode: how long producer and detector run before stopping with a
+	// resource usage report (0 disables), how often usage is sampled, the
+	// directory the report is written to and its key prefix in MINIO_BUCKET
+	// (empty skips the upload)
+	SoakDuration       time.Duration
+	SoakSampleInterval time.Duration
+	SoakReportDir      string
+	SoakUploadPrefix   string
 
 	// MinIO configuration
 	MinioEndpoint  string
 	MinioAccessKey string
 	MinioSecretKey string
 	MinioBucket    string
+
+	// Cold archiver configuration; objects are compressed with zstd or gzip
+	ArchiveGroupID       string
+	ArchivePrefix        string
+	ArchiveCompression   string
+	ArchiveBatchSize     int
+	ArchiveFlushInterval time.Duration
+
+	// Archive objects as compressed NDJSON (json) or as Parquet (parquet)
+	// with row groups of ArchiveParquetRowGroupSize readings
+	ArchiveFormat              string
+	ArchiveParquetRowGroupSize int
+
+	// Record every archived object and offset range in PostgreSQL
+	ArchiveCatalogEnabled bool
+
+	// HTTP ingest configuration; requests carry up to HTTPIngestMaxBatch
+	// readings in at most HTTPIngestMaxBodyBytes, and retries with the same
+	// Idempotency-Key within IdempotencyTTL replay the first response
+	HTTPIngestPort         int
+	HTTPIngestMaxBatch     int
+	HTTPIngestMaxBodyBytes int64
+	IdempotencyStore       string
+	IdempotencyTTL         time.Duration
+
+	// Ingest admission rules
+	AdmissionMaxFutureSkew     time.Duration
+	AdmissionMaxPastAge        time.Duration
+	AdmissionClampValues       bool
+	AdmissionRequireRegistered bool
+	AdmissionRegisteredSensors []string
+
+	// CoAP ingest listens on a UDP address for CBOR readings, forwarding up
+	// to CoAPMaxInflight requests at once and asking devices to retry after
+	// CoAPRetryAfter beyond that
+	CoAPListenAddr  string
+	CoAPMaxInflight int
+	CoAPRetryAfter  time.Duration
+
+	// Sensor registry configuration
+	RegistryPort       int
+	RegistryAdminToken string
+
+	// Runbook and annotation refresh for alert producers (0 disables)
+	AnnotationRefreshInterval time.Duration
+
+	// Partition site refresh for the site-affinity balance strategy (0 disables)
+	PartitionSiteRefreshInterval time.Duration
+	// PartitionBySite has the simulator and ingest gateways send each reading
+	// to a partition of its site in the partition site map
+	PartitionBySite bool
+
+	// Device credential rotation
+	CredentialRotationOverlap time.Duration
+	CredentialMaxAge          time.Duration
+	CredentialEnforceSchedule string
+	AuthCacheTTL              time.Duration
+
+	// Archive encryption configuration
+	ArchiveEncryptionMode string
+	ArchiveTenantKeys     string
+	ArchiveDefaultTenant  string
+
+	// Column encryption configuration. ColumnEncryptionKeys lists
+	// "id:base64key" entries, the first encrypting and all decrypting; a
+	// Vault path, when set, supplies them instead.
+	ColumnEncryptionKeys      string
+	ColumnEncryptionColumns   []string
+	ColumnEncryptionVaultPath string
+	VaultAddr                 string
+	VaultToken                string
+
+	// Query API configuration
+	APIPort int
+	// APIDefaultPageSize and APIMaxPageSize bound the items per response page
+	APIDefaultPageSize int
+	APIMaxPageSize     int
+	// APICacheTTL is how long results of the queries dashboards poll are
+	// reused (0 disables caching)
+	APICacheTTL time.Duration
+	// APISummaryWindow is the window of the fleet summary, and
+	// APIActiveAlertWindow how long an alert counts as active
+	APISummaryWindow     time.Duration
+	APIActiveAlertWindow time.Duration
+	// APIGRPCPort serves live alerts over gRPC (0 disables), buffering up to
+	// APIGRPCBufferSize alerts per subscriber
+	APIGRPCPort       int
+	APIGRPCBufferSize int
+	// APIAlertStream pushes alerts inserted into sensor_alerts, announced by
+	// PostgreSQL NOTIFY, to event stream clients, buffering up to
+	// APIAlertStreamBufferSize alerts per client
+	APIAlertStream           bool
+	APIAlertStreamBufferSize int
+	// APIValidateResponses logs and counts responses that do not match the
+	// OpenAPI document; requests are always validated
+	APIValidateResponses bool
+
+	// Alert notifier configuration: the YAML file listing the destinations
+	// alerts are delivered to, and the default bound of each request
+	NotifierConfig  string
+	NotifierGroupID string
+	NotifierTimeout time.Duration
+	// NotifierTemplateRefreshInterval is how often the notifier reloads the
+	// templates stored in PostgreSQL (0 disables stored templates), and
+	// NotifierAdminToken guards the template endpoints (empty disables them)
+	NotifierTemplateRefreshInterval time.Duration
+	NotifierAdminToken              string
+	// NotifierSource is what the notifier delivers: every alert of the
+	// alert topic ("alerts") or the pages the correlator writes to the
+	// notification topic ("notifications")
+	NotifierSource string
+
+	// Aggregator configuration: readings are downsampled into per-sensor
+	// windows of AggregatorWindow, closed AggregatorGrace after their end
+	AggregatorGroupID string
+	AggregatorWindow  time.Duration
+	AggregatorGrace   time.Duration
+
+	// Retention configuration: every RetentionInterval, rows and documents
+	// older than their TTL (0 keeps them) are deleted in batches of
+	// RetentionBatchSize, each bounded by RetentionTimeout, or only counted
+	// with RetentionDryRun
+	RetentionInterval      time.Duration
+	RetentionDryRun        bool
+	RetentionBatchSize     int
+	RetentionTimeout       time.Duration
+	RetentionReadingsTTL   time.Duration
+	RetentionAlertsTTL     time.Duration
+	RetentionAggregatesTTL time.Duration
+	RetentionESReadingsTTL time.Duration
+	RetentionESAlertsTTL   time.Duration
+	// RetentionRequireArchive only purges readings the cold archive holds
+	RetentionRequireArchive bool
 }
 
 // LoadConfig loads the configuration from environment variables
@@ -69,30 +456,117 @@ func LoadConfig() (*Config, error) {
 
 	config := &Config{
 		// Default values
+		LogLevel:  "info",
+		LogFormat: "text",
+
 		KafkaBrokers:      []string{"localhost:9092"},
 		KafkaVersion:      "3.7.0",
 		SchemaRegistryURL: "http://localhost:8081",
+		SaramaLogLevel:    "warn",
 
-		TopicSensorRaw:    "sensor.raw",
-		TopicSensorAlert:  "sensor.alert",
-		TopicSensorRawDLT: "sensor.raw.dlt",
+		Topics:            defaultTopics(),
+		KafkaCreateTopics: true,
 
-		ProducerRequiredAcks:  1, // WaitForLocal
-		ProducerReturnSuccess: true,
-		ProducerReturnErrors:  true,
+		ProducerRequiredAcks:    1, // WaitForLocal
+		ProducerReturnSuccess:   true,
+		ProducerReturnErrors:    true,
+		ProducerSendTimeout:     10 * time.Second,
+		ProducerShutdownTimeout: 15 * time.Second,
+		ProducerRetry:           defaultRetry(),
 
 		ConsumerGroupID:         "iot-sensor-group",
 		ConsumerOffsetInitial:   -1, // OffsetNewest
 		ConsumerReturnErrors:    true,
 		ConsumerBalanceStrategy: "range",
+		ConsumerHandlerTimeout:  30 * time.Second,
+		ConsumerDrainTimeout:    30 * time.Second,
+		ConsumerRetry:           defaultRetry(),
+		ConsumerLagInterval:     15 * time.Second,
 
 		SensorCount:    1000,
 		SensorInterval: 2 * time.Second,
+		SensorSites:    10,
+		SensorFormat:   "json",
+		SensorProfile:  "uniform",
+
+		SimulatorPositions:  "fixed",
+		SimulatorArea:       "52.52,13.405,25",
+		SimulatorDriftSpeed: 1.5,
+
+		SimulatorRegistryURL:     "http://localhost:8090",
+		SimulatorRotationSensors: 5,
+		SimulatorAnomalyTypes:    "over_temperature,low_humidity,low_battery,weak_signal,null_payload,corrupt_avro,wrong_schema_version,garbage_bytes",
+
+		SimulatorShards:      4,
+		SimulatorMaxInFlight: 64,
+		SimulatorMaxBackoff:  8,
 
 		MetricsPort: 2112,
 
+		StartupKafkaTimeout:         2 * time.Minute,
+		StartupPostgresTimeout:      2 * time.Minute,
+		StartupRegistryTimeout:      2 * time.Minute,
+		StartupElasticsearchTimeout: 2 * time.Minute,
+		StartupBackoffInitial:       500 * time.Millisecond,
+		StartupBackoffMax:           15 * time.Second,
+
+		ClusterMetricsInterval: 30 * time.Second,
+
+		LagExporterInterval: 15 * time.Second,
+
+		AutoscaleWindow:            30 * time.Second,
+		AutoscalePartitionCapacity: 500,
+		AutoscaleTargetUtilization: 0.7,
+
+		DetectorSnapshotPrefix: "detector-snapshots",
+
+		HeartbeatInterval: 10 * time.Second,
+		HeartbeatLeaseTTL: 30 * time.Second,
+		HeartbeatMaxLag:   time.Minute,
+
+		HopHeaders: true,
+
+		PriorityWeight: 4,
+
+		SchemaTracking: true,
+
+		TracingSampleRatio: 1,
+
+		CaptureDestination:  "topic",
+		CapturePrefix:       "debug/captures",
+		CaptureRedactFields: "id,sensor_id,site",
+
+		DecisionLogDestination: "topic",
+		DecisionLogFile:        "decisions.ndjson",
+
 		MaxTemperature: 50.0,
 		MinHumidity:    10.0,
+		MinBatteryPct:  15.0,
+		MinRSSI:        -90,
+
+		ThresholdRefreshInterval: 30 * time.Second,
+
+		StatsWindow:     0,
+		StatsSigmas:     3,
+		StatsWarmup:     10,
+		StatsMaxSensors: 10000,
+
+		FleetRateWindow:     10 * time.Second,
+		FleetRateAlpha:      0.1,
+		FleetRateDropRatio:  0.5,
+		FleetRateSurgeRatio: 2.0,
+		FleetRateWarmup:     6,
+
+		AggregateWindow: time.Minute,
+		SiteRules:       "site_hot=avg_temperature>40@10m;site_widespread_alerts=alerting_fraction>0.2@5m",
+
+		CorrelationGroupWait:   30 * time.Second,
+		CorrelationMinSensors:  3,
+		CorrelationQuietPeriod: 10 * time.Minute,
+
+		AlertBudgetGlobal:          1000,
+		AlertBudgetPerSite:         200,
+		AlertBudgetSummaryInterval: 5 * time.Minute,
 
 		// PostgreSQL defaults
 		PostgresHost:     "localhost",
@@ -101,15 +575,112 @@ func LoadConfig() (*Config, error) {
 		PostgresPassword: "postgres",
 		PostgresDB:       "sensordb",
 
+		PostgresReplicaMaxLag:        10 * time.Second,
+		PostgresReplicaCheckInterval: 5 * time.Second,
+
+		PostgresTimescaleChunkInterval:     24 * time.Hour,
+		PostgresTimescaleCompressAfter:     7 * 24 * time.Hour,
+		PostgresTimescaleAggregateLookback: 24 * time.Hour,
+
+		StoreTimeout: 10 * time.Second,
+
+		PostgresSinkGroupID:       "postgres-sink-group",
+		PostgresSinkBatchSize:     500,
+		PostgresSinkFlushInterval: time.Second,
+		PostgresSinkInsertMethod:  "copy",
+
+		PostgresSinkAlerts: false,
+
 		// Elasticsearch defaults
-		ElasticsearchURL:   "http://localhost:9200",
-		ElasticsearchIndex: "sensor_readings",
+		ElasticsearchURL:        "http://localhost:9200",
+		ElasticsearchIndex:      "sensor_readings",
+		ElasticsearchAlertIndex: "sensor_alerts",
+
+		ESSinkGroupID:       "es-sink-group",
+		ESSinkBatchSize:     500,
+		ESSinkFlushInterval: time.Second,
+
+		SoakSampleInterval: 10 * time.Second,
+		SoakReportDir:      ".",
 
 		// MinIO defaults
 		MinioEndpoint:  "localhost:9000",
 		MinioAccessKey: "minioadmin",
 		MinioSecretKey: "minioadmin",
 		MinioBucket:    "sensor-cold",
+
+		ArchiveGroupID:       "cold-archiver-group",
+		ArchivePrefix:        "readings",
+		ArchiveCompression:   "zstd",
+		ArchiveBatchSize:     5000,
+		ArchiveFlushInterval: time.Minute,
+
+		ArchiveFormat:              "json",
+		ArchiveParquetRowGroupSize: 10000,
+
+		// HTTP ingest defaults
+		HTTPIngestPort:         8094,
+		HTTPIngestMaxBatch:     500,
+		HTTPIngestMaxBodyBytes: 1 << 20,
+		IdempotencyStore:       "postgres",
+		IdempotencyTTL:         24 * time.Hour,
+
+		AdmissionMaxFutureSkew: 5 * time.Minute,
+		AdmissionMaxPastAge:    7 * 24 * time.Hour,
+		AdmissionClampValues:   true,
+
+		// CoAP ingest defaults
+		CoAPListenAddr:  ":5683",
+		CoAPMaxInflight: 256,
+		CoAPRetryAfter:  5 * time.Second,
+
+		// Sensor registry defaults
+		RegistryPort: 8090,
+
+		AnnotationRefreshInterval: time.Minute,
+
+		PartitionSiteRefreshInterval: time.Minute,
+
+		CredentialRotationOverlap: time.Hour,
+		CredentialMaxAge:          90 * 24 * time.Hour,
+		CredentialEnforceSchedule: "@every 5m",
+		AuthCacheTTL:              30 * time.Second,
+
+		// Archive encryption defaults
+		ArchiveEncryptionMode: "none",
+		ArchiveDefaultTenant:  "default",
+
+		// Column encryption defaults
+		ColumnEncryptionColumns: []string{"location", "site", "zone"},
+
+		// Query API defaults
+		APIPort:                  8092,
+		APIDefaultPageSize:       100,
+		APIMaxPageSize:           1000,
+		APICacheTTL:              2 * time.Second,
+		APISummaryWindow:         5 * time.Minute,
+		APIActiveAlertWindow:     15 * time.Minute,
+		APIGRPCPort:              8093,
+		APIGRPCBufferSize:        256,
+		APIAlertStream:           true,
+		APIAlertStreamBufferSize: 256,
+
+		NotifierGroupID: "alert-notifier-group",
+		NotifierTimeout: 10 * time.Second,
+		NotifierSource:  "alerts",
+
+		AggregatorGroupID: "aggregator-group",
+		AggregatorWindow:  time.Minute,
+		AggregatorGrace:   10 * time.Second,
+
+		RetentionInterval:  time.Hour,
+		RetentionBatchSize: 10000,
+		RetentionTimeout:   time.Minute,
+	}
+
+	// Adjust the defaults for the deployment profile
+	if err := applyProfile(config, normalizeProfile(os.Getenv("APP_ENV"))); err != nil {
+		return nil, err
 	}
 
 	// Override defaults with environment variables
@@ -121,20 +692,56 @@ func LoadConfig() (*Config, error) {
 		config.KafkaVersion = version
 	}
 
+	if tlsEnabled := os.Getenv("KAFKA_TLS_ENABLED"); tlsEnabled != "" {
+		tlsEnabledBool, err := strconv.ParseBool(tlsEnabled)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KAFKA_TLS_ENABLED: %w", err)
+		}
+		config.KafkaTLSEnabled = tlsEnabledBool
+	}
+
+	config.KafkaTLSCertFile = os.Getenv("KAFKA_TLS_CERT_FILE")
+	config.KafkaTLSKeyFile = os.Getenv("KAFKA_TLS_KEY_FILE")
+	config.KafkaTLSCAFile = os.Getenv("KAFKA_TLS_CA_FILE")
+
+	if insecure := os.Getenv("KAFKA_TLS_INSECURE_SKIP_VERIFY"); insecure != "" {
+		insecureBool, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KAFKA_TLS_INSECURE_SKIP_VERIFY: %w", err)
+		}
+		config.KafkaTLSInsecureSkipVerify = insecureBool
+	}
+
+	config.KafkaSASLMechanism = strings.ToUpper(os.Getenv("KAFKA_SASL_MECHANISM"))
+	config.KafkaSASLUsername = os.Getenv("KAFKA_SASL_USERNAME")
+	config.KafkaSASLPassword = os.Getenv("KAFKA_SASL_PASSWORD")
+
 	if url := os.Getenv("SCHEMA_REGISTRY_URL"); url != "" {
 		config.SchemaRegistryURL = url
 	}
 
-	if topic := os.Getenv("TOPIC_SENSOR_RAW"); topic != "" {
-		config.TopicSensorRaw = topic
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		config.LogLevel = strings.ToLower(level)
+	}
+
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		config.LogFormat = strings.ToLower(format)
+	}
+
+	if level := os.Getenv("SARAMA_LOG_LEVEL"); level != "" {
+		config.SaramaLogLevel = strings.ToLower(level)
 	}
 
-	if topic := os.Getenv("TOPIC_SENSOR_ALERT"); topic != "" {
-		config.TopicSensorAlert = topic
+	if err := loadTopics(config.Topics); err != nil {
+		return nil, err
 	}
 
-	if topic := os.Getenv("TOPIC_SENSOR_RAW_DLT"); topic != "" {
-		config.TopicSensorRawDLT = topic
+	if createTopics := os.Getenv("KAFKA_CREATE_TOPICS"); createTopics != "" {
+		createTopicsBool, err := strconv.ParseBool(createTopics)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KAFKA_CREATE_TOPICS: %w", err)
+		}
+		config.KafkaCreateTopics = createTopicsBool
 	}
 
 	if acks := os.Getenv("PRODUCER_REQUIRED_ACKS"); acks != "" {
@@ -145,6 +752,14 @@ func LoadConfig() (*Config, error) {
 		config.ProducerRequiredAcks = acksInt
 	}
 
+	if idempotent := os.Getenv("PRODUCER_IDEMPOTENT"); idempotent != "" {
+		idempotentBool, err := strconv.ParseBool(idempotent)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PRODUCER_IDEMPOTENT: %w", err)
+		}
+		config.ProducerIdempotent = idempotentBool
+	}
+
 	if returnSuccess := os.Getenv("PRODUCER_RETURN_SUCCESS"); returnSuccess != "" {
 		returnSuccessBool, err := strconv.ParseBool(returnSuccess)
 		if err != nil {
@@ -161,6 +776,22 @@ func LoadConfig() (*Config, error) {
 		config.ProducerReturnErrors = returnErrorsBool
 	}
 
+	if sendTimeout := os.Getenv("PRODUCER_SEND_TIMEOUT"); sendTimeout != "" {
+		sendTimeoutDuration, err := time.ParseDuration(sendTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PRODUCER_SEND_TIMEOUT: %w", err)
+		}
+		config.ProducerSendTimeout = sendTimeoutDuration
+	}
+
+	if shutdownTimeout := os.Getenv("PRODUCER_SHUTDOWN_TIMEOUT"); shutdownTimeout != "" {
+		shutdownTimeoutDuration, err := time.ParseDuration(shutdownTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PRODUCER_SHUTDOWN_TIMEOUT: %w", err)
+		}
+		config.ProducerShutdownTimeout = shutdownTimeoutDuration
+	}
+
 	if groupID := os.Getenv("CONSUMER_GROUP_ID"); groupID != "" {
 		config.ConsumerGroupID = groupID
 	}
@@ -185,6 +816,70 @@ func LoadConfig() (*Config, error) {
 		config.ConsumerBalanceStrategy = strings.ToLower(balanceStrategy)
 	}
 
+	if handlerTimeout := os.Getenv("CONSUMER_HANDLER_TIMEOUT"); handlerTimeout != "" {
+		handlerTimeoutDuration, err := time.ParseDuration(handlerTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONSUMER_HANDLER_TIMEOUT: %w", err)
+		}
+		config.ConsumerHandlerTimeout = handlerTimeoutDuration
+	}
+
+	if drainTimeout := os.Getenv("CONSUMER_DRAIN_TIMEOUT"); drainTimeout != "" {
+		drainTimeoutDuration, err := time.ParseDuration(drainTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONSUMER_DRAIN_TIMEOUT: %w", err)
+		}
+		config.ConsumerDrainTimeout = drainTimeoutDuration
+	}
+
+	if maxInflight := os.Getenv("CONSUMER_MAX_INFLIGHT_BYTES"); maxInflight != "" {
+		maxInflightInt, err := strconv.ParseInt(maxInflight, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONSUMER_MAX_INFLIGHT_BYTES: %w", err)
+		}
+		config.ConsumerMaxInflightBytes = maxInflightInt
+	}
+
+	if lagInterval := os.Getenv("CONSUMER_LAG_INTERVAL"); lagInterval != "" {
+		lagIntervalDuration, err := time.ParseDuration(lagInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONSUMER_LAG_INTERVAL: %w", err)
+		}
+		config.ConsumerLagInterval = lagIntervalDuration
+	}
+
+	producerRetries, err := loadRetries("PRODUCER", &config.ProducerRetry)
+	if err != nil {
+		return nil, err
+	}
+	config.ProducerRetries = producerRetries
+
+	consumerRetries, err := loadRetries("CONSUMER", &config.ConsumerRetry)
+	if err != nil {
+		return nil, err
+	}
+	config.ConsumerRetries = consumerRetries
+
+	if deadLetter := os.Getenv("CONSUMER_DEAD_LETTER"); deadLetter != "" {
+		deadLetterBool, err := strconv.ParseBool(deadLetter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONSUMER_DEAD_LETTER: %w", err)
+		}
+		config.ConsumerDeadLetter = deadLetterBool
+	}
+
+	if sensors := os.Getenv("PRIORITY_SENSORS"); sensors != "" {
+		config.PrioritySensors = strings.Split(sensors, ",")
+	}
+
+	if weight := os.Getenv("PRIORITY_WEIGHT"); weight != "" {
+		weightInt, err := strconv.Atoi(weight)
+		if err != nil || weightInt <= 0 {
+			return nil, fmt.Errorf("invalid PRIORITY_WEIGHT: must be a positive integer")
+		}
+		config.PriorityWeight = weightInt
+	}
+
 	if sensorCount := os.Getenv("SENSOR_COUNT"); sensorCount != "" {
 		sensorCountInt, err := strconv.Atoi(sensorCount)
 		if err != nil {
@@ -201,6 +896,130 @@ func LoadConfig() (*Config, error) {
 		config.SensorInterval = sensorIntervalDuration
 	}
 
+	if sites := os.Getenv("SENSOR_SITES"); sites != "" {
+		sitesInt, err := strconv.Atoi(sites)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SENSOR_SITES: %w", err)
+		}
+		config.SensorSites = sitesInt
+	}
+
+	if zones := os.Getenv("SENSOR_ZONES"); zones != "" {
+		zonesInt, err := strconv.Atoi(zones)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SENSOR_ZONES: %w", err)
+		}
+		config.SensorZones = zonesInt
+	}
+
+	if positions := os.Getenv("SIMULATOR_POSITIONS"); positions != "" {
+		config.SimulatorPositions = strings.ToLower(positions)
+	}
+
+	if area := os.Getenv("SIMULATOR_AREA"); area != "" {
+		config.SimulatorArea = area
+	}
+
+	if speed := os.Getenv("SIMULATOR_DRIFT_SPEED"); speed != "" {
+		speedFloat, err := strconv.ParseFloat(speed, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIMULATOR_DRIFT_SPEED: %w", err)
+		}
+		config.SimulatorDriftSpeed = speedFloat
+	}
+
+	if format := os.Getenv("SENSOR_FORMAT"); format != "" {
+		config.SensorFormat = strings.ToLower(format)
+	}
+
+	if profile := os.Getenv("SENSOR_PROFILE"); profile != "" {
+		config.SensorProfile = profile
+	}
+
+	if profiles := os.Getenv("SENSOR_PROFILES"); profiles != "" {
+		config.SensorProfiles = profiles
+	}
+
+	if scenario := os.Getenv("SIMULATOR_SCENARIO"); scenario != "" {
+		config.SimulatorScenario = scenario
+	}
+
+	if rate := os.Getenv("SIMULATOR_ANOMALY_RATE"); rate != "" {
+		rateFloat, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIMULATOR_ANOMALY_RATE: %w", err)
+		}
+		config.SimulatorAnomalyRate = rateFloat
+	}
+
+	if types := os.Getenv("SIMULATOR_ANOMALY_TYPES"); types != "" {
+		config.SimulatorAnomalyTypes = types
+	}
+
+	if rate := os.Getenv("SIMULATOR_MAX_MESSAGES_PER_SECOND"); rate != "" {
+		rateFloat, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIMULATOR_MAX_MESSAGES_PER_SECOND: %w", err)
+		}
+		config.SimulatorMaxMessagesPerSecond = rateFloat
+	}
+
+	if rate := os.Getenv("SIMULATOR_MAX_BYTES_PER_SECOND"); rate != "" {
+		rateFloat, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIMULATOR_MAX_BYTES_PER_SECOND: %w", err)
+		}
+		config.SimulatorMaxBytesPerSecond = rateFloat
+	}
+
+	if shards := os.Getenv("SIMULATOR_SHARDS"); shards != "" {
+		shardsInt, err := strconv.Atoi(shards)
+		if err != nil || shardsInt <= 0 {
+			return nil, fmt.Errorf("invalid SIMULATOR_SHARDS: must be a positive integer")
+		}
+		config.SimulatorShards = shardsInt
+	}
+
+	if inFlight := os.Getenv("SIMULATOR_MAX_IN_FLIGHT"); inFlight != "" {
+		inFlightInt, err := strconv.Atoi(inFlight)
+		if err != nil || inFlightInt <= 0 {
+			return nil, fmt.Errorf("invalid SIMULATOR_MAX_IN_FLIGHT: must be a positive integer")
+		}
+		config.SimulatorMaxInFlight = inFlightInt
+	}
+
+	if backoff := os.Getenv("SIMULATOR_MAX_BACKOFF"); backoff != "" {
+		backoffFloat, err := strconv.ParseFloat(backoff, 64)
+		if err != nil || backoffFloat < 1 {
+			return nil, fmt.Errorf("invalid SIMULATOR_MAX_BACKOFF: must be a number of at least 1")
+		}
+		config.SimulatorMaxBackoff = backoffFloat
+	}
+
+	if url := os.Getenv("SIMULATOR_REGISTRY_URL"); url != "" {
+		config.SimulatorRegistryURL = url
+	}
+
+	if token := os.Getenv("SIMULATOR_PROVISIONING_TOKEN"); token != "" {
+		config.SimulatorProvisioningToken = token
+	}
+
+	if interval := os.Getenv("SIMULATOR_ROTATION_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIMULATOR_ROTATION_INTERVAL: %w", err)
+		}
+		config.SimulatorRotationInterval = intervalDuration
+	}
+
+	if sensors := os.Getenv("SIMULATOR_ROTATION_SENSORS"); sensors != "" {
+		sensorsInt, err := strconv.Atoi(sensors)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIMULATOR_ROTATION_SENSORS: %w", err)
+		}
+		config.SimulatorRotationSensors = sensorsInt
+	}
+
 	if metricsPort := os.Getenv("METRICS_PORT"); metricsPort != "" {
 		metricsPortInt, err := strconv.Atoi(metricsPort)
 		if err != nil {
@@ -209,63 +1028,631 @@ func LoadConfig() (*Config, error) {
 		config.MetricsPort = metricsPortInt
 	}
 
-	if maxTemperature := os.Getenv("MAX_TEMPERATURE"); maxTemperature != "" {
-		maxTemperatureFloat, err := strconv.ParseFloat(maxTemperature, 32)
+	if timeout := os.Getenv("STARTUP_KAFKA_TIMEOUT"); timeout != "" {
+		timeoutDuration, err := time.ParseDuration(timeout)
 		if err != nil {
-			return nil, fmt.Errorf("invalid MAX_TEMPERATURE: %w", err)
+			return nil, fmt.Errorf("invalid STARTUP_KAFKA_TIMEOUT: %w", err)
 		}
-		config.MaxTemperature = float32(maxTemperatureFloat)
+		config.StartupKafkaTimeout = timeoutDuration
 	}
 
-	if minHumidity := os.Getenv("MIN_HUMIDITY"); minHumidity != "" {
-		minHumidityFloat, err := strconv.ParseFloat(minHumidity, 32)
+	if timeout := os.Getenv("STARTUP_POSTGRES_TIMEOUT"); timeout != "" {
+		timeoutDuration, err := time.ParseDuration(timeout)
 		if err != nil {
-			return nil, fmt.Errorf("invalid MIN_HUMIDITY: %w", err)
+			return nil, fmt.Errorf("invalid STARTUP_POSTGRES_TIMEOUT: %w", err)
 		}
-		config.MinHumidity = float32(minHumidityFloat)
+		config.StartupPostgresTimeout = timeoutDuration
 	}
 
-	// PostgreSQL configuration
-	if host := os.Getenv("POSTGRES_HOST"); host != "" {
-		config.PostgresHost = host
+	if timeout := os.Getenv("STARTUP_REGISTRY_TIMEOUT"); timeout != "" {
+		timeoutDuration, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STARTUP_REGISTRY_TIMEOUT: %w", err)
+		}
+		config.StartupRegistryTimeout = timeoutDuration
 	}
 
-	if port := os.Getenv("POSTGRES_PORT"); port != "" {
-		portInt, err := strconv.Atoi(port)
+	if timeout := os.Getenv("STARTUP_ELASTICSEARCH_TIMEOUT"); timeout != "" {
+		timeoutDuration, err := time.ParseDuration(timeout)
 		if err != nil {
-			return nil, fmt.Errorf("invalid POSTGRES_PORT: %w", err)
+			return nil, fmt.Errorf("invalid STARTUP_ELASTICSEARCH_TIMEOUT: %w", err)
 		}
-		config.PostgresPort = portInt
+		config.StartupElasticsearchTimeout = timeoutDuration
 	}
 
-	if user := os.Getenv("POSTGRES_USER"); user != "" {
-		config.PostgresUser = user
+	if backoff := os.Getenv("STARTUP_BACKOFF_INITIAL"); backoff != "" {
+		backoffDuration, err := time.ParseDuration(backoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STARTUP_BACKOFF_INITIAL: %w", err)
+		}
+		config.StartupBackoffInitial = backoffDuration
 	}
 
-	if password := os.Getenv("POSTGRES_PASSWORD"); password != "" {
-		config.PostgresPassword = password
+	if backoff := os.Getenv("STARTUP_BACKOFF_MAX"); backoff != "" {
+		backoffDuration, err := time.ParseDuration(backoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STARTUP_BACKOFF_MAX: %w", err)
+		}
+		config.StartupBackoffMax = backoffDuration
 	}
 
-	if db := os.Getenv("POSTGRES_DB"); db != "" {
-		config.PostgresDB = db
+	if interval := os.Getenv("CLUSTER_METRICS_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLUSTER_METRICS_INTERVAL: %w", err)
+		}
+		config.ClusterMetricsInterval = intervalDuration
 	}
 
-	// Elasticsearch configuration
-	if url := os.Getenv("ELASTICSEARCH_URL"); url != "" {
-		config.ElasticsearchURL = url
+	if interval := os.Getenv("LAG_EXPORTER_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LAG_EXPORTER_INTERVAL: %w", err)
+		}
+		config.LagExporterInterval = intervalDuration
 	}
 
-	if index := os.Getenv("ELASTICSEARCH_INDEX"); index != "" {
-		config.ElasticsearchIndex = index
+	if groups := os.Getenv("LAG_EXPORTER_GROUPS"); groups != "" {
+		config.LagExporterGroups = groups
 	}
 
-	// MinIO configuration
-	if endpoint := os.Getenv("MINIO_ENDPOINT"); endpoint != "" {
-		config.MinioEndpoint = endpoint
+	if window := os.Getenv("AUTOSCALE_WINDOW"); window != "" {
+		windowDuration, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTOSCALE_WINDOW: %w", err)
+		}
+		config.AutoscaleWindow = windowDuration
 	}
 
-	if accessKey := os.Getenv("MINIO_ACCESS_KEY"); accessKey != "" {
-		config.MinioAccessKey = accessKey
+	if capacity := os.Getenv("AUTOSCALE_PARTITION_CAPACITY"); capacity != "" {
+		capacityFloat, err := strconv.ParseFloat(capacity, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTOSCALE_PARTITION_CAPACITY: %w", err)
+		}
+		config.AutoscalePartitionCapacity = capacityFloat
+	}
+
+	if target := os.Getenv("AUTOSCALE_TARGET_UTILIZATION"); target != "" {
+		targetFloat, err := strconv.ParseFloat(target, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTOSCALE_TARGET_UTILIZATION: %w", err)
+		}
+		config.AutoscaleTargetUtilization = targetFloat
+	}
+
+	if token := os.Getenv("DETECTOR_ADMIN_TOKEN"); token != "" {
+		config.DetectorAdminToken = token
+	}
+
+	if prefix := os.Getenv("DETECTOR_SNAPSHOT_PREFIX"); prefix != "" {
+		config.DetectorSnapshotPrefix = prefix
+	}
+
+	if snapshot := os.Getenv("DETECTOR_RESTORE_SNAPSHOT"); snapshot != "" {
+		config.DetectorRestoreSnapshot = snapshot
+	}
+
+	if id := os.Getenv("DETECTOR_TRANSACTIONAL_ID"); id != "" {
+		config.DetectorTransactionalID = id
+	}
+
+	if color := os.Getenv("DETECTOR_COLOR"); color != "" {
+		config.DetectorColor = strings.ToLower(color)
+	}
+
+	if replica := os.Getenv("DETECTOR_REPLICA_ID"); replica != "" {
+		config.DetectorReplicaID = replica
+	}
+
+	if interval := os.Getenv("HEARTBEAT_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEARTBEAT_INTERVAL: %w", err)
+		}
+		config.HeartbeatInterval = intervalDuration
+	}
+
+	if ttl := os.Getenv("HEARTBEAT_LEASE_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEARTBEAT_LEASE_TTL: %w", err)
+		}
+		config.HeartbeatLeaseTTL = ttlDuration
+	}
+
+	if maxLag := os.Getenv("HEARTBEAT_MAX_LAG"); maxLag != "" {
+		maxLagDuration, err := time.ParseDuration(maxLag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEARTBEAT_MAX_LAG: %w", err)
+		}
+		config.HeartbeatMaxLag = maxLagDuration
+	}
+
+	if workers := os.Getenv("DETECTOR_DECODE_WORKERS"); workers != "" {
+		workersInt, err := strconv.Atoi(workers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DETECTOR_DECODE_WORKERS: %w", err)
+		}
+		config.DetectorDecodeWorkers = workersInt
+	}
+
+	if workers := os.Getenv("DETECTOR_DETECT_WORKERS"); workers != "" {
+		workersInt, err := strconv.Atoi(workers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DETECTOR_DETECT_WORKERS: %w", err)
+		}
+		config.DetectorDetectWorkers = workersInt
+	}
+
+	if workers := os.Getenv("DETECTOR_EMIT_WORKERS"); workers != "" {
+		workersInt, err := strconv.Atoi(workers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DETECTOR_EMIT_WORKERS: %w", err)
+		}
+		config.DetectorEmitWorkers = workersInt
+	}
+
+	if buffer := os.Getenv("DETECTOR_STAGE_BUFFER"); buffer != "" {
+		bufferInt, err := strconv.Atoi(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DETECTOR_STAGE_BUFFER: %w", err)
+		}
+		config.DetectorStageBuffer = bufferInt
+	}
+
+	if clockDiagnostics := os.Getenv("CLOCK_DIAGNOSTICS"); clockDiagnostics != "" {
+		clockDiagnosticsBool, err := strconv.ParseBool(clockDiagnostics)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLOCK_DIAGNOSTICS: %w", err)
+		}
+		config.ClockDiagnostics = clockDiagnosticsBool
+	}
+
+	if hopHeaders := os.Getenv("HOP_HEADERS"); hopHeaders != "" {
+		hopHeadersBool, err := strconv.ParseBool(hopHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HOP_HEADERS: %w", err)
+		}
+		config.HopHeaders = hopHeadersBool
+	}
+
+	if schemaTracking := os.Getenv("SCHEMA_TRACKING"); schemaTracking != "" {
+		schemaTrackingBool, err := strconv.ParseBool(schemaTracking)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCHEMA_TRACKING: %w", err)
+		}
+		config.SchemaTracking = schemaTrackingBool
+	}
+
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		config.OTLPEndpoint = endpoint
+	}
+
+	if ratio := os.Getenv("TRACING_SAMPLE_RATIO"); ratio != "" {
+		ratioFloat, err := strconv.ParseFloat(ratio, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRACING_SAMPLE_RATIO: %w", err)
+		}
+		config.TracingSampleRatio = ratioFloat
+	}
+
+	if rate := os.Getenv("CAPTURE_RATE"); rate != "" {
+		rateFloat, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CAPTURE_RATE: %w", err)
+		}
+		config.CaptureRate = rateFloat
+	}
+
+	if destination := os.Getenv("CAPTURE_DESTINATION"); destination != "" {
+		config.CaptureDestination = strings.ToLower(destination)
+	}
+
+	if prefix := os.Getenv("CAPTURE_PREFIX"); prefix != "" {
+		config.CapturePrefix = prefix
+	}
+
+	config.CaptureRedactSalt = os.Getenv("CAPTURE_REDACT_SALT")
+
+	if fields := os.Getenv("CAPTURE_REDACT_FIELDS"); fields != "" {
+		config.CaptureRedactFields = fields
+	}
+
+	if undecodableRaw := os.Getenv("CAPTURE_UNDECODABLE_RAW"); undecodableRaw != "" {
+		undecodableRawBool, err := strconv.ParseBool(undecodableRaw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CAPTURE_UNDECODABLE_RAW: %w", err)
+		}
+		config.CaptureUndecodableRaw = undecodableRawBool
+	}
+
+	if rate := os.Getenv("DECISION_LOG_RATE"); rate != "" {
+		rateFloat, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DECISION_LOG_RATE: %w", err)
+		}
+		config.DecisionLogRate = rateFloat
+	}
+
+	config.DecisionLogSensors = os.Getenv("DECISION_LOG_SENSORS")
+
+	if destination := os.Getenv("DECISION_LOG_DESTINATION"); destination != "" {
+		config.DecisionLogDestination = strings.ToLower(destination)
+	}
+
+	if file := os.Getenv("DECISION_LOG_FILE"); file != "" {
+		config.DecisionLogFile = file
+	}
+
+	if maxTemperature := os.Getenv("MAX_TEMPERATURE"); maxTemperature != "" {
+		maxTemperatureFloat, err := strconv.ParseFloat(maxTemperature, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAX_TEMPERATURE: %w", err)
+		}
+		config.MaxTemperature = float32(maxTemperatureFloat)
+	}
+
+	if minHumidity := os.Getenv("MIN_HUMIDITY"); minHumidity != "" {
+		minHumidityFloat, err := strconv.ParseFloat(minHumidity, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MIN_HUMIDITY: %w", err)
+		}
+		config.MinHumidity = float32(minHumidityFloat)
+	}
+
+	if minBattery := os.Getenv("MIN_BATTERY_PCT"); minBattery != "" {
+		minBatteryFloat, err := strconv.ParseFloat(minBattery, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MIN_BATTERY_PCT: %w", err)
+		}
+		config.MinBatteryPct = float32(minBatteryFloat)
+	}
+
+	if minRSSI := os.Getenv("MIN_RSSI"); minRSSI != "" {
+		minRSSIInt, err := strconv.ParseInt(minRSSI, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MIN_RSSI: %w", err)
+		}
+		config.MinRSSI = int32(minRSSIInt)
+	}
+
+	if overrides := os.Getenv("THRESHOLD_OVERRIDES"); overrides != "" {
+		config.ThresholdOverrides = overrides
+	}
+
+	if interval := os.Getenv("THRESHOLD_REFRESH_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid THRESHOLD_REFRESH_INTERVAL: %w", err)
+		}
+		config.ThresholdRefreshInterval = intervalDuration
+	}
+
+	if window := os.Getenv("STATS_WINDOW"); window != "" {
+		windowInt, err := strconv.Atoi(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATS_WINDOW: %w", err)
+		}
+		config.StatsWindow = windowInt
+	}
+
+	if sigmas := os.Getenv("STATS_SIGMAS"); sigmas != "" {
+		sigmasFloat, err := strconv.ParseFloat(sigmas, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATS_SIGMAS: %w", err)
+		}
+		config.StatsSigmas = sigmasFloat
+	}
+
+	if warmup := os.Getenv("STATS_WARMUP"); warmup != "" {
+		warmupInt, err := strconv.Atoi(warmup)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATS_WARMUP: %w", err)
+		}
+		config.StatsWarmup = warmupInt
+	}
+
+	if maxSensors := os.Getenv("STATS_MAX_SENSORS"); maxSensors != "" {
+		maxSensorsInt, err := strconv.Atoi(maxSensors)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATS_MAX_SENSORS: %w", err)
+		}
+		config.StatsMaxSensors = maxSensorsInt
+	}
+
+	if window := os.Getenv("FLEET_RATE_WINDOW"); window != "" {
+		windowDuration, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLEET_RATE_WINDOW: %w", err)
+		}
+		config.FleetRateWindow = windowDuration
+	}
+
+	if alpha := os.Getenv("FLEET_RATE_ALPHA"); alpha != "" {
+		alphaFloat, err := strconv.ParseFloat(alpha, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLEET_RATE_ALPHA: %w", err)
+		}
+		config.FleetRateAlpha = alphaFloat
+	}
+
+	if ratio := os.Getenv("FLEET_RATE_DROP_RATIO"); ratio != "" {
+		ratioFloat, err := strconv.ParseFloat(ratio, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLEET_RATE_DROP_RATIO: %w", err)
+		}
+		config.FleetRateDropRatio = ratioFloat
+	}
+
+	if ratio := os.Getenv("FLEET_RATE_SURGE_RATIO"); ratio != "" {
+		ratioFloat, err := strconv.ParseFloat(ratio, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLEET_RATE_SURGE_RATIO: %w", err)
+		}
+		config.FleetRateSurgeRatio = ratioFloat
+	}
+
+	if warmup := os.Getenv("FLEET_RATE_WARMUP"); warmup != "" {
+		warmupInt, err := strconv.Atoi(warmup)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLEET_RATE_WARMUP: %w", err)
+		}
+		config.FleetRateWarmup = warmupInt
+	}
+
+	if window := os.Getenv("AGGREGATE_WINDOW"); window != "" {
+		windowDuration, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AGGREGATE_WINDOW: %w", err)
+		}
+		config.AggregateWindow = windowDuration
+	}
+
+	if rules := os.Getenv("SITE_RULES"); rules != "" {
+		config.SiteRules = rules
+	}
+
+	if wait := os.Getenv("CORRELATION_GROUP_WAIT"); wait != "" {
+		waitDuration, err := time.ParseDuration(wait)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORRELATION_GROUP_WAIT: %w", err)
+		}
+		config.CorrelationGroupWait = waitDuration
+	}
+
+	if minSensors := os.Getenv("CORRELATION_MIN_SENSORS"); minSensors != "" {
+		minSensorsInt, err := strconv.Atoi(minSensors)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORRELATION_MIN_SENSORS: %w", err)
+		}
+		config.CorrelationMinSensors = minSensorsInt
+	}
+
+	if quiet := os.Getenv("CORRELATION_QUIET_PERIOD"); quiet != "" {
+		quietDuration, err := time.ParseDuration(quiet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORRELATION_QUIET_PERIOD: %w", err)
+		}
+		config.CorrelationQuietPeriod = quietDuration
+	}
+
+	if budget := os.Getenv("ALERT_BUDGET_GLOBAL"); budget != "" {
+		budgetInt, err := strconv.Atoi(budget)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ALERT_BUDGET_GLOBAL: %w", err)
+		}
+		config.AlertBudgetGlobal = budgetInt
+	}
+
+	if budget := os.Getenv("ALERT_BUDGET_PER_SITE"); budget != "" {
+		budgetInt, err := strconv.Atoi(budget)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ALERT_BUDGET_PER_SITE: %w", err)
+		}
+		config.AlertBudgetPerSite = budgetInt
+	}
+
+	if interval := os.Getenv("ALERT_BUDGET_SUMMARY_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ALERT_BUDGET_SUMMARY_INTERVAL: %w", err)
+		}
+		config.AlertBudgetSummaryInterval = intervalDuration
+	}
+
+	// PostgreSQL configuration
+	if host := os.Getenv("POSTGRES_HOST"); host != "" {
+		config.PostgresHost = host
+	}
+
+	if port := os.Getenv("POSTGRES_PORT"); port != "" {
+		portInt, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_PORT: %w", err)
+		}
+		config.PostgresPort = portInt
+	}
+
+	if user := os.Getenv("POSTGRES_USER"); user != "" {
+		config.PostgresUser = user
+	}
+
+	if password := os.Getenv("POSTGRES_PASSWORD"); password != "" {
+		config.PostgresPassword = password
+	}
+
+	if db := os.Getenv("POSTGRES_DB"); db != "" {
+		config.PostgresDB = db
+	}
+
+	if hosts := os.Getenv("POSTGRES_REPLICA_HOSTS"); hosts != "" {
+		config.PostgresReplicaHosts = strings.Split(hosts, ",")
+	}
+
+	if maxLag := os.Getenv("POSTGRES_REPLICA_MAX_LAG"); maxLag != "" {
+		maxLagDuration, err := time.ParseDuration(maxLag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_REPLICA_MAX_LAG: %w", err)
+		}
+		config.PostgresReplicaMaxLag = maxLagDuration
+	}
+
+	if interval := os.Getenv("POSTGRES_REPLICA_CHECK_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil || intervalDuration <= 0 {
+			return nil, fmt.Errorf("invalid POSTGRES_REPLICA_CHECK_INTERVAL: must be a positive duration")
+		}
+		config.PostgresReplicaCheckInterval = intervalDuration
+	}
+
+	if timescale := os.Getenv("POSTGRES_TIMESCALE"); timescale != "" {
+		timescaleBool, err := strconv.ParseBool(timescale)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_TIMESCALE: %w", err)
+		}
+		config.PostgresTimescale = timescaleBool
+	}
+
+	if interval := os.Getenv("POSTGRES_TIMESCALE_CHUNK_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_TIMESCALE_CHUNK_INTERVAL: %w", err)
+		}
+		if intervalDuration < time.Millisecond {
+			return nil, fmt.Errorf("invalid POSTGRES_TIMESCALE_CHUNK_INTERVAL: must be at least 1ms")
+		}
+		config.PostgresTimescaleChunkInterval = intervalDuration
+	}
+
+	if after := os.Getenv("POSTGRES_TIMESCALE_COMPRESS_AFTER"); after != "" {
+		afterDuration, err := time.ParseDuration(after)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_TIMESCALE_COMPRESS_AFTER: %w", err)
+		}
+		config.PostgresTimescaleCompressAfter = afterDuration
+	}
+
+	if aggregates := os.Getenv("POSTGRES_TIMESCALE_AGGREGATES"); aggregates != "" {
+		config.PostgresTimescaleAggregates = strings.Split(aggregates, ",")
+	}
+
+	if lookback := os.Getenv("POSTGRES_TIMESCALE_AGGREGATE_LOOKBACK"); lookback != "" {
+		lookbackDuration, err := time.ParseDuration(lookback)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_TIMESCALE_AGGREGATE_LOOKBACK: %w", err)
+		}
+		config.PostgresTimescaleAggregateLookback = lookbackDuration
+	}
+
+	if storeTimeout := os.Getenv("STORE_TIMEOUT"); storeTimeout != "" {
+		storeTimeoutDuration, err := time.ParseDuration(storeTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STORE_TIMEOUT: %w", err)
+		}
+		config.StoreTimeout = storeTimeoutDuration
+	}
+
+	if groupID := os.Getenv("POSTGRES_SINK_GROUP_ID"); groupID != "" {
+		config.PostgresSinkGroupID = groupID
+	}
+
+	if batchSize := os.Getenv("POSTGRES_SINK_BATCH_SIZE"); batchSize != "" {
+		batchSizeInt, err := strconv.Atoi(batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_SINK_BATCH_SIZE: %w", err)
+		}
+		config.PostgresSinkBatchSize = batchSizeInt
+	}
+
+	if flushInterval := os.Getenv("POSTGRES_SINK_FLUSH_INTERVAL"); flushInterval != "" {
+		flushIntervalDuration, err := time.ParseDuration(flushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_SINK_FLUSH_INTERVAL: %w", err)
+		}
+		config.PostgresSinkFlushInterval = flushIntervalDuration
+	}
+
+	if method := os.Getenv("POSTGRES_SINK_INSERT_METHOD"); method != "" {
+		switch method = strings.ToLower(method); method {
+		case "copy", "values":
+			config.PostgresSinkInsertMethod = method
+		default:
+			return nil, fmt.Errorf("invalid POSTGRES_SINK_INSERT_METHOD: must be copy or values")
+		}
+	}
+
+	if alerts := os.Getenv("POSTGRES_SINK_ALERTS"); alerts != "" {
+		storeAlerts, err := strconv.ParseBool(alerts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_SINK_ALERTS: %w", err)
+		}
+		config.PostgresSinkAlerts = storeAlerts
+	}
+
+	// Elasticsearch configuration
+	if url := os.Getenv("ELASTICSEARCH_URL"); url != "" {
+		config.ElasticsearchURL = url
+	}
+
+	if index := os.Getenv("ELASTICSEARCH_INDEX"); index != "" {
+		config.ElasticsearchIndex = index
+	}
+
+	if index := os.Getenv("ELASTICSEARCH_ALERT_INDEX"); index != "" {
+		config.ElasticsearchAlertIndex = index
+	}
+
+	if groupID := os.Getenv("ES_SINK_GROUP_ID"); groupID != "" {
+		config.ESSinkGroupID = groupID
+	}
+
+	if batchSize := os.Getenv("ES_SINK_BATCH_SIZE"); batchSize != "" {
+		batchSizeInt, err := strconv.Atoi(batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ES_SINK_BATCH_SIZE: %w", err)
+		}
+		config.ESSinkBatchSize = batchSizeInt
+	}
+
+	if flushInterval := os.Getenv("ES_SINK_FLUSH_INTERVAL"); flushInterval != "" {
+		flushIntervalDuration, err := time.ParseDuration(flushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ES_SINK_FLUSH_INTERVAL: %w", err)
+		}
+		config.ESSinkFlushInterval = flushIntervalDuration
+	}
+
+	// MinIO configuration
+	if duration := os.Getenv("SOAK_DURATION"); duration != "" {
+		soakDuration, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOAK_DURATION: %w", err)
+		}
+		config.SoakDuration = soakDuration
+	}
+
+	if interval := os.Getenv("SOAK_SAMPLE_INTERVAL"); interval != "" {
+		sampleInterval, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOAK_SAMPLE_INTERVAL: %w", err)
+		}
+		config.SoakSampleInterval = sampleInterval
+	}
+
+	if dir := os.Getenv("SOAK_REPORT_DIR"); dir != "" {
+		config.SoakReportDir = dir
+	}
+
+	if prefix := os.Getenv("SOAK_UPLOAD_PREFIX"); prefix != "" {
+		config.SoakUploadPrefix = prefix
+	}
+
+	if endpoint := os.Getenv("MINIO_ENDPOINT"); endpoint != "" {
+		config.MinioEndpoint = endpoint
+	}
+
+	if accessKey := os.Getenv("MINIO_ACCESS_KEY"); accessKey != "" {
+		config.MinioAccessKey = accessKey
 	}
 
 	if secretKey := os.Getenv("MINIO_SECRET_KEY"); secretKey != "" {
@@ -276,5 +1663,479 @@ func LoadConfig() (*Config, error) {
 		config.MinioBucket = bucket
 	}
 
+	// Cold archiver configuration
+	if groupID := os.Getenv("ARCHIVE_GROUP_ID"); groupID != "" {
+		config.ArchiveGroupID = groupID
+	}
+
+	if prefix := os.Getenv("ARCHIVE_PREFIX"); prefix != "" {
+		config.ArchivePrefix = prefix
+	}
+
+	if compression := os.Getenv("ARCHIVE_COMPRESSION"); compression != "" {
+		config.ArchiveCompression = strings.ToLower(compression)
+	}
+
+	if batchSize := os.Getenv("ARCHIVE_BATCH_SIZE"); batchSize != "" {
+		batchSizeInt, err := strconv.Atoi(batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARCHIVE_BATCH_SIZE: %w", err)
+		}
+		config.ArchiveBatchSize = batchSizeInt
+	}
+
+	if flushInterval := os.Getenv("ARCHIVE_FLUSH_INTERVAL"); flushInterval != "" {
+		flushIntervalDuration, err := time.ParseDuration(flushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARCHIVE_FLUSH_INTERVAL: %w", err)
+		}
+		config.ArchiveFlushInterval = flushIntervalDuration
+	}
+
+	if format := os.Getenv("ARCHIVE_FORMAT"); format != "" {
+		config.ArchiveFormat = strings.ToLower(format)
+	}
+
+	if rowGroupSize := os.Getenv("ARCHIVE_PARQUET_ROW_GROUP_SIZE"); rowGroupSize != "" {
+		rowGroupSizeInt, err := strconv.Atoi(rowGroupSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARCHIVE_PARQUET_ROW_GROUP_SIZE: %w", err)
+		}
+		if rowGroupSizeInt <= 0 {
+			return nil, fmt.Errorf("invalid ARCHIVE_PARQUET_ROW_GROUP_SIZE: must be positive")
+		}
+		config.ArchiveParquetRowGroupSize = rowGroupSizeInt
+	}
+
+	if catalog := os.Getenv("ARCHIVE_CATALOG_ENABLED"); catalog != "" {
+		catalogBool, err := strconv.ParseBool(catalog)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARCHIVE_CATALOG_ENABLED: %w", err)
+		}
+		config.ArchiveCatalogEnabled = catalogBool
+	}
+
+	// HTTP ingest configuration
+	if port := os.Getenv("HTTP_INGEST_PORT"); port != "" {
+		portInt, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP_INGEST_PORT: %w", err)
+		}
+		config.HTTPIngestPort = portInt
+	}
+
+	if batch := os.Getenv("HTTP_INGEST_MAX_BATCH"); batch != "" {
+		batchInt, err := strconv.Atoi(batch)
+		if err != nil || batchInt <= 0 {
+			return nil, fmt.Errorf("invalid HTTP_INGEST_MAX_BATCH: must be a positive integer")
+		}
+		config.HTTPIngestMaxBatch = batchInt
+	}
+
+	if body := os.Getenv("HTTP_INGEST_MAX_BODY_BYTES"); body != "" {
+		bodyInt, err := strconv.ParseInt(body, 10, 64)
+		if err != nil || bodyInt <= 0 {
+			return nil, fmt.Errorf("invalid HTTP_INGEST_MAX_BODY_BYTES: must be a positive integer")
+		}
+		config.HTTPIngestMaxBodyBytes = bodyInt
+	}
+
+	if store := os.Getenv("IDEMPOTENCY_STORE"); store != "" {
+		config.IdempotencyStore = strings.ToLower(store)
+	}
+
+	if ttl := os.Getenv("IDEMPOTENCY_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IDEMPOTENCY_TTL: %w", err)
+		}
+		config.IdempotencyTTL = ttlDuration
+	}
+
+	if skew := os.Getenv("ADMISSION_MAX_FUTURE_SKEW"); skew != "" {
+		skewDuration, err := time.ParseDuration(skew)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMISSION_MAX_FUTURE_SKEW: %w", err)
+		}
+		config.AdmissionMaxFutureSkew = skewDuration
+	}
+
+	if age := os.Getenv("ADMISSION_MAX_PAST_AGE"); age != "" {
+		ageDuration, err := time.ParseDuration(age)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMISSION_MAX_PAST_AGE: %w", err)
+		}
+		config.AdmissionMaxPastAge = ageDuration
+	}
+
+	if clamp := os.Getenv("ADMISSION_CLAMP_VALUES"); clamp != "" {
+		clampBool, err := strconv.ParseBool(clamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMISSION_CLAMP_VALUES: %w", err)
+		}
+		config.AdmissionClampValues = clampBool
+	}
+
+	if require := os.Getenv("ADMISSION_REQUIRE_REGISTERED"); require != "" {
+		requireBool, err := strconv.ParseBool(require)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMISSION_REQUIRE_REGISTERED: %w", err)
+		}
+		config.AdmissionRequireRegistered = requireBool
+	}
+
+	if sensors := os.Getenv("ADMISSION_REGISTERED_SENSORS"); sensors != "" {
+		config.AdmissionRegisteredSensors = strings.Split(sensors, ",")
+	}
+
+	// CoAP ingest configuration
+	if addr := os.Getenv("COAP_LISTEN_ADDR"); addr != "" {
+		config.CoAPListenAddr = addr
+	}
+
+	if inflight := os.Getenv("COAP_MAX_INFLIGHT"); inflight != "" {
+		inflightInt, err := strconv.Atoi(inflight)
+		if err != nil || inflightInt <= 0 {
+			return nil, fmt.Errorf("invalid COAP_MAX_INFLIGHT: must be a positive integer")
+		}
+		config.CoAPMaxInflight = inflightInt
+	}
+
+	if retryAfter := os.Getenv("COAP_RETRY_AFTER"); retryAfter != "" {
+		retryAfterDuration, err := time.ParseDuration(retryAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid COAP_RETRY_AFTER: %w", err)
+		}
+		config.CoAPRetryAfter = retryAfterDuration
+	}
+
+	// Sensor registry configuration
+	if port := os.Getenv("REGISTRY_PORT"); port != "" {
+		portInt, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REGISTRY_PORT: %w", err)
+		}
+		config.RegistryPort = portInt
+	}
+
+	if token := os.Getenv("REGISTRY_ADMIN_TOKEN"); token != "" {
+		config.RegistryAdminToken = token
+	}
+
+	if interval := os.Getenv("ANNOTATION_REFRESH_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ANNOTATION_REFRESH_INTERVAL: %w", err)
+		}
+		config.AnnotationRefreshInterval = intervalDuration
+	}
+
+	if interval := os.Getenv("PARTITION_SITE_REFRESH_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PARTITION_SITE_REFRESH_INTERVAL: %w", err)
+		}
+		config.PartitionSiteRefreshInterval = intervalDuration
+	}
+
+	if bySite := os.Getenv("PARTITION_BY_SITE"); bySite != "" {
+		bySiteBool, err := strconv.ParseBool(bySite)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PARTITION_BY_SITE: %w", err)
+		}
+		config.PartitionBySite = bySiteBool
+	}
+
+	if overlap := os.Getenv("CREDENTIAL_ROTATION_OVERLAP"); overlap != "" {
+		overlapDuration, err := time.ParseDuration(overlap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CREDENTIAL_ROTATION_OVERLAP: %w", err)
+		}
+		config.CredentialRotationOverlap = overlapDuration
+	}
+
+	if maxAge := os.Getenv("CREDENTIAL_MAX_AGE"); maxAge != "" {
+		maxAgeDuration, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CREDENTIAL_MAX_AGE: %w", err)
+		}
+		config.CredentialMaxAge = maxAgeDuration
+	}
+
+	if schedule := os.Getenv("CREDENTIAL_ENFORCE_SCHEDULE"); schedule != "" {
+		config.CredentialEnforceSchedule = schedule
+	}
+
+	if ttl := os.Getenv("AUTH_CACHE_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTH_CACHE_TTL: %w", err)
+		}
+		config.AuthCacheTTL = ttlDuration
+	}
+
+	// Archive encryption configuration
+	if mode := os.Getenv("ARCHIVE_ENCRYPTION_MODE"); mode != "" {
+		config.ArchiveEncryptionMode = strings.ToLower(mode)
+	}
+
+	if keys := os.Getenv("ARCHIVE_TENANT_KEYS"); keys != "" {
+		config.ArchiveTenantKeys = keys
+	}
+
+	if tenant := os.Getenv("ARCHIVE_DEFAULT_TENANT"); tenant != "" {
+		config.ArchiveDefaultTenant = tenant
+	}
+
+	// Column encryption configuration
+	if keys := os.Getenv("COLUMN_ENCRYPTION_KEYS"); keys != "" {
+		config.ColumnEncryptionKeys = keys
+	}
+
+	if columns := os.Getenv("COLUMN_ENCRYPTION_COLUMNS"); columns != "" {
+		config.ColumnEncryptionColumns = strings.Split(strings.ToLower(columns), ",")
+	}
+
+	if path := os.Getenv("COLUMN_ENCRYPTION_VAULT_PATH"); path != "" {
+		config.ColumnEncryptionVaultPath = path
+	}
+
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		config.VaultAddr = addr
+	}
+
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		config.VaultToken = token
+	}
+
+	// Query API configuration
+	if port := os.Getenv("API_PORT"); port != "" {
+		portInt, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_PORT: %w", err)
+		}
+		config.APIPort = portInt
+	}
+
+	if size := os.Getenv("API_DEFAULT_PAGE_SIZE"); size != "" {
+		sizeInt, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_DEFAULT_PAGE_SIZE: %w", err)
+		}
+		config.APIDefaultPageSize = sizeInt
+	}
+
+	if size := os.Getenv("API_MAX_PAGE_SIZE"); size != "" {
+		sizeInt, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_MAX_PAGE_SIZE: %w", err)
+		}
+		config.APIMaxPageSize = sizeInt
+	}
+
+	if ttl := os.Getenv("API_CACHE_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_CACHE_TTL: %w", err)
+		}
+		config.APICacheTTL = ttlDuration
+	}
+
+	if window := os.Getenv("API_SUMMARY_WINDOW"); window != "" {
+		windowDuration, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_SUMMARY_WINDOW: %w", err)
+		}
+		config.APISummaryWindow = windowDuration
+	}
+
+	if window := os.Getenv("API_ACTIVE_ALERT_WINDOW"); window != "" {
+		windowDuration, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_ACTIVE_ALERT_WINDOW: %w", err)
+		}
+		config.APIActiveAlertWindow = windowDuration
+	}
+
+	if port := os.Getenv("API_GRPC_PORT"); port != "" {
+		portInt, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_GRPC_PORT: %w", err)
+		}
+		config.APIGRPCPort = portInt
+	}
+
+	if size := os.Getenv("API_GRPC_BUFFER_SIZE"); size != "" {
+		sizeInt, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_GRPC_BUFFER_SIZE: %w", err)
+		}
+		config.APIGRPCBufferSize = sizeInt
+	}
+
+	if stream := os.Getenv("API_ALERT_STREAM"); stream != "" {
+		streamBool, err := strconv.ParseBool(stream)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_ALERT_STREAM: %w", err)
+		}
+		config.APIAlertStream = streamBool
+	}
+
+	if size := os.Getenv("API_ALERT_STREAM_BUFFER_SIZE"); size != "" {
+		sizeInt, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_ALERT_STREAM_BUFFER_SIZE: %w", err)
+		}
+		config.APIAlertStreamBufferSize = sizeInt
+	}
+
+	if validate := os.Getenv("API_VALIDATE_RESPONSES"); validate != "" {
+		validateBool, err := strconv.ParseBool(validate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_VALIDATE_RESPONSES: %w", err)
+		}
+		config.APIValidateResponses = validateBool
+	}
+
+	// Alert notifier configuration
+	if path := os.Getenv("NOTIFIER_CONFIG"); path != "" {
+		config.NotifierConfig = path
+	}
+
+	if groupID := os.Getenv("NOTIFIER_GROUP_ID"); groupID != "" {
+		config.NotifierGroupID = groupID
+	}
+
+	if timeout := os.Getenv("NOTIFIER_TIMEOUT"); timeout != "" {
+		timeoutDuration, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NOTIFIER_TIMEOUT: %w", err)
+		}
+		config.NotifierTimeout = timeoutDuration
+	}
+
+	if interval := os.Getenv("NOTIFIER_TEMPLATE_REFRESH_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NOTIFIER_TEMPLATE_REFRESH_INTERVAL: %w", err)
+		}
+		config.NotifierTemplateRefreshInterval = intervalDuration
+	}
+
+	if token := os.Getenv("NOTIFIER_ADMIN_TOKEN"); token != "" {
+		config.NotifierAdminToken = token
+	}
+
+	if source := os.Getenv("NOTIFIER_SOURCE"); source != "" {
+		switch source = strings.ToLower(source); source {
+		case "alerts", "notifications":
+			config.NotifierSource = source
+		default:
+			return nil, fmt.Errorf("invalid NOTIFIER_SOURCE: must be alerts or notifications")
+		}
+	}
+
+	// Aggregator configuration
+	if groupID := os.Getenv("AGGREGATOR_GROUP_ID"); groupID != "" {
+		config.AggregatorGroupID = groupID
+	}
+
+	if window := os.Getenv("AGGREGATOR_WINDOW"); window != "" {
+		windowDuration, err := time.ParseDuration(window)
+		if err != nil || windowDuration < time.Millisecond {
+			return nil, fmt.Errorf("invalid AGGREGATOR_WINDOW: must be a duration of at least 1ms")
+		}
+		config.AggregatorWindow = windowDuration
+	}
+
+	if grace := os.Getenv("AGGREGATOR_GRACE"); grace != "" {
+		graceDuration, err := time.ParseDuration(grace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AGGREGATOR_GRACE: %w", err)
+		}
+		config.AggregatorGrace = graceDuration
+	}
+
+	// Retention configuration
+	if interval := os.Getenv("RETENTION_INTERVAL"); interval != "" {
+		intervalDuration, err := time.ParseDuration(interval)
+		if err != nil || intervalDuration <= 0 {
+			return nil, fmt.Errorf("invalid RETENTION_INTERVAL: must be a positive duration")
+		}
+		config.RetentionInterval = intervalDuration
+	}
+
+	if dryRun := os.Getenv("RETENTION_DRY_RUN"); dryRun != "" {
+		dryRunBool, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_DRY_RUN: %w", err)
+		}
+		config.RetentionDryRun = dryRunBool
+	}
+
+	if batchSize := os.Getenv("RETENTION_BATCH_SIZE"); batchSize != "" {
+		batchSizeInt, err := strconv.Atoi(batchSize)
+		if err != nil || batchSizeInt <= 0 {
+			return nil, fmt.Errorf("invalid RETENTION_BATCH_SIZE: must be a positive integer")
+		}
+		config.RetentionBatchSize = batchSizeInt
+	}
+
+	if timeout := os.Getenv("RETENTION_TIMEOUT"); timeout != "" {
+		timeoutDuration, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_TIMEOUT: %w", err)
+		}
+		config.RetentionTimeout = timeoutDuration
+	}
+
+	if ttl := os.Getenv("RETENTION_READINGS_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_READINGS_TTL: %w", err)
+		}
+		config.RetentionReadingsTTL = ttlDuration
+	}
+
+	if ttl := os.Getenv("RETENTION_ALERTS_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_ALERTS_TTL: %w", err)
+		}
+		config.RetentionAlertsTTL = ttlDuration
+	}
+
+	if ttl := os.Getenv("RETENTION_AGGREGATES_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_AGGREGATES_TTL: %w", err)
+		}
+		config.RetentionAggregatesTTL = ttlDuration
+	}
+
+	if ttl := os.Getenv("RETENTION_ES_READINGS_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_ES_READINGS_TTL: %w", err)
+		}
+		config.RetentionESReadingsTTL = ttlDuration
+	}
+
+	if ttl := os.Getenv("RETENTION_ES_ALERTS_TTL"); ttl != "" {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_ES_ALERTS_TTL: %w", err)
+		}
+		config.RetentionESAlertsTTL = ttlDuration
+	}
+
+	if requireArchive := os.Getenv("RETENTION_REQUIRE_ARCHIVE"); requireArchive != "" {
+		requireArchiveBool, err := strconv.ParseBool(requireArchive)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETENTION_REQUIRE_ARCHIVE: %w", err)
+		}
+		config.RetentionRequireArchive = requireArchiveBool
+	}
+
 	return config, nil
 }
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Profiles selectable with APP_ENV
+const (
+	ProfileDev     = "dev"
+	ProfileStaging = "staging"
+	ProfileProd    = "prod"
+)
+
+// applyProfile changes the defaults for a deployment profile. It runs before
+// the environment is read, so explicitly set variables still win. An empty
+// profile keeps the built-in defaults.
+func applyProfile(config *Config, profile string) error {
+	switch profile {
+	case "":
+	case ProfileDev:
+		// A handful of sensors is enough on a laptop, and readings are
+		// decoded with the built-in schemas so no Schema Registry is needed
+		config.SensorCount = 10
+		config.SchemaRegistryURL = ""
+		// Catch API responses that drift from the OpenAPI document early
+		config.APIValidateResponses = true
+	case ProfileStaging:
+		config.ProducerRequiredAcks = -1 // WaitForAll
+		config.LogFormat = "json"
+	case ProfileProd:
+		config.ProducerRequiredAcks = -1 // WaitForAll
+		config.LogFormat = "json"
+		// Topics are provisioned with the cluster, not by whichever service starts first
+		config.KafkaCreateTopics = false
+	default:
+		return fmt.Errorf("invalid APP_ENV %q: expected %s, %s or %s", profile, ProfileDev, ProfileStaging, ProfileProd)
+	}
+	config.Profile = profile
+	return nil
+}
+
+// normalizeProfile maps common spellings of a profile to its name
+func normalizeProfile(profile string) string {
+	profile = strings.ToLower(strings.TrimSpace(profile))
+	switch profile {
+	case "development", "local":
+		return ProfileDev
+	case "stage":
+		return ProfileStaging
+	case "production":
+		return ProfileProd
+	}
+	return profile
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry components name the services whose producer and consumer retries
+// can be overridden
+const (
+	RetryComponentDetector     = "detector"
+	RetryComponentPostgresSink = "postgres_sink"
+	RetryComponentESSink       = "es_sink"
+	RetryComponentColdArchiver = "cold_archiver"
+	RetryComponentCorrelator   = "correlator"
+	RetryComponentAggregator   = "aggregator"
+	RetryComponentSimulator    = "simulator"
+	RetryComponentCapture      = "capture"
+	RetryComponentDLTReplayer  = "dlt_replayer"
+	RetryComponentNotifier     = "notifier"
+	RetryComponentIngest       = "ingest"
+	RetryComponentReplay       = "replay"
+)
+
+// RetryConfig holds the retry policy of producer sends or consumer handler attempts
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts, the first one included
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt, doubled
+	// after each further one up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter spreads each wait by up to this fraction either way
+	Jitter float64
+	// Deadline stops retrying once this long has passed since the first
+	// attempt (0 disables)
+	Deadline time.Duration
+}
+
+// defaultRetry returns the built-in retry policy
+func defaultRetry() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Jitter:         0.2,
+		Deadline:       2 * time.Minute,
+	}
+}
+
+// ProducerRetryFor returns the retry policy of component's producers
+func (c *Config) ProducerRetryFor(component string) RetryConfig {
+	if retry, ok := c.ProducerRetries[component]; ok {
+		return retry
+	}
+	return c.ProducerRetry
+}
+
+// ConsumerRetryFor returns the retry policy of component's consumers
+func (c *Config) ConsumerRetryFor(component string) RetryConfig {
+	if retry, ok := c.ConsumerRetries[component]; ok {
+		return retry
+	}
+	return c.ConsumerRetry
+}
+
+// loadRetries applies the <prefix>_RETRY_* settings to retry, then the
+// per-component overrides of <prefix>_RETRY_POLICIES on top of it
+func loadRetries(prefix string, retry *RetryConfig) (map[string]RetryConfig, error) {
+	if attempts := os.Getenv(prefix + "_RETRY_MAX_ATTEMPTS"); attempts != "" {
+		attemptsInt, err := strconv.Atoi(attempts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_RETRY_MAX_ATTEMPTS: %w", prefix, err)
+		}
+		retry.MaxAttempts = attemptsInt
+	}
+
+	if initial := os.Getenv(prefix + "_RETRY_INITIAL_BACKOFF"); initial != "" {
+		initialDuration, err := time.ParseDuration(initial)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_RETRY_INITIAL_BACKOFF: %w", prefix, err)
+		}
+		retry.InitialBackoff = initialDuration
+	}
+
+	if max := os.Getenv(prefix + "_RETRY_MAX_BACKOFF"); max != "" {
+		maxDuration, err := time.ParseDuration(max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_RETRY_MAX_BACKOFF: %w", prefix, err)
+		}
+		retry.MaxBackoff = maxDuration
+	}
+
+	if jitter := os.Getenv(prefix + "_RETRY_JITTER"); jitter != "" {
+		jitterFloat, err := strconv.ParseFloat(jitter, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_RETRY_JITTER: %w", prefix, err)
+		}
+		retry.Jitter = jitterFloat
+	}
+
+	if deadline := os.Getenv(prefix + "_RETRY_DEADLINE"); deadline != "" {
+		deadlineDuration, err := time.ParseDuration(deadline)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_RETRY_DEADLINE: %w", prefix, err)
+		}
+		retry.Deadline = deadlineDuration
+	}
+
+	retries := make(map[string]RetryConfig)
+	if spec := os.Getenv(prefix + "_RETRY_POLICIES"); spec != "" {
+		if err := applyRetrySpec(retries, *retry, spec); err != nil {
+			return nil, fmt.Errorf("invalid %s_RETRY_POLICIES: %w", prefix, err)
+		}
+	}
+	return retries, nil
+}
+
+// applyRetrySpec applies "component=attempts:5,initial:200ms,max:5s,jitter:0.1,deadline:5m;..."
+// Settings a component does not list are taken from base.
+func applyRetrySpec(retries map[string]RetryConfig, base RetryConfig, spec string) error {
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		component, list, ok := strings.Cut(entry, "=")
+		component = strings.TrimSpace(component)
+		if !ok || component == "" {
+			return fmt.Errorf("invalid retry policy %q: expected component=setting:value,...", entry)
+		}
+
+		retry, ok := retries[component]
+		if !ok {
+			retry = base
+		}
+		for _, pair := range strings.Split(list, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return fmt.Errorf("invalid setting for component %s: %q is not name:value", component, pair)
+			}
+			value = strings.TrimSpace(value)
+
+			var err error
+			switch strings.TrimSpace(name) {
+			case "attempts":
+				retry.MaxAttempts, err = strconv.Atoi(value)
+			case "initial":
+				retry.InitialBackoff, err = time.ParseDuration(value)
+			case "max":
+				retry.MaxBackoff, err = time.ParseDuration(value)
+			case "jitter":
+				retry.Jitter, err = strconv.ParseFloat(value, 64)
+			case "deadline":
+				retry.Deadline, err = time.ParseDuration(value)
+			default:
+				return fmt.Errorf("invalid setting for component %s: unknown setting %q", component, name)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid %s for component %s: %q", strings.TrimSpace(name), component, value)
+			}
+		}
+		retries[component] = retry
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Topic keys name the topics of the pipeline independently of their Kafka names
+const (
+	TopicKeySensorRaw      = "sensor_raw"
+	TopicKeySensorPriority = "sensor_raw_priority"
+	TopicKeySensorAlert    = "sensor_alert"
+	TopicKeySensorRawDLT   = "sensor_raw_dlt"
+	TopicKeySensorReject   = "sensor_rejects"
+	TopicKeyFleetAlert     = "fleet_alert"
+	TopicKeySiteAlert      = "site_alert"
+	TopicKeyNotification   = "notification"
+	TopicKeyCapture        = "capture"
+	TopicKeyDecisions      = "decisions"
+	TopicKeyShadowAlert    = "shadow_alert"
+	TopicKeyAuthority      = "detector_authority"
+	TopicKeyHeartbeat      = "detector_heartbeat"
+	TopicKeySensorAgg      = "sensor_agg"
+)
+
+// TopicConfig holds the settings of one topic
+type TopicConfig struct {
+	// Name is the Kafka topic name
+	Name string
+	// Partitions and Retention are applied when the topic is created; topics
+	// with 0 partitions are left for the broker to create, and 0 retention
+	// keeps the broker default
+	Partitions int32
+	Retention  time.Duration
+	// Serde lists the wire formats of readings on the topic in the order
+	// they are tried, e.g. "confluent,avro,json" (empty tries all formats)
+	Serde string
+	// DLT is the key of the topic undecodable messages are sent to
+	DLT string
+	// Compact creates the topic with log compaction, keeping the last
+	// message of each key instead of deleting by retention
+	Compact bool
+}
+
+// defaultTopics returns the built-in topics
+func defaultTopics() map[string]TopicConfig {
+	week := 7 * 24 * time.Hour
+	return map[string]TopicConfig{
+		TopicKeySensorRaw:      {Name: "sensor.raw", Partitions: 6, Retention: week, DLT: TopicKeySensorRawDLT},
+		TopicKeySensorPriority: {Name: "sensor.raw.priority", Partitions: 3, Retention: week, DLT: TopicKeySensorRawDLT},
+		TopicKeySensorAlert:    {Name: "sensor.alert", Partitions: 3, Retention: week},
+		TopicKeySensorRawDLT:   {Name: "sensor.raw.dlt", Partitions: 1, Retention: 30 * 24 * time.Hour},
+		TopicKeySensorReject:   {Name: "sensor.rejects", Partitions: 1, Retention: week},
+		TopicKeyFleetAlert:     {Name: "fleet.alert", Partitions: 1, Retention: week},
+		TopicKeySiteAlert:      {Name: "site.alert", Partitions: 3, Retention: week},
+		TopicKeyNotification:   {Name: "sensor.notify", Partitions: 3, Retention: week},
+		TopicKeyCapture:        {Name: "sensor.capture", Partitions: 1, Retention: 24 * time.Hour},
+		TopicKeyDecisions:      {Name: "sensor.decisions", Partitions: 1, Retention: 24 * time.Hour},
+		TopicKeyShadowAlert:    {Name: "sensor.alert.shadow", Partitions: 3, Retention: 24 * time.Hour},
+		TopicKeyAuthority:      {Name: "detector.authority", Partitions: 1, Compact: true},
+		TopicKeyHeartbeat:      {Name: "detector.heartbeat", Partitions: 1, Retention: time.Hour},
+		TopicKeySensorAgg:      {Name: "sensor.agg", Partitions: 3, Retention: 30 * 24 * time.Hour},
+	}
+}
+
+// Topic returns the Kafka name of the topic with key; unknown keys are a
+// programming error and panic
+func (c *Config) Topic(key string) string {
+	topic, ok := c.Topics[key]
+	if !ok {
+		panic(fmt.Sprintf("unknown topic key %q", key))
+	}
+	return topic.Name
+}
+
+// TopicDLT returns the Kafka name of the dead-letter topic paired with key,
+// or an empty string if the topic has none
+func (c *Config) TopicDLT(key string) string {
+	dlt := c.Topics[key].DLT
+	if dlt == "" {
+		return ""
+	}
+	return c.Topic(dlt)
+}
+
+// RawTopics returns the topics readings are produced to: the raw topic and,
+// when PRIORITY_SENSORS lists sensors, the priority topic
+func (c *Config) RawTopics() []string {
+	if len(c.PrioritySensors) == 0 {
+		return []string{c.Topic(TopicKeySensorRaw)}
+	}
+	return []string{c.Topic(TopicKeySensorRaw), c.Topic(TopicKeySensorPriority)}
+}
+
+// RawTopicWeights returns the worker weights of the raw topics for
+// consumers of readings, or nil when there is no priority topic
+func (c *Config) RawTopicWeights() map[string]int {
+	if len(c.PrioritySensors) == 0 {
+		return nil
+	}
+	return map[string]int{c.Topic(TopicKeySensorRaw): 1, c.Topic(TopicKeySensorPriority): c.PriorityWeight}
+}
+
+// ReadingTopics returns the topic the readings of each sensor are produced
+// to when it is not the raw topic, keyed by sensor ID
+func (c *Config) ReadingTopics() map[string]string {
+	topics := make(map[string]string, len(c.PrioritySensors))
+	for _, id := range c.PrioritySensors {
+		if id = strings.TrimSpace(id); id != "" {
+			topics[id] = c.Topic(TopicKeySensorPriority)
+		}
+	}
+	return topics
+}
+
+// TopicFormats returns the wire formats of the topics that declare a serde,
+// keyed by Kafka topic name
+func (c *Config) TopicFormats() map[string][]string {
+	formats := make(map[string][]string)
+	for _, topic := range c.Topics {
+		if topic.Serde == "" {
+			continue
+		}
+		for _, format := range strings.Split(topic.Serde, ",") {
+			formats[topic.Name] = append(formats[topic.Name], strings.ToLower(strings.TrimSpace(format)))
+		}
+	}
+	return formats
+}
+
+// TopicKeys returns the topic keys in a stable order
+func (c *Config) TopicKeys() []string {
+	keys := make([]string, 0, len(c.Topics))
+	for key := range c.Topics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// loadTopics applies topic overrides from the environment: TOPIC_<KEY> renames
+// a topic, TOPIC_FORMATS sets serdes by topic name, and TOPICS, which takes
+// precedence, sets any setting and adds topics
+func loadTopics(topics map[string]TopicConfig) error {
+	for key, topic := range topics {
+		if name := os.Getenv("TOPIC_" + strings.ToUpper(key)); name != "" {
+			topic.Name = name
+			topics[key] = topic
+		}
+	}
+
+	if spec := os.Getenv("TOPIC_FORMATS"); spec != "" {
+		if err := applyTopicFormats(topics, spec); err != nil {
+			return fmt.Errorf("invalid TOPIC_FORMATS: %w", err)
+		}
+	}
+
+	if spec := os.Getenv("TOPICS"); spec != "" {
+		if err := applyTopicSpec(topics, spec); err != nil {
+			return fmt.Errorf("invalid TOPICS: %w", err)
+		}
+	}
+
+	for key, topic := range topics {
+		if topic.DLT == "" {
+			continue
+		}
+		if _, ok := topics[topic.DLT]; !ok {
+			return fmt.Errorf("topic %s pairs with unknown DLT topic %q", key, topic.DLT)
+		}
+	}
+	return nil
+}
+
+// applyTopicSpec applies "key=name:x,partitions:6,retention:168h,serde:avro|json,dlt:key,compact:true;..."
+// Unknown keys add topics; settings a topic does not list are kept.
+func applyTopicSpec(topics map[string]TopicConfig, spec string) error {
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, list, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("invalid topic %q: expected key=setting:value,...", entry)
+		}
+
+		topic := topics[key]
+		for _, pair := range strings.Split(list, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return fmt.Errorf("invalid setting for topic %s: %q is not name:value", key, pair)
+			}
+			value = strings.TrimSpace(value)
+
+			switch strings.TrimSpace(name) {
+			case "name":
+				topic.Name = value
+			case "partitions":
+				partitions, err := strconv.ParseInt(value, 10, 32)
+				if err != nil || partitions < 0 {
+					return fmt.Errorf("invalid partitions for topic %s: %q", key, value)
+				}
+				topic.Partitions = int32(partitions)
+			case "retention":
+				retention, err := time.ParseDuration(value)
+				if err != nil {
+					return fmt.Errorf("invalid retention for topic %s: %w", key, err)
+				}
+				topic.Retention = retention
+			case "serde":
+				// Formats are separated by | since , separates settings
+				topic.Serde = strings.ReplaceAll(value, "|", ",")
+			case "dlt":
+				topic.DLT = value
+			case "compact":
+				compact, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("invalid compact for topic %s: %w", key, err)
+				}
+				topic.Compact = compact
+			default:
+				return fmt.Errorf("invalid setting for topic %s: unknown setting %q", key, name)
+			}
+		}
+
+		if topic.Name == "" {
+			return fmt.Errorf("topic %s has no name", key)
+		}
+		topics[key] = topic
+	}
+	return nil
+}
+
+// applyTopicFormats applies the legacy "topic=fmt,fmt;..." spec keyed by Kafka
+// topic name to the serde of the matching topics
+func applyTopicFormats(topics map[string]TopicConfig, spec string) error {
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, formats, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || formats == "" {
+			return fmt.Errorf("invalid topic format entry: %q", entry)
+		}
+
+		matched := false
+		for key, topic := range topics {
+			if topic.Name == name {
+				topic.Serde = formats
+				topics[key] = topic
+				matched = true
+			}
+		}
+		if !matched {
+			// Formats of topics outside the map are still honoured by decoders
+			topics[name] = TopicConfig{Name: name, Serde: formats}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package cutover
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Detector deployment colors
+const (
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+)
+
+// defaultLoadTimeout bounds reading the current authority when a watcher starts
+const defaultLoadTimeout = 30 * time.Second
+
+// Authority says which detector color's alerts reach the alert topic. It is
+// kept in the compacted authority topic, keyed by the raw topic the
+// detectors consume, so the latest record is the one in force.
+type Authority struct {
+	// Color owns the alert topic for readings at or after Offsets
+	Color string `json:"color"`
+	// Previous owns it for readings before Offsets
+	Previous string `json:"previous,omitempty"`
+	// Offsets are the raw topic offsets, by partition, from which Color is
+	// authoritative; partitions without one are Color's from the start
+	Offsets    map[int32]int64 `json:"offsets,omitempty"`
+	SwitchedAt time.Time       `json:"switched_at"`
+}
+
+// Owner returns the color whose alert for the reading at offset of partition
+// reaches the alert topic
+func (a *Authority) Owner(partition int32, offset int64) string {
+	if cut, ok := a.Offsets[partition]; ok && offset < cut && a.Previous != "" {
+		return a.Previous
+	}
+	return a.Color
+}
+
+// ValidColor reports whether color is a deployment color
+func ValidColor(color string) bool {
+	return color == ColorBlue || color == ColorGreen
+}
+
+// Other returns the other deployment color
+func Other(color string) string {
+	if color == ColorBlue {
+		return ColorGreen
+	}
+	return ColorBlue
+}
+
+// decodeAuthority decodes an authority record; a tombstone decodes as nil
+func decodeAuthority(value []byte) (*Authority, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	var authority Authority
+	if err := json.Unmarshal(value, &authority); err != nil {
+		return nil, fmt.Errorf("failed to decode authority record: %w", err)
+	}
+	if !ValidColor(authority.Color) || (authority.Previous != "" && !ValidColor(authority.Previous)) {
+		return nil, fmt.Errorf("invalid authority record: unknown color %q or %q", authority.Color, authority.Previous)
+	}
+	return &authority, nil
+}
+
+// WatcherMetrics holds Prometheus metrics for the authority a detector follows
+type WatcherMetrics struct {
+	Leading prometheus.Gauge
+	Changes prometheus.Counter
+}
+
+// NewWatcherMetrics creates a new set of authority watcher metrics
+func NewWatcherMetrics(namespace, subsystem string, registry prometheus.Registerer) *WatcherMetrics {
+	metrics := &WatcherMetrics{
+		Leading: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "authoritative",
+			Help:      "1 while this detector's color owns the alert topic for new readings, 0 while it runs in shadow",
+		}),
+		Changes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "authority_changes_total",
+			Help:      "Total number of authority records received after start",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Leading,
+		metrics.Changes,
+	)
+
+	return metrics
+}
+
+// WatcherConfig holds the settings of an authority watcher
+type WatcherConfig struct {
+	Brokers []string
+	// Topic is the authority topic, and Key the raw topic whose authority
+	// records are followed
+	Topic string
+	Key   string
+	// Color is the color of the detector
+	Color string
+	// LoadTimeout bounds reading the current authority at start (0 uses 30s)
+	LoadTimeout time.Duration
+	Metrics     *WatcherMetrics
+	Logger      *slog.Logger
+}
+
+// Watcher follows the authority topic so a detector knows, reading by
+// reading, whether its alerts go to the alert topic or the shadow topic
+type Watcher struct {
+	config   WatcherConfig
+	client   sarama.Client
+	consumer sarama.Consumer
+	logger   *slog.Logger
+
+	current atomic.Pointer[Authority]
+
+	// seen is the highest raw offset handled per partition, so a cutover
+	// that arrives too late to take effect exactly can be reported
+	mu   sync.Mutex
+	seen map[int32]int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWatcher creates a watcher connected to the brokers
+func NewWatcher(config WatcherConfig, opts ...kafka.OptionFunc) (*Watcher, error) {
+	if !ValidColor(config.Color) {
+		return nil, fmt.Errorf("invalid detector color %q: expected %s or %s", config.Color, ColorBlue, ColorGreen)
+	}
+	if config.LoadTimeout <= 0 {
+		config.LoadTimeout = defaultLoadTimeout
+	}
+
+	saramaConfig := sarama.NewConfig()
+	for _, opt := range opts {
+		opt(saramaConfig)
+	}
+	client, err := sarama.NewClient(config.Brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Watcher{
+		config: config,
+		client: client,
+		logger: logging.OrDefault(config.Logger).With("color", config.Color),
+		seen:   make(map[int32]int64),
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// NewWatcherFromConfig creates the watcher of a detector deployed with
+// DETECTOR_COLOR. It returns nil, nil when the color is empty.
+func NewWatcherFromConfig(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Watcher, error) {
+	if cfg.DetectorColor == "" {
+		return nil, nil
+	}
+	if !ValidColor(cfg.DetectorColor) {
+		return nil, fmt.Errorf("invalid DETECTOR_COLOR %q: expected %s or %s", cfg.DetectorColor, ColorBlue, ColorGreen)
+	}
+
+	opts := []kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Kafka security settings: %w", err)
+	}
+
+	watcher, err := NewWatcher(WatcherConfig{
+		Brokers: cfg.KafkaBrokers,
+		Topic:   cfg.Topic(config.TopicKeyAuthority),
+		Key:     cfg.Topic(config.TopicKeySensorRaw),
+		Color:   cfg.DetectorColor,
+		Metrics: NewWatcherMetrics("iot", "anomaly_detector", registry),
+		Logger:  logger,
+	}, append(opts, security...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authority watcher: %w", err)
+	}
+	return watcher, nil
+}
+
+// Color returns the color of the detector
+func (w *Watcher) Color() string {
+	return w.config.Color
+}
+
+// Current returns the authority in force, or nil if none was published
+func (w *Watcher) Current() *Authority {
+	return w.current.Load()
+}
+
+// Start reads the authority topic to its end, so the detector does not
+// handle a reading under a stale authority, and then follows it in the
+// background
+func (w *Watcher) Start() error {
+	end, err := w.client.GetOffset(w.config.Topic, 0, sarama.OffsetNewest)
+	if err != nil {
+		return fmt.Errorf("failed to get end offset of %s: %w", w.config.Topic, err)
+	}
+	start, err := w.client.GetOffset(w.config.Topic, 0, sarama.OffsetOldest)
+	if err != nil {
+		return fmt.Errorf("failed to get oldest offset of %s: %w", w.config.Topic, err)
+	}
+	consumer, err := sarama.NewConsumerFromClient(w.client)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %w", err)
+	}
+	pc, err := consumer.ConsumePartition(w.config.Topic, 0, sarama.OffsetOldest)
+	if err != nil {
+		consumer.Close()
+		return fmt.Errorf("failed to consume %s: %w", w.config.Topic, err)
+	}
+	w.consumer = consumer
+
+	loaded := make(chan struct{})
+	loading := start < end
+	if !loading {
+		close(loaded)
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer pc.Close()
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case err := <-pc.Errors():
+				w.logger.Error("Failed to read authority topic", "topic", w.config.Topic, "error", err)
+			case message := <-pc.Messages():
+				w.apply(message, !loading)
+				if loading && message.Offset+1 >= end {
+					loading = false
+					close(loaded)
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-loaded:
+	case <-time.After(w.config.LoadTimeout):
+		return fmt.Errorf("timed out reading the authority topic %s", w.config.Topic)
+	}
+	w.logState()
+	return nil
+}
+
+// Stop stops following the authority topic and closes the Kafka client
+func (w *Watcher) Stop() {
+	w.cancel()
+	w.wg.Wait()
+	if w.consumer != nil {
+		w.consumer.Close()
+	}
+	w.client.Close()
+}
+
+// Authoritative reports whether the detector's alert for a raw message goes
+// to the alert topic. Without an authority record every detector is
+// authoritative, so a first deployment works before any cutover.
+func (w *Watcher) Authoritative(message *sarama.ConsumerMessage) bool {
+	w.mu.Lock()
+	if offset, ok := w.seen[message.Partition]; !ok || message.Offset > offset {
+		w.seen[message.Partition] = message.Offset
+	}
+	w.mu.Unlock()
+
+	authority := w.current.Load()
+	return authority == nil || authority.Owner(message.Partition, message.Offset) == w.config.Color
+}
+
+// Leading reports whether the detector's color owns the alert topic for new
+// readings, for alerts not raised by one reading such as fleet rate alerts
+func (w *Watcher) Leading() bool {
+	authority := w.current.Load()
+	return authority == nil || authority.Color == w.config.Color
+}
+
+// apply makes an authority record the one in force. live is false while
+// the topic is read to its end at start.
+func (w *Watcher) apply(message *sarama.ConsumerMessage, live bool) {
+	if string(message.Key) != w.config.Key {
+		return
+	}
+	authority, err := decodeAuthority(message.Value)
+	if err != nil {
+		w.logger.Error("Ignoring authority record", "offset", message.Offset, "error", err)
+		return
+	}
+	w.current.Store(authority)
+	if !live {
+		return
+	}
+
+	if w.config.Metrics != nil {
+		w.config.Metrics.Changes.Inc()
+	}
+	if authority != nil {
+		w.checkPassed(authority)
+	}
+	w.logState()
+}
+
+// checkPassed reports partitions whose cutover offset the detector already
+// handled readings beyond: their alerts were routed under the previous
+// authority, so the two colors may have duplicated or both skipped some
+func (w *Watcher) checkPassed(authority *Authority) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for partition, cut := range authority.Offsets {
+		if seen, ok := w.seen[partition]; ok && seen >= cut {
+			w.logger.Error("Cutover offset already passed; alerts around it may be duplicated or missed",
+				"partition", partition, "cutover_offset", cut, "handled_offset", seen)
+		}
+	}
+}
+
+// logState logs and exports the authority in force
+func (w *Watcher) logState() {
+	leading := w.Leading()
+	if w.config.Metrics != nil {
+		value := 0.0
+		if leading {
+			value = 1
+		}
+		w.config.Metrics.Leading.Set(value)
+	}
+
+	authority := w.current.Load()
+	if authority == nil {
+		w.logger.Info("No detector authority published, alerting as authoritative")
+		return
+	}
+	w.logger.Info("Detector authority in force", "authority", authority.Color, "previous", authority.Previous,
+		"offsets", authority.Offsets, "leading", leading)
+}
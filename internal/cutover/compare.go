@@ -0,0 +1,127 @@
+package cutover
+
+import (
+	"sort"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// alertKey identifies the alert of one reading and rule in both streams
+type alertKey struct {
+	SensorID  string
+	Timestamp int64
+	Rule      string
+}
+
+// AlertDiff is an alert only one stream has
+type AlertDiff struct {
+	// Stream is "authoritative" or "shadow"
+	Stream    string `json:"stream"`
+	SensorID  string `json:"sensor_id"`
+	Timestamp int64  `json:"timestamp"`
+	Rule      string `json:"rule"`
+	Reason    string `json:"reason"`
+}
+
+// RuleComparison counts the alerts of one rule in both streams
+type RuleComparison struct {
+	Rule              string `json:"rule"`
+	Matched           int    `json:"matched"`
+	OnlyAuthoritative int    `json:"only_authoritative"`
+	OnlyShadow        int    `json:"only_shadow"`
+}
+
+// ComparisonReport is the outcome of comparing the alert streams of the
+// authoritative and the shadow detector
+type ComparisonReport struct {
+	Matched           int `json:"matched"`
+	OnlyAuthoritative int `json:"only_authoritative"`
+	OnlyShadow        int `json:"only_shadow"`
+	// DiffRatio is the share of alerts only one stream has
+	DiffRatio float64          `json:"diff_ratio"`
+	Rules     []RuleComparison `json:"rules"`
+	Samples   []AlertDiff      `json:"samples,omitempty"`
+}
+
+// Comparison matches the alerts of two detectors reading by reading: alerts
+// of the same sensor, reading timestamp and rule match, whatever their
+// reason text or annotations
+type Comparison struct {
+	authoritative map[alertKey][]*model.SensorAlert
+	shadow        map[alertKey][]*model.SensorAlert
+}
+
+// NewComparison creates an empty comparison
+func NewComparison() *Comparison {
+	return &Comparison{
+		authoritative: make(map[alertKey][]*model.SensorAlert),
+		shadow:        make(map[alertKey][]*model.SensorAlert),
+	}
+}
+
+// Add records an alert of the authoritative stream, or of the shadow stream
+// if shadow is set
+func (c *Comparison) Add(alert *model.SensorAlert, shadow bool) {
+	key := alertKey{SensorID: alert.SensorID, Timestamp: alert.Timestamp, Rule: alert.Rule}
+	if shadow {
+		c.shadow[key] = append(c.shadow[key], alert)
+	} else {
+		c.authoritative[key] = append(c.authoritative[key], alert)
+	}
+}
+
+// Report counts matched and unmatched alerts and keeps up to samples of the
+// unmatched ones. Alerts repeated in one stream, e.g. by producer retries,
+// match as many times as the other stream repeats them.
+func (c *Comparison) Report(samples int) ComparisonReport {
+	var report ComparisonReport
+	rules := make(map[string]*RuleComparison)
+	rule := func(name string) *RuleComparison {
+		if rules[name] == nil {
+			rules[name] = &RuleComparison{Rule: name}
+		}
+		return rules[name]
+	}
+
+	for key, alerts := range c.authoritative {
+		matched := min(len(alerts), len(c.shadow[key]))
+		rule(key.Rule).Matched += matched
+		report.Matched += matched
+		for _, alert := range alerts[matched:] {
+			rule(key.Rule).OnlyAuthoritative++
+			report.OnlyAuthoritative++
+			report.Samples = append(report.Samples, diff("authoritative", alert))
+		}
+	}
+	for key, alerts := range c.shadow {
+		matched := min(len(alerts), len(c.authoritative[key]))
+		for _, alert := range alerts[matched:] {
+			rule(key.Rule).OnlyShadow++
+			report.OnlyShadow++
+			report.Samples = append(report.Samples, diff("shadow", alert))
+		}
+	}
+
+	if total := report.Matched + report.OnlyAuthoritative + report.OnlyShadow; total > 0 {
+		report.DiffRatio = float64(report.OnlyAuthoritative+report.OnlyShadow) / float64(total)
+	}
+	for _, r := range rules {
+		report.Rules = append(report.Rules, *r)
+	}
+	sort.Slice(report.Rules, func(i, j int) bool { return report.Rules[i].Rule < report.Rules[j].Rule })
+	sort.Slice(report.Samples, func(i, j int) bool {
+		if report.Samples[i].Timestamp != report.Samples[j].Timestamp {
+			return report.Samples[i].Timestamp < report.Samples[j].Timestamp
+		}
+		return report.Samples[i].SensorID < report.Samples[j].SensorID
+	})
+	if len(report.Samples) > samples {
+		report.Samples = report.Samples[:samples]
+	}
+	return report
+}
+
+// diff describes an unmatched alert of stream
+func diff(stream string, alert *model.SensorAlert) AlertDiff {
+	return AlertDiff{Stream: stream, SensorID: alert.SensorID, Timestamp: alert.Timestamp, Rule: alert.Rule, Reason: alert.Reason}
+}
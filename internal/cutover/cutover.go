@@ -0,0 +1,425 @@
+package cutover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// ErrCutoverInProgress is returned when switching again before the
+// detector giving up authority has passed the offsets of the last switch
+var ErrCutoverInProgress = errors.New("previous cutover still in progress")
+
+// OrchestratorConfig holds the settings of a cutover orchestrator
+type OrchestratorConfig struct {
+	Brokers []string
+	// RawTopic is the topic the detectors consume, which keys their
+	// authority records in AuthorityTopic
+	RawTopic       string
+	AlertTopic     string
+	ShadowTopic    string
+	AuthorityTopic string
+	// Groups are the consumer groups of the detectors by color
+	Groups map[string]string
+	Logger *slog.Logger
+}
+
+// Orchestrator runs the steps of a blue/green detector deployment: it
+// starts the new color's group from the old one's offsets, compares the
+// alerts of both, and moves the alert topic authority between them at
+// offsets neither has reached, so each reading's alert is sent by exactly
+// one color
+type Orchestrator struct {
+	config       OrchestratorConfig
+	client       sarama.Client
+	producer     sarama.SyncProducer
+	checkpointer *kafka.OffsetCheckpointer
+	logger       *slog.Logger
+}
+
+// NewOrchestrator creates an orchestrator connected to the brokers
+func NewOrchestrator(config OrchestratorConfig, opts ...kafka.OptionFunc) (*Orchestrator, error) {
+	for _, color := range []string{ColorBlue, ColorGreen} {
+		if config.Groups[color] == "" {
+			return nil, fmt.Errorf("no consumer group for %s", color)
+		}
+	}
+	if config.Groups[ColorBlue] == config.Groups[ColorGreen] {
+		return nil, fmt.Errorf("%s and %s must use different consumer groups", ColorBlue, ColorGreen)
+	}
+	logger := logging.OrDefault(config.Logger)
+
+	saramaConfig := sarama.NewConfig()
+	for _, opt := range opts {
+		opt(saramaConfig)
+	}
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	saramaConfig.Producer.Return.Successes = true
+
+	client, err := sarama.NewClient(config.Brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+	checkpointer, err := kafka.NewOffsetCheckpointer(config.Brokers, logger, opts...)
+	if err != nil {
+		producer.Close()
+		client.Close()
+		return nil, err
+	}
+
+	return &Orchestrator{
+		config:       config,
+		client:       client,
+		producer:     producer,
+		checkpointer: checkpointer,
+		logger:       logger,
+	}, nil
+}
+
+// NewOrchestratorFromConfig creates an orchestrator for the configured
+// topics; blueGroup and greenGroup are the consumer groups of the detectors
+func NewOrchestratorFromConfig(cfg *config.Config, blueGroup, greenGroup string, logger *slog.Logger) (*Orchestrator, error) {
+	opts := []kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Kafka security settings: %w", err)
+	}
+	return NewOrchestrator(OrchestratorConfig{
+		Brokers:        cfg.KafkaBrokers,
+		RawTopic:       cfg.Topic(config.TopicKeySensorRaw),
+		AlertTopic:     cfg.Topic(config.TopicKeySensorAlert),
+		ShadowTopic:    cfg.Topic(config.TopicKeyShadowAlert),
+		AuthorityTopic: cfg.Topic(config.TopicKeyAuthority),
+		Groups:         map[string]string{ColorBlue: blueGroup, ColorGreen: greenGroup},
+		Logger:         logger,
+	}, append(opts, security...)...)
+}
+
+// Close releases the Kafka clients
+func (o *Orchestrator) Close() error {
+	o.checkpointer.Close()
+	o.producer.Close()
+	return o.client.Close()
+}
+
+// Current reads the authority in force, or nil if none was published
+func (o *Orchestrator) Current() (*Authority, error) {
+	end, err := o.client.GetOffset(o.config.AuthorityTopic, 0, sarama.OffsetNewest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get end offset of %s: %w", o.config.AuthorityTopic, err)
+	}
+	start, err := o.client.GetOffset(o.config.AuthorityTopic, 0, sarama.OffsetOldest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get oldest offset of %s: %w", o.config.AuthorityTopic, err)
+	}
+
+	var current *Authority
+	err = o.read(context.Background(), o.config.AuthorityTopic, 0, start, end, func(message *sarama.ConsumerMessage) {
+		if string(message.Key) != o.config.RawTopic {
+			return
+		}
+		authority, err := decodeAuthority(message.Value)
+		if err != nil {
+			o.logger.Warn("Ignoring authority record", "offset", message.Offset, "error", err)
+			return
+		}
+		current = authority
+	})
+	return current, err
+}
+
+// publish makes authority the one in force
+func (o *Orchestrator) publish(authority *Authority) error {
+	data, err := json.Marshal(authority)
+	if err != nil {
+		return err
+	}
+	_, _, err = o.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: o.config.AuthorityTopic,
+		Key:   sarama.StringEncoder(o.config.RawTopic),
+		Value: sarama.ByteEncoder(data),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish authority record: %w", err)
+	}
+	o.logger.Info("Published detector authority", "authority", authority.Color, "previous", authority.Previous, "offsets", authority.Offsets)
+	return nil
+}
+
+// Prepare copies the committed offsets of the authoritative color's group to
+// the other color's group, so a detector started in it resumes where the
+// authoritative one is instead of at its initial offset. The other color's
+// detectors must be stopped. Without an authority record yet, from is made
+// authoritative first, so the new detector starts in shadow.
+func (o *Orchestrator) Prepare(from string, dryRun bool) (kafka.ImportResult, error) {
+	if !ValidColor(from) {
+		return kafka.ImportResult{}, fmt.Errorf("invalid color %q", from)
+	}
+	to := Other(from)
+
+	current, err := o.Current()
+	if err != nil {
+		return kafka.ImportResult{}, err
+	}
+	if current != nil && current.Color != from {
+		return kafka.ImportResult{}, fmt.Errorf("%s is authoritative, not %s", current.Color, from)
+	}
+
+	checkpoint, err := o.checkpointer.Export([]kafka.LagGroup{{Group: o.config.Groups[from], Topics: []string{o.config.RawTopic}}})
+	if err != nil {
+		return kafka.ImportResult{}, err
+	}
+	if len(checkpoint.Groups) == 0 || len(checkpoint.Groups[0].Topics) == 0 || len(checkpoint.Groups[0].Topics[0].Partitions) == 0 {
+		return kafka.ImportResult{}, fmt.Errorf("group %s has no committed offsets on %s", o.config.Groups[from], o.config.RawTopic)
+	}
+
+	result, err := o.checkpointer.Import(checkpoint, kafka.ImportOptions{
+		RenameGroups: map[string]string{o.config.Groups[from]: o.config.Groups[to]},
+		DryRun:       dryRun,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if current == nil && !dryRun {
+		if err := o.publish(&Authority{Color: from, SwitchedAt: time.Now().UTC()}); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Switch moves the alert topic authority to color at offsets lead ahead of
+// the end of every raw partition, estimating each partition's rate over
+// sample. Both detectors must receive the record before they reach those
+// offsets, so lead must exceed their lag and the authority topic's delivery
+// time; a detector that was already past them logs an error. The previous
+// color stays authoritative for the readings before the offsets and can be
+// stopped once Progress reports it passed them.
+func (o *Orchestrator) Switch(ctx context.Context, color string, lead, sample time.Duration) (*Authority, error) {
+	if !ValidColor(color) {
+		return nil, fmt.Errorf("invalid color %q", color)
+	}
+	current, err := o.Current()
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no authority published: prepare the deployment first")
+	}
+	if current.Color == color {
+		return nil, fmt.Errorf("%s is already authoritative", color)
+	}
+
+	progress, err := o.Progress()
+	if err != nil {
+		return nil, err
+	}
+	if !progress.Complete {
+		return nil, ErrCutoverInProgress
+	}
+
+	partitions, err := o.client.Partitions(o.config.RawTopic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", o.config.RawTopic, err)
+	}
+	before, err := o.ends(partitions)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(sample):
+	}
+	after, err := o.ends(partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	authority := &Authority{
+		Color:      color,
+		Previous:   current.Color,
+		Offsets:    make(map[int32]int64, len(partitions)),
+		SwitchedAt: time.Now().UTC(),
+	}
+	for _, partition := range partitions {
+		rate := float64(after[partition]-before[partition]) / sample.Seconds()
+		authority.Offsets[partition] = after[partition] + int64(math.Ceil(rate*lead.Seconds()))
+	}
+	if err := o.publish(authority); err != nil {
+		return nil, err
+	}
+	return authority, nil
+}
+
+// ends returns the end offsets of raw partitions
+func (o *Orchestrator) ends(partitions []int32) (map[int32]int64, error) {
+	ends := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		end, err := o.client.GetOffset(o.config.RawTopic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get end offset of %s/%d: %w", o.config.RawTopic, partition, err)
+		}
+		ends[partition] = end
+	}
+	return ends, nil
+}
+
+// PartitionProgress is how far the detectors are on one raw partition
+// relative to its cutover offset
+type PartitionProgress struct {
+	Partition int32 `json:"partition"`
+	Cutover   int64 `json:"cutover"`
+	// Committed are the committed offsets of the groups by color; groups
+	// without one are left out
+	Committed map[string]int64 `json:"committed"`
+}
+
+// Progress is how far a cutover is
+type Progress struct {
+	Authority  *Authority          `json:"authority"`
+	Partitions []PartitionProgress `json:"partitions"`
+	// Complete is set once the previous color's group committed every
+	// cutover offset, so its detectors have no alerts left to send
+	Complete bool `json:"complete"`
+}
+
+// Progress compares the committed offsets of both groups to the cutover
+// offsets of the authority in force
+func (o *Orchestrator) Progress() (Progress, error) {
+	current, err := o.Current()
+	if err != nil {
+		return Progress{}, err
+	}
+	progress := Progress{Authority: current, Complete: true}
+	if current == nil || current.Previous == "" {
+		return progress, nil
+	}
+
+	checkpoint, err := o.checkpointer.Export([]kafka.LagGroup{
+		{Group: o.config.Groups[ColorBlue], Topics: []string{o.config.RawTopic}},
+		{Group: o.config.Groups[ColorGreen], Topics: []string{o.config.RawTopic}},
+	})
+	if err != nil {
+		return progress, err
+	}
+	committed := make(map[int32]map[string]int64)
+	for i, group := range checkpoint.Groups {
+		color := []string{ColorBlue, ColorGreen}[i]
+		for _, topic := range group.Topics {
+			for _, partition := range topic.Partitions {
+				if committed[partition.Partition] == nil {
+					committed[partition.Partition] = make(map[string]int64)
+				}
+				committed[partition.Partition][color] = partition.Offset
+			}
+		}
+	}
+
+	for partition, cut := range current.Offsets {
+		p := PartitionProgress{Partition: partition, Cutover: cut, Committed: committed[partition]}
+		if offset, ok := p.Committed[current.Previous]; !ok || offset < cut {
+			progress.Complete = false
+		}
+		progress.Partitions = append(progress.Partitions, p)
+	}
+	sort.Slice(progress.Partitions, func(i, j int) bool {
+		return progress.Partitions[i].Partition < progress.Partitions[j].Partition
+	})
+	return progress, nil
+}
+
+// Compare matches the alerts the authoritative and the shadow detector sent
+// for readings taken in [since, until). Both topics are read from the
+// first alert produced at since to their end, so until should leave the
+// slower detector time to catch up.
+func (o *Orchestrator) Compare(ctx context.Context, since, until time.Time, samples int) (ComparisonReport, error) {
+	comparison := NewComparison()
+	for _, stream := range []struct {
+		topic  string
+		shadow bool
+	}{{o.config.AlertTopic, false}, {o.config.ShadowTopic, true}} {
+		partitions, err := o.client.Partitions(stream.topic)
+		if err != nil {
+			return ComparisonReport{}, fmt.Errorf("failed to list partitions of %s: %w", stream.topic, err)
+		}
+		for _, partition := range partitions {
+			end, err := o.client.GetOffset(stream.topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return ComparisonReport{}, fmt.Errorf("failed to get end offset of %s/%d: %w", stream.topic, partition, err)
+			}
+			start, err := o.client.GetOffset(stream.topic, partition, since.UnixMilli())
+			if err != nil {
+				return ComparisonReport{}, fmt.Errorf("failed to look up offset of %s/%d at %s: %w", stream.topic, partition, since, err)
+			}
+			if start < 0 {
+				continue
+			}
+
+			shadow := stream.shadow
+			err = o.read(ctx, stream.topic, partition, start, end, func(message *sarama.ConsumerMessage) {
+				alert, err := model.DeserializeSensorAlert(message.Value)
+				if err != nil {
+					o.logger.Warn("Skipping undecodable alert", "topic", message.Topic, "partition", message.Partition, "offset", message.Offset, "error", err)
+					return
+				}
+				if alert.Timestamp < since.UnixMilli() || alert.Timestamp >= until.UnixMilli() {
+					return
+				}
+				comparison.Add(alert, shadow)
+			})
+			if err != nil {
+				return ComparisonReport{}, err
+			}
+		}
+	}
+	return comparison.Report(samples), nil
+}
+
+// read passes the messages of a partition in [start, end) to handle
+func (o *Orchestrator) read(ctx context.Context, topic string, partition int32, start, end int64, handle func(*sarama.ConsumerMessage)) error {
+	if start >= end {
+		return nil
+	}
+	consumer, err := sarama.NewConsumerFromClient(o.client)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s/%d: %w", topic, partition, err)
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-pc.Errors():
+			return fmt.Errorf("failed to read %s/%d: %w", topic, partition, err)
+		case message := <-pc.Messages():
+			handle(message)
+			if message.Offset+1 >= end {
+				return nil
+			}
+		}
+	}
+}
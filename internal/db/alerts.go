@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+)
+
+// InsertAlert writes an alert to sensor_alerts and reports whether it was
+// new. Its site, zone and location are encrypted like those of readings if
+// columns is set. An alert already stored for the sensor and time is
+// skipped, so a redelivered alert is harmless.
+func (p *PostgresDB) InsertAlert(ctx context.Context, alert *model.SensorAlert, columns *encryption.Columns) (bool, error) {
+	place, err := placeValues(alert.Site, alert.Zone, alert.Location, columns)
+	if err != nil {
+		return false, err
+	}
+	args := append([]interface{}{alert.SensorID, alert.Timestamp, alert.Reason, alert.Temperature, alert.Humidity}, place...)
+
+	result, err := p.db.ExecContext(ctx, `
+		INSERT INTO sensor_alerts (sensor_id, ts, reason, temperature, humidity, site, zone, latitude, longitude, location_enc)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT DO NOTHING
+	`, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert alert: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return inserted > 0, nil
+}
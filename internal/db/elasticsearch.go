@@ -2,40 +2,109 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/example/iot-sensor-fleet/internal/config"
 )
 
 // ElasticsearchDB represents an Elasticsearch connection
 type ElasticsearchDB struct {
-	url   string
-	index string
+	url        string
+	index      string
+	alertIndex string
+	client     *http.Client
 }
 
 // NewElasticsearchDB creates a new Elasticsearch connection
 func NewElasticsearchDB(cfg *config.Config) *ElasticsearchDB {
 	return &ElasticsearchDB{
-		url:   cfg.ElasticsearchURL,
-		index: cfg.ElasticsearchIndex,
+		url:        cfg.ElasticsearchURL,
+		index:      cfg.ElasticsearchIndex,
+		alertIndex: cfg.ElasticsearchAlertIndex,
+		client:     &http.Client{Timeout: time.Minute},
 	}
 }
 
-// InitIndex creates the necessary index if it doesn't exist
+// Index returns the name of the readings index
+func (e *ElasticsearchDB) Index() string {
+	return e.index
+}
+
+// AlertIndex returns the name of the alerts index
+func (e *ElasticsearchDB) AlertIndex() string {
+	return e.alertIndex
+}
+
+// Ping checks that the cluster responds
+func (e *ElasticsearchDB) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Elasticsearch: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Elasticsearch responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// InitIndex creates the readings and alerts indexes if they don't exist
 func (e *ElasticsearchDB) InitIndex() error {
+	if err := e.createIndex(e.index, map[string]interface{}{
+		"id":          map[string]interface{}{"type": "keyword"},
+		"ts":          map[string]interface{}{"type": "long"},
+		"temperature": map[string]interface{}{"type": "float"},
+		"humidity":    map[string]interface{}{"type": "float"},
+		"site":        map[string]interface{}{"type": "keyword"},
+		"zone":        map[string]interface{}{"type": "keyword"},
+		"location":    map[string]interface{}{"type": "geo_point"},
+	}); err != nil {
+		return err
+	}
+
+	return e.createIndex(e.alertIndex, map[string]interface{}{
+		"sensor_id":   map[string]interface{}{"type": "keyword"},
+		"ts":          map[string]interface{}{"type": "long"},
+		"reason":      map[string]interface{}{"type": "keyword"},
+		"temperature": map[string]interface{}{"type": "float"},
+		"humidity":    map[string]interface{}{"type": "float"},
+		"site":        map[string]interface{}{"type": "keyword"},
+		"zone":        map[string]interface{}{"type": "keyword"},
+		"location":    map[string]interface{}{"type": "geo_point"},
+		"rule":        map[string]interface{}{"type": "keyword"},
+		"runbook_url": map[string]interface{}{"type": "keyword", "index": false},
+		"annotations": map[string]interface{}{"type": "flattened"},
+	})
+}
+
+// createIndex creates an index with the given field mappings if it doesn't
+// exist, or adds the mappings an existing index lacks
+func (e *ElasticsearchDB) createIndex(index string, properties map[string]interface{}) error {
 	// Check if index exists
-	resp, err := http.Head(fmt.Sprintf("%s/%s", e.url, e.index))
+	resp, err := e.client.Head(fmt.Sprintf("%s/%s", e.url, index))
 	if err != nil {
 		return fmt.Errorf("failed to check if index exists: %w", err)
 	}
+	resp.Body.Close()
 
-	// If index exists, return
+	// If index exists, add fields introduced since it was created. Without
+	// this, a location would be mapped dynamically as two floats rather than
+	// as a geo_point.
 	if resp.StatusCode == http.StatusOK {
-		log.Printf("Elasticsearch index '%s' already exists", e.index)
-		return nil
+		slog.Info("Elasticsearch index already exists", "index", index)
+		return e.updateMapping(index, properties)
 	}
 
 	// Create index with mapping
@@ -45,20 +114,7 @@ func (e *ElasticsearchDB) InitIndex() error {
 			"number_of_replicas": 0,
 		},
 		"mappings": map[string]interface{}{
-			"properties": map[string]interface{}{
-				"id": map[string]interface{}{
-					"type": "keyword",
-				},
-				"ts": map[string]interface{}{
-					"type": "long",
-				},
-				"temperature": map[string]interface{}{
-					"type": "float",
-				},
-				"humidity": map[string]interface{}{
-					"type": "float",
-				},
-			},
+			"properties": properties,
 		},
 	}
 
@@ -71,7 +127,7 @@ func (e *ElasticsearchDB) InitIndex() error {
 	// Create index
 	req, err := http.NewRequest(
 		http.MethodPut,
-		fmt.Sprintf("%s/%s", e.url, e.index),
+		fmt.Sprintf("%s/%s", e.url, index),
 		bytes.NewBuffer(mappingJSON),
 	)
 	if err != nil {
@@ -79,8 +135,7 @@ func (e *ElasticsearchDB) InitIndex() error {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err = client.Do(req)
+	resp, err = e.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to create index: %w", err)
 	}
@@ -90,6 +145,183 @@ func (e *ElasticsearchDB) InitIndex() error {
 		return fmt.Errorf("failed to create index, status code: %d", resp.StatusCode)
 	}
 
-	log.Printf("Elasticsearch index '%s' created successfully", e.index)
+	slog.Info("Elasticsearch index created successfully", "index", index)
+	return nil
+}
+
+// updateMapping adds field mappings to an existing index. Fields that are
+// already mapped the same way are left as they are.
+func (e *ElasticsearchDB) updateMapping(index string, properties map[string]interface{}) error {
+	mappingJSON, err := json.Marshal(map[string]interface{}{"properties": properties})
+	if err != nil {
+		return fmt.Errorf("failed to marshal mapping to JSON: %w", err)
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPut,
+		fmt.Sprintf("%s/%s/_mapping", e.url, index),
+		bytes.NewBuffer(mappingJSON),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to update index mapping: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to update mapping of index '%s', status code: %d", index, resp.StatusCode)
+	}
 	return nil
 }
+
+// BulkDocument is a document written by Bulk
+type BulkDocument struct {
+	Index string
+	ID    string
+	Body  []byte
+}
+
+// bulkResponse is the part of a _bulk response the client reads
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// Bulk creates documents with the _bulk API and returns how many were new.
+// Documents whose ID already exists are left unchanged, so writing the same
+// document twice is harmless. An error is returned if any document failed.
+func (e *ElasticsearchDB) Bulk(ctx context.Context, docs []BulkDocument) (int, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, doc := range docs {
+		action := map[string]map[string]string{
+			"create": {"_index": doc.Index, "_id": doc.ID},
+		}
+		if err := encoder.Encode(action); err != nil {
+			return 0, fmt.Errorf("failed to marshal bulk action: %w", err)
+		}
+		body.Write(doc.Body)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/_bulk", &body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("bulk request failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+
+	created, failed := 0, 0
+	var firstErr string
+	for _, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status == http.StatusCreated:
+				created++
+			case status.Status == http.StatusConflict:
+				// Already indexed
+			default:
+				failed++
+				if firstErr == "" && status.Error != nil {
+					firstErr = status.Error.Type + ": " + status.Error.Reason
+				}
+			}
+		}
+	}
+	if failed > 0 {
+		return created, fmt.Errorf("%d of %d documents failed to index, first error: %s", failed, len(docs), firstErr)
+	}
+	return created, nil
+}
+
+// CountBefore returns the number of documents of index whose ts is before
+// ts, or 0 if the index does not exist
+func (e *ElasticsearchDB) CountBefore(ctx context.Context, index string, ts int64) (int64, error) {
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	if err := e.queryBefore(ctx, index+"/_count", ts, &result); err != nil {
+		return 0, fmt.Errorf("failed to count documents of %s: %w", index, err)
+	}
+	return result.Count, nil
+}
+
+// DeleteBefore deletes up to maxDocs documents of index whose ts is before
+// ts with the _delete_by_query API and returns how many were deleted, or 0
+// if the index does not exist. Documents changed during the deletion are
+// skipped rather than failing it.
+func (e *ElasticsearchDB) DeleteBefore(ctx context.Context, index string, ts int64, maxDocs int) (int64, error) {
+	var result struct {
+		Deleted  int64             `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	path := fmt.Sprintf("%s/_delete_by_query?conflicts=proceed&refresh=true&max_docs=%d", index, maxDocs)
+	if err := e.queryBefore(ctx, path, ts, &result); err != nil {
+		return 0, fmt.Errorf("failed to delete documents of %s: %w", index, err)
+	}
+	if len(result.Failures) > 0 {
+		return result.Deleted, fmt.Errorf("failed to delete %d documents of %s: %s", len(result.Failures), index, result.Failures[0])
+	}
+	return result.Deleted, nil
+}
+
+// queryBefore posts a query matching documents whose ts is before ts to path
+// and decodes the response into v. A missing index leaves v unchanged.
+func (e *ElasticsearchDB) queryBefore(ctx context.Context, path string, ts int64, v interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{"ts": map[string]int64{"lt": ts}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusNotFound:
+		return nil
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+}
@@ -1,7 +1,7 @@
 package db
 
 import (
-	"log"
+	"log/slog"
 
 	"github.com/example/iot-sensor-fleet/internal/config"
 )
@@ -10,7 +10,7 @@ import (
 // Returns the PostgreSQL connection that should be closed by the caller when done
 func InitDatabases(cfg *config.Config) (*PostgresDB, error) {
 	// Initialize PostgreSQL
-	log.Println("Initializing PostgreSQL...")
+	slog.Info("Initializing PostgreSQL")
 	postgres, err := NewPostgresDB(cfg)
 	if err != nil {
 		return nil, err
@@ -21,14 +21,14 @@ func InitDatabases(cfg *config.Config) (*PostgresDB, error) {
 		return nil, err
 	}
 
-	//// Initialize Elasticsearch
-	//log.Println("Initializing Elasticsearch...")
-	//elasticsearch := NewElasticsearchDB(cfg)
-	//if err := elasticsearch.InitIndex(); err != nil {
-	//	postgres.Close()
-	//	return nil, err
-	//}
+	// Initialize Elasticsearch. Only es-sink writes to it, and it creates the
+	// indexes itself before consuming, so other services start without it.
+	slog.Info("Initializing Elasticsearch")
+	elasticsearch := NewElasticsearchDB(cfg)
+	if err := elasticsearch.InitIndex(); err != nil {
+		slog.Warn("Failed to initialize Elasticsearch", "error", err)
+	}
 
-	log.Println("All databases initialized successfully")
+	slog.Info("All databases initialized successfully")
 	return postgres, nil
 }
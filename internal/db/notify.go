@@ -0,0 +1,157 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+)
+
+// AlertChannel is the notification channel every row inserted into
+// sensor_alerts is announced on, as JSON, by the sensor_alerts_notify trigger
+const AlertChannel = "sensor_alerts"
+
+// Reconnect backoff of the listening connection
+const (
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = time.Minute
+)
+
+// alertNotification is the payload of a notification on AlertChannel
+type alertNotification struct {
+	SensorID    string   `json:"sensor_id"`
+	Timestamp   int64    `json:"ts"`
+	Reason      string   `json:"reason"`
+	Temperature float32  `json:"temperature"`
+	Humidity    float32  `json:"humidity"`
+	Site        string   `json:"site"`
+	Zone        string   `json:"zone"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	LocationEnc string   `json:"location_enc"`
+}
+
+// AlertListener listens on AlertChannel over a dedicated connection and
+// publishes the alerts inserted into sensor_alerts, whichever service wrote
+// them, with their encrypted columns decrypted. Alerts inserted while the
+// connection is down are not replayed.
+type AlertListener struct {
+	listener *pq.Listener
+	columns  *encryption.Columns
+	publish  func(*model.SensorAlert)
+	logger   *slog.Logger
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewAlertListener creates a listener calling publish for each inserted
+// alert; columns and logger may be nil
+func NewAlertListener(cfg *config.Config, columns *encryption.Columns, publish func(*model.SensorAlert), logger *slog.Logger) *AlertListener {
+	logger = logging.OrDefault(logger).With("channel", AlertChannel)
+	report := func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			logger.Warn("Lost alert notification connection", "error", err)
+		case pq.ListenerEventReconnected:
+			logger.Info("Reconnected alert notification connection; alerts inserted meanwhile were missed")
+		case pq.ListenerEventConnectionAttemptFailed:
+			logger.Warn("Failed to reconnect alert notification connection", "error", err)
+		}
+	}
+
+	return &AlertListener{
+		listener: pq.NewListener(postgresConnString(cfg), listenerMinReconnect, listenerMaxReconnect, report),
+		columns:  columns,
+		publish:  publish,
+		logger:   logger,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start subscribes to AlertChannel and publishes notifications until Stop is
+// called
+func (l *AlertListener) Start() error {
+	if err := l.listener.Listen(AlertChannel); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", AlertChannel, err)
+	}
+
+	l.wg.Add(1)
+	go l.run()
+	l.logger.Info("Listening for inserted alerts")
+	return nil
+}
+
+// Stop stops publishing and closes the connection
+func (l *AlertListener) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.done)
+		l.wg.Wait()
+		if err := l.listener.Close(); err != nil {
+			l.logger.Error("Failed to close alert notification connection", "error", err)
+		}
+	})
+}
+
+// run publishes notifications until Stop is called
+func (l *AlertListener) run() {
+	defer l.wg.Done()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case notification := <-l.listener.Notify:
+			// A nil notification follows a reconnect
+			if notification == nil {
+				continue
+			}
+			alert, err := l.decode(notification.Extra)
+			if err != nil {
+				l.logger.Warn("Skipping undecodable alert notification", "error", err)
+				continue
+			}
+			l.publish(alert)
+		}
+	}
+}
+
+// decode converts a notification payload to an alert
+func (l *AlertListener) decode(payload string) (*model.SensorAlert, error) {
+	var row alertNotification
+	if err := json.Unmarshal([]byte(payload), &row); err != nil {
+		return nil, err
+	}
+
+	alert := &model.SensorAlert{
+		SensorID:    row.SensorID,
+		Timestamp:   row.Timestamp,
+		Reason:      row.Reason,
+		Temperature: row.Temperature,
+		Humidity:    row.Humidity,
+	}
+	var err error
+	if alert.Site, err = l.columns.Open(encryption.ColumnSite, row.Site); err != nil {
+		return nil, err
+	}
+	if alert.Zone, err = l.columns.Open(encryption.ColumnZone, row.Zone); err != nil {
+		return nil, err
+	}
+	if row.LocationEnc != "" {
+		if alert.Location, err = l.columns.OpenLocation(row.LocationEnc); err != nil {
+			return nil, err
+		}
+	} else if row.Latitude != nil && row.Longitude != nil {
+		alert.Location = &model.GeoPoint{Lat: *row.Latitude, Lon: *row.Longitude}
+	}
+	return alert, nil
+}
@@ -1,9 +1,10 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 
 	_ "github.com/lib/pq"
 
@@ -13,16 +14,16 @@ import (
 // PostgresDB represents a PostgreSQL database connection
 type PostgresDB struct {
 	db *sql.DB
+	// timescale makes InitTables create sensor_readings as a hypertable
+	// when set
+	timescale *TimescaleConfig
+	// replicas serve ReadDB when set
+	replicas *replicaSet
 }
 
 // NewPostgresDB creates a new PostgreSQL database connection
 func NewPostgresDB(cfg *config.Config) (*PostgresDB, error) {
-	connStr := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		cfg.PostgresHost, cfg.PostgresPort, cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresDB,
-	)
-
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", postgresConnString(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
@@ -31,11 +32,47 @@ func NewPostgresDB(cfg *config.Config) (*PostgresDB, error) {
 		return nil, fmt.Errorf("failed to ping PostgreSQL: %w", err)
 	}
 
-	return &PostgresDB{db: db}, nil
+	return &PostgresDB{db: db, timescale: TimescaleConfigFromConfig(cfg)}, nil
+}
+
+// PingPostgres checks that PostgreSQL accepts connections, without keeping one open
+func PingPostgres(ctx context.Context, cfg *config.Config) error {
+	db, err := sql.Open("postgres", postgresConnString(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping PostgreSQL: %w", err)
+	}
+	return nil
+}
+
+// postgresConnString builds the connection string for the configured database
+func postgresConnString(cfg *config.Config) string {
+	return postgresConnStringFor(cfg, cfg.PostgresHost, cfg.PostgresPort)
+}
+
+// postgresConnStringFor builds the connection string for the configured
+// database on another server, such as a read replica
+func postgresConnStringFor(cfg *config.Config, host string, port int) string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		host, port, cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresDB,
+	)
+}
+
+// DB returns the underlying database handle
+func (p *PostgresDB) DB() *sql.DB {
+	return p.db
 }
 
-// Close closes the database connection
+// Close closes the database connection and those to the replicas
 func (p *PostgresDB) Close() error {
+	if p.replicas != nil {
+		p.replicas.close()
+	}
 	return p.db.Close()
 }
 
@@ -44,17 +81,50 @@ func (p *PostgresDB) InitTables() error {
 	// Create sensor_readings table
 	_, err := p.db.Exec(`
 		CREATE TABLE IF NOT EXISTS sensor_readings (
-			id VARCHAR(36) PRIMARY KEY,
+			sensor_id VARCHAR(36) NOT NULL,
 			ts BIGINT NOT NULL,
 			temperature REAL NOT NULL,
 			humidity REAL NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		)
+			site TEXT,
+			zone TEXT,
+			latitude DOUBLE PRECISION,
+			longitude DOUBLE PRECISION,
+			location_enc TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (sensor_id, ts)
+		);
+		ALTER TABLE sensor_readings ADD COLUMN IF NOT EXISTS site TEXT;
+		ALTER TABLE sensor_readings ADD COLUMN IF NOT EXISTS zone TEXT;
+		ALTER TABLE sensor_readings ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+		ALTER TABLE sensor_readings ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+		ALTER TABLE sensor_readings ADD COLUMN IF NOT EXISTS location_enc TEXT
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to create sensor_readings table: %w", err)
 	}
 
+	// Tables created before readings were keyed by sensor and time held the
+	// sensor ID in an id column that was the primary key on its own, so a
+	// sensor's later readings were dropped as conflicts
+	_, err = p.db.Exec(`
+		DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = 'sensor_readings' AND column_name = 'id') THEN
+				ALTER TABLE sensor_readings RENAME COLUMN id TO sensor_id;
+			END IF;
+			IF NOT EXISTS (SELECT 1 FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+				WHERE i.indrelid = 'sensor_readings'::REGCLASS AND i.indisprimary AND a.attname = 'ts') THEN
+				ALTER TABLE sensor_readings DROP CONSTRAINT IF EXISTS sensor_readings_pkey;
+				ALTER TABLE sensor_readings ADD PRIMARY KEY (sensor_id, ts);
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to key sensor_readings by sensor and time: %w", err)
+	}
+
 	// Create sensor_alerts table
 	_, err = p.db.Exec(`
 		CREATE TABLE IF NOT EXISTS sensor_alerts (
@@ -63,23 +133,297 @@ func (p *PostgresDB) InitTables() error {
 			reason TEXT NOT NULL,
 			temperature REAL NOT NULL,
 			humidity REAL NOT NULL,
+			site TEXT,
+			zone TEXT,
+			latitude DOUBLE PRECISION,
+			longitude DOUBLE PRECISION,
+			location_enc TEXT,
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
 			PRIMARY KEY (sensor_id, ts)
-		)
+		);
+		ALTER TABLE sensor_alerts ADD COLUMN IF NOT EXISTS site TEXT;
+		ALTER TABLE sensor_alerts ADD COLUMN IF NOT EXISTS zone TEXT;
+		ALTER TABLE sensor_alerts ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+		ALTER TABLE sensor_alerts ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+		ALTER TABLE sensor_alerts ADD COLUMN IF NOT EXISTS location_enc TEXT
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to create sensor_alerts table: %w", err)
 	}
 
+	// Announce inserted alerts on the sensor_alerts channel, so API instances
+	// can push them to clients whichever service stored them
+	_, err = p.db.Exec(`
+		CREATE OR REPLACE FUNCTION notify_sensor_alert() RETURNS TRIGGER
+		LANGUAGE plpgsql AS $$
+		BEGIN
+			PERFORM pg_notify('` + AlertChannel + `', json_build_object(
+				'sensor_id', NEW.sensor_id, 'ts', NEW.ts, 'reason', NEW.reason,
+				'temperature', NEW.temperature, 'humidity', NEW.humidity, 'site', NEW.site, 'zone', NEW.zone,
+				'latitude', NEW.latitude, 'longitude', NEW.longitude, 'location_enc', NEW.location_enc)::TEXT);
+			RETURN NULL;
+		END
+		$$;
+		DROP TRIGGER IF EXISTS sensor_alerts_notify ON sensor_alerts;
+		CREATE TRIGGER sensor_alerts_notify AFTER INSERT ON sensor_alerts
+			FOR EACH ROW EXECUTE FUNCTION notify_sensor_alert()
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create sensor_alerts notify trigger: %w", err)
+	}
+
+	// Create ingest_idempotency_keys table for de-duplicating HTTP ingest retries
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ingest_idempotency_keys (
+			key VARCHAR(320) PRIMARY KEY,
+			status_code INTEGER,
+			content_type TEXT,
+			body BYTEA,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create ingest_idempotency_keys table: %w", err)
+	}
+
+	// Create sensor registry tables for device provisioning
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS provisioning_tokens (
+			id BIGSERIAL PRIMARY KEY,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			label TEXT NOT NULL DEFAULT '',
+			max_claims INTEGER NOT NULL,
+			claims INTEGER NOT NULL DEFAULT 0,
+			revoked BOOLEAN NOT NULL DEFAULT FALSE,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS sensors (
+			id VARCHAR(36) PRIMARY KEY,
+			hardware_id TEXT NOT NULL UNIQUE,
+			firmware_version TEXT,
+			provisioning_token_id BIGINT REFERENCES provisioning_tokens (id),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		ALTER TABLE sensors ADD COLUMN IF NOT EXISTS site TEXT;
+		ALTER TABLE sensors ADD COLUMN IF NOT EXISTS zone TEXT;
+		ALTER TABLE sensors ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+		CREATE TABLE IF NOT EXISTS sensor_credentials (
+			id BIGSERIAL PRIMARY KEY,
+			sensor_id VARCHAR(36) NOT NULL REFERENCES sensors (id) ON DELETE CASCADE,
+			api_key_hash CHAR(64) NOT NULL UNIQUE,
+			mqtt_username TEXT NOT NULL,
+			mqtt_password_hash CHAR(64) NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		ALTER TABLE sensor_credentials ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+		ALTER TABLE sensor_credentials ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create sensor registry tables: %w", err)
+	}
+
+	// Tables created before credentials could be rotated held one credential
+	// per sensor, keyed by sensor ID with a unique MQTT username, so the new
+	// credentials of a rotation conflicted with the ones they replace
+	_, err = p.db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+				WHERE i.indrelid = 'sensor_credentials'::REGCLASS AND i.indisprimary AND a.attname = 'id') THEN
+				ALTER TABLE sensor_credentials DROP CONSTRAINT IF EXISTS sensor_credentials_pkey;
+				ALTER TABLE sensor_credentials ADD PRIMARY KEY (id);
+			END IF;
+		END
+		$$;
+		ALTER TABLE sensor_credentials DROP CONSTRAINT IF EXISTS sensor_credentials_mqtt_username_key
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to key sensor_credentials by credential: %w", err)
+	}
+
+	// Create incident tables for correlated alerts
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS incidents (
+			id VARCHAR(36) PRIMARY KEY,
+			site TEXT NOT NULL,
+			status VARCHAR(16) NOT NULL,
+			opened_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			resolved_at TIMESTAMP WITH TIME ZONE,
+			alert_count INTEGER NOT NULL,
+			summary TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS incident_alerts (
+			incident_id VARCHAR(36) NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+			sensor_id VARCHAR(36) NOT NULL,
+			ts BIGINT NOT NULL,
+			reason TEXT NOT NULL,
+			PRIMARY KEY (incident_id, sensor_id, ts)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create incident tables: %w", err)
+	}
+
+	// Create annotations table for runbook links on rules and sites
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS annotations (
+			scope VARCHAR(16) NOT NULL,
+			target TEXT NOT NULL,
+			runbook_url TEXT,
+			annotations JSONB,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (scope, target)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create annotations table: %w", err)
+	}
+
+	// Create threshold tables for detector thresholds managed over the API
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS thresholds (
+			scope VARCHAR(16) NOT NULL,
+			target TEXT NOT NULL,
+			max_temperature REAL,
+			min_humidity REAL,
+			min_battery_pct REAL,
+			min_rssi INTEGER,
+			updated_by TEXT NOT NULL,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (scope, target)
+		);
+		CREATE TABLE IF NOT EXISTS threshold_changes (
+			id BIGSERIAL PRIMARY KEY,
+			scope VARCHAR(16) NOT NULL,
+			target TEXT NOT NULL,
+			action VARCHAR(16) NOT NULL,
+			old_values JSONB,
+			new_values JSONB,
+			changed_by TEXT NOT NULL,
+			reason TEXT,
+			changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create threshold tables: %w", err)
+	}
+
+	// Create notification_templates table for templates managed over the
+	// notifier's API, one row per version
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_templates (
+			name VARCHAR(64) NOT NULL,
+			version INTEGER NOT NULL,
+			format VARCHAR(16) NOT NULL,
+			body TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (name, version)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_templates table: %w", err)
+	}
+
+	// Create partition_sites table for the site-affinity balance strategy
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS partition_sites (
+			topic TEXT NOT NULL,
+			partition INTEGER NOT NULL,
+			site TEXT NOT NULL,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (topic, partition)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create partition_sites table: %w", err)
+	}
+
+	// Create archive catalog tables for verifying cold storage
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS archive_catalog (
+			object_key TEXT PRIMARY KEY,
+			manifest_key TEXT NOT NULL,
+			readings INTEGER NOT NULL,
+			bytes BIGINT NOT NULL,
+			sha256 CHAR(64) NOT NULL,
+			min_ts BIGINT NOT NULL,
+			max_ts BIGINT NOT NULL,
+			compression TEXT NOT NULL,
+			encryption TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS archive_catalog_offsets (
+			manifest_key TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			partition INTEGER NOT NULL,
+			readings INTEGER NOT NULL,
+			min_offset BIGINT NOT NULL,
+			max_offset BIGINT NOT NULL,
+			PRIMARY KEY (manifest_key, topic, partition)
+		);
+		CREATE TABLE IF NOT EXISTS archive_catalog_sensors (
+			object_key TEXT NOT NULL,
+			sensor_id TEXT NOT NULL,
+			PRIMARY KEY (sensor_id, object_key)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create archive catalog tables: %w", err)
+	}
+
+	// Create sensor_aggregates table for the aggregator's downsampled readings
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS sensor_aggregates (
+			sensor_id VARCHAR(36) NOT NULL,
+			site TEXT,
+			window_start BIGINT NOT NULL,
+			window_end BIGINT NOT NULL,
+			readings INTEGER NOT NULL,
+			min_temperature DOUBLE PRECISION NOT NULL,
+			max_temperature DOUBLE PRECISION NOT NULL,
+			avg_temperature DOUBLE PRECISION NOT NULL,
+			min_humidity DOUBLE PRECISION NOT NULL,
+			max_humidity DOUBLE PRECISION NOT NULL,
+			avg_humidity DOUBLE PRECISION NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (sensor_id, window_start)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create sensor_aggregates table: %w", err)
+	}
+
 	// Create indexes for better query performance
 	_, err = p.db.Exec(`
 		CREATE INDEX IF NOT EXISTS idx_sensor_readings_ts ON sensor_readings (ts);
 		CREATE INDEX IF NOT EXISTS idx_sensor_alerts_ts ON sensor_alerts (ts);
+		CREATE INDEX IF NOT EXISTS idx_sensor_alerts_location ON sensor_alerts (latitude, longitude);
+		CREATE INDEX IF NOT EXISTS idx_ingest_idempotency_keys_expires_at ON ingest_idempotency_keys (expires_at);
+		CREATE INDEX IF NOT EXISTS idx_sensors_tags ON sensors USING GIN (tags);
+		CREATE INDEX IF NOT EXISTS idx_sensor_credentials_sensor_id ON sensor_credentials (sensor_id);
+		CREATE INDEX IF NOT EXISTS idx_sensor_credentials_mqtt_username ON sensor_credentials (mqtt_username);
+		CREATE INDEX IF NOT EXISTS idx_incidents_site_status ON incidents (site, status);
+		CREATE INDEX IF NOT EXISTS idx_incident_alerts_sensor_ts ON incident_alerts (sensor_id, ts);
+		CREATE INDEX IF NOT EXISTS idx_archive_catalog_ts ON archive_catalog (min_ts, max_ts);
+		CREATE INDEX IF NOT EXISTS idx_archive_catalog_offsets_partition ON archive_catalog_offsets (topic, partition, min_offset);
+		CREATE INDEX IF NOT EXISTS idx_threshold_changes_target ON threshold_changes (scope, target, id);
+		CREATE INDEX IF NOT EXISTS idx_sensor_aggregates_window_start ON sensor_aggregates (window_start);
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to create indexes: %w", err)
 	}
 
-	log.Println("PostgreSQL tables initialized successfully")
+	if p.timescale != nil {
+		if err := p.initTimescale(p.timescale); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("PostgreSQL tables initialized successfully")
 	return nil
 }
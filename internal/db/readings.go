@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+)
+
+// Methods of inserting batches of readings
+const (
+	// InsertMethodCopy streams the batch into a temporary table with COPY and
+	// moves it into sensor_readings in one INSERT ... SELECT
+	InsertMethodCopy = "copy"
+	// InsertMethodValues sends the batch as multi-row INSERT statements
+	InsertMethodValues = "values"
+)
+
+// readingColumns lists the columns written for each reading
+var readingColumns = []string{"sensor_id", "ts", "temperature", "humidity", "site", "zone", "latitude", "longitude", "location_enc"}
+
+// maxValuesRows keeps a multi-row insert below PostgreSQL's limit of 65535
+// bind parameters
+var maxValuesRows = 65535 / len(readingColumns)
+
+// ReadingBatchOptions configures InsertReadingsBatch
+type ReadingBatchOptions struct {
+	// Method is InsertMethodCopy or InsertMethodValues (empty uses copy)
+	Method string
+	// Columns encrypts the sensitive columns of readings (optional)
+	Columns *encryption.Columns
+}
+
+// InsertReadingsBatch writes readings to sensor_readings in one transaction
+// and returns the number of new rows. Readings already stored, and repeats
+// within the batch, are skipped, so a batch can be retried as a whole.
+func (p *PostgresDB) InsertReadingsBatch(ctx context.Context, readings []*model.SensorReading, opts ReadingBatchOptions) (int64, error) {
+	if len(readings) == 0 {
+		return 0, nil
+	}
+	rows := make([][]interface{}, len(readings))
+	for i, reading := range readings {
+		row, err := readingRow(reading, opts.Columns)
+		if err != nil {
+			return 0, err
+		}
+		rows[i] = row
+	}
+
+	switch opts.Method {
+	case InsertMethodCopy, "":
+		return p.copyReadings(ctx, rows)
+	case InsertMethodValues:
+		return p.insertReadingValues(ctx, rows)
+	default:
+		return 0, fmt.Errorf("unknown insert method: %s", opts.Method)
+	}
+}
+
+// copyReadings streams rows into a temporary table, which COPY can fill
+// without conflict handling, and inserts them from there skipping those
+// already stored. The table is dropped on commit.
+func (p *PostgresDB) copyReadings(ctx context.Context, rows [][]interface{}) (int64, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TEMP TABLE sensor_readings_copy (LIKE sensor_readings) ON COMMIT DROP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create copy table: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("sensor_readings_copy", readingColumns...))
+	if err != nil {
+		return 0, fmt.Errorf("failed to start copy: %w", err)
+	}
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			stmt.Close()
+			return 0, fmt.Errorf("failed to copy reading: %w", err)
+		}
+	}
+	// An Exec without arguments ends the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return 0, fmt.Errorf("failed to copy readings: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, fmt.Errorf("failed to copy readings: %w", err)
+	}
+
+	columns := strings.Join(readingColumns, ", ")
+	// Readings are keyed by (sensor_id, ts), so a redelivered reading
+	// conflicts and is skipped
+	result, err := tx.ExecContext(ctx, `INSERT INTO sensor_readings (`+columns+`)
+		SELECT `+columns+` FROM sensor_readings_copy ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert copied readings: %w", err)
+	}
+	written, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit readings: %w", err)
+	}
+	return written, nil
+}
+
+// insertReadingValues inserts rows in as few multi-row statements as the
+// bind parameter limit allows
+func (p *PostgresDB) insertReadingValues(ctx context.Context, rows [][]interface{}) (int64, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var written int64
+	for len(rows) > 0 {
+		n := min(len(rows), maxValuesRows)
+		inserted, err := insertReadingValues(ctx, tx, rows[:n])
+		if err != nil {
+			return 0, err
+		}
+		written += inserted
+		rows = rows[n:]
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit readings: %w", err)
+	}
+	return written, nil
+}
+
+// insertReadingValues inserts up to maxValuesRows rows in one statement
+func insertReadingValues(ctx context.Context, tx *sql.Tx, rows [][]interface{}) (int64, error) {
+	var query strings.Builder
+	query.WriteString("INSERT INTO sensor_readings (" + strings.Join(readingColumns, ", ") + ") VALUES ")
+	args := make([]interface{}, 0, len(rows)*len(readingColumns))
+	for i, row := range rows {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j := range row {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", len(args)+j+1)
+		}
+		query.WriteString(")")
+		args = append(args, row...)
+	}
+	query.WriteString(" ON CONFLICT DO NOTHING")
+
+	result, err := tx.ExecContext(ctx, query.String(), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert readings: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// readingRow returns the column values of a reading in the order of
+// readingColumns, encrypting its sensitive columns if columns is set. Empty
+// sites and zones are stored as NULL, and an encrypted location in
+// location_enc instead of latitude and longitude.
+func readingRow(reading *model.SensorReading, columns *encryption.Columns) ([]interface{}, error) {
+	place, err := placeValues(reading.Site, reading.Zone, reading.Location, columns)
+	if err != nil {
+		return nil, err
+	}
+	return append([]interface{}{reading.ID, reading.Timestamp, reading.Temperature, reading.Humidity}, place...), nil
+}
+
+// placeValues returns the site, zone, latitude, longitude and location_enc
+// values of a reading or alert, encrypting them if columns is set
+func placeValues(site, zone string, location *model.GeoPoint, columns *encryption.Columns) ([]interface{}, error) {
+	site, err := columns.Seal(encryption.ColumnSite, site)
+	if err != nil {
+		return nil, err
+	}
+	zone, err = columns.Seal(encryption.ColumnZone, zone)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := columns.SealLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	var latitude, longitude sql.NullFloat64
+	if location != nil && sealed == "" {
+		latitude = sql.NullFloat64{Float64: location.Lat, Valid: true}
+		longitude = sql.NullFloat64{Float64: location.Lon, Valid: true}
+	}
+	return []interface{}{nullString(site), nullString(zone), latitude, longitude, nullString(sealed)}, nil
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+)
+
+// replicaLagQuery measures how far a replica's replay trails the WAL it has
+// received, in seconds. A replica that has replayed everything it received
+// has no lag however long ago the last transaction was, and a server that is
+// not in recovery is the primary itself.
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()), 0)
+	END`
+
+// ReplicaMetrics holds Prometheus metrics for read replicas
+type ReplicaMetrics struct {
+	Lag     *prometheus.GaugeVec
+	Healthy *prometheus.GaugeVec
+}
+
+// NewReplicaMetrics creates a new set of read replica metrics
+func NewReplicaMetrics(namespace, subsystem string, registry prometheus.Registerer) *ReplicaMetrics {
+	metrics := &ReplicaMetrics{
+		Lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "postgres_replica_lag_seconds",
+			Help:      "Replication lag of each PostgreSQL read replica at its last check",
+		}, []string{"replica"}),
+		Healthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "postgres_replica_healthy",
+			Help:      "Whether each PostgreSQL read replica serves reads (1) or they go to the primary (0)",
+		}, []string{"replica"}),
+	}
+
+	registry.MustRegister(metrics.Lag, metrics.Healthy)
+
+	return metrics
+}
+
+// replica is a read replica and whether its last check found it usable
+type replica struct {
+	addr    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// replicaSet routes reads round-robin over the replicas whose lag is within
+// maxLag, checked every interval
+type replicaSet struct {
+	replicas []*replica
+	maxLag   time.Duration
+	timeout  time.Duration
+	metrics  *ReplicaMetrics
+	next     atomic.Uint64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPostgresDBWithReplicas connects to the primary like NewPostgresDB and to
+// the read replicas of POSTGRES_REPLICA_HOSTS, which ReadDB then routes to
+// while their lag stays within POSTGRES_REPLICA_MAX_LAG. A replica that is
+// down or lagging is skipped until a later check finds it caught up, so
+// reads fall back to the primary rather than fail. metrics may be nil.
+func NewPostgresDBWithReplicas(cfg *config.Config, metrics *ReplicaMetrics) (*PostgresDB, error) {
+	p, err := NewPostgresDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.PostgresReplicaHosts) == 0 {
+		return p, nil
+	}
+
+	set := &replicaSet{
+		maxLag:  cfg.PostgresReplicaMaxLag,
+		timeout: cfg.PostgresReplicaCheckInterval,
+		metrics: metrics,
+		done:    make(chan struct{}),
+	}
+	for _, addr := range cfg.PostgresReplicaHosts {
+		host, port, err := replicaAddr(addr, cfg.PostgresPort)
+		if err != nil {
+			set.close()
+			p.Close()
+			return nil, err
+		}
+		db, err := sql.Open("postgres", postgresConnStringFor(cfg, host, port))
+		if err != nil {
+			set.close()
+			p.Close()
+			return nil, fmt.Errorf("failed to connect to replica %s: %w", addr, err)
+		}
+		set.replicas = append(set.replicas, &replica{addr: addr, db: db})
+	}
+
+	// Route nothing to a replica before it has been checked
+	set.check()
+	set.wg.Add(1)
+	go set.run(cfg.PostgresReplicaCheckInterval)
+
+	p.replicas = set
+	return p, nil
+}
+
+// ReadDB returns a handle for read-only queries that tolerate replication
+// lag: the next healthy replica, or the primary when there is none
+func (p *PostgresDB) ReadDB() *sql.DB {
+	if p.replicas == nil {
+		return p.db
+	}
+	n := uint64(len(p.replicas.replicas))
+	start := p.replicas.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if r := p.replicas.replicas[(start+i)%n]; r.healthy.Load() {
+			return r.db
+		}
+	}
+	return p.db
+}
+
+// run checks the replicas every interval until close is called
+func (s *replicaSet) run(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.check()
+		}
+	}
+}
+
+// check measures the lag of every replica and marks those within maxLag
+// healthy, logging each change
+func (s *replicaSet) check() {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		var lagSeconds float64
+		err := r.db.QueryRowContext(ctx, replicaLagQuery).Scan(&lagSeconds)
+		cancel()
+
+		lag := time.Duration(lagSeconds * float64(time.Second))
+		healthy := err == nil && lag <= s.maxLag
+		if was := r.healthy.Swap(healthy); was != healthy {
+			switch {
+			case healthy:
+				slog.Info("Routing reads to replica", "replica", r.addr, "lag", lag)
+			case err != nil:
+				slog.Warn("Replica unreachable, routing its reads to the primary", "replica", r.addr, "error", err)
+			default:
+				slog.Warn("Replica lagging, routing its reads to the primary", "replica", r.addr, "lag", lag, "max_lag", s.maxLag)
+			}
+		}
+
+		if s.metrics != nil {
+			if err == nil {
+				s.metrics.Lag.WithLabelValues(r.addr).Set(lag.Seconds())
+			}
+			s.metrics.Healthy.WithLabelValues(r.addr).Set(boolGauge(healthy))
+		}
+	}
+}
+
+// close stops the checks and closes the replica connections
+func (s *replicaSet) close() {
+	close(s.done)
+	s.wg.Wait()
+	for _, r := range s.replicas {
+		r.db.Close()
+	}
+}
+
+// replicaAddr splits a replica address into host and port, defaulting to
+// port
+func replicaAddr(addr string, port int) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		// No port
+		return addr, port, nil
+	}
+	portInt, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid replica address %q: %w", addr, err)
+	}
+	return host, portInt, nil
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
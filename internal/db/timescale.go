@@ -0,0 +1,158 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+)
+
+// aggregateName restricts continuous aggregate suffixes to what can be
+// spliced into a view name
+var aggregateName = regexp.MustCompile(`^[0-9a-z]+$`)
+
+// TimescaleConfig configures sensor_readings as a TimescaleDB hypertable
+type TimescaleConfig struct {
+	// ChunkInterval is the span of reading time each chunk holds
+	ChunkInterval time.Duration
+	// CompressAfter is the age at which chunks are compressed (0 disables)
+	CompressAfter time.Duration
+	// Aggregates names the buckets of the continuous aggregates to create,
+	// as durations such as 1m or 1h
+	Aggregates []string
+	// AggregateLookback is how far back each refresh of the continuous
+	// aggregates recomputes buckets, to take in late readings
+	AggregateLookback time.Duration
+}
+
+// TimescaleConfigFromConfig returns the TimescaleDB settings, or nil when
+// POSTGRES_TIMESCALE is off
+func TimescaleConfigFromConfig(cfg *config.Config) *TimescaleConfig {
+	if !cfg.PostgresTimescale {
+		return nil
+	}
+	return &TimescaleConfig{
+		ChunkInterval:     cfg.PostgresTimescaleChunkInterval,
+		CompressAfter:     cfg.PostgresTimescaleCompressAfter,
+		Aggregates:        cfg.PostgresTimescaleAggregates,
+		AggregateLookback: cfg.PostgresTimescaleAggregateLookback,
+	}
+}
+
+// initTimescale turns sensor_readings into a hypertable partitioned on ts,
+// moving any rows it holds into chunks, and sets up compression and the
+// continuous aggregates. Hypertables need the time column in every unique
+// key, which the primary key (sensor_id, ts) has. Every step is idempotent.
+func (p *PostgresDB) initTimescale(ts *TimescaleConfig) error {
+	if _, err := p.db.Exec(`CREATE EXTENSION IF NOT EXISTS timescaledb`); err != nil {
+		return fmt.Errorf("failed to enable TimescaleDB: %w", err)
+	}
+
+	// ts holds Unix milliseconds, so intervals are given in milliseconds and
+	// policies need a function telling the current time in the same unit
+	_, err := p.db.Exec(`
+		SELECT create_hypertable('sensor_readings', 'ts', chunk_time_interval => $1::BIGINT,
+			if_not_exists => TRUE, migrate_data => TRUE)
+	`, ts.ChunkInterval.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to create sensor_readings hypertable: %w", err)
+	}
+	// A hypertable created earlier keeps its interval until set
+	_, err = p.db.Exec(`SELECT set_chunk_time_interval('sensor_readings', $1::BIGINT)`, ts.ChunkInterval.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to set sensor_readings chunk interval: %w", err)
+	}
+	_, err = p.db.Exec(`
+		CREATE OR REPLACE FUNCTION sensor_readings_now() RETURNS BIGINT
+		LANGUAGE SQL STABLE AS $$ SELECT (EXTRACT(EPOCH FROM NOW()) * 1000)::BIGINT $$;
+		SELECT set_integer_now_func('sensor_readings', 'sensor_readings_now', replace_if_exists => TRUE)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to configure sensor_readings hypertable: %w", err)
+	}
+
+	if err := p.initCompression(ts.CompressAfter); err != nil {
+		return err
+	}
+
+	for _, name := range ts.Aggregates {
+		if err := p.initContinuousAggregate(name, ts.AggregateLookback); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("TimescaleDB hypertable initialized", "chunk_interval", ts.ChunkInterval,
+		"compress_after", ts.CompressAfter, "aggregates", ts.Aggregates)
+	return nil
+}
+
+// initCompression compresses chunks older than after, segmented by sensor so
+// a sensor's readings stay together, or removes the policy when after is 0
+func (p *PostgresDB) initCompression(after time.Duration) error {
+	if after <= 0 {
+		if _, err := p.db.Exec(`SELECT remove_compression_policy('sensor_readings', if_exists => TRUE)`); err != nil {
+			return fmt.Errorf("failed to remove sensor_readings compression policy: %w", err)
+		}
+		return nil
+	}
+
+	_, err := p.db.Exec(`
+		ALTER TABLE sensor_readings SET (timescaledb.compress,
+			timescaledb.compress_segmentby = 'sensor_id', timescaledb.compress_orderby = 'ts DESC')
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to enable sensor_readings compression: %w", err)
+	}
+	// Replace the policy so a changed age takes effect
+	if _, err := p.db.Exec(`SELECT remove_compression_policy('sensor_readings', if_exists => TRUE)`); err != nil {
+		return fmt.Errorf("failed to replace sensor_readings compression policy: %w", err)
+	}
+	_, err = p.db.Exec(`SELECT add_compression_policy('sensor_readings', compress_after => $1::BIGINT)`, after.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to add sensor_readings compression policy: %w", err)
+	}
+	return nil
+}
+
+// initContinuousAggregate creates the view sensor_readings_<name> averaging
+// each sensor's readings per bucket of the duration name, refreshed every
+// bucket over the lookback
+func (p *PostgresDB) initContinuousAggregate(name string, lookback time.Duration) error {
+	bucket, err := time.ParseDuration(name)
+	if err != nil || bucket < time.Millisecond || !aggregateName.MatchString(name) {
+		return fmt.Errorf("invalid continuous aggregate bucket: %q", name)
+	}
+	view := "sensor_readings_" + name
+	width := fmt.Sprint(bucket.Milliseconds())
+
+	_, err = p.db.Exec(`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS ` + view + `
+		WITH (timescaledb.continuous) AS
+		SELECT sensor_id, time_bucket(` + width + `::BIGINT, ts) AS bucket, COUNT(*) AS readings,
+			AVG(temperature) AS avg_temperature, MIN(temperature) AS min_temperature, MAX(temperature) AS max_temperature,
+			AVG(humidity) AS avg_humidity, MIN(humidity) AS min_humidity, MAX(humidity) AS max_humidity
+		FROM sensor_readings
+		GROUP BY sensor_id, bucket
+		WITH NO DATA
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create continuous aggregate %s: %w", view, err)
+	}
+
+	// The open bucket is left to the next refresh; the refresh must span at
+	// least two buckets
+	start := max(lookback, 2*bucket).Milliseconds()
+	if _, err := p.db.Exec(`SELECT remove_continuous_aggregate_policy($1::REGCLASS, if_exists => TRUE)`, view); err != nil {
+		return fmt.Errorf("failed to replace refresh policy of %s: %w", view, err)
+	}
+	_, err = p.db.Exec(`
+		SELECT add_continuous_aggregate_policy($1::REGCLASS, start_offset => $2::BIGINT, end_offset => $3::BIGINT,
+			schedule_interval => $4::INTERVAL)
+	`, view, start, bucket.Milliseconds(), fmt.Sprintf("%d milliseconds", bucket.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("failed to add refresh policy of %s: %w", view, err)
+	}
+	return nil
+}
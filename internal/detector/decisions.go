@@ -0,0 +1,367 @@
+package detector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Decision log destinations
+const (
+	DecisionDestinationTopic = "topic"
+	DecisionDestinationFile  = "file"
+)
+
+// Decision outcomes
+const (
+	OutcomeAlert  = "alert"
+	OutcomeNormal = "normal"
+)
+
+// CheckThresholds names the threshold check in decisions
+const CheckThresholds = "thresholds"
+
+// decisionQueueSize bounds the decisions waiting to be written
+const decisionQueueSize = 256
+
+// decisionWriteTimeout bounds writing one decision to its destination
+const decisionWriteTimeout = 10 * time.Second
+
+// CheckResult is the outcome of one check of a reading; Rule and Reason are
+// empty when the check passed
+type CheckResult struct {
+	Check  string `json:"check"`
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Decision records how the detector evaluated a reading: the values it saw,
+// the thresholds they were compared with, the result of every check and
+// whether an alert was raised
+type Decision struct {
+	DecidedAt  int64                `json:"decided_at"`
+	Topic      string               `json:"topic,omitempty"`
+	Partition  int32                `json:"partition"`
+	Offset     int64                `json:"offset"`
+	SensorID   string               `json:"sensor_id"`
+	Reading    *model.SensorReading `json:"reading"`
+	Thresholds Thresholds           `json:"thresholds"`
+	Checks     []CheckResult        `json:"checks"`
+	Outcome    string               `json:"outcome"`
+	Rule       string               `json:"rule,omitempty"`
+	Reason     string               `json:"reason,omitempty"`
+}
+
+// DecisionDestination stores decisions
+type DecisionDestination interface {
+	Write(ctx context.Context, decision *Decision) error
+	Close() error
+}
+
+// DecisionLogConfig configures a decision log
+type DecisionLogConfig struct {
+	// Rate is the fraction of readings whose decisions are recorded
+	Rate float64
+	// Sensors restricts the log to these sensor IDs (empty records every sensor)
+	Sensors []string
+	// Logger receives the decision log's logs (nil uses the default logger)
+	Logger *slog.Logger
+}
+
+// DecisionLogMetrics holds Prometheus metrics for the decision log
+type DecisionLogMetrics struct {
+	Recorded *prometheus.CounterVec
+	Dropped  prometheus.Counter
+	Errors   prometheus.Counter
+}
+
+// NewDecisionLogMetrics creates a new set of decision log metrics
+func NewDecisionLogMetrics(namespace, subsystem string, registry prometheus.Registerer) *DecisionLogMetrics {
+	metrics := &DecisionLogMetrics{
+		Recorded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "recorded_total",
+			Help:      "Total number of detector decisions recorded by outcome",
+		}, []string{"outcome"}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dropped_total",
+			Help:      "Total number of sampled decisions dropped because the queue was full",
+		}),
+		Errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Total number of decisions that failed to be written",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Recorded,
+		metrics.Dropped,
+		metrics.Errors,
+	)
+
+	return metrics
+}
+
+// DecisionLog records a random fraction of the detector's decisions, so a
+// missed anomaly can be investigated from what the detector saw without
+// replaying the data. Decisions are written in the background and dropped
+// when the queue is full, so detection never waits on the destination.
+type DecisionLog struct {
+	config      DecisionLogConfig
+	sensors     map[string]bool
+	destination DecisionDestination
+	metrics     *DecisionLogMetrics
+	queue       chan *Decision
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// NewDecisionLog creates a new decision log; metrics may be nil
+func NewDecisionLog(config DecisionLogConfig, destination DecisionDestination, metrics *DecisionLogMetrics) *DecisionLog {
+	config.Logger = logging.OrDefault(config.Logger)
+
+	var sensors map[string]bool
+	if len(config.Sensors) > 0 {
+		sensors = make(map[string]bool, len(config.Sensors))
+		for _, sensorID := range config.Sensors {
+			sensors[sensorID] = true
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DecisionLog{
+		config:      config,
+		sensors:     sensors,
+		destination: destination,
+		metrics:     metrics,
+		queue:       make(chan *Decision, decisionQueueSize),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// NewDecisionLogFromConfig creates a decision log from the DECISION_LOG_*
+// settings, or returns nil when it is disabled. Metrics are registered on
+// registry.
+func NewDecisionLogFromConfig(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*DecisionLog, error) {
+	if cfg.DecisionLogRate <= 0 {
+		return nil, nil
+	}
+	if cfg.DecisionLogRate > 1 {
+		return nil, fmt.Errorf("decision log rate must be at most 1, got %v", cfg.DecisionLogRate)
+	}
+
+	var destination DecisionDestination
+	switch cfg.DecisionLogDestination {
+	case DecisionDestinationTopic:
+		producer, err := kafka.NewProducer(kafka.ProducerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			Topic:           cfg.Topic(config.TopicKeyDecisions),
+			RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+			Idempotent:      cfg.ProducerIdempotent,
+			ReturnSuccesses: cfg.ProducerReturnSuccess,
+			ReturnErrors:    cfg.ProducerReturnErrors,
+			Metrics:         kafka.NewProducerMetrics("iot", "decision_producer", registry),
+			Version:         cfg.KafkaVersion,
+			SendTimeout:     cfg.ProducerSendTimeout,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentDetector),
+			Logger:          logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create decision producer: %w", err)
+		}
+		destination = NewTopicDecisionDestination(producer)
+	case DecisionDestinationFile:
+		fileDestination, err := NewFileDecisionDestination(cfg.DecisionLogFile)
+		if err != nil {
+			return nil, err
+		}
+		destination = fileDestination
+	default:
+		return nil, fmt.Errorf("unknown decision log destination %q (expected %s or %s)",
+			cfg.DecisionLogDestination, DecisionDestinationTopic, DecisionDestinationFile)
+	}
+
+	var sensors []string
+	for _, sensorID := range strings.Split(cfg.DecisionLogSensors, ",") {
+		if sensorID = strings.TrimSpace(sensorID); sensorID != "" {
+			sensors = append(sensors, sensorID)
+		}
+	}
+
+	return NewDecisionLog(DecisionLogConfig{
+		Rate:    cfg.DecisionLogRate,
+		Sensors: sensors,
+		Logger:  logger,
+	}, destination, NewDecisionLogMetrics("iot", "decision_log", registry)), nil
+}
+
+// Start starts writing decisions
+func (l *DecisionLog) Start() {
+	go l.run()
+}
+
+// Stop writes the queued decisions and closes the destination
+func (l *DecisionLog) Stop() {
+	l.cancel()
+	<-l.done
+	if err := l.destination.Close(); err != nil {
+		l.config.Logger.Error("Failed to close decision log destination", "error", err)
+	}
+}
+
+// sample starts the decision of a reading if it is sampled, or returns nil.
+// message is nil for readings evaluated outside a consumer.
+func (l *DecisionLog) sample(message *sarama.ConsumerMessage, reading *model.SensorReading) *Decision {
+	if l.sensors != nil && !l.sensors[reading.ID] {
+		return nil
+	}
+	if rand.Float64() >= l.config.Rate {
+		return nil
+	}
+
+	decision := &Decision{
+		DecidedAt: time.Now().UnixMilli(),
+		SensorID:  reading.ID,
+		Reading:   reading,
+	}
+	if message != nil {
+		decision.Topic = message.Topic
+		decision.Partition = message.Partition
+		decision.Offset = message.Offset
+	}
+	return decision
+}
+
+// record queues a complete decision
+func (l *DecisionLog) record(decision *Decision) {
+	select {
+	case l.queue <- decision:
+	default:
+		if l.metrics != nil {
+			l.metrics.Dropped.Inc()
+		}
+	}
+}
+
+func (l *DecisionLog) run() {
+	defer close(l.done)
+
+	for {
+		select {
+		case decision := <-l.queue:
+			l.write(decision)
+		case <-l.ctx.Done():
+			// Write what was recorded before the stop
+			for {
+				select {
+				case decision := <-l.queue:
+					l.write(decision)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *DecisionLog) write(decision *Decision) {
+	ctx, cancel := context.WithTimeout(context.Background(), decisionWriteTimeout)
+	defer cancel()
+
+	if err := l.destination.Write(ctx, decision); err != nil {
+		l.config.Logger.Warn("Failed to write decision", "sensor_id", decision.SensorID, "error", err)
+		if l.metrics != nil {
+			l.metrics.Errors.Inc()
+		}
+		return
+	}
+	if l.metrics != nil {
+		l.metrics.Recorded.WithLabelValues(decision.Outcome).Inc()
+	}
+}
+
+// checkName returns the name of a checker in decisions: its Name if it has
+// one, or its type
+func checkName(checker Checker) string {
+	if named, ok := checker.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", checker)
+}
+
+// TopicDecisionDestination writes decisions as JSON to a Kafka topic, keyed
+// by sensor ID
+type TopicDecisionDestination struct {
+	producer *kafka.Producer
+}
+
+// NewTopicDecisionDestination creates a destination writing to producer's topic
+func NewTopicDecisionDestination(producer *kafka.Producer) *TopicDecisionDestination {
+	return &TopicDecisionDestination{producer: producer}
+}
+
+// Write sends one decision
+func (d *TopicDecisionDestination) Write(ctx context.Context, decision *Decision) error {
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("failed to marshal decision: %w", err)
+	}
+	return d.producer.SendMessageWithKey(ctx, decision.SensorID, data)
+}
+
+// Close closes the producer
+func (d *TopicDecisionDestination) Close() error {
+	return d.producer.Close()
+}
+
+// FileDecisionDestination appends decisions to a file as NDJSON
+type FileDecisionDestination struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewFileDecisionDestination opens path for appending, creating it if needed
+func NewFileDecisionDestination(path string) (*FileDecisionDestination, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open decision log: %w", err)
+	}
+	return &FileDecisionDestination{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Write appends one decision as a line
+func (d *FileDecisionDestination) Write(ctx context.Context, decision *Decision) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.encoder.Encode(decision)
+}
+
+// Close closes the file
+func (d *FileDecisionDestination) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.file.Close()
+}
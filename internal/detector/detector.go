@@ -0,0 +1,371 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/capture"
+	"github.com/example/iot-sensor-fleet/internal/cutover"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the detector's stages, children of the span
+// of the message being handled
+var tracer = otel.Tracer("github.com/example/iot-sensor-fleet/internal/detector")
+
+// AnomalyDetector processes sensor readings and detects anomalies
+type AnomalyDetector struct {
+	consumer    *kafka.Consumer
+	producer    *kafka.Producer
+	dltProducer *kafka.Producer
+	metrics     *metrics.AnomalyDetectorMetrics
+	decoder     *model.ReadingDecoder
+	validator   *Validator
+	logger      *slog.Logger
+
+	// checkers optionally flag readings the thresholds do not, such as the stats engine
+	checkers []Checker
+
+	// bus optionally fans readings and alerts out to in-process components
+	bus *bus.Bus
+
+	// rateMonitor optionally tracks the fleet-wide ingest rate
+	rateMonitor *RateMonitor
+
+	// annotator optionally attaches runbook links and annotations to alerts
+	annotator model.Annotator
+
+	// sampler optionally captures a fraction of raw and decoded messages for debugging
+	sampler *capture.Sampler
+
+	// decisions optionally records a fraction of the detector's decisions
+	decisions *DecisionLog
+
+	// authority optionally routes the alerts of readings another detector
+	// color owns to shadowTopic during a blue/green deployment
+	authority   *cutover.Watcher
+	shadowTopic string
+
+	// pipeline runs messages through the detector's stages; running is set
+	// once its workers are started
+	pipeline *pipeline
+	running  bool
+}
+
+// NewAnomalyDetector creates a new anomaly detector
+func NewAnomalyDetector(
+	consumer *kafka.Consumer,
+	producer *kafka.Producer,
+	dltProducer *kafka.Producer,
+	metrics *metrics.AnomalyDetectorMetrics,
+	decoder *model.ReadingDecoder,
+	validator *Validator,
+	logger *slog.Logger,
+) *AnomalyDetector {
+	a := &AnomalyDetector{
+		consumer:    consumer,
+		producer:    producer,
+		dltProducer: dltProducer,
+		metrics:     metrics,
+		decoder:     decoder,
+		validator:   validator,
+		logger:      logging.OrDefault(logger),
+	}
+	a.pipeline = newPipeline(a, PipelineConfig{}, nil)
+	return a
+}
+
+// Start starts the pipeline and then the anomaly detector's consumer
+func (a *AnomalyDetector) Start() error {
+	if a.rateMonitor != nil {
+		a.rateMonitor.Start()
+	}
+	a.pipeline.start()
+	a.running = true
+	return a.consumer.Start()
+}
+
+// Stop stops the anomaly detector's consumer, which waits for the messages in
+// flight, and then the pipeline
+func (a *AnomalyDetector) Stop() {
+	a.consumer.Stop()
+	if a.running {
+		a.pipeline.stop()
+		a.running = false
+	}
+	if a.rateMonitor != nil {
+		a.rateMonitor.Stop()
+	}
+}
+
+// SetConsumer sets the consumer feeding the detector.
+// The consumer is created after the detector because it needs HandleMessage.
+func (a *AnomalyDetector) SetConsumer(consumer *kafka.Consumer) {
+	a.consumer = consumer
+}
+
+// SetBus sets the in-process bus readings and alerts are published to
+func (a *AnomalyDetector) SetBus(b *bus.Bus) {
+	a.bus = b
+}
+
+// SetRateMonitor sets the fleet-level ingest rate monitor fed by every consumed message
+func (a *AnomalyDetector) SetRateMonitor(m *RateMonitor) {
+	a.rateMonitor = m
+}
+
+// AddChecker adds a check run on every decoded reading after the thresholds
+func (a *AnomalyDetector) AddChecker(checker Checker) {
+	a.checkers = append(a.checkers, checker)
+}
+
+// SetSampler sets the payload sampler fed by every consumed message
+func (a *AnomalyDetector) SetSampler(sampler *capture.Sampler) {
+	a.sampler = sampler
+}
+
+// SetDecisionLog sets the log a sample of decisions is recorded in
+func (a *AnomalyDetector) SetDecisionLog(decisions *DecisionLog) {
+	a.decisions = decisions
+}
+
+// SetAuthority sets the blue/green authority alerts are routed by and the
+// topic alerts of readings owned by the other color are sent to
+func (a *AnomalyDetector) SetAuthority(w *cutover.Watcher, shadowTopic string) {
+	a.authority = w
+	a.shadowTopic = shadowTopic
+}
+
+// SetPipeline sizes the stages of the detector's pipeline and sets their
+// metrics (optional). It must be called before Start.
+func (a *AnomalyDetector) SetPipeline(config PipelineConfig, metrics *PipelineMetrics) {
+	a.pipeline = newPipeline(a, config, metrics)
+}
+
+// SetAnnotator sets the source of runbook links and annotations for alerts
+func (a *AnomalyDetector) SetAnnotator(annotator model.Annotator) {
+	a.annotator = annotator
+}
+
+// HandleMessage processes a message from Kafka; ctx bounds the alert and DLT
+// sends. Once the detector is started, the message goes through the stages
+// of its pipeline and HandleMessage waits until it is done.
+func (a *AnomalyDetector) HandleMessage(ctx context.Context, message *sarama.ConsumerMessage) error {
+	j := &job{ctx: ctx, message: message, start: time.Now(), done: make(chan struct{})}
+	var err error
+	if a.running {
+		err = a.pipeline.submit(j)
+	} else {
+		err = a.pipeline.runInline(j)
+	}
+
+	// Update processing latency metric
+	if err == nil && j.decodeErr == nil && a.metrics != nil {
+		a.metrics.ProcessingLatency.Observe(time.Since(j.start).Seconds())
+	}
+	return err
+}
+
+// Evaluate runs a reading through the thresholds and every added checker, as
+// a consumed reading is, and returns the alert it raises, or nil. Nothing is
+// sent, so it lets a detector without Kafka clients replay readings from
+// elsewhere. Stateful checkers must see the readings in order.
+func (a *AnomalyDetector) Evaluate(reading *model.SensorReading) *model.SensorAlert {
+	j := &job{ctx: context.Background(), reading: reading}
+	a.validate(j)
+	if !a.detect(j) {
+		return nil
+	}
+	return a.newAlert(reading, j.rule, j.reason)
+}
+
+// decode deserializes the message of a job, sniffing the wire format
+// configured for the topic, and reports whether it decoded
+func (a *AnomalyDetector) decode(j *job) bool {
+	if a.metrics != nil {
+		a.metrics.MessagesProcessedTotal.Inc()
+	}
+	if a.rateMonitor != nil {
+		a.rateMonitor.Observe()
+	}
+	if a.authority != nil {
+		j.shadow = !a.authority.Authoritative(j.message)
+	}
+
+	_, span := tracer.Start(j.ctx, "decode reading")
+	j.reading, j.format, j.decodeErr = a.decoder.DecodeFormat(j.message.Topic, j.message.Value, kafka.PayloadFormat(j.message))
+	span.SetAttributes(attribute.String("format", j.format))
+	tracing.End(span, j.decodeErr)
+	if a.sampler != nil {
+		a.sampler.Capture(j.message, j.reading, j.format, j.decodeErr)
+	}
+	if j.decodeErr != nil {
+		a.logger.With(kafka.MessageLogAttrs(j.message)...).Warn("Error deserializing message", "error", j.decodeErr)
+		return false
+	}
+
+	kafka.AddInflightBytes(j.ctx, j.reading.MemorySize())
+	if a.metrics != nil {
+		a.metrics.DecodedMessagesTotal.WithLabelValues(j.message.Topic, j.format).Inc()
+	}
+	if a.bus != nil {
+		a.bus.Publish(bus.TopicReadings, j.reading.ID, j.reading)
+	}
+	return true
+}
+
+// validate checks the reading of a job against its sensor's thresholds
+func (a *AnomalyDetector) validate(j *job) {
+	_, span := tracer.Start(j.ctx, "validate reading")
+	j.rule, j.reason = a.validator.Check(j.reading)
+	if j.rule != "" {
+		span.SetAttributes(attribute.String("rule", j.rule))
+	}
+	span.End()
+}
+
+// detect runs every added checker, so stateful checkers see every reading,
+// keeps the first violation and reports whether there is one. Sampled
+// readings have the result of every check recorded in the decision log.
+func (a *AnomalyDetector) detect(j *job) bool {
+	var decision *Decision
+	if a.decisions != nil {
+		decision = a.decisions.sample(j.message, j.reading)
+	}
+	if decision != nil {
+		decision.Thresholds = a.validator.Thresholds(j.reading.ID)
+		decision.Checks = append(decision.Checks, CheckResult{Check: CheckThresholds, Rule: j.rule, Reason: j.reason})
+	}
+
+	for _, checker := range a.checkers {
+		rule, reason := checker.Check(j.reading)
+		if decision != nil {
+			decision.Checks = append(decision.Checks, CheckResult{Check: checkName(checker), Rule: rule, Reason: reason})
+		}
+		if j.rule == "" {
+			j.rule, j.reason = rule, reason
+		}
+	}
+
+	if decision != nil {
+		decision.Outcome, decision.Rule, decision.Reason = OutcomeNormal, j.rule, j.reason
+		if j.rule != "" {
+			decision.Outcome = OutcomeAlert
+		}
+		a.decisions.record(decision)
+	}
+	return j.rule != ""
+}
+
+// emit sends the alert of a job's reading, or its message to the DLT if it
+// did not decode. In shadow the alert goes to the shadow topic, and
+// undecodable messages are left for the authoritative detector to
+// dead-letter.
+func (a *AnomalyDetector) emit(j *job) {
+	if j.decodeErr != nil {
+		if j.shadow {
+			return
+		}
+		j.err = a.deadLetter(j.ctx, j.message, j.decodeErr)
+		return
+	}
+	j.err = a.sendAlert(j.ctx, j.reading, j.rule, j.reason, j.shadow)
+}
+
+// deadLetter sends an undecodable message to the DLT with the reason, so it
+// can be filtered and replayed. A dead-lettered message is handled: retrying
+// would only decode it the same way and dead-letter it again.
+func (a *AnomalyDetector) deadLetter(ctx context.Context, message *sarama.ConsumerMessage, reason error) error {
+	if a.dltProducer == nil {
+		return reason
+	}
+	dltCtx := kafka.ContextWithHeaders(ctx, kafka.DLTHeaders(ctx, message, reason, time.Now())...)
+	if err := a.dltProducer.SendMessage(dltCtx, message.Key, message.Value); err != nil {
+		a.logger.With(kafka.MessageLogAttrs(message)...).Error("Error sending message to DLT", "error", err)
+		return reason
+	}
+	if a.metrics != nil {
+		a.metrics.DLTMessagesTotal.Inc()
+	}
+	return nil
+}
+
+// newAlert creates and annotates the alert for a reading that violated rule
+func (a *AnomalyDetector) newAlert(reading *model.SensorReading, rule, reason string) *model.SensorAlert {
+	alert := model.NewSensorAlert(reading, reason)
+	alert.Rule = rule
+	alert.Tags = a.validator.Tags(reading.ID)
+	if a.annotator != nil {
+		alert.RunbookURL, alert.Annotations = a.annotator.Annotate(rule, reading.Site)
+	}
+	return alert
+}
+
+// sendAlert creates, annotates and sends the alert for a reading that
+// violated rule, to the shadow topic if shadow is set
+func (a *AnomalyDetector) sendAlert(ctx context.Context, reading *model.SensorReading, rule, reason string, shadow bool) (err error) {
+	ctx, span := tracer.Start(ctx, "send alert", trace.WithAttributes(attribute.String("rule", rule), attribute.Bool("shadow", shadow)))
+	defer func() { tracing.End(span, err) }()
+
+	logger := a.logger
+	if shadow {
+		logger = logger.With("shadow", true)
+	}
+	logger.Info("Anomaly detected", "sensor_id", reading.ID, "rule", rule, "reason", reason,
+		"temperature", reading.Temperature, "humidity", reading.Humidity)
+
+	// Create alert
+	alert := a.newAlert(reading, rule, reason)
+
+	// Serialize alert
+	_, serializeSpan := tracer.Start(ctx, "serialize alert")
+	alertData, err := model.SerializeSensorAlert(alert)
+	tracing.End(serializeSpan, err)
+	if err != nil {
+		a.logger.Error("Error serializing alert", "sensor_id", reading.ID, "error", err)
+		return err
+	}
+
+	headers := []sarama.RecordHeader{kafka.SchemaVersionHeader(model.SchemaVersion)}
+	if a.authority != nil {
+		headers = append(headers, kafka.DetectorColorHeader(a.authority.Color()))
+	}
+	ctx = kafka.AppendHop(ctx, kafka.HopDetected, time.Now())
+
+	// Send the alert of a reading the other color owns to the shadow topic
+	// only, for comparison
+	if shadow {
+		if err := a.producer.SendMessageToTopic(ctx, a.shadowTopic, []byte(alert.SensorID), alertData, headers...); err != nil {
+			return fmt.Errorf("failed to send shadow alert: %w", err)
+		}
+		if a.metrics != nil {
+			a.metrics.ShadowAlertsTotal.WithLabelValues(metrics.AlertLabelValues(alert)...).Inc()
+		}
+		return nil
+	}
+
+	// Send alert to Kafka
+	if err := a.producer.SendMessageWithKey(ctx, alert.SensorID, alertData, headers...); err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	if a.bus != nil {
+		a.bus.Publish(bus.TopicAlerts, alert.SensorID, alert)
+	}
+
+	// Update metrics
+	if a.metrics != nil {
+		a.metrics.AlertsGeneratedTotal.WithLabelValues(metrics.AlertLabelValues(alert)...).Inc()
+	}
+	return nil
+}
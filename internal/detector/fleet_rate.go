@@ -0,0 +1,327 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/cutover"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RateMonitorConfig configures fleet-level ingest rate anomaly detection
+type RateMonitorConfig struct {
+	// Window is the interval over which the message rate is measured
+	Window time.Duration
+	// Alpha is the EWMA smoothing factor applied to the baseline (0 < Alpha <= 1)
+	Alpha float64
+	// DropRatio raises a rate_drop alert when rate < baseline * DropRatio
+	DropRatio float64
+	// SurgeRatio raises a rate_surge alert when rate > baseline * SurgeRatio
+	SurgeRatio float64
+	// Warmup is the number of windows used to establish a baseline before alerting
+	Warmup int
+	// Logger receives the monitor's logs (nil uses the default logger)
+	Logger *slog.Logger
+}
+
+// RateMonitorMetrics holds Prometheus metrics for fleet rate monitoring
+type RateMonitorMetrics struct {
+	Rate     prometheus.Gauge
+	Baseline prometheus.Gauge
+	Alerts   *prometheus.CounterVec
+}
+
+// NewRateMonitorMetrics creates a new set of rate monitor metrics
+func NewRateMonitorMetrics(namespace, subsystem string, registry prometheus.Registerer) *RateMonitorMetrics {
+	metrics := &RateMonitorMetrics{
+		Rate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "fleet_ingest_rate",
+			Help:      "Fleet-wide messages per second consumed in the last window",
+		}),
+		Baseline: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "fleet_ingest_rate_baseline",
+			Help:      "EWMA baseline of the fleet-wide ingest rate",
+		}),
+		Alerts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "fleet_alerts_total",
+			Help:      "Total number of fleet-level alerts by kind",
+		}, []string{"kind"}),
+	}
+
+	registry.MustRegister(metrics.Rate, metrics.Baseline, metrics.Alerts)
+
+	return metrics
+}
+
+// RateMonitor counts consumed messages and compares each window's rate against
+// an EWMA baseline, emitting fleet alerts on sudden drops (site outages) or
+// surges (runaway devices). The baseline is frozen while an anomaly is active
+// so an outage does not become the new normal. The count covers the
+// partitions of this replica, so a rebalance is not mistaken for an anomaly:
+// windows overlapping a rebalance are not evaluated, and the baseline is
+// learned again when the replica's partitions change.
+type RateMonitor struct {
+	config   RateMonitorConfig
+	producer *kafka.Producer
+	metrics  *RateMonitorMetrics
+	bus      *bus.Bus
+
+	// authority optionally holds fleet alerts back while another detector
+	// color owns the alert topics
+	authority *cutover.Watcher
+
+	count atomic.Int64
+
+	// mu guards the baseline state, which snapshots read and restore
+	mu       sync.Mutex
+	baseline float64
+	windows  int
+	active   string
+
+	// assignment identifies the partitions the baseline was learned on;
+	// rebalancing is set while the consumer has none, and disturbed once a
+	// rebalance touched the current window
+	assignment  string
+	rebalancing bool
+	disturbed   bool
+
+	// ctx is cancelled on Stop so in-flight alert sends are abandoned
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRateMonitor creates a new rate monitor; producer and metrics may be nil
+func NewRateMonitor(config RateMonitorConfig, producer *kafka.Producer, metrics *RateMonitorMetrics) (*RateMonitor, error) {
+	if config.Window <= 0 {
+		return nil, fmt.Errorf("rate window must be positive")
+	}
+	if config.Alpha <= 0 || config.Alpha > 1 {
+		return nil, fmt.Errorf("rate EWMA alpha must be in (0, 1], got %v", config.Alpha)
+	}
+	if config.DropRatio <= 0 || config.DropRatio >= 1 {
+		return nil, fmt.Errorf("rate drop ratio must be in (0, 1), got %v", config.DropRatio)
+	}
+	if config.SurgeRatio <= 1 {
+		return nil, fmt.Errorf("rate surge ratio must be greater than 1, got %v", config.SurgeRatio)
+	}
+	config.Logger = logging.OrDefault(config.Logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RateMonitor{
+		config:   config,
+		producer: producer,
+		metrics:  metrics,
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// SetBus sets the in-process bus fleet alerts are published to
+func (m *RateMonitor) SetBus(b *bus.Bus) {
+	m.bus = b
+}
+
+// SetAuthority sets the blue/green authority fleet alerts are sent under
+func (m *RateMonitor) SetAuthority(w *cutover.Watcher) {
+	m.authority = w
+}
+
+// Observe records one consumed message
+func (m *RateMonitor) Observe() {
+	m.count.Add(1)
+}
+
+// Revoked holds off evaluating windows while the consumer rebalances
+func (m *RateMonitor) Revoked() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rebalancing, m.disturbed = true, true
+}
+
+// Assigned resumes evaluating windows after a rebalance, learning the
+// baseline again if the consumer's partitions changed
+func (m *RateMonitor) Assigned(claims map[string][]int32) {
+	assignment := assignmentKey(claims)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rebalancing, m.disturbed = false, true
+	if m.assignment != "" && assignment != m.assignment {
+		m.config.Logger.Info("Consumer partitions changed, learning the fleet ingest rate baseline again", "baseline", m.baseline)
+		m.baseline, m.windows, m.active = 0, 0, ""
+	}
+	m.assignment = assignment
+}
+
+// assignmentKey returns claims as a string that is the same for the same partitions
+func assignmentKey(claims map[string][]int32) string {
+	topics := make([]string, 0, len(claims))
+	for topic := range claims {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	var key strings.Builder
+	for _, topic := range topics {
+		partitions := append([]int32(nil), claims[topic]...)
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		fmt.Fprintf(&key, "%s%v;", topic, partitions)
+	}
+	return key.String()
+}
+
+// RateState is the restorable state of a rate monitor
+type RateState struct {
+	Baseline float64 `json:"baseline"`
+	Windows  int     `json:"windows"`
+	Active   string  `json:"active,omitempty"`
+}
+
+// State returns the current baseline state
+func (m *RateMonitor) State() RateState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return RateState{Baseline: m.baseline, Windows: m.windows, Active: m.active}
+}
+
+// Restore replaces the baseline state, e.g. with one saved by another deployment
+func (m *RateMonitor) Restore(state RateState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.baseline = state.Baseline
+	m.windows = state.Windows
+	m.active = state.Active
+	if m.metrics != nil {
+		m.metrics.Baseline.Set(m.baseline)
+	}
+}
+
+// Start begins evaluating the rate every window
+func (m *RateMonitor) Start() {
+	m.wg.Add(1)
+	go m.run()
+}
+
+// Stop stops the monitor
+func (m *RateMonitor) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// run evaluates each completed window
+func (m *RateMonitor) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.Window)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(last).Seconds()
+			last = now
+			m.evaluate(float64(m.count.Swap(0))/elapsed, now)
+		}
+	}
+}
+
+// evaluate compares a window's rate with the baseline and updates state,
+// skipping windows a rebalance touched
+func (m *RateMonitor) evaluate(rate float64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.metrics != nil {
+		m.metrics.Rate.Set(rate)
+	}
+	if m.rebalancing || m.disturbed {
+		m.disturbed = false
+		return
+	}
+
+	m.windows++
+	if m.windows == 1 {
+		m.baseline = rate
+	}
+
+	kind := ""
+	if m.windows > m.config.Warmup && m.baseline > 0 {
+		switch {
+		case rate < m.baseline*m.config.DropRatio:
+			kind = model.FleetAlertRateDrop
+		case rate > m.baseline*m.config.SurgeRatio:
+			kind = model.FleetAlertRateSurge
+		}
+	}
+
+	if kind == "" {
+		if m.active != "" {
+			m.config.Logger.Info("Fleet ingest rate recovered", "kind", m.active, "rate", rate, "baseline", m.baseline)
+			m.active = ""
+		}
+		m.baseline = m.config.Alpha*rate + (1-m.config.Alpha)*m.baseline
+	} else if kind != m.active {
+		m.active = kind
+		m.emit(kind, rate, now)
+	}
+
+	if m.metrics != nil {
+		m.metrics.Baseline.Set(m.baseline)
+	}
+}
+
+// emit publishes a fleet alert
+func (m *RateMonitor) emit(kind string, rate float64, now time.Time) {
+	alert := &model.FleetAlert{
+		Kind:      kind,
+		Timestamp: now.UnixMilli(),
+		Reason:    fmt.Sprintf("Fleet ingest rate %.1f msg/s deviates from baseline %.1f msg/s", rate, m.baseline),
+		Rate:      rate,
+		Baseline:  m.baseline,
+		Window:    m.config.Window.Seconds(),
+	}
+	m.config.Logger.Warn("Fleet anomaly detected", "kind", kind, "rate", rate, "baseline", m.baseline)
+
+	if m.metrics != nil {
+		m.metrics.Alerts.WithLabelValues(kind).Inc()
+	}
+	if m.authority != nil && !m.authority.Leading() {
+		m.config.Logger.Info("Not sending fleet alert in shadow", "kind", kind)
+		return
+	}
+	if m.bus != nil {
+		m.bus.Publish(bus.TopicFleetAlerts, kind, alert)
+	}
+	if m.producer == nil {
+		return
+	}
+
+	data, err := model.SerializeFleetAlert(alert)
+	if err != nil {
+		m.config.Logger.Error("Error serializing fleet alert", "error", err)
+		return
+	}
+	if err := m.producer.SendMessageWithKey(m.ctx, kind, data); err != nil {
+		m.config.Logger.Error("Error sending fleet alert", "kind", kind, "error", err)
+	}
+}
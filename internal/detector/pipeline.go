@@ -0,0 +1,244 @@
+package detector
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Pipeline stage names, used as metric labels
+const (
+	StageDecode   = "decode"
+	StageValidate = "validate"
+	StageDetect   = "detect"
+	StageEmit     = "emit"
+)
+
+// DefaultStageBuffer is the queue capacity of each stage when none is configured
+const DefaultStageBuffer = 64
+
+// PipelineConfig sizes the stages a message goes through: decode → validate
+// → detect → emit. Decoding and checking are CPU-bound and emitting waits on
+// Kafka, so each stage has its own workers, fed by a bounded queue that holds
+// back the stage before it when full. Zero values take the defaults.
+type PipelineConfig struct {
+	// DecodeWorkers decode payloads (default GOMAXPROCS)
+	DecodeWorkers int
+	// DetectWorkers run the threshold check, and as many run the other
+	// checkers (default GOMAXPROCS)
+	DetectWorkers int
+	// EmitWorkers send alerts and dead-lettered messages (default
+	// kafka.DefaultWorkerPoolSize)
+	EmitWorkers int
+	// Buffer is the queue capacity of each stage (default DefaultStageBuffer)
+	Buffer int
+}
+
+// withDefaults fills in the zero values of c
+func (c PipelineConfig) withDefaults() PipelineConfig {
+	if c.DecodeWorkers <= 0 {
+		c.DecodeWorkers = runtime.GOMAXPROCS(0)
+	}
+	if c.DetectWorkers <= 0 {
+		c.DetectWorkers = runtime.GOMAXPROCS(0)
+	}
+	if c.EmitWorkers <= 0 {
+		c.EmitWorkers = kafka.DefaultWorkerPoolSize
+	}
+	if c.Buffer <= 0 {
+		c.Buffer = DefaultStageBuffer
+	}
+	return c
+}
+
+// PipelineMetrics holds Prometheus metrics for the detector's stages
+type PipelineMetrics struct {
+	StageDuration *prometheus.HistogramVec
+	QueueWait     *prometheus.HistogramVec
+}
+
+// NewPipelineMetrics creates a new set of pipeline metrics
+func NewPipelineMetrics(namespace, subsystem string, registry prometheus.Registerer) *PipelineMetrics {
+	metrics := &PipelineMetrics{
+		StageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "stage_duration_seconds",
+			Help:      "Time a pipeline stage spent on a message in seconds",
+			Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}, []string{"stage"}),
+		QueueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "stage_queue_wait_seconds",
+			Help:      "Time a message waited in a pipeline stage's queue in seconds",
+			Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}, []string{"stage"}),
+	}
+
+	registry.MustRegister(metrics.StageDuration, metrics.QueueWait)
+
+	return metrics
+}
+
+// job is a message on its way through the pipeline
+type job struct {
+	ctx     context.Context
+	message *sarama.ConsumerMessage
+	start   time.Time
+	queued  time.Time
+
+	// Set by the decode stage
+	reading   *model.SensorReading
+	format    string
+	decodeErr error
+	// shadow is set when another detector color owns the alert of the reading
+	shadow bool
+
+	// Set by the validate and detect stages
+	rule, reason string
+
+	// err is returned to the consumer once the job is done
+	err  error
+	done chan struct{}
+}
+
+// stage is a step of the pipeline run by a pool of workers
+type stage struct {
+	name    string
+	workers int
+	queue   chan *job
+	wg      sync.WaitGroup
+
+	// handle processes a job and returns the stage it goes to next, or nil
+	// when it is done
+	handle func(*job) *stage
+}
+
+// pipeline runs the detector's stages
+type pipeline struct {
+	metrics *PipelineMetrics
+	stages  []*stage
+}
+
+// newPipeline creates the stages of a, in order
+func newPipeline(a *AnomalyDetector, config PipelineConfig, metrics *PipelineMetrics) *pipeline {
+	config = config.withDefaults()
+	p := &pipeline{metrics: metrics}
+
+	decode := &stage{name: StageDecode, workers: config.DecodeWorkers}
+	validate := &stage{name: StageValidate, workers: config.DetectWorkers}
+	detect := &stage{name: StageDetect, workers: config.DetectWorkers}
+	emit := &stage{name: StageEmit, workers: config.EmitWorkers}
+
+	decode.handle = func(j *job) *stage {
+		if a.decode(j) {
+			return validate
+		}
+		return emit
+	}
+	validate.handle = func(j *job) *stage {
+		a.validate(j)
+		if len(a.checkers) > 0 {
+			return detect
+		}
+		if j.rule != "" {
+			return emit
+		}
+		return nil
+	}
+	detect.handle = func(j *job) *stage {
+		if a.detect(j) {
+			return emit
+		}
+		return nil
+	}
+	emit.handle = func(j *job) *stage {
+		a.emit(j)
+		return nil
+	}
+
+	p.stages = []*stage{decode, validate, detect, emit}
+	for _, s := range p.stages {
+		s.queue = make(chan *job, config.Buffer)
+	}
+	return p
+}
+
+// start starts the workers of every stage
+func (p *pipeline) start() {
+	for _, s := range p.stages {
+		for i := 0; i < s.workers; i++ {
+			s.wg.Add(1)
+			go p.work(s)
+		}
+	}
+}
+
+// stop lets every stage finish its queued jobs and stops the workers. No
+// jobs may be submitted once it is called.
+func (p *pipeline) stop() {
+	for _, s := range p.stages {
+		close(s.queue)
+		s.wg.Wait()
+	}
+}
+
+// submit runs a job through the pipeline and waits until it is done
+func (p *pipeline) submit(j *job) error {
+	j.queued = time.Now()
+	select {
+	case p.stages[0].queue <- j:
+	case <-j.ctx.Done():
+		return j.ctx.Err()
+	}
+	<-j.done
+	return j.err
+}
+
+// work processes the jobs of a stage until its queue is closed
+func (p *pipeline) work(s *stage) {
+	defer s.wg.Done()
+	for j := range s.queue {
+		start := time.Now()
+		if p.metrics != nil {
+			p.metrics.QueueWait.WithLabelValues(s.name).Observe(start.Sub(j.queued).Seconds())
+		}
+
+		// A job whose handler gave up is not worth finishing
+		var next *stage
+		if err := j.ctx.Err(); err != nil {
+			j.err = err
+		} else {
+			next = s.handle(j)
+		}
+		if p.metrics != nil {
+			p.metrics.StageDuration.WithLabelValues(s.name).Observe(time.Since(start).Seconds())
+		}
+
+		if next == nil {
+			close(j.done)
+			continue
+		}
+		j.queued = time.Now()
+		next.queue <- j
+	}
+}
+
+// runInline runs a job through the stages on the calling goroutine, for a
+// detector whose pipeline is not started
+func (p *pipeline) runInline(j *job) error {
+	for s := p.stages[0]; s != nil; {
+		if err := j.ctx.Err(); err != nil {
+			return err
+		}
+		s = s.handle(j)
+	}
+	return j.err
+}
@@ -0,0 +1,515 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/capture"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/cutover"
+	"github.com/example/iot-sensor-fleet/internal/heartbeat"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	sensorregistry "github.com/example/iot-sensor-fleet/internal/registry"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Service wires an AnomalyDetector to its Kafka clients and telemetry
+type Service struct {
+	Detector         *AnomalyDetector
+	alertProducer    *kafka.Producer
+	dltProducer      *kafka.Producer
+	fleetProducer    *kafka.Producer
+	clusterCollector *kafka.ClusterCollector
+	annotations      *sensorregistry.AnnotationCache
+	thresholds       *ThresholdRefresher
+	partitionSites   *sensorregistry.PartitionSiteCache
+	sampler          *capture.Sampler
+	decisions        *DecisionLog
+	authority        *cutover.Watcher
+	heartbeats       *heartbeat.Publisher
+
+	// Saturation derives autoscaling hints from the consumer; nil when disabled
+	Saturation *kafka.SaturationMonitor
+
+	// Snapshots saves and restores the detector state; nil when neither the
+	// admin endpoints nor a restore are configured
+	Snapshots *Snapshotter
+}
+
+// NewService creates a fully wired anomaly detector from configuration.
+// Metrics are registered on registry; eventBus and logger may be nil.
+func NewService(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (*Service, error) {
+	s := &Service{}
+	logger = logging.OrDefault(logger).With("component", "detector")
+
+	// Create anomaly detector metrics
+	anomalyMetrics := metrics.NewAnomalyDetectorMetrics(registry)
+
+	// Create Kafka producer metrics for the alert producer
+	alertProducerMetrics := kafka.NewProducerMetrics("iot", "alert_producer", registry)
+
+	// Create Kafka producer metrics for the DLT producer
+	dltProducerMetrics := kafka.NewProducerMetrics("iot", "dlt_producer", registry)
+
+	// Create Kafka consumer metrics
+	consumerMetrics := kafka.NewConsumerMetrics("iot", "sensor_consumer", registry)
+
+	// Create Kafka alert producer
+	alertProducer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:         cfg.KafkaBrokers,
+		Topic:           cfg.Topic(config.TopicKeySensorAlert),
+		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+		Idempotent:      cfg.ProducerIdempotent,
+		ReturnSuccesses: cfg.ProducerReturnSuccess,
+		ReturnErrors:    cfg.ProducerReturnErrors,
+		Metrics:         alertProducerMetrics,
+		Version:         cfg.KafkaVersion,
+		SendTimeout:     cfg.ProducerSendTimeout,
+		HopHeaders:      cfg.HopHeaders,
+		Security:        kafka.SecurityFromConfig(cfg),
+		Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentDetector),
+		Logger:          logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create alert producer: %w", err)
+	}
+	s.alertProducer = alertProducer
+
+	// Create Kafka DLT producer for the dead-letter topic paired with the raw topic
+	if dltTopic := cfg.TopicDLT(config.TopicKeySensorRaw); dltTopic != "" {
+		dltProducer, err := kafka.NewProducer(kafka.ProducerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			Topic:           dltTopic,
+			RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+			Idempotent:      cfg.ProducerIdempotent,
+			ReturnSuccesses: cfg.ProducerReturnSuccess,
+			ReturnErrors:    cfg.ProducerReturnErrors,
+			Metrics:         dltProducerMetrics,
+			Version:         cfg.KafkaVersion,
+			SendTimeout:     cfg.ProducerSendTimeout,
+			HopHeaders:      cfg.HopHeaders,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentDetector),
+			Logger:          logger,
+		})
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("failed to create DLT producer: %w", err)
+		}
+		s.dltProducer = dltProducer
+	}
+
+	// Create Kafka cluster telemetry collector
+	if cfg.ClusterMetricsInterval > 0 {
+		clusterMetrics := kafka.NewClusterMetrics("iot", "kafka_cluster", registry)
+		opts := []kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}
+		security, err := kafka.SecurityFromConfig(cfg).Options()
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("invalid Kafka security settings: %w", err)
+		}
+		clusterCollector, err := kafka.NewClusterCollector(
+			cfg.KafkaBrokers,
+			append(cfg.RawTopics(), cfg.Topic(config.TopicKeySensorAlert), cfg.Topic(config.TopicKeySensorRawDLT), cfg.Topic(config.TopicKeyFleetAlert)),
+			cfg.ClusterMetricsInterval,
+			clusterMetrics,
+			logger,
+			append(opts, security...)...,
+		)
+		if err != nil {
+			logger.Warn("Failed to create cluster metrics collector", "error", err)
+		} else {
+			s.clusterCollector = clusterCollector
+		}
+	}
+
+	// Create the per-topic reading decoder
+	decoder, err := model.NewReadingDecoder(cfg.TopicFormats())
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to create reading decoder: %w", err)
+	}
+
+	// Check readings against the configured thresholds and per-sensor overrides
+	validator, err := newValidatorFromConfig(cfg)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	// Create anomaly detector instance
+	detector := NewAnomalyDetector(
+		nil, // Will be set after consumer creation
+		alertProducer,
+		s.dltProducer,
+		anomalyMetrics,
+		decoder,
+		validator,
+		logger,
+	)
+	detector.SetBus(eventBus)
+
+	// Run decoding, checks and sends in separately sized stages
+	detector.SetPipeline(PipelineConfig{
+		DecodeWorkers: cfg.DetectorDecodeWorkers,
+		DetectWorkers: cfg.DetectorDetectWorkers,
+		EmitWorkers:   cfg.DetectorEmitWorkers,
+		Buffer:        cfg.DetectorStageBuffer,
+	}, NewPipelineMetrics("iot", "anomaly_detector", registry))
+
+	// Attach runbook links and annotations from the sensor registry
+	annotations, err := sensorregistry.NewAnnotationCacheFromConfig(cfg, logger)
+	if err != nil {
+		logger.Warn("Alerts will not be annotated", "error", err)
+	} else if annotations != nil {
+		s.annotations = annotations
+		detector.SetAnnotator(annotations)
+	}
+
+	// Apply thresholds managed through the sensor registry API
+	thresholds, err := NewThresholdRefresherFromConfig(cfg, validator, logger)
+	if err != nil {
+		logger.Warn("Stored thresholds will not be applied", "error", err)
+	} else if thresholds != nil {
+		s.thresholds = thresholds
+	}
+
+	// Keep the partitions of a site on one replica when assigned by site
+	var partitionSites kafka.PartitionSitesFunc
+	if cfg.ConsumerBalanceStrategy == kafka.BalanceStrategySiteAffinity {
+		cache, err := sensorregistry.NewPartitionSiteCacheFromConfig(cfg, logger)
+		if err != nil {
+			logger.Warn("Partitions will be assigned without their sites", "error", err)
+		} else if cache != nil {
+			s.partitionSites = cache
+			partitionSites = cache.Sites
+		}
+	}
+
+	// Capture a sample of consumed messages for debugging when enabled
+	sampler, err := capture.NewSamplerFromConfig(cfg, registry, logger)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("invalid payload capture configuration: %w", err)
+	}
+	if sampler != nil {
+		s.sampler = sampler
+		detector.SetSampler(sampler)
+	}
+
+	// Record a sample of detection decisions when enabled
+	decisions, err := NewDecisionLogFromConfig(cfg, registry, logger)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("invalid decision log configuration: %w", err)
+	}
+	if decisions != nil {
+		s.decisions = decisions
+		detector.SetDecisionLog(decisions)
+	}
+
+	// Flag readings far from their sensor's recent values
+	if err := addCheckersFromConfig(detector, cfg, registry); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	// Route alerts by the blue/green authority when deployed with a color
+	authority, err := cutover.NewWatcherFromConfig(cfg, registry, logger)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	if authority != nil {
+		s.authority = authority
+		detector.SetAuthority(authority, cfg.Topic(config.TopicKeyShadowAlert))
+	}
+
+	// Create the fleet-level ingest rate monitor and its alert producer; it
+	// follows the consumer's rebalances
+	var rebalances kafka.RebalanceObserver
+	if cfg.FleetRateWindow > 0 {
+		fleetProducer, err := kafka.NewProducer(kafka.ProducerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			Topic:           cfg.Topic(config.TopicKeyFleetAlert),
+			RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+			Idempotent:      cfg.ProducerIdempotent,
+			ReturnSuccesses: cfg.ProducerReturnSuccess,
+			ReturnErrors:    cfg.ProducerReturnErrors,
+			Metrics:         kafka.NewProducerMetrics("iot", "fleet_alert_producer", registry),
+			Version:         cfg.KafkaVersion,
+			SendTimeout:     cfg.ProducerSendTimeout,
+			HopHeaders:      cfg.HopHeaders,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentDetector),
+			Logger:          logger,
+		})
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("failed to create fleet alert producer: %w", err)
+		}
+		s.fleetProducer = fleetProducer
+
+		rateMonitor, err := NewRateMonitor(RateMonitorConfig{
+			Window:     cfg.FleetRateWindow,
+			Alpha:      cfg.FleetRateAlpha,
+			DropRatio:  cfg.FleetRateDropRatio,
+			SurgeRatio: cfg.FleetRateSurgeRatio,
+			Warmup:     cfg.FleetRateWarmup,
+			Logger:     logger,
+		}, fleetProducer, NewRateMonitorMetrics("iot", "anomaly_detector", registry))
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("invalid fleet rate configuration: %w", err)
+		}
+		rateMonitor.SetBus(eventBus)
+		if s.authority != nil {
+			rateMonitor.SetAuthority(s.authority)
+		}
+		detector.SetRateMonitor(rateMonitor)
+		rebalances = rateMonitor
+	}
+
+	// Save and restore the detector state in MinIO
+	if cfg.DetectorAdminToken != "" || cfg.DetectorRestoreSnapshot != "" {
+		store, err := storage.NewS3StoreFromConfig(cfg)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("invalid snapshot storage configuration: %w", err)
+		}
+		s.Snapshots = NewSnapshotter(store, cfg.DetectorSnapshotPrefix, detector, cfg.DetectorAdminToken)
+
+		if cfg.DetectorRestoreSnapshot != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+			err := s.Snapshots.Restore(ctx, cfg.DetectorRestoreSnapshot)
+			cancel()
+			if err != nil {
+				s.close()
+				return nil, fmt.Errorf("failed to restore detector snapshot: %w", err)
+			}
+		}
+	}
+
+	// Create clock diagnostic metrics when enabled
+	var clockMetrics *kafka.ClockMetrics
+	if cfg.ClockDiagnostics {
+		clockMetrics = kafka.NewClockMetrics("iot", "sensor_consumer", registry)
+	}
+
+	// Derive autoscaling hints from the consumer's partition and handler load
+	if cfg.AutoscaleWindow > 0 {
+		s.Saturation = kafka.NewSaturationMonitor(kafka.SaturationConfig{
+			Window:            cfg.AutoscaleWindow,
+			PartitionCapacity: cfg.AutoscalePartitionCapacity,
+			TargetUtilization: cfg.AutoscaleTargetUtilization,
+			Logger:            logger,
+		}, kafka.NewSaturationMetrics("iot", "sensor_consumer", registry))
+	}
+
+	// Publish the partitions and progress of this replica when heartbeats are enabled
+	var progress *kafka.PartitionProgress
+	if cfg.HeartbeatInterval > 0 {
+		progress = kafka.NewPartitionProgress()
+		heartbeats, err := heartbeat.NewPublisherFromConfig(cfg, progress, registry, logger)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.heartbeats = heartbeats
+	}
+
+	// Commit alerts, DLT entries and offsets together when exactly-once is enabled
+	var transaction *kafka.TransactionConfig
+	if cfg.DetectorTransactionalID != "" {
+		transaction = &kafka.TransactionConfig{ID: cfg.DetectorTransactionalID, HopHeaders: cfg.HopHeaders}
+	}
+
+	// Readings whose alert cannot be sent after retries join the undecodable
+	// ones in the DLT when enabled
+	var deadLetter kafka.DeadLetterFunc
+	if cfg.ConsumerDeadLetter && s.dltProducer != nil {
+		deadLetter = kafka.DeadLetterTo(s.dltProducer, nil)
+	}
+
+	// Create Kafka consumer
+	consumer, err := kafka.NewConsumer(
+		kafka.ConsumerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			GroupID:         cfg.ConsumerGroupID,
+			Topics:          cfg.RawTopics(),
+			TopicWeights:    cfg.RawTopicWeights(),
+			OffsetInitial:   cfg.ConsumerOffsetInitial,
+			ReturnErrors:    cfg.ConsumerReturnErrors,
+			Metrics:         consumerMetrics,
+			Version:         cfg.KafkaVersion,
+			HandlerTimeout:  cfg.ConsumerHandlerTimeout,
+			DrainTimeout:    cfg.ConsumerDrainTimeout,
+			HopHeaders:      cfg.HopHeaders,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ConsumerRetryFromConfig(cfg, config.RetryComponentDetector),
+			DeadLetter:      deadLetter,
+			BalanceStrategy: cfg.ConsumerBalanceStrategy,
+			PartitionSites:  partitionSites,
+			ClockMetrics:    clockMetrics,
+			Saturation:      s.Saturation,
+			Progress:        progress,
+			Rebalances:      rebalances,
+			Transaction:     transaction,
+			InflightBudget:  kafka.InflightBudgetFromConfig(cfg, registry),
+			Schemas:         kafka.SchemaTrackerFromConfig(cfg, registry),
+			LagInterval:     cfg.ConsumerLagInterval,
+			LagGauge:        anomalyMetrics.ConsumerLag,
+			Logger:          logger,
+		},
+		detector.HandleMessage,
+	)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	// Set the consumer in the detector
+	detector.SetConsumer(consumer)
+	s.Detector = detector
+
+	return s, nil
+}
+
+// NewOfflineDetector creates a detector with the configured thresholds and
+// checkers but no Kafka clients, whose only use is Evaluate. Readings replayed
+// through it raise the alerts the deployed detector would have, without
+// annotations and fleet-level rate alerts.
+func NewOfflineDetector(cfg *config.Config, registry prometheus.Registerer) (*AnomalyDetector, error) {
+	validator, err := newValidatorFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	detector := NewAnomalyDetector(nil, nil, nil, nil, nil, validator, nil)
+	if err := addCheckersFromConfig(detector, cfg, registry); err != nil {
+		return nil, err
+	}
+	return detector, nil
+}
+
+// newValidatorFromConfig creates a validator of the configured thresholds
+// and per-sensor overrides
+func newValidatorFromConfig(cfg *config.Config) (*Validator, error) {
+	thresholds := Thresholds{
+		MaxTemperature: cfg.MaxTemperature,
+		MinHumidity:    cfg.MinHumidity,
+		MinBatteryPct:  cfg.MinBatteryPct,
+		MinRSSI:        cfg.MinRSSI,
+	}
+	overrides, err := ParseThresholdOverrides(cfg.ThresholdOverrides, thresholds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid THRESHOLD_OVERRIDES: %w", err)
+	}
+	return NewValidator(thresholds, overrides), nil
+}
+
+// addCheckersFromConfig adds the configured checkers to a detector
+func addCheckersFromConfig(detector *AnomalyDetector, cfg *config.Config, registry prometheus.Registerer) error {
+	if cfg.StatsWindow > 0 {
+		stats, err := NewStatsEngine(StatsConfig{
+			Window:     cfg.StatsWindow,
+			Sigmas:     cfg.StatsSigmas,
+			Warmup:     cfg.StatsWarmup,
+			MaxSensors: cfg.StatsMaxSensors,
+		}, NewStatsMetrics("iot", "anomaly_detector", registry))
+		if err != nil {
+			return fmt.Errorf("invalid stats configuration: %w", err)
+		}
+		detector.AddChecker(stats)
+	}
+	return nil
+}
+
+// Start starts the detector and its telemetry. A blue/green detector first
+// reads the authority in force, and does not start without it.
+func (s *Service) Start() error {
+	if s.authority != nil {
+		if err := s.authority.Start(); err != nil {
+			return fmt.Errorf("failed to read detector authority: %w", err)
+		}
+	}
+	if s.clusterCollector != nil {
+		s.clusterCollector.Start()
+	}
+	if s.annotations != nil {
+		s.annotations.Start()
+	}
+	if s.thresholds != nil {
+		s.thresholds.Start()
+	}
+	// Loaded before the consumer joins its group, in case it leads
+	if s.partitionSites != nil {
+		s.partitionSites.Start()
+	}
+	if s.sampler != nil {
+		s.sampler.Start()
+	}
+	if s.decisions != nil {
+		s.decisions.Start()
+	}
+	if s.Saturation != nil {
+		s.Saturation.Start()
+	}
+	if s.heartbeats != nil {
+		s.heartbeats.Start()
+	}
+	return s.Detector.Start()
+}
+
+// Stop stops the detector and closes its Kafka clients
+func (s *Service) Stop() {
+	s.Detector.Stop()
+	if s.clusterCollector != nil {
+		s.clusterCollector.Stop()
+	}
+	if s.annotations != nil {
+		s.annotations.Stop()
+	}
+	if s.thresholds != nil {
+		s.thresholds.Stop()
+	}
+	if s.partitionSites != nil {
+		s.partitionSites.Stop()
+	}
+	if s.sampler != nil {
+		s.sampler.Stop()
+	}
+	if s.decisions != nil {
+		s.decisions.Stop()
+	}
+	if s.Saturation != nil {
+		s.Saturation.Stop()
+	}
+	// The consumer is stopped, so the last heartbeat releases the lease
+	if s.heartbeats != nil {
+		s.heartbeats.Stop()
+	}
+	s.close()
+}
+
+// close releases the producers and the authority watcher
+func (s *Service) close() {
+	if s.authority != nil {
+		s.authority.Stop()
+	}
+	if s.alertProducer != nil {
+		s.alertProducer.Close()
+	}
+	if s.dltProducer != nil {
+		s.dltProducer.Close()
+	}
+	if s.fleetProducer != nil {
+		s.fleetProducer.Close()
+	}
+}
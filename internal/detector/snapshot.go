@@ -0,0 +1,240 @@
+package detector
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/storage"
+)
+
+// SnapshotVersion is the version of the snapshot format written by this build.
+// Snapshots with a newer version are refused; fields a build does not know are
+// ignored, so older snapshots restore into newer builds.
+const SnapshotVersion = 1
+
+// snapshotTimeout bounds saving or loading one snapshot
+const snapshotTimeout = 30 * time.Second
+
+// snapshotName restricts snapshot names to a safe object key segment
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Snapshot errors
+var (
+	ErrSnapshotNotFound    = errors.New("snapshot not found")
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+)
+
+// Snapshot is the in-memory state of a detector
+type Snapshot struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Thresholds are the defaults of the deployment that took the snapshot;
+	// they are informational and not restored
+	Thresholds Thresholds            `json:"thresholds"`
+	Overrides  map[string]Thresholds `json:"overrides,omitempty"`
+	FleetRate  *RateState            `json:"fleet_rate,omitempty"`
+}
+
+// SnapshotStore reads and writes snapshot objects
+type SnapshotStore interface {
+	storage.ObjectStore
+	storage.ObjectReader
+}
+
+// Snapshotter saves a detector's state to object storage and restores it, so
+// the state can move across deployments that cannot share a consumer group.
+// It serves admin endpoints guarded by "Authorization: Bearer <adminToken>";
+// they are disabled when adminToken is empty:
+//
+//	POST /admin/snapshots[?name=<name>]  save a snapshot
+//	GET  /admin/snapshots/<name>         download a snapshot
+type Snapshotter struct {
+	store      SnapshotStore
+	prefix     string
+	detector   *AnomalyDetector
+	adminToken string
+	mux        *http.ServeMux
+}
+
+// NewSnapshotter creates a snapshotter storing snapshots under prefix
+func NewSnapshotter(store SnapshotStore, prefix string, detector *AnomalyDetector, adminToken string) *Snapshotter {
+	s := &Snapshotter{
+		store:      store,
+		prefix:     prefix,
+		detector:   detector,
+		adminToken: adminToken,
+		mux:        http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /admin/snapshots", s.requireAdmin(s.save))
+	s.mux.HandleFunc("GET /admin/snapshots/{name}", s.requireAdmin(s.get))
+	return s
+}
+
+// Save snapshots the detector under name; an empty name uses the current time
+func (s *Snapshotter) Save(ctx context.Context, name string) (*Snapshot, error) {
+	now := time.Now().UTC()
+	if name == "" {
+		name = now.Format("20060102T150405Z")
+	}
+	if !snapshotName.MatchString(name) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidSnapshotName, name)
+	}
+
+	snapshot := s.detector.snapshot()
+	snapshot.Name = name
+	snapshot.CreatedAt = now
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := s.store.Put(ctx, s.key(name), data, map[string]string{"Content-Type": "application/json"}); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot %s: %w", name, err)
+	}
+
+	s.detector.logger.Info("Saved detector snapshot", "name", name, "overrides", len(snapshot.Overrides))
+	return snapshot, nil
+}
+
+// Load reads a named snapshot
+func (s *Snapshotter) Load(ctx context.Context, name string) (*Snapshot, error) {
+	if !snapshotName.MatchString(name) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidSnapshotName, name)
+	}
+
+	data, err := s.store.Get(ctx, s.key(name))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot %s: %w", name, err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %w", name, err)
+	}
+	if snapshot.Version > SnapshotVersion {
+		return nil, fmt.Errorf("snapshot %s has version %d, this build reads up to %d", name, snapshot.Version, SnapshotVersion)
+	}
+	return &snapshot, nil
+}
+
+// Restore loads a named snapshot into the detector. It must be called before
+// the detector starts.
+func (s *Snapshotter) Restore(ctx context.Context, name string) error {
+	snapshot, err := s.Load(ctx, name)
+	if err != nil {
+		return err
+	}
+	s.detector.restore(snapshot)
+	return nil
+}
+
+// ServeHTTP serves the admin endpoints
+func (s *Snapshotter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// key returns the object key of a snapshot
+func (s *Snapshotter) key(name string) string {
+	return path.Join(s.prefix, name+".json")
+}
+
+func (s *Snapshotter) save(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), snapshotTimeout)
+	defer cancel()
+
+	snapshot, err := s.Save(ctx, r.URL.Query().Get("name"))
+	switch {
+	case errors.Is(err, ErrInvalidSnapshotName):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		s.detector.logger.Error("Failed to save detector snapshot", "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"name":       snapshot.Name,
+		"key":        s.key(snapshot.Name),
+		"created_at": snapshot.CreatedAt,
+	})
+}
+
+func (s *Snapshotter) get(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), snapshotTimeout)
+	defer cancel()
+
+	snapshot, err := s.Load(ctx, r.PathValue("name"))
+	switch {
+	case errors.Is(err, ErrSnapshotNotFound):
+		writeError(w, http.StatusNotFound, "snapshot not found")
+	case errors.Is(err, ErrInvalidSnapshotName):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		s.detector.logger.Error("Failed to load detector snapshot", "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, snapshot)
+	}
+}
+
+// requireAdmin guards a handler with the admin bearer token
+func (s *Snapshotter) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// snapshot returns the detector's restorable state
+func (a *AnomalyDetector) snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		Version:    SnapshotVersion,
+		Thresholds: a.validator.Defaults(),
+		Overrides:  a.validator.Overrides(),
+	}
+	if a.rateMonitor != nil {
+		state := a.rateMonitor.State()
+		snapshot.FleetRate = &state
+	}
+	return snapshot
+}
+
+// restore loads a snapshot's state. Per-sensor overrides are added for sensors
+// without a configured override, so THRESHOLD_OVERRIDES still wins.
+func (a *AnomalyDetector) restore(snapshot *Snapshot) {
+	added := a.validator.AddOverrides(snapshot.Overrides)
+	if snapshot.FleetRate != nil && a.rateMonitor != nil {
+		a.rateMonitor.Restore(*snapshot.FleetRate)
+	}
+	a.logger.Info("Restored detector snapshot", "name", snapshot.Name,
+		"created_at", snapshot.CreatedAt.Format(time.RFC3339), "overrides", added, "snapshot_overrides", len(snapshot.Overrides))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
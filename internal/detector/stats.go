@@ -0,0 +1,219 @@
+package detector
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Checker flags anomalous readings. The threshold Validator and the
+// StatsEngine are both checkers; the detector runs every checker it has.
+type Checker interface {
+	// Check returns the rule the reading violates and a human-readable
+	// reason, or empty strings if the reading is normal
+	Check(reading *model.SensorReading) (rule, reason string)
+}
+
+// StatsConfig configures statistical anomaly detection
+type StatsConfig struct {
+	// Window is the number of recent readings per sensor the mean and
+	// standard deviation are computed over
+	Window int
+	// Sigmas flags readings deviating from the mean by more than this many
+	// standard deviations
+	Sigmas float64
+	// Warmup is the number of readings a sensor needs before it is checked
+	Warmup int
+	// MaxSensors bounds the number of sensors tracked; the least recently
+	// seen sensor is forgotten when a new one arrives
+	MaxSensors int
+}
+
+// StatsMetrics holds Prometheus metrics for statistical anomaly detection
+type StatsMetrics struct {
+	TrackedSensors prometheus.Gauge
+	Evictions      prometheus.Counter
+}
+
+// NewStatsMetrics creates a new set of stats engine metrics
+func NewStatsMetrics(namespace, subsystem string, registry prometheus.Registerer) *StatsMetrics {
+	metrics := &StatsMetrics{
+		TrackedSensors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "stats_tracked_sensors",
+			Help:      "Number of sensors with rolling statistics",
+		}),
+		Evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "stats_evictions_total",
+			Help:      "Total number of sensors forgotten to stay within the tracked sensor limit",
+		}),
+	}
+
+	registry.MustRegister(metrics.TrackedSensors, metrics.Evictions)
+
+	return metrics
+}
+
+// StatsEngine flags readings whose temperature or humidity deviates from the
+// sensor's rolling mean by more than a number of standard deviations. Every
+// reading joins the window after it is checked, so a lasting change in a
+// sensor's level stops being flagged once the window has caught up.
+type StatsEngine struct {
+	config  StatsConfig
+	metrics *StatsMetrics
+
+	mu      sync.Mutex
+	sensors map[string]*list.Element
+	// recent orders sensors from most to least recently seen
+	recent *list.List
+}
+
+// sensorStats is the rolling window of one sensor
+type sensorStats struct {
+	id          string
+	temperature rollingWindow
+	humidity    rollingWindow
+}
+
+// NewStatsEngine creates a new stats engine; metrics may be nil
+func NewStatsEngine(config StatsConfig, metrics *StatsMetrics) (*StatsEngine, error) {
+	if config.Window < 2 {
+		return nil, fmt.Errorf("stats window must be at least 2 readings, got %d", config.Window)
+	}
+	if config.Sigmas <= 0 {
+		return nil, fmt.Errorf("stats sigmas must be positive, got %v", config.Sigmas)
+	}
+	if config.Warmup < 2 || config.Warmup > config.Window {
+		return nil, fmt.Errorf("stats warmup must be between 2 and the window (%d), got %d", config.Window, config.Warmup)
+	}
+	if config.MaxSensors <= 0 {
+		return nil, fmt.Errorf("stats max sensors must be positive, got %d", config.MaxSensors)
+	}
+
+	return &StatsEngine{
+		config:  config,
+		metrics: metrics,
+		sensors: make(map[string]*list.Element),
+		recent:  list.New(),
+	}, nil
+}
+
+// Name names the engine's check in decisions
+func (e *StatsEngine) Name() string {
+	return "stats"
+}
+
+// Check compares a reading with its sensor's window and then adds it
+func (e *StatsEngine) Check(reading *model.SensorReading) (string, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := e.sensor(reading.ID)
+	temperature, humidity := float64(reading.Temperature), float64(reading.Humidity)
+
+	var rule, reason string
+	if z, ok := stats.temperature.zscore(temperature, e.config.Warmup); ok && math.Abs(z) > e.config.Sigmas {
+		rule = model.RuleTemperatureDeviation
+		reason = fmt.Sprintf("Temperature %.1f°C is %.1fσ from the mean of %.1f°C", temperature, z, stats.temperature.mean())
+	} else if z, ok := stats.humidity.zscore(humidity, e.config.Warmup); ok && math.Abs(z) > e.config.Sigmas {
+		rule = model.RuleHumidityDeviation
+		reason = fmt.Sprintf("Humidity %.1f%% is %.1fσ from the mean of %.1f%%", humidity, z, stats.humidity.mean())
+	}
+
+	stats.temperature.add(temperature)
+	stats.humidity.add(humidity)
+	return rule, reason
+}
+
+// sensor returns a sensor's window, creating it and forgetting the least
+// recently seen sensor if needed. It must be called with mu held.
+func (e *StatsEngine) sensor(id string) *sensorStats {
+	if element, ok := e.sensors[id]; ok {
+		e.recent.MoveToFront(element)
+		return element.Value.(*sensorStats)
+	}
+
+	if len(e.sensors) >= e.config.MaxSensors {
+		oldest := e.recent.Back()
+		e.recent.Remove(oldest)
+		delete(e.sensors, oldest.Value.(*sensorStats).id)
+		if e.metrics != nil {
+			e.metrics.Evictions.Inc()
+		}
+	}
+
+	stats := &sensorStats{
+		id:          id,
+		temperature: newRollingWindow(e.config.Window),
+		humidity:    newRollingWindow(e.config.Window),
+	}
+	e.sensors[id] = e.recent.PushFront(stats)
+	if e.metrics != nil {
+		e.metrics.TrackedSensors.Set(float64(len(e.sensors)))
+	}
+	return stats
+}
+
+// rollingWindow keeps the last values of a series with their running sums
+type rollingWindow struct {
+	values []float64
+	next   int
+	count  int
+	sum    float64
+	sumSq  float64
+}
+
+func newRollingWindow(size int) rollingWindow {
+	return rollingWindow{values: make([]float64, size)}
+}
+
+// add adds a value, replacing the oldest once the window is full
+func (w *rollingWindow) add(value float64) {
+	if w.count == len(w.values) {
+		old := w.values[w.next]
+		w.sum -= old
+		w.sumSq -= old * old
+	} else {
+		w.count++
+	}
+	w.values[w.next] = value
+	w.next = (w.next + 1) % len(w.values)
+	w.sum += value
+	w.sumSq += value * value
+
+	// Recompute the sums once per lap so rounding errors do not accumulate
+	if w.next == 0 {
+		w.sum, w.sumSq = 0, 0
+		for _, v := range w.values {
+			w.sum += v
+			w.sumSq += v * v
+		}
+	}
+}
+
+func (w *rollingWindow) mean() float64 {
+	return w.sum / float64(w.count)
+}
+
+// zscore returns how many standard deviations value is from the mean. It is
+// not ok before warmup values were added or while the window is constant.
+func (w *rollingWindow) zscore(value float64, warmup int) (float64, bool) {
+	if w.count < warmup {
+		return 0, false
+	}
+	mean := w.mean()
+	// Clamp rounding errors of the running sums
+	variance := math.Max(w.sumSq/float64(w.count)-mean*mean, 0)
+	stddev := math.Sqrt(variance)
+	if stddev < 1e-9 {
+		return 0, false
+	}
+	return (value - mean) / stddev, true
+}
@@ -0,0 +1,177 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	sensorregistry "github.com/example/iot-sensor-fleet/internal/registry"
+)
+
+// ThresholdRefresher applies the thresholds managed through the registry API
+// to a validator, so changes take effect within one refresh interval without
+// a restart
+type ThresholdRefresher struct {
+	registry  *sensorregistry.Registry
+	validator *Validator
+	interval  time.Duration
+	timeout   time.Duration
+	postgres  *db.PostgresDB
+	logger    *slog.Logger
+
+	// version identifies the last applied thresholds, so only changes are logged
+	version string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewThresholdRefresher creates a refresher applying the thresholds of
+// registry to validator every interval; each refresh is bounded by timeout.
+// logger may be nil.
+func NewThresholdRefresher(registry *sensorregistry.Registry, validator *Validator, interval, timeout time.Duration, logger *slog.Logger) *ThresholdRefresher {
+	if timeout <= 0 {
+		timeout = interval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ThresholdRefresher{
+		registry:  registry,
+		validator: validator,
+		interval:  interval,
+		timeout:   timeout,
+		logger:    logging.OrDefault(logger),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// NewThresholdRefresherFromConfig connects to the registry database and
+// creates a refresher. It returns nil, nil when THRESHOLD_REFRESH_INTERVAL
+// is 0.
+func NewThresholdRefresherFromConfig(cfg *config.Config, validator *Validator, logger *slog.Logger) (*ThresholdRefresher, error) {
+	if cfg.ThresholdRefreshInterval <= 0 {
+		return nil, nil
+	}
+
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect threshold database: %w", err)
+	}
+
+	refresher := NewThresholdRefresher(sensorregistry.NewRegistry(postgres.DB()), validator, cfg.ThresholdRefreshInterval, cfg.StoreTimeout, logger)
+	refresher.postgres = postgres
+	return refresher, nil
+}
+
+// Start applies the stored thresholds and refreshes them in the background
+func (r *ThresholdRefresher) Start() {
+	if err := r.refresh(); err != nil {
+		r.logger.Warn("Failed to load stored thresholds", "error", err)
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.refresh(); err != nil {
+					r.logger.Warn("Failed to refresh stored thresholds", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing and closes the database connection if the refresher owns it
+func (r *ThresholdRefresher) Stop() {
+	r.cancel()
+	r.wg.Wait()
+	if r.postgres != nil {
+		r.postgres.Close()
+	}
+}
+
+// refresh reloads the thresholds under the refresher's lifetime and timeout
+func (r *ThresholdRefresher) refresh() error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+	return r.Refresh(ctx)
+}
+
+// Refresh loads every stored threshold setting and the tags of the sensors
+// and applies them to the validator. A sensor's own thresholds take
+// precedence over those of its tags, and where several of its tags set a
+// threshold the strictest applies. Until a refresh succeeds the validator
+// keeps the thresholds and tags it has.
+func (r *ThresholdRefresher) Refresh(ctx context.Context) error {
+	settings, err := r.registry.ListThresholds(ctx)
+	if err != nil {
+		return err
+	}
+	sensorTags, err := r.registry.SensorTags(ctx)
+	if err != nil {
+		return err
+	}
+
+	var global ThresholdPatch
+	tags := make(map[string]ThresholdPatch)
+	sensors := make(map[string]ThresholdPatch)
+	var latest time.Time
+	for _, setting := range settings {
+		patch := ThresholdPatch{
+			MaxTemperature: setting.MaxTemperature,
+			MinHumidity:    setting.MinHumidity,
+			MinBatteryPct:  setting.MinBatteryPct,
+			MinRSSI:        setting.MinRSSI,
+		}
+		switch setting.Scope {
+		case sensorregistry.ThresholdScopeGlobal:
+			global = patch
+		case sensorregistry.ThresholdScopeTag:
+			tags[setting.Target] = patch
+		case sensorregistry.ThresholdScopeSensor:
+			sensors[setting.Target] = patch
+		}
+		if setting.UpdatedAt.After(latest) {
+			latest = setting.UpdatedAt
+		}
+	}
+	if len(tags) > 0 {
+		for sensorID, sensorTagList := range sensorTags {
+			var tagged ThresholdPatch
+			matched := false
+			for _, tag := range sensorTagList {
+				if patch, ok := tags[tag]; ok {
+					tagged = tagged.stricter(patch)
+					matched = true
+				}
+			}
+			if matched {
+				sensors[sensorID] = sensors[sensorID].over(tagged)
+			}
+		}
+	}
+	r.validator.ApplyStored(global, sensors)
+	r.validator.SetTags(sensorTags)
+
+	version := fmt.Sprintf("%d/%d", len(settings), latest.UnixNano())
+	if version != r.version {
+		r.version = version
+		r.logger.Info("Applied stored thresholds", "settings", len(settings), "tags", len(tags), "sensors", len(sensors), "updated_at", latest)
+	}
+	return nil
+}
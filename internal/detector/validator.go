@@ -0,0 +1,295 @@
+package detector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Threshold names used in override specs
+const (
+	ThresholdMaxTemperature = "max_temperature"
+	ThresholdMinHumidity    = "min_humidity"
+	ThresholdMinBatteryPct  = "min_battery_pct"
+	ThresholdMinRSSI        = "min_rssi"
+)
+
+// Thresholds are the limits a reading is checked against
+type Thresholds struct {
+	MaxTemperature float32 `json:"max_temperature"`
+	MinHumidity    float32 `json:"min_humidity"`
+	// MinBatteryPct and MinRSSI apply to readings that report a battery
+	// level and signal strength; 0 disables them
+	MinBatteryPct float32 `json:"min_battery_pct,omitempty"`
+	MinRSSI       int32   `json:"min_rssi,omitempty"`
+}
+
+// ThresholdPatch changes some thresholds; nil ones are left as they are
+type ThresholdPatch struct {
+	MaxTemperature *float32
+	MinHumidity    *float32
+	MinBatteryPct  *float32
+	MinRSSI        *int32
+}
+
+// apply returns thresholds with the patch's thresholds replaced
+func (p ThresholdPatch) apply(thresholds Thresholds) Thresholds {
+	if p.MaxTemperature != nil {
+		thresholds.MaxTemperature = *p.MaxTemperature
+	}
+	if p.MinHumidity != nil {
+		thresholds.MinHumidity = *p.MinHumidity
+	}
+	if p.MinBatteryPct != nil {
+		thresholds.MinBatteryPct = *p.MinBatteryPct
+	}
+	if p.MinRSSI != nil {
+		thresholds.MinRSSI = *p.MinRSSI
+	}
+	return thresholds
+}
+
+// stricter returns the patch setting each threshold to the stricter of the
+// two patches' values: the lower maximum and the higher minimums
+func (p ThresholdPatch) stricter(other ThresholdPatch) ThresholdPatch {
+	if other.MaxTemperature != nil && (p.MaxTemperature == nil || *other.MaxTemperature < *p.MaxTemperature) {
+		p.MaxTemperature = other.MaxTemperature
+	}
+	if other.MinHumidity != nil && (p.MinHumidity == nil || *other.MinHumidity > *p.MinHumidity) {
+		p.MinHumidity = other.MinHumidity
+	}
+	if other.MinBatteryPct != nil && (p.MinBatteryPct == nil || *other.MinBatteryPct > *p.MinBatteryPct) {
+		p.MinBatteryPct = other.MinBatteryPct
+	}
+	if other.MinRSSI != nil && (p.MinRSSI == nil || *other.MinRSSI > *p.MinRSSI) {
+		p.MinRSSI = other.MinRSSI
+	}
+	return p
+}
+
+// over returns the patch with the thresholds it leaves unset taken from base
+func (p ThresholdPatch) over(base ThresholdPatch) ThresholdPatch {
+	if p.MaxTemperature == nil {
+		p.MaxTemperature = base.MaxTemperature
+	}
+	if p.MinHumidity == nil {
+		p.MinHumidity = base.MinHumidity
+	}
+	if p.MinBatteryPct == nil {
+		p.MinBatteryPct = base.MinBatteryPct
+	}
+	if p.MinRSSI == nil {
+		p.MinRSSI = base.MinRSSI
+	}
+	return p
+}
+
+// Validator checks readings against default thresholds, overridden per sensor
+type Validator struct {
+	mu        sync.RWMutex
+	defaults  Thresholds
+	overrides map[string]Thresholds
+	// tags are the registry tags of each sensor as of the last refresh
+	tags map[string][]string
+
+	// configured are the thresholds stored patches are applied on top of
+	configuredDefaults  Thresholds
+	configuredOverrides map[string]Thresholds
+}
+
+// NewValidator creates a validator; overrides maps sensor IDs to their thresholds
+func NewValidator(defaults Thresholds, overrides map[string]Thresholds) *Validator {
+	if overrides == nil {
+		overrides = make(map[string]Thresholds)
+	}
+	configured := make(map[string]Thresholds, len(overrides))
+	for sensorID, thresholds := range overrides {
+		configured[sensorID] = thresholds
+	}
+	return &Validator{
+		defaults:            defaults,
+		overrides:           overrides,
+		configuredDefaults:  defaults,
+		configuredOverrides: configured,
+	}
+}
+
+// Thresholds returns the thresholds that apply to a sensor
+func (v *Validator) Thresholds(sensorID string) Thresholds {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if thresholds, ok := v.overrides[sensorID]; ok {
+		return thresholds
+	}
+	return v.defaults
+}
+
+// Check returns the name of the first rule the reading violates and a
+// human-readable reason, or empty strings if the reading is valid
+func (v *Validator) Check(reading *model.SensorReading) (string, string) {
+	thresholds := v.Thresholds(reading.ID)
+	if rule, reason := model.CheckThresholds(reading, thresholds.MaxTemperature, thresholds.MinHumidity); rule != "" {
+		return rule, reason
+	}
+	return model.CheckDeviceHealth(reading, thresholds.MinBatteryPct, thresholds.MinRSSI)
+}
+
+// Violation is the rule a reading violates and a human-readable reason; both
+// are empty for a valid reading
+type Violation struct {
+	Rule   string
+	Reason string
+}
+
+// CheckBatch checks a slice of readings in one call, writing the violation
+// of each reading to the same index of violations, which must be at least as
+// long. It takes the lock once rather than per reading and skips the
+// override lookup entirely when no sensor has one, which matters at the
+// rates batches are consumed at.
+func (v *Validator) CheckBatch(readings []*model.SensorReading, violations []Violation) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for i, reading := range readings {
+		thresholds := v.defaults
+		if len(v.overrides) > 0 {
+			if override, ok := v.overrides[reading.ID]; ok {
+				thresholds = override
+			}
+		}
+
+		rule, reason := model.CheckThresholds(reading, thresholds.MaxTemperature, thresholds.MinHumidity)
+		if rule == "" {
+			rule, reason = model.CheckDeviceHealth(reading, thresholds.MinBatteryPct, thresholds.MinRSSI)
+		}
+		violations[i] = Violation{Rule: rule, Reason: reason}
+	}
+}
+
+// Tags returns the registry tags of a sensor, which callers must not modify
+func (v *Validator) Tags(sensorID string) []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.tags[sensorID]
+}
+
+// SetTags replaces the registry tags of every sensor
+func (v *Validator) SetTags(tags map[string][]string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tags = tags
+}
+
+// Defaults returns the thresholds of sensors without an override
+func (v *Validator) Defaults() Thresholds {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.defaults
+}
+
+// Overrides returns a copy of the per-sensor overrides
+func (v *Validator) Overrides() map[string]Thresholds {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	overrides := make(map[string]Thresholds, len(v.overrides))
+	for sensorID, thresholds := range v.overrides {
+		overrides[sensorID] = thresholds
+	}
+	return overrides
+}
+
+// AddOverrides adds overrides for sensors that have none and returns how many
+// were added; existing overrides take precedence
+func (v *Validator) AddOverrides(overrides map[string]Thresholds) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	added := 0
+	for sensorID, thresholds := range overrides {
+		if _, ok := v.configuredOverrides[sensorID]; !ok {
+			v.configuredOverrides[sensorID] = thresholds
+		}
+		if _, ok := v.overrides[sensorID]; !ok {
+			v.overrides[sensorID] = thresholds
+			added++
+		}
+	}
+	return added
+}
+
+// ApplyStored replaces the thresholds stored outside the configuration:
+// global patches the configured defaults, and each patch of sensors the
+// sensor's configured override, or the patched defaults if it has none.
+// Patches of a previous call are undone.
+func (v *Validator) ApplyStored(global ThresholdPatch, sensors map[string]ThresholdPatch) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	defaults := global.apply(v.configuredDefaults)
+	overrides := make(map[string]Thresholds, len(v.configuredOverrides)+len(sensors))
+	for sensorID, thresholds := range v.configuredOverrides {
+		overrides[sensorID] = thresholds
+	}
+	for sensorID, patch := range sensors {
+		base, ok := overrides[sensorID]
+		if !ok {
+			base = defaults
+		}
+		overrides[sensorID] = patch.apply(base)
+	}
+	v.defaults, v.overrides = defaults, overrides
+}
+
+// ParseThresholdOverrides parses per-sensor overrides of the form
+// "sensor=max_temperature:45,min_humidity:5;sensor=min_rssi:-100".
+// Thresholds a sensor does not override keep their default.
+func ParseThresholdOverrides(spec string, defaults Thresholds) (map[string]Thresholds, error) {
+	overrides := make(map[string]Thresholds)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		sensorID, list, ok := strings.Cut(entry, "=")
+		sensorID = strings.TrimSpace(sensorID)
+		if !ok || sensorID == "" {
+			return nil, fmt.Errorf("invalid threshold override %q: expected sensor=name:value,...", entry)
+		}
+
+		thresholds := defaults
+		for _, pair := range strings.Split(list, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid threshold override for %s: %q is not name:value", sensorID, pair)
+			}
+			name = strings.TrimSpace(name)
+			if name == ThresholdMinRSSI {
+				parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s for %s: %w", name, sensorID, err)
+				}
+				thresholds.MinRSSI = int32(parsed)
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s for %s: %w", name, sensorID, err)
+			}
+
+			switch name {
+			case ThresholdMaxTemperature:
+				thresholds.MaxTemperature = float32(parsed)
+			case ThresholdMinHumidity:
+				thresholds.MinHumidity = float32(parsed)
+			case ThresholdMinBatteryPct:
+				thresholds.MinBatteryPct = float32(parsed)
+			default:
+				return nil, fmt.Errorf("invalid threshold override for %s: unknown threshold %q", sensorID, name)
+			}
+		}
+		overrides[sensorID] = thresholds
+	}
+	return overrides, nil
+}
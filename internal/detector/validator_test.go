@@ -0,0 +1,70 @@
+package detector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// batchSize is the number of readings per benchmark iteration
+const batchSize = 10000
+
+// benchmarkValidator returns a validator and a batch of readings from 1000
+// sensors, one in a hundred of which is too hot; with overrides, every tenth
+// sensor has one
+func benchmarkValidator(withOverrides bool) (*Validator, []*model.SensorReading) {
+	defaults := Thresholds{MaxTemperature: 50, MinHumidity: 10, MinBatteryPct: 15, MinRSSI: -90}
+	overrides := make(map[string]Thresholds)
+	if withOverrides {
+		for i := 0; i < 1000; i += 10 {
+			overrides[fmt.Sprintf("sensor-%d", i)] = Thresholds{MaxTemperature: 45, MinHumidity: 20}
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	readings := make([]*model.SensorReading, batchSize)
+	for i := range readings {
+		battery, rssi := float32(80), int32(-60)
+		temperature := float32(20 + i%20)
+		if i%100 == 99 {
+			temperature = 60
+		}
+		readings[i] = model.NewSensorReading(now, temperature, float32(40+i%50))
+		readings[i].ID = fmt.Sprintf("sensor-%d", i%1000)
+		readings[i].BatteryPct, readings[i].RSSI = &battery, &rssi
+	}
+	return NewValidator(defaults, overrides), readings
+}
+
+func BenchmarkValidatorCheck(b *testing.B) {
+	for _, withOverrides := range []bool{false, true} {
+		b.Run(fmt.Sprintf("overrides=%t", withOverrides), func(b *testing.B) {
+			validator, readings := benchmarkValidator(withOverrides)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				for _, reading := range readings {
+					validator.Check(reading)
+				}
+			}
+			b.ReportMetric(float64(b.N*batchSize)/b.Elapsed().Seconds(), "readings/s")
+		})
+	}
+}
+
+func BenchmarkValidatorCheckBatch(b *testing.B) {
+	for _, withOverrides := range []bool{false, true} {
+		b.Run(fmt.Sprintf("overrides=%t", withOverrides), func(b *testing.B) {
+			validator, readings := benchmarkValidator(withOverrides)
+			violations := make([]Violation, len(readings))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				validator.CheckBatch(readings, violations)
+			}
+			b.ReportMetric(float64(b.N*batchSize)/b.Elapsed().Seconds(), "readings/s")
+		})
+	}
+}
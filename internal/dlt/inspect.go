@@ -0,0 +1,379 @@
+package dlt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Failure classes of dead-lettered messages, from decoding their payload again
+const (
+	// ClassEmptyPayload is a message with a null or empty value
+	ClassEmptyPayload = "empty_payload"
+	// ClassTruncatedAvro is Avro that ends before the reading does
+	ClassTruncatedAvro = "truncated_avro"
+	// ClassTrailingBytes is Avro with data after the reading, typically of a
+	// newer schema version than the consumers know
+	ClassTrailingBytes = "trailing_bytes"
+	// ClassUnknownSchema is Confluent framing with a schema ID the Schema
+	// Registry does not know or that is not the reading schema
+	ClassUnknownSchema = "unknown_schema"
+	// ClassMalformedJSON is a payload declared or sniffed as JSON that is not
+	// a reading
+	ClassMalformedJSON = "malformed_json"
+	// ClassUnrecognizedFormat is a payload no format decodes
+	ClassUnrecognizedFormat = "unrecognized_format"
+	// ClassDecodable is a payload that decodes: the handler failed for
+	// another reason, such as an outage of its store
+	ClassDecodable = "decodable"
+)
+
+// dominantShare is the share of a class one source must have to be named
+// as responsible for it
+const dominantShare = 0.8
+
+// Classification describes one dead-lettered message
+type Classification struct {
+	Class string `json:"class"`
+	// Error is why decoding failed, or the dead-letter reason of a
+	// decodable payload
+	Error string `json:"error,omitempty"`
+	// SchemaID is the schema ID of Confluent-framed payloads
+	SchemaID *int32 `json:"schema_id,omitempty"`
+	Source   Source `json:"source"`
+}
+
+// Source is what the headers of a message say about who produced it
+type Source struct {
+	ClientID        string `json:"client_id,omitempty"`
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+	SchemaVersion   int    `json:"schema_version,omitempty"`
+	Format          string `json:"format,omitempty"`
+}
+
+// String describes a source for people, naming what is unknown as such
+func (s Source) String() string {
+	describe := func(value string) string {
+		if value == "" {
+			return "unknown"
+		}
+		return value
+	}
+	version := "unknown"
+	if s.SchemaVersion > 0 {
+		version = fmt.Sprintf("v%d", s.SchemaVersion)
+	}
+	return fmt.Sprintf("client %s, firmware %s, schema %s, format %s",
+		describe(s.ClientID), describe(s.FirmwareVersion), version, describe(s.Format))
+}
+
+// Classify decodes a dead-lettered message again, in the format its producer
+// declared or by sniffing, and classifies why it cannot be decoded
+func Classify(decoder *model.ReadingDecoder, message *sarama.ConsumerMessage) Classification {
+	var c Classification
+	c.Source.ClientID, _ = kafka.ClientID(message)
+	c.Source.FirmwareVersion, _ = kafka.FirmwareVersion(message)
+	c.Source.SchemaVersion, _ = kafka.SchemaVersion(message)
+	c.Source.Format = kafka.PayloadFormat(message)
+	if schemaID, ok := model.ConfluentSchemaID(message.Value); ok {
+		c.SchemaID = &schemaID
+	}
+
+	if len(message.Value) == 0 {
+		c.Class = ClassEmptyPayload
+		return c
+	}
+
+	topic, _ := kafka.Header(message, kafka.HeaderOriginalTopic)
+	_, _, err := decoder.DecodeFormat(topic, message.Value, c.Source.Format)
+	if err == nil {
+		c.Class = ClassDecodable
+		c.Error, _ = kafka.Header(message, kafka.HeaderDLTReason)
+		return c
+	}
+	// Errors of every sniffed format are joined one per line
+	c.Error = strings.ReplaceAll(err.Error(), "\n", "; ")
+	c.Class = classifyDecodeError(err)
+	return c
+}
+
+// classifyDecodeError maps a decoding error to a failure class by the
+// messages of the model package's decoders
+func classifyDecodeError(err error) string {
+	if errors.Is(err, model.ErrUnrecognizedFormat) {
+		return ClassUnrecognizedFormat
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "trailing bytes"):
+		return ClassTrailingBytes
+	case strings.Contains(message, "schema ID"):
+		return ClassUnknownSchema
+	case strings.Contains(message, "avro"), strings.Contains(message, "Avro"):
+		return ClassTruncatedAvro
+	case strings.Contains(message, "JSON"), strings.Contains(message, "json"):
+		return ClassMalformedJSON
+	default:
+		return ClassUnrecognizedFormat
+	}
+}
+
+// SourceCount is how many messages of a class came from one source
+type SourceCount struct {
+	Source Source `json:"source"`
+	Count  int    `json:"count"`
+}
+
+// ClassReport aggregates the messages of one failure class
+type ClassReport struct {
+	Class string `json:"class"`
+	Count int    `json:"count"`
+	// Sources are ordered by count, most first
+	Sources []SourceCount `json:"sources"`
+	// SchemaIDs counts the Confluent schema IDs seen
+	SchemaIDs map[int32]int `json:"schema_ids,omitempty"`
+	// Example is the error of the first message of the class
+	Example string `json:"example,omitempty"`
+	// Suggestion names the likely culprit
+	Suggestion string `json:"suggestion"`
+}
+
+// Report aggregates the classified messages of a scan
+type Report struct {
+	Scanned  int `json:"scanned"`
+	Filtered int `json:"filtered"`
+	// Classes are ordered by count, most first
+	Classes []ClassReport `json:"classes"`
+}
+
+// Aggregator counts classified messages by class, source and schema ID
+type Aggregator struct {
+	scanned  int
+	filtered int
+	classes  map[string]*classCounts
+}
+
+// classCounts are the running counts of one class
+type classCounts struct {
+	count     int
+	sources   map[Source]int
+	schemaIDs map[int32]int
+	example   string
+}
+
+// NewAggregator creates an empty aggregator
+func NewAggregator() *Aggregator {
+	return &Aggregator{classes: make(map[string]*classCounts)}
+}
+
+// Add counts one classified message
+func (a *Aggregator) Add(c Classification) {
+	a.scanned++
+	counts, ok := a.classes[c.Class]
+	if !ok {
+		counts = &classCounts{sources: make(map[Source]int), schemaIDs: make(map[int32]int), example: c.Error}
+		a.classes[c.Class] = counts
+	}
+	counts.count++
+	counts.sources[c.Source]++
+	if c.SchemaID != nil {
+		counts.schemaIDs[*c.SchemaID]++
+	}
+}
+
+// Skip counts a message that was read but left out by the filter
+func (a *Aggregator) Skip() {
+	a.scanned++
+	a.filtered++
+}
+
+// Report returns the counts so far with a suggestion per class
+func (a *Aggregator) Report() Report {
+	report := Report{Scanned: a.scanned, Filtered: a.filtered, Classes: []ClassReport{}}
+	for class, counts := range a.classes {
+		classReport := ClassReport{Class: class, Count: counts.count, Example: counts.example}
+		for source, count := range counts.sources {
+			classReport.Sources = append(classReport.Sources, SourceCount{Source: source, Count: count})
+		}
+		sort.Slice(classReport.Sources, func(i, j int) bool {
+			if classReport.Sources[i].Count != classReport.Sources[j].Count {
+				return classReport.Sources[i].Count > classReport.Sources[j].Count
+			}
+			return classReport.Sources[i].Source.String() < classReport.Sources[j].Source.String()
+		})
+		if len(counts.schemaIDs) > 0 {
+			classReport.SchemaIDs = counts.schemaIDs
+		}
+		classReport.Suggestion = suggest(classReport)
+		report.Classes = append(report.Classes, classReport)
+	}
+	sort.Slice(report.Classes, func(i, j int) bool {
+		if report.Classes[i].Count != report.Classes[j].Count {
+			return report.Classes[i].Count > report.Classes[j].Count
+		}
+		return report.Classes[i].Class < report.Classes[j].Class
+	})
+	return report
+}
+
+// suggest names the source most likely responsible for a class: the one
+// that sent at least dominantShare of its messages, if any
+func suggest(report ClassReport) string {
+	if report.Class == ClassDecodable {
+		return "payloads decode now; fix the handler failure in the dead-letter reasons, then replay with dlt-replayer"
+	}
+	top := report.Sources[0]
+	share := float64(top.Count) / float64(report.Count)
+	if share < dominantShare {
+		return fmt.Sprintf("spread over %d sources, the most from %s (%.0f%%); likely not one producer or firmware",
+			len(report.Sources), top.Source, share*100)
+	}
+	if top.Source.ClientID == "" && top.Source.FirmwareVersion == "" {
+		return fmt.Sprintf("%.0f%% from producers that send no client ID or firmware headers (%s)", share*100, top.Source)
+	}
+	return fmt.Sprintf("%.0f%% from %s; check that producer and firmware", share*100, top.Source)
+}
+
+// InspectorConfig configures a scan of dead-letter topics
+type InspectorConfig struct {
+	Brokers []string
+	// Topics are the dead-letter topics to read
+	Topics []string
+	Filter Filter
+	// Limit stops after classifying this many messages (0 is unlimited)
+	Limit int
+	// Logger receives the progress of the scan (optional)
+	Logger *slog.Logger
+}
+
+// Inspector reads dead-letter topics up to their end at the start of the run,
+// without committing, and classifies every message that passes the filter
+type Inspector struct {
+	config  InspectorConfig
+	client  sarama.Client
+	decoder *model.ReadingDecoder
+
+	// OnMessage, if set, is called with every classified message
+	OnMessage func(message *sarama.ConsumerMessage, c Classification)
+}
+
+// NewInspector creates an inspector decoding payloads with decoder
+func NewInspector(config InspectorConfig, decoder *model.ReadingDecoder, opts ...kafka.OptionFunc) (*Inspector, error) {
+	if len(config.Topics) == 0 {
+		return nil, fmt.Errorf("no dead-letter topics to inspect")
+	}
+	config.Logger = logging.OrDefault(config.Logger)
+
+	saramaConfig := sarama.NewConfig()
+	for _, opt := range opts {
+		opt(saramaConfig)
+	}
+	client, err := sarama.NewClient(config.Brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+
+	return &Inspector{config: config, client: client, decoder: decoder}, nil
+}
+
+// Run reads every partition of every topic in turn until each is read to
+// the end it had when the run started, ctx is done, or the limit is reached
+func (i *Inspector) Run(ctx context.Context) (Report, error) {
+	aggregator := NewAggregator()
+
+	consumer, err := sarama.NewConsumerFromClient(i.client)
+	if err != nil {
+		return aggregator.Report(), fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	for _, topic := range i.config.Topics {
+		partitions, err := i.client.Partitions(topic)
+		if err != nil {
+			return aggregator.Report(), fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+		}
+		for _, partition := range partitions {
+			if err := i.inspectPartition(ctx, consumer, topic, partition, aggregator); err != nil {
+				return aggregator.Report(), err
+			}
+			if ctx.Err() != nil || i.limitReached(aggregator) {
+				return aggregator.Report(), nil
+			}
+		}
+	}
+	return aggregator.Report(), nil
+}
+
+// Close releases the Kafka client
+func (i *Inspector) Close() error {
+	return i.client.Close()
+}
+
+// inspectPartition classifies one partition from its oldest retained
+// message, or the first produced at Since, to its current end
+func (i *Inspector) inspectPartition(ctx context.Context, consumer sarama.Consumer, topic string, partition int32, aggregator *Aggregator) error {
+	end, err := i.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return fmt.Errorf("failed to get end offset of %s/%d: %w", topic, partition, err)
+	}
+	start, err := i.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return fmt.Errorf("failed to get oldest offset of %s/%d: %w", topic, partition, err)
+	}
+	// Messages are dead-lettered after they are produced, so nothing before
+	// the first message produced at Since can match
+	if !i.config.Filter.Since.IsZero() {
+		since, err := i.client.GetOffset(topic, partition, i.config.Filter.Since.UnixMilli())
+		if err != nil {
+			return fmt.Errorf("failed to look up offset of %s/%d at %s: %w", topic, partition, i.config.Filter.Since, err)
+		}
+		if since > start {
+			start = since
+		}
+	}
+	if start >= end {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s/%d: %w", topic, partition, err)
+	}
+	defer pc.Close()
+
+	i.config.Logger.Info("Inspecting partition", "topic", topic, "partition", partition, "from", start, "to", end)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-pc.Errors():
+			return fmt.Errorf("failed to read %s/%d: %w", topic, partition, err)
+		case message := <-pc.Messages():
+			if i.config.Filter.Match(message) {
+				c := Classify(i.decoder, message)
+				aggregator.Add(c)
+				if i.OnMessage != nil {
+					i.OnMessage(message, c)
+				}
+			} else {
+				aggregator.Skip()
+			}
+			if message.Offset+1 >= end || i.limitReached(aggregator) {
+				return nil
+			}
+		}
+	}
+}
+
+// limitReached reports whether the classification limit has been reached
+func (i *Inspector) limitReached(aggregator *Aggregator) bool {
+	return i.config.Limit > 0 && aggregator.scanned-aggregator.filtered >= i.config.Limit
+}
@@ -0,0 +1,259 @@
+package dlt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+// Filter selects dead-lettered messages to replay; zero fields match everything
+type Filter struct {
+	// Since and Until bound when messages were dead-lettered
+	Since time.Time
+	Until time.Time
+	// Reason must be contained in the message's dead-letter reason
+	Reason string
+}
+
+// Match reports whether a dead-lettered message passes the filter
+func (f Filter) Match(message *sarama.ConsumerMessage) bool {
+	failedAt := kafka.DLTFailedAt(message)
+	if !f.Since.IsZero() && failedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !failedAt.Before(f.Until) {
+		return false
+	}
+	if f.Reason != "" {
+		reason, _ := kafka.Header(message, kafka.HeaderDLTReason)
+		if !strings.Contains(reason, f.Reason) {
+			return false
+		}
+	}
+	return true
+}
+
+// Config configures a replay
+type Config struct {
+	Brokers []string
+	// Source is the dead-letter topic to read
+	Source string
+	// Group commits how far the topic was read, so the next run continues
+	// from there; messages skipped by the filter are passed over too
+	Group string
+	// FromBeginning ignores the committed offsets and reads from the oldest
+	FromBeginning bool
+	Filter        Filter
+	// MaxReplays leaves messages already replayed this many times in the
+	// dead-letter topic
+	MaxReplays int
+	// Limit stops after replaying this many messages (0 is unlimited)
+	Limit int
+	// DryRun reports what would be replayed without sending or committing
+	DryRun bool
+	// Logger receives the progress of the replay (optional)
+	Logger *slog.Logger
+}
+
+// Result counts what a replay did with the messages it read
+type Result struct {
+	Scanned   int
+	Replayed  int
+	Filtered  int
+	Exhausted int
+}
+
+// Replayer reads a dead-letter topic up to its end at the start of the run and
+// republishes matching messages with an incremented replay count header
+type Replayer struct {
+	config   Config
+	client   sarama.Client
+	producer *kafka.Producer
+}
+
+// NewReplayer creates a replayer publishing with producer, which may be nil for dry runs
+func NewReplayer(config Config, producer *kafka.Producer, opts ...kafka.OptionFunc) (*Replayer, error) {
+	if config.MaxReplays <= 0 {
+		return nil, fmt.Errorf("max replays must be positive, got %d", config.MaxReplays)
+	}
+	if producer == nil && !config.DryRun {
+		return nil, fmt.Errorf("a producer is required unless dry-running")
+	}
+
+	config.Logger = logging.OrDefault(config.Logger)
+
+	saramaConfig := sarama.NewConfig()
+	for _, opt := range opts {
+		opt(saramaConfig)
+	}
+	client, err := sarama.NewClient(config.Brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+
+	return &Replayer{config: config, client: client, producer: producer}, nil
+}
+
+// Run replays every partition in turn until each is read to the end it had
+// when the run started, ctx is done, or the limit is reached
+func (r *Replayer) Run(ctx context.Context) (Result, error) {
+	var result Result
+
+	var offsets sarama.OffsetManager
+	if r.config.Group != "" && !r.config.DryRun {
+		var err error
+		offsets, err = sarama.NewOffsetManagerFromClient(r.config.Group, r.client)
+		if err != nil {
+			return result, fmt.Errorf("failed to create offset manager: %w", err)
+		}
+		defer offsets.Close()
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(r.client)
+	if err != nil {
+		return result, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := r.client.Partitions(r.config.Source)
+	if err != nil {
+		return result, fmt.Errorf("failed to list partitions of %s: %w", r.config.Source, err)
+	}
+
+	for _, partition := range partitions {
+		if err := r.replayPartition(ctx, consumer, offsets, partition, &result); err != nil {
+			return result, err
+		}
+		if ctx.Err() != nil || r.limitReached(result) {
+			break
+		}
+	}
+	return result, nil
+}
+
+// Close releases the Kafka client
+func (r *Replayer) Close() error {
+	return r.client.Close()
+}
+
+// replayPartition replays one partition from its start offset to its current end
+func (r *Replayer) replayPartition(ctx context.Context, consumer sarama.Consumer, offsets sarama.OffsetManager, partition int32, result *Result) error {
+	end, err := r.client.GetOffset(r.config.Source, partition, sarama.OffsetNewest)
+	if err != nil {
+		return fmt.Errorf("failed to get end offset of %s/%d: %w", r.config.Source, partition, err)
+	}
+
+	var pom sarama.PartitionOffsetManager
+	if offsets != nil {
+		pom, err = offsets.ManagePartition(r.config.Source, partition)
+		if err != nil {
+			return fmt.Errorf("failed to manage offsets of %s/%d: %w", r.config.Source, partition, err)
+		}
+		defer pom.Close()
+	}
+
+	start, err := r.startOffset(pom, partition)
+	if err != nil {
+		return err
+	}
+	if start >= end {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(r.config.Source, partition, start)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s/%d: %w", r.config.Source, partition, err)
+	}
+	defer pc.Close()
+
+	r.config.Logger.Info("Replaying partition", "topic", r.config.Source, "partition", partition, "from", start, "to", end)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-pc.Errors():
+			return fmt.Errorf("failed to read %s/%d: %w", r.config.Source, partition, err)
+		case message := <-pc.Messages():
+			if err := r.handle(ctx, message, result); err != nil {
+				return err
+			}
+			if pom != nil {
+				pom.MarkOffset(message.Offset+1, "")
+			}
+			if message.Offset+1 >= end || r.limitReached(*result) {
+				return nil
+			}
+		}
+	}
+}
+
+// startOffset returns where to start reading a partition: the committed
+// offset unless reading from the beginning, skipping ahead to Since
+func (r *Replayer) startOffset(pom sarama.PartitionOffsetManager, partition int32) (int64, error) {
+	start, err := r.client.GetOffset(r.config.Source, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get oldest offset of %s/%d: %w", r.config.Source, partition, err)
+	}
+	if pom != nil && !r.config.FromBeginning {
+		if next, _ := pom.NextOffset(); next > start {
+			start = next
+		}
+	}
+
+	// Messages are dead-lettered after they are produced, so nothing before
+	// the first message produced at Since can match
+	if !r.config.Filter.Since.IsZero() {
+		since, err := r.client.GetOffset(r.config.Source, partition, r.config.Filter.Since.UnixMilli())
+		if err != nil {
+			return 0, fmt.Errorf("failed to look up offset of %s/%d at %s: %w", r.config.Source, partition, r.config.Filter.Since, err)
+		}
+		if since > start {
+			start = since
+		}
+	}
+	return start, nil
+}
+
+// handle replays one message if it matches and has replays left
+func (r *Replayer) handle(ctx context.Context, message *sarama.ConsumerMessage, result *Result) error {
+	result.Scanned++
+	if !r.config.Filter.Match(message) {
+		result.Filtered++
+		return nil
+	}
+
+	count := kafka.ReplayCount(message)
+	if count >= r.config.MaxReplays {
+		result.Exhausted++
+		r.config.Logger.With(kafka.MessageLogAttrs(message)...).Warn("Leaving message in the DLT: replayed too many times already", "replays", count)
+		return nil
+	}
+
+	if r.config.DryRun {
+		reason, _ := kafka.Header(message, kafka.HeaderDLTReason)
+		r.config.Logger.With(kafka.MessageLogAttrs(message)...).Info("Would replay message", "key", string(message.Key), "replay", count+1, "reason", reason)
+		result.Replayed++
+		return nil
+	}
+
+	headers := []sarama.RecordHeader{kafka.ReplayCountHeader(count + 1)}
+	if traceID, ok := kafka.TraceID(message); ok {
+		headers = append(headers, kafka.TraceIDHeader(traceID))
+	}
+	if err := r.producer.SendMessage(ctx, message.Key, message.Value, headers...); err != nil {
+		return fmt.Errorf("failed to replay %s/%d@%d: %w", message.Topic, message.Partition, message.Offset, err)
+	}
+	result.Replayed++
+	return nil
+}
+
+// limitReached reports whether the replay limit has been reached
+func (r *Replayer) limitReached(result Result) bool {
+	return r.config.Limit > 0 && result.Replayed >= r.config.Limit
+}
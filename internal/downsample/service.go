@@ -0,0 +1,323 @@
+package downsample
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// closeInterval is how often windows are checked for closing
+const closeInterval = time.Second
+
+// publishConcurrency bounds the aggregates sent to Kafka at once
+const publishConcurrency = 64
+
+// Reading outcomes
+const (
+	OutcomeAggregated  = "aggregated"
+	OutcomeLate        = "late"
+	OutcomeUndecodable = "undecodable"
+)
+
+// Aggregate destinations
+const (
+	DestinationKafka    = "kafka"
+	DestinationPostgres = "postgres"
+)
+
+// Metrics holds Prometheus metrics for the aggregator
+type Metrics struct {
+	Readings    *prometheus.CounterVec
+	OpenWindows prometheus.Gauge
+	Aggregates  prometheus.Counter
+	WriteErrors *prometheus.CounterVec
+	EmitTime    prometheus.Histogram
+}
+
+// NewMetrics creates a new set of aggregator metrics
+func NewMetrics(namespace, subsystem string, registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		Readings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "readings_total",
+			Help:      "Total number of readings consumed, by outcome (aggregated, late or undecodable)",
+		}, []string{"outcome"}),
+		OpenWindows: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "open_windows",
+			Help:      "Number of sensor windows accumulating readings",
+		}),
+		Aggregates: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "aggregates_total",
+			Help:      "Total number of sensor aggregates emitted",
+		}),
+		WriteErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "write_errors_total",
+			Help:      "Total number of aggregates that could not be written, by destination (kafka or postgres)",
+		}, []string{"destination"}),
+		EmitTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "emit_duration_seconds",
+			Help:      "Time taken to write the aggregates of the windows closed at once, in seconds",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Readings,
+		metrics.OpenWindows,
+		metrics.Aggregates,
+		metrics.WriteErrors,
+		metrics.EmitTime,
+	)
+
+	return metrics
+}
+
+// Service consumes raw readings, downsamples them into per-sensor windows
+// and writes each closed window's aggregate to the aggregates topic and the
+// sensor_aggregates table. Offsets are committed as readings are folded in,
+// so a replica that crashes loses its open windows; one that stops cleanly
+// emits them as they are, and the next owner of their partitions emits the
+// rest, which the table merges.
+type Service struct {
+	Windows  *Windows
+	consumer *kafka.Consumer
+	decoder  *model.ReadingDecoder
+	producer *kafka.Producer
+	store    *Store
+	postgres *db.PostgresDB
+	metrics  *Metrics
+	logger   *slog.Logger
+
+	storeTimeout    time.Duration
+	shutdownTimeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewService connects to PostgreSQL and creates the aggregator with its
+// consumer and producer. Metrics are registered on registry.
+func NewService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "aggregator")
+	decoder, err := model.NewReadingDecoder(cfg.TopicFormats())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reading decoder: %w", err)
+	}
+
+	columns, err := encryption.NewColumnsFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load column encryption keys: %w", err)
+	}
+
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect aggregator database: %w", err)
+	}
+
+	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:         cfg.KafkaBrokers,
+		Topic:           cfg.Topic(config.TopicKeySensorAgg),
+		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+		Idempotent:      cfg.ProducerIdempotent,
+		ReturnSuccesses: cfg.ProducerReturnSuccess,
+		ReturnErrors:    cfg.ProducerReturnErrors,
+		Metrics:         kafka.NewProducerMetrics("iot", "aggregator_producer", registry),
+		Version:         cfg.KafkaVersion,
+		SendTimeout:     cfg.ProducerSendTimeout,
+		Security:        kafka.SecurityFromConfig(cfg),
+		Logger:          logger,
+	})
+	if err != nil {
+		postgres.Close()
+		return nil, fmt.Errorf("failed to create aggregate producer: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		Windows:         NewWindows(cfg.AggregatorWindow, cfg.AggregatorGrace),
+		decoder:         decoder,
+		producer:        producer,
+		store:           NewStore(postgres.DB(), columns),
+		postgres:        postgres,
+		metrics:         NewMetrics("iot", "aggregator", registry),
+		logger:          logger,
+		storeTimeout:    cfg.StoreTimeout,
+		shutdownTimeout: cfg.ProducerShutdownTimeout,
+		ctx:             ctx,
+		cancel:          cancel,
+		done:            make(chan struct{}),
+	}
+
+	consumer, err := kafka.NewConsumer(
+		kafka.ConsumerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			GroupID:         cfg.AggregatorGroupID,
+			Topics:          cfg.RawTopics(),
+			TopicWeights:    cfg.RawTopicWeights(),
+			OffsetInitial:   cfg.ConsumerOffsetInitial,
+			ReturnErrors:    cfg.ConsumerReturnErrors,
+			Metrics:         kafka.NewConsumerMetrics("iot", "aggregator_consumer", registry),
+			Version:         cfg.KafkaVersion,
+			HandlerTimeout:  cfg.ConsumerHandlerTimeout,
+			DrainTimeout:    cfg.ConsumerDrainTimeout,
+			Security:        kafka.SecurityFromConfig(cfg),
+			LagInterval:     cfg.ConsumerLagInterval,
+			BalanceStrategy: cfg.ConsumerBalanceStrategy,
+			InflightBudget:  kafka.InflightBudgetFromConfig(cfg, registry),
+			Schemas:         kafka.SchemaTrackerFromConfig(cfg, registry),
+			Logger:          logger,
+		},
+		s.HandleMessage,
+	)
+	if err != nil {
+		producer.Close()
+		postgres.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	s.consumer = consumer
+
+	return s, nil
+}
+
+// HandleMessage folds a raw reading into its window. Undecodable messages
+// are skipped; the detector routes them to the DLT.
+func (s *Service) HandleMessage(ctx context.Context, message *sarama.ConsumerMessage) error {
+	reading, _, err := s.decoder.DecodeFormat(message.Topic, message.Value, kafka.PayloadFormat(message))
+	if err != nil {
+		s.metrics.Readings.WithLabelValues(OutcomeUndecodable).Inc()
+		s.logger.With(kafka.MessageLogAttrs(message)...).Warn("Skipping undecodable reading", "error", err)
+		return nil
+	}
+
+	if !s.Windows.Add(reading, time.Now()) {
+		s.metrics.Readings.WithLabelValues(OutcomeLate).Inc()
+		s.logger.Debug("Skipping late reading", "sensor_id", reading.ID, "ts", reading.Timestamp)
+		return nil
+	}
+	s.metrics.Readings.WithLabelValues(OutcomeAggregated).Inc()
+	return nil
+}
+
+// Start starts closing windows and consuming readings
+func (s *Service) Start() error {
+	go s.run()
+	return s.consumer.Start()
+}
+
+// Stop stops consuming, emits the windows still open and closes the
+// producer and the database connection
+func (s *Service) Stop() {
+	s.consumer.Stop()
+	s.cancel()
+	<-s.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	s.emit(ctx, s.Windows.Flush())
+
+	if err := s.producer.GracefulShutdown(ctx); err != nil {
+		s.logger.Error("Failed to drain aggregate producer", "error", err)
+	}
+	if err := s.postgres.Close(); err != nil {
+		s.logger.Error("Failed to close aggregator database", "error", err)
+	}
+}
+
+// run closes the windows due on every interval until the service stops
+func (s *Service) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(closeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.emit(s.ctx, s.Windows.Close(now))
+			s.metrics.OpenWindows.Set(float64(s.Windows.Open()))
+		}
+	}
+}
+
+// emit writes aggregates to PostgreSQL and Kafka until ctx is done. Failed
+// writes are logged and counted; the aggregates are not retried.
+func (s *Service) emit(ctx context.Context, aggregates []*model.SensorAggregate) {
+	if len(aggregates) == 0 {
+		return
+	}
+	start := time.Now()
+	defer func() { s.metrics.EmitTime.Observe(time.Since(start).Seconds()) }()
+
+	storeCtx, cancel := context.WithTimeout(ctx, s.storeTimeout)
+	err := s.store.Insert(storeCtx, aggregates)
+	cancel()
+	if err != nil {
+		s.metrics.WriteErrors.WithLabelValues(DestinationPostgres).Add(float64(len(aggregates)))
+		s.logger.Error("Failed to store aggregates", "aggregates", len(aggregates), "error", err)
+	}
+
+	if failed := s.publish(ctx, aggregates); failed > 0 {
+		s.metrics.WriteErrors.WithLabelValues(DestinationKafka).Add(float64(failed))
+	}
+	s.metrics.Aggregates.Add(float64(len(aggregates)))
+}
+
+// publish sends aggregates keyed by sensor ID, a few at a time so sarama
+// batches them, and returns the number that failed
+func (s *Service) publish(ctx context.Context, aggregates []*model.SensorAggregate) int {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+		last   error
+	)
+	slots := make(chan struct{}, publishConcurrency)
+	for _, aggregate := range aggregates {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(aggregate *model.SensorAggregate) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			data, err := model.SerializeSensorAggregate(aggregate)
+			if err == nil {
+				err = s.producer.SendMessageWithKey(ctx, aggregate.SensorID, data)
+			}
+			if err != nil {
+				mu.Lock()
+				failed++
+				last = err
+				mu.Unlock()
+			}
+		}(aggregate)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		s.logger.Error("Failed to publish aggregates", "failed", failed, "aggregates", len(aggregates), "error", last)
+	}
+	return failed
+}
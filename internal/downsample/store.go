@@ -0,0 +1,81 @@
+package downsample
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+)
+
+// aggregateColumns is the number of bind parameters per inserted aggregate
+const aggregateColumns = 11
+
+// maxInsertRows keeps an insert below PostgreSQL's limit of 65535 bind
+// parameters
+const maxInsertRows = 5000
+
+// Store writes sensor aggregates to the sensor_aggregates table
+type Store struct {
+	db      *sql.DB
+	columns *encryption.Columns
+}
+
+// NewStore creates a store on db; columns encrypts sites and may be nil
+func NewStore(db *sql.DB, columns *encryption.Columns) *Store {
+	return &Store{db: db, columns: columns}
+}
+
+// Insert writes aggregates in as few statements as the bind parameter limit
+// allows. An aggregate of a window already stored, such as the rest of a
+// window whose partition moved to another aggregator, is merged into it.
+func (s *Store) Insert(ctx context.Context, aggregates []*model.SensorAggregate) error {
+	for len(aggregates) > 0 {
+		n := min(len(aggregates), maxInsertRows)
+		if err := s.insert(ctx, aggregates[:n]); err != nil {
+			return err
+		}
+		aggregates = aggregates[n:]
+	}
+	return nil
+}
+
+// insert writes up to maxInsertRows aggregates in one statement
+func (s *Store) insert(ctx context.Context, aggregates []*model.SensorAggregate) error {
+	var query strings.Builder
+	query.WriteString(`INSERT INTO sensor_aggregates (sensor_id, site, window_start, window_end, readings,
+		min_temperature, max_temperature, avg_temperature, min_humidity, max_humidity, avg_humidity) VALUES `)
+	args := make([]interface{}, 0, len(aggregates)*aggregateColumns)
+	for i, a := range aggregates {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := i * aggregateColumns
+		fmt.Fprintf(&query, "($%d, NULLIF($%d, ''), $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10, n+11)
+		site, err := s.columns.Seal(encryption.ColumnSite, a.Site)
+		if err != nil {
+			return err
+		}
+		args = append(args, a.SensorID, site, a.WindowStart, a.WindowEnd, a.Readings,
+			a.MinTemperature, a.MaxTemperature, a.AvgTemperature, a.MinHumidity, a.MaxHumidity, a.AvgHumidity)
+	}
+	query.WriteString(` ON CONFLICT (sensor_id, window_start) DO UPDATE SET
+		readings = sensor_aggregates.readings + EXCLUDED.readings,
+		min_temperature = LEAST(sensor_aggregates.min_temperature, EXCLUDED.min_temperature),
+		max_temperature = GREATEST(sensor_aggregates.max_temperature, EXCLUDED.max_temperature),
+		avg_temperature = (sensor_aggregates.avg_temperature * sensor_aggregates.readings + EXCLUDED.avg_temperature * EXCLUDED.readings)
+			/ (sensor_aggregates.readings + EXCLUDED.readings),
+		min_humidity = LEAST(sensor_aggregates.min_humidity, EXCLUDED.min_humidity),
+		max_humidity = GREATEST(sensor_aggregates.max_humidity, EXCLUDED.max_humidity),
+		avg_humidity = (sensor_aggregates.avg_humidity * sensor_aggregates.readings + EXCLUDED.avg_humidity * EXCLUDED.readings)
+			/ (sensor_aggregates.readings + EXCLUDED.readings),
+		site = COALESCE(EXCLUDED.site, sensor_aggregates.site)`)
+
+	if _, err := s.db.ExecContext(ctx, query.String(), args...); err != nil {
+		return fmt.Errorf("failed to insert %d sensor aggregates: %w", len(aggregates), err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package downsample
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// forgetAfter is the number of windows behind the latest reading time after
+// which a sensor's closed windows are forgotten, so that sensors that stopped
+// sending do not accumulate; a reading that late opens its window again
+const forgetAfter = 10
+
+// windowKey identifies the window of one sensor by its start in Unix
+// milliseconds
+type windowKey struct {
+	sensor string
+	start  int64
+}
+
+// sensorWindow accumulates one sensor's readings within a window
+type sensorWindow struct {
+	site        string
+	readings    int
+	minTemp     float64
+	maxTemp     float64
+	sumTemp     float64
+	minHumidity float64
+	maxHumidity float64
+	sumHumidity float64
+	// updated is when the window last received a reading
+	updated time.Time
+}
+
+func newSensorWindow() *sensorWindow {
+	return &sensorWindow{
+		minTemp:     math.Inf(1),
+		maxTemp:     math.Inf(-1),
+		minHumidity: math.Inf(1),
+		maxHumidity: math.Inf(-1),
+	}
+}
+
+// Windows folds readings into per-sensor tumbling windows of reading time,
+// aligned on multiples of the window size. A window closes once the latest
+// reading time seen passes its end by the grace period, so readings arriving
+// out of order within the grace still count, or once it has received nothing
+// for a window and the grace, so the last windows close when readings stop.
+// Readings of a window already closed are late and left out. Windows is safe
+// for concurrent use.
+type Windows struct {
+	size  time.Duration
+	grace time.Duration
+
+	mu   sync.Mutex
+	open map[windowKey]*sensorWindow
+	// watermark is the latest reading time seen, in Unix milliseconds
+	watermark int64
+	// closedUntil is the end of the last window closed for each sensor,
+	// forgotten forgetAfter windows behind the watermark
+	closedUntil map[string]int64
+}
+
+// NewWindows creates windows of size, closed grace after their end
+func NewWindows(size, grace time.Duration) *Windows {
+	return &Windows{
+		size:        size,
+		grace:       grace,
+		open:        make(map[windowKey]*sensorWindow),
+		closedUntil: make(map[string]int64),
+	}
+}
+
+// Add folds a reading received at now into its sensor's window. It returns
+// false for a late reading, whose window is already closed.
+func (w *Windows) Add(reading *model.SensorReading, now time.Time) bool {
+	start := reading.Timestamp - mod(reading.Timestamp, w.size.Milliseconds())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if until, ok := w.closedUntil[reading.ID]; ok && start < until {
+		return false
+	}
+	key := windowKey{sensor: reading.ID, start: start}
+	window, ok := w.open[key]
+	if !ok {
+		window = newSensorWindow()
+		w.open[key] = window
+	}
+	window.readings++
+	window.minTemp = math.Min(window.minTemp, float64(reading.Temperature))
+	window.maxTemp = math.Max(window.maxTemp, float64(reading.Temperature))
+	window.sumTemp += float64(reading.Temperature)
+	window.minHumidity = math.Min(window.minHumidity, float64(reading.Humidity))
+	window.maxHumidity = math.Max(window.maxHumidity, float64(reading.Humidity))
+	window.sumHumidity += float64(reading.Humidity)
+	if reading.Site != "" {
+		window.site = reading.Site
+	}
+	window.updated = now
+	w.watermark = max(w.watermark, reading.Timestamp)
+	return true
+}
+
+// Open returns the number of open windows
+func (w *Windows) Open() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.open)
+}
+
+// Close closes the windows due at now and returns their aggregates
+func (w *Windows) Close(now time.Time) []*model.SensorAggregate {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	aggregates := w.close(func(key windowKey, window *sensorWindow) bool {
+		end := key.start + w.size.Milliseconds()
+		return w.watermark >= end+w.grace.Milliseconds() || now.Sub(window.updated) >= w.size+w.grace
+	})
+	for sensor, until := range w.closedUntil {
+		if until < w.watermark-forgetAfter*w.size.Milliseconds() {
+			delete(w.closedUntil, sensor)
+		}
+	}
+	return aggregates
+}
+
+// Flush closes every open window, complete or not, and returns their
+// aggregates
+func (w *Windows) Flush() []*model.SensorAggregate {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close(func(windowKey, *sensorWindow) bool { return true })
+}
+
+// close closes the windows due and returns their aggregates ordered by
+// window and sensor
+func (w *Windows) close(due func(windowKey, *sensorWindow) bool) []*model.SensorAggregate {
+	var aggregates []*model.SensorAggregate
+	for key, window := range w.open {
+		if !due(key, window) {
+			continue
+		}
+		delete(w.open, key)
+		end := key.start + w.size.Milliseconds()
+		if until, ok := w.closedUntil[key.sensor]; !ok || end > until {
+			w.closedUntil[key.sensor] = end
+		}
+		aggregates = append(aggregates, &model.SensorAggregate{
+			SensorID:       key.sensor,
+			Site:           window.site,
+			WindowStart:    key.start,
+			WindowEnd:      end,
+			Readings:       window.readings,
+			MinTemperature: window.minTemp,
+			MaxTemperature: window.maxTemp,
+			AvgTemperature: window.sumTemp / float64(window.readings),
+			MinHumidity:    window.minHumidity,
+			MaxHumidity:    window.maxHumidity,
+			AvgHumidity:    window.sumHumidity / float64(window.readings),
+		})
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		if aggregates[i].WindowStart != aggregates[j].WindowStart {
+			return aggregates[i].WindowStart < aggregates[j].WindowStart
+		}
+		return aggregates[i].SensorID < aggregates[j].SensorID
+	})
+	return aggregates
+}
+
+// mod returns a modulo b, positive for negative a
+func mod(a, b int64) int64 {
+	return (a%b + b) % b
+}
@@ -0,0 +1,50 @@
+package heartbeat
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+)
+
+// Heartbeat is what a detector replica reports about its work on every
+// interval: the partitions it owns, how far it got in each and its rate. The
+// heartbeat topic is keyed by replica, so the monitor sees each replica's
+// lease without asking the group coordinator.
+type Heartbeat struct {
+	Replica string `json:"replica"`
+	Group   string `json:"group"`
+	// Color is the deployment color of a blue/green detector
+	Color string `json:"color,omitempty"`
+	// Topics are the topics the replica subscribes to, and Generation the
+	// consumer group generation its partitions were assigned in
+	Topics     []string                  `json:"topics"`
+	Generation int32                     `json:"generation"`
+	Partitions []kafka.PartitionPosition `json:"partitions"`
+	// Rate is the messages handled per second since the previous heartbeat
+	Rate float64 `json:"rate"`
+	// IntervalMs is the replica's heartbeat interval in milliseconds
+	IntervalMs int64     `json:"interval_ms"`
+	SentAt     time.Time `json:"sent_at"`
+	// Stopping marks the last heartbeat of a replica shutting down cleanly,
+	// which releases its lease at once
+	Stopping bool `json:"stopping,omitempty"`
+}
+
+// Encode serializes a heartbeat
+func (h *Heartbeat) Encode() ([]byte, error) {
+	return json.Marshal(h)
+}
+
+// Decode deserializes a heartbeat
+func Decode(value []byte) (*Heartbeat, error) {
+	var heartbeat Heartbeat
+	if err := json.Unmarshal(value, &heartbeat); err != nil {
+		return nil, fmt.Errorf("failed to decode heartbeat: %w", err)
+	}
+	if heartbeat.Replica == "" || heartbeat.Group == "" {
+		return nil, fmt.Errorf("invalid heartbeat: replica and group are required")
+	}
+	return &heartbeat, nil
+}
@@ -0,0 +1,647 @@
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// historyLeases is how many lease TTLs of heartbeats the monitor reads back
+// at start, so replicas that died while it was down are still noticed
+const historyLeases = 10
+
+// MonitorMetrics holds Prometheus metrics for the detector monitor
+type MonitorMetrics struct {
+	UnownedPartitions   *prometheus.GaugeVec
+	ContestedPartitions *prometheus.GaugeVec
+	LiveReplicas        *prometheus.GaugeVec
+	ReplicaLag          *prometheus.GaugeVec
+	ReplicaLagSeconds   *prometheus.GaugeVec
+	ReplicaLagging      *prometheus.GaugeVec
+	HeartbeatAge        *prometheus.GaugeVec
+	ExpiredLeases       *prometheus.CounterVec
+	InvalidHeartbeats   prometheus.Counter
+}
+
+// NewMonitorMetrics creates a new set of detector monitor metrics
+func NewMonitorMetrics(namespace, subsystem string, registry prometheus.Registerer) *MonitorMetrics {
+	metrics := &MonitorMetrics{
+		UnownedPartitions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "unowned_partitions",
+			Help:      "Number of partitions no live replica of the group has owned for at least a lease TTL",
+		}, []string{"group"}),
+		ContestedPartitions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "contested_partitions",
+			Help:      "Number of partitions several live replicas of the group have claimed for at least a lease TTL",
+		}, []string{"group"}),
+		LiveReplicas: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "live_replicas",
+			Help:      "Number of replicas of the group holding a live lease",
+		}, []string{"group"}),
+		ReplicaLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "replica_lag_messages",
+			Help:      "Messages between the replica's reported offsets and the high-water marks of its partitions",
+		}, []string{"group", "replica"}),
+		ReplicaLagSeconds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "replica_lag_seconds",
+			Help:      "Time the replica needs to clear its lag at its reported rate (+Inf when it handles nothing)",
+		}, []string{"group", "replica"}),
+		ReplicaLagging: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "replica_lagging",
+			Help:      "1 while the replica needs longer than the maximum lag to clear its lag, 0 otherwise",
+		}, []string{"group", "replica"}),
+		HeartbeatAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "heartbeat_age_seconds",
+			Help:      "Seconds since the replica's last heartbeat was sent",
+		}, []string{"group", "replica"}),
+		ExpiredLeases: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "expired_leases_total",
+			Help:      "Total number of replica leases that expired without a clean shutdown",
+		}, []string{"group"}),
+		InvalidHeartbeats: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "invalid_heartbeats_total",
+			Help:      "Total number of heartbeat records that could not be decoded",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.UnownedPartitions,
+		metrics.ContestedPartitions,
+		metrics.LiveReplicas,
+		metrics.ReplicaLag,
+		metrics.ReplicaLagSeconds,
+		metrics.ReplicaLagging,
+		metrics.HeartbeatAge,
+		metrics.ExpiredLeases,
+		metrics.InvalidHeartbeats,
+	)
+
+	return metrics
+}
+
+// MonitorConfig holds the settings of a detector monitor
+type MonitorConfig struct {
+	Brokers []string
+	// Topic is the heartbeat topic
+	Topic string
+	// LeaseTTL is how long a replica owns its partitions after its last
+	// heartbeat; a partition is also only reported unowned or contested once
+	// it has been for LeaseTTL, so rebalances do not alert
+	LeaseTTL time.Duration
+	// MaxLag is how long a replica's lag may take to clear at its rate
+	MaxLag time.Duration
+	// Interval is how often leases are evaluated
+	Interval time.Duration
+	Metrics  *MonitorMetrics
+	Logger   *slog.Logger
+}
+
+// PartitionRef names a partition of a topic
+type PartitionRef struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+}
+
+// ReplicaStatus is the lease and progress of one replica
+type ReplicaStatus struct {
+	Replica    string                    `json:"replica"`
+	Color      string                    `json:"color,omitempty"`
+	Live       bool                      `json:"live"`
+	SentAt     time.Time                 `json:"sent_at"`
+	Generation int32                     `json:"generation"`
+	Partitions []kafka.PartitionPosition `json:"partitions"`
+	Rate       float64                   `json:"rate"`
+	Lag        int64                     `json:"lag"`
+	// LagSeconds is the time to clear the lag at the rate; Stalled replaces
+	// it when the replica has lag but handles nothing
+	LagSeconds float64 `json:"lag_seconds"`
+	Stalled    bool    `json:"stalled,omitempty"`
+	Lagging    bool    `json:"lagging"`
+}
+
+// GroupStatus is the ownership of one consumer group's partitions
+type GroupStatus struct {
+	Group     string          `json:"group"`
+	Replicas  []ReplicaStatus `json:"replicas"`
+	Unowned   []PartitionRef  `json:"unowned"`
+	Contested []PartitionRef  `json:"contested"`
+}
+
+// lease is the last heartbeat of a replica; expired is set once its expiry
+// was reported
+type lease struct {
+	heartbeat *Heartbeat
+	expired   bool
+}
+
+// condition names a partition or replica problem of a group
+type condition struct {
+	kind    string
+	group   string
+	subject string
+}
+
+// Monitor follows the heartbeat topic and checks, from the replicas' own
+// reports rather than the group coordinator, that every partition of each
+// group has exactly one live owner and that no replica falls behind. A
+// replica that dies keeps its lease until it expires, and then keeps its
+// group watched until its partitions are owned again.
+type Monitor struct {
+	config   MonitorConfig
+	client   sarama.Client
+	consumer sarama.Consumer
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	leases map[string]map[string]*lease
+	status []GroupStatus
+
+	// since is when each candidate condition was first seen, and active
+	// the conditions reported; both are only used by evaluate
+	since  map[condition]time.Time
+	active map[condition]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewMonitor creates a monitor connected to the brokers
+func NewMonitor(config MonitorConfig, opts ...kafka.OptionFunc) (*Monitor, error) {
+	if config.LeaseTTL <= 0 {
+		return nil, fmt.Errorf("lease TTL must be positive, got %v", config.LeaseTTL)
+	}
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+
+	saramaConfig := sarama.NewConfig()
+	for _, opt := range opts {
+		opt(saramaConfig)
+	}
+	client, err := sarama.NewClient(config.Brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Monitor{
+		config: config,
+		client: client,
+		logger: logging.OrDefault(config.Logger),
+		leases: make(map[string]map[string]*lease),
+		since:  make(map[condition]time.Time),
+		active: make(map[condition]bool),
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// NewMonitorFromConfig creates a monitor of the configured heartbeat topic.
+// Metrics are registered on registry.
+func NewMonitorFromConfig(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Monitor, error) {
+	opts := []kafka.OptionFunc{kafka.WithKafkaVersion(cfg.KafkaVersion)}
+	security, err := kafka.SecurityFromConfig(cfg).Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Kafka security settings: %w", err)
+	}
+	return NewMonitor(MonitorConfig{
+		Brokers:  cfg.KafkaBrokers,
+		Topic:    cfg.Topic(config.TopicKeyHeartbeat),
+		LeaseTTL: cfg.HeartbeatLeaseTTL,
+		MaxLag:   cfg.HeartbeatMaxLag,
+		Interval: cfg.HeartbeatInterval,
+		Metrics:  NewMonitorMetrics("iot", "detector_monitor", registry),
+		Logger:   logger,
+	}, append(opts, security...)...)
+}
+
+// Start reads the heartbeats of the last leases and then follows the topic,
+// evaluating leases on every interval
+func (m *Monitor) Start() error {
+	partitions, err := m.client.Partitions(m.config.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to get partitions of %s: %w", m.config.Topic, err)
+	}
+	consumer, err := sarama.NewConsumerFromClient(m.client)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %w", err)
+	}
+	m.consumer = consumer
+
+	from := time.Now().Add(-historyLeases * m.config.LeaseTTL).UnixMilli()
+	for _, partition := range partitions {
+		offset, err := m.client.GetOffset(m.config.Topic, partition, from)
+		if err != nil {
+			return fmt.Errorf("failed to get offset of %s/%d: %w", m.config.Topic, partition, err)
+		}
+		if offset < 0 {
+			offset = sarama.OffsetNewest
+		}
+		pc, err := consumer.ConsumePartition(m.config.Topic, partition, offset)
+		if err != nil {
+			return fmt.Errorf("failed to consume %s/%d: %w", m.config.Topic, partition, err)
+		}
+		m.wg.Add(1)
+		go m.follow(pc)
+	}
+
+	m.wg.Add(1)
+	go m.run()
+	return nil
+}
+
+// Stop stops following the heartbeat topic and closes the Kafka client
+func (m *Monitor) Stop() {
+	m.cancel()
+	m.wg.Wait()
+	if m.consumer != nil {
+		m.consumer.Close()
+	}
+	m.client.Close()
+}
+
+// Status returns the groups as of the last evaluation
+func (m *Monitor) Status() []GroupStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// ServeHTTP serves the last evaluation as JSON, optionally only the group
+// named by the group query parameter
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	group := r.URL.Query().Get("group")
+	matches := []GroupStatus{}
+	for _, status := range m.Status() {
+		if group == "" || status.Group == group {
+			matches = append(matches, status)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(matches); err != nil {
+		m.logger.Warn("Failed to encode replica leases", "error", err)
+	}
+}
+
+// follow applies the heartbeats of one partition of the topic
+func (m *Monitor) follow(pc sarama.PartitionConsumer) {
+	defer m.wg.Done()
+	defer pc.Close()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case err := <-pc.Errors():
+			m.logger.Error("Failed to read heartbeat topic", "topic", m.config.Topic, "error", err)
+		case message := <-pc.Messages():
+			m.apply(message)
+		}
+	}
+}
+
+// apply records a heartbeat as its replica's lease, or releases the lease
+// of a replica that stopped cleanly
+func (m *Monitor) apply(message *sarama.ConsumerMessage) {
+	heartbeat, err := Decode(message.Value)
+	if err != nil {
+		m.logger.Warn("Ignoring heartbeat", "offset", message.Offset, "error", err)
+		if m.config.Metrics != nil {
+			m.config.Metrics.InvalidHeartbeats.Inc()
+		}
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replicas := m.leases[heartbeat.Group]
+	previous := replicas[heartbeat.Replica]
+	if previous != nil && heartbeat.SentAt.Before(previous.heartbeat.SentAt) {
+		return
+	}
+	if heartbeat.Stopping {
+		if previous != nil {
+			delete(replicas, heartbeat.Replica)
+			m.logger.Info("Replica released its lease", "group", heartbeat.Group, "replica", heartbeat.Replica)
+		}
+		if len(replicas) == 0 {
+			delete(m.leases, heartbeat.Group)
+		}
+		return
+	}
+	if replicas == nil {
+		replicas = make(map[string]*lease)
+		m.leases[heartbeat.Group] = replicas
+	}
+	if previous == nil || previous.expired {
+		m.logger.Info("Replica holds a lease", "group", heartbeat.Group, "replica", heartbeat.Replica, "color", heartbeat.Color,
+			"partitions", len(heartbeat.Partitions))
+	}
+	replicas[heartbeat.Replica] = &lease{heartbeat: heartbeat}
+}
+
+// run evaluates the leases on every tick. The first evaluation waits one
+// interval, so the heartbeats read back at start are applied first.
+func (m *Monitor) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.evaluate(time.Now())
+		}
+	}
+}
+
+// evaluate expires leases, checks the ownership and lag of every group and
+// publishes the result
+func (m *Monitor) evaluate(now time.Time) {
+	groups := m.expire(now)
+
+	var statuses []GroupStatus
+	candidates := make(map[condition]bool)
+	settled := make(map[string]bool)
+	for _, group := range sortedKeys(groups) {
+		status, owned := m.evaluateGroup(group, groups[group], now, candidates)
+		settled[group] = owned
+		statuses = append(statuses, status)
+	}
+
+	// Report conditions that lasted a lease TTL, and their recovery
+	for c := range m.since {
+		if !candidates[c] {
+			delete(m.since, c)
+		}
+	}
+	for c := range candidates {
+		if _, ok := m.since[c]; !ok {
+			m.since[c] = now
+		}
+	}
+	for c := range m.active {
+		if !candidates[c] {
+			delete(m.active, c)
+			m.logger.Info("Replica condition cleared", "condition", c.kind, "group", c.group, "subject", c.subject)
+		}
+	}
+
+	if m.config.Metrics != nil {
+		m.config.Metrics.UnownedPartitions.Reset()
+		m.config.Metrics.ContestedPartitions.Reset()
+		m.config.Metrics.LiveReplicas.Reset()
+		m.config.Metrics.ReplicaLag.Reset()
+		m.config.Metrics.ReplicaLagSeconds.Reset()
+		m.config.Metrics.ReplicaLagging.Reset()
+		m.config.Metrics.HeartbeatAge.Reset()
+	}
+	for i := range statuses {
+		m.report(&statuses[i], now)
+	}
+
+	m.mu.Lock()
+	m.status = statuses
+	// Forget the expired leases of groups whose partitions are all owned again
+	for group, replicas := range m.leases {
+		if !settled[group] {
+			continue
+		}
+		for replica, lease := range replicas {
+			if lease.expired {
+				delete(replicas, replica)
+			}
+		}
+		if len(replicas) == 0 {
+			delete(m.leases, group)
+		}
+	}
+	m.mu.Unlock()
+}
+
+// expire marks the leases whose TTL ran out and returns a copy of every
+// group's leases
+func (m *Monitor) expire(now time.Time) map[string][]lease {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	groups := make(map[string][]lease, len(m.leases))
+	for group, replicas := range m.leases {
+		for replica, lease := range replicas {
+			if !lease.expired && now.Sub(lease.heartbeat.SentAt) > m.config.LeaseTTL {
+				lease.expired = true
+				m.logger.Error("Replica lease expired without a clean shutdown", "group", group, "replica", replica,
+					"last_heartbeat", lease.heartbeat.SentAt, "partitions", len(lease.heartbeat.Partitions))
+				if m.config.Metrics != nil {
+					m.config.Metrics.ExpiredLeases.WithLabelValues(group).Inc()
+				}
+			}
+			groups[group] = append(groups[group], *lease)
+		}
+	}
+	return groups
+}
+
+// evaluateGroup checks the ownership of a group's partitions and the lag of
+// its live replicas, adding the problems found to candidates. It also reports
+// whether every partition has a live owner right now.
+func (m *Monitor) evaluateGroup(group string, leases []lease, now time.Time, candidates map[condition]bool) (GroupStatus, bool) {
+	sort.Slice(leases, func(i, j int) bool { return leases[i].heartbeat.Replica < leases[j].heartbeat.Replica })
+	status := GroupStatus{Group: group, Replicas: []ReplicaStatus{}, Unowned: []PartitionRef{}, Contested: []PartitionRef{}}
+
+	topics := make(map[string]bool)
+	owners := make(map[PartitionRef]int)
+	for _, lease := range leases {
+		heartbeat := lease.heartbeat
+		for _, topic := range heartbeat.Topics {
+			topics[topic] = true
+		}
+		replica := ReplicaStatus{
+			Replica:    heartbeat.Replica,
+			Color:      heartbeat.Color,
+			Live:       !lease.expired,
+			SentAt:     heartbeat.SentAt,
+			Generation: heartbeat.Generation,
+			Partitions: heartbeat.Partitions,
+			Rate:       heartbeat.Rate,
+		}
+		if replica.Live {
+			for _, position := range heartbeat.Partitions {
+				owners[PartitionRef{position.Topic, position.Partition}]++
+			}
+			m.lag(&replica)
+			if replica.Lagging {
+				candidates[condition{"lagging", group, replica.Replica}] = true
+			}
+		}
+		status.Replicas = append(status.Replicas, replica)
+	}
+
+	for _, topic := range sortedKeys(topics) {
+		partitions, err := m.client.Partitions(topic)
+		if err != nil {
+			m.logger.Warn("Failed to get partitions", "group", group, "topic", topic, "error", err)
+			continue
+		}
+		for _, partition := range partitions {
+			ref := PartitionRef{topic, partition}
+			switch {
+			case owners[ref] == 0:
+				status.Unowned = append(status.Unowned, ref)
+			case owners[ref] > 1:
+				status.Contested = append(status.Contested, ref)
+			}
+		}
+	}
+
+	// Partitions count once they have been unowned or contested for a lease
+	// TTL, which outlasts a rebalance
+	owned := len(status.Unowned) == 0
+	status.Unowned = m.lasting(group, "unowned", status.Unowned, now, candidates)
+	status.Contested = m.lasting(group, "contested", status.Contested, now, candidates)
+	return status, owned
+}
+
+// lasting records refs as candidates of a condition and returns those that
+// have been for a lease TTL
+func (m *Monitor) lasting(group, kind string, refs []PartitionRef, now time.Time, candidates map[condition]bool) []PartitionRef {
+	lasting := []PartitionRef{}
+	for _, ref := range refs {
+		c := condition{kind, group, fmt.Sprintf("%s/%d", ref.Topic, ref.Partition)}
+		candidates[c] = true
+		if since, ok := m.since[c]; ok && now.Sub(since) >= m.config.LeaseTTL {
+			lasting = append(lasting, ref)
+		}
+	}
+	return lasting
+}
+
+// lag computes a live replica's lag from the high-water marks of its
+// partitions. Partitions without a handled or committed offset are skipped.
+func (m *Monitor) lag(replica *ReplicaStatus) {
+	for _, position := range replica.Partitions {
+		if position.Offset < 0 {
+			continue
+		}
+		hwm, err := m.client.GetOffset(position.Topic, position.Partition, sarama.OffsetNewest)
+		if err != nil {
+			m.logger.Warn("Failed to get high-water mark", "topic", position.Topic, "partition", position.Partition, "error", err)
+			continue
+		}
+		if hwm > position.Offset {
+			replica.Lag += hwm - position.Offset
+		}
+	}
+
+	switch {
+	case replica.Lag == 0:
+	case replica.Rate > 0:
+		replica.LagSeconds = float64(replica.Lag) / replica.Rate
+	default:
+		replica.Stalled = true
+	}
+	replica.Lagging = replica.Stalled || replica.LagSeconds > m.config.MaxLag.Seconds()
+}
+
+// report logs the conditions of a group that lasted a lease TTL and exports
+// its metrics
+func (m *Monitor) report(status *GroupStatus, now time.Time) {
+	for _, ref := range status.Unowned {
+		m.activate(condition{"unowned", status.Group, fmt.Sprintf("%s/%d", ref.Topic, ref.Partition)},
+			"Partition has no live owner", "topic", ref.Topic, "partition", ref.Partition)
+	}
+	for _, ref := range status.Contested {
+		m.activate(condition{"contested", status.Group, fmt.Sprintf("%s/%d", ref.Topic, ref.Partition)},
+			"Partition is claimed by several replicas", "topic", ref.Topic, "partition", ref.Partition)
+	}
+
+	live := 0
+	for _, replica := range status.Replicas {
+		if !replica.Live {
+			continue
+		}
+		live++
+		if replica.Lagging {
+			m.activate(condition{"lagging", status.Group, replica.Replica},
+				"Replica is lagging", "lag", replica.Lag, "lag_seconds", replica.LagSeconds, "rate", replica.Rate)
+		}
+	}
+
+	metrics := m.config.Metrics
+	if metrics == nil {
+		return
+	}
+	metrics.UnownedPartitions.WithLabelValues(status.Group).Set(float64(len(status.Unowned)))
+	metrics.ContestedPartitions.WithLabelValues(status.Group).Set(float64(len(status.Contested)))
+	metrics.LiveReplicas.WithLabelValues(status.Group).Set(float64(live))
+	for _, replica := range status.Replicas {
+		metrics.HeartbeatAge.WithLabelValues(status.Group, replica.Replica).Set(now.Sub(replica.SentAt).Seconds())
+		if !replica.Live {
+			continue
+		}
+		lagSeconds := replica.LagSeconds
+		if replica.Stalled {
+			lagSeconds = math.Inf(1)
+		}
+		lagging := 0.0
+		if replica.Lagging {
+			lagging = 1
+		}
+		metrics.ReplicaLag.WithLabelValues(status.Group, replica.Replica).Set(float64(replica.Lag))
+		metrics.ReplicaLagSeconds.WithLabelValues(status.Group, replica.Replica).Set(lagSeconds)
+		metrics.ReplicaLagging.WithLabelValues(status.Group, replica.Replica).Set(lagging)
+	}
+}
+
+// activate logs a condition the first time it is reported
+func (m *Monitor) activate(c condition, msg string, args ...any) {
+	if m.active[c] {
+		return
+	}
+	m.active[c] = true
+	m.logger.Error(msg, append([]any{"group", c.group, "subject", c.subject}, args...)...)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,182 @@
+package heartbeat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PublisherConfig holds the settings of a heartbeat publisher
+type PublisherConfig struct {
+	// Replica names the replica and keys its heartbeats; Group is its
+	// consumer group and Color its blue/green color, if any
+	Replica  string
+	Group    string
+	Color    string
+	Interval time.Duration
+	Logger   *slog.Logger
+}
+
+// Publisher publishes the heartbeats of one consumer replica from its
+// partition progress
+type Publisher struct {
+	config   PublisherConfig
+	progress *kafka.PartitionProgress
+	producer *kafka.Producer
+	logger   *slog.Logger
+
+	// handled and at are the handled count and time of the previous
+	// heartbeat, from which the rate is computed
+	handled int64
+	at      time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPublisher creates a publisher of progress sending with producer, which
+// it owns
+func NewPublisher(config PublisherConfig, progress *kafka.PartitionProgress, producer *kafka.Producer) *Publisher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Publisher{
+		config:   config,
+		progress: progress,
+		producer: producer,
+		logger:   logging.OrDefault(config.Logger),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// NewPublisherFromConfig creates the heartbeat publisher of a detector
+// replica, or returns nil when HEARTBEAT_INTERVAL is 0. Metrics are
+// registered on registry.
+func NewPublisherFromConfig(cfg *config.Config, progress *kafka.PartitionProgress, registry prometheus.Registerer, logger *slog.Logger) (*Publisher, error) {
+	if cfg.HeartbeatInterval <= 0 {
+		return nil, nil
+	}
+
+	replica := cfg.DetectorReplicaID
+	if replica == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname for the replica ID; set DETECTOR_REPLICA_ID: %w", err)
+		}
+		replica = hostname
+	}
+
+	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:         cfg.KafkaBrokers,
+		Topic:           cfg.Topic(config.TopicKeyHeartbeat),
+		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+		ReturnSuccesses: cfg.ProducerReturnSuccess,
+		ReturnErrors:    cfg.ProducerReturnErrors,
+		Metrics:         kafka.NewProducerMetrics("iot", "heartbeat_producer", registry),
+		Version:         cfg.KafkaVersion,
+		SendTimeout:     cfg.ProducerSendTimeout,
+		Security:        kafka.SecurityFromConfig(cfg),
+		Logger:          logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create heartbeat producer: %w", err)
+	}
+
+	return NewPublisher(PublisherConfig{
+		Replica:  replica,
+		Group:    cfg.ConsumerGroupID,
+		Color:    cfg.DetectorColor,
+		Interval: cfg.HeartbeatInterval,
+		Logger:   logger,
+	}, progress, producer), nil
+}
+
+// Replica returns the name of the replica
+func (p *Publisher) Replica() string {
+	return p.config.Replica
+}
+
+// Start publishes a heartbeat on every interval
+func (p *Publisher) Start() {
+	p.at = time.Now()
+	go p.run()
+}
+
+// Stop stops publishing and sends a last heartbeat releasing the replica's
+// lease, then closes the producer. Stop the consumer first, so the partitions
+// are no longer handled when the lease is released.
+func (p *Publisher) Stop() {
+	p.cancel()
+	<-p.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Interval)
+	defer cancel()
+	p.publish(ctx, true)
+	if err := p.producer.GracefulShutdown(ctx); err != nil {
+		p.logger.Warn("Failed to flush heartbeats", "error", err)
+	}
+}
+
+// run publishes heartbeats until the publisher is stopped
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	p.publish(p.ctx, false)
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.publish(p.ctx, false)
+		}
+	}
+}
+
+// publish sends the current progress. A failed heartbeat is logged; the next
+// one replaces it.
+func (p *Publisher) publish(ctx context.Context, stopping bool) {
+	now := time.Now()
+	snapshot := p.progress.Snapshot()
+
+	var rate float64
+	if elapsed := now.Sub(p.at).Seconds(); elapsed > 0 {
+		rate = float64(snapshot.Handled-p.handled) / elapsed
+	}
+	p.handled, p.at = snapshot.Handled, now
+
+	heartbeat := &Heartbeat{
+		Replica:    p.config.Replica,
+		Group:      p.config.Group,
+		Color:      p.config.Color,
+		Topics:     snapshot.Topics,
+		Generation: snapshot.Generation,
+		Partitions: snapshot.Partitions,
+		Rate:       rate,
+		IntervalMs: p.config.Interval.Milliseconds(),
+		SentAt:     now,
+		Stopping:   stopping,
+	}
+	if stopping {
+		heartbeat.Partitions = nil
+	}
+
+	data, err := heartbeat.Encode()
+	if err == nil {
+		err = p.producer.SendMessage(ctx, []byte(p.config.Replica), data)
+	}
+	if err != nil {
+		p.logger.Warn("Failed to publish heartbeat", "replica", p.config.Replica, "error", err)
+	}
+}
@@ -0,0 +1,301 @@
+package incident
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// budgetBuckets is the number of one-minute buckets in the hourly budget window
+const budgetBuckets = 60
+
+// BudgetConfig configures alert volume budgets
+type BudgetConfig struct {
+	// Global is the maximum number of alerts per hour across the fleet (0 disables)
+	Global int
+	// PerSite is the maximum number of alerts per hour at any one site (0 disables)
+	PerSite int
+	// SummaryInterval is how often suppressed alerts are summarized while a budget is exceeded
+	SummaryInterval time.Duration
+}
+
+// BudgetMetrics holds Prometheus metrics for alert budgets
+type BudgetMetrics struct {
+	Suppressed *prometheus.CounterVec
+	Breaches   *prometheus.CounterVec
+	Summaries  prometheus.Counter
+	Breached   *prometheus.GaugeVec
+}
+
+// NewBudgetMetrics creates a new set of alert budget metrics
+func NewBudgetMetrics(namespace, subsystem string, registry prometheus.Registerer) *BudgetMetrics {
+	metrics := &BudgetMetrics{
+		Suppressed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "suppressed_alerts_total",
+			Help:      "Total number of alerts summarized instead of notified, by budget scope",
+		}, []string{"scope"}),
+		Breaches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "breaches_total",
+			Help:      "Total number of alert budget breaches by scope",
+		}, []string{"scope"}),
+		Summaries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "summaries_total",
+			Help:      "Total number of alert summaries notified",
+		}),
+		Breached: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "breached",
+			Help:      "Number of alert budgets currently exceeded by scope",
+		}, []string{"scope"}),
+	}
+
+	registry.MustRegister(
+		metrics.Suppressed,
+		metrics.Breaches,
+		metrics.Summaries,
+		metrics.Breached,
+	)
+
+	return metrics
+}
+
+// rateWindow counts events over the last hour in one-minute buckets
+type rateWindow struct {
+	counts  [budgetBuckets]int
+	minutes [budgetBuckets]int64
+}
+
+func (w *rateWindow) add(now time.Time) {
+	minute := now.Unix() / 60
+	i := minute % budgetBuckets
+	if w.minutes[i] != minute {
+		w.minutes[i] = minute
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+func (w *rateWindow) total(now time.Time) int {
+	minute := now.Unix() / 60
+	total := 0
+	for i, m := range w.minutes {
+		if minute-m < budgetBuckets {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
+
+// budgetScope tracks the alert rate and suppressed alerts of the fleet or one site
+type budgetScope struct {
+	scope    string
+	site     string
+	limit    int
+	window   rateWindow
+	breached bool
+	since    time.Time
+
+	// summary accumulates suppressed alerts until the next summary is notified
+	summary *model.AlertSummary
+	sensors map[string]struct{}
+}
+
+func (s *budgetScope) suppress(alert *model.SensorAlert, now time.Time) {
+	if s.summary == nil {
+		s.summary = &model.AlertSummary{
+			Scope:       s.scope,
+			Site:        s.site,
+			WindowStart: now.UnixMilli(),
+			ByRule:      make(map[string]int),
+		}
+		s.sensors = make(map[string]struct{})
+	}
+	rule := alert.Rule
+	if rule == "" {
+		rule = alert.Reason
+	}
+	s.summary.Suppressed++
+	s.summary.ByRule[rule]++
+	s.sensors[alert.SensorID] = struct{}{}
+}
+
+// takeSummary returns the accumulated summary, if any, and starts a new one
+func (s *budgetScope) takeSummary(now time.Time) *model.AlertSummary {
+	summary := s.summary
+	if summary == nil {
+		return nil
+	}
+	s.summary = nil
+
+	summary.WindowEnd = now.UnixMilli()
+	summary.Sensors = len(s.sensors)
+	summary.Summary = fmt.Sprintf("%d alerts from %d sensors %s suppressed by the alert budget",
+		summary.Suppressed, summary.Sensors, s.describe())
+	return summary
+}
+
+func (s *budgetScope) breach(status string, count int, now time.Time) *model.BudgetBreach {
+	verb := "exceeded"
+	if status == model.BudgetRecovered {
+		verb = "back within"
+	}
+	return &model.BudgetBreach{
+		Scope:     s.scope,
+		Site:      s.site,
+		Status:    status,
+		Limit:     s.limit,
+		Count:     count,
+		Since:     s.since.UnixMilli(),
+		Timestamp: now.UnixMilli(),
+		Summary:   fmt.Sprintf("%d alerts in the last hour %s %s the budget of %d", count, s.describe(), verb, s.limit),
+	}
+}
+
+func (s *budgetScope) describe() string {
+	if s.site != "" {
+		return "at site " + s.site
+	}
+	return "across the fleet"
+}
+
+// Budget caps the rate of individually notified alerts globally and per site.
+// While either budget is exceeded, alerts in its scope are folded into periodic
+// summaries and a budget breach meta-alert is raised; a second meta-alert marks
+// recovery once the hourly rate is back within the budget. Every alert counts
+// towards the rate, whether it was notified or summarized.
+// It is not safe for concurrent use; the correlator drives it from its run goroutine.
+type Budget struct {
+	config  BudgetConfig
+	metrics *BudgetMetrics
+	global  *budgetScope
+	sites   map[string]*budgetScope
+}
+
+// NewBudget creates an alert budget; metrics may be nil
+func NewBudget(config BudgetConfig, metrics *BudgetMetrics) *Budget {
+	return &Budget{
+		config:  config,
+		metrics: metrics,
+		global:  &budgetScope{scope: model.BudgetScopeGlobal, limit: config.Global},
+		sites:   make(map[string]*budgetScope),
+	}
+}
+
+// Allow records an alert and reports whether it may be notified individually.
+// It returns the breach meta-alerts raised by this alert, if any.
+func (b *Budget) Allow(alert *model.SensorAlert, now time.Time) (bool, []*model.BudgetBreach) {
+	scopes := []*budgetScope{b.global}
+	if alert.Site != "" && b.config.PerSite > 0 {
+		scopes = append(scopes, b.site(alert.Site))
+	}
+
+	var breaches []*model.BudgetBreach
+	var suppressing *budgetScope
+	for _, s := range scopes {
+		if s.limit <= 0 {
+			continue
+		}
+		s.window.add(now)
+		if count := s.window.total(now); !s.breached && count > s.limit {
+			s.breached = true
+			s.since = now
+			breaches = append(breaches, s.breach(model.BudgetBreached, count, now))
+			b.observeBreach(s.scope, 1)
+		}
+		// The global budget takes precedence so a systemic event yields one summary
+		if s.breached && suppressing == nil {
+			suppressing = s
+		}
+	}
+
+	if suppressing == nil {
+		return true, breaches
+	}
+	suppressing.suppress(alert, now)
+	if b.metrics != nil {
+		b.metrics.Suppressed.WithLabelValues(suppressing.scope).Inc()
+	}
+	return false, breaches
+}
+
+// Tick returns the summaries that are due and the recoveries of budgets back within their limit
+func (b *Budget) Tick(now time.Time) ([]*model.AlertSummary, []*model.BudgetBreach) {
+	var summaries []*model.AlertSummary
+	var breaches []*model.BudgetBreach
+
+	tick := func(s *budgetScope) {
+		recovered := false
+		if s.breached {
+			if count := s.window.total(now); count <= s.limit {
+				s.breached = false
+				recovered = true
+				breaches = append(breaches, s.breach(model.BudgetRecovered, count, now))
+				b.observeBreach(s.scope, -1)
+			}
+		}
+		if s.summary != nil && (recovered || now.Sub(time.UnixMilli(s.summary.WindowStart)) >= b.config.SummaryInterval) {
+			summaries = append(summaries, s.takeSummary(now))
+		}
+	}
+
+	tick(b.global)
+	for site, s := range b.sites {
+		tick(s)
+		if !s.breached && s.summary == nil && s.window.total(now) == 0 {
+			delete(b.sites, site)
+		}
+	}
+
+	if b.metrics != nil {
+		b.metrics.Summaries.Add(float64(len(summaries)))
+	}
+	return summaries, breaches
+}
+
+// Flush returns every pending summary regardless of the summary interval
+func (b *Budget) Flush(now time.Time) []*model.AlertSummary {
+	var summaries []*model.AlertSummary
+	if summary := b.global.takeSummary(now); summary != nil {
+		summaries = append(summaries, summary)
+	}
+	for _, s := range b.sites {
+		if summary := s.takeSummary(now); summary != nil {
+			summaries = append(summaries, summary)
+		}
+	}
+
+	if b.metrics != nil {
+		b.metrics.Summaries.Add(float64(len(summaries)))
+	}
+	return summaries
+}
+
+// site returns the budget scope of a site, creating it if needed
+func (b *Budget) site(name string) *budgetScope {
+	s, ok := b.sites[name]
+	if !ok {
+		s = &budgetScope{scope: model.BudgetScopeSite, site: name, limit: b.config.PerSite}
+		b.sites[name] = s
+	}
+	return s
+}
+
+// observeBreach records a budget entering (delta 1) or leaving (delta -1) breach
+func (b *Budget) observeBreach(scope string, delta int) {
+	if b.metrics == nil {
+		return
+	}
+	if delta > 0 {
+		b.metrics.Breaches.WithLabelValues(scope).Inc()
+	}
+	b.metrics.Breached.WithLabelValues(scope).Add(float64(delta))
+}
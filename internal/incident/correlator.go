@@ -0,0 +1,504 @@
+package incident
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxIncidentAlerts bounds the member alerts embedded in incident notifications;
+// the full membership is always stored relationally
+const maxIncidentAlerts = 50
+
+// defaultTimeout bounds store operations and notifications when no timeout is configured
+const defaultTimeout = 10 * time.Second
+
+// Notifier delivers pages. The correlator calls NotifyAlert for alerts that were
+// not correlated and NotifyIncident when an incident opens or resolves. When an
+// alert budget is configured it calls NotifySummary in place of suppressed alerts
+// and NotifyBudget when the budget is breached or recovers.
+type Notifier interface {
+	NotifyAlert(ctx context.Context, alert *model.SensorAlert) error
+	NotifyIncident(ctx context.Context, incident *model.Incident) error
+	NotifySummary(ctx context.Context, summary *model.AlertSummary) error
+	NotifyBudget(ctx context.Context, breach *model.BudgetBreach) error
+}
+
+// LogNotifier is a Notifier that writes notifications to the service log
+type LogNotifier struct {
+	// Logger receives the notifications; nil uses the default logger
+	Logger *slog.Logger
+}
+
+// NotifyAlert logs an individual alert
+func (n LogNotifier) NotifyAlert(ctx context.Context, alert *model.SensorAlert) error {
+	logging.OrDefault(n.Logger).Info("Notify alert", "sensor_id", alert.SensorID, "site", alert.Site,
+		"reason", alert.Reason, "runbook_url", alert.RunbookURL)
+	return nil
+}
+
+// NotifyIncident logs an incident
+func (n LogNotifier) NotifyIncident(ctx context.Context, incident *model.Incident) error {
+	logging.OrDefault(n.Logger).Info("Notify incident", "incident_id", incident.ID, "status", incident.Status,
+		"summary", incident.Summary, "runbook_url", incident.RunbookURL)
+	return nil
+}
+
+// NotifySummary logs a summary of suppressed alerts
+func (n LogNotifier) NotifySummary(ctx context.Context, summary *model.AlertSummary) error {
+	logging.OrDefault(n.Logger).Info("Notify summary", "summary", summary.Summary)
+	return nil
+}
+
+// NotifyBudget logs an alert budget breach or recovery
+func (n LogNotifier) NotifyBudget(ctx context.Context, breach *model.BudgetBreach) error {
+	logging.OrDefault(n.Logger).Info("Notify alert budget", "status", breach.Status, "summary", breach.Summary)
+	return nil
+}
+
+// CorrelatorConfig configures alert correlation
+type CorrelatorConfig struct {
+	// GroupWait is how long alerts from a site are held before deciding whether they form an incident
+	GroupWait time.Duration
+	// MinSensors is the number of distinct alerting sensors at a site that opens an incident
+	MinSensors int
+	// QuietPeriod resolves an incident after no new alerts arrive for this long
+	QuietPeriod time.Duration
+	// Timeout bounds each store operation and notification
+	Timeout time.Duration
+	// Logger receives incident transitions and failures (optional)
+	Logger *slog.Logger
+}
+
+// CorrelatorMetrics holds Prometheus metrics for alert correlation
+type CorrelatorMetrics struct {
+	Alerts        *prometheus.CounterVec
+	Suppressed    *prometheus.CounterVec
+	Deduplicated  *prometheus.CounterVec
+	RateLimited   *prometheus.CounterVec
+	Incidents     *prometheus.CounterVec
+	OpenIncidents prometheus.Gauge
+	StoreErrors   prometheus.Counter
+	NotifyErrors  prometheus.Counter
+}
+
+// NewCorrelatorMetrics creates a new set of correlator metrics
+func NewCorrelatorMetrics(namespace, subsystem string, registry prometheus.Registerer) *CorrelatorMetrics {
+	metrics := &CorrelatorMetrics{
+		Alerts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "alerts_total",
+			Help:      "Total number of alerts by outcome (notified, correlated, summarized)",
+		}, []string{"outcome"}),
+		Suppressed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "suppressed_alerts_total",
+			Help:      "Total number of alerts not notified individually, by reason (the rule), severity and site",
+		}, metrics.AlertLabels),
+		Deduplicated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "deduplicated_alerts_total",
+			Help:      "Total number of alerts folded into an incident, by reason (the rule), severity and site",
+		}, metrics.AlertLabels),
+		RateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "rate_limited_alerts_total",
+			Help:      "Total number of alerts summarized by the alert budget, by reason (the rule), severity and site",
+		}, metrics.AlertLabels),
+		Incidents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "incidents_total",
+			Help:      "Total number of incident transitions by status",
+		}, []string{"status"}),
+		OpenIncidents: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "open_incidents",
+			Help:      "Number of currently open incidents",
+		}),
+		StoreErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "store_errors_total",
+			Help:      "Total number of failed incident store operations",
+		}),
+		NotifyErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "notify_errors_total",
+			Help:      "Total number of failed notifications",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Alerts,
+		metrics.Suppressed,
+		metrics.Deduplicated,
+		metrics.RateLimited,
+		metrics.Incidents,
+		metrics.OpenIncidents,
+		metrics.StoreErrors,
+		metrics.NotifyErrors,
+	)
+
+	return metrics
+}
+
+// pendingGroup holds a site's alerts during the group wait
+type pendingGroup struct {
+	alerts   []*model.SensorAlert
+	sensors  map[string]struct{}
+	deadline time.Time
+}
+
+// openIncident tracks an incident that is still receiving alerts
+type openIncident struct {
+	incident  *model.Incident
+	sensors   map[string]struct{}
+	lastAlert time.Time
+}
+
+// Correlator collapses bursts of sensor alerts at one site into incidents.
+// Alerts from a site are held for GroupWait; if MinSensors distinct sensors
+// alerted, a single incident is opened and notified, otherwise each alert is
+// notified individually. Later alerts at a site with an open incident are
+// attached to it silently until the site has been quiet for QuietPeriod.
+type Correlator struct {
+	config   CorrelatorConfig
+	store    *Store
+	notifier Notifier
+	metrics  *CorrelatorMetrics
+	bus      *bus.Bus
+	sub      *bus.Subscription
+
+	// pending and open are keyed by site and only touched by the run goroutine
+	pending map[string]*pendingGroup
+	open    map[string]*openIncident
+
+	// annotator optionally attaches the site runbook and annotations to incidents
+	annotator model.Annotator
+
+	// budget optionally caps the rate of individually notified alerts
+	budget *Budget
+
+	// ctx outlives the final flush on Stop and is cancelled once it is done
+	ctx    context.Context
+	cancel context.CancelFunc
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewCorrelator creates a correlator; store and metrics may be nil
+func NewCorrelator(eventBus *bus.Bus, config CorrelatorConfig, store *Store, notifier Notifier, metrics *CorrelatorMetrics) *Correlator {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	config.Logger = logging.OrDefault(config.Logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Correlator{
+		ctx:      ctx,
+		cancel:   cancel,
+		config:   config,
+		store:    store,
+		notifier: notifier,
+		metrics:  metrics,
+		bus:      eventBus,
+		pending:  make(map[string]*pendingGroup),
+		open:     make(map[string]*openIncident),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// SetAnnotator sets the source of runbook links and annotations for incidents
+func (c *Correlator) SetAnnotator(annotator model.Annotator) {
+	c.annotator = annotator
+}
+
+// SetBudget sets the alert volume budget applied to uncorrelated alerts
+func (c *Correlator) SetBudget(budget *Budget) {
+	c.budget = budget
+}
+
+// Start subscribes to alerts and begins correlating
+func (c *Correlator) Start() {
+	c.sub = c.bus.Subscribe(bus.TopicAlerts, 4096)
+
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Stop stops correlating; pending alerts are notified individually
+func (c *Correlator) Stop() {
+	close(c.stopCh)
+	c.wg.Wait()
+	c.cancel()
+	c.sub.Unsubscribe()
+}
+
+func (c *Correlator) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			now := time.Now()
+			for site, group := range c.pending {
+				c.notifyIndividually(group, now)
+				delete(c.pending, site)
+			}
+			if c.budget != nil {
+				for _, summary := range c.budget.Flush(now) {
+					c.notifySummary(summary)
+				}
+			}
+			return
+		case event, ok := <-c.sub.C():
+			if !ok {
+				return
+			}
+			if alert, ok := event.Payload.(*model.SensorAlert); ok {
+				c.Add(alert, time.Now())
+			}
+		case now := <-ticker.C:
+			c.Tick(now)
+		}
+	}
+}
+
+// Add routes an alert into its site's pending group or open incident.
+// Alerts without a site cannot be correlated and are notified immediately.
+func (c *Correlator) Add(alert *model.SensorAlert, now time.Time) {
+	if alert.Site == "" {
+		c.notifyAlert(alert, now)
+		return
+	}
+
+	if open, ok := c.open[alert.Site]; ok {
+		open.sensors[alert.SensorID] = struct{}{}
+		open.lastAlert = now
+		open.incident.AlertCount++
+		open.incident.Sensors = len(open.sensors)
+		open.incident.UpdatedAt = now.UnixMilli()
+		c.observeAlerts("correlated", alert)
+		c.persist(func(ctx context.Context) error {
+			return c.store.AddAlerts(ctx, open.incident, []*model.SensorAlert{alert})
+		})
+		return
+	}
+
+	group, ok := c.pending[alert.Site]
+	if !ok {
+		group = &pendingGroup{sensors: make(map[string]struct{}), deadline: now.Add(c.config.GroupWait)}
+		c.pending[alert.Site] = group
+	}
+	group.alerts = append(group.alerts, alert)
+	group.sensors[alert.SensorID] = struct{}{}
+}
+
+// Tick flushes pending groups whose wait has elapsed and resolves quiet incidents
+func (c *Correlator) Tick(now time.Time) {
+	for site, group := range c.pending {
+		if now.Before(group.deadline) {
+			continue
+		}
+		delete(c.pending, site)
+
+		if len(group.sensors) >= c.config.MinSensors {
+			c.openIncident(site, group, now)
+		} else {
+			c.notifyIndividually(group, now)
+		}
+	}
+
+	for site, open := range c.open {
+		if now.Sub(open.lastAlert) < c.config.QuietPeriod {
+			continue
+		}
+		delete(c.open, site)
+
+		open.incident.Status = model.IncidentResolved
+		open.incident.ResolvedAt = now.UnixMilli()
+		open.incident.Alerts = nil
+		c.persist(func(ctx context.Context) error {
+			return c.store.Resolve(ctx, open.incident)
+		})
+		c.publish(open.incident)
+	}
+
+	if c.budget != nil {
+		summaries, breaches := c.budget.Tick(now)
+		for _, breach := range breaches {
+			c.notifyBudget(breach)
+		}
+		for _, summary := range summaries {
+			c.notifySummary(summary)
+		}
+	}
+
+	if c.metrics != nil {
+		c.metrics.OpenIncidents.Set(float64(len(c.open)))
+	}
+}
+
+// openIncident turns a pending group into an incident and notifies it once
+func (c *Correlator) openIncident(site string, group *pendingGroup, now time.Time) {
+	incident := &model.Incident{
+		ID:         uuid.New().String(),
+		Site:       site,
+		Status:     model.IncidentOpen,
+		OpenedAt:   now.UnixMilli(),
+		UpdatedAt:  now.UnixMilli(),
+		AlertCount: len(group.alerts),
+		Sensors:    len(group.sensors),
+		Summary:    fmt.Sprintf("%d sensors at site %s alerted within %s", len(group.sensors), site, c.config.GroupWait),
+	}
+	if c.annotator != nil {
+		incident.RunbookURL, incident.Annotations = c.annotator.Annotate("", site)
+	}
+	c.open[site] = &openIncident{incident: incident, sensors: group.sensors, lastAlert: now}
+	c.observeAlerts("correlated", group.alerts...)
+
+	c.persist(func(ctx context.Context) error {
+		return c.store.Create(ctx, incident, group.alerts)
+	})
+
+	notification := *incident
+	notification.Alerts = group.alerts
+	if len(notification.Alerts) > maxIncidentAlerts {
+		notification.Alerts = notification.Alerts[:maxIncidentAlerts]
+	}
+	c.publish(&notification)
+}
+
+// publish notifies an incident transition
+func (c *Correlator) publish(incident *model.Incident) {
+	c.config.Logger.Info("Incident", "incident_id", incident.ID, "status", incident.Status, "site", incident.Site, "summary", incident.Summary)
+	if c.metrics != nil {
+		c.metrics.Incidents.WithLabelValues(incident.Status).Inc()
+	}
+	c.bus.Publish(bus.TopicIncidents, incident.Site, incident)
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
+	defer cancel()
+	if err := c.notifier.NotifyIncident(ctx, incident); err != nil {
+		c.config.Logger.Error("Failed to notify incident", "incident_id", incident.ID, "error", err)
+		c.observeNotifyError()
+	}
+}
+
+// notifyIndividually pages each alert of an uncorrelated group
+func (c *Correlator) notifyIndividually(group *pendingGroup, now time.Time) {
+	for _, alert := range group.alerts {
+		c.notifyAlert(alert, now)
+	}
+}
+
+// notifyAlert pages an alert unless the alert budget folds it into a summary
+func (c *Correlator) notifyAlert(alert *model.SensorAlert, now time.Time) {
+	if c.budget != nil {
+		allowed, breaches := c.budget.Allow(alert, now)
+		for _, breach := range breaches {
+			c.notifyBudget(breach)
+		}
+		if !allowed {
+			c.observeAlerts("summarized", alert)
+			return
+		}
+	}
+	c.observeAlerts("notified", alert)
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
+	defer cancel()
+	if err := c.notifier.NotifyAlert(ctx, alert); err != nil {
+		c.config.Logger.Error("Failed to notify alert", "sensor_id", alert.SensorID, "error", err)
+		c.observeNotifyError()
+	}
+}
+
+// notifySummary pages a summary of alerts suppressed by the budget
+func (c *Correlator) notifySummary(summary *model.AlertSummary) {
+	c.config.Logger.Info("Alert summary", "summary", summary.Summary)
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
+	defer cancel()
+	if err := c.notifier.NotifySummary(ctx, summary); err != nil {
+		c.config.Logger.Error("Failed to notify alert summary", "error", err)
+		c.observeNotifyError()
+	}
+}
+
+// notifyBudget pages an alert budget breach or recovery
+func (c *Correlator) notifyBudget(breach *model.BudgetBreach) {
+	c.config.Logger.Warn("Alert budget", "status", breach.Status, "summary", breach.Summary)
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
+	defer cancel()
+	if err := c.notifier.NotifyBudget(ctx, breach); err != nil {
+		c.config.Logger.Error("Failed to notify alert budget", "status", breach.Status, "error", err)
+		c.observeNotifyError()
+	}
+}
+
+// persist runs a store operation when a store is configured
+func (c *Correlator) persist(op func(ctx context.Context) error) {
+	if c.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout)
+	defer cancel()
+	if err := op(ctx); err != nil {
+		c.config.Logger.Error("Failed to store incident", "error", err)
+		if c.metrics != nil {
+			c.metrics.StoreErrors.Inc()
+		}
+	}
+}
+
+// observeAlerts counts alerts by outcome, and alerts not notified
+// individually by reason, severity and site
+func (c *Correlator) observeAlerts(outcome string, alerts ...*model.SensorAlert) {
+	if c.metrics == nil {
+		return
+	}
+	c.metrics.Alerts.WithLabelValues(outcome).Add(float64(len(alerts)))
+
+	var folded *prometheus.CounterVec
+	switch outcome {
+	case "correlated":
+		folded = c.metrics.Deduplicated
+	case "summarized":
+		folded = c.metrics.RateLimited
+	default:
+		return
+	}
+	for _, alert := range alerts {
+		labels := metrics.AlertLabelValues(alert)
+		folded.WithLabelValues(labels...).Inc()
+		c.metrics.Suppressed.WithLabelValues(labels...).Inc()
+	}
+}
+
+func (c *Correlator) observeNotifyError() {
+	if c.metrics != nil {
+		c.metrics.NotifyErrors.Inc()
+	}
+}
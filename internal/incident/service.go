@@ -0,0 +1,160 @@
+package incident
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/bus"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	sensorregistry "github.com/example/iot-sensor-fleet/internal/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ProducerNotifier writes notifications to a Kafka topic for the notifier to deliver
+type ProducerNotifier struct {
+	producer *kafka.Producer
+}
+
+// NewProducerNotifier creates a notifier publishing to producer's topic
+func NewProducerNotifier(producer *kafka.Producer) *ProducerNotifier {
+	return &ProducerNotifier{producer: producer}
+}
+
+// NotifyAlert publishes an uncorrelated alert notification keyed by sensor
+func (n *ProducerNotifier) NotifyAlert(ctx context.Context, alert *model.SensorAlert) error {
+	return n.send(ctx, alert.SensorID, &model.Notification{Type: model.NotificationAlert, Alert: alert})
+}
+
+// NotifyIncident publishes an incident notification keyed by site
+func (n *ProducerNotifier) NotifyIncident(ctx context.Context, incident *model.Incident) error {
+	return n.send(ctx, incident.Site, &model.Notification{Type: model.NotificationIncident, Incident: incident})
+}
+
+// NotifySummary publishes a summary of suppressed alerts keyed by site, or by scope for the fleet
+func (n *ProducerNotifier) NotifySummary(ctx context.Context, summary *model.AlertSummary) error {
+	return n.send(ctx, budgetKey(summary.Scope, summary.Site), &model.Notification{Type: model.NotificationSummary, Summary: summary})
+}
+
+// NotifyBudget publishes an alert budget breach or recovery keyed like summaries
+func (n *ProducerNotifier) NotifyBudget(ctx context.Context, breach *model.BudgetBreach) error {
+	return n.send(ctx, budgetKey(breach.Scope, breach.Site), &model.Notification{Type: model.NotificationBudget, Budget: breach})
+}
+
+// budgetKey keeps a scope's summaries and budget notifications in order on one partition
+func budgetKey(scope, site string) string {
+	if site != "" {
+		return site
+	}
+	return scope
+}
+
+func (n *ProducerNotifier) send(ctx context.Context, key string, notification *model.Notification) error {
+	data, err := model.SerializeNotification(notification)
+	if err != nil {
+		return err
+	}
+	return n.producer.SendMessageWithKey(ctx, key, data)
+}
+
+// Service correlates alerts from the in-process bus into incidents
+type Service struct {
+	Correlator  *Correlator
+	producer    *kafka.Producer
+	postgres    *db.PostgresDB
+	annotations *sensorregistry.AnnotationCache
+}
+
+// NewService creates the correlator from configuration. Alerts are taken from
+// eventBus, so the detector must run in the same process. Incidents are stored
+// in PostgreSQL when it is reachable. Metrics are registered on registry.
+func NewService(cfg *config.Config, registry prometheus.Registerer, eventBus *bus.Bus, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "correlator")
+	if eventBus == nil {
+		return nil, fmt.Errorf("alert correlation requires an in-process bus")
+	}
+
+	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:         cfg.KafkaBrokers,
+		Topic:           cfg.Topic(config.TopicKeyNotification),
+		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+		Idempotent:      cfg.ProducerIdempotent,
+		ReturnSuccesses: cfg.ProducerReturnSuccess,
+		ReturnErrors:    cfg.ProducerReturnErrors,
+		Metrics:         kafka.NewProducerMetrics("iot", "notification_producer", registry),
+		Version:         cfg.KafkaVersion,
+		SendTimeout:     cfg.ProducerSendTimeout,
+		HopHeaders:      cfg.HopHeaders,
+		Security:        kafka.SecurityFromConfig(cfg),
+		Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentCorrelator),
+		Logger:          logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notification producer: %w", err)
+	}
+
+	s := &Service{producer: producer}
+
+	var store *Store
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		logger.Warn("Incidents will not be stored", "error", err)
+	} else {
+		s.postgres = postgres
+		store = NewStore(postgres.DB())
+	}
+
+	s.Correlator = NewCorrelator(eventBus, CorrelatorConfig{
+		GroupWait:   cfg.CorrelationGroupWait,
+		MinSensors:  cfg.CorrelationMinSensors,
+		QuietPeriod: cfg.CorrelationQuietPeriod,
+		Timeout:     cfg.StoreTimeout,
+		Logger:      logger,
+	}, store, NewProducerNotifier(producer), NewCorrelatorMetrics("iot", "correlator", registry))
+
+	// Summarize alerts instead of paging each one while an alert budget is exceeded
+	if cfg.AlertBudgetGlobal > 0 || cfg.AlertBudgetPerSite > 0 {
+		s.Correlator.SetBudget(NewBudget(BudgetConfig{
+			Global:          cfg.AlertBudgetGlobal,
+			PerSite:         cfg.AlertBudgetPerSite,
+			SummaryInterval: cfg.AlertBudgetSummaryInterval,
+		}, NewBudgetMetrics("iot", "alert_budget", registry)))
+	}
+
+	// Attach site runbook links and annotations from the sensor registry
+	annotations, err := sensorregistry.NewAnnotationCacheFromConfig(cfg, logger)
+	if err != nil {
+		logger.Warn("Incidents will not be annotated", "error", err)
+	} else if annotations != nil {
+		s.annotations = annotations
+		s.Correlator.SetAnnotator(annotations)
+	}
+
+	return s, nil
+}
+
+// Start starts correlating alerts
+func (s *Service) Start() error {
+	if s.annotations != nil {
+		s.annotations.Start()
+	}
+	s.Correlator.Start()
+	return nil
+}
+
+// Stop stops correlating and releases the producer and database
+func (s *Service) Stop() {
+	s.Correlator.Stop()
+	s.producer.Close()
+	if s.postgres != nil {
+		s.postgres.Close()
+	}
+	if s.annotations != nil {
+		s.annotations.Stop()
+	}
+}
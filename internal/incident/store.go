@@ -0,0 +1,102 @@
+package incident
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Store persists incidents and their member alerts in PostgreSQL.
+// Member alerts reference sensor_alerts by (sensor_id, ts).
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a new incident store
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create inserts a new incident together with its member alerts
+func (s *Store) Create(ctx context.Context, incident *model.Incident, alerts []*model.SensorAlert) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO incidents (id, site, status, opened_at, updated_at, alert_count, summary)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, incident.ID, incident.Site, incident.Status,
+		time.UnixMilli(incident.OpenedAt), time.UnixMilli(incident.UpdatedAt),
+		incident.AlertCount, incident.Summary); err != nil {
+		return fmt.Errorf("failed to insert incident: %w", err)
+	}
+
+	if err := insertMembers(ctx, tx, incident.ID, alerts); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit incident: %w", err)
+	}
+	return nil
+}
+
+// AddAlerts attaches further alerts to an open incident
+func (s *Store) AddAlerts(ctx context.Context, incident *model.Incident, alerts []*model.SensorAlert) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertMembers(ctx, tx, incident.ID, alerts); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE incidents SET alert_count = $2, updated_at = $3 WHERE id = $1
+	`, incident.ID, incident.AlertCount, time.UnixMilli(incident.UpdatedAt)); err != nil {
+		return fmt.Errorf("failed to update incident: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit incident update: %w", err)
+	}
+	return nil
+}
+
+// Resolve marks an incident as resolved
+func (s *Store) Resolve(ctx context.Context, incident *model.Incident) error {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE incidents SET status = $2, resolved_at = $3 WHERE id = $1
+	`, incident.ID, incident.Status, time.UnixMilli(incident.ResolvedAt)); err != nil {
+		return fmt.Errorf("failed to resolve incident: %w", err)
+	}
+	return nil
+}
+
+// insertMembers records the alerts belonging to an incident
+func insertMembers(ctx context.Context, tx *sql.Tx, incidentID string, alerts []*model.SensorAlert) error {
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO incident_alerts (incident_id, sensor_id, ts, reason)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare incident alert insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, alert := range alerts {
+		if _, err := stmt.ExecContext(ctx, incidentID, alert.SensorID, alert.Timestamp, alert.Reason); err != nil {
+			return fmt.Errorf("failed to insert incident alert: %w", err)
+		}
+	}
+	return nil
+}
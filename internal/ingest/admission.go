@@ -0,0 +1,237 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Admission rule names used in metrics and rejection records
+const (
+	RuleTimestampFuture  = "timestamp_future"
+	RuleTimestampPast    = "timestamp_past"
+	RuleNonFinite        = "non_finite_value"
+	RuleTemperatureRange = "temperature_range"
+	RuleHumidityRange    = "humidity_range"
+	RuleUnregistered     = "unregistered_sensor"
+	RuleMissingID        = "missing_id"
+)
+
+// Physical limits outside of which values are considered impossible
+const (
+	MinPlausibleTemperature float32 = -90
+	MaxPlausibleTemperature float32 = 150
+	MinPlausibleHumidity    float32 = 0
+	MaxPlausibleHumidity    float32 = 100
+)
+
+// SensorRegistry reports whether a sensor ID is known to the fleet
+type SensorRegistry interface {
+	IsRegistered(ctx context.Context, sensorID string) (bool, error)
+}
+
+// StaticSensorRegistry is a SensorRegistry backed by a fixed set of IDs
+type StaticSensorRegistry map[string]struct{}
+
+// IsRegistered implements SensorRegistry
+func (s StaticSensorRegistry) IsRegistered(ctx context.Context, sensorID string) (bool, error) {
+	_, ok := s[sensorID]
+	return ok, nil
+}
+
+// AdmissionConfig configures the admission rules
+type AdmissionConfig struct {
+	// MaxFutureSkew rejects readings timestamped further in the future; zero disables
+	MaxFutureSkew time.Duration
+	// MaxPastAge rejects readings older than this; zero disables
+	MaxPastAge time.Duration
+	// ClampValues clamps out-of-range values to the plausible limits instead of rejecting
+	ClampValues bool
+	// Registry, when set, rejects readings from unregistered sensors
+	Registry SensorRegistry
+}
+
+// Rejection describes a reading refused by admission, as published to the rejects topic
+type Rejection struct {
+	Rule       string               `json:"rule"`
+	Reason     string               `json:"reason"`
+	ReceivedAt int64                `json:"received_at"`
+	Reading    *model.SensorReading `json:"reading"`
+}
+
+// AdmissionMetrics holds Prometheus metrics for admission control
+type AdmissionMetrics struct {
+	Rejected *prometheus.CounterVec
+	Clamped  *prometheus.CounterVec
+	Admitted prometheus.Counter
+}
+
+// NewAdmissionMetrics creates a new set of admission metrics
+func NewAdmissionMetrics(namespace, subsystem string, registry prometheus.Registerer) *AdmissionMetrics {
+	metrics := &AdmissionMetrics{
+		Rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "admission_rejected_total",
+			Help:      "Total number of readings rejected by admission, by rule",
+		}, []string{"rule"}),
+		Clamped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "admission_clamped_total",
+			Help:      "Total number of reading values clamped by admission, by rule",
+		}, []string{"rule"}),
+		Admitted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "admission_admitted_total",
+			Help:      "Total number of readings admitted",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Rejected,
+		metrics.Clamped,
+		metrics.Admitted,
+	)
+
+	return metrics
+}
+
+// Admission applies admission rules to incoming readings
+type Admission struct {
+	config  AdmissionConfig
+	metrics *AdmissionMetrics
+	now     func() time.Time
+}
+
+// NewAdmission creates a new admission controller; metrics may be nil
+func NewAdmission(config AdmissionConfig, metrics *AdmissionMetrics) *Admission {
+	return &Admission{
+		config:  config,
+		metrics: metrics,
+		now:     time.Now,
+	}
+}
+
+// Admit checks a reading against the admission rules, clamping values in place
+// when configured. It returns nil when the reading is admitted.
+func (a *Admission) Admit(ctx context.Context, reading *model.SensorReading) (*Rejection, error) {
+	now := a.now()
+
+	reject := func(rule, reason string) (*Rejection, error) {
+		if a.metrics != nil {
+			a.metrics.Rejected.WithLabelValues(rule).Inc()
+		}
+		return &Rejection{
+			Rule:       rule,
+			Reason:     reason,
+			ReceivedAt: now.UnixMilli(),
+			Reading:    reading,
+		}, nil
+	}
+
+	if reading.ID == "" {
+		return reject(RuleMissingID, "reading has no sensor ID")
+	}
+
+	ts := time.UnixMilli(reading.Timestamp)
+	if a.config.MaxFutureSkew > 0 && ts.After(now.Add(a.config.MaxFutureSkew)) {
+		return reject(RuleTimestampFuture, fmt.Sprintf("timestamp %s is more than %s in the future", ts.UTC().Format(time.RFC3339), a.config.MaxFutureSkew))
+	}
+	if a.config.MaxPastAge > 0 && ts.Before(now.Add(-a.config.MaxPastAge)) {
+		return reject(RuleTimestampPast, fmt.Sprintf("timestamp %s is more than %s in the past", ts.UTC().Format(time.RFC3339), a.config.MaxPastAge))
+	}
+
+	if !isFinite(reading.Temperature) || !isFinite(reading.Humidity) {
+		return reject(RuleNonFinite, "temperature or humidity is not a finite number")
+	}
+
+	if reading.Temperature < MinPlausibleTemperature || reading.Temperature > MaxPlausibleTemperature {
+		if !a.config.ClampValues {
+			return reject(RuleTemperatureRange, fmt.Sprintf("temperature %.1f°C outside plausible range", reading.Temperature))
+		}
+		reading.Temperature = clamp(reading.Temperature, MinPlausibleTemperature, MaxPlausibleTemperature)
+		a.clamped(RuleTemperatureRange)
+	}
+
+	if reading.Humidity < MinPlausibleHumidity || reading.Humidity > MaxPlausibleHumidity {
+		if !a.config.ClampValues {
+			return reject(RuleHumidityRange, fmt.Sprintf("humidity %.1f%% outside plausible range", reading.Humidity))
+		}
+		reading.Humidity = clamp(reading.Humidity, MinPlausibleHumidity, MaxPlausibleHumidity)
+		a.clamped(RuleHumidityRange)
+	}
+
+	if a.config.Registry != nil {
+		registered, err := a.config.Registry.IsRegistered(ctx, reading.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check sensor registration: %w", err)
+		}
+		if !registered {
+			return reject(RuleUnregistered, fmt.Sprintf("sensor %s is not registered", reading.ID))
+		}
+	}
+
+	if a.metrics != nil {
+		a.metrics.Admitted.Inc()
+	}
+	return nil, nil
+}
+
+func (a *Admission) clamped(rule string) {
+	if a.metrics != nil {
+		a.metrics.Clamped.WithLabelValues(rule).Inc()
+	}
+}
+
+// SerializeRejection serializes a rejection for the rejects topic
+func SerializeRejection(rejection *Rejection) ([]byte, error) {
+	data, err := json.Marshal(rejection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rejection to JSON: %w", err)
+	}
+	return data, nil
+}
+
+func isFinite(v float32) bool {
+	f := float64(v)
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func clamp(v, lo, hi float32) float32 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// NewAdmissionConfig builds an admission configuration from the service config.
+// Registration checks use the static sensor list unless a registry is supplied later.
+func NewAdmissionConfig(cfg *config.Config) AdmissionConfig {
+	admission := AdmissionConfig{
+		MaxFutureSkew: cfg.AdmissionMaxFutureSkew,
+		MaxPastAge:    cfg.AdmissionMaxPastAge,
+		ClampValues:   cfg.AdmissionClampValues,
+	}
+
+	if cfg.AdmissionRequireRegistered {
+		registry := make(StaticSensorRegistry, len(cfg.AdmissionRegisteredSensors))
+		for _, id := range cfg.AdmissionRegisteredSensors {
+			registry[strings.TrimSpace(id)] = struct{}{}
+		}
+		admission.Registry = registry
+	}
+
+	return admission
+}
@@ -0,0 +1,327 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth bounds the nesting of decoded CBOR items
+const maxCBORDepth = 16
+
+// CBOR tags understood by the reading decoder
+const (
+	cborTagEpoch = 1 // epoch-based date/time in seconds
+)
+
+// errCBORTruncated is returned for items running past the end of the data
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// cborTag is a tagged CBOR item
+type cborTag struct {
+	Number uint64
+	Value  any
+}
+
+// cborBreak marks the end of an indefinite-length item
+type cborBreak struct{}
+
+// cborUndefined is the CBOR undefined value; it decodes like null
+type cborUndefined struct{}
+
+// decodeCBOR decodes one CBOR data item (RFC 8949) and reports an error if
+// data holds anything after it. Unsigned and negative integers decode as
+// uint64 and int64, floats as float64, byte strings as []byte, text as
+// string, arrays as []any, maps as map[any]any with integer or text keys,
+// tags as cborTag, null and undefined as nil.
+func decodeCBOR(data []byte) (any, error) {
+	d := cborDecoder{data: data}
+	value, err := d.item(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := value.(cborBreak); ok {
+		return nil, fmt.Errorf("cbor: unexpected break")
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("cbor: %d bytes after the data item", len(d.data)-d.pos)
+	}
+	return value, nil
+}
+
+// cborDecoder reads CBOR items from data
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// item decodes the item at the current position
+func (d *cborDecoder) item(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("cbor: nested deeper than %d", maxCBORDepth)
+	}
+	if d.pos >= len(d.data) {
+		return nil, errCBORTruncated
+	}
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	// Simple values and floats use the additional information differently
+	if major == 7 {
+		return d.simple(info)
+	}
+
+	if info == 31 {
+		return d.indefinite(major, depth)
+	}
+	arg, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		return arg, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: negative integer out of range")
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		raw, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == 2 {
+			return append([]byte(nil), raw...), nil
+		}
+		return string(raw), nil
+	case 4:
+		// Every item takes at least one byte, which bounds the allocation
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		array := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			value, err := d.definite(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos)/2 {
+			return nil, errCBORTruncated
+		}
+		m := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			if err := d.entry(m, depth); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	default: // 6
+		value, err := d.definite(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTag{Number: arg, Value: value}, nil
+	}
+}
+
+// definite decodes an item that may not be a break
+func (d *cborDecoder) definite(depth int) (any, error) {
+	value, err := d.item(depth)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := value.(cborBreak); ok {
+		return nil, fmt.Errorf("cbor: unexpected break")
+	}
+	return value, nil
+}
+
+// entry decodes a map key and its value into m
+func (d *cborDecoder) entry(m map[any]any, depth int) error {
+	key, err := d.definite(depth + 1)
+	if err != nil {
+		return err
+	}
+	switch k := key.(type) {
+	case uint64:
+		if k > math.MaxInt64 {
+			return fmt.Errorf("cbor: map key out of range")
+		}
+		key = int64(k)
+	case int64, string:
+	default:
+		return fmt.Errorf("cbor: unsupported map key type %T", key)
+	}
+	value, err := d.definite(depth + 1)
+	if err != nil {
+		return err
+	}
+	if _, ok := m[key]; ok {
+		return fmt.Errorf("cbor: duplicate map key %v", key)
+	}
+	m[key] = value
+	return nil
+}
+
+// indefinite decodes an indefinite-length string, array or map
+func (d *cborDecoder) indefinite(major byte, depth int) (any, error) {
+	switch major {
+	case 2, 3:
+		var chunks []byte
+		for {
+			chunk, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := chunk.(cborBreak); ok {
+				break
+			}
+			switch c := chunk.(type) {
+			case []byte:
+				if major != 2 {
+					return nil, fmt.Errorf("cbor: byte string chunk in text string")
+				}
+				chunks = append(chunks, c...)
+			case string:
+				if major != 3 {
+					return nil, fmt.Errorf("cbor: text string chunk in byte string")
+				}
+				chunks = append(chunks, c...)
+			default:
+				return nil, fmt.Errorf("cbor: invalid string chunk")
+			}
+		}
+		if major == 2 {
+			return chunks, nil
+		}
+		return string(chunks), nil
+	case 4:
+		var array []any
+		for {
+			value, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := value.(cborBreak); ok {
+				return array, nil
+			}
+			array = append(array, value)
+		}
+	case 5:
+		m := make(map[any]any)
+		for {
+			if d.pos < len(d.data) && d.data[d.pos] == 0xff {
+				d.pos++
+				return m, nil
+			}
+			if err := d.entry(m, depth); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cbor: indefinite length for major type %d", major)
+	}
+}
+
+// argument reads the argument of an item's initial byte
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		raw, err := d.bytes(uint64(size))
+		if err != nil {
+			return 0, err
+		}
+		switch size {
+		case 1:
+			return uint64(raw[0]), nil
+		case 2:
+			return uint64(binary.BigEndian.Uint16(raw)), nil
+		case 4:
+			return uint64(binary.BigEndian.Uint32(raw)), nil
+		default:
+			return binary.BigEndian.Uint64(raw), nil
+		}
+	default:
+		return 0, fmt.Errorf("cbor: reserved additional information %d", info)
+	}
+}
+
+// simple decodes the simple values and floats of major type 7
+func (d *cborDecoder) simple(info byte) (any, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22:
+		return nil, nil
+	case 23:
+		return cborUndefined{}, nil
+	case 25:
+		raw, err := d.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat(binary.BigEndian.Uint16(raw)), nil
+	case 26:
+		raw, err := d.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), nil
+	case 27:
+		raw, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+	case 31:
+		return cborBreak{}, nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+}
+
+// bytes returns the next n bytes
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBORTruncated
+	}
+	raw := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return raw, nil
+}
+
+// halfToFloat converts an IEEE 754 half-precision float
+func halfToFloat(half uint16) float64 {
+	exponent := int(half>>10) & 0x1f
+	mantissa := float64(half & 0x3ff)
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 31:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+1024, exponent-25)
+	}
+	if half&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,246 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Compact integer keys of CBOR readings, for devices that cannot spare the
+// bytes of the field names. Text keys use the JSON field names.
+const (
+	CBORKeyID          = 0
+	CBORKeyTimestamp   = 1
+	CBORKeyTemperature = 2
+	CBORKeyHumidity    = 3
+	CBORKeySite        = 4
+	CBORKeyBatteryPct  = 5
+	CBORKeyRSSI        = 6
+	CBORKeyZone        = 7
+	CBORKeyLocation    = 8
+)
+
+// cborFieldNames maps the compact keys to the JSON field names
+var cborFieldNames = map[int64]string{
+	CBORKeyID:          "id",
+	CBORKeyTimestamp:   "ts",
+	CBORKeyTemperature: "temperature",
+	CBORKeyHumidity:    "humidity",
+	CBORKeySite:        "site",
+	CBORKeyBatteryPct:  "battery_pct",
+	CBORKeyRSSI:        "rssi",
+	CBORKeyZone:        "zone",
+	CBORKeyLocation:    "location",
+}
+
+// DecodeCBORReadings decodes a CBOR reading, a map keyed by the JSON field
+// names or their compact integer keys, or an array of them. The timestamp is
+// Unix milliseconds, or a tag 1 epoch in seconds; readings without one are
+// stamped with receivedAt, for devices without a clock. Unknown keys are
+// ignored so devices can send fields newer than the service.
+func DecodeCBORReadings(payload []byte, receivedAt time.Time) ([]*model.SensorReading, error) {
+	item, err := decodeCBOR(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := item.([]any)
+	if !ok {
+		items = []any{item}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no readings")
+	}
+	readings := make([]*model.SensorReading, 0, len(items))
+	for i, item := range items {
+		fields, ok := item.(map[any]any)
+		if !ok {
+			return nil, fmt.Errorf("reading %d: expected a map, got %s", i, cborTypeName(item))
+		}
+		reading, err := cborReading(fields, receivedAt)
+		if err != nil {
+			return nil, fmt.Errorf("reading %d: %w", i, err)
+		}
+		readings = append(readings, reading)
+	}
+	return readings, nil
+}
+
+// cborReading converts the fields of one CBOR reading
+func cborReading(fields map[any]any, receivedAt time.Time) (*model.SensorReading, error) {
+	reading := &model.SensorReading{}
+	seen := make(map[string]bool, len(fields))
+	for key, value := range fields {
+		name, ok := key.(string)
+		if id, isInt := key.(int64); isInt {
+			name, ok = cborFieldNames[id]
+		}
+		if !ok {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s given twice", name)
+		}
+		seen[name] = true
+		if value == nil || value == (cborUndefined{}) {
+			continue
+		}
+
+		var err error
+		switch name {
+		case "id":
+			reading.ID, err = cborText(value)
+		case "ts":
+			reading.Timestamp, err = cborTimestamp(value)
+		case "temperature":
+			reading.Temperature, err = cborFloat32(value)
+		case "humidity":
+			reading.Humidity, err = cborFloat32(value)
+		case "site":
+			reading.Site, err = cborText(value)
+		case "zone":
+			reading.Zone, err = cborText(value)
+		case "battery_pct":
+			var battery float32
+			battery, err = cborFloat32(value)
+			reading.BatteryPct = &battery
+		case "rssi":
+			var rssi int64
+			rssi, err = cborInt(value)
+			if err == nil && (rssi < math.MinInt32 || rssi > math.MaxInt32) {
+				err = fmt.Errorf("out of range")
+			}
+			rssi32 := int32(rssi)
+			reading.RSSI = &rssi32
+		case "location":
+			reading.Location, err = cborLocation(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if !seen["temperature"] || !seen["humidity"] {
+		return nil, fmt.Errorf("temperature and humidity are required")
+	}
+	if reading.Timestamp == 0 {
+		reading.Timestamp = receivedAt.UnixMilli()
+	}
+	return reading, nil
+}
+
+// cborText returns a text string
+func cborText(value any) (string, error) {
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("expected text, got %s", cborTypeName(value))
+	}
+	return text, nil
+}
+
+// cborNumber returns an integer or float as float64
+func cborNumber(value any) (float64, error) {
+	switch v := value.(type) {
+	case uint64:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %s", cborTypeName(value))
+	}
+}
+
+// cborFloat32 returns a number as float32
+func cborFloat32(value any) (float32, error) {
+	f, err := cborNumber(value)
+	return float32(f), err
+}
+
+// cborInt returns an integer
+func cborInt(value any) (int64, error) {
+	switch v := value.(type) {
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("out of range")
+		}
+		return int64(v), nil
+	case int64:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %s", cborTypeName(value))
+	}
+}
+
+// cborTimestamp returns Unix milliseconds, or converts a tag 1 epoch in seconds
+func cborTimestamp(value any) (int64, error) {
+	if tag, ok := value.(cborTag); ok {
+		if tag.Number != cborTagEpoch {
+			return 0, fmt.Errorf("unsupported tag %d", tag.Number)
+		}
+		seconds, err := cborNumber(tag.Value)
+		if err != nil {
+			return 0, err
+		}
+		if math.IsNaN(seconds) || math.Abs(seconds) > math.MaxInt64/1000 {
+			return 0, fmt.Errorf("out of range")
+		}
+		return int64(math.Round(seconds * 1000)), nil
+	}
+	return cborInt(value)
+}
+
+// cborLocation returns a position given as {lat, lon} or [lat, lon]
+func cborLocation(value any) (*model.GeoPoint, error) {
+	var lat, lon any
+	switch v := value.(type) {
+	case []any:
+		if len(v) != 2 {
+			return nil, fmt.Errorf("expected [lat, lon]")
+		}
+		lat, lon = v[0], v[1]
+	case map[any]any:
+		lat, lon = v["lat"], v["lon"]
+	default:
+		return nil, fmt.Errorf("expected [lat, lon] or a map, got %s", cborTypeName(value))
+	}
+	latitude, err := cborNumber(lat)
+	if err != nil {
+		return nil, fmt.Errorf("lat: %w", err)
+	}
+	longitude, err := cborNumber(lon)
+	if err != nil {
+		return nil, fmt.Errorf("lon: %w", err)
+	}
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return nil, fmt.Errorf("%g,%g is not a WGS 84 position", latitude, longitude)
+	}
+	return &model.GeoPoint{Lat: latitude, Lon: longitude}, nil
+}
+
+// cborTypeName names the type of a decoded item in errors
+func cborTypeName(value any) string {
+	switch value.(type) {
+	case uint64, int64:
+		return "an integer"
+	case float64:
+		return "a float"
+	case string:
+		return "text"
+	case []byte:
+		return "a byte string"
+	case []any:
+		return "an array"
+	case map[any]any:
+		return "a map"
+	case bool:
+		return "a boolean"
+	case cborTag:
+		return "a tagged item"
+	default:
+		return "null"
+	}
+}
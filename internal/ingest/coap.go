@@ -0,0 +1,248 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CoAP message types (RFC 7252)
+const (
+	coapConfirmable     = 0
+	coapNonConfirmable  = 1
+	coapAcknowledgement = 2
+	coapReset           = 3
+)
+
+// CoAP codes, as class<<5 | detail
+const (
+	coapCodeEmpty              = 0x00
+	coapCodePOST               = 0x02
+	coapCodeChanged            = 2<<5 | 4
+	coapCodeBadRequest         = 4<<5 | 0
+	coapCodeNotFound           = 4<<5 | 4
+	coapCodeMethodNotAllowed   = 4<<5 | 5
+	coapCodeUnsupportedFormat  = 4<<5 | 15
+	coapCodeUnprocessable      = 4<<5 | 22
+	coapCodeServiceUnavailable = 5<<5 | 3
+)
+
+// CoAP option numbers
+const (
+	coapOptionURIHost       = 3
+	coapOptionURIPort       = 7
+	coapOptionURIPath       = 11
+	coapOptionContentFormat = 12
+	coapOptionMaxAge        = 14
+	coapOptionURIQuery      = 15
+)
+
+// coapFormatCBOR is the application/cbor content format
+const coapFormatCBOR = 60
+
+// coapPayloadMarker separates the options from the payload
+const coapPayloadMarker = 0xff
+
+// coapOption is one option of a CoAP message
+type coapOption struct {
+	Number int
+	Value  []byte
+}
+
+// coapMessage is a CoAP message over UDP
+type coapMessage struct {
+	Type      int
+	Code      byte
+	MessageID uint16
+	Token     []byte
+	Options   []coapOption
+	Payload   []byte
+}
+
+// coapCodeString formats a code as class.detail, e.g. 2.04
+func coapCodeString(code byte) string {
+	return fmt.Sprintf("%d.%02d", code>>5, code&0x1f)
+}
+
+// parseCoAP decodes a CoAP message
+func parseCoAP(data []byte) (*coapMessage, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("message shorter than the CoAP header")
+	}
+	if version := data[0] >> 6; version != 1 {
+		return nil, fmt.Errorf("unsupported CoAP version %d", version)
+	}
+	tokenLength := int(data[0] & 0x0f)
+	if tokenLength > 8 {
+		return nil, fmt.Errorf("invalid token length %d", tokenLength)
+	}
+	message := &coapMessage{
+		Type:      int(data[0]>>4) & 0x03,
+		Code:      data[1],
+		MessageID: binary.BigEndian.Uint16(data[2:4]),
+	}
+	pos := 4
+	if len(data) < pos+tokenLength {
+		return nil, fmt.Errorf("truncated token")
+	}
+	message.Token = data[pos : pos+tokenLength]
+	pos += tokenLength
+
+	number := 0
+	for pos < len(data) {
+		if data[pos] == coapPayloadMarker {
+			if pos+1 == len(data) {
+				return nil, fmt.Errorf("payload marker without payload")
+			}
+			message.Payload = data[pos+1:]
+			break
+		}
+		delta, length := int(data[pos]>>4), int(data[pos]&0x0f)
+		pos++
+		var err error
+		if delta, pos, err = coapOptionField(data, pos, delta); err != nil {
+			return nil, fmt.Errorf("option delta: %w", err)
+		}
+		if length, pos, err = coapOptionField(data, pos, length); err != nil {
+			return nil, fmt.Errorf("option length: %w", err)
+		}
+		if len(data) < pos+length {
+			return nil, fmt.Errorf("truncated option")
+		}
+		number += delta
+		message.Options = append(message.Options, coapOption{Number: number, Value: data[pos : pos+length]})
+		pos += length
+	}
+	return message, nil
+}
+
+// coapOptionField reads the extended form of an option delta or length
+func coapOptionField(data []byte, pos, nibble int) (int, int, error) {
+	switch nibble {
+	case 13:
+		if len(data) < pos+1 {
+			return 0, pos, fmt.Errorf("truncated")
+		}
+		return int(data[pos]) + 13, pos + 1, nil
+	case 14:
+		if len(data) < pos+2 {
+			return 0, pos, fmt.Errorf("truncated")
+		}
+		return int(binary.BigEndian.Uint16(data[pos:])) + 269, pos + 2, nil
+	case 15:
+		return 0, pos, fmt.Errorf("reserved value 15")
+	default:
+		return nibble, pos, nil
+	}
+}
+
+// encode encodes the message, sorting its options by number
+func (m *coapMessage) encode() []byte {
+	data := []byte{1<<6 | byte(m.Type)<<4 | byte(len(m.Token)), m.Code, byte(m.MessageID >> 8), byte(m.MessageID)}
+	data = append(data, m.Token...)
+
+	options := append([]coapOption(nil), m.Options...)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].Number < options[j].Number })
+	previous := 0
+	for _, option := range options {
+		delta, deltaExt := coapOptionNibble(option.Number - previous)
+		length, lengthExt := coapOptionNibble(len(option.Value))
+		data = append(data, byte(delta<<4|length))
+		data = append(data, deltaExt...)
+		data = append(data, lengthExt...)
+		data = append(data, option.Value...)
+		previous = option.Number
+	}
+	if len(m.Payload) > 0 {
+		data = append(data, coapPayloadMarker)
+		data = append(data, m.Payload...)
+	}
+	return data
+}
+
+// coapOptionNibble returns the nibble and extended bytes of an option delta
+// or length
+func coapOptionNibble(value int) (int, []byte) {
+	switch {
+	case value < 13:
+		return value, nil
+	case value < 269:
+		return 13, []byte{byte(value - 13)}
+	default:
+		value -= 269
+		return 14, []byte{byte(value >> 8), byte(value)}
+	}
+}
+
+// option returns the first value of an option
+func (m *coapMessage) option(number int) ([]byte, bool) {
+	for _, option := range m.Options {
+		if option.Number == number {
+			return option.Value, true
+		}
+	}
+	return nil, false
+}
+
+// path returns the Uri-Path options joined by slashes
+func (m *coapMessage) path() string {
+	var segments []string
+	for _, option := range m.Options {
+		if option.Number == coapOptionURIPath {
+			segments = append(segments, string(option.Value))
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// criticalOption returns an unrecognized critical option, which a server
+// must reject; critical options have odd numbers
+func (m *coapMessage) criticalOption() (int, bool) {
+	for _, option := range m.Options {
+		switch option.Number {
+		case coapOptionURIHost, coapOptionURIPort, coapOptionURIPath, coapOptionURIQuery:
+		default:
+			if option.Number&1 == 1 {
+				return option.Number, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// coapUint decodes an unsigned integer option value
+func coapUint(value []byte) uint32 {
+	var n uint32
+	for _, b := range value {
+		n = n<<8 | uint32(b)
+	}
+	return n
+}
+
+// coapUintValue encodes an unsigned integer option value in as few bytes as
+// possible
+func coapUintValue(n uint32) []byte {
+	var value []byte
+	for n > 0 {
+		value = append([]byte{byte(n)}, value...)
+		n >>= 8
+	}
+	return value
+}
+
+// response builds the response to a request: piggybacked on the
+// acknowledgement of a confirmable request, or non-confirmable otherwise
+func (m *coapMessage) response(code byte, options ...coapOption) *coapMessage {
+	response := &coapMessage{
+		Type:      coapNonConfirmable,
+		Code:      code,
+		MessageID: m.MessageID,
+		Token:     m.Token,
+		Options:   options,
+	}
+	if m.Type == coapConfirmable {
+		response.Type = coapAcknowledgement
+	}
+	return response
+}
@@ -0,0 +1,453 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CoAPReadingsPath is the resource devices POST readings to
+const CoAPReadingsPath = "readings"
+
+// coapExchangeLifetime is how long a message ID identifies an exchange, so
+// retransmissions are answered from the recorded response (EXCHANGE_LIFETIME
+// of RFC 7252 with the default transmission parameters)
+const coapExchangeLifetime = 247 * time.Second
+
+// coapForwardTimeout bounds forwarding the readings of a request: past
+// MAX_TRANSMIT_WAIT of RFC 7252 the device has given up waiting for the
+// response
+const coapForwardTimeout = 93 * time.Second
+
+// maxCoAPExchanges bounds the exchanges remembered for deduplication
+const maxCoAPExchanges = 100000
+
+// maxCoAPDatagram is the largest datagram read
+const maxCoAPDatagram = 65535
+
+// CoAPMetrics holds Prometheus metrics for the CoAP listener
+type CoAPMetrics struct {
+	Requests     *prometheus.CounterVec
+	Readings     *prometheus.CounterVec
+	Duplicates   prometheus.Counter
+	Malformed    prometheus.Counter
+	Throttled    prometheus.Counter
+	Inflight     prometheus.Gauge
+	HandlingTime prometheus.Histogram
+}
+
+// NewCoAPMetrics creates a new set of CoAP listener metrics
+func NewCoAPMetrics(namespace, subsystem string, registry prometheus.Registerer) *CoAPMetrics {
+	metrics := &CoAPMetrics{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Total number of CoAP requests answered, by response code",
+		}, []string{"code"}),
+		Readings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "readings_total",
+			Help:      "Total number of readings received, by outcome (forwarded, rejected or failed)",
+		}, []string{"outcome"}),
+		Duplicates: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "duplicate_messages_total",
+			Help:      "Total number of retransmitted messages answered without forwarding their readings again",
+		}),
+		Malformed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "malformed_messages_total",
+			Help:      "Total number of datagrams that are not valid CoAP messages",
+		}),
+		Throttled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "throttled_requests_total",
+			Help:      "Total number of requests answered 5.03 because the in-flight limit was reached",
+		}),
+		Inflight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "inflight_requests",
+			Help:      "Number of requests whose readings are being forwarded",
+		}),
+		HandlingTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "handling_time_seconds",
+			Help:      "Time from receiving a request to answering it, in seconds",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Requests,
+		metrics.Readings,
+		metrics.Duplicates,
+		metrics.Malformed,
+		metrics.Throttled,
+		metrics.Inflight,
+		metrics.HandlingTime,
+	)
+
+	return metrics
+}
+
+// CoAPConfig holds the settings of the CoAP listener
+type CoAPConfig struct {
+	// Addr is the UDP address to listen on
+	Addr string
+	// MaxInflight is the number of requests forwarded at once; further
+	// requests are answered 5.03 with a Max-Age of RetryAfter
+	MaxInflight int
+	RetryAfter  time.Duration
+	// ShutdownTimeout bounds draining the producer on Stop
+	ShutdownTimeout time.Duration
+	Metrics         *CoAPMetrics
+	Logger          *slog.Logger
+}
+
+// exchangeKey identifies a message by its sender and message ID
+type exchangeKey struct {
+	addr      string
+	messageID uint16
+}
+
+// exchange is the recorded response to a message; nil while it is handled
+type exchange struct {
+	response []byte
+	expires  time.Time
+}
+
+// CoAPServer accepts CBOR readings POSTed by constrained devices over CoAP
+// (RFC 7252) and forwards them to Kafka. A confirmable request is
+// acknowledged once its readings are sent, so a device retransmitting after
+// a lost or refused request delivers them at least once.
+type CoAPServer struct {
+	config    CoAPConfig
+	forwarder *Forwarder
+	logger    *slog.Logger
+	conn      net.PacketConn
+
+	// slots holds a token per request being forwarded
+	slots chan struct{}
+
+	mu        sync.Mutex
+	exchanges map[exchangeKey]*exchange
+
+	messageID atomic.Uint32
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	handlers sync.WaitGroup
+}
+
+// NewCoAPServer creates a listener forwarding with forwarder
+func NewCoAPServer(config CoAPConfig, forwarder *Forwarder) *CoAPServer {
+	if config.MaxInflight <= 0 {
+		config.MaxInflight = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CoAPServer{
+		config:    config,
+		forwarder: forwarder,
+		logger:    logging.OrDefault(config.Logger),
+		slots:     make(chan struct{}, config.MaxInflight),
+		exchanges: make(map[exchangeKey]*exchange),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// NewCoAPServerFromConfig creates the listener of COAP_LISTEN_ADDR and its
+// forwarder. Metrics are registered on registry.
+func NewCoAPServerFromConfig(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*CoAPServer, error) {
+	logger = logging.OrDefault(logger).With("component", "coap-ingest")
+	forwarder, err := NewForwarderFromConfig(cfg, "coap-ingest", registry, logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewCoAPServer(CoAPConfig{
+		Addr:            cfg.CoAPListenAddr,
+		MaxInflight:     cfg.CoAPMaxInflight,
+		RetryAfter:      cfg.CoAPRetryAfter,
+		ShutdownTimeout: cfg.ProducerShutdownTimeout,
+		Metrics:         NewCoAPMetrics("iot", "coap_ingest", registry),
+		Logger:          logger,
+	}, forwarder), nil
+}
+
+// Start listens on the configured address and serves in the background
+func (s *CoAPServer) Start() error {
+	conn, err := net.ListenPacket("udp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Addr, err)
+	}
+	s.conn = conn
+
+	s.wg.Add(2)
+	go s.serve()
+	go s.expire()
+	s.logger.Info("Listening for CoAP readings", "addr", conn.LocalAddr().String(), "path", "/"+CoAPReadingsPath)
+	return nil
+}
+
+// Addr returns the address listened on, once started
+func (s *CoAPServer) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Stop stops reading datagrams, cancels forwarding the requests in flight,
+// waits for them to be answered and drains the producer
+func (s *CoAPServer) Stop() {
+	s.cancel()
+	s.conn.SetReadDeadline(time.Now())
+	s.wg.Wait()
+	s.handlers.Wait()
+	s.conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := s.forwarder.Close(ctx); err != nil {
+		s.logger.Error("Failed to drain producer", "error", err)
+	}
+}
+
+// serve reads datagrams until Stop
+func (s *CoAPServer) serve() {
+	defer s.wg.Done()
+	buf := make([]byte, maxCoAPDatagram)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if s.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("Failed to read datagram", "error", err)
+			continue
+		}
+		s.handle(append([]byte(nil), buf[:n]...), addr)
+	}
+}
+
+// expire forgets exchanges past their lifetime
+func (s *CoAPServer) expire() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, exchange := range s.exchanges {
+				if exchange.response != nil && now.After(exchange.expires) {
+					delete(s.exchanges, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// handle answers one datagram. Cheap checks are answered inline; requests
+// carrying readings are forwarded in the background while a slot is free.
+func (s *CoAPServer) handle(data []byte, addr net.Addr) {
+	received := time.Now()
+	request, err := parseCoAP(data)
+	if err != nil {
+		s.malformed(data, addr, err)
+		return
+	}
+
+	switch {
+	case request.Type == coapAcknowledgement || request.Type == coapReset:
+		// This server sends no confirmable messages, so there is nothing to acknowledge
+		return
+	case request.Code == coapCodeEmpty || request.Code>>5 != 0:
+		// A confirmable empty message is a ping; responses are not expected
+		if request.Type == coapConfirmable {
+			s.write(&coapMessage{Type: coapReset, MessageID: request.MessageID}, addr)
+		}
+		return
+	}
+
+	key := exchangeKey{addr: addr.String(), messageID: request.MessageID}
+	if !s.begin(key, addr) {
+		return
+	}
+
+	respond := func(code byte, diagnostic string, options ...coapOption) {
+		response := request.response(code, options...)
+		response.Payload = []byte(diagnostic)
+		s.finish(key, response, addr, received)
+	}
+
+	if number, ok := request.criticalOption(); ok {
+		respond(coapCodeBadRequest, fmt.Sprintf("unsupported critical option %d", number))
+		return
+	}
+	if request.path() != CoAPReadingsPath {
+		respond(coapCodeNotFound, "readings are POSTed to /"+CoAPReadingsPath)
+		return
+	}
+	if request.Code != coapCodePOST {
+		respond(coapCodeMethodNotAllowed, "")
+		return
+	}
+	if format, ok := request.option(coapOptionContentFormat); ok && coapUint(format) != coapFormatCBOR {
+		respond(coapCodeUnsupportedFormat, "readings must be application/cbor")
+		return
+	}
+
+	// Refuse rather than queue once the producer is behind, so devices back off
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		if s.config.Metrics != nil {
+			s.config.Metrics.Throttled.Inc()
+		}
+		respond(coapCodeServiceUnavailable, "busy", s.retryAfter())
+		return
+	}
+
+	s.handlers.Add(1)
+	go func() {
+		defer s.handlers.Done()
+		defer func() { <-s.slots }()
+		if s.config.Metrics != nil {
+			s.config.Metrics.Inflight.Inc()
+			defer s.config.Metrics.Inflight.Dec()
+		}
+		code, diagnostic := s.forward(request.Payload, received)
+		if code == coapCodeServiceUnavailable {
+			respond(code, diagnostic, s.retryAfter())
+			return
+		}
+		respond(code, diagnostic)
+	}()
+}
+
+// forward decodes and forwards the readings of a request, returning the
+// response code and diagnostic
+func (s *CoAPServer) forward(payload []byte, received time.Time) (byte, string) {
+	readings, err := DecodeCBORReadings(payload, received)
+	if err != nil {
+		return coapCodeBadRequest, err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, coapForwardTimeout)
+	defer cancel()
+	result, err := s.forwarder.Forward(ctx, readings, received)
+	if s.config.Metrics != nil {
+		s.config.Metrics.Readings.WithLabelValues("forwarded").Add(float64(result.Admitted))
+		s.config.Metrics.Readings.WithLabelValues("rejected").Add(float64(len(result.Rejected)))
+	}
+	if err != nil {
+		if s.config.Metrics != nil {
+			s.config.Metrics.Readings.WithLabelValues("failed").Add(float64(len(readings) - result.Admitted - len(result.Rejected)))
+		}
+		s.logger.Warn("Failed to forward readings", "readings", len(readings), "forwarded", result.Admitted, "error", err)
+		if result.Processed > 0 {
+			// The device resends only the readings that were not sent
+			return coapCodeServiceUnavailable, fmt.Sprintf("forwarded the first %d of %d readings; resend the rest",
+				result.Processed, len(readings))
+		}
+		return coapCodeServiceUnavailable, "failed to forward readings"
+	}
+	if result.Admitted == 0 {
+		return coapCodeUnprocessable, result.Rejected[0].Reason
+	}
+	return coapCodeChanged, ""
+}
+
+// begin records the start of an exchange. For a retransmission it resends
+// the recorded response, or nothing while the original is handled, and
+// returns false.
+func (s *CoAPServer) begin(key exchangeKey, addr net.Addr) bool {
+	s.mu.Lock()
+	previous, ok := s.exchanges[key]
+	if !ok {
+		if len(s.exchanges) < maxCoAPExchanges {
+			s.exchanges[key] = &exchange{}
+		}
+		s.mu.Unlock()
+		return true
+	}
+	response := previous.response
+	s.mu.Unlock()
+
+	if s.config.Metrics != nil {
+		s.config.Metrics.Duplicates.Inc()
+	}
+	if response != nil {
+		s.writeRaw(response, addr)
+	}
+	return false
+}
+
+// finish records and sends the response of an exchange
+func (s *CoAPServer) finish(key exchangeKey, response *coapMessage, addr net.Addr, received time.Time) {
+	if response.Type == coapNonConfirmable {
+		response.MessageID = uint16(s.messageID.Add(1))
+	}
+	data := response.encode()
+
+	s.mu.Lock()
+	if exchange, ok := s.exchanges[key]; ok {
+		exchange.response = data
+		exchange.expires = time.Now().Add(coapExchangeLifetime)
+	}
+	s.mu.Unlock()
+
+	if s.config.Metrics != nil {
+		s.config.Metrics.Requests.WithLabelValues(coapCodeString(response.Code)).Inc()
+		s.config.Metrics.HandlingTime.Observe(time.Since(received).Seconds())
+	}
+	s.writeRaw(data, addr)
+}
+
+// malformed counts a datagram that is not a valid CoAP message and resets a
+// confirmable one, as RFC 7252 asks
+func (s *CoAPServer) malformed(data []byte, addr net.Addr, err error) {
+	if s.config.Metrics != nil {
+		s.config.Metrics.Malformed.Inc()
+	}
+	s.logger.Debug("Ignoring malformed CoAP message", "remote", addr.String(), "error", err)
+	if len(data) >= 4 && data[0]>>6 == 1 && int(data[0]>>4)&0x03 == coapConfirmable {
+		s.write(&coapMessage{Type: coapReset, MessageID: uint16(data[2])<<8 | uint16(data[3])}, addr)
+	}
+}
+
+// retryAfter returns the Max-Age option telling a device when to retry
+func (s *CoAPServer) retryAfter() coapOption {
+	seconds := uint32(s.config.RetryAfter.Round(time.Second) / time.Second)
+	return coapOption{Number: coapOptionMaxAge, Value: coapUintValue(seconds)}
+}
+
+// write sends a message to addr
+func (s *CoAPServer) write(message *coapMessage, addr net.Addr) {
+	s.writeRaw(message.encode(), addr)
+}
+
+// writeRaw sends an encoded message to addr
+func (s *CoAPServer) writeRaw(data []byte, addr net.Addr) {
+	if _, err := s.conn.WriteTo(data, addr); err != nil {
+		s.logger.Debug("Failed to send CoAP response", "remote", addr.String(), "error", err)
+	}
+}
@@ -0,0 +1,213 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	sensorregistry "github.com/example/iot-sensor-fleet/internal/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ForwarderConfig holds the settings of a forwarder
+type ForwarderConfig struct {
+	// Topic receives admitted readings and RejectsTopic the rejections
+	// (empty drops them after counting)
+	Topic        string
+	RejectsTopic string
+	// SensorTopics sends the readings of the sensors it lists to their
+	// topic instead of Topic, such as the priority topic (optional)
+	SensorTopics map[string]string
+	// Source names the ingest path in logs and the client ID header
+	Source     string
+	Admission  *Admission
+	Serializer *model.ReadingSerializer
+	Logger     *slog.Logger
+}
+
+// ForwardResult counts what became of the readings of one request
+type ForwardResult struct {
+	Admitted int
+	Rejected []*Rejection
+	// Processed is the number of leading readings sent or rejected; when
+	// Forward fails, the readings after them were not sent
+	Processed int
+}
+
+// Forwarder admits readings received from devices and produces them to the
+// raw topic in the fleet's payload format, so they are consumed exactly like
+// the simulator's
+type Forwarder struct {
+	config   ForwarderConfig
+	producer *kafka.Producer
+	logger   *slog.Logger
+
+	// partitionSites places readings by site with PARTITION_BY_SITE
+	partitionSites *sensorregistry.PartitionSiteCache
+}
+
+// NewForwarder creates a forwarder sending with producer
+func NewForwarder(config ForwarderConfig, producer *kafka.Producer) *Forwarder {
+	return &Forwarder{
+		config:   config,
+		producer: producer,
+		logger:   logging.OrDefault(config.Logger),
+	}
+}
+
+// NewForwarderFromConfig creates the producer and forwarder of an ingest
+// service named source, with the admission rules and SENSOR_FORMAT of
+// configuration. Metrics are registered on registry.
+func NewForwarderFromConfig(cfg *config.Config, source string, registry prometheus.Registerer, logger *slog.Logger) (*Forwarder, error) {
+	logger = logging.OrDefault(logger)
+
+	// Send readings to the partitions the site-affinity strategy gives their site
+	var partitionSites *sensorregistry.PartitionSiteCache
+	var sites kafka.PartitionSitesFunc
+	if cfg.PartitionBySite {
+		cache, err := sensorregistry.NewPartitionSiteCacheFromConfig(cfg, logger)
+		switch {
+		case err != nil:
+			logger.Warn("Readings will be partitioned without their sites", "error", err)
+		case cache == nil:
+			logger.Warn("PARTITION_BY_SITE needs PARTITION_SITE_REFRESH_INTERVAL; partitioning readings without their sites")
+		default:
+			cache.Start()
+			partitionSites, sites = cache, cache.Sites
+		}
+	}
+
+	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:         cfg.KafkaBrokers,
+		Topic:           cfg.Topic(config.TopicKeySensorRaw),
+		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+		Idempotent:      cfg.ProducerIdempotent,
+		ReturnSuccesses: cfg.ProducerReturnSuccess,
+		ReturnErrors:    cfg.ProducerReturnErrors,
+		Metrics:         kafka.NewProducerMetrics("iot", "kafka_producer", registry),
+		Version:         cfg.KafkaVersion,
+		SendTimeout:     cfg.ProducerSendTimeout,
+		HopHeaders:      cfg.HopHeaders,
+		ClientID:        "iot-" + source,
+		Security:        kafka.SecurityFromConfig(cfg),
+		Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentIngest),
+		Logger:          logger,
+		PartitionSites:  sites,
+
+		ClockDiagnostics: cfg.ClockDiagnostics,
+	})
+	if err != nil {
+		if partitionSites != nil {
+			partitionSites.Stop()
+		}
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+
+	// Without a registry Confluent framing falls back to JSON carrying its
+	// schema, as for the simulator
+	format := cfg.SensorFormat
+	if format == model.FormatConfluent && model.DefaultSchemaRegistry() == nil {
+		logger.Warn("SENSOR_FORMAT needs SCHEMA_REGISTRY_URL; sending JSON Schema framing instead", "format", format, "fallback", model.FormatJSONSchema)
+		format = model.FormatJSONSchema
+	}
+	serializer, err := model.NewReadingSerializer(format, model.DefaultSchemaRegistry(), cfg.Topic(config.TopicKeySensorRaw)+"-value")
+	if err != nil {
+		producer.Close()
+		if partitionSites != nil {
+			partitionSites.Stop()
+		}
+		return nil, fmt.Errorf("invalid SENSOR_FORMAT: %w", err)
+	}
+
+	forwarder := NewForwarder(ForwarderConfig{
+		Topic:        cfg.Topic(config.TopicKeySensorRaw),
+		RejectsTopic: cfg.Topic(config.TopicKeySensorReject),
+		SensorTopics: cfg.ReadingTopics(),
+		Source:       source,
+		Admission:    NewAdmission(NewAdmissionConfig(cfg), NewAdmissionMetrics("iot", "ingest", registry)),
+		Serializer:   serializer,
+		Logger:       logger,
+	}, producer)
+	forwarder.partitionSites = partitionSites
+	return forwarder, nil
+}
+
+// Forward admits each reading and sends the admitted ones to the topic of
+// their sensor, keyed by sensor ID and stamped with receivedAt, the time the
+// request arrived. Rejections go
+// to the rejects topic and are returned. Readings are handled in order, and
+// when one fails Forward returns an error with the number of readings
+// handled before it, so callers can have clients resend only the rest.
+func (f *Forwarder) Forward(ctx context.Context, readings []*model.SensorReading, receivedAt time.Time) (ForwardResult, error) {
+	var result ForwardResult
+	for _, reading := range readings {
+		if f.config.Admission != nil {
+			rejection, err := f.config.Admission.Admit(ctx, reading)
+			if err != nil {
+				return result, err
+			}
+			if rejection != nil {
+				result.Rejected = append(result.Rejected, rejection)
+				f.reject(ctx, rejection)
+				result.Processed++
+				continue
+			}
+		}
+
+		data, err := f.config.Serializer.Serialize(reading)
+		if err != nil {
+			return result, fmt.Errorf("failed to serialize reading of %s: %w", reading.ID, err)
+		}
+		headers := []sarama.RecordHeader{
+			kafka.TraceIDHeader(kafka.NewTraceID()),
+			kafka.SchemaVersionHeader(model.SchemaVersion),
+			kafka.FormatHeader(f.config.Serializer.Format()),
+			kafka.ReceivedAtHeader(receivedAt),
+		}
+		if reading.Site != "" {
+			headers = append(headers, kafka.SiteHeader(reading.Site))
+		}
+		topic := f.config.Topic
+		if sensorTopic, ok := f.config.SensorTopics[reading.ID]; ok {
+			topic = sensorTopic
+		}
+		if err := f.producer.SendMessageToTopic(ctx, topic, []byte(reading.ID), data, headers...); err != nil {
+			return result, fmt.Errorf("failed to send reading of %s: %w", reading.ID, err)
+		}
+		result.Admitted++
+		result.Processed++
+	}
+	return result, nil
+}
+
+// reject publishes a rejection; failing to is logged, as the reading is not
+// admitted either way
+func (f *Forwarder) reject(ctx context.Context, rejection *Rejection) {
+	f.logger.Debug("Rejected reading", "source", f.config.Source, "sensor_id", rejection.Reading.ID, "rule", rejection.Rule, "reason", rejection.Reason)
+	if f.config.RejectsTopic == "" {
+		return
+	}
+	data, err := SerializeRejection(rejection)
+	if err == nil {
+		err = f.producer.SendMessageToTopic(ctx, f.config.RejectsTopic, []byte(rejection.Reading.ID), data)
+	}
+	if err != nil {
+		f.logger.Warn("Failed to publish rejection", "source", f.config.Source, "sensor_id", rejection.Reading.ID, "error", err)
+	}
+}
+
+// Close drains the readings being sent, for up to the producer's shutdown
+// timeout when ctx has none, and closes the producer
+func (f *Forwarder) Close(ctx context.Context) error {
+	err := f.producer.GracefulShutdown(ctx)
+	if f.partitionSites != nil {
+		f.partitionSites.Stop()
+	}
+	return err
+}
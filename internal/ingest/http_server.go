@@ -0,0 +1,330 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HTTPReadingsPath is the endpoint gateways POST readings to
+const HTTPReadingsPath = "/v1/readings"
+
+// Idempotency stores
+const (
+	IdempotencyStoreMemory   = "memory"
+	IdempotencyStorePostgres = "postgres"
+)
+
+// HTTPMetrics holds Prometheus metrics for the HTTP ingest gateway
+type HTTPMetrics struct {
+	Requests     *prometheus.CounterVec
+	Readings     *prometheus.CounterVec
+	BatchSize    prometheus.Histogram
+	HandlingTime prometheus.Histogram
+}
+
+// NewHTTPMetrics creates a new set of HTTP ingest metrics
+func NewHTTPMetrics(namespace, subsystem string, registry prometheus.Registerer) *HTTPMetrics {
+	metrics := &HTTPMetrics{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Total number of ingest requests answered, by status code",
+		}, []string{"code"}),
+		Readings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "readings_total",
+			Help:      "Total number of readings received, by outcome (forwarded, rejected, invalid or failed)",
+		}, []string{"outcome"}),
+		BatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "batch_size",
+			Help:      "Number of readings in each valid request",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 7),
+		}),
+		HandlingTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "handling_time_seconds",
+			Help:      "Time from receiving a request to answering it, in seconds",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Requests,
+		metrics.Readings,
+		metrics.BatchSize,
+		metrics.HandlingTime,
+	)
+
+	return metrics
+}
+
+// HTTPConfig holds the settings of the HTTP ingest gateway
+type HTTPConfig struct {
+	// Addr is the TCP address to listen on
+	Addr string
+	// MaxBatch bounds the readings of one request and MaxBodyBytes its size
+	MaxBatch     int
+	MaxBodyBytes int64
+	// Idempotency replays the response of a request retried with the same
+	// Idempotency-Key for IdempotencyTTL; nil disables replays
+	Idempotency        IdempotencyStore
+	IdempotencyTTL     time.Duration
+	IdempotencyMetrics *IdempotencyMetrics
+	// ShutdownTimeout bounds finishing requests and draining the producer on Stop
+	ShutdownTimeout time.Duration
+	Metrics         *HTTPMetrics
+	Logger          *slog.Logger
+}
+
+// RejectedReading is a reading of a request refused by admission
+type RejectedReading struct {
+	Index    int    `json:"index"`
+	SensorID string `json:"sensor_id"`
+	Rule     string `json:"rule"`
+	Reason   string `json:"reason"`
+}
+
+// IngestResponse is the body of an answered ingest request
+type IngestResponse struct {
+	Accepted int               `json:"accepted"`
+	Rejected []RejectedReading `json:"rejected"`
+}
+
+// ingestError is the body of a refused ingest request
+type ingestError struct {
+	Error    string         `json:"error"`
+	Readings []ReadingError `json:"readings,omitempty"`
+}
+
+// HTTPServer accepts JSON readings POSTed by gateways that cannot produce to
+// Kafka and forwards them like the CoAP listener. A request is answered once
+// its readings are sent, so a gateway retrying a failed request delivers them
+// at least once, and exactly once when it sends an Idempotency-Key.
+type HTTPServer struct {
+	config    HTTPConfig
+	forwarder *Forwarder
+	server    *http.Server
+	listener  net.Listener
+	logger    *slog.Logger
+
+	// closeStore releases the idempotency store's connections, if any
+	closeStore func() error
+}
+
+// NewHTTPServer creates a gateway forwarding with forwarder
+func NewHTTPServer(config HTTPConfig, forwarder *Forwarder) *HTTPServer {
+	s := &HTTPServer{
+		config:    config,
+		forwarder: forwarder,
+		logger:    logging.OrDefault(config.Logger),
+	}
+
+	var handler http.Handler = http.HandlerFunc(s.handleReadings)
+	if config.Idempotency != nil {
+		handler = Idempotency(config.Idempotency, config.IdempotencyTTL, config.IdempotencyMetrics, s.logger, handler)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST "+HTTPReadingsPath, handler)
+	s.server = &http.Server{
+		Addr:              config.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// NewHTTPServerFromConfig creates the gateway of HTTP_INGEST_PORT, its
+// forwarder and the idempotency store of IDEMPOTENCY_STORE. Metrics are
+// registered on registry.
+func NewHTTPServerFromConfig(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*HTTPServer, error) {
+	logger = logging.OrDefault(logger).With("component", "http-ingest")
+
+	var store IdempotencyStore
+	closeStore := func() error { return nil }
+	switch cfg.IdempotencyStore {
+	case IdempotencyStoreMemory:
+		store = NewMemoryIdempotencyStore()
+	case IdempotencyStorePostgres:
+		postgres, err := db.NewPostgresDB(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect idempotency database: %w", err)
+		}
+		store = NewPostgresIdempotencyStore(postgres.DB())
+		closeStore = postgres.Close
+	default:
+		return nil, fmt.Errorf("unknown idempotency store %q (expected %s or %s)",
+			cfg.IdempotencyStore, IdempotencyStoreMemory, IdempotencyStorePostgres)
+	}
+
+	forwarder, err := NewForwarderFromConfig(cfg, "http-ingest", registry, logger)
+	if err != nil {
+		closeStore()
+		return nil, err
+	}
+
+	server := NewHTTPServer(HTTPConfig{
+		Addr:               fmt.Sprintf(":%d", cfg.HTTPIngestPort),
+		MaxBatch:           cfg.HTTPIngestMaxBatch,
+		MaxBodyBytes:       cfg.HTTPIngestMaxBodyBytes,
+		Idempotency:        store,
+		IdempotencyTTL:     cfg.IdempotencyTTL,
+		IdempotencyMetrics: NewIdempotencyMetrics("iot", "http_ingest", registry),
+		ShutdownTimeout:    cfg.ProducerShutdownTimeout,
+		Metrics:            NewHTTPMetrics("iot", "http_ingest", registry),
+		Logger:             logger,
+	}, forwarder)
+	server.closeStore = closeStore
+	return server, nil
+}
+
+// Start listens on the configured address and serves in the background
+func (s *HTTPServer) Start() error {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Addr, err)
+	}
+	s.listener = listener
+	s.logger.Info("Accepting JSON readings over HTTP", "addr", listener.Addr().String(), "path", HTTPReadingsPath)
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(s.logger, "Error serving HTTP ingest", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address listened on, once started
+func (s *HTTPServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stop finishes the requests being handled, drains the producer and closes
+// the idempotency store
+func (s *HTTPServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.logger.Error("Failed to shut down HTTP ingest", "error", err)
+	}
+	if err := s.forwarder.Close(ctx); err != nil {
+		s.logger.Error("Failed to drain producer", "error", err)
+	}
+	if s.closeStore != nil {
+		if err := s.closeStore(); err != nil {
+			s.logger.Error("Failed to close idempotency store", "error", err)
+		}
+	}
+}
+
+// handleReadings validates the readings of a request and forwards them
+func (s *HTTPServer) handleReadings(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	if s.config.Metrics != nil {
+		defer func() { s.config.Metrics.HandlingTime.Observe(time.Since(received).Seconds()) }()
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		s.respond(w, http.StatusUnsupportedMediaType, ingestError{Error: "Content-Type must be application/json"})
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.respond(w, http.StatusRequestEntityTooLarge, ingestError{Error: fmt.Sprintf("body exceeds %d bytes", tooLarge.Limit)})
+			return
+		}
+		s.respond(w, http.StatusBadRequest, ingestError{Error: "failed to read body"})
+		return
+	}
+
+	readings, err := DecodeJSONReadings(body, s.config.MaxBatch, received)
+	if err != nil {
+		response := ingestError{Error: err.Error()}
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			response.Readings = invalid.Readings
+			s.count("invalid", len(invalid.Readings))
+		}
+		s.respond(w, http.StatusBadRequest, response)
+		return
+	}
+	if s.config.Metrics != nil {
+		s.config.Metrics.BatchSize.Observe(float64(len(readings)))
+	}
+
+	result, err := s.forwarder.Forward(r.Context(), readings, received)
+	s.count("forwarded", result.Admitted)
+	s.count("rejected", len(result.Rejected))
+	if err != nil {
+		s.count("failed", len(readings)-result.Admitted-len(result.Rejected))
+		s.logger.Warn("Failed to forward readings", "readings", len(readings), "forwarded", result.Admitted, "error", err)
+		w.Header().Set("Retry-After", "1")
+		s.respond(w, http.StatusServiceUnavailable, ingestError{Error: "failed to forward readings"})
+		return
+	}
+
+	response := IngestResponse{Accepted: result.Admitted, Rejected: rejectedReadings(readings, result.Rejected)}
+	if result.Admitted == 0 {
+		s.respond(w, http.StatusUnprocessableEntity, response)
+		return
+	}
+	s.respond(w, http.StatusAccepted, response)
+}
+
+// rejectedReadings locates the rejections in the request by their reading
+func rejectedReadings(readings []*model.SensorReading, rejections []*Rejection) []RejectedReading {
+	index := make(map[*model.SensorReading]int, len(readings))
+	for i, reading := range readings {
+		index[reading] = i
+	}
+	rejected := make([]RejectedReading, 0, len(rejections))
+	for _, rejection := range rejections {
+		rejected = append(rejected, RejectedReading{
+			Index:    index[rejection.Reading],
+			SensorID: rejection.Reading.ID,
+			Rule:     rejection.Rule,
+			Reason:   rejection.Reason,
+		})
+	}
+	return rejected
+}
+
+// count records readings by outcome
+func (s *HTTPServer) count(outcome string, n int) {
+	if s.config.Metrics != nil && n > 0 {
+		s.config.Metrics.Readings.WithLabelValues(outcome).Add(float64(n))
+	}
+}
+
+// respond writes a JSON response and counts it
+func (s *HTTPServer) respond(w http.ResponseWriter, status int, body any) {
+	if s.config.Metrics != nil {
+		s.config.Metrics.Requests.WithLabelValues(strconv.Itoa(status)).Inc()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.logger.Warn("Failed to encode response", "error", err)
+	}
+}
@@ -0,0 +1,363 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HeaderIdempotencyKey is the request header carrying the client's idempotency key
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the size of accepted keys
+const maxIdempotencyKeyLength = 255
+
+// idempotencyLease is how long a key stays reserved for a request without
+// being renewed, so the key of a gateway that died mid-request frees up soon
+const idempotencyLease = 30 * time.Second
+
+// ErrKeyInProgress is returned when a request with the same key is still being processed
+var ErrKeyInProgress = errors.New("request with this idempotency key is in progress")
+
+// IdempotencyRecord is the stored outcome of a request
+type IdempotencyRecord struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyStore persists request outcomes by idempotency key
+type IdempotencyStore interface {
+	// Reserve claims a key for lease. It returns the stored record if the key
+	// has already completed, ErrKeyInProgress if another request holds it, or
+	// nil, nil when the caller now owns the key.
+	Reserve(ctx context.Context, key string, lease time.Duration) (*IdempotencyRecord, error)
+	// Renew extends the reservation of a key still in progress by lease
+	Renew(ctx context.Context, key string, lease time.Duration) error
+	// Complete stores the outcome for a reserved key
+	Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Release drops a reservation so the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// IdempotencyMetrics holds Prometheus metrics for idempotency handling
+type IdempotencyMetrics struct {
+	Requests *prometheus.CounterVec
+}
+
+// NewIdempotencyMetrics creates a new set of idempotency metrics
+func NewIdempotencyMetrics(namespace, subsystem string, registry prometheus.Registerer) *IdempotencyMetrics {
+	metrics := &IdempotencyMetrics{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "idempotency_requests_total",
+			Help:      "Total number of requests carrying an idempotency key, by outcome",
+		}, []string{"outcome"}),
+	}
+
+	registry.MustRegister(metrics.Requests)
+
+	return metrics
+}
+
+// Idempotency wraps a handler so that repeated requests with the same
+// Idempotency-Key replay the stored response instead of being processed again.
+// Requests without the header pass through untouched; server errors are not
+// stored so the client can retry them. A key is reserved with a short lease
+// renewed while the request runs and released if the handler panics.
+// metrics and logger may be nil.
+func Idempotency(store IdempotencyStore, ttl time.Duration, metrics *IdempotencyMetrics, logger *slog.Logger, next http.Handler) http.Handler {
+	logger = logging.OrDefault(logger)
+	record := func(outcome string) {
+		if metrics != nil {
+			metrics.Requests.WithLabelValues(outcome).Inc()
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderIdempotencyKey)
+		if key == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		// Scope keys by path so the same key can't collide across endpoints
+		scopedKey := r.URL.Path + ":" + key
+
+		stored, err := store.Reserve(r.Context(), scopedKey, idempotencyLease)
+		switch {
+		case errors.Is(err, ErrKeyInProgress):
+			record("conflict")
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			record("error")
+			logger.Error("Failed to reserve idempotency key", "error", err)
+			http.Error(w, "idempotency store unavailable", http.StatusServiceUnavailable)
+			return
+		case stored != nil:
+			record("replayed")
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.Body)
+			return
+		}
+
+		record("processed")
+		stopRenewing := renewLease(store, scopedKey, logger)
+		release := func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := store.Release(ctx, scopedKey); err != nil {
+				logger.Error("Failed to release idempotency key", "error", err)
+			}
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				stopRenewing()
+				release()
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		stopRenewing()
+
+		if recorder.statusCode >= 500 {
+			release()
+			return
+		}
+
+		// Use a fresh context: the request context may already be cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := store.Complete(ctx, scopedKey, &IdempotencyRecord{
+			StatusCode:  recorder.statusCode,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		}, ttl); err != nil {
+			logger.Error("Failed to store idempotent response", "error", err)
+		}
+	})
+}
+
+// renewLease renews the reservation of key every third of a lease until the
+// returned function is called, which waits for a renewal in progress
+func renewLease(store IdempotencyStore, key string, logger *slog.Logger) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(idempotencyLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), idempotencyLease/3)
+				if err := store.Renew(ctx, key, idempotencyLease); err != nil {
+					logger.Warn("Failed to renew idempotency key", "error", err)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// responseRecorder captures a response while writing it through
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// MemoryIdempotencyStore is an in-process IdempotencyStore, suitable for a single gateway replica
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	record    *IdempotencyRecord
+	expiresAt time.Time
+}
+
+// NewMemoryIdempotencyStore creates a new in-memory store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]memoryEntry)}
+}
+
+// Reserve implements IdempotencyStore
+func (m *MemoryIdempotencyStore) Reserve(ctx context.Context, key string, lease time.Duration) (*IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := m.entries[key]; ok && now.Before(entry.expiresAt) {
+		if entry.record == nil {
+			return nil, ErrKeyInProgress
+		}
+		return entry.record, nil
+	}
+
+	m.entries[key] = memoryEntry{expiresAt: now.Add(lease)}
+
+	// Opportunistically evict expired entries
+	for k, entry := range m.entries {
+		if now.After(entry.expiresAt) {
+			delete(m.entries, k)
+		}
+	}
+	return nil, nil
+}
+
+// Renew implements IdempotencyStore
+func (m *MemoryIdempotencyStore) Renew(ctx context.Context, key string, lease time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.entries[key]; ok && entry.record == nil {
+		m.entries[key] = memoryEntry{expiresAt: time.Now().Add(lease)}
+	}
+	return nil
+}
+
+// Complete implements IdempotencyStore
+func (m *MemoryIdempotencyStore) Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = memoryEntry{record: record, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (m *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// PostgresIdempotencyStore is an IdempotencyStore shared by all gateway replicas.
+// It uses the ingest_idempotency_keys table created by PostgresDB.InitTables.
+type PostgresIdempotencyStore struct {
+	db *sql.DB
+}
+
+// NewPostgresIdempotencyStore creates a new PostgreSQL-backed store
+func NewPostgresIdempotencyStore(db *sql.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{db: db}
+}
+
+// Reserve implements IdempotencyStore
+func (p *PostgresIdempotencyStore) Reserve(ctx context.Context, key string, lease time.Duration) (*IdempotencyRecord, error) {
+	// Drop an expired entry for this key so it can be reserved again
+	if _, err := p.db.ExecContext(ctx,
+		`DELETE FROM ingest_idempotency_keys WHERE key = $1 AND expires_at < NOW()`, key); err != nil {
+		return nil, fmt.Errorf("failed to purge expired idempotency key: %w", err)
+	}
+
+	result, err := p.db.ExecContext(ctx, `
+		INSERT INTO ingest_idempotency_keys (key, expires_at)
+		VALUES ($1, NOW() + $2 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO NOTHING
+	`, key, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 1 {
+		return nil, nil
+	}
+
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	err = p.db.QueryRowContext(ctx,
+		`SELECT status_code, content_type, body FROM ingest_idempotency_keys WHERE key = $1`, key,
+	).Scan(&statusCode, &contentType, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+	if !statusCode.Valid {
+		return nil, ErrKeyInProgress
+	}
+
+	return &IdempotencyRecord{
+		StatusCode:  int(statusCode.Int64),
+		ContentType: contentType.String,
+		Body:        body,
+	}, nil
+}
+
+// Renew implements IdempotencyStore
+func (p *PostgresIdempotencyStore) Renew(ctx context.Context, key string, lease time.Duration) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE ingest_idempotency_keys SET expires_at = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE key = $1 AND status_code IS NULL
+	`, key, lease.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to renew idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Complete implements IdempotencyStore
+func (p *PostgresIdempotencyStore) Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE ingest_idempotency_keys
+		SET status_code = $2, content_type = $3, body = $4, expires_at = NOW() + $5 * INTERVAL '1 millisecond'
+		WHERE key = $1
+	`, key, record.StatusCode, record.ContentType, record.Body, ttl.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (p *PostgresIdempotencyStore) Release(ctx context.Context, key string) error {
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM ingest_idempotency_keys WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
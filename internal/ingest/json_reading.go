@@ -0,0 +1,149 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// ReadingError says why one reading of a request failed validation
+type ReadingError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// ValidationError lists the readings of a request that failed validation
+type ValidationError struct {
+	Readings []ReadingError
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Readings) == 1 {
+		return fmt.Sprintf("reading %d: %s", e.Readings[0].Index, e.Readings[0].Error)
+	}
+	return fmt.Sprintf("%d invalid readings, first: reading %d: %s", len(e.Readings), e.Readings[0].Index, e.Readings[0].Error)
+}
+
+// jsonReading is the wire form of a JSON reading, with pointers to tell
+// missing fields from zero values
+type jsonReading struct {
+	ID          *string         `json:"id"`
+	Timestamp   *int64          `json:"ts"`
+	Temperature *float32        `json:"temperature"`
+	Humidity    *float32        `json:"humidity"`
+	Site        string          `json:"site"`
+	BatteryPct  *float32        `json:"battery_pct"`
+	RSSI        *int32          `json:"rssi"`
+	Zone        string          `json:"zone"`
+	Location    *model.GeoPoint `json:"location"`
+}
+
+// DecodeJSONReadings decodes a JSON reading, an object with the fields of
+// model.SensorReading, or an array of up to maxBatch of them (0 means no
+// limit). Each reading is checked against the reading schema: temperature
+// and humidity are required numbers, ts is Unix milliseconds and location a
+// WGS 84 position. Readings without ts are stamped with receivedAt. Unknown
+// fields are ignored so gateways can send fields newer than the service.
+// Invalid readings are returned together in a *ValidationError.
+func DecodeJSONReadings(body []byte, maxBatch int, receivedAt time.Time) ([]*model.SensorReading, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, fmt.Errorf("no readings")
+	}
+
+	var items []json.RawMessage
+	if body[0] == '[' {
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		if len(items) == 0 {
+			return nil, fmt.Errorf("no readings")
+		}
+		if maxBatch > 0 && len(items) > maxBatch {
+			return nil, fmt.Errorf("%d readings exceed the batch limit of %d", len(items), maxBatch)
+		}
+	} else {
+		if !json.Valid(body) {
+			return nil, fmt.Errorf("invalid JSON")
+		}
+		items = []json.RawMessage{body}
+	}
+
+	readings := make([]*model.SensorReading, 0, len(items))
+	var invalid []ReadingError
+	for i, item := range items {
+		reading, err := jsonSensorReading(item, receivedAt)
+		if err != nil {
+			invalid = append(invalid, ReadingError{Index: i, Error: err.Error()})
+			continue
+		}
+		readings = append(readings, reading)
+	}
+	if len(invalid) > 0 {
+		return nil, &ValidationError{Readings: invalid}
+	}
+	return readings, nil
+}
+
+// jsonSensorReading validates and converts one JSON reading
+func jsonSensorReading(item json.RawMessage, receivedAt time.Time) (*model.SensorReading, error) {
+	if len(item) == 0 || item[0] != '{' {
+		return nil, fmt.Errorf("expected an object")
+	}
+	var wire jsonReading
+	if err := json.Unmarshal(item, &wire); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return nil, fmt.Errorf("%s: expected %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind().String()), typeErr.Value)
+		}
+		return nil, err
+	}
+
+	if wire.Temperature == nil {
+		return nil, fmt.Errorf("temperature is required")
+	}
+	if wire.Humidity == nil {
+		return nil, fmt.Errorf("humidity is required")
+	}
+	if wire.Location != nil && (wire.Location.Lat < -90 || wire.Location.Lat > 90 || wire.Location.Lon < -180 || wire.Location.Lon > 180) {
+		return nil, fmt.Errorf("location: %g,%g is not a WGS 84 position", wire.Location.Lat, wire.Location.Lon)
+	}
+
+	reading := &model.SensorReading{
+		Timestamp:   receivedAt.UnixMilli(),
+		Temperature: *wire.Temperature,
+		Humidity:    *wire.Humidity,
+		Site:        wire.Site,
+		BatteryPct:  wire.BatteryPct,
+		RSSI:        wire.RSSI,
+		Zone:        wire.Zone,
+		Location:    wire.Location,
+	}
+	if wire.ID != nil {
+		reading.ID = *wire.ID
+	}
+	if wire.Timestamp != nil {
+		reading.Timestamp = *wire.Timestamp
+	}
+	return reading, nil
+}
+
+// jsonTypeName names a Go kind as the JSON type expected
+func jsonTypeName(kind string) string {
+	switch kind {
+	case "string":
+		return "a string"
+	case "float32", "float64":
+		return "a number"
+	case "int32", "int64":
+		return "an integer"
+	case "struct":
+		return "an object"
+	default:
+		return kind
+	}
+}
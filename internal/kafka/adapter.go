@@ -2,17 +2,42 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/logging"
 	"github.com/prometheus/client_golang/prometheus"
-	"log"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrProducerClosed is returned for messages sent after shutdown has begun
+var ErrProducerClosed = errors.New("producer is shutting down")
+
 // Producer is a wrapper around IPublisher that provides the same API as internal/kafka.Producer
 type Producer struct {
-	publisher IPublisher
-	topic     string
-	metrics   *ProducerMetrics
+	publisher   IPublisher
+	topic       string
+	metrics     *ProducerMetrics
+	sendTimeout time.Duration
+	logger      *slog.Logger
+
+	// mu guards closing so no send starts once shutdown has begun
+	mu      sync.Mutex
+	closing bool
+	// inflight tracks sends that have started and not yet returned
+	inflight sync.WaitGroup
+	// abort cancels in-flight sends when shutdown runs out of time
+	abort    context.Context
+	cancel   context.CancelFunc
+	rejected atomic.Int64
+	failed   atomic.Int64
+
+	// messageLimit and byteLimit cap the send rate; nil is unlimited
+	messageLimit *tokenBucket
+	byteLimit    *tokenBucket
 }
 
 // ProducerMetrics holds Prometheus metrics for the producer
@@ -21,7 +46,10 @@ type ProducerMetrics struct {
 	BytesSent      prometheus.Counter
 	ErrorsTotal    prometheus.Counter
 	MessageLatency prometheus.Histogram
+	Dropped        prometheus.Counter
+	Throttled      prometheus.Counter
 	registry       prometheus.Registerer
+	subsystem      string
 }
 
 // NewProducerMetrics creates a new set of producer metrics
@@ -52,7 +80,20 @@ func NewProducerMetrics(namespace, subsystem string, registry prometheus.Registe
 			Help:      "Latency of message production in seconds",
 			Buckets:   prometheus.DefBuckets,
 		}),
-		registry: registry,
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_dropped_total",
+			Help:      "Total number of messages rejected or abandoned during shutdown",
+		}),
+		Throttled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "throttled_seconds_total",
+			Help:      "Total time sends waited for the producer's rate limits in seconds",
+		}),
+		registry:  registry,
+		subsystem: subsystem,
 	}
 
 	registry.MustRegister(
@@ -60,6 +101,8 @@ func NewProducerMetrics(namespace, subsystem string, registry prometheus.Registe
 		metrics.BytesSent,
 		metrics.ErrorsTotal,
 		metrics.MessageLatency,
+		metrics.Dropped,
+		metrics.Throttled,
 	)
 
 	return metrics
@@ -67,71 +110,172 @@ func NewProducerMetrics(namespace, subsystem string, registry prometheus.Registe
 
 // ProducerConfig holds configuration for the producer
 type ProducerConfig struct {
-	Brokers         []string
+	Brokers []string
+	// Topic is the topic of SendMessage; it may be empty for a producer that
+	// only sends with SendMessageToTopic
 	Topic           string
 	RequiredAcks    sarama.RequiredAcks
 	ReturnSuccesses bool
 	ReturnErrors    bool
 	Metrics         *ProducerMetrics
 	Version         string
+
+	// ClockDiagnostics embeds send timestamps in message headers
+	ClockDiagnostics bool
+
+	// HopHeaders records the pipeline stages of every message in hop headers,
+	// including the hops of the message being handled (see ContextWithHops)
+	HopHeaders bool
+
+	// ClientID names the producer to the brokers and in the client ID header
+	// of every message that has none yet (empty keeps sarama's default and
+	// sends no header)
+	ClientID string
+
+	// SendTimeout bounds each send, including retries, on top of the caller's
+	// deadline (0 leaves only the caller's deadline)
+	SendTimeout time.Duration
+
+	// Idempotent writes every message once per partition despite retries; it
+	// overrides RequiredAcks with WaitForAll
+	Idempotent bool
+
+	// Security holds the TLS and SASL settings of the broker connections
+	Security SecurityConfig
+
+	// Retry bounds the attempts at each send (zero uses DefaultRetryPolicy)
+	Retry RetryPolicy
+
+	// Logger receives the producer's logs (nil uses the default logger)
+	Logger *slog.Logger
+
+	// PartitionSites, when set, sends messages with a site header to the
+	// partitions of their site (see NewSitePartitioner)
+	PartitionSites PartitionSitesFunc
 }
 
-// NewProducer creates a new Kafka producer
-func NewProducer(config ProducerConfig) (*Producer, error) {
+// NewProducer creates a new Kafka producer, applying opts such as
+// WithMaxMessagesPerSecond
+func NewProducer(config ProducerConfig, opts ...ProducerOption) (*Producer, error) {
 	// Create options for the publisher
-	opts := []OptionFunc{
+	publisherOpts := []OptionFunc{
 		WithProducerRequiredAcks(int(config.RequiredAcks)),
 		WithProducerReturnSuccesses(config.ReturnSuccesses),
 	}
 
 	// Set Kafka version if provided
 	if config.Version != "" {
-		opts = append(opts, WithKafkaVersion(config.Version))
+		publisherOpts = append(publisherOpts, WithKafkaVersion(config.Version))
+	}
+
+	// Apply TLS and SASL settings
+	security, err := config.Security.Options()
+	if err != nil {
+		return nil, err
+	}
+	publisherOpts = append(publisherOpts, security...)
+
+	if config.Idempotent {
+		publisherOpts = append(publisherOpts, WithIdempotence())
+	}
+
+	if config.ClientID != "" {
+		publisherOpts = append(publisherOpts, WithClientID(config.ClientID))
+	}
+
+	// Share the client unless the producer partitions by site, which is
+	// configured on the client
+	share := config.Security.securityKey()
+	if config.PartitionSites != nil {
+		publisherOpts = append(publisherOpts, WithPartitioner(NewSitePartitioner(config.PartitionSites)))
+		share = ""
 	}
 
 	// Create the publisher
-	publisher, err := NewKafkaPublisher(config.Brokers, config.Topic, opts...)
+	publisher, err := newKafkaPublisher(share, config.Brokers, config.Topic, publisherOpts...)
 	if err != nil {
 		return nil, err
 	}
+	publisher.clockHeaders = config.ClockDiagnostics
+	publisher.hopHeaders = config.HopHeaders
+	publisher.clientID = config.ClientID
+	publisher.retry = config.Retry
+	publisher.logger = logging.OrDefault(config.Logger)
+
+	// Export sarama's client metrics next to the producer metrics
+	if config.Metrics != nil {
+		registerClientMetrics(config.Metrics.registry, config.Metrics.subsystem, publisher.config.MetricRegistry)
+	}
 
-	return &Producer{
-		publisher: publisher,
-		topic:     config.Topic,
-		metrics:   config.Metrics,
-	}, nil
+	abort, cancel := context.WithCancel(context.Background())
+	producer := &Producer{
+		publisher:   publisher,
+		topic:       config.Topic,
+		metrics:     config.Metrics,
+		sendTimeout: config.SendTimeout,
+		logger:      publisher.logger,
+		abort:       abort,
+		cancel:      cancel,
+	}
+	for _, opt := range opts {
+		opt(producer)
+	}
+	return producer, nil
 }
 
-// SendMessage sends a message to the configured topic
-func (p *Producer) SendMessage(key, value []byte) {
-	startTime := time.Now()
+// SendMessage sends a message with headers to the configured topic. It gives
+// up when ctx is done or the configured send timeout elapses, and returns
+// ErrProducerClosed once shutdown has begun.
+func (p *Producer) SendMessage(ctx context.Context, key, value []byte, headers ...sarama.RecordHeader) error {
+	return p.send(ctx, p.topic, key, value, headers)
+}
 
-	// Publish the message
-	ctx := context.Background()
-	err := p.publisher.Publish(ctx, key, value)
+// SendMessageToTopic sends a message to topic like SendMessage, so that one
+// producer, its connections and its shutdown accounting can serve several
+// topics such as a data topic and its dead-letter topic
+func (p *Producer) SendMessageToTopic(ctx context.Context, topic string, key, value []byte, headers ...sarama.RecordHeader) error {
+	return p.send(ctx, topic, key, value, headers)
+}
 
-	// Update metrics
-	if p.metrics != nil {
-		if err == nil {
-			p.metrics.MessagesSent.Inc()
-			p.metrics.BytesSent.Add(float64(len(value)))
-			p.metrics.MessageLatency.Observe(time.Since(startTime).Seconds())
-		} else {
-			p.metrics.ErrorsTotal.Inc()
+// send sends a message to topic, tracking it for shutdown and metrics
+func (p *Producer) send(ctx context.Context, topic string, key, value []byte, headers []sarama.RecordHeader) error {
+	p.mu.Lock()
+	if p.closing {
+		p.mu.Unlock()
+		p.drop(&p.rejected)
+		return ErrProducerClosed
+	}
+	p.inflight.Add(1)
+	p.mu.Unlock()
+	defer p.inflight.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(p.abort, cancel)
+	defer stop()
+
+	// Wait for the rate limits before the send timeout starts
+	if err := p.throttle(ctx, len(value)); err != nil {
+		if p.abort.Err() != nil {
+			p.drop(&p.failed)
 		}
+		return err
+	}
+	if p.sendTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, p.sendTimeout)
+		defer cancelTimeout()
 	}
-}
 
-// SendMessageWithKey sends a message with the specified key to the configured topic
-func (p *Producer) SendMessageWithKey(key string, value []byte) {
 	startTime := time.Now()
 
-	// Convert string key to []byte
-	keyBytes := []byte(key)
-
-	// Publish the message
-	ctx := context.Background()
-	err := p.publisher.Publish(ctx, keyBytes, value)
+	// Publish the message, inside the transaction of the message being
+	// handled if there is one
+	publisher := p.publisher
+	if txn := txnFromContext(ctx); txn != nil {
+		publisher = txn
+	}
+	err := publisher.PublishToTopic(ctx, topic, key, value, headers...)
 
 	// Update metrics
 	if p.metrics != nil {
@@ -143,26 +287,91 @@ func (p *Producer) SendMessageWithKey(key string, value []byte) {
 			p.metrics.ErrorsTotal.Inc()
 		}
 	}
+	if err != nil && p.abort.Err() != nil {
+		p.drop(&p.failed)
+	}
+	return err
 }
 
-// SendMessageToTopic sends a message to the specified topic
-func (p *Producer) SendMessageToTopic(topic string, key, value []byte) {
-	// For this adapter, we'll just use the configured topic
-	// since the underlying publisher doesn't support changing topics
-	log.Printf("Warning: SendMessageToTopic called with topic %s, but using configured topic %s", topic, p.topic)
-	p.SendMessage(key, value)
+// throttle waits until a message of size bytes is within the rate limits
+func (p *Producer) throttle(ctx context.Context, size int) error {
+	waited, err := p.messageLimit.wait(ctx, 1)
+	if err != nil {
+		return err
+	}
+	byteWait, err := p.byteLimit.wait(ctx, float64(size))
+	if err != nil {
+		return err
+	}
+	if waited += byteWait; waited > 0 && p.metrics != nil {
+		p.metrics.Throttled.Add(waited.Seconds())
+	}
+	return nil
 }
 
-// Close closes the producer
+// drop counts a message dropped during shutdown
+func (p *Producer) drop(counter *atomic.Int64) {
+	counter.Add(1)
+	if p.metrics != nil {
+		p.metrics.Dropped.Inc()
+	}
+}
+
+// SendMessageWithKey sends a message with the specified key to the configured topic
+func (p *Producer) SendMessageWithKey(ctx context.Context, key string, value []byte, headers ...sarama.RecordHeader) error {
+	return p.SendMessage(ctx, []byte(key), value, headers...)
+}
+
+// Close closes the producer without waiting for in-flight sends, which fail
 func (p *Producer) Close() error {
+	p.mu.Lock()
+	p.closing = true
+	p.mu.Unlock()
+	p.cancel()
 	p.publisher.Stop()
 	return nil
 }
 
-// GracefulShutdown performs a graceful shutdown of the producer
+// GracefulShutdown stops accepting messages and waits for in-flight sends to
+// be acknowledged until ctx is done, then cancels the rest, flushes and closes
+// the publisher. It returns an error reporting how many messages were dropped:
+// rejected because they were sent after shutdown began, or abandoned when ctx
+// ran out.
 func (p *Producer) GracefulShutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closing = true
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		p.logger.Warn("Producer did not drain in time, cancelling in-flight sends", "topic", p.name())
+		p.cancel()
+		<-drained
+	}
 	p.publisher.Stop()
-	return nil
+	p.cancel()
+
+	rejected, failed := p.rejected.Load(), p.failed.Load()
+	if rejected+failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("producer for %s dropped %d messages during shutdown (%d rejected, %d abandoned)",
+		p.name(), rejected+failed, rejected, failed)
+}
+
+// name identifies the producer in shutdown messages
+func (p *Producer) name() string {
+	if p.topic == "" {
+		return "per-message topics"
+	}
+	return p.topic
 }
 
 // Consumer is a wrapper around IConsumer that provides the same API as internal/kafka.Consumer
@@ -173,12 +382,21 @@ type Consumer struct {
 
 // ConsumerMetrics holds Prometheus metrics for the consumer
 type ConsumerMetrics struct {
-	MessagesReceived prometheus.Counter
-	BytesReceived    prometheus.Counter
-	ErrorsTotal      prometheus.Counter
-	ProcessingTime   prometheus.Histogram
-	LagGauge         prometheus.Gauge
-	registry         prometheus.Registerer
+	MessagesReceived   prometheus.Counter
+	BytesReceived      prometheus.Counter
+	ErrorsTotal        prometheus.Counter
+	ProcessingTime     prometheus.Histogram
+	LagGauge           prometheus.Gauge
+	PartitionLag       *prometheus.GaugeVec
+	GroupGeneration    prometheus.Gauge
+	GroupMembers       prometheus.Gauge
+	AssignedPartitions prometheus.Gauge
+	Rebalances         *prometheus.CounterVec
+	DeadLettered       prometheus.Counter
+	WorkerWaitTime     *prometheus.CounterVec
+	ConsumeErrors      prometheus.Counter
+	registry           prometheus.Registerer
+	subsystem          string
 }
 
 // NewConsumerMetrics creates a new set of consumer metrics
@@ -213,9 +431,58 @@ func NewConsumerMetrics(namespace, subsystem string, registry prometheus.Registe
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "consumer_lag",
-			Help:      "Current consumer lag (messages behind)",
+			Help:      "Current consumer lag (messages behind), summed over the assigned partitions",
+		}),
+		PartitionLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "partition_lag",
+			Help:      "Messages between the committed offset and the high-water mark of each assigned partition",
+		}, []string{"topic", "partition"}),
+		GroupGeneration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "group_generation",
+			Help:      "Current consumer group generation ID",
+		}),
+		GroupMembers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "group_members",
+			Help:      "Number of members in the consumer group",
+		}),
+		AssignedPartitions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "assigned_partitions",
+			Help:      "Number of partitions assigned to this member",
+		}),
+		Rebalances: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "rebalances_total",
+			Help:      "Total number of consumer group rebalances by reason",
+		}, []string{"reason"}),
+		DeadLettered: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dead_lettered_total",
+			Help:      "Total number of messages sent to a dead-letter topic after every attempt failed",
+		}),
+		WorkerWaitTime: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "worker_wait_seconds_total",
+			Help:      "Total time messages of weighted topics waited for a worker, by topic",
+		}, []string{"topic"}),
+		ConsumeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "consume_errors_total",
+			Help:      "Total number of consumer group sessions that failed to join or ended with an error",
 		}),
-		registry: registry,
+		registry:  registry,
+		subsystem: subsystem,
 	}
 
 	registry.MustRegister(
@@ -224,6 +491,14 @@ func NewConsumerMetrics(namespace, subsystem string, registry prometheus.Registe
 		metrics.ErrorsTotal,
 		metrics.ProcessingTime,
 		metrics.LagGauge,
+		metrics.PartitionLag,
+		metrics.GroupGeneration,
+		metrics.GroupMembers,
+		metrics.AssignedPartitions,
+		metrics.Rebalances,
+		metrics.DeadLettered,
+		metrics.WorkerWaitTime,
+		metrics.ConsumeErrors,
 	)
 
 	return metrics
@@ -239,10 +514,87 @@ type ConsumerConfig struct {
 	Metrics         *ConsumerMetrics
 	Version         string
 	BalanceStrategy string
+
+	// PartitionSites maps partitions to sites for the site-affinity balance
+	// strategy (optional)
+	PartitionSites PartitionSitesFunc
+
+	// ClockMetrics records clock deltas from messages carrying send timestamps
+	ClockMetrics *ClockMetrics
+
+	// Schemas records the payload schema of every message under GroupID (optional)
+	Schemas *SchemaTracker
+
+	// HandlerTimeout bounds each handler attempt (0 disables)
+	HandlerTimeout time.Duration
+
+	// DrainTimeout bounds waiting for in-flight messages on Stop before they
+	// are cancelled and left for redelivery (0 cancels them immediately)
+	DrainTimeout time.Duration
+
+	// WorkerPoolSize is the number of messages handled concurrently (0 uses DefaultWorkerPoolSize)
+	WorkerPoolSize int
+
+	// TopicWeights shares the workers between topics by weight when messages
+	// of several topics wait for one; topics not listed weigh 1 (optional)
+	TopicWeights map[string]int
+
+	// HopHeaders passes a message's hops plus a consumed hop to the handler's context
+	HopHeaders bool
+
+	// Security holds the TLS and SASL settings of the broker connections
+	Security SecurityConfig
+
+	// Retry bounds the handler attempts at each message (zero uses DefaultRetryPolicy)
+	Retry RetryPolicy
+
+	// DeadLetter forwards messages whose handler failed every attempt, e.g.
+	// DeadLetterTo a dead-letter topic (optional; without it they are skipped)
+	DeadLetter DeadLetterFunc
+
+	// Saturation is fed every handled message and the group's assignment (optional)
+	Saturation *SaturationMonitor
+
+	// Progress records the claimed partitions and the offsets handled in
+	// them (optional)
+	Progress *PartitionProgress
+
+	// Rebalances is told when the consumer's partitions are revoked and
+	// assigned around each rebalance (optional)
+	Rebalances RebalanceObserver
+
+	// Transaction handles each message in a Kafka transaction (optional)
+	Transaction *TransactionConfig
+
+	// InflightBudget caps the memory of messages taken and not yet handled,
+	// applying backpressure once it is reached (optional)
+	InflightBudget *InflightBudget
+
+	// LagInterval is how often the lag of the assigned partitions is
+	// published in Metrics (0 disables; requires Metrics)
+	LagInterval time.Duration
+
+	// LagGauge also receives the summed lag, for services that report it
+	// under their own name (optional)
+	LagGauge prometheus.Gauge
+
+	// Logger receives the consumer's logs (nil uses the default logger)
+	Logger *slog.Logger
 }
 
-// MessageHandler is a function that processes a Kafka message
-type MessageHandler func(message *sarama.ConsumerMessage) error
+// MessageHandler is a function that processes a Kafka message. ctx is cancelled
+// when the consumer stops or the handler timeout elapses. It carries the
+// message's trace ID and retry count, so messages produced with it share the
+// trace ID and dead-letter headers record the retries.
+type MessageHandler func(ctx context.Context, message *sarama.ConsumerMessage) error
+
+// RebalanceObserver follows a consumer's assignment. Revoked is called when
+// a session ends, before the group rebalances, and Assigned with the
+// partitions of each new session by topic, before its claims start.
+type RebalanceObserver interface {
+	Revoked()
+	Assigned(claims map[string][]int32)
+}
 
 // NewConsumer creates a new Kafka consumer
 func NewConsumer(config ConsumerConfig, handler MessageHandler) (*Consumer, error) {
@@ -253,7 +605,19 @@ func NewConsumer(config ConsumerConfig, handler MessageHandler) (*Consumer, erro
 			config.Metrics.MessagesReceived.Inc()
 			config.Metrics.BytesReceived.Add(float64(len(message.Value)))
 		}
-		err := handler(message)
+		if config.ClockMetrics != nil {
+			config.ClockMetrics.Observe(message, startTime)
+		}
+		if config.Schemas != nil {
+			config.Schemas.Observe(config.GroupID, message, startTime)
+		}
+		if config.HopHeaders {
+			ctx = ContextWithHops(ctx, append(Hops(message), Hop{Stage: HopConsumed, At: startTime}))
+		}
+		if traceID, ok := TraceID(message); ok {
+			ctx = ContextWithTraceID(ctx, traceID)
+		}
+		err := handler(ctx, message)
 		if config.Metrics != nil {
 			config.Metrics.ProcessingTime.Observe(time.Since(startTime).Seconds())
 			if err != nil {
@@ -275,32 +639,92 @@ func NewConsumer(config ConsumerConfig, handler MessageHandler) (*Consumer, erro
 		opts = append(opts, WithKafkaVersion(config.Version))
 	}
 
-	// Set balance strategy if provided
+	// Apply TLS and SASL settings
+	security, err := config.Security.Options()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, security...)
+
+	// Set balance strategy if provided. The group shares its client unless
+	// it balances by site, which is configured on the client.
+	share := config.Security.securityKey()
 	if config.BalanceStrategy != "" {
 		strategy := GetBalanceStrategy(config.BalanceStrategy)
+		if config.BalanceStrategy == BalanceStrategySiteAffinity {
+			strategy = NewSiteAffinityStrategy(config.PartitionSites)
+			share = ""
+		}
 		opts = append(opts, WithConsumerGroupRebalanceStrategy(strategy))
 	}
 
-	topic := ""
-	if len(config.Topics) > 0 {
-		topic = config.Topics[0]
-		if len(config.Topics) > 1 {
-			log.Printf("Warning: Multiple topics provided, but only using the first one: %s", topic)
+	if len(config.Topics) == 0 {
+		return nil, fmt.Errorf("no topics to consume")
+	}
+
+	// Transactions commit offsets themselves, so the session must not
+	if config.Transaction != nil {
+		if config.Transaction.ID == "" {
+			return nil, fmt.Errorf("no transactional ID")
 		}
+		opts = append(opts, WithConsumerAutoCommit(false))
+	}
+
+	workerPoolSize := config.WorkerPoolSize
+	if workerPoolSize <= 0 {
+		workerPoolSize = DefaultWorkerPoolSize
 	}
 
 	// Create the consumer
-	consumer, err := NewKafkaConsumer(
+	consumer, err := newKafkaConsumer(
+		share,
 		config.Brokers,
-		topic,
+		config.Topics,
 		config.GroupID,
 		adaptedHandler,
-		DefaultWorkerPoolSize,
+		workerPoolSize,
 		opts...,
 	)
 	if err != nil {
 		return nil, err
 	}
+	consumer.groupMetrics = config.Metrics
+	consumer.logger = logging.OrDefault(config.Logger).With("group", config.GroupID)
+	if config.Metrics != nil {
+		registerClientMetrics(config.Metrics.registry, config.Metrics.subsystem, consumer.config.MetricRegistry)
+	}
+	consumer.handlerTimeout = config.HandlerTimeout
+	consumer.drainTimeout = config.DrainTimeout
+	consumer.retry = config.Retry
+	consumer.deadLetterHook = config.DeadLetter
+	consumer.inflightBudget = config.InflightBudget
+	if len(config.TopicWeights) > 0 {
+		var waitTime *prometheus.CounterVec
+		if config.Metrics != nil {
+			waitTime = config.Metrics.WorkerWaitTime
+		}
+		consumer.scheduler = newWorkerScheduler(workerPoolSize, config.TopicWeights, waitTime)
+	}
+	if config.Progress != nil {
+		config.Progress.setTopics(config.Topics)
+		consumer.progress = config.Progress
+	}
+	consumer.rebalances = config.Rebalances
+	if config.Transaction != nil {
+		consumer.txn = config.Transaction
+		// Transactional producers share the consumer's cluster settings
+		if config.Version != "" {
+			consumer.txnOpts = append(consumer.txnOpts, WithKafkaVersion(config.Version))
+		}
+		consumer.txnOpts = append(consumer.txnOpts, security...)
+	}
+	if config.Saturation != nil {
+		config.Saturation.setWorkers(workerPoolSize)
+		consumer.saturation = config.Saturation
+	}
+	if config.Metrics != nil && config.LagInterval > 0 {
+		consumer.lag = newLagMonitor(config.Brokers, consumer.config, config.GroupID, config.LagInterval, config.Metrics, config.LagGauge, consumer.logger)
+	}
 
 	return &Consumer{
 		consumer: consumer,
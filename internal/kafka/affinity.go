@@ -0,0 +1,219 @@
+package kafka
+
+import (
+	"sort"
+
+	"github.com/IBM/sarama"
+)
+
+// BalanceStrategySiteAffinity names the balance strategy assigning the
+// partitions of a site to one member
+const BalanceStrategySiteAffinity = "site-affinity"
+
+// PartitionSitesFunc returns the site of each partition by topic. Partitions
+// missing from the map have no site.
+type PartitionSitesFunc func() map[string]map[int32]string
+
+// less orders partitions by topic, then partition
+func (tp topicPartition) less(other topicPartition) bool {
+	if tp.topic != other.topic {
+		return tp.topic < other.topic
+	}
+	return tp.partition < other.partition
+}
+
+// partitionGroup is a set of partitions assigned to the same member
+type partitionGroup struct {
+	site       string
+	partitions []topicPartition
+}
+
+// siteAffinityStrategy implements sarama.BalanceStrategy
+type siteAffinityStrategy struct {
+	sites PartitionSitesFunc
+}
+
+// NewSiteAffinityStrategy creates a balance strategy that assigns the
+// partitions carrying the same site, across every topic, to the same member,
+// so that member sees all of the site's readings. Sites are placed largest
+// first on the member with the fewest partitions; a site with more partitions
+// than a member's share of the total is split into shares so no member stays
+// idle. Partitions without a site are spread over the least loaded members.
+// The plan depends only on the members, the partitions and the map, so it is
+// the same whichever member leads the group. sites may be nil, which leaves
+// every partition without a site.
+func NewSiteAffinityStrategy(sites PartitionSitesFunc) sarama.BalanceStrategy {
+	return &siteAffinityStrategy{sites: sites}
+}
+
+// Name implements sarama.BalanceStrategy
+func (s *siteAffinityStrategy) Name() string {
+	return BalanceStrategySiteAffinity
+}
+
+// Plan implements sarama.BalanceStrategy
+func (s *siteAffinityStrategy) Plan(members map[string]sarama.ConsumerGroupMemberMetadata, topics map[string][]int32) (sarama.BalanceStrategyPlan, error) {
+	plan := make(sarama.BalanceStrategyPlan, len(members))
+	if len(members) == 0 {
+		return plan, nil
+	}
+
+	var sites map[string]map[int32]string
+	if s.sites != nil {
+		sites = s.sites()
+	}
+
+	memberIDs := make([]string, 0, len(members))
+	subscriptions := make(map[string]map[string]bool, len(members))
+	for memberID, metadata := range members {
+		memberIDs = append(memberIDs, memberID)
+		subscriptions[memberID] = make(map[string]bool, len(metadata.Topics))
+		for _, topic := range metadata.Topics {
+			subscriptions[memberID][topic] = true
+		}
+	}
+	sort.Strings(memberIDs)
+
+	topicNames := make([]string, 0, len(topics))
+	for topic := range topics {
+		topicNames = append(topicNames, topic)
+	}
+	sort.Strings(topicNames)
+
+	// Group the partitions of each site; partitions without one stand alone
+	var groups []partitionGroup
+	bySite := make(map[string][]topicPartition)
+	total := 0
+	for _, topic := range topicNames {
+		partitions := append([]int32(nil), topics[topic]...)
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		for _, partition := range partitions {
+			total++
+			tp := topicPartition{topic: topic, partition: partition}
+			if site := sites[topic][partition]; site != "" {
+				bySite[site] = append(bySite[site], tp)
+				continue
+			}
+			groups = append(groups, partitionGroup{partitions: []topicPartition{tp}})
+		}
+	}
+	share := (total + len(members) - 1) / len(members)
+	for site, partitions := range bySite {
+		for len(partitions) > share {
+			groups = append(groups, partitionGroup{site: site, partitions: partitions[:share]})
+			partitions = partitions[share:]
+		}
+		groups = append(groups, partitionGroup{site: site, partitions: partitions})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].partitions) != len(groups[j].partitions) {
+			return len(groups[i].partitions) > len(groups[j].partitions)
+		}
+		if groups[i].site != groups[j].site {
+			return groups[i].site < groups[j].site
+		}
+		return groups[i].partitions[0].less(groups[j].partitions[0])
+	})
+
+	load := make(map[string]int, len(members))
+	assign := func(memberID string, partitions []topicPartition) {
+		for _, tp := range partitions {
+			plan.Add(memberID, tp.topic, tp.partition)
+		}
+		load[memberID] += len(partitions)
+	}
+	for _, group := range groups {
+		if memberID := leastLoaded(memberIDs, load, subscriptions, group.partitions); memberID != "" {
+			assign(memberID, group.partitions)
+			continue
+		}
+		// No member subscribes to every topic of the site; place its
+		// partitions one by one
+		for _, tp := range group.partitions {
+			if memberID := leastLoaded(memberIDs, load, subscriptions, []topicPartition{tp}); memberID != "" {
+				assign(memberID, []topicPartition{tp})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// AssignmentData implements sarama.BalanceStrategy
+func (s *siteAffinityStrategy) AssignmentData(memberID string, topics map[string][]int32, generationID int32) ([]byte, error) {
+	return nil, nil
+}
+
+// sitePartitioner implements sarama.Partitioner
+type sitePartitioner struct {
+	topic string
+	sites PartitionSitesFunc
+	hash  sarama.Partitioner
+}
+
+// NewSitePartitioner creates a partitioner sending each message with a site
+// header to one of the partitions sites gives its site, chosen by the hash of
+// its key, so the site-affinity strategy finds a site's readings where the
+// map says. Messages without a site, or whose site has no partition of the
+// topic, are partitioned by the hash of their key.
+func NewSitePartitioner(sites PartitionSitesFunc) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return &sitePartitioner{topic: topic, sites: sites, hash: sarama.NewHashPartitioner(topic)}
+	}
+}
+
+// Partition implements sarama.Partitioner
+func (p *sitePartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if site := producerHeader(message, HeaderSite); site != "" && p.sites != nil {
+		var partitions []int32
+		for partition, partitionSite := range p.sites()[p.topic] {
+			if partitionSite == site && partition < numPartitions {
+				partitions = append(partitions, partition)
+			}
+		}
+		if len(partitions) > 0 {
+			sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+			i, err := p.hash.Partition(message, int32(len(partitions)))
+			if err != nil {
+				return 0, err
+			}
+			return partitions[i], nil
+		}
+	}
+	return p.hash.Partition(message, numPartitions)
+}
+
+// RequiresConsistency implements sarama.Partitioner: a key keeps its
+// partition while the map does
+func (p *sitePartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// producerHeader returns the value of a header of a message being produced
+func producerHeader(message *sarama.ProducerMessage, key string) string {
+	for _, header := range message.Headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// leastLoaded returns the member with the fewest partitions among those
+// subscribed to the topics of partitions, the first by ID on ties, or ""
+// if none is
+func leastLoaded(memberIDs []string, load map[string]int, subscriptions map[string]map[string]bool, partitions []topicPartition) string {
+	best := ""
+	for _, memberID := range memberIDs {
+		subscribed := true
+		for _, tp := range partitions {
+			if !subscriptions[memberID][tp.topic] {
+				subscribed = false
+				break
+			}
+		}
+		if subscribed && (best == "" || load[memberID] < load[best]) {
+			best = memberID
+		}
+	}
+	return best
+}
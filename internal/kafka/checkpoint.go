@@ -0,0 +1,363 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+// OffsetCheckpointVersion is the version of the checkpoint file format
+const OffsetCheckpointVersion = 1
+
+// ErrGroupActive is returned when importing offsets into a group that has
+// members; its consumers must be stopped first
+var ErrGroupActive = errors.New("consumer group has active members")
+
+// OffsetCheckpoint holds the committed offsets of consumer groups at one point in time
+type OffsetCheckpoint struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Groups     []GroupCheckpoint `json:"groups"`
+}
+
+// GroupCheckpoint holds the committed offsets of one group
+type GroupCheckpoint struct {
+	Group  string            `json:"group"`
+	Topics []TopicCheckpoint `json:"topics"`
+}
+
+// TopicCheckpoint holds the committed offsets of a group on one topic
+type TopicCheckpoint struct {
+	Topic      string                `json:"topic"`
+	Partitions []PartitionCheckpoint `json:"partitions"`
+}
+
+// PartitionCheckpoint is the committed offset of a group on one partition
+type PartitionCheckpoint struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Metadata  string `json:"metadata,omitempty"`
+	// HighWatermark is the end of the partition at export, so the lag of
+	// the group can be told from the file
+	HighWatermark int64 `json:"high_watermark"`
+}
+
+// ReadOffsetCheckpoint decodes a checkpoint and checks its version
+func ReadOffsetCheckpoint(r io.Reader) (OffsetCheckpoint, error) {
+	var checkpoint OffsetCheckpoint
+	if err := json.NewDecoder(r).Decode(&checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("failed to decode offset checkpoint: %w", err)
+	}
+	if checkpoint.Version != OffsetCheckpointVersion {
+		return checkpoint, fmt.Errorf("unsupported offset checkpoint version %d, expected %d", checkpoint.Version, OffsetCheckpointVersion)
+	}
+	return checkpoint, nil
+}
+
+// WriteOffsetCheckpoint encodes a checkpoint as indented JSON
+func WriteOffsetCheckpoint(w io.Writer, checkpoint OffsetCheckpoint) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(checkpoint)
+}
+
+// ParseRenames parses a spec of the form "old=new,old=new"
+func ParseRenames(spec string) (map[string]string, error) {
+	renames := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(entry, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid rename %q, expected old=new", entry)
+		}
+		renames[from] = to
+	}
+	return renames, nil
+}
+
+// ImportOptions selects and maps the offsets an import commits
+type ImportOptions struct {
+	// Groups limits the import to these groups of the checkpoint (empty is all)
+	Groups []string
+	// RenameGroups and RenameTopics map names in the checkpoint to the names
+	// in the target cluster, for environments named differently
+	RenameGroups map[string]string
+	RenameTopics map[string]string
+	// DryRun reports what would be committed without committing
+	DryRun bool
+}
+
+// OffsetChange is the import of one partition offset
+type OffsetChange struct {
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Previous is the committed offset before the import, or -1 if none
+	Previous int64 `json:"previous"`
+	Offset   int64 `json:"offset"`
+	// Clamped is set when the checkpoint offset was outside the partition,
+	// as after importing into another cluster, and Offset was moved to its
+	// nearest end
+	Clamped bool `json:"clamped,omitempty"`
+}
+
+// ImportResult lists the offsets an import committed and the partitions it skipped
+type ImportResult struct {
+	Changes []OffsetChange `json:"changes"`
+	// Skipped lists partitions of the checkpoint missing in the target cluster
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// OffsetCheckpointer exports and imports the committed offsets of consumer
+// groups, for disaster recovery and for moving groups between environments
+type OffsetCheckpointer struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+	logger *slog.Logger
+}
+
+// NewOffsetCheckpointer creates a checkpointer connected to brokers. logger may be nil.
+func NewOffsetCheckpointer(brokers []string, logger *slog.Logger, opts ...OptionFunc) (*OffsetCheckpointer, error) {
+	config := sarama.NewConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create Kafka cluster admin: %w", err)
+	}
+
+	return &OffsetCheckpointer{client: client, admin: admin, logger: logging.OrDefault(logger)}, nil
+}
+
+// Close closes the admin connection and its client
+func (c *OffsetCheckpointer) Close() error {
+	return c.admin.Close()
+}
+
+// Export reads the committed offsets of groups on their topics. Partitions
+// a group has never committed are left out.
+func (c *OffsetCheckpointer) Export(groups []LagGroup) (OffsetCheckpoint, error) {
+	checkpoint := OffsetCheckpoint{Version: OffsetCheckpointVersion, ExportedAt: time.Now().UTC()}
+
+	for _, group := range groups {
+		partitions := make(map[string][]int32)
+		for _, topic := range group.Topics {
+			ids, err := c.client.Partitions(topic)
+			if err != nil {
+				return checkpoint, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+			}
+			partitions[topic] = ids
+		}
+
+		offsets, err := c.admin.ListConsumerGroupOffsets(group.Group, partitions)
+		if err != nil {
+			return checkpoint, fmt.Errorf("failed to fetch offsets of group %s: %w", group.Group, err)
+		}
+		if offsets.Err != sarama.ErrNoError {
+			return checkpoint, fmt.Errorf("failed to fetch offsets of group %s: %w", group.Group, offsets.Err)
+		}
+
+		groupCheckpoint := GroupCheckpoint{Group: group.Group}
+		for _, topic := range group.Topics {
+			topicCheckpoint := TopicCheckpoint{Topic: topic}
+			for _, partition := range partitions[topic] {
+				block := offsets.GetBlock(topic, partition)
+				if block == nil || block.Offset < 0 {
+					continue
+				}
+				if block.Err != sarama.ErrNoError {
+					return checkpoint, fmt.Errorf("failed to fetch offset of group %s on %s/%d: %w", group.Group, topic, partition, block.Err)
+				}
+				end, err := c.client.GetOffset(topic, partition, sarama.OffsetNewest)
+				if err != nil {
+					return checkpoint, fmt.Errorf("failed to get end offset of %s/%d: %w", topic, partition, err)
+				}
+				topicCheckpoint.Partitions = append(topicCheckpoint.Partitions, PartitionCheckpoint{
+					Partition:     partition,
+					Offset:        block.Offset,
+					Metadata:      block.Metadata,
+					HighWatermark: end,
+				})
+			}
+			sort.Slice(topicCheckpoint.Partitions, func(i, j int) bool {
+				return topicCheckpoint.Partitions[i].Partition < topicCheckpoint.Partitions[j].Partition
+			})
+			groupCheckpoint.Topics = append(groupCheckpoint.Topics, topicCheckpoint)
+		}
+		checkpoint.Groups = append(checkpoint.Groups, groupCheckpoint)
+		c.logger.Info("Exported consumer group offsets", "group", group.Group, "topics", len(group.Topics))
+	}
+	return checkpoint, nil
+}
+
+// Import commits the offsets of a checkpoint to its groups. A group must
+// have no members, since the brokers reject commits from outside an active
+// group; ErrGroupActive is returned before anything of that group is
+// committed. Offsets outside a partition are clamped to its nearest end, so
+// a consumer resumes there instead of falling back to its initial offset.
+func (c *OffsetCheckpointer) Import(checkpoint OffsetCheckpoint, opts ImportOptions) (ImportResult, error) {
+	var result ImportResult
+
+	selected := make(map[string]bool, len(opts.Groups))
+	for _, group := range opts.Groups {
+		selected[group] = true
+	}
+
+	for _, groupCheckpoint := range checkpoint.Groups {
+		if len(selected) > 0 && !selected[groupCheckpoint.Group] {
+			continue
+		}
+		group := rename(opts.RenameGroups, groupCheckpoint.Group)
+
+		if err := c.checkGroupEmpty(group); err != nil {
+			return result, err
+		}
+
+		request := &sarama.OffsetCommitRequest{
+			Version:                 1,
+			ConsumerGroup:           group,
+			ConsumerGroupGeneration: -1,
+		}
+		var changes []OffsetChange
+		for _, topicCheckpoint := range groupCheckpoint.Topics {
+			topic := rename(opts.RenameTopics, topicCheckpoint.Topic)
+			change, skipped, err := c.planTopic(group, topic, topicCheckpoint.Partitions)
+			if err != nil {
+				return result, err
+			}
+			result.Skipped = append(result.Skipped, skipped...)
+			for i, partition := range topicCheckpoint.Partitions {
+				if change[i] == nil {
+					continue
+				}
+				request.AddBlock(topic, change[i].Partition, change[i].Offset, sarama.ReceiveTime, partition.Metadata)
+				changes = append(changes, *change[i])
+			}
+		}
+
+		if len(changes) > 0 && !opts.DryRun {
+			if err := c.commit(request); err != nil {
+				return result, err
+			}
+		}
+		result.Changes = append(result.Changes, changes...)
+		c.logger.Info("Imported consumer group offsets", "group", group, "partitions", len(changes), "dry_run", opts.DryRun)
+	}
+	return result, nil
+}
+
+// checkGroupEmpty returns ErrGroupActive if group has members
+func (c *OffsetCheckpointer) checkGroupEmpty(group string) error {
+	descriptions, err := c.admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		return fmt.Errorf("failed to describe group %s: %w", group, err)
+	}
+	for _, description := range descriptions {
+		if description.Err != sarama.ErrNoError {
+			return fmt.Errorf("failed to describe group %s: %w", group, description.Err)
+		}
+		if len(description.Members) > 0 {
+			return fmt.Errorf("%w: %s has %d, stop its consumers first", ErrGroupActive, group, len(description.Members))
+		}
+	}
+	return nil
+}
+
+// planTopic returns the change for each checkpointed partition of topic, nil
+// where the partition does not exist, and the partitions skipped
+func (c *OffsetCheckpointer) planTopic(group, topic string, partitions []PartitionCheckpoint) ([]*OffsetChange, []string, error) {
+	existing, err := c.client.Partitions(topic)
+	if err != nil && !errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+		return nil, nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+	}
+	exists := make(map[int32]bool, len(existing))
+	for _, partition := range existing {
+		exists[partition] = true
+	}
+
+	current := &sarama.OffsetFetchResponse{}
+	if len(existing) > 0 {
+		current, err = c.admin.ListConsumerGroupOffsets(group, map[string][]int32{topic: existing})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch offsets of group %s: %w", group, err)
+		}
+	}
+
+	changes := make([]*OffsetChange, len(partitions))
+	var skipped []string
+	for i, partition := range partitions {
+		if !exists[partition.Partition] {
+			skipped = append(skipped, fmt.Sprintf("%s/%s/%d", group, topic, partition.Partition))
+			continue
+		}
+
+		oldest, err := c.client.GetOffset(topic, partition.Partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get oldest offset of %s/%d: %w", topic, partition.Partition, err)
+		}
+		newest, err := c.client.GetOffset(topic, partition.Partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get end offset of %s/%d: %w", topic, partition.Partition, err)
+		}
+
+		change := &OffsetChange{Group: group, Topic: topic, Partition: partition.Partition, Previous: -1, Offset: partition.Offset}
+		if block := current.GetBlock(topic, partition.Partition); block != nil {
+			change.Previous = block.Offset
+		}
+		if change.Offset < oldest {
+			change.Offset, change.Clamped = oldest, true
+		} else if change.Offset > newest {
+			change.Offset, change.Clamped = newest, true
+		}
+		changes[i] = change
+	}
+	return changes, skipped, nil
+}
+
+// commit sends an offset commit to the group's coordinator
+func (c *OffsetCheckpointer) commit(request *sarama.OffsetCommitRequest) error {
+	coordinator, err := c.client.Coordinator(request.ConsumerGroup)
+	if err != nil {
+		return fmt.Errorf("failed to find coordinator of group %s: %w", request.ConsumerGroup, err)
+	}
+	response, err := coordinator.CommitOffset(request)
+	if err != nil {
+		return fmt.Errorf("failed to commit offsets of group %s: %w", request.ConsumerGroup, err)
+	}
+	for topic, partitions := range response.Errors {
+		for partition, kerr := range partitions {
+			if kerr != sarama.ErrNoError {
+				return fmt.Errorf("failed to commit offset of group %s on %s/%d: %w", request.ConsumerGroup, topic, partition, kerr)
+			}
+		}
+	}
+	return nil
+}
+
+// rename returns the name renames maps name to, or name itself
+func rename(renames map[string]string, name string) string {
+	if renamed, ok := renames[name]; ok {
+		return renamed
+	}
+	return name
+}
@@ -0,0 +1,141 @@
+package kafka
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// clientMetricsPrefix namespaces the bridged sarama metrics
+const clientMetricsPrefix = "iot_kafka_client_"
+
+// clientMetricQuantiles are the quantiles exported for sarama histograms
+var clientMetricQuantiles = []float64{0.5, 0.95, 0.99}
+
+// invalidMetricChars matches characters not allowed in Prometheus metric names
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// consumerGroupMetric matches sarama's per-group counters, which embed the group ID in the name
+var consumerGroupMetric = regexp.MustCompile(`^(consumer-group-(?:join|sync)-(?:total|failed))-.+$`)
+
+// protocolRequestsMetric matches sarama's per-API request meters
+var protocolRequestsMetric = regexp.MustCompile(`^protocol-requests-rate-(\d+)$`)
+
+// clientMetricsCollector exports the go-metrics registry of one sarama client.
+// Sarama keeps request rates, batch sizes, compression ratios, latencies and
+// in-flight requests there, per broker and per topic, and they are otherwise
+// invisible. Metrics are read at scrape time, so the collector is unchecked:
+// the set of brokers and topics is only known once the client is running.
+type clientMetricsCollector struct {
+	client   string
+	registry gometrics.Registry
+}
+
+var (
+	exportedClientMetricsMu sync.Mutex
+	// exportedClientMetrics holds the sarama registries already exported, so
+	// the metrics of a shared client are exported once
+	exportedClientMetrics = make(map[gometrics.Registry]bool)
+)
+
+// registerClientMetrics exports a sarama client's metrics as iot_kafka_client_*
+// on registry, labelled with client. It is a no-op when either is nil or the
+// metrics are already exported, labelled with the first producer or consumer
+// of a shared client.
+func registerClientMetrics(registry prometheus.Registerer, client string, metrics gometrics.Registry) {
+	if registry == nil || metrics == nil {
+		return
+	}
+	exportedClientMetricsMu.Lock()
+	defer exportedClientMetricsMu.Unlock()
+	if exportedClientMetrics[metrics] {
+		return
+	}
+	exportedClientMetrics[metrics] = true
+	registry.MustRegister(&clientMetricsCollector{client: client, registry: metrics})
+}
+
+// Describe sends no descriptors, making the collector unchecked
+func (c *clientMetricsCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect converts every sarama metric into a Prometheus metric
+func (c *clientMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.registry.Each(func(name string, metric interface{}) {
+		base, labels, values := c.parse(name)
+
+		switch m := metric.(type) {
+		case gometrics.Meter:
+			snapshot := m.Snapshot()
+			ch <- prometheus.MustNewConstMetric(
+				c.desc(base, "one-minute rate", labels),
+				prometheus.GaugeValue, snapshot.Rate1(), values...)
+			ch <- prometheus.MustNewConstMetric(
+				c.desc(strings.TrimSuffix(base, "_rate")+"_total", "count", labels),
+				prometheus.CounterValue, float64(snapshot.Count()), values...)
+		case gometrics.Histogram:
+			snapshot := m.Snapshot()
+			percentiles := snapshot.Percentiles(clientMetricQuantiles)
+			quantiles := make(map[float64]float64, len(clientMetricQuantiles))
+			for i, q := range clientMetricQuantiles {
+				quantiles[q] = percentiles[i]
+			}
+			ch <- prometheus.MustNewConstSummary(
+				c.desc(base, "distribution over a sample of recent values", labels),
+				uint64(snapshot.Count()), float64(snapshot.Sum()), quantiles, values...)
+		case gometrics.Counter:
+			// Sarama counters such as requests-in-flight move in both directions
+			ch <- prometheus.MustNewConstMetric(
+				c.desc(base, "current value", labels),
+				prometheus.GaugeValue, float64(m.Count()), values...)
+		case gometrics.Gauge:
+			ch <- prometheus.MustNewConstMetric(
+				c.desc(base, "current value", labels),
+				prometheus.GaugeValue, float64(m.Value()), values...)
+		case gometrics.GaugeFloat64:
+			ch <- prometheus.MustNewConstMetric(
+				c.desc(base, "current value", labels),
+				prometheus.GaugeValue, m.Value(), values...)
+		}
+	})
+}
+
+// parse splits a sarama metric name such as "record-send-rate-for-topic-sensor_raw"
+// into a Prometheus base name and its labels. Every family carries the client,
+// broker and topic labels; broker and topic are empty on client-wide totals.
+func (c *clientMetricsCollector) parse(name string) (string, []string, []string) {
+	var broker, topic string
+	if i := strings.LastIndex(name, "-for-broker-"); i >= 0 {
+		broker = name[i+len("-for-broker-"):]
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "-for-topic-"); i >= 0 {
+		// Sarama replaces dots in topic names with underscores
+		topic = name[i+len("-for-topic-"):]
+		name = name[:i]
+	}
+
+	labels := []string{"client", "broker", "topic"}
+	values := []string{c.client, broker, topic}
+	if m := consumerGroupMetric.FindStringSubmatch(name); m != nil {
+		name = m[1]
+	}
+	if m := protocolRequestsMetric.FindStringSubmatch(name); m != nil {
+		labels = append(labels, "api_key")
+		values = append(values, m[1])
+		name = "protocol-requests-rate"
+	}
+
+	return invalidMetricChars.ReplaceAllString(name, "_"), labels, values
+}
+
+// desc builds the descriptor of a bridged metric
+func (c *clientMetricsCollector) desc(base, kind string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		clientMetricsPrefix+base,
+		"Sarama client metric "+base+" ("+kind+")",
+		labels, nil,
+	)
+}
@@ -0,0 +1,102 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+var (
+	sharedClientsMu sync.Mutex
+	// sharedClients holds the clients of the process by settings once
+	// ShareClients is called (nil creates a client per producer and consumer)
+	sharedClients map[string]*sharedClient
+)
+
+// sharedClient is a client and the number of producers and consumer groups using it
+type sharedClient struct {
+	client sarama.Client
+	users  int
+}
+
+// ShareClients makes the producers and consumer groups created afterwards
+// share one client, with its broker connections and metadata, with those of
+// the same brokers and settings. The fleet binary calls it so that its
+// components do not each open their own connections. Producers partitioning
+// by site, consumer groups balancing by site and transactional producers
+// keep their own client.
+func ShareClients() {
+	sharedClientsMu.Lock()
+	defer sharedClientsMu.Unlock()
+	if sharedClients == nil {
+		sharedClients = make(map[string]*sharedClient)
+	}
+}
+
+// acquireClient returns the shared client of brokers and config, creating it
+// on first use, and the function to call when done with it, which closes the
+// client once its last user is done. It returns a nil client when clients are
+// not shared or share is empty, for the caller to create its own. share
+// identifies the settings the sarama configuration does not show, such as
+// the TLS and SASL settings.
+func acquireClient(share string, brokers []string, config *sarama.Config) (sarama.Client, func(), error) {
+	if share == "" {
+		return nil, nil, nil
+	}
+	sharedClientsMu.Lock()
+	defer sharedClientsMu.Unlock()
+	if sharedClients == nil {
+		return nil, nil, nil
+	}
+
+	key := share + "|" + clientKey(brokers, config)
+	shared := sharedClients[key]
+	if shared == nil {
+		client, err := sarama.NewClient(brokers, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		shared = &sharedClient{client: client}
+		sharedClients[key] = shared
+	}
+	shared.users++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			sharedClientsMu.Lock()
+			defer sharedClientsMu.Unlock()
+			if shared.users--; shared.users > 0 {
+				return
+			}
+			delete(sharedClients, key)
+			shared.client.Close()
+		})
+	}
+	return shared.client, release, nil
+}
+
+// clientKey lists the settings of config that producers and consumer groups
+// sharing a client must agree on
+func clientKey(brokers []string, config *sarama.Config) string {
+	var strategies []string
+	if config.Consumer.Group.Rebalance.Strategy != nil {
+		strategies = append(strategies, config.Consumer.Group.Rebalance.Strategy.Name())
+	}
+	for _, strategy := range config.Consumer.Group.Rebalance.GroupStrategies {
+		strategies = append(strategies, strategy.Name())
+	}
+	return fmt.Sprintf("%s|%s|%s|%d|%d|%t|%t|%t|%d|%s|%t|%d|%t|%d|%s",
+		strings.Join(brokers, ","), config.Version, config.ClientID, config.Net.MaxOpenRequests,
+		config.Producer.RequiredAcks, config.Producer.Return.Successes, config.Producer.Return.Errors,
+		config.Producer.Idempotent, config.Producer.Retry.Max, config.Producer.Retry.Backoff,
+		config.Consumer.Return.Errors, config.Consumer.Offsets.Initial, config.Consumer.Offsets.AutoCommit.Enable,
+		config.Consumer.IsolationLevel, strings.Join(strategies, ","))
+}
+
+// securityKey identifies TLS and SASL settings for sharing clients
+func (s SecurityConfig) securityKey() string {
+	return fmt.Sprintf("%+v", s)
+}
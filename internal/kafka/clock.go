@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clockDeltaBuckets spans negative deltas so that clock skew in either direction is visible
+var clockDeltaBuckets = []float64{-10, -5, -2, -1, -0.5, -0.1, -0.01, 0, 0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10}
+
+// ClockMetrics holds Prometheus metrics for produce-to-consume clock diagnostics
+type ClockMetrics struct {
+	ProducerToBroker   prometheus.Histogram
+	BrokerToConsumer   prometheus.Histogram
+	ProducerToConsumer prometheus.Histogram
+	NegativeDeltas     *prometheus.CounterVec
+	LastSkew           prometheus.Gauge
+}
+
+// NewClockMetrics creates a new set of clock diagnostic metrics
+func NewClockMetrics(namespace, subsystem string, registry prometheus.Registerer) *ClockMetrics {
+	metrics := &ClockMetrics{
+		ProducerToBroker: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "clock_producer_to_broker_seconds",
+			Help:      "Broker message timestamp minus producer send time in seconds",
+			Buckets:   clockDeltaBuckets,
+		}),
+		BrokerToConsumer: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "clock_broker_to_consumer_seconds",
+			Help:      "Consumer receive time minus broker message timestamp in seconds",
+			Buckets:   clockDeltaBuckets,
+		}),
+		ProducerToConsumer: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "clock_producer_to_consumer_seconds",
+			Help:      "Consumer receive time minus producer send time in seconds",
+			Buckets:   clockDeltaBuckets,
+		}),
+		NegativeDeltas: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "clock_negative_deltas_total",
+			Help:      "Total number of causally impossible (negative) clock deltas, indicating clock drift",
+		}, []string{"hop"}),
+		LastSkew: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "clock_last_producer_to_consumer_seconds",
+			Help:      "Most recently observed producer-to-consumer clock delta in seconds",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.ProducerToBroker,
+		metrics.BrokerToConsumer,
+		metrics.ProducerToConsumer,
+		metrics.NegativeDeltas,
+		metrics.LastSkew,
+	)
+
+	return metrics
+}
+
+// Observe records the clock deltas for a message received at the given time.
+// Messages without a send-time header are ignored.
+func (m *ClockMetrics) Observe(message *sarama.ConsumerMessage, receivedAt time.Time) {
+	sentAt, ok := SentAt(message)
+	if !ok {
+		return
+	}
+
+	producerToConsumer := receivedAt.Sub(sentAt).Seconds()
+	m.ProducerToConsumer.Observe(producerToConsumer)
+	m.LastSkew.Set(producerToConsumer)
+	if producerToConsumer < 0 {
+		m.NegativeDeltas.WithLabelValues("producer_to_consumer").Inc()
+	}
+
+	if message.Timestamp.IsZero() {
+		return
+	}
+
+	producerToBroker := message.Timestamp.Sub(sentAt).Seconds()
+	m.ProducerToBroker.Observe(producerToBroker)
+	if producerToBroker < 0 {
+		m.NegativeDeltas.WithLabelValues("producer_to_broker").Inc()
+	}
+
+	brokerToConsumer := receivedAt.Sub(message.Timestamp).Seconds()
+	m.BrokerToConsumer.Observe(brokerToConsumer)
+	if brokerToConsumer < 0 {
+		m.NegativeDeltas.WithLabelValues("broker_to_consumer").Inc()
+	}
+}
+
+// SentAt returns the producer send time embedded in a message, if present
+func SentAt(message *sarama.ConsumerMessage) (time.Time, bool) {
+	for _, header := range message.Headers {
+		if header == nil || string(header.Key) != HeaderSentAt {
+			continue
+		}
+		millis, err := strconv.ParseInt(string(header.Value), 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.UnixMilli(millis), true
+	}
+	return time.Time{}, false
+}
+
+// sentAtHeader builds the send-time header for a message
+func sentAtHeader(t time.Time) sarama.RecordHeader {
+	return sarama.RecordHeader{
+		Key:   []byte(HeaderSentAt),
+		Value: []byte(strconv.FormatInt(t.UnixMilli(), 10)),
+	}
+}
@@ -0,0 +1,322 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ClusterMetrics holds Prometheus metrics describing the Kafka cluster as seen by the admin APIs
+type ClusterMetrics struct {
+	Brokers                   prometheus.Gauge
+	TopicPartitions           *prometheus.GaugeVec
+	TopicHighWatermark        *prometheus.GaugeVec
+	TopicMessageRate          *prometheus.GaugeVec
+	UnderReplicatedPartitions *prometheus.GaugeVec
+	OfflinePartitions         *prometheus.GaugeVec
+	ClientQuota               *prometheus.GaugeVec
+	ScrapeErrors              prometheus.Counter
+	ScrapeDuration            prometheus.Histogram
+}
+
+// NewClusterMetrics creates a new set of cluster metrics
+func NewClusterMetrics(namespace, subsystem string, registry prometheus.Registerer) *ClusterMetrics {
+	metrics := &ClusterMetrics{
+		Brokers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "brokers",
+			Help:      "Number of brokers in the cluster",
+		}),
+		TopicPartitions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "topic_partitions",
+			Help:      "Number of partitions per topic",
+		}, []string{"topic"}),
+		TopicHighWatermark: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "topic_high_watermark",
+			Help:      "Sum of partition high-water marks per topic",
+		}, []string{"topic"}),
+		TopicMessageRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "topic_messages_per_second",
+			Help:      "Messages per second appended to the topic, derived from high-water mark deltas",
+		}, []string{"topic"}),
+		UnderReplicatedPartitions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "topic_under_replicated_partitions",
+			Help:      "Number of partitions whose ISR is smaller than the replica set",
+		}, []string{"topic"}),
+		OfflinePartitions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "topic_offline_partitions",
+			Help:      "Number of partitions without an active leader",
+		}, []string{"topic"}),
+		ClientQuota: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "client_quota",
+			Help:      "Configured client quota values",
+		}, []string{"entity_type", "entity", "quota"}),
+		ScrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scrape_errors_total",
+			Help:      "Total number of failed cluster metadata scrapes",
+		}),
+		ScrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scrape_duration_seconds",
+			Help:      "Time taken to scrape cluster metadata in seconds",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Brokers,
+		metrics.TopicPartitions,
+		metrics.TopicHighWatermark,
+		metrics.TopicMessageRate,
+		metrics.UnderReplicatedPartitions,
+		metrics.OfflinePartitions,
+		metrics.ClientQuota,
+		metrics.ScrapeErrors,
+		metrics.ScrapeDuration,
+	)
+
+	return metrics
+}
+
+// ClusterCollector periodically describes topics and quotas through the admin API
+// and publishes the results as Prometheus metrics
+type ClusterCollector struct {
+	client      sarama.Client
+	admin       sarama.ClusterAdmin
+	topics      []string
+	interval    time.Duration
+	metrics     *ClusterMetrics
+	lastOffsets map[string]topicOffset
+	logger      *slog.Logger
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+
+	// denied holds the parts of the scrape whose last attempt was
+	// permanently denied, so the denial is reported once
+	denied map[string]bool
+}
+
+// topicOffset is the summed high-water mark of a topic and when it was read
+type topicOffset struct {
+	offset int64
+	at     time.Time
+}
+
+// NewClusterCollector creates a new cluster collector for the given topics
+func NewClusterCollector(brokers []string, topics []string, interval time.Duration, metrics *ClusterMetrics, logger *slog.Logger, opts ...OptionFunc) (*ClusterCollector, error) {
+	config := sarama.NewConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create Kafka cluster admin: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ClusterCollector{
+		client:      client,
+		admin:       admin,
+		topics:      topics,
+		interval:    interval,
+		metrics:     metrics,
+		lastOffsets: make(map[string]topicOffset),
+		logger:      logging.OrDefault(logger),
+		ctx:         ctx,
+		cancel:      cancel,
+		denied:      make(map[string]bool),
+	}, nil
+}
+
+// Start begins collecting cluster metrics
+func (c *ClusterCollector) Start() {
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Stop stops collecting and closes the admin connection
+func (c *ClusterCollector) Stop() {
+	c.cancel()
+	c.wg.Wait()
+	// Closing the admin also closes the underlying client
+	if err := c.admin.Close(); err != nil {
+		c.logger.Error("Failed to close Kafka cluster admin", "error", err)
+	}
+}
+
+// run scrapes the cluster on every tick
+func (c *ClusterCollector) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	c.scrape()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.scrape()
+		}
+	}
+}
+
+// scrape performs a single collection pass
+func (c *ClusterCollector) scrape() {
+	startTime := time.Now()
+	defer func() {
+		c.metrics.ScrapeDuration.Observe(time.Since(startTime).Seconds())
+	}()
+
+	brokers, _, err := c.admin.DescribeCluster()
+	if c.report("cluster", err) {
+		return
+	}
+	c.metrics.Brokers.Set(float64(len(brokers)))
+
+	c.report("topics", c.scrapeTopics(startTime))
+
+	// Quotas are optional; managed clusters frequently deny DescribeClientQuotas
+	c.report("client quotas", c.scrapeQuotas())
+}
+
+// report logs and counts the failure of a part of the scrape, and reports
+// whether there was one. A permanent denial, such as missing ACLs, would
+// fail every scrape, so it is logged and counted once until the part
+// succeeds again.
+func (c *ClusterCollector) report(part string, err error) bool {
+	if err == nil {
+		delete(c.denied, part)
+		return false
+	}
+	if permanentDenial(err) {
+		if c.denied[part] {
+			return true
+		}
+		c.denied[part] = true
+		c.logger.Warn("Kafka denied describing "+part+"; not reported again until it succeeds", "error", err)
+	} else {
+		c.logger.Warn("Failed to describe Kafka "+part, "error", err)
+	}
+	c.metrics.ScrapeErrors.Inc()
+	return true
+}
+
+// permanentDenial reports whether err is a refusal that repeats until the
+// cluster's ACLs or version change
+func permanentDenial(err error) bool {
+	return errors.Is(err, sarama.ErrClusterAuthorizationFailed) ||
+		errors.Is(err, sarama.ErrTopicAuthorizationFailed) ||
+		errors.Is(err, sarama.ErrUnsupportedVersion)
+}
+
+// scrapeTopics reports partition counts, replication health and message
+// rates. A topic's high-water mark is recorded, with when it was read, only
+// once every partition was read, so a rate always spans the time between two
+// complete reads.
+func (c *ClusterCollector) scrapeTopics(now time.Time) error {
+	metadata, err := c.admin.DescribeTopics(c.topics)
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range metadata {
+		if c.report("topic "+topic.Name, topicError(topic.Err)) {
+			continue
+		}
+
+		underReplicated, offline := 0, 0
+		var highWatermark int64
+		for _, partition := range topic.Partitions {
+			if len(partition.Isr) < len(partition.Replicas) {
+				underReplicated++
+			}
+			if partition.Leader < 0 {
+				offline++
+				continue
+			}
+
+			offset, err := c.client.GetOffset(topic.Name, partition.ID, sarama.OffsetNewest)
+			if err != nil {
+				return fmt.Errorf("failed to get offset for %s/%d: %w", topic.Name, partition.ID, err)
+			}
+			highWatermark += offset
+		}
+
+		c.metrics.TopicPartitions.WithLabelValues(topic.Name).Set(float64(len(topic.Partitions)))
+		c.metrics.UnderReplicatedPartitions.WithLabelValues(topic.Name).Set(float64(underReplicated))
+		c.metrics.OfflinePartitions.WithLabelValues(topic.Name).Set(float64(offline))
+		c.metrics.TopicHighWatermark.WithLabelValues(topic.Name).Set(float64(highWatermark))
+
+		if last, ok := c.lastOffsets[topic.Name]; ok {
+			if elapsed := now.Sub(last.at).Seconds(); elapsed > 0 && highWatermark >= last.offset {
+				c.metrics.TopicMessageRate.WithLabelValues(topic.Name).Set(float64(highWatermark-last.offset) / elapsed)
+			}
+		}
+		c.lastOffsets[topic.Name] = topicOffset{offset: highWatermark, at: now}
+	}
+	return nil
+}
+
+// topicError returns the error of a topic's metadata, or nil
+func topicError(err sarama.KError) error {
+	if err == sarama.ErrNoError {
+		return nil
+	}
+	return err
+}
+
+// scrapeQuotas reports all configured client quotas
+func (c *ClusterCollector) scrapeQuotas() error {
+	entries, err := c.admin.DescribeClientQuotas(nil, false)
+	if err != nil {
+		return err
+	}
+
+	c.metrics.ClientQuota.Reset()
+	for _, entry := range entries {
+		for _, entity := range entry.Entity {
+			name := entity.Name
+			if name == "" {
+				name = "<default>"
+			}
+			for quota, value := range entry.Values {
+				c.metrics.ClientQuota.WithLabelValues(string(entity.EntityType), name, quota).Set(value)
+			}
+		}
+	}
+	return nil
+}
@@ -22,9 +22,70 @@ const (
 	DefaultKafkaVersion = "3.7.0" // Updated to match iot-sensor-fleet version
 )
 
+// Message header keys
+const (
+	// HeaderSentAt carries the producer's wall-clock send time in Unix milliseconds
+	HeaderSentAt = "x-sent-at"
+
+	// HeaderHop records one pipeline stage as "stage=unix-millis"; a message
+	// carries one header per stage it and its predecessors passed through
+	HeaderHop = "x-hop"
+
+	// HeaderDLTReason carries why a message was sent to a dead-letter topic
+	HeaderDLTReason = "x-dlt-reason"
+
+	// HeaderDLTSource records where a dead-lettered message was consumed from as "topic/partition@offset"
+	HeaderDLTSource = "x-dlt-source"
+
+	// HeaderDLTFailedAt carries when a message was dead-lettered in Unix milliseconds
+	HeaderDLTFailedAt = "x-dlt-failed-at"
+
+	// HeaderReplayCount counts how many times a message was replayed from a dead-letter topic
+	HeaderReplayCount = "x-replay-count"
+
+	// HeaderTraceID identifies a reading and every message derived from it
+	HeaderTraceID = "x-trace-id"
+
+	// HeaderSchemaVersion carries the version of the payload's schema
+	HeaderSchemaVersion = "x-schema-version"
+
+	// HeaderFormat names the wire format of the payload, so consumers decode
+	// it without sniffing
+	HeaderFormat = "x-format"
+
+	// HeaderClientID names the client that produced a message, and
+	// HeaderFirmwareVersion the firmware of the device a reading comes from,
+	// so bad payloads can be traced back to their source
+	HeaderClientID        = "x-client-id"
+	HeaderFirmwareVersion = "x-firmware-version"
+
+	// HeaderRetryCount counts how many times the handler retried a message
+	// before it was dead-lettered
+	HeaderRetryCount = "x-retry-count"
+
+	// HeaderOriginalTopic, HeaderOriginalPartition and HeaderOriginalOffset
+	// record where a dead-lettered message was consumed from
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+
+	// HeaderDetectorColor names the blue/green deployment color of the
+	// detector that raised an alert
+	HeaderDetectorColor = "x-detector-color"
+
+	// HeaderReceivedAt carries when an ingest gateway received a reading
+	// from its device in Unix milliseconds, whatever the device's clock says
+	HeaderReceivedAt = "x-received-at"
+
+	// HeaderSite carries the site of a reading, so producers partitioning
+	// by site place it without decoding the payload
+	HeaderSite = "x-site"
+)
+
 // RebalanceStrategyMap maps string names to sarama BalanceStrategy implementations
 var RebalanceStrategyMap = map[string]string{
-	"range":      "Range",
-	"roundrobin": "RoundRobin",
-	"sticky":     "Sticky",
+	"range":         "Range",
+	"roundrobin":    "RoundRobin",
+	"sticky":        "Sticky",
+	"site-affinity": "SiteAffinity",
 }
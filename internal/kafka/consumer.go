@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/IBM/sarama"
-	"log"
-	"math/rand"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/tracing"
 )
 
 // MessageHandlerFunc defines the function for handling messages
@@ -22,7 +24,7 @@ type IConsumer interface {
 // kafkaConsumer implements both IConsumer and sarama.ConsumerGroupHandler
 type kafkaConsumer struct {
 	brokers       []string
-	topic         string
+	topics        []string
 	groupID       string
 	consumerGroup sarama.ConsumerGroup
 	handler       MessageHandlerFunc
@@ -31,32 +33,109 @@ type kafkaConsumer struct {
 	ctx           context.Context
 	cancel        context.CancelFunc
 	wg            sync.WaitGroup
+
+	// handlerCtx outlives ctx during the drain so in-flight messages can finish
+	handlerCtx    context.Context
+	cancelHandler context.CancelFunc
+	inflight      atomic.Int64
+
+	// handlerTimeout bounds each handler attempt (0 disables)
+	handlerTimeout time.Duration
+
+	// drainTimeout bounds waiting for in-flight messages on Stop (0 cancels them)
+	drainTimeout time.Duration
+
+	// retry bounds the handler attempts at each message
+	retry RetryPolicy
+
+	// deadLetterHook forwards messages that failed every attempt (nil skips them)
+	deadLetterHook DeadLetterFunc
+
+	// inflightBudget caps the memory of messages taken from claims and not
+	// yet handled (nil disables)
+	inflightBudget *InflightBudget
+
+	// txn handles each message in a transaction when set, with producers
+	// created with txnOpts
+	txn     *TransactionConfig
+	txnOpts []OptionFunc
+
+	// Group membership tracking, only populated when metrics or a saturation monitor are configured
+	groupMetrics *ConsumerMetrics
+	saturation   *SaturationMonitor
+	admin        sarama.ClusterAdmin
+	generation   int32
+	assignment   map[string][]int32
+
+	// lag publishes the lag of the assigned partitions (nil disables)
+	lag *lagMonitor
+
+	// progress records the claimed partitions and handled offsets (nil disables)
+	progress *PartitionProgress
+
+	// rebalances is told of revoked and assigned partitions (nil disables)
+	rebalances RebalanceObserver
+
+	// scheduler hands out the workers by topic weight in place of
+	// workerPool (nil uses workerPool)
+	scheduler *workerScheduler
+
+	// release returns a shared client once the consumer group is closed (nil
+	// when the group owns its client)
+	release func()
+
+	logger *slog.Logger
 }
 
 // NewKafkaConsumer creates a new Kafka consumer
 func NewKafkaConsumer(brokers []string, topic, groupID string, handler MessageHandlerFunc, workerPoolSize int, opts ...OptionFunc) (IConsumer, error) {
+	return newKafkaConsumer("", brokers, []string{topic}, groupID, handler, workerPoolSize, opts...)
+}
+
+// newKafkaConsumer creates a new Kafka consumer, returning the concrete type so
+// that wrappers in this package can attach optional behaviour. Unless share
+// is empty, the consumer group uses the shared client of its settings when
+// clients are shared (see acquireClient).
+func newKafkaConsumer(share string, brokers, topics []string, groupID string, handler MessageHandlerFunc, workerPoolSize int, opts ...OptionFunc) (*kafkaConsumer, error) {
 	config := sarama.NewConfig()
 
 	// Set default values
 	config.Consumer.Return.Errors = DefaultConsumerReturnErrors
 	config.Consumer.Offsets.Initial = DefaultConsumerOffsetInitial
+	// Skip messages of aborted transactions, so consumers never see the
+	// output of a transactional handler attempt that was rolled back
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
 
 	// Apply options
 	for _, opt := range opts {
 		opt(config)
 	}
 
-	// Create consumer group
-	consumerGroup, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	// Create consumer group, on the shared client if there is one
+	client, release, err := acquireClient(share, brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	var consumerGroup sarama.ConsumerGroup
+	if client != nil {
+		config = client.Config()
+		consumerGroup, err = sarama.NewConsumerGroupFromClient(groupID, client)
+	} else {
+		consumerGroup, err = sarama.NewConsumerGroup(brokers, groupID, config)
+	}
 	if err != nil {
+		if release != nil {
+			release()
+		}
 		return nil, fmt.Errorf("failed to create Kafka consumer group: %w", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	handlerCtx, cancelHandler := context.WithCancel(context.Background())
 
 	return &kafkaConsumer{
 		brokers:       brokers,
-		topic:         topic,
+		topics:        topics,
 		groupID:       groupID,
 		consumerGroup: consumerGroup,
 		handler:       handler,
@@ -64,6 +143,10 @@ func NewKafkaConsumer(brokers []string, topic, groupID string, handler MessageHa
 		workerPool:    make(chan struct{}, workerPoolSize),
 		ctx:           ctx,
 		cancel:        cancel,
+		handlerCtx:    handlerCtx,
+		cancelHandler: cancelHandler,
+		release:       release,
+		logger:        slog.Default().With("group", groupID),
 	}, nil
 }
 
@@ -71,15 +154,50 @@ func NewKafkaConsumer(brokers []string, topic, groupID string, handler MessageHa
 func (c *kafkaConsumer) Start() error {
 	c.wg.Add(1)
 	go c.consume()
+	if c.lag != nil {
+		c.lag.start()
+	}
 	return nil
 }
 
-// Stop stops consuming messages and closes the consumer group
+// Stop stops claiming messages, waits up to the drain timeout for in-flight
+// messages to be handled, commits their offsets and closes the consumer group.
+// Messages still in flight when the timeout elapses are cancelled and left
+// uncommitted, and so are the messages after them on their partition, so
+// they are all redelivered.
 func (c *kafkaConsumer) Stop() {
 	c.cancel()
-	c.wg.Wait()
+	if c.lag != nil {
+		c.lag.stop()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(drained)
+	}()
+
+	if c.drainTimeout > 0 {
+		select {
+		case <-drained:
+		case <-time.After(c.drainTimeout):
+			c.logger.Warn("Consumer group did not drain in time, cancelling in-flight messages",
+				"drain_timeout", c.drainTimeout, "inflight", c.inflight.Load())
+		}
+	}
+	c.cancelHandler()
+	<-drained
+
 	if err := c.consumerGroup.Close(); err != nil {
-		log.Printf("Failed to close Kafka consumer group: %v", err)
+		c.logger.Error("Failed to close Kafka consumer group", "error", err)
+	}
+	if c.release != nil {
+		c.release()
+	}
+	if c.admin != nil {
+		if err := c.admin.Close(); err != nil {
+			c.logger.Error("Failed to close Kafka cluster admin", "error", err)
+		}
 	}
 }
 
@@ -92,8 +210,11 @@ func (c *kafkaConsumer) consume() {
 		case <-c.ctx.Done():
 			return
 		default:
-			if err := c.consumerGroup.Consume(c.ctx, []string{c.topic}, c); err != nil {
-				log.Printf("Error from consumer: %v", err)
+			if err := c.consumerGroup.Consume(c.ctx, c.topics, c); err != nil {
+				c.logger.Error("Error from consumer", "error", err)
+				if c.groupMetrics != nil {
+					c.groupMetrics.ConsumeErrors.Inc()
+				}
 				time.Sleep(time.Second) // Wait before retrying
 			}
 		}
@@ -101,83 +222,197 @@ func (c *kafkaConsumer) consume() {
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (c *kafkaConsumer) Setup(sarama.ConsumerGroupSession) error {
+func (c *kafkaConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	if c.groupMetrics != nil || c.saturation != nil {
+		c.recordSession(session)
+	}
+	if c.lag != nil {
+		c.lag.setAssignment(session.Claims())
+	}
+	if c.progress != nil {
+		c.progress.setGeneration(session.GenerationID())
+	}
+	if c.rebalances != nil {
+		c.rebalances.Assigned(session.Claims())
+	}
 	return nil
 }
 
-// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (c *kafkaConsumer) Cleanup(sarama.ConsumerGroupSession) error {
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have
+// exited, and commits the offsets they marked before the partitions are released
+func (c *kafkaConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	session.Commit()
+	if c.progress != nil {
+		c.progress.released()
+	}
+	if c.rebalances != nil {
+		c.rebalances.Revoked()
+	}
 	return nil
 }
 
-// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages()
+// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
+// It stops claiming when the consumer stops or the session ends and returns
+// once its in-flight messages are handled, so their offsets are committed by
+// this session rather than redelivered to the next owner of the partition.
 func (c *kafkaConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for message := range claim.Messages() {
+	if c.progress != nil {
+		c.progress.claimed(claim.Topic(), claim.Partition(), claim.InitialOffset())
+	}
+	if c.txn != nil {
+		return c.consumeClaimInTxns(session, claim)
+	}
+
+	var inflight sync.WaitGroup
+	defer inflight.Wait()
+
+	// Workers finish out of order, so offsets are marked up to the lowest
+	// message not yet handled
+	offsets := newClaimOffsets(session, claim)
+
+	for {
 		select {
 		case <-c.ctx.Done():
 			return nil
-		case c.workerPool <- struct{}{}: // Acquire worker
-			c.wg.Add(1)
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			// Hold off taking more messages while too much memory is in flight
+			var charge *inflightCharge
+			if c.inflightBudget != nil {
+				if charge = c.inflightBudget.acquireMessage(c.ctx, message); charge == nil {
+					return nil
+				}
+			}
+			if !c.acquireWorker(message.Topic) {
+				charge.release()
+				return nil
+			}
+			inflight.Add(1)
+			c.inflight.Add(1)
+			offsets.taken(message.Offset)
 			go func(msg *sarama.ConsumerMessage) {
-				defer c.wg.Done()
-				defer func() { <-c.workerPool }() // Release worker
+				defer inflight.Done()
+				defer c.inflight.Add(-1)
+				defer c.releaseWorker()
+				defer charge.release()
 
-				c.processMessage(session, msg)
+				if c.processMessage(msg, charge) {
+					offsets.done(msg.Offset)
+				}
 			}(message)
 		}
 	}
-	return nil
 }
 
-// processMessage processes a single message with retry logic
-func (c *kafkaConsumer) processMessage(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) {
-	// Simple retry mechanism with exponential backoff
+// acquireWorker takes a worker for a message of topic, from the scheduler
+// when topics are weighted. It returns false if the consumer stops first.
+func (c *kafkaConsumer) acquireWorker(topic string) bool {
+	if c.scheduler != nil {
+		return c.scheduler.acquire(c.ctx, topic)
+	}
+	select {
+	case <-c.ctx.Done():
+		return false
+	case c.workerPool <- struct{}{}:
+		return true
+	}
+}
+
+// releaseWorker frees a worker taken with acquireWorker
+func (c *kafkaConsumer) releaseWorker() {
+	if c.scheduler != nil {
+		c.scheduler.release()
+		return
+	}
+	<-c.workerPool
+}
+
+// processMessage processes a single message with retry logic; charge is what
+// the message holds of the in-flight budget, if any. It reports whether the
+// message was handled or dead-lettered, and so may be committed; a message
+// whose handling was cancelled is neither.
+func (c *kafkaConsumer) processMessage(msg *sarama.ConsumerMessage, charge *inflightCharge) bool {
+	// Retry with exponential backoff under the consumer's retry policy
 	var err error
-	maxRetries := 3
-	maxWait := 2 * time.Minute
-	deadline := time.Now().Add(maxWait)
+	var busy time.Duration
+	var attempts int
+	retry := c.retry.orDefault()
+	deadline := retry.deadline(time.Now())
 
-	for i := 0; i < maxRetries; i++ {
-		// Check if context is done
-		select {
-		case <-c.ctx.Done():
-			log.Printf("Context canceled while processing message")
-			return
-		default:
-			// Try to process the message
-			err = c.handler(c.ctx, msg)
-			if err == nil {
-				break // Success, exit the loop
-			}
+	for i := 0; i < retry.MaxAttempts; i++ {
+		// Check if handling was cancelled
+		if c.handlerCtx.Err() != nil {
+			c.logger.With(MessageLogAttrs(msg)...).Debug("Context canceled while processing message")
+			return false
+		}
 
-			// Check if we've exceeded the deadline
-			if time.Now().After(deadline) {
-				log.Printf("Exceeded retry deadline for message")
-				break
-			}
+		// Try to process the message
+		start := time.Now()
+		err = c.handle(charge.context(c.handlerCtx), msg, i)
+		busy += time.Since(start)
+		attempts++
+		if err == nil {
+			break // Success, exit the loop
+		}
 
-			// Calculate backoff time (exponential with jitter)
-			backoffTime := time.Duration(100*(1<<i)) * time.Millisecond
-			// Add some jitter (±20%)
-			jitter := time.Duration(float64(backoffTime) * (0.8 + 0.4*rand.Float64()))
+		// Give up after the last attempt or once the deadline passed
+		if i == retry.MaxAttempts-1 {
+			break
+		}
+		if retry.expired(deadline, time.Now()) {
+			c.logger.With(MessageLogAttrs(msg)...).Warn("Exceeded retry deadline for message")
+			break
+		}
 
-			log.Printf("Retrying message after %v (attempt %d/%d): %v", jitter, i+1, maxRetries, err)
+		// Calculate backoff time (exponential with jitter)
+		backoff := retry.backoff(i)
 
-			// Wait before retrying
-			select {
-			case <-c.ctx.Done():
-				return
-			case <-time.After(jitter):
-				// Continue with next retry
-			}
+		c.logger.With(MessageLogAttrs(msg)...).Warn("Retrying message",
+			"backoff", backoff, "attempt", i+1, "max_attempts", retry.MaxAttempts, "error", err)
+
+		// Wait before retrying
+		select {
+		case <-c.handlerCtx.Done():
+			return false
+		case <-time.After(backoff):
+			// Continue with next retry
 		}
 	}
 
-	if err != nil {
-		log.Printf("Failed to process message after retries: %v", err)
-		// Here you could implement a Dead Letter Queue (DLQ) for failed messages
+	// An attempt cut short by Stop failed for the consumer, not the message
+	if err != nil && c.handlerCtx.Err() != nil {
+		c.logger.With(MessageLogAttrs(msg)...).Debug("Context canceled while processing message")
+		return false
+	}
+
+	if err != nil && !c.deadLetter(c.handlerCtx, msg, attempts, err) {
+		c.logger.With(MessageLogAttrs(msg)...).Error("Failed to process message after retries", "error", err)
+	}
+
+	if c.saturation != nil {
+		c.saturation.observe(msg.Topic, msg.Partition, busy)
 	}
 
-	// Mark message as processed
-	session.MarkMessage(msg, "")
+	if c.progress != nil {
+		c.progress.handledMessage(msg)
+	}
+	return true
+}
+
+// handle runs one handler attempt under ctx, derived from the handler
+// context, and the handler timeout. Both ConsumeClaim loops go through it,
+// so every attempt is a span continuing the trace of the message.
+func (c *kafkaConsumer) handle(ctx context.Context, msg *sarama.ConsumerMessage, retries int) error {
+	ctx = ContextWithRetryCount(ctx, retries)
+	if c.handlerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.handlerTimeout)
+		defer cancel()
+	}
+	ctx, span := startProcessSpan(ctx, msg, c.groupID, retries)
+	err := c.handler(ctx, msg)
+	tracing.End(span, err)
+	return err
 }
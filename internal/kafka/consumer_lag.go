@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lagMonitor periodically compares the group's committed offsets of the
+// partitions assigned to this member with their high-water marks, and
+// publishes the lag of each in the consumer metrics. Every member reports
+// only its own partitions, so the series of all members add up to the lag of
+// the group without double counting. Offsets are committed on the consumer's
+// commit interval, so the lag reads that much high.
+type lagMonitor struct {
+	brokers  []string
+	config   *sarama.Config
+	groupID  string
+	interval time.Duration
+	metrics  *ConsumerMetrics
+	// total also receives the summed lag (optional)
+	total  prometheus.Gauge
+	logger *slog.Logger
+
+	mu         sync.Mutex
+	assignment map[string][]int32
+
+	// client and admin are connected on the first check, once Kafka is up
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newLagMonitor creates a lag monitor for a consumer's group
+func newLagMonitor(brokers []string, config *sarama.Config, groupID string, interval time.Duration, metrics *ConsumerMetrics, total prometheus.Gauge, logger *slog.Logger) *lagMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lagMonitor{
+		brokers:  brokers,
+		config:   config,
+		groupID:  groupID,
+		interval: interval,
+		metrics:  metrics,
+		total:    total,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// start begins checking the lag every interval
+func (m *lagMonitor) start() {
+	m.wg.Add(1)
+	go m.run()
+}
+
+// stop stops checking the lag and closes the admin connection
+func (m *lagMonitor) stop() {
+	m.cancel()
+	m.wg.Wait()
+	// Closing the admin also closes the underlying client
+	if m.admin != nil {
+		if err := m.admin.Close(); err != nil {
+			m.logger.Error("Failed to close Kafka cluster admin", "error", err)
+		}
+	}
+}
+
+// setAssignment replaces the partitions whose lag is reported, at the start
+// of every group session
+func (m *lagMonitor) setAssignment(claims map[string][]int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.assignment = claims
+}
+
+// run checks the lag on every tick
+func (m *lagMonitor) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.check(); err != nil {
+				m.logger.Warn("Failed to check consumer group lag", "error", err)
+			}
+		}
+	}
+}
+
+// check computes the lag of the assigned partitions and replaces the
+// published values. On failure the previous values are kept.
+func (m *lagMonitor) check() error {
+	m.mu.Lock()
+	assignment := m.assignment
+	m.mu.Unlock()
+
+	if len(assignment) == 0 {
+		m.metrics.PartitionLag.Reset()
+		m.metrics.LagGauge.Set(0)
+		if m.total != nil {
+			m.total.Set(0)
+		}
+		return nil
+	}
+
+	if m.admin == nil {
+		client, err := sarama.NewClient(m.brokers, m.config)
+		if err != nil {
+			return fmt.Errorf("failed to create Kafka client: %w", err)
+		}
+		admin, err := sarama.NewClusterAdminFromClient(client)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("failed to create Kafka cluster admin: %w", err)
+		}
+		m.client, m.admin = client, admin
+	}
+
+	offsets, err := m.admin.ListConsumerGroupOffsets(m.groupID, assignment)
+	if err != nil {
+		return fmt.Errorf("failed to list committed offsets: %w", err)
+	}
+
+	fromOldest := m.config.Consumer.Offsets.Initial == sarama.OffsetOldest
+	lags := make(map[string][]PartitionLag, len(assignment))
+	var total int64
+	for topic, partitions := range assignment {
+		for _, partition := range partitions {
+			lag, err := partitionLag(m.client, offsets, topic, partition, fromOldest)
+			if err != nil {
+				return err
+			}
+			lags[topic] = append(lags[topic], lag)
+			total += lag.Lag
+		}
+	}
+
+	// Partitions that moved to another member stop being reported here
+	m.metrics.PartitionLag.Reset()
+	for topic, partitions := range lags {
+		for _, lag := range partitions {
+			m.metrics.PartitionLag.WithLabelValues(topic, strconv.FormatInt(int64(lag.Partition), 10)).Set(float64(lag.Lag))
+		}
+	}
+	m.metrics.LagGauge.Set(float64(total))
+	if m.total != nil {
+		m.total.Set(float64(total))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNoDeadLetterTopic is returned by dead-letter hooks for messages whose
+// topic has no dead-letter topic; such messages are skipped quietly
+var ErrNoDeadLetterTopic = errors.New("no dead-letter topic")
+
+// DeadLetterFunc forwards a message whose handler failed every attempt with
+// reason. ctx carries the retry count of the last attempt and, for
+// transactional consumers, the transaction that commits the message's
+// offset. When it fails the message is skipped, as it is without a hook.
+type DeadLetterFunc func(ctx context.Context, message *sarama.ConsumerMessage, reason error) error
+
+// DeadLetterTo returns a hook that sends failed messages through producer
+// with their original key, value and headers followed by DLTHeaders. A
+// message goes to the dead-letter topic topics maps its topic to, or to the
+// producer's topic when it maps none; without either it is not sent and
+// ErrNoDeadLetterTopic is returned.
+func DeadLetterTo(producer *Producer, topics map[string]string) DeadLetterFunc {
+	return func(ctx context.Context, message *sarama.ConsumerMessage, reason error) error {
+		headers := make([]sarama.RecordHeader, 0, len(message.Headers))
+		for _, header := range message.Headers {
+			if header != nil {
+				headers = append(headers, *header)
+			}
+		}
+		headers = append(headers, DLTHeaders(ctx, message, reason, time.Now())...)
+
+		if topic, ok := topics[message.Topic]; ok {
+			return producer.SendMessageToTopic(ctx, topic, message.Key, message.Value, headers...)
+		}
+		if producer.topic == "" {
+			return ErrNoDeadLetterTopic
+		}
+		return producer.SendMessage(ctx, message.Key, message.Value, headers...)
+	}
+}
+
+// NewDeadLetterFromConfig creates the dead-letter hook of component's
+// consumer of the topics with keys when CONSUMER_DEAD_LETTER is on, sending
+// each failed message to the DLT paired with its topic (see TOPICS). It
+// returns a nil hook and producer when it is off or no topic has a DLT; the
+// caller closes the producer once the consumer stopped.
+func NewDeadLetterFromConfig(cfg *config.Config, keys []string, component string, registry prometheus.Registerer, logger *slog.Logger) (DeadLetterFunc, *Producer, error) {
+	if !cfg.ConsumerDeadLetter {
+		return nil, nil, nil
+	}
+	topics := make(map[string]string)
+	for _, key := range keys {
+		if dlt := cfg.TopicDLT(key); dlt != "" {
+			topics[cfg.Topic(key)] = dlt
+		}
+	}
+	if len(topics) == 0 {
+		return nil, nil, nil
+	}
+
+	producer, err := NewProducer(ProducerConfig{
+		Brokers:         cfg.KafkaBrokers,
+		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+		Idempotent:      cfg.ProducerIdempotent,
+		ReturnSuccesses: cfg.ProducerReturnSuccess,
+		ReturnErrors:    cfg.ProducerReturnErrors,
+		Metrics:         NewProducerMetrics("iot", component+"_dlt_producer", registry),
+		Version:         cfg.KafkaVersion,
+		SendTimeout:     cfg.ProducerSendTimeout,
+		Security:        SecurityFromConfig(cfg),
+		Retry:           ProducerRetryFromConfig(cfg, component),
+		Logger:          logger,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create DLT producer: %w", err)
+	}
+	return DeadLetterTo(producer, topics), producer, nil
+}
+
+// deadLetter forwards a message that failed every attempt through the
+// consumer's hook, if it has one, and reports whether it was forwarded
+func (c *kafkaConsumer) deadLetter(ctx context.Context, msg *sarama.ConsumerMessage, attempts int, reason error) bool {
+	if c.deadLetterHook == nil {
+		return false
+	}
+	ctx = ContextWithRetryCount(ctx, attempts-1)
+	if err := c.deadLetterHook(ctx, msg, reason); err != nil {
+		if errors.Is(err, ErrNoDeadLetterTopic) {
+			return false
+		}
+		c.logger.With(MessageLogAttrs(msg)...).Error("Failed to send message to DLT", "reason", reason, "error", err)
+		return false
+	}
+	if c.groupMetrics != nil {
+		c.groupMetrics.DeadLettered.Inc()
+	}
+	c.logger.With(MessageLogAttrs(msg)...).Warn("Sent message to DLT after retries", "attempts", attempts, "error", reason)
+	return true
+}
@@ -0,0 +1,124 @@
+package kafka
+
+import (
+	"slices"
+
+	"github.com/IBM/sarama"
+)
+
+// Rebalance reasons reported in the rebalances_total metric
+const (
+	RebalanceReasonInitial           = "initial"
+	RebalanceReasonAssignmentChanged = "assignment_changed"
+	RebalanceReasonGenerationChanged = "generation_changed"
+)
+
+// recordSession updates group membership metrics and the saturation monitor's
+// view of the group at the start of a session
+func (c *kafkaConsumer) recordSession(session sarama.ConsumerGroupSession) {
+	claims := session.Claims()
+
+	reason := RebalanceReasonGenerationChanged
+	switch {
+	case c.assignment == nil:
+		reason = RebalanceReasonInitial
+	case !sameAssignment(c.assignment, claims):
+		reason = RebalanceReasonAssignmentChanged
+	}
+
+	assigned := 0
+	for _, partitions := range claims {
+		assigned += len(partitions)
+	}
+
+	c.generation = session.GenerationID()
+	c.assignment = claims
+
+	if c.groupMetrics != nil {
+		c.groupMetrics.GroupGeneration.Set(float64(session.GenerationID()))
+		c.groupMetrics.AssignedPartitions.Set(float64(assigned))
+		c.groupMetrics.Rebalances.WithLabelValues(reason).Inc()
+	}
+
+	c.logger.Info("Joined consumer group", "generation", session.GenerationID(),
+		"member", session.MemberID(), "partitions", assigned, "reason", reason)
+
+	members, err := c.describeMembers()
+	if err != nil {
+		c.logger.Warn("Failed to describe consumer group", "error", err)
+	} else if c.groupMetrics != nil {
+		c.groupMetrics.GroupMembers.Set(float64(members))
+	}
+
+	if c.saturation != nil {
+		total, err := c.describePartitions()
+		if err != nil {
+			c.logger.Warn("Failed to describe topics", "topics", c.topics, "error", err)
+		}
+		c.saturation.setGroup(members, total, claims)
+	}
+}
+
+// describePartitions returns the number of partitions of the consumed topics
+func (c *kafkaConsumer) describePartitions() (int, error) {
+	admin, err := c.clusterAdmin()
+	if err != nil {
+		return 0, err
+	}
+
+	metadata, err := admin.DescribeTopics(c.topics)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, topic := range metadata {
+		total += len(topic.Partitions)
+	}
+	return total, nil
+}
+
+// clusterAdmin returns the consumer's cluster admin, connecting on first use
+func (c *kafkaConsumer) clusterAdmin() (sarama.ClusterAdmin, error) {
+	if c.admin == nil {
+		admin, err := sarama.NewClusterAdmin(c.brokers, c.config)
+		if err != nil {
+			return nil, err
+		}
+		c.admin = admin
+	}
+	return c.admin, nil
+}
+
+// describeMembers returns the number of members currently in the group
+func (c *kafkaConsumer) describeMembers() (int, error) {
+	admin, err := c.clusterAdmin()
+	if err != nil {
+		return 0, err
+	}
+
+	groups, err := admin.DescribeConsumerGroups([]string{c.groupID})
+	if err != nil {
+		return 0, err
+	}
+	if len(groups) == 0 {
+		return 0, nil
+	}
+	return len(groups[0].Members), nil
+}
+
+// sameAssignment reports whether two topic-partition assignments are identical
+func sameAssignment(a, b map[string][]int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for topic, partitions := range a {
+		other := slices.Clone(b[topic])
+		current := slices.Clone(partitions)
+		slices.Sort(other)
+		slices.Sort(current)
+		if !slices.Equal(current, other) {
+			return false
+		}
+	}
+	return true
+}
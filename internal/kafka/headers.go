@@ -0,0 +1,318 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+)
+
+// headersKey is the context key carrying headers to add to produced messages
+type headersKey struct{}
+
+// ContextWithHeaders returns a context carrying headers, after any already
+// carried by ctx. Producers add them to every message sent with the context.
+func ContextWithHeaders(ctx context.Context, headers ...sarama.RecordHeader) context.Context {
+	existing := HeadersFromContext(ctx)
+	all := make([]sarama.RecordHeader, 0, len(existing)+len(headers))
+	all = append(append(all, existing...), headers...)
+	return context.WithValue(ctx, headersKey{}, all)
+}
+
+// HeadersFromContext returns the headers carried by ctx
+func HeadersFromContext(ctx context.Context) []sarama.RecordHeader {
+	headers, _ := ctx.Value(headersKey{}).([]sarama.RecordHeader)
+	return headers
+}
+
+// Header returns the value of a message's last header with key
+func Header(message *sarama.ConsumerMessage, key string) (string, bool) {
+	for i := len(message.Headers) - 1; i >= 0; i-- {
+		if header := message.Headers[i]; header != nil && string(header.Key) == key {
+			return string(header.Value), true
+		}
+	}
+	return "", false
+}
+
+// hasHeader reports whether headers contain key
+func hasHeader(headers []sarama.RecordHeader, key string) bool {
+	for _, header := range headers {
+		if string(header.Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// DLTHeaders describes why and where a message failed, for sending it to a
+// dead-letter topic, with the retry count of the handler context ctx. The
+// message's replay count and trace ID are carried over so a replayed message
+// that fails again is not replayed forever and can still be followed through
+// the pipeline.
+func DLTHeaders(ctx context.Context, message *sarama.ConsumerMessage, reason error, at time.Time) []sarama.RecordHeader {
+	headers := []sarama.RecordHeader{
+		{Key: []byte(HeaderDLTReason), Value: []byte(reason.Error())},
+		{Key: []byte(HeaderDLTSource), Value: []byte(fmt.Sprintf("%s/%d@%d", message.Topic, message.Partition, message.Offset))},
+		{Key: []byte(HeaderDLTFailedAt), Value: []byte(strconv.FormatInt(at.UnixMilli(), 10))},
+	}
+	headers = append(headers, OriginHeaders(message)...)
+	headers = append(headers, RetryCountHeader(RetryCountFromContext(ctx)))
+	if count := ReplayCount(message); count > 0 {
+		headers = append(headers, ReplayCountHeader(count))
+	}
+	if traceID, ok := TraceID(message); ok {
+		headers = append(headers, TraceIDHeader(traceID))
+	}
+	return headers
+}
+
+// DLTFailedAt returns when a message was dead-lettered, falling back to its
+// Kafka timestamp for messages without the header
+func DLTFailedAt(message *sarama.ConsumerMessage) time.Time {
+	if value, ok := Header(message, HeaderDLTFailedAt); ok {
+		if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.UnixMilli(millis)
+		}
+	}
+	return message.Timestamp
+}
+
+// ReplayCount returns how many times a message was replayed; malformed or
+// missing headers count as zero
+func ReplayCount(message *sarama.ConsumerMessage) int {
+	value, ok := Header(message, HeaderReplayCount)
+	if !ok {
+		return 0
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0
+	}
+	return count
+}
+
+// ReplayCountHeader builds a replay count header
+func ReplayCountHeader(count int) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderReplayCount), Value: []byte(strconv.Itoa(count))}
+}
+
+// traceIDKey is the context key carrying the trace ID of the message being handled
+type traceIDKey struct{}
+
+// NewTraceID returns a new random trace ID
+func NewTraceID() string {
+	return uuid.New().String()
+}
+
+// ContextWithTraceID returns a context carrying a trace ID. Producers add it
+// to every message sent with the context that has no trace ID header, so
+// derived messages share the trace ID of the message they came from.
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext returns the trace ID carried by ctx
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	traceID, ok := ctx.Value(traceIDKey{}).(string)
+	return traceID, ok && traceID != ""
+}
+
+// TraceIDHeader builds a trace ID header
+func TraceIDHeader(traceID string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderTraceID), Value: []byte(traceID)}
+}
+
+// TraceID returns the trace ID of a message
+func TraceID(message *sarama.ConsumerMessage) (string, bool) {
+	traceID, ok := Header(message, HeaderTraceID)
+	return traceID, ok && traceID != ""
+}
+
+// SchemaVersionHeader builds a schema version header
+func SchemaVersionHeader(version int) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderSchemaVersion), Value: []byte(strconv.Itoa(version))}
+}
+
+// SchemaVersion returns the schema version of a message's payload; messages
+// without a well-formed header report false
+func SchemaVersion(message *sarama.ConsumerMessage) (int, bool) {
+	value, ok := Header(message, HeaderSchemaVersion)
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// ClientIDHeader builds a producing client header
+func ClientIDHeader(clientID string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderClientID), Value: []byte(clientID)}
+}
+
+// ClientID returns the client that produced a message, if it said
+func ClientID(message *sarama.ConsumerMessage) (string, bool) {
+	clientID, ok := Header(message, HeaderClientID)
+	return clientID, ok && clientID != ""
+}
+
+// FirmwareVersionHeader builds a device firmware version header
+func FirmwareVersionHeader(version string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderFirmwareVersion), Value: []byte(version)}
+}
+
+// FirmwareVersion returns the firmware of the device a message comes from, if it said
+func FirmwareVersion(message *sarama.ConsumerMessage) (string, bool) {
+	version, ok := Header(message, HeaderFirmwareVersion)
+	return version, ok && version != ""
+}
+
+// DetectorColorHeader builds a detector deployment color header
+func DetectorColorHeader(color string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderDetectorColor), Value: []byte(color)}
+}
+
+// DetectorColor returns the color of the detector that raised an alert, if it said
+func DetectorColor(message *sarama.ConsumerMessage) (string, bool) {
+	color, ok := Header(message, HeaderDetectorColor)
+	return color, ok && color != ""
+}
+
+// SiteHeader builds a reading site header
+func SiteHeader(site string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderSite), Value: []byte(site)}
+}
+
+// ReceivedAtHeader builds an ingest receive time header
+func ReceivedAtHeader(at time.Time) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderReceivedAt), Value: []byte(strconv.FormatInt(at.UnixMilli(), 10))}
+}
+
+// ReceivedAt returns when an ingest gateway received a reading; messages
+// without a well-formed header report false
+func ReceivedAt(message *sarama.ConsumerMessage) (time.Time, bool) {
+	value, ok := Header(message, HeaderReceivedAt)
+	if !ok {
+		return time.Time{}, false
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
+}
+
+// FormatHeader builds a payload format header
+func FormatHeader(format string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderFormat), Value: []byte(format)}
+}
+
+// PayloadFormat returns the wire format a message's producer declared, or ""
+func PayloadFormat(message *sarama.ConsumerMessage) string {
+	format, _ := Header(message, HeaderFormat)
+	return format
+}
+
+// retryCountKey is the context key carrying how many times the message being
+// handled was retried
+type retryCountKey struct{}
+
+// ContextWithRetryCount returns a context carrying how many times the message
+// being handled was retried; consumers set it for every handler attempt
+func ContextWithRetryCount(ctx context.Context, count int) context.Context {
+	return context.WithValue(ctx, retryCountKey{}, count)
+}
+
+// RetryCountFromContext returns how many times the message being handled was
+// retried, zero on its first attempt
+func RetryCountFromContext(ctx context.Context) int {
+	count, _ := ctx.Value(retryCountKey{}).(int)
+	return count
+}
+
+// RetryCountHeader builds a retry count header
+func RetryCountHeader(count int) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderRetryCount), Value: []byte(strconv.Itoa(count))}
+}
+
+// RetryCount returns how many times a message was retried before it was
+// dead-lettered; malformed or missing headers count as zero
+func RetryCount(message *sarama.ConsumerMessage) int {
+	value, ok := Header(message, HeaderRetryCount)
+	if !ok {
+		return 0
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0
+	}
+	return count
+}
+
+// Origin is where a message was consumed from
+type Origin struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// String formats the origin as "topic/partition@offset"
+func (o Origin) String() string {
+	return fmt.Sprintf("%s/%d@%d", o.Topic, o.Partition, o.Offset)
+}
+
+// OriginHeaders records where a message was consumed from
+func OriginHeaders(message *sarama.ConsumerMessage) []sarama.RecordHeader {
+	return []sarama.RecordHeader{
+		{Key: []byte(HeaderOriginalTopic), Value: []byte(message.Topic)},
+		{Key: []byte(HeaderOriginalPartition), Value: []byte(strconv.FormatInt(int64(message.Partition), 10))},
+		{Key: []byte(HeaderOriginalOffset), Value: []byte(strconv.FormatInt(message.Offset, 10))},
+	}
+}
+
+// MessageOrigin returns where a dead-lettered message was originally consumed
+// from, falling back to the combined source header of messages dead-lettered
+// before the origin headers existed
+func MessageOrigin(message *sarama.ConsumerMessage) (Origin, bool) {
+	topic, hasTopic := Header(message, HeaderOriginalTopic)
+	partition, hasPartition := Header(message, HeaderOriginalPartition)
+	offset, hasOffset := Header(message, HeaderOriginalOffset)
+	if !hasTopic || !hasPartition || !hasOffset {
+		source, ok := Header(message, HeaderDLTSource)
+		if !ok {
+			return Origin{}, false
+		}
+		var found bool
+		if topic, source, found = cutLast(source, "/"); !found {
+			return Origin{}, false
+		}
+		if partition, offset, found = strings.Cut(source, "@"); !found {
+			return Origin{}, false
+		}
+	}
+
+	p, err := strconv.ParseInt(partition, 10, 32)
+	if err != nil {
+		return Origin{}, false
+	}
+	o, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return Origin{}, false
+	}
+	return Origin{Topic: topic, Partition: int32(p), Offset: o}, true
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
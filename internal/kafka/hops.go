@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Pipeline stages recorded in hop headers
+const (
+	HopProduced  = "produced"
+	HopConsumed  = "consumed"
+	HopDetected  = "detected"
+	HopPersisted = "persisted"
+)
+
+// Hop is one stage a message passed through and when
+type Hop struct {
+	Stage string
+	At    time.Time
+}
+
+// hopsKey is the context key carrying the hops of the message being handled
+type hopsKey struct{}
+
+// ContextWithHops returns a context carrying hops. Producers with hop headers
+// enabled copy them onto every message sent with the context, so a derived
+// message carries the full history of the message it was derived from.
+func ContextWithHops(ctx context.Context, hops []Hop) context.Context {
+	return context.WithValue(ctx, hopsKey{}, hops)
+}
+
+// HopsFromContext returns the hops carried by ctx
+func HopsFromContext(ctx context.Context) []Hop {
+	hops, _ := ctx.Value(hopsKey{}).([]Hop)
+	return hops
+}
+
+// AppendHop returns a context carrying the hops of ctx followed by stage at the given time
+func AppendHop(ctx context.Context, stage string, at time.Time) context.Context {
+	existing := HopsFromContext(ctx)
+	hops := make([]Hop, len(existing), len(existing)+1)
+	copy(hops, existing)
+	return ContextWithHops(ctx, append(hops, Hop{Stage: stage, At: at}))
+}
+
+// Hops returns the hops recorded in a message's headers in the order they were appended.
+// Malformed hop headers are skipped.
+func Hops(message *sarama.ConsumerMessage) []Hop {
+	var hops []Hop
+	for _, header := range message.Headers {
+		if header == nil || string(header.Key) != HeaderHop {
+			continue
+		}
+		if hop, err := parseHop(string(header.Value)); err == nil {
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// FormatHops renders hops with the latency accrued at each stage and in total
+func FormatHops(hops []Hop) string {
+	if len(hops) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, hop := range hops {
+		if i > 0 {
+			fmt.Fprintf(&b, " -> %s +%s", hop.Stage, hop.At.Sub(hops[i-1].At))
+		} else {
+			fmt.Fprintf(&b, "%s@%s", hop.Stage, hop.At.UTC().Format(time.RFC3339Nano))
+		}
+	}
+	fmt.Fprintf(&b, " (total %s)", hops[len(hops)-1].At.Sub(hops[0].At))
+	return b.String()
+}
+
+// hopHeaders builds one header per hop
+func hopHeaders(hops []Hop) []sarama.RecordHeader {
+	headers := make([]sarama.RecordHeader, 0, len(hops))
+	for _, hop := range hops {
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte(HeaderHop),
+			Value: []byte(hop.Stage + "=" + strconv.FormatInt(hop.At.UnixMilli(), 10)),
+		})
+	}
+	return headers
+}
+
+// parseHop parses a "stage=unix-millis" header value
+func parseHop(value string) (Hop, error) {
+	stage, millis, ok := strings.Cut(value, "=")
+	if !ok || stage == "" {
+		return Hop{}, fmt.Errorf("malformed hop %q", value)
+	}
+	at, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return Hop{}, fmt.Errorf("malformed hop %q: %w", value, err)
+	}
+	return Hop{Stage: stage, At: time.UnixMilli(at)}, nil
+}
@@ -0,0 +1,223 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// messageOverhead approximates the memory of a consumed message besides its
+// key, value and headers: the sarama.ConsumerMessage and its bookkeeping
+const messageOverhead = 256
+
+// InflightMetrics holds Prometheus metrics for in-flight message accounting
+type InflightMetrics struct {
+	Bytes             prometheus.Gauge
+	LimitBytes        prometheus.Gauge
+	BackpressureTotal prometheus.Counter
+	BackpressureTime  prometheus.Counter
+}
+
+// NewInflightMetrics creates a new set of in-flight accounting metrics
+func NewInflightMetrics(namespace, subsystem string, registry prometheus.Registerer) *InflightMetrics {
+	metrics := &InflightMetrics{
+		Bytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "inflight_bytes",
+			Help:      "Estimated memory held by consumed messages that are not yet handled, raw and decoded",
+		}),
+		LimitBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "inflight_limit_bytes",
+			Help:      "Cap on the in-flight bytes above which consumers stop taking messages",
+		}),
+		BackpressureTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "backpressure_total",
+			Help:      "Total number of times a consumer waited for in-flight bytes to be released",
+		}),
+		BackpressureTime: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "backpressure_seconds_total",
+			Help:      "Total time consumers waited for in-flight bytes to be released",
+		}),
+	}
+
+	registry.MustRegister(metrics.Bytes, metrics.LimitBytes, metrics.BackpressureTotal, metrics.BackpressureTime)
+
+	return metrics
+}
+
+// InflightBudget accounts the memory held by messages consumers took from
+// their claims but have not finished handling: the raw payloads, plus what
+// handlers report decoding them into with AddInflightBytes. Once the total
+// reaches the limit, consumers stop taking messages until handlers release
+// some. The claims' channels then fill and sarama stops fetching, so a slow
+// handler cannot make the process buffer messages until it runs out of
+// memory. A budget may be shared by several consumers to cap them together.
+type InflightBudget struct {
+	limit   int64
+	metrics *InflightMetrics
+
+	mu    sync.Mutex
+	used  int64
+	freed chan struct{}
+}
+
+// NewInflightBudget creates a budget of limit bytes. metrics may be nil.
+func NewInflightBudget(limit int64, metrics *InflightMetrics) *InflightBudget {
+	if metrics != nil {
+		metrics.LimitBytes.Set(float64(limit))
+	}
+	return &InflightBudget{limit: limit, metrics: metrics, freed: make(chan struct{})}
+}
+
+var (
+	sharedBudgetMu sync.Mutex
+	sharedBudget   *InflightBudget
+)
+
+// InflightBudgetFromConfig returns the budget of CONSUMER_MAX_INFLIGHT_BYTES,
+// shared by every consumer of the process that asks for it, or nil if the
+// cap is disabled. Its metrics are registered with the registry of the first
+// caller.
+func InflightBudgetFromConfig(cfg *config.Config, registry prometheus.Registerer) *InflightBudget {
+	if cfg.ConsumerMaxInflightBytes <= 0 {
+		return nil
+	}
+	sharedBudgetMu.Lock()
+	defer sharedBudgetMu.Unlock()
+	if sharedBudget == nil {
+		sharedBudget = NewInflightBudget(cfg.ConsumerMaxInflightBytes, NewInflightMetrics("iot", "consumer", registry))
+	}
+	return sharedBudget
+}
+
+// Used returns the bytes currently accounted
+func (b *InflightBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// acquire accounts n bytes, waiting while that would exceed the limit. A
+// message is let through when nothing else is in flight, however large, so
+// one message above the limit cannot stall the consumer. It returns false if
+// ctx is done first.
+func (b *InflightBudget) acquire(ctx context.Context, n int64) bool {
+	var waitStart time.Time
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.limit {
+			b.add(n)
+			b.mu.Unlock()
+			if !waitStart.IsZero() && b.metrics != nil {
+				b.metrics.BackpressureTime.Add(time.Since(waitStart).Seconds())
+			}
+			return true
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		if waitStart.IsZero() {
+			waitStart = time.Now()
+			if b.metrics != nil {
+				b.metrics.BackpressureTotal.Inc()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if b.metrics != nil {
+				b.metrics.BackpressureTime.Add(time.Since(waitStart).Seconds())
+			}
+			return false
+		case <-freed:
+		}
+	}
+}
+
+// grow accounts n more bytes without waiting
+func (b *InflightBudget) grow(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(n)
+}
+
+// release returns n bytes and wakes consumers waiting for them
+func (b *InflightBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(-n)
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// add changes the accounted bytes; b.mu must be held
+func (b *InflightBudget) add(n int64) {
+	b.used += n
+	if b.metrics != nil {
+		b.metrics.Bytes.Set(float64(b.used))
+	}
+}
+
+// inflightCharge is what one message holds of a budget
+type inflightCharge struct {
+	budget *InflightBudget
+	bytes  atomic.Int64
+}
+
+// inflightKey is the context key carrying the charge of the message being handled
+type inflightKey struct{}
+
+// AddInflightBytes accounts n more bytes to the message handled with ctx,
+// typically the structures its payload was decoded into, until the message
+// is done with. It never waits: the bytes only hold back the next messages.
+// It does nothing when the consumer has no budget.
+func AddInflightBytes(ctx context.Context, n int) {
+	charge, _ := ctx.Value(inflightKey{}).(*inflightCharge)
+	if charge == nil || n <= 0 {
+		return
+	}
+	charge.bytes.Add(int64(n))
+	charge.budget.grow(int64(n))
+}
+
+// acquireMessage accounts a consumed message, waiting for room in the budget;
+// it returns nil if ctx is done first
+func (b *InflightBudget) acquireMessage(ctx context.Context, msg *sarama.ConsumerMessage) *inflightCharge {
+	n := int64(messageOverhead + len(msg.Key) + len(msg.Value))
+	for _, header := range msg.Headers {
+		n += int64(len(header.Key) + len(header.Value))
+	}
+	if !b.acquire(ctx, n) {
+		return nil
+	}
+	charge := &inflightCharge{budget: b}
+	charge.bytes.Store(n)
+	return charge
+}
+
+// context returns ctx carrying the charge, so handlers can add to it
+func (c *inflightCharge) context(ctx context.Context) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, inflightKey{}, c)
+}
+
+// release returns everything the message held to the budget
+func (c *inflightCharge) release() {
+	if c == nil {
+		return
+	}
+	c.budget.release(c.bytes.Swap(0))
+}
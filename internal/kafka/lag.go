@@ -0,0 +1,364 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LagGroup is a consumer group and the topics whose lag is exported for it
+type LagGroup struct {
+	Group  string
+	Topics []string
+}
+
+// ParseLagGroups parses a spec of the form "group=topic|topic,group=topic"
+func ParseLagGroups(spec string) ([]LagGroup, error) {
+	var groups []LagGroup
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, topics, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(group) == "" || strings.TrimSpace(topics) == "" {
+			return nil, fmt.Errorf("invalid lag group %q, expected group=topic|topic", entry)
+		}
+
+		lagGroup := LagGroup{Group: strings.TrimSpace(group)}
+		for _, topic := range strings.Split(topics, "|") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				lagGroup.Topics = append(lagGroup.Topics, topic)
+			}
+		}
+		groups = append(groups, lagGroup)
+	}
+	return groups, nil
+}
+
+// LagGroupsFromConfig returns the groups listed in LAG_EXPORTER_GROUPS, or the
+// detector, sink and aggregator groups with the topics they consume when it is empty
+func LagGroupsFromConfig(cfg *config.Config) ([]LagGroup, error) {
+	if cfg.LagExporterGroups != "" {
+		return ParseLagGroups(cfg.LagExporterGroups)
+	}
+	return []LagGroup{
+		{Group: cfg.ConsumerGroupID, Topics: cfg.RawTopics()},
+		{Group: cfg.PostgresSinkGroupID, Topics: cfg.RawTopics()},
+		{Group: cfg.ESSinkGroupID, Topics: append(cfg.RawTopics(), cfg.Topic(config.TopicKeySensorAlert))},
+		{Group: cfg.ArchiveGroupID, Topics: cfg.RawTopics()},
+		{Group: cfg.AggregatorGroupID, Topics: cfg.RawTopics()},
+	}, nil
+}
+
+// LagMetrics holds consumer group lag gauges. Created with the "kafka"
+// namespace and "consumergroup" subsystem, the series are named like those of
+// kafka_exporter, so existing KEDA Prometheus triggers and dashboards work as is.
+type LagMetrics struct {
+	Lag          *prometheus.GaugeVec
+	LagSum       *prometheus.GaugeVec
+	ScrapeErrors prometheus.Counter
+}
+
+// NewLagMetrics creates a new set of lag metrics
+func NewLagMetrics(namespace, subsystem string, registry prometheus.Registerer) *LagMetrics {
+	metrics := &LagMetrics{
+		Lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "lag",
+			Help:      "Messages between the committed offset of the group and the high-water mark of each partition",
+		}, []string{"consumergroup", "topic", "partition"}),
+		LagSum: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "lag_sum",
+			Help:      "Lag of the group summed over the partitions of each topic",
+		}, []string{"consumergroup", "topic"}),
+		ScrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "lag_scrape_errors_total",
+			Help:      "Total number of failed consumer group lag scrapes",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Lag,
+		metrics.LagSum,
+		metrics.ScrapeErrors,
+	)
+
+	return metrics
+}
+
+// PartitionLag is the lag of a group on one partition
+type PartitionLag struct {
+	Partition int32 `json:"partition"`
+	// CurrentOffset is the committed offset, or -1 if the group has none
+	CurrentOffset int64 `json:"current_offset"`
+	HighWatermark int64 `json:"high_watermark"`
+	Lag           int64 `json:"lag"`
+}
+
+// TopicLag is the lag of a group on one topic
+type TopicLag struct {
+	ConsumerGroup string         `json:"consumergroup"`
+	Topic         string         `json:"topic"`
+	Lag           int64          `json:"lag"`
+	Partitions    []PartitionLag `json:"partitions"`
+	ScrapedAt     time.Time      `json:"scraped_at"`
+}
+
+// LagExporter periodically computes the lag of consumer groups from their
+// committed offsets and the partition high-water marks. The lag is read from
+// the brokers rather than from the consumers, so it stays available while a
+// group has no members, which is what lets an autoscaler scale it to zero and
+// back. It publishes the lag as Prometheus metrics and serves it as JSON.
+type LagExporter struct {
+	client   sarama.Client
+	admin    sarama.ClusterAdmin
+	groups   []LagGroup
+	interval time.Duration
+	// fromOldest counts partitions without a committed offset from the oldest
+	// offset, as a group would start consuming them; otherwise they have no lag
+	fromOldest bool
+	metrics    *LagMetrics
+	logger     *slog.Logger
+
+	mu   sync.RWMutex
+	lags []TopicLag
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewLagExporter creates a new lag exporter for the given groups. offsetInitial
+// is the initial offset of the groups (sarama.OffsetOldest or OffsetNewest).
+func NewLagExporter(brokers []string, groups []LagGroup, interval time.Duration, offsetInitial int64, metrics *LagMetrics, logger *slog.Logger, opts ...OptionFunc) (*LagExporter, error) {
+	config := sarama.NewConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create Kafka cluster admin: %w", err)
+	}
+
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &LagExporter{
+		client:     client,
+		admin:      admin,
+		groups:     groups,
+		interval:   interval,
+		fromOldest: offsetInitial == sarama.OffsetOldest,
+		metrics:    metrics,
+		logger:     logging.OrDefault(logger),
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// Start begins computing lag every interval
+func (e *LagExporter) Start() {
+	e.wg.Add(1)
+	go e.run()
+}
+
+// Stop stops computing lag and closes the admin connection
+func (e *LagExporter) Stop() {
+	e.cancel()
+	e.wg.Wait()
+	// Closing the admin also closes the underlying client
+	if err := e.admin.Close(); err != nil {
+		e.logger.Error("Failed to close Kafka cluster admin", "error", err)
+	}
+}
+
+// Lags returns the lag computed by the last scrape
+func (e *LagExporter) Lags() []TopicLag {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lags
+}
+
+// ServeHTTP serves the last computed lag as JSON. With group and topic query
+// parameters it serves that one topic lag, whose top-level "lag" field is what
+// a KEDA metrics-api trigger reads; otherwise it serves every lag matching the
+// given parameters.
+func (e *LagExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	group := r.URL.Query().Get("group")
+	topic := r.URL.Query().Get("topic")
+
+	var matches []TopicLag
+	for _, lag := range e.Lags() {
+		if (group == "" || lag.ConsumerGroup == group) && (topic == "" || lag.Topic == topic) {
+			matches = append(matches, lag)
+		}
+	}
+
+	var body interface{} = matches
+	if group != "" && topic != "" {
+		if len(matches) == 0 {
+			http.Error(w, "unknown consumer group or topic", http.StatusNotFound)
+			return
+		}
+		body = matches[0]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		e.logger.Warn("Failed to encode consumer group lag", "error", err)
+	}
+}
+
+// run scrapes the lag on every tick
+func (e *LagExporter) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	e.scrape()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			e.scrape()
+		}
+	}
+}
+
+// scrape computes the lag of every group and replaces the published values.
+// A group that fails keeps its previous values.
+func (e *LagExporter) scrape() {
+	now := time.Now()
+	previous := make(map[string][]TopicLag)
+	for _, lag := range e.Lags() {
+		previous[lag.ConsumerGroup] = append(previous[lag.ConsumerGroup], lag)
+	}
+
+	var lags []TopicLag
+	for _, group := range e.groups {
+		groupLags, err := e.groupLag(group, now)
+		if err != nil {
+			e.logger.Warn("Failed to compute consumer group lag", "group", group.Group, "error", err)
+			if e.metrics != nil {
+				e.metrics.ScrapeErrors.Inc()
+			}
+			lags = append(lags, previous[group.Group]...)
+			continue
+		}
+		lags = append(lags, groupLags...)
+	}
+
+	if e.metrics != nil {
+		e.metrics.Lag.Reset()
+		e.metrics.LagSum.Reset()
+		for _, lag := range lags {
+			e.metrics.LagSum.WithLabelValues(lag.ConsumerGroup, lag.Topic).Set(float64(lag.Lag))
+			for _, partition := range lag.Partitions {
+				e.metrics.Lag.WithLabelValues(lag.ConsumerGroup, lag.Topic, strconv.FormatInt(int64(partition.Partition), 10)).Set(float64(partition.Lag))
+			}
+		}
+	}
+
+	e.mu.Lock()
+	e.lags = lags
+	e.mu.Unlock()
+}
+
+// groupLag computes the lag of one group on each of its topics
+func (e *LagExporter) groupLag(group LagGroup, now time.Time) ([]TopicLag, error) {
+	partitions := make(map[string][]int32, len(group.Topics))
+	for _, topic := range group.Topics {
+		ids, err := e.client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+		}
+		partitions[topic] = ids
+	}
+
+	offsets, err := e.admin.ListConsumerGroupOffsets(group.Group, partitions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list committed offsets: %w", err)
+	}
+
+	lags := make([]TopicLag, 0, len(group.Topics))
+	for _, topic := range group.Topics {
+		topicLag := TopicLag{ConsumerGroup: group.Group, Topic: topic, ScrapedAt: now}
+		for _, partition := range partitions[topic] {
+			partitionLag, err := partitionLag(e.client, offsets, topic, partition, e.fromOldest)
+			if err != nil {
+				return nil, err
+			}
+			topicLag.Lag += partitionLag.Lag
+			topicLag.Partitions = append(topicLag.Partitions, partitionLag)
+		}
+		sort.Slice(topicLag.Partitions, func(i, j int) bool {
+			return topicLag.Partitions[i].Partition < topicLag.Partitions[j].Partition
+		})
+		lags = append(lags, topicLag)
+	}
+	return lags, nil
+}
+
+// partitionLag computes the lag of a group on one partition from its
+// committed offsets. fromOldest counts a partition without a committed offset
+// from its oldest offset; otherwise it has no lag.
+func partitionLag(client sarama.Client, offsets *sarama.OffsetFetchResponse, topic string, partition int32, fromOldest bool) (PartitionLag, error) {
+	highWatermark, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return PartitionLag{}, fmt.Errorf("failed to get offset for %s/%d: %w", topic, partition, err)
+	}
+
+	current := int64(-1)
+	if block := offsets.GetBlock(topic, partition); block != nil {
+		if block.Err != sarama.ErrNoError {
+			return PartitionLag{}, fmt.Errorf("failed to get committed offset for %s/%d: %w", topic, partition, block.Err)
+		}
+		current = block.Offset
+	}
+
+	lag := PartitionLag{Partition: partition, CurrentOffset: current, HighWatermark: highWatermark}
+	switch {
+	case current >= 0:
+		lag.Lag = highWatermark - current
+	case fromOldest:
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return PartitionLag{}, fmt.Errorf("failed to get offset for %s/%d: %w", topic, partition, err)
+		}
+		lag.Lag = highWatermark - oldest
+	}
+	if lag.Lag < 0 {
+		lag.Lag = 0
+	}
+	return lag, nil
+}
@@ -0,0 +1,9 @@
+package kafka
+
+import "github.com/IBM/sarama"
+
+// MessageLogAttrs returns the topic, partition and offset of a message as
+// log attributes, for use with slog.Logger.With
+func MessageLogAttrs(message *sarama.ConsumerMessage) []any {
+	return []any{"topic", message.Topic, "partition", message.Partition, "offset", message.Offset}
+}
@@ -0,0 +1,82 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+// saramaLogger adapts sarama.StdLogger onto a structured slog.Logger.
+// Sarama's messages are unleveled, so the level is derived from the message content.
+type saramaLogger struct {
+	logger *slog.Logger
+	level  slog.Level
+	// threshold drops sarama's messages below it, whatever the service logs
+	threshold slog.Level
+}
+
+// Print implements sarama.StdLogger
+func (l *saramaLogger) Print(v ...interface{}) {
+	l.log(fmt.Sprint(v...))
+}
+
+// Printf implements sarama.StdLogger
+func (l *saramaLogger) Printf(format string, v ...interface{}) {
+	l.log(fmt.Sprintf(format, v...))
+}
+
+// Println implements sarama.StdLogger
+func (l *saramaLogger) Println(v ...interface{}) {
+	l.log(fmt.Sprintln(v...))
+}
+
+func (l *saramaLogger) log(msg string) {
+	msg = strings.TrimSpace(msg)
+	level := l.level
+	if level < slog.LevelWarn && looksLikeError(msg) {
+		level = slog.LevelWarn
+	}
+	if level < l.threshold {
+		return
+	}
+	l.logger.Log(context.Background(), level, msg)
+}
+
+// looksLikeError reports whether a sarama message describes a failure
+func looksLikeError(msg string) bool {
+	lower := strings.ToLower(msg)
+	for _, keyword := range []string{"error", "failed", "unable", "closed unexpectedly", "timeout"} {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigureSaramaLogging routes sarama's internal loggers into logger.
+// Messages below level are dropped; level "off" silences sarama entirely.
+// Regular sarama messages are logged at info (warn when they describe a failure),
+// and sarama.DebugLogger output at debug.
+func ConfigureSaramaLogging(logger *slog.Logger, level string) error {
+	threshold, enabled, err := logging.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		sarama.Logger = log.New(io.Discard, "", 0)
+		sarama.DebugLogger = log.New(io.Discard, "", 0)
+		return nil
+	}
+
+	logger = logging.OrDefault(logger).With("component", "sarama")
+	sarama.Logger = &saramaLogger{logger: logger, level: slog.LevelInfo, threshold: threshold}
+	sarama.DebugLogger = &saramaLogger{logger: logger, level: slog.LevelDebug, threshold: threshold}
+	return nil
+}
@@ -0,0 +1,60 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// claimOffsets marks the offsets of a claim whose messages are handled by
+// concurrent workers. An offset is marked only once every message taken
+// before it from the claim was handled, so a message cancelled or still in
+// flight holds back the committed offset and is redelivered, with the
+// messages after it, to the next owner of the partition.
+type claimOffsets struct {
+	session   sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+
+	mu sync.Mutex
+	// pending holds the offsets taken from the claim and not yet marked, in
+	// order, and handled those of them that were handled
+	pending []int64
+	handled map[int64]bool
+}
+
+// newClaimOffsets creates the offset tracker of a claim
+func newClaimOffsets(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) *claimOffsets {
+	return &claimOffsets{
+		session:   session,
+		topic:     claim.Topic(),
+		partition: claim.Partition(),
+		handled:   make(map[int64]bool),
+	}
+}
+
+// taken records a message taken from the claim, before it is handed to a
+// worker
+func (o *claimOffsets) taken(offset int64) {
+	o.mu.Lock()
+	o.pending = append(o.pending, offset)
+	o.mu.Unlock()
+}
+
+// done records a handled message and marks the offset after the longest
+// run of handled messages from the lowest pending one
+func (o *claimOffsets) done(offset int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.handled[offset] = true
+	next := int64(-1)
+	for len(o.pending) > 0 && o.handled[o.pending[0]] {
+		delete(o.handled, o.pending[0])
+		next = o.pending[0] + 1
+		o.pending = o.pending[1:]
+	}
+	if next >= 0 {
+		o.session.MarkOffset(o.topic, o.partition, next, "")
+	}
+}
@@ -1,6 +1,7 @@
 package kafka
 
 import (
+	"crypto/tls"
 	"github.com/IBM/sarama"
 	"time"
 )
@@ -38,6 +39,45 @@ func WithProducerRequiredAcks(requiredAcks int) OptionFunc {
 	}
 }
 
+// WithIdempotence makes the producer write every message once per partition
+// even when it retries. Idempotence requires acknowledgements from all
+// in-sync replicas and one request in flight per broker, which it sets.
+func WithIdempotence() OptionFunc {
+	return func(config *sarama.Config) {
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Net.MaxOpenRequests = 1
+		if config.Producer.Retry.Max < 1 {
+			config.Producer.Retry.Max = 1
+		}
+	}
+}
+
+// WithPartitioner sets how the producer picks the partition of a message
+func WithPartitioner(partitioner sarama.PartitionerConstructor) OptionFunc {
+	return func(config *sarama.Config) {
+		config.Producer.Partitioner = partitioner
+	}
+}
+
+// WithTransactionalID makes the producer transactional, which implies
+// idempotence. The ID must stay the same across restarts so that a new
+// producer fences off its predecessor, and differ between producers that
+// may be alive at the same time.
+func WithTransactionalID(id string) OptionFunc {
+	return func(config *sarama.Config) {
+		WithIdempotence()(config)
+		config.Producer.Transaction.ID = id
+	}
+}
+
+// WithClientID names the client to the brokers, in their logs and quotas
+func WithClientID(clientID string) OptionFunc {
+	return func(config *sarama.Config) {
+		config.ClientID = clientID
+	}
+}
+
 // Consumer options
 
 // WithConsumerReturnErrors configures the consumer to return errors
@@ -61,6 +101,13 @@ func WithConsumerOffsetsInitial(offset int64) OptionFunc {
 	}
 }
 
+// WithConsumerAutoCommit enables or disables committing marked offsets periodically
+func WithConsumerAutoCommit(enabled bool) OptionFunc {
+	return func(config *sarama.Config) {
+		config.Consumer.Offsets.AutoCommit.Enable = enabled
+	}
+}
+
 // General options
 
 // WithKafkaVersion sets the Kafka version
@@ -76,6 +123,28 @@ func WithKafkaVersion(version string) OptionFunc {
 	}
 }
 
+// WithTLS encrypts broker connections with the given TLS configuration
+func WithTLS(tlsConfig *tls.Config) OptionFunc {
+	return func(config *sarama.Config) {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+}
+
+// WithSASL authenticates with the given SASL mechanism and credentials
+func WithSASL(mechanism sarama.SASLMechanism, username, password string) OptionFunc {
+	return func(config *sarama.Config) {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = mechanism
+		config.Net.SASL.User = username
+		config.Net.SASL.Password = password
+		config.Net.SASL.Handshake = true
+		if mechanism == sarama.SASLTypeSCRAMSHA256 || mechanism == sarama.SASLTypeSCRAMSHA512 {
+			config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(mechanism)
+		}
+	}
+}
+
 // GetBalanceStrategy returns the appropriate balance strategy based on the string name
 func GetBalanceStrategy(strategyName string) sarama.BalanceStrategy {
 	switch strategyName {
@@ -85,6 +154,8 @@ func GetBalanceStrategy(strategyName string) sarama.BalanceStrategy {
 		return sarama.BalanceStrategyRoundRobin
 	case "sticky":
 		return sarama.BalanceStrategySticky
+	case BalanceStrategySiteAffinity:
+		return NewSiteAffinityStrategy(nil)
 	default:
 		return sarama.BalanceStrategyRange // Default to range strategy
 	}
@@ -0,0 +1,38 @@
+package kafka
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// pingTimeout bounds connecting to a broker when checking reachability
+const pingTimeout = 5 * time.Second
+
+// Ping connects to the cluster and fetches its metadata, returning an error
+// if no broker is reachable
+func Ping(brokers []string, version string, security SecurityConfig) error {
+	config := sarama.NewConfig()
+	config.Net.DialTimeout = pingTimeout
+	config.Net.ReadTimeout = pingTimeout
+	config.Net.WriteTimeout = pingTimeout
+	config.Metadata.Retry.Max = 0
+	if version != "" {
+		WithKafkaVersion(version)(config)
+	}
+
+	opts, err := security.Options()
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kafka: %w", err)
+	}
+	return client.Close()
+}
@@ -0,0 +1,136 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// workerScheduler hands the workers of a consumer to the messages of its
+// topics by weight. While workers are free, messages take them in the order
+// they arrive. Once every worker is busy, the messages waiting for one queue
+// by topic, and each worker freed goes to a topic in proportion to its weight,
+// so a topic of weight 4 gets four workers for every one of a topic of weight
+// 1 for as long as both have messages waiting. Claims of the lighter topic
+// take fewer messages, their channels fill and sarama fetches less of them.
+type workerScheduler struct {
+	weights  map[string]int
+	waitTime *prometheus.CounterVec
+
+	mu      sync.Mutex
+	free    int
+	waiting map[string][]chan struct{}
+	// current holds the smooth weighted round-robin credit of each topic
+	// with messages waiting
+	current map[string]int
+}
+
+// newWorkerScheduler creates a scheduler of workers workers. Topics missing
+// from weights weigh 1. waitTime may be nil.
+func newWorkerScheduler(workers int, weights map[string]int, waitTime *prometheus.CounterVec) *workerScheduler {
+	return &workerScheduler{
+		weights:  weights,
+		waitTime: waitTime,
+		free:     workers,
+		waiting:  make(map[string][]chan struct{}),
+		current:  make(map[string]int),
+	}
+}
+
+// weight returns the weight of topic
+func (s *workerScheduler) weight(topic string) int {
+	if weight, ok := s.weights[topic]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// acquire takes a worker for a message of topic, waiting for its turn when
+// none is free. It returns false if ctx is done first.
+func (s *workerScheduler) acquire(ctx context.Context, topic string) bool {
+	s.mu.Lock()
+	if s.free > 0 && len(s.waiting) == 0 {
+		s.free--
+		s.mu.Unlock()
+		return true
+	}
+	granted := make(chan struct{})
+	s.waiting[topic] = append(s.waiting[topic], granted)
+	s.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		if s.waitTime != nil {
+			s.waitTime.WithLabelValues(topic).Add(time.Since(start).Seconds())
+		}
+	}()
+
+	select {
+	case <-granted:
+		return true
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-granted:
+		// The worker was handed over as ctx was done; pass it on
+		s.handOver()
+	default:
+		s.dequeue(topic, granted)
+	}
+	return false
+}
+
+// release frees a worker, handing it to the next waiting message if any
+func (s *workerScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handOver()
+}
+
+// handOver gives a freed worker to the topic whose turn it is, or returns it
+// to the free workers when no message waits. Topics are picked by smooth
+// weighted round-robin: each waiting topic gains its weight, the richest is
+// picked and pays back the total, which interleaves the turns evenly.
+func (s *workerScheduler) handOver() {
+	if len(s.waiting) == 0 {
+		s.free++
+		return
+	}
+	var next string
+	total := 0
+	for topic := range s.waiting {
+		weight := s.weight(topic)
+		total += weight
+		s.current[topic] += weight
+		if next == "" || s.current[topic] > s.current[next] || (s.current[topic] == s.current[next] && topic < next) {
+			next = topic
+		}
+	}
+	s.current[next] -= total
+
+	queue := s.waiting[next]
+	close(queue[0])
+	s.dequeue(next, queue[0])
+}
+
+// dequeue removes a waiting message from the queue of topic
+func (s *workerScheduler) dequeue(topic string, granted chan struct{}) {
+	queue := s.waiting[topic]
+	for i, waiting := range queue {
+		if waiting == granted {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(s.waiting, topic)
+		delete(s.current, topic)
+		return
+	}
+	s.waiting[topic] = queue
+}
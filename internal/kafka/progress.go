@@ -0,0 +1,109 @@
+package kafka
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// PartitionPosition is how far a consumer got in one of its partitions
+type PartitionPosition struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Offset is the next offset to handle: the claim's initial offset until
+	// a message is handled, which is sarama.OffsetNewest or OffsetOldest when
+	// the group has not committed one
+	Offset int64 `json:"offset"`
+}
+
+// ProgressSnapshot is a consumer's assignment and progress at one time
+type ProgressSnapshot struct {
+	// Topics are the topics the consumer subscribes to
+	Topics     []string
+	Generation int32
+	Partitions []PartitionPosition
+	// Handled counts every message handled since the consumer was created
+	Handled int64
+}
+
+// PartitionProgress tracks the partitions a consumer owns and the offsets it
+// handled, so a replica can report its work, e.g. in heartbeats, without
+// anyone asking the group coordinator
+type PartitionProgress struct {
+	mu         sync.Mutex
+	topics     []string
+	generation int32
+	offsets    map[topicPartition]int64
+	handled    int64
+}
+
+// NewPartitionProgress creates an empty progress tracker
+func NewPartitionProgress() *PartitionProgress {
+	return &PartitionProgress{offsets: make(map[topicPartition]int64)}
+}
+
+// Snapshot returns the current assignment and progress, sorted by topic and
+// partition
+func (p *PartitionProgress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshot := ProgressSnapshot{
+		Topics:     append([]string(nil), p.topics...),
+		Generation: p.generation,
+		Partitions: make([]PartitionPosition, 0, len(p.offsets)),
+		Handled:    p.handled,
+	}
+	for tp, offset := range p.offsets {
+		snapshot.Partitions = append(snapshot.Partitions, PartitionPosition{Topic: tp.topic, Partition: tp.partition, Offset: offset})
+	}
+	sort.Slice(snapshot.Partitions, func(i, j int) bool {
+		a, b := snapshot.Partitions[i], snapshot.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+	return snapshot
+}
+
+// setTopics records the subscribed topics
+func (p *PartitionProgress) setTopics(topics []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.topics = append([]string(nil), topics...)
+}
+
+// setGeneration records the generation of a new session. Its partitions are
+// added as their claims start.
+func (p *PartitionProgress) setGeneration(generation int32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.generation = generation
+}
+
+// claimed records a partition whose claim starts at offset
+func (p *PartitionProgress) claimed(topic string, partition int32, offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.offsets[topicPartition{topic, partition}] = offset
+}
+
+// released forgets every partition at the end of a session
+func (p *PartitionProgress) released() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.offsets = make(map[topicPartition]int64)
+}
+
+// handledMessage records a message handled, or skipped after its last attempt
+func (p *PartitionProgress) handledMessage(msg *sarama.ConsumerMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handled++
+	tp := topicPartition{msg.Topic, msg.Partition}
+	if offset, ok := p.offsets[tp]; ok && msg.Offset+1 > offset {
+		p.offsets[tp] = msg.Offset + 1
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"github.com/IBM/sarama"
-	"log"
-	"math/rand"
+	"log/slog"
+	"sync"
 	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/tracing"
 )
 
-// IPublisher defines the interface for a Kafka publisher
+// IPublisher defines the interface for a Kafka publisher. Messages carry the
+// given headers after those of the context (see ContextWithHeaders).
 type IPublisher interface {
-	Publish(ctx context.Context, key, value []byte) error
+	// Publish sends a message to the publisher's topic
+	Publish(ctx context.Context, key, value []byte, headers ...sarama.RecordHeader) error
+	// PublishToTopic sends a message to any topic over the same connections
+	PublishToTopic(ctx context.Context, topic string, key, value []byte, headers ...sarama.RecordHeader) error
 	Stop()
 }
 
@@ -21,10 +27,41 @@ type kafkaPublisher struct {
 	topic    string
 	producer sarama.SyncProducer
 	config   *sarama.Config
+
+	// clockHeaders embeds the client send time in every message
+	clockHeaders bool
+
+	// hopHeaders copies the context's hops plus a produced hop onto every message
+	hopHeaders bool
+
+	// clientID is sent in the client ID header of messages without one
+	clientID string
+
+	// mu keeps Stop from closing the producer under an in-progress send,
+	// which sarama does not allow
+	mu     sync.RWMutex
+	closed bool
+
+	// retry bounds the attempts at each send
+	retry RetryPolicy
+
+	// release returns a shared client once the producer is closed (nil when
+	// the producer owns its client)
+	release func()
+
+	logger *slog.Logger
 }
 
 // NewKafkaPublisher creates a new Kafka publisher
 func NewKafkaPublisher(brokers []string, topic string, opts ...OptionFunc) (IPublisher, error) {
+	return newKafkaPublisher("", brokers, topic, opts...)
+}
+
+// newKafkaPublisher creates a new Kafka publisher, returning the concrete type so
+// that wrappers in this package can attach optional behaviour. Unless share
+// is empty, the publisher uses the shared client of its settings when clients
+// are shared (see acquireClient).
+func newKafkaPublisher(share string, brokers []string, topic string, opts ...OptionFunc) (*kafkaPublisher, error) {
 	config := sarama.NewConfig()
 	config.Producer.RequiredAcks = sarama.RequiredAcks(DefaultRequiredAcks)
 	config.Producer.Return.Successes = DefaultProducerReturnSucc
@@ -34,9 +71,22 @@ func NewKafkaPublisher(brokers []string, topic string, opts ...OptionFunc) (IPub
 		o(config)
 	}
 
-	// Create producer
-	producer, err := sarama.NewSyncProducer(brokers, config)
+	// Create producer, on the shared client if there is one
+	client, release, err := acquireClient(share, brokers, config)
 	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	var producer sarama.SyncProducer
+	if client != nil {
+		config = client.Config()
+		producer, err = sarama.NewSyncProducerFromClient(client)
+	} else {
+		producer, err = sarama.NewSyncProducer(brokers, config)
+	}
+	if err != nil {
+		if release != nil {
+			release()
+		}
 		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
 	}
 
@@ -45,56 +95,111 @@ func NewKafkaPublisher(brokers []string, topic string, opts ...OptionFunc) (IPub
 		topic:    topic,
 		producer: producer,
 		config:   config,
+		release:  release,
+		logger:   slog.Default(),
 	}, nil
 }
 
-// Publish sends a message to Kafka with retry logic
-func (p *kafkaPublisher) Publish(ctx context.Context, key, value []byte) error {
+// Publish sends a message to the publisher's topic with retry logic
+func (p *kafkaPublisher) Publish(ctx context.Context, key, value []byte, headers ...sarama.RecordHeader) error {
+	return p.PublishToTopic(ctx, p.topic, key, value, headers...)
+}
+
+// PublishToTopic sends a message to topic with retry logic. The sarama
+// producer is not tied to a topic, so one publisher can fan out to any
+// number of topics. The trace ID of ctx is added unless the message already
+// has a trace ID header, and the trace context of the publish span, a child
+// of the span of ctx, replaces any traceparent header. A nil value is sent
+// as a null payload.
+func (p *kafkaPublisher) PublishToTopic(ctx context.Context, topic string, key, value []byte, headers ...sarama.RecordHeader) error {
+	if topic == "" {
+		return fmt.Errorf("no topic to publish to")
+	}
 	msg := &sarama.ProducerMessage{
-		Topic: p.topic,
+		Topic: topic,
 		Key:   sarama.ByteEncoder(key),
-		Value: sarama.ByteEncoder(value),
+	}
+	if value != nil {
+		msg.Value = sarama.ByteEncoder(value)
+	}
+	if p.clockHeaders {
+		msg.Headers = append(msg.Headers, sentAtHeader(time.Now()))
+	}
+	if p.hopHeaders {
+		msg.Headers = append(msg.Headers, hopHeaders(HopsFromContext(AppendHop(ctx, HopProduced, time.Now())))...)
+	}
+	msg.Headers = append(msg.Headers, HeadersFromContext(ctx)...)
+	msg.Headers = append(msg.Headers, headers...)
+	if traceID, ok := TraceIDFromContext(ctx); ok && !hasHeader(msg.Headers, HeaderTraceID) {
+		msg.Headers = append(msg.Headers, TraceIDHeader(traceID))
+	}
+	if p.clientID != "" && !hasHeader(msg.Headers, HeaderClientID) {
+		msg.Headers = append(msg.Headers, ClientIDHeader(p.clientID))
 	}
 
-	// Simple retry mechanism with exponential backoff
-	maxRetries := 3
-	maxWait := 2 * time.Minute
-	deadline := time.Now().Add(maxWait)
+	ctx, span := startPublishSpan(ctx, msg)
+	err := p.sendWithRetries(ctx, msg)
+	tracing.End(span, err)
+	return err
+}
+
+// sendWithRetries sends a message, retrying with exponential backoff under
+// the publisher's retry policy
+func (p *kafkaPublisher) sendWithRetries(ctx context.Context, msg *sarama.ProducerMessage) error {
+	retry := p.retry.orDefault()
+	deadline := retry.deadline(time.Now())
 
 	var lastErr error
-	for i := 0; i < maxRetries; i++ {
+	for i := 0; i < retry.MaxAttempts; i++ {
 		// Check if context is done
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Try to send the message
+		err := p.send(msg)
+		if err == nil {
+			return nil // Success
+		}
+
+		lastErr = err
+		if i == retry.MaxAttempts-1 || retry.expired(deadline, time.Now()) {
+			break
+		}
+
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		default:
-			// Try to send the message
-			_, _, err := p.producer.SendMessage(msg)
-			if err == nil {
-				return nil // Success
-			}
-
-			lastErr = err
-			if time.Now().After(deadline) {
-				break
-			}
-
-			backoffTime := time.Duration(100*(1<<i)) * time.Millisecond
-			jitter := time.Duration(float64(backoffTime) * (0.8 + 0.4*rand.Float64()))
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(jitter):
-			}
+		case <-time.After(retry.backoff(i)):
 		}
 	}
 
 	return fmt.Errorf("failed to publish message after retries: %w", lastErr)
 }
 
-// Stop closes the producer
+// send sends one message unless the producer is closed
+func (p *kafkaPublisher) send(msg *sarama.ProducerMessage) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return sarama.ErrClosedClient
+	}
+	_, _, err := p.producer.SendMessage(msg)
+	return err
+}
+
+// Stop waits for in-progress sends, flushes and closes the producer
 func (p *kafkaPublisher) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
 	if err := p.producer.Close(); err != nil {
-		log.Printf("Failed to close Kafka producer: %v", err)
+		p.logger.Error("Failed to close Kafka producer", "error", err)
+	}
+	if p.release != nil {
+		p.release()
 	}
 }
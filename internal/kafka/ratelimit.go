@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ProducerOption configures a producer beyond its ProducerConfig
+type ProducerOption func(*Producer)
+
+// WithMaxMessagesPerSecond caps the messages the producer sends per second,
+// up to a second's worth at once; sends beyond it wait (0 is unlimited)
+func WithMaxMessagesPerSecond(rate float64) ProducerOption {
+	return func(p *Producer) {
+		p.messageLimit = newTokenBucket(rate)
+	}
+}
+
+// WithMaxBytesPerSecond caps the value bytes the producer sends per second,
+// up to a second's worth at once; sends beyond it wait (0 is unlimited). A
+// message larger than a second's worth is sent once the bucket is full and
+// delays the following ones.
+func WithMaxBytesPerSecond(rate float64) ProducerOption {
+	return func(p *Producer) {
+		p.byteLimit = newTokenBucket(rate)
+	}
+}
+
+// tokenBucket admits rate tokens per second, up to burst at once. Takers
+// reserve their tokens at once, which may leave the bucket in debt, and wait
+// until the debt would have refilled, so waiting takers are served in order.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket of a second's worth of tokens, or nil
+// when rate is not positive
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait takes n tokens, waiting until they are due or ctx is done, in which
+// case the tokens are returned. A nil bucket never waits.
+func (b *tokenBucket) wait(ctx context.Context, n float64) (time.Duration, error) {
+	if b == nil {
+		return 0, nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	// A take larger than the bucket waits only for the bucket to fill; the
+	// rest of its debt delays the takes after it
+	b.tokens -= n
+	delay := time.Duration(-(b.tokens + n - min(n, b.burst)) / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += n
+		b.mu.Unlock()
+		return 0, ctx.Err()
+	case <-timer.C:
+		return delay, nil
+	}
+}
@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+)
+
+// RetryPolicy bounds the attempts at sending a message or handling a
+// consumed one. The zero value uses DefaultRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, the first one included
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt, doubled
+	// after each further one up to MaxBackoff (0 MaxBackoff does not cap it)
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter spreads each wait by up to this fraction either way (0.2 is ±20%)
+	Jitter float64
+	// Deadline stops retrying once this long has passed since the first
+	// attempt (0 disables)
+	Deadline time.Duration
+}
+
+// DefaultRetryPolicy makes 3 attempts, waiting 100ms then 200ms ±20%, for
+// at most 2 minutes
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Jitter:         0.2,
+	Deadline:       2 * time.Minute,
+}
+
+// ProducerRetryFromConfig returns the retry policy of the sends of component's producers
+func ProducerRetryFromConfig(cfg *config.Config, component string) RetryPolicy {
+	return retryPolicy(cfg.ProducerRetryFor(component))
+}
+
+// ConsumerRetryFromConfig returns the retry policy of the handler attempts of component's consumers
+func ConsumerRetryFromConfig(cfg *config.Config, component string) RetryPolicy {
+	return retryPolicy(cfg.ConsumerRetryFor(component))
+}
+
+// retryPolicy converts retry settings of the application configuration
+func retryPolicy(retry config.RetryConfig) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    retry.MaxAttempts,
+		InitialBackoff: retry.InitialBackoff,
+		MaxBackoff:     retry.MaxBackoff,
+		Jitter:         retry.Jitter,
+		Deadline:       retry.Deadline,
+	}
+}
+
+// orDefault returns the policy, or DefaultRetryPolicy for the zero value.
+// A policy always makes at least one attempt.
+func (p RetryPolicy) orDefault() RetryPolicy {
+	if p == (RetryPolicy{}) {
+		return DefaultRetryPolicy
+	}
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	return p
+}
+
+// deadline returns when retrying stops for attempts starting at start, or
+// the zero time if it never does
+func (p RetryPolicy) deadline(start time.Time) time.Time {
+	if p.Deadline <= 0 {
+		return time.Time{}
+	}
+	return start.Add(p.Deadline)
+}
+
+// expired reports whether no attempt should follow one failing at now
+func (p RetryPolicy) expired(deadline, now time.Time) bool {
+	return !deadline.IsZero() && now.After(deadline)
+}
+
+// backoff returns the jittered wait after the failed attempt with index attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 0; i < attempt; i++ {
+		if (p.MaxBackoff > 0 && wait >= p.MaxBackoff) || wait > math.MaxInt64/2 {
+			break
+		}
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait = time.Duration(float64(wait) * (1 - p.Jitter + 2*p.Jitter*rand.Float64()))
+	}
+	return wait
+}
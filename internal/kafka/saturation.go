@@ -0,0 +1,333 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SaturationConfig configures a saturation monitor
+type SaturationConfig struct {
+	// Window is the interval over which rates and utilization are computed
+	Window time.Duration
+	// PartitionCapacity is the message rate one partition can be handled at,
+	// in messages per second (0 leaves partitions out of the saturation)
+	PartitionCapacity float64
+	// TargetUtilization is the saturation replicas should be scaled to, e.g. 0.7
+	TargetUtilization float64
+	// Logger receives the monitor's logs (nil uses the default logger)
+	Logger *slog.Logger
+}
+
+// SaturationMetrics holds Prometheus metrics derived by a saturation monitor
+type SaturationMetrics struct {
+	PartitionRate        *prometheus.GaugeVec
+	PartitionUtilization *prometheus.GaugeVec
+	HandlerUtilization   prometheus.Gauge
+	Saturation           prometheus.Gauge
+	Headroom             prometheus.Gauge
+	DesiredReplicas      prometheus.Gauge
+}
+
+// NewSaturationMetrics creates a new set of saturation metrics
+func NewSaturationMetrics(namespace, subsystem string, registry prometheus.Registerer) *SaturationMetrics {
+	metrics := &SaturationMetrics{
+		PartitionRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "partition_messages_per_second",
+			Help:      "Messages handled per second on each assigned partition over the last window",
+		}, []string{"topic", "partition"}),
+		PartitionUtilization: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "partition_utilization",
+			Help:      "Message rate of each assigned partition as a fraction of the configured partition capacity",
+		}, []string{"topic", "partition"}),
+		HandlerUtilization: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "handler_utilization",
+			Help:      "Fraction of worker time spent in message handlers over the last window",
+		}),
+		Saturation: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "saturation",
+			Help:      "Highest of the handler utilization and the partition utilizations",
+		}),
+		Headroom: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "headroom",
+			Help:      "Remaining capacity before saturation (1 - saturation, floored at 0)",
+		}),
+		DesiredReplicas: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "desired_replicas",
+			Help:      "Consumer group size that would bring saturation to the target utilization",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.PartitionRate,
+		metrics.PartitionUtilization,
+		metrics.HandlerUtilization,
+		metrics.Saturation,
+		metrics.Headroom,
+		metrics.DesiredReplicas,
+	)
+
+	return metrics
+}
+
+// PartitionLoad is the load on one assigned partition
+type PartitionLoad struct {
+	Topic             string  `json:"topic"`
+	Partition         int32   `json:"partition"`
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	Utilization       float64 `json:"utilization"`
+}
+
+// AutoscaleHints summarizes how saturated a consumer is, for autoscalers
+type AutoscaleHints struct {
+	WindowSeconds      float64         `json:"window_seconds"`
+	Workers            int             `json:"workers"`
+	GroupMembers       int             `json:"group_members"`
+	TotalPartitions    int             `json:"total_partitions"`
+	Partitions         []PartitionLoad `json:"partitions"`
+	HandlerUtilization float64         `json:"handler_utilization"`
+	Saturation         float64         `json:"saturation"`
+	Headroom           float64         `json:"headroom"`
+	TargetUtilization  float64         `json:"target_utilization"`
+	DesiredReplicas    int             `json:"desired_replicas"`
+}
+
+// topicPartition identifies a partition
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// SaturationMonitor derives partition and handler utilization from the
+// messages a consumer handles. Each window it computes how close the consumer
+// is to saturation and how many group members would bring it to the target
+// utilization, so that replicas can be scaled on saturation instead of CPU.
+// It serves the latest hints as JSON.
+type SaturationMonitor struct {
+	config  SaturationConfig
+	metrics *SaturationMetrics
+
+	mu              sync.Mutex
+	workers         int
+	members         int
+	totalPartitions int
+	assigned        map[topicPartition]bool
+	counts          map[topicPartition]int64
+	busy            time.Duration
+	windowStart     time.Time
+	hints           AutoscaleHints
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSaturationMonitor creates a new saturation monitor; metrics may be nil
+func NewSaturationMonitor(config SaturationConfig, metrics *SaturationMetrics) *SaturationMonitor {
+	if config.Window <= 0 {
+		config.Window = 30 * time.Second
+	}
+	if config.TargetUtilization <= 0 || config.TargetUtilization > 1 {
+		config.TargetUtilization = 0.7
+	}
+	config.Logger = logging.OrDefault(config.Logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SaturationMonitor{
+		config:      config,
+		metrics:     metrics,
+		workers:     DefaultWorkerPoolSize,
+		assigned:    make(map[topicPartition]bool),
+		counts:      make(map[topicPartition]int64),
+		windowStart: time.Now(),
+		hints:       AutoscaleHints{TargetUtilization: config.TargetUtilization, DesiredReplicas: 1},
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// Start starts computing hints every window
+func (m *SaturationMonitor) Start() {
+	go m.run()
+}
+
+// Stop stops computing hints
+func (m *SaturationMonitor) Stop() {
+	m.cancel()
+	<-m.done
+}
+
+// Hints returns the hints computed at the end of the last window
+func (m *SaturationMonitor) Hints() AutoscaleHints {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hints
+}
+
+// ServeHTTP serves the latest hints as JSON
+func (m *SaturationMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.Hints()); err != nil {
+		m.config.Logger.Warn("Failed to encode autoscale hints", "error", err)
+	}
+}
+
+// setWorkers records the size of the consumer's worker pool
+func (m *SaturationMonitor) setWorkers(workers int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers = workers
+}
+
+// setGroup records the group size and this member's assignment after a rebalance
+func (m *SaturationMonitor) setGroup(members, totalPartitions int, claims map[string][]int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members = members
+	m.totalPartitions = totalPartitions
+	m.assigned = make(map[topicPartition]bool)
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			m.assigned[topicPartition{topic, partition}] = true
+		}
+	}
+}
+
+// observe records one handled message and the time its handler took
+func (m *SaturationMonitor) observe(topic string, partition int32, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[topicPartition{topic, partition}]++
+	m.busy += d
+}
+
+func (m *SaturationMonitor) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.config.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.update(time.Now())
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// update computes the hints for the window ending at now and starts a new window
+func (m *SaturationMonitor) update(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elapsed := now.Sub(m.windowStart).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	hints := AutoscaleHints{
+		WindowSeconds:     elapsed,
+		Workers:           m.workers,
+		GroupMembers:      m.members,
+		TotalPartitions:   m.totalPartitions,
+		TargetUtilization: m.config.TargetUtilization,
+	}
+	if m.workers > 0 {
+		hints.HandlerUtilization = m.busy.Seconds() / (elapsed * float64(m.workers))
+	}
+	hints.Saturation = hints.HandlerUtilization
+
+	// Report every assigned partition, including idle ones, and any partition
+	// handled during the window that has since been revoked
+	loads := make(map[topicPartition]int64, len(m.assigned))
+	for tp := range m.assigned {
+		loads[tp] = 0
+	}
+	for tp, count := range m.counts {
+		loads[tp] = count
+	}
+
+	if m.metrics != nil {
+		m.metrics.PartitionRate.Reset()
+		m.metrics.PartitionUtilization.Reset()
+	}
+	for tp, count := range loads {
+		load := PartitionLoad{Topic: tp.topic, Partition: tp.partition, MessagesPerSecond: float64(count) / elapsed}
+		if m.config.PartitionCapacity > 0 {
+			load.Utilization = load.MessagesPerSecond / m.config.PartitionCapacity
+			hints.Saturation = math.Max(hints.Saturation, load.Utilization)
+		}
+		hints.Partitions = append(hints.Partitions, load)
+
+		if m.metrics != nil {
+			partition := strconv.FormatInt(int64(tp.partition), 10)
+			m.metrics.PartitionRate.WithLabelValues(tp.topic, partition).Set(load.MessagesPerSecond)
+			if m.config.PartitionCapacity > 0 {
+				m.metrics.PartitionUtilization.WithLabelValues(tp.topic, partition).Set(load.Utilization)
+			}
+		}
+	}
+	sort.Slice(hints.Partitions, func(i, j int) bool {
+		a, b := hints.Partitions[i], hints.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+
+	hints.Headroom = math.Max(0, 1-hints.Saturation)
+	hints.DesiredReplicas = desiredReplicas(m.members, hints.Saturation, m.config.TargetUtilization, m.totalPartitions)
+
+	if m.metrics != nil {
+		m.metrics.HandlerUtilization.Set(hints.HandlerUtilization)
+		m.metrics.Saturation.Set(hints.Saturation)
+		m.metrics.Headroom.Set(hints.Headroom)
+		m.metrics.DesiredReplicas.Set(float64(hints.DesiredReplicas))
+	}
+
+	m.hints = hints
+	m.counts = make(map[topicPartition]int64, len(m.counts))
+	m.busy = 0
+	m.windowStart = now
+}
+
+// desiredReplicas scales the current group size by saturation over target.
+// Members beyond the partition count would sit idle, so the result is capped
+// there when the partition count is known.
+func desiredReplicas(members int, saturation, target float64, totalPartitions int) int {
+	if members < 1 {
+		members = 1
+	}
+	desired := int(math.Ceil(float64(members) * saturation / target))
+	if desired < 1 {
+		desired = 1
+	}
+	if totalPartitions > 0 && desired > totalPartitions {
+		desired = totalPartitions
+	}
+	return desired
+}
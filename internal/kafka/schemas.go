@@ -0,0 +1,251 @@
+package kafka
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxSchemaObservations bounds the label combinations a tracker keeps, so a
+// producer writing garbage cannot grow the metrics without limit
+const maxSchemaObservations = 1000
+
+// schemaNone labels messages without a schema version or schema ID
+const schemaNone = "none"
+
+// SchemaLabels are the labels of the schema tracking metrics
+var SchemaLabels = []string{"topic", "consumer", "format", "version", "schema_id"}
+
+// SchemaMetrics holds Prometheus metrics for the payload schemas consumed
+type SchemaMetrics struct {
+	Messages *prometheus.CounterVec
+	LastSeen *prometheus.GaugeVec
+	Dropped  prometheus.Counter
+}
+
+// NewSchemaMetrics creates a new set of schema tracking metrics
+func NewSchemaMetrics(namespace, subsystem string, registry prometheus.Registerer) *SchemaMetrics {
+	metrics := &SchemaMetrics{
+		Messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_total",
+			Help:      "Total number of messages consumed by payload format, schema version and schema ID",
+		}, SchemaLabels),
+		LastSeen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_seen_timestamp_seconds",
+			Help:      "Unix time the last message of a payload format, schema version and schema ID was consumed",
+		}, SchemaLabels),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "untracked_messages_total",
+			Help:      "Total number of messages not tracked because too many schema combinations were seen",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Messages,
+		metrics.LastSeen,
+		metrics.Dropped,
+	)
+
+	return metrics
+}
+
+// SchemaObservation is a payload schema one consumer group saw on a topic
+type SchemaObservation struct {
+	Topic    string `json:"topic"`
+	Consumer string `json:"consumer"`
+	Format   string `json:"format"`
+	// Version is the schema version header, and SchemaID the Confluent
+	// schema ID; "none" when messages carry none
+	Version   string    `json:"version"`
+	SchemaID  string    `json:"schema_id"`
+	Messages  int64     `json:"messages"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// schemaKey identifies an observation
+type schemaKey struct {
+	topic, consumer, format, version, schemaID string
+}
+
+// trackedSchema is an observation with its metric children
+type trackedSchema struct {
+	observation SchemaObservation
+	messages    prometheus.Counter
+	lastSeen    prometheus.Gauge
+}
+
+// SchemaTracker records which payload formats, schema versions and schema
+// IDs each consumer group sees on each topic, so operators know when no
+// producer writes an old version any more
+type SchemaTracker struct {
+	metrics *SchemaMetrics
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	tracked map[schemaKey]*trackedSchema
+}
+
+// NewSchemaTracker creates a tracker. metrics may be nil.
+func NewSchemaTracker(metrics *SchemaMetrics, logger *slog.Logger) *SchemaTracker {
+	return &SchemaTracker{
+		metrics: metrics,
+		logger:  logging.OrDefault(logger),
+		tracked: make(map[schemaKey]*trackedSchema),
+	}
+}
+
+var (
+	sharedSchemasMu sync.Mutex
+	sharedSchemas   *SchemaTracker
+)
+
+// SchemaTrackerFromConfig returns the tracker of SCHEMA_TRACKING, shared by
+// every consumer of the process that asks for it, or nil if tracking is
+// disabled. Its metrics are registered with the registry of the first caller.
+func SchemaTrackerFromConfig(cfg *config.Config, registry prometheus.Registerer) *SchemaTracker {
+	if !cfg.SchemaTracking {
+		return nil
+	}
+	sharedSchemasMu.Lock()
+	defer sharedSchemasMu.Unlock()
+	if sharedSchemas == nil {
+		sharedSchemas = NewSchemaTracker(NewSchemaMetrics("iot", "schema", registry), nil)
+	}
+	return sharedSchemas
+}
+
+// Observe records a message consumed by the consumer group at time at
+func (t *SchemaTracker) Observe(consumer string, message *sarama.ConsumerMessage, at time.Time) {
+	key := schemaKey{
+		topic:    message.Topic,
+		consumer: consumer,
+		format:   sniffFormat(message),
+		version:  schemaNone,
+		schemaID: schemaNone,
+	}
+	if version, ok := SchemaVersion(message); ok {
+		key.version = strconv.Itoa(version)
+	}
+	if id, ok := model.ConfluentSchemaID(message.Value); ok {
+		key.schemaID = strconv.Itoa(int(id))
+	}
+
+	t.mu.Lock()
+	tracked := t.tracked[key]
+	if tracked == nil {
+		if len(t.tracked) >= maxSchemaObservations {
+			t.mu.Unlock()
+			if t.metrics != nil {
+				t.metrics.Dropped.Inc()
+			}
+			return
+		}
+		tracked = &trackedSchema{observation: SchemaObservation{
+			Topic:     key.topic,
+			Consumer:  key.consumer,
+			Format:    key.format,
+			Version:   key.version,
+			SchemaID:  key.schemaID,
+			FirstSeen: at,
+		}}
+		if t.metrics != nil {
+			labels := prometheus.Labels{"topic": key.topic, "consumer": key.consumer, "format": key.format,
+				"version": key.version, "schema_id": key.schemaID}
+			tracked.messages = t.metrics.Messages.With(labels)
+			tracked.lastSeen = t.metrics.LastSeen.With(labels)
+		}
+		t.tracked[key] = tracked
+		t.logger.Info("New payload schema observed", "topic", key.topic, "consumer", key.consumer,
+			"format", key.format, "version", key.version, "schema_id", key.schemaID)
+	}
+	tracked.observation.Messages++
+	if at.After(tracked.observation.LastSeen) {
+		tracked.observation.LastSeen = at
+	}
+	t.mu.Unlock()
+
+	if tracked.messages != nil {
+		tracked.messages.Inc()
+		tracked.lastSeen.Set(float64(at.UnixNano()) / 1e9)
+	}
+}
+
+// Observations returns what was observed, by topic, consumer, format,
+// version and schema ID
+func (t *SchemaTracker) Observations() []SchemaObservation {
+	t.mu.Lock()
+	observations := make([]SchemaObservation, 0, len(t.tracked))
+	for _, tracked := range t.tracked {
+		observations = append(observations, tracked.observation)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(observations, func(i, j int) bool {
+		a, b := observations[i], observations[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		if a.Consumer != b.Consumer {
+			return a.Consumer < b.Consumer
+		}
+		if a.Format != b.Format {
+			return a.Format < b.Format
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.SchemaID < b.SchemaID
+	})
+	return observations
+}
+
+// ServeHTTP serves the observations as JSON, limited to those matching the
+// topic and consumer query parameters when given
+func (t *SchemaTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	consumer := r.URL.Query().Get("consumer")
+
+	matches := []SchemaObservation{}
+	for _, observation := range t.Observations() {
+		if (topic == "" || observation.Topic == topic) && (consumer == "" || observation.Consumer == consumer) {
+			matches = append(matches, observation)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(matches); err != nil {
+		t.logger.Warn("Failed to encode schema observations", "error", err)
+	}
+}
+
+// sniffFormat returns the payload format header of a message, or the format
+// its payload looks like when it has none
+func sniffFormat(message *sarama.ConsumerMessage) string {
+	if format := PayloadFormat(message); format != "" {
+		return format
+	}
+	if _, ok := model.ConfluentSchemaID(message.Value); ok {
+		return model.FormatConfluent
+	}
+	if len(message.Value) > 0 && message.Value[0] == '{' {
+		return model.FormatJSON
+	}
+	return "unknown"
+}
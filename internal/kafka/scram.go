@@ -0,0 +1,165 @@
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// scramClient implements the client side of a SCRAM exchange (RFC 5802)
+// without channel binding, as required by sarama for SCRAM-SHA-256/512
+type scramClient struct {
+	hash func() hash.Hash
+
+	username string
+	password string
+	authzID  string
+
+	nonce           string
+	gs2Header       string
+	clientFirstBare string
+	serverSignature []byte
+	step            int
+	done            bool
+}
+
+// newSCRAMClientGenerator returns a sarama SCRAM client generator for a mechanism
+func newSCRAMClientGenerator(mechanism sarama.SASLMechanism) func() sarama.SCRAMClient {
+	h := sha256.New
+	if mechanism == sarama.SASLTypeSCRAMSHA512 {
+		h = sha512.New
+	}
+	return func() sarama.SCRAMClient {
+		return &scramClient{hash: h}
+	}
+}
+
+// Begin prepares a new exchange
+func (c *scramClient) Begin(username, password, authzID string) error {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate SCRAM nonce: %w", err)
+	}
+
+	c.username = username
+	c.password = password
+	c.authzID = authzID
+	c.nonce = base64.RawStdEncoding.EncodeToString(nonce)
+	c.step = 0
+	c.done = false
+	return nil
+}
+
+// Step returns the response to the server's challenge
+func (c *scramClient) Step(challenge string) (string, error) {
+	c.step++
+	switch c.step {
+	case 1:
+		return c.clientFirst(), nil
+	case 2:
+		return c.clientFinal(challenge)
+	case 3:
+		c.done = true
+		return "", c.verifyServerFinal(challenge)
+	default:
+		return "", errors.New("unexpected SCRAM challenge after the exchange completed")
+	}
+}
+
+// Done reports whether the exchange is over
+func (c *scramClient) Done() bool {
+	return c.done
+}
+
+func (c *scramClient) clientFirst() string {
+	c.gs2Header = "n,"
+	if c.authzID != "" {
+		c.gs2Header += "a=" + scramEscape(c.authzID)
+	}
+	c.gs2Header += ","
+	c.clientFirstBare = "n=" + scramEscape(c.username) + ",r=" + c.nonce
+	return c.gs2Header + c.clientFirstBare
+}
+
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+	if e, ok := attrs["e"]; ok {
+		return "", fmt.Errorf("SCRAM server error: %s", e)
+	}
+	nonce, salt64, iter := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.New("SCRAM server nonce does not extend the client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM salt: %w", err)
+	}
+	iterations, err := strconv.Atoi(iter)
+	if err != nil || iterations <= 0 {
+		return "", fmt.Errorf("invalid SCRAM iteration count %q", iter)
+	}
+
+	salted := pbkdf2.Key([]byte(c.password), salt, iterations, c.hash().Size(), c.hash)
+	clientKey := c.hmac(salted, "Client Key")
+	storedKey := c.hash()
+	storedKey.Write(clientKey)
+
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(c.gs2Header)) + ",r=" + nonce
+	authMessage := c.clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	proof := c.hmac(storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	c.serverSignature = c.hmac(c.hmac(salted, "Server Key"), authMessage)
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (c *scramClient) verifyServerFinal(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("SCRAM server error: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil {
+		return fmt.Errorf("invalid SCRAM server signature: %w", err)
+	}
+	if subtle.ConstantTimeCompare(signature, c.serverSignature) != 1 {
+		return errors.New("SCRAM server signature mismatch")
+	}
+	return nil
+}
+
+func (c *scramClient) hmac(key []byte, message string) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// scramAttributes parses a comma-separated list of SCRAM attributes
+func scramAttributes(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(message, ",") {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
+
+// scramEscape encodes a SCRAM saslname
+func scramEscape(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
@@ -0,0 +1,113 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+)
+
+// SecurityConfig holds the TLS and SASL settings of a Kafka connection.
+// The zero value connects in plaintext without authentication.
+type SecurityConfig struct {
+	// TLSEnabled encrypts broker connections
+	TLSEnabled bool
+	// CertFile and KeyFile are a PEM client certificate and key for mutual TLS (optional)
+	CertFile string
+	KeyFile  string
+	// CAFile is a PEM bundle of CAs to trust instead of the system roots (optional)
+	CAFile string
+	// InsecureSkipVerify disables broker certificate verification
+	InsecureSkipVerify bool
+
+	// SASLMechanism is PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512 (empty disables SASL)
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+}
+
+// SecurityFromConfig returns the Kafka security settings of the application configuration
+func SecurityFromConfig(cfg *config.Config) SecurityConfig {
+	return SecurityConfig{
+		TLSEnabled:         cfg.KafkaTLSEnabled,
+		CertFile:           cfg.KafkaTLSCertFile,
+		KeyFile:            cfg.KafkaTLSKeyFile,
+		CAFile:             cfg.KafkaTLSCAFile,
+		InsecureSkipVerify: cfg.KafkaTLSInsecureSkipVerify,
+		SASLMechanism:      cfg.KafkaSASLMechanism,
+		SASLUsername:       cfg.KafkaSASLUsername,
+		SASLPassword:       cfg.KafkaSASLPassword,
+	}
+}
+
+// Options returns the options applying the security settings, loading any
+// certificate files. It fails on unreadable files or an unknown mechanism.
+func (s SecurityConfig) Options() ([]OptionFunc, error) {
+	var opts []OptionFunc
+
+	if s.TLSEnabled {
+		tlsConfig, err := NewTLSConfig(s.CertFile, s.KeyFile, s.CAFile, s.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTLS(tlsConfig))
+	}
+
+	if s.SASLMechanism != "" {
+		mechanism, err := ParseSASLMechanism(s.SASLMechanism)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithSASL(mechanism, s.SASLUsername, s.SASLPassword))
+	}
+
+	return opts, nil
+}
+
+// NewTLSConfig builds a client TLS configuration. certFile and keyFile enable
+// mutual TLS when both are set; caFile replaces the system roots when set.
+func NewTLSConfig(certFile, keyFile, caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("TLS client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// ParseSASLMechanism validates a SASL mechanism name (case-insensitive)
+func ParseSASLMechanism(name string) (sarama.SASLMechanism, error) {
+	switch mechanism := sarama.SASLMechanism(strings.ToUpper(name)); mechanism {
+	case sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		return mechanism, nil
+	default:
+		return "", fmt.Errorf("unsupported SASL mechanism %q (expected PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512)", name)
+	}
+}
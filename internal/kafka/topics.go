@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+)
+
+// EnsureTopics creates the topics that do not exist yet with their configured
+// partitions, retention and cleanup policy. Topics with 0 partitions are left
+// to the broker, and existing topics are not altered.
+func EnsureTopics(brokers []string, version string, security SecurityConfig, topics []config.TopicConfig) error {
+	saramaConfig := sarama.NewConfig()
+	if version != "" {
+		WithKafkaVersion(version)(saramaConfig)
+	}
+	opts, err := security.Options()
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(saramaConfig)
+	}
+
+	admin, err := sarama.NewClusterAdmin(brokers, saramaConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kafka cluster admin: %w", err)
+	}
+	defer admin.Close()
+
+	existing, err := admin.ListTopics()
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	for _, topic := range topics {
+		if _, ok := existing[topic.Name]; ok || topic.Partitions <= 0 {
+			continue
+		}
+
+		detail := &sarama.TopicDetail{
+			NumPartitions: topic.Partitions,
+			// -1 uses the broker's default replication factor
+			ReplicationFactor: -1,
+		}
+		detail.ConfigEntries = make(map[string]*string)
+		if topic.Retention > 0 {
+			retention := strconv.FormatInt(topic.Retention.Milliseconds(), 10)
+			detail.ConfigEntries["retention.ms"] = &retention
+		}
+		if topic.Compact {
+			compact := "compact"
+			detail.ConfigEntries["cleanup.policy"] = &compact
+		}
+
+		err := admin.CreateTopic(topic.Name, detail, false)
+		if errors.Is(err, sarama.ErrTopicAlreadyExists) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create topic %s: %w", topic.Name, err)
+		}
+		slog.Info("Created topic", "topic", topic.Name, "partitions", topic.Partitions)
+	}
+	return nil
+}
+
+// TopicsFromConfig returns the configured topics in key order
+func TopicsFromConfig(cfg *config.Config) []config.TopicConfig {
+	keys := cfg.TopicKeys()
+	topics := make([]config.TopicConfig, 0, len(keys))
+	for _, key := range keys {
+		topics = append(topics, cfg.Topics[key])
+	}
+	return topics
+}
@@ -0,0 +1,119 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of producers and consumers. It follows the global
+// tracer provider, so spans are only recorded once tracing is set up.
+var tracer = otel.Tracer("github.com/example/iot-sensor-fleet/internal/kafka")
+
+// propagator carries the W3C trace context in the traceparent and
+// tracestate headers. It is used even when this process does not record
+// spans, so a trace passes through services that do not export it.
+var propagator = propagation.TraceContext{}
+
+// retryCountAttribute is the span attribute of the number of earlier attempts at
+// handling a message
+const retryCountAttribute = attribute.Key("messaging.retry_count")
+
+// producerHeaders adapts the headers of a produced message to propagators
+type producerHeaders struct {
+	msg *sarama.ProducerMessage
+}
+
+// Get returns the value of the last header with key
+func (c producerHeaders) Get(key string) string {
+	for i := len(c.msg.Headers) - 1; i >= 0; i-- {
+		if string(c.msg.Headers[i].Key) == key {
+			return string(c.msg.Headers[i].Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces the headers with key, such as a trace context copied from
+// the context's headers, with one header
+func (c producerHeaders) Set(key, value string) {
+	headers := c.msg.Headers[:0]
+	for _, header := range c.msg.Headers {
+		if string(header.Key) != key {
+			headers = append(headers, header)
+		}
+	}
+	c.msg.Headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+// Keys returns the keys of the headers
+func (c producerHeaders) Keys() []string {
+	keys := make([]string, len(c.msg.Headers))
+	for i, header := range c.msg.Headers {
+		keys[i] = string(header.Key)
+	}
+	return keys
+}
+
+// consumerHeaders adapts the headers of a consumed message to propagators
+type consumerHeaders struct {
+	message *sarama.ConsumerMessage
+}
+
+// Get returns the value of the last header with key
+func (c consumerHeaders) Get(key string) string {
+	value, _ := Header(c.message, key)
+	return value
+}
+
+// Set does nothing: consumed messages are only read
+func (c consumerHeaders) Set(key, value string) {}
+
+// Keys returns the keys of the headers
+func (c consumerHeaders) Keys() []string {
+	keys := make([]string, 0, len(c.message.Headers))
+	for _, header := range c.message.Headers {
+		if header != nil {
+			keys = append(keys, string(header.Key))
+		}
+	}
+	return keys
+}
+
+// startPublishSpan starts the span of sending msg, a child of the span of
+// ctx, and injects its trace context into the message's headers
+func startPublishSpan(ctx context.Context, msg *sarama.ProducerMessage) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, "publish "+msg.Topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(msg.Topic),
+		))
+	propagator.Inject(ctx, producerHeaders{msg})
+	return ctx, span
+}
+
+// startProcessSpan extracts the trace context of a consumed message and
+// starts the span of one attempt at handling it, so the spans of the
+// handler continue the trace of the message's producer
+func startProcessSpan(ctx context.Context, message *sarama.ConsumerMessage, groupID string, retries int) (context.Context, trace.Span) {
+	ctx = propagator.Extract(ctx, consumerHeaders{message})
+	return tracer.Start(ctx, "process "+message.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(message.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.FormatInt(int64(message.Partition), 10)),
+			semconv.MessagingKafkaMessageOffset(int(message.Offset)),
+			semconv.MessagingKafkaConsumerGroup(groupID),
+			retryCountAttribute.Int(retries),
+		))
+}
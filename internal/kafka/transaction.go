@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// TransactionConfig makes a consumer handle each message in a Kafka
+// transaction that also commits the message's offset. Messages the handler
+// sends with a Producer and the handler's context join the transaction, so
+// they are written exactly once together with the offset: a handler attempt
+// that fails, or a consumer that dies mid-message, leaves nothing behind for
+// read-committed consumers, and the message is handled again.
+//
+// Each claimed partition gets its own transactional producer with the ID
+// "<ID>-<topic>-<partition>". When a partition moves to another instance, the
+// new owner's producer fences off the old one, which can then no longer
+// commit. Messages are handled one at a time per partition.
+type TransactionConfig struct {
+	// ID prefixes the transactional ID of each partition's producer. It must
+	// be the same on every instance of the consumer group.
+	ID string
+
+	// HopHeaders records hops on messages sent in transactions
+	HopHeaders bool
+}
+
+// txnKey is the context key carrying the transactional publisher of the
+// message being handled
+type txnKey struct{}
+
+// contextWithTxn returns a context whose sends join publisher's transaction
+func contextWithTxn(ctx context.Context, publisher *kafkaPublisher) context.Context {
+	return context.WithValue(ctx, txnKey{}, publisher)
+}
+
+// txnFromContext returns the transactional publisher carried by ctx, or nil
+func txnFromContext(ctx context.Context) *kafkaPublisher {
+	publisher, _ := ctx.Value(txnKey{}).(*kafkaPublisher)
+	return publisher
+}
+
+// InTransaction reports whether messages sent with ctx join a transaction
+func InTransaction(ctx context.Context) bool {
+	return txnFromContext(ctx) != nil
+}
+
+// consumeClaimInTxns handles the messages of a claim one at a time, each in
+// its own transaction. An error ends the session; the partition is claimed
+// again from its last committed offset.
+func (c *kafkaConsumer) consumeClaimInTxns(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	id := fmt.Sprintf("%s-%s-%d", c.txn.ID, claim.Topic(), claim.Partition())
+	publisher, err := newKafkaPublisher("", c.brokers, "", append(c.txnOpts, WithTransactionalID(id))...)
+	if err != nil {
+		return fmt.Errorf("failed to create transactional producer %s: %w", id, err)
+	}
+	publisher.hopHeaders = c.txn.HopHeaders
+	publisher.logger = c.logger
+	defer publisher.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			var charge *inflightCharge
+			if c.inflightBudget != nil {
+				if charge = c.inflightBudget.acquireMessage(c.ctx, msg); charge == nil {
+					return nil
+				}
+			}
+			// Claims handle their messages one at a time here, but take a
+			// worker when topics are weighted so lighter topics wait their turn
+			if c.scheduler != nil && !c.scheduler.acquire(c.ctx, msg.Topic) {
+				charge.release()
+				return nil
+			}
+			c.inflight.Add(1)
+			err := c.processMessageInTxn(publisher, msg, charge)
+			c.inflight.Add(-1)
+			if c.scheduler != nil {
+				c.scheduler.release()
+			}
+			charge.release()
+			if err != nil {
+				c.logger.Error("Transactional producer failed, ending session", "transactional_id", id, "error", err)
+				return err
+			}
+		}
+	}
+}
+
+// processMessageInTxn handles a message with the same retries as
+// processMessage, each attempt in a transaction that commits the message's
+// offset when the handler succeeds and is aborted when it fails. After the
+// last failed attempt the offset is committed alone, or with the message
+// sent to the dead-letter hook, so the message is skipped as it is without
+// transactions.
+func (c *kafkaConsumer) processMessageInTxn(publisher *kafkaPublisher, msg *sarama.ConsumerMessage, charge *inflightCharge) error {
+	var err error
+	var busy time.Duration
+	var attempts int
+	retry := c.retry.orDefault()
+	deadline := retry.deadline(time.Now())
+
+	for i := 0; i < retry.MaxAttempts; i++ {
+		if c.handlerCtx.Err() != nil {
+			return nil
+		}
+
+		if err := publisher.producer.BeginTxn(); err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		start := time.Now()
+		err = c.handle(contextWithTxn(charge.context(c.handlerCtx), publisher), msg, i)
+		busy += time.Since(start)
+		attempts++
+		if err == nil {
+			if err = c.commitTxn(publisher, msg); err == nil {
+				break
+			}
+		}
+		if abortErr := publisher.producer.AbortTxn(); abortErr != nil {
+			return fmt.Errorf("failed to abort transaction after %v: %w", err, abortErr)
+		}
+
+		if i == retry.MaxAttempts-1 || retry.expired(deadline, time.Now()) {
+			break
+		}
+		backoff := retry.backoff(i)
+		c.logger.With(MessageLogAttrs(msg)...).Warn("Retrying message in a new transaction",
+			"backoff", backoff, "attempt", i+1, "max_attempts", retry.MaxAttempts, "error", err)
+		select {
+		case <-c.handlerCtx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+
+	if c.saturation != nil {
+		c.saturation.observe(msg.Topic, msg.Partition, busy)
+	}
+	if c.progress != nil {
+		c.progress.handledMessage(msg)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if err := publisher.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// The message is dead-lettered in the transaction skipping it
+	if !c.deadLetter(contextWithTxn(c.handlerCtx, publisher), msg, attempts, err) {
+		c.logger.With(MessageLogAttrs(msg)...).Error("Failed to process message after retries", "error", err)
+	}
+	if err := c.commitTxn(publisher, msg); err != nil {
+		if abortErr := publisher.producer.AbortTxn(); abortErr != nil {
+			c.logger.Error("Failed to abort transaction", "error", abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// commitTxn adds the offset after msg to the open transaction and commits it
+func (c *kafkaConsumer) commitTxn(publisher *kafkaPublisher, msg *sarama.ConsumerMessage) error {
+	if err := publisher.producer.AddMessageToTxn(msg, c.groupID, nil); err != nil {
+		return fmt.Errorf("failed to add offset to transaction: %w", err)
+	}
+	if err := publisher.producer.CommitTxn(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel converts a level name (debug, info, warn, error, off) into a slog level.
+// The boolean is false when logging is disabled.
+func ParseLevel(name string) (slog.Level, bool, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, true, nil
+	case "info", "":
+		return slog.LevelInfo, true, nil
+	case "warn", "warning":
+		return slog.LevelWarn, true, nil
+	case "error":
+		return slog.LevelError, true, nil
+	case "off", "none":
+		return 0, false, nil
+	default:
+		return 0, false, fmt.Errorf("unknown log level: %s", name)
+	}
+}
+
+// New creates a logger writing records at or above level to w as text or
+// JSON, with a service attribute on every record
+func New(w io.Writer, service, level, format string) (*slog.Logger, error) {
+	threshold, enabled, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		w = io.Discard
+	}
+
+	options := &slog.HandlerOptions{Level: threshold}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+
+	logger := slog.New(handler)
+	if service != "" {
+		logger = logger.With("service", service)
+	}
+	return logger, nil
+}
+
+// NewFromConfig creates the logger of a service from LOG_LEVEL and
+// LOG_FORMAT, writing to stderr. It also becomes the default logger, so
+// components created without a logger and the standard log package write
+// through it.
+func NewFromConfig(service string, cfg *config.Config) (*slog.Logger, error) {
+	logger, err := New(os.Stderr, service, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// OrDefault returns logger, or the default logger when it is nil
+func OrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// Fatal logs msg at error level and exits
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	OrDefault(logger).Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,17 @@
+package metrics
+
+import "github.com/example/iot-sensor-fleet/internal/model"
+
+// AlertLabels are the labels of alert counters. The reason label holds the
+// rule rather than the free-text reason, whose measured values would make
+// every alert a new series.
+var AlertLabels = []string{"reason", "severity", "site"}
+
+// AlertLabelValues returns the values of AlertLabels for an alert
+func AlertLabelValues(alert *model.SensorAlert) []string {
+	reason := alert.Rule
+	if reason == "" {
+		reason = "unknown"
+	}
+	return []string{reason, model.AlertSeverity(alert), alert.Site}
+}
@@ -2,10 +2,11 @@ package metrics
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/example/iot-sensor-fleet/internal/logging"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -14,10 +15,12 @@ import (
 type MetricsServer struct {
 	registry *prometheus.Registry
 	server   *http.Server
+	mux      *http.ServeMux
+	logger   *slog.Logger
 }
 
-// NewMetricsServer creates a new metrics server
-func NewMetricsServer(port int) *MetricsServer {
+// NewMetricsServer creates a new metrics server; logger may be nil
+func NewMetricsServer(port int, logger *slog.Logger) *MetricsServer {
 	registry := prometheus.NewRegistry()
 	
 	// Register the Go collector (collects runtime metrics about the Go process)
@@ -28,6 +31,8 @@ func NewMetricsServer(port int) *MetricsServer {
 	
 	return &MetricsServer{
 		registry: registry,
+		mux:      http.NewServeMux(),
+		logger:   logging.OrDefault(logger),
 		server: &http.Server{
 			Addr:         fmt.Sprintf(":%d", port),
 			ReadTimeout:  5 * time.Second,
@@ -42,9 +47,15 @@ func (m *MetricsServer) Registry() *prometheus.Registry {
 	return m.registry
 }
 
+// Handle registers an additional handler on the metrics server.
+// It may be called before or after Start.
+func (m *MetricsServer) Handle(pattern string, handler http.Handler) {
+	m.mux.Handle(pattern, handler)
+}
+
 // Start starts the metrics server
 func (m *MetricsServer) Start() {
-	mux := http.NewServeMux()
+	mux := m.mux
 	
 	// Register the metrics handler
 	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
@@ -58,16 +69,16 @@ func (m *MetricsServer) Start() {
 	m.server.Handler = mux
 	
 	go func() {
-		log.Printf("Starting metrics server on %s", m.server.Addr)
+		m.logger.Info("Starting metrics server", "addr", m.server.Addr)
 		if err := m.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error starting metrics server: %v", err)
+			logging.Fatal(m.logger, "Error starting metrics server", "error", err)
 		}
 	}()
 }
 
 // Stop stops the metrics server
 func (m *MetricsServer) Stop() error {
-	log.Println("Stopping metrics server")
+	m.logger.Info("Stopping metrics server")
 	return m.server.Close()
 }
 
@@ -130,10 +141,12 @@ func NewSensorProducerMetrics(registry prometheus.Registerer) *SensorProducerMet
 // AnomalyDetectorMetrics holds metrics for the anomaly detector
 type AnomalyDetectorMetrics struct {
 	MessagesProcessedTotal prometheus.Counter
-	AlertsGeneratedTotal   prometheus.Counter
+	AlertsGeneratedTotal   *prometheus.CounterVec
 	DLTMessagesTotal       prometheus.Counter
 	ProcessingLatency      prometheus.Histogram
 	ConsumerLag            prometheus.Gauge
+	DecodedMessagesTotal   *prometheus.CounterVec
+	ShadowAlertsTotal      *prometheus.CounterVec
 }
 
 // NewAnomalyDetectorMetrics creates a new set of anomaly detector metrics
@@ -145,12 +158,12 @@ func NewAnomalyDetectorMetrics(registry prometheus.Registerer) *AnomalyDetectorM
 			Name:      "messages_processed_total",
 			Help:      "Total number of messages processed",
 		}),
-		AlertsGeneratedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+		AlertsGeneratedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: "iot",
 			Subsystem: "anomaly_detector",
 			Name:      "alerts_generated_total",
-			Help:      "Total number of alerts generated",
-		}),
+			Help:      "Total number of alerts generated, by reason (the rule), severity and site",
+		}, AlertLabels),
 		DLTMessagesTotal: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: "iot",
 			Subsystem: "anomaly_detector",
@@ -170,6 +183,18 @@ func NewAnomalyDetectorMetrics(registry prometheus.Registerer) *AnomalyDetectorM
 			Name:      "consumer_lag",
 			Help:      "Current consumer lag (messages behind)",
 		}),
+		DecodedMessagesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "iot",
+			Subsystem: "anomaly_detector",
+			Name:      "decoded_messages_total",
+			Help:      "Total number of messages decoded, by topic and detected wire format",
+		}, []string{"topic", "format"}),
+		ShadowAlertsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "iot",
+			Subsystem: "anomaly_detector",
+			Name:      "shadow_alerts_total",
+			Help:      "Total number of alerts sent to the shadow alert topic while another detector color is authoritative, by reason (the rule), severity and site",
+		}, AlertLabels),
 	}
 	
 	registry.MustRegister(
@@ -178,6 +203,8 @@ func NewAnomalyDetectorMetrics(registry prometheus.Registerer) *AnomalyDetectorM
 		metrics.DLTMessagesTotal,
 		metrics.ProcessingLatency,
 		metrics.ConsumerLag,
+		metrics.DecodedMessagesTotal,
+		metrics.ShadowAlertsTotal,
 	)
 	
 	return metrics
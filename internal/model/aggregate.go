@@ -0,0 +1,40 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SensorAggregate summarizes the readings of one sensor over a tumbling
+// window [WindowStart, WindowEnd), in Unix milliseconds of reading time
+type SensorAggregate struct {
+	SensorID       string  `json:"sensor_id"`
+	Site           string  `json:"site,omitempty"`
+	WindowStart    int64   `json:"window_start"`
+	WindowEnd      int64   `json:"window_end"`
+	Readings       int     `json:"readings"`
+	MinTemperature float64 `json:"min_temperature"`
+	MaxTemperature float64 `json:"max_temperature"`
+	AvgTemperature float64 `json:"avg_temperature"`
+	MinHumidity    float64 `json:"min_humidity"`
+	MaxHumidity    float64 `json:"max_humidity"`
+	AvgHumidity    float64 `json:"avg_humidity"`
+}
+
+// SerializeSensorAggregate serializes a sensor aggregate to JSON format
+func SerializeSensorAggregate(aggregate *SensorAggregate) ([]byte, error) {
+	jsonData, err := json.Marshal(aggregate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sensor aggregate to JSON: %w", err)
+	}
+	return jsonData, nil
+}
+
+// DeserializeSensorAggregate deserializes JSON data to a sensor aggregate
+func DeserializeSensorAggregate(data []byte) (*SensorAggregate, error) {
+	var aggregate SensorAggregate
+	if err := json.Unmarshal(data, &aggregate); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON to sensor aggregate: %w", err)
+	}
+	return &aggregate, nil
+}
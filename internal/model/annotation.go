@@ -0,0 +1,50 @@
+package model
+
+// Detector rule names, used to look up runbooks and annotations
+const (
+	RuleTemperatureHigh      = "temperature_high"
+	RuleHumidityLow          = "humidity_low"
+	RuleTemperatureDeviation = "temperature_deviation"
+	RuleHumidityDeviation    = "humidity_deviation"
+	RuleBatteryLow           = "battery_low"
+	RuleSignalWeak           = "signal_weak"
+)
+
+// Annotator resolves the runbook link and annotations attached to a rule and site.
+// Either argument may be empty.
+type Annotator interface {
+	Annotate(rule, site string) (runbookURL string, annotations map[string]string)
+}
+
+// Alert severities
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// SeverityAnnotation is the annotation that overrides the default severity of a rule or site
+const SeverityAnnotation = "severity"
+
+// RuleSeverity returns the default severity of a detector rule: thresholds
+// protecting the monitored environment are critical, drifts from a sensor's
+// own baseline are warnings and device health is informational
+func RuleSeverity(rule string) string {
+	switch rule {
+	case RuleTemperatureHigh, RuleHumidityLow:
+		return SeverityCritical
+	case RuleBatteryLow, RuleSignalWeak:
+		return SeverityInfo
+	default:
+		return SeverityWarning
+	}
+}
+
+// AlertSeverity returns the severity of an alert, taken from its severity
+// annotation when its rule or site has one
+func AlertSeverity(alert *SensorAlert) string {
+	if severity := alert.Annotations[SeverityAnnotation]; severity != "" {
+		return severity
+	}
+	return RuleSeverity(alert.Rule)
+}
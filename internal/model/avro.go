@@ -0,0 +1,292 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// SensorReadingAvroSchema is the Avro schema used for sensor readings.
+// Version 3 added the optional site, zone and location, the latter as lat
+// and lon, both null for readings without one.
+const SensorReadingAvroSchema = `{
+  "type": "record",
+  "name": "SensorReading",
+  "namespace": "iot.sensor",
+  "fields": [
+    {"name": "id", "type": "string"},
+    {"name": "ts", "type": "long"},
+    {"name": "temperature", "type": "float"},
+    {"name": "humidity", "type": "float"},
+    {"name": "battery_pct", "type": ["null", "float"], "default": null},
+    {"name": "rssi", "type": ["null", "int"], "default": null},
+    {"name": "site", "type": ["null", "string"], "default": null},
+    {"name": "zone", "type": ["null", "string"], "default": null},
+    {"name": "lat", "type": ["null", "double"], "default": null},
+    {"name": "lon", "type": ["null", "double"], "default": null}
+  ]
+}`
+
+// sensorReadingAvroSchemaV2 is the schema before site, zone, lat and lon
+// were added. Readings encoded with it are still decoded.
+const sensorReadingAvroSchemaV2 = `{
+  "type": "record",
+  "name": "SensorReading",
+  "namespace": "iot.sensor",
+  "fields": [
+    {"name": "id", "type": "string"},
+    {"name": "ts", "type": "long"},
+    {"name": "temperature", "type": "float"},
+    {"name": "humidity", "type": "float"},
+    {"name": "battery_pct", "type": ["null", "float"], "default": null},
+    {"name": "rssi", "type": ["null", "int"], "default": null}
+  ]
+}`
+
+// sensorReadingAvroSchemaV1 is the schema before battery_pct and rssi were
+// added. Readings encoded with it are still decoded.
+const sensorReadingAvroSchemaV1 = `{
+  "type": "record",
+  "name": "SensorReading",
+  "namespace": "iot.sensor",
+  "fields": [
+    {"name": "id", "type": "string"},
+    {"name": "ts", "type": "long"},
+    {"name": "temperature", "type": "float"},
+    {"name": "humidity", "type": "float"}
+  ]
+}`
+
+var errAvroShortBuffer = errors.New("avro: unexpected end of data")
+
+// SerializeSensorReadingAvro serializes a sensor reading to Avro binary encoding
+func SerializeSensorReadingAvro(reading *SensorReading) ([]byte, error) {
+	buf := make([]byte, 0, len(reading.ID)+len(reading.Site)+len(reading.Zone)+56)
+	buf = appendAvroString(buf, reading.ID)
+	buf = appendAvroLong(buf, reading.Timestamp)
+	buf = appendAvroFloat(buf, reading.Temperature)
+	buf = appendAvroFloat(buf, reading.Humidity)
+	if reading.BatteryPct == nil {
+		buf = appendAvroLong(buf, 0)
+	} else {
+		buf = appendAvroFloat(appendAvroLong(buf, 1), *reading.BatteryPct)
+	}
+	if reading.RSSI == nil {
+		buf = appendAvroLong(buf, 0)
+	} else {
+		buf = appendAvroLong(appendAvroLong(buf, 1), int64(*reading.RSSI))
+	}
+	buf = appendAvroOptionalString(buf, reading.Site)
+	buf = appendAvroOptionalString(buf, reading.Zone)
+	if reading.Location == nil {
+		buf = appendAvroLong(appendAvroLong(buf, 0), 0)
+	} else {
+		buf = appendAvroDouble(appendAvroLong(buf, 1), reading.Location.Lat)
+		buf = appendAvroDouble(appendAvroLong(buf, 1), reading.Location.Lon)
+	}
+	return buf, nil
+}
+
+// DeserializeSensorReadingAvro deserializes Avro binary data to a sensor reading.
+// The entire buffer must be consumed, which lets callers use it to sniff the format.
+// Readings that end after humidity were encoded with the schema before
+// battery_pct and rssi, and those that end after rssi with the schema before
+// site, zone, lat and lon; both are decoded without the fields they lack.
+func DeserializeSensorReadingAvro(data []byte) (*SensorReading, error) {
+	r := avroReader{data: data}
+
+	var reading SensorReading
+	var err error
+	if reading.ID, err = r.readString(); err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading id: %w", err)
+	}
+	if reading.Timestamp, err = r.readLong(); err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading ts: %w", err)
+	}
+	if reading.Temperature, err = r.readFloat(); err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading temperature: %w", err)
+	}
+	if reading.Humidity, err = r.readFloat(); err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading humidity: %w", err)
+	}
+	if r.remaining() == 0 {
+		return &reading, nil
+	}
+	if reading.BatteryPct, err = r.readOptionalFloat(); err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading battery_pct: %w", err)
+	}
+	if reading.RSSI, err = r.readOptionalInt(); err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading rssi: %w", err)
+	}
+	if r.remaining() == 0 {
+		return &reading, nil
+	}
+	if reading.Site, err = r.readOptionalString(); err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading site: %w", err)
+	}
+	if reading.Zone, err = r.readOptionalString(); err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading zone: %w", err)
+	}
+	lat, err := r.readOptionalDouble()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading lat: %w", err)
+	}
+	lon, err := r.readOptionalDouble()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Avro sensor reading lon: %w", err)
+	}
+	if (lat == nil) != (lon == nil) {
+		return nil, errors.New("avro: sensor reading has only one of lat and lon")
+	}
+	if lat != nil {
+		reading.Location = &GeoPoint{Lat: *lat, Lon: *lon}
+	}
+	if r.remaining() != 0 {
+		return nil, fmt.Errorf("avro: %d trailing bytes after sensor reading", r.remaining())
+	}
+
+	return &reading, nil
+}
+
+func appendAvroLong(buf []byte, v int64) []byte {
+	return binary.AppendVarint(buf, v)
+}
+
+func appendAvroString(buf []byte, s string) []byte {
+	buf = appendAvroLong(buf, int64(len(s)))
+	return append(buf, s...)
+}
+
+func appendAvroFloat(buf []byte, f float32) []byte {
+	return binary.LittleEndian.AppendUint32(buf, math.Float32bits(f))
+}
+
+func appendAvroDouble(buf []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+}
+
+// appendAvroOptionalString appends a ["null", "string"] union, null for an
+// empty string
+func appendAvroOptionalString(buf []byte, s string) []byte {
+	if s == "" {
+		return appendAvroLong(buf, 0)
+	}
+	return appendAvroString(appendAvroLong(buf, 1), s)
+}
+
+// avroReader decodes Avro primitive types from a byte slice
+type avroReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *avroReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *avroReader) readLong() (int64, error) {
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errAvroShortBuffer
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *avroReader) readString() (string, error) {
+	length, err := r.readLong()
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int64(r.remaining()) < length {
+		return "", fmt.Errorf("avro: invalid string length %d", length)
+	}
+	s := r.data[r.pos : r.pos+int(length)]
+	if !utf8.Valid(s) {
+		return "", errors.New("avro: string is not valid UTF-8")
+	}
+	r.pos += int(length)
+	return string(s), nil
+}
+
+func (r *avroReader) readFloat() (float32, error) {
+	if r.remaining() < 4 {
+		return 0, errAvroShortBuffer
+	}
+	bits := binary.LittleEndian.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return math.Float32frombits(bits), nil
+}
+
+// readUnionIndex reads the branch of a ["null", T] union, reporting whether
+// it holds a value
+func (r *avroReader) readUnionIndex() (bool, error) {
+	index, err := r.readLong()
+	if err != nil {
+		return false, err
+	}
+	switch index {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("avro: invalid union index %d", index)
+	}
+}
+
+func (r *avroReader) readOptionalFloat() (*float32, error) {
+	if ok, err := r.readUnionIndex(); !ok || err != nil {
+		return nil, err
+	}
+	f, err := r.readFloat()
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (r *avroReader) readDouble() (float64, error) {
+	if r.remaining() < 8 {
+		return 0, errAvroShortBuffer
+	}
+	bits := binary.LittleEndian.Uint64(r.data[r.pos:])
+	r.pos += 8
+	return math.Float64frombits(bits), nil
+}
+
+func (r *avroReader) readOptionalDouble() (*float64, error) {
+	if ok, err := r.readUnionIndex(); !ok || err != nil {
+		return nil, err
+	}
+	f, err := r.readDouble()
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// readOptionalString reads a ["null", "string"] union, null as an empty string
+func (r *avroReader) readOptionalString() (string, error) {
+	if ok, err := r.readUnionIndex(); !ok || err != nil {
+		return "", err
+	}
+	return r.readString()
+}
+
+func (r *avroReader) readOptionalInt() (*int32, error) {
+	if ok, err := r.readUnionIndex(); !ok || err != nil {
+		return nil, err
+	}
+	v, err := r.readLong()
+	if err != nil {
+		return nil, err
+	}
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return nil, fmt.Errorf("avro: int %d out of range", v)
+	}
+	i := int32(v)
+	return &i, nil
+}
@@ -0,0 +1,37 @@
+package model
+
+// Alert budget scopes
+const (
+	BudgetScopeGlobal = "global"
+	BudgetScopeSite   = "site"
+)
+
+// Alert budget statuses
+const (
+	BudgetBreached  = "breached"
+	BudgetRecovered = "recovered"
+)
+
+// AlertSummary stands in for the individual alerts suppressed while an alert budget is exceeded
+type AlertSummary struct {
+	Scope       string         `json:"scope"`
+	Site        string         `json:"site,omitempty"`
+	WindowStart int64          `json:"window_start"`
+	WindowEnd   int64          `json:"window_end"`
+	Suppressed  int            `json:"suppressed"`
+	Sensors     int            `json:"sensors"`
+	ByRule      map[string]int `json:"by_rule"`
+	Summary     string         `json:"summary"`
+}
+
+// BudgetBreach is a meta-alert raised when an alert budget is exceeded and again when it recovers
+type BudgetBreach struct {
+	Scope     string `json:"scope"`
+	Site      string `json:"site,omitempty"`
+	Status    string `json:"status"`
+	Limit     int    `json:"limit"`
+	Count     int    `json:"count"`
+	Since     int64  `json:"since"`
+	Timestamp int64  `json:"ts"`
+	Summary   string `json:"summary"`
+}
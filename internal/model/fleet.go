@@ -0,0 +1,40 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Fleet alert kinds
+const (
+	FleetAlertRateDrop  = "rate_drop"
+	FleetAlertRateSurge = "rate_surge"
+)
+
+// FleetAlert represents an anomaly in fleet-wide behaviour rather than in a single sensor
+type FleetAlert struct {
+	Kind      string  `json:"kind"`
+	Timestamp int64   `json:"ts"`
+	Reason    string  `json:"reason"`
+	Rate      float64 `json:"rate"`
+	Baseline  float64 `json:"baseline"`
+	Window    float64 `json:"window_seconds"`
+}
+
+// SerializeFleetAlert serializes a fleet alert to JSON format
+func SerializeFleetAlert(alert *FleetAlert) ([]byte, error) {
+	jsonData, err := json.Marshal(alert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal fleet alert to JSON: %w", err)
+	}
+	return jsonData, nil
+}
+
+// DeserializeFleetAlert deserializes JSON data to a fleet alert
+func DeserializeFleetAlert(data []byte) (*FleetAlert, error) {
+	var alert FleetAlert
+	if err := json.Unmarshal(data, &alert); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON to fleet alert: %w", err)
+	}
+	return &alert, nil
+}
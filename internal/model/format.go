@@ -0,0 +1,212 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Wire formats a sensor reading can be encoded in
+const (
+	FormatConfluent  = "confluent"   // Confluent envelope: magic byte, 4-byte schema ID, Avro body
+	FormatAvro       = "avro"        // bare Avro binary
+	FormatJSONSchema = "json-schema" // JSON envelope carrying its schema, as Kafka Connect's JsonConverter writes it
+	FormatJSON       = "json"        // plain JSON
+)
+
+// confluentMagicByte prefixes every Confluent wire-format message
+const confluentMagicByte = 0x0
+
+// DefaultFormats is the sniffing order used for topics without explicit configuration
+var DefaultFormats = []string{FormatConfluent, FormatAvro, FormatJSONSchema, FormatJSON}
+
+// ErrUnrecognizedFormat is returned when none of the configured formats can decode a message
+var ErrUnrecognizedFormat = errors.New("message does not match any configured format")
+
+// ReadingDecoder decodes sensor readings by sniffing the payload against an ordered,
+// per-topic list of formats
+type ReadingDecoder struct {
+	defaults []string
+	topics   map[string][]string
+}
+
+// NewReadingDecoder creates a decoder from a per-topic format list.
+// Topics missing from the map fall back to DefaultFormats.
+func NewReadingDecoder(topics map[string][]string) (*ReadingDecoder, error) {
+	for topic, formats := range topics {
+		for _, format := range formats {
+			if !isKnownFormat(format) {
+				return nil, fmt.Errorf("unknown format %q for topic %s", format, topic)
+			}
+		}
+	}
+	return &ReadingDecoder{defaults: DefaultFormats, topics: topics}, nil
+}
+
+// DecodeFormat decodes a sensor reading in the format its producer declared,
+// such as in the format header of a Kafka message, without sniffing. An
+// empty or unknown format falls back to Decode.
+func (d *ReadingDecoder) DecodeFormat(topic string, data []byte, format string) (*SensorReading, string, error) {
+	if !isKnownFormat(format) {
+		return d.Decode(topic, data)
+	}
+	reading, err := decodeAs(format, data)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", format, err)
+	}
+	return reading, format, nil
+}
+
+// Decode decodes a sensor reading, returning the format that matched
+func (d *ReadingDecoder) Decode(topic string, data []byte) (*SensorReading, string, error) {
+	formats, ok := d.topics[topic]
+	if !ok {
+		formats = d.defaults
+	}
+
+	var errs []error
+	for _, format := range formats {
+		reading, err := decodeAs(format, data)
+		if err == nil {
+			return reading, format, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", format, err))
+	}
+
+	return nil, "", fmt.Errorf("%w: %w", ErrUnrecognizedFormat, errors.Join(errs...))
+}
+
+// decodeAs decodes data in a single format
+func decodeAs(format string, data []byte) (*SensorReading, error) {
+	switch format {
+	case FormatConfluent:
+		return DeserializeSensorReadingConfluent(data)
+	case FormatAvro:
+		return DeserializeSensorReadingAvro(data)
+	case FormatJSONSchema:
+		return DeserializeSensorReadingJSONSchema(data)
+	case FormatJSON:
+		trimmed := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(trimmed, "{") {
+			return nil, errors.New("payload is not a JSON object")
+		}
+		// An envelope would otherwise decode as an empty reading
+		if isJSONSchemaEnvelope(data) {
+			return nil, errors.New("payload is a json-schema envelope")
+		}
+		return DeserializeSensorReading(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// SerializeSensorReadingConfluent serializes a sensor reading to the Confluent
+// wire format: magic byte, big-endian schema ID, then the Avro body
+func SerializeSensorReadingConfluent(reading *SensorReading, schemaID int32) ([]byte, error) {
+	body, err := SerializeSensorReadingAvro(reading)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 5, 5+len(body))
+	buf[0] = confluentMagicByte
+	binary.BigEndian.PutUint32(buf[1:5], uint32(schemaID))
+	return append(buf, body...), nil
+}
+
+// DeserializeSensorReadingConfluent deserializes a Confluent wire-format
+// reading. With a registry set by InitSchemaRegistry, the schema ID must name
+// a schema with the same encoding as SensorReadingAvroSchema.
+func DeserializeSensorReadingConfluent(data []byte) (*SensorReading, error) {
+	schemaID, ok := ConfluentSchemaID(data)
+	if !ok {
+		return nil, errors.New("missing Confluent wire-format header")
+	}
+	reading, err := DeserializeSensorReadingAvro(data[5:])
+	if err != nil {
+		return nil, err
+	}
+	if registry := schemaRegistry; registry != nil {
+		if err := registry.CheckReadingSchema(schemaID); err != nil {
+			return nil, err
+		}
+	}
+	return reading, nil
+}
+
+// ReadingSerializer encodes sensor readings in one wire format. In the
+// Confluent format it registers SensorReadingAvroSchema under its subject on
+// first use and frames readings with the returned schema ID.
+type ReadingSerializer struct {
+	format   string
+	registry *SchemaRegistry
+	subject  string
+
+	mu       sync.Mutex
+	schemaID int32
+}
+
+// NewReadingSerializer creates a serializer for format. The Confluent format
+// needs a registry and the subject to register the schema under, by
+// convention "<topic>-value".
+func NewReadingSerializer(format string, registry *SchemaRegistry, subject string) (*ReadingSerializer, error) {
+	if !isKnownFormat(format) {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if format == FormatConfluent && registry == nil {
+		return nil, errors.New("the confluent format requires a schema registry")
+	}
+	return &ReadingSerializer{format: format, registry: registry, subject: subject, schemaID: -1}, nil
+}
+
+// Format returns the wire format readings are encoded in
+func (s *ReadingSerializer) Format() string {
+	return s.format
+}
+
+// Serialize encodes a reading. Avro and Confluent encodings drop the site.
+func (s *ReadingSerializer) Serialize(reading *SensorReading) ([]byte, error) {
+	switch s.format {
+	case FormatConfluent:
+		schemaID, err := s.register()
+		if err != nil {
+			return nil, err
+		}
+		return SerializeSensorReadingConfluent(reading, schemaID)
+	case FormatAvro:
+		return SerializeSensorReadingAvro(reading)
+	case FormatJSONSchema:
+		return SerializeSensorReadingJSONSchema(reading)
+	default:
+		return SerializeSensorReading(reading)
+	}
+}
+
+// register returns the schema ID, registering the schema if it is not known
+// yet; a failed registration is retried by the next call
+func (s *ReadingSerializer) register() (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.schemaID < 0 {
+		schemaID, err := s.registry.Register(s.subject, SensorReadingAvroSchema)
+		if err != nil {
+			return 0, err
+		}
+		s.schemaID = schemaID
+	}
+	return s.schemaID, nil
+}
+
+// ConfluentSchemaID returns the schema ID of a Confluent wire-format message
+func ConfluentSchemaID(data []byte) (int32, bool) {
+	if len(data) < 5 || data[0] != confluentMagicByte {
+		return 0, false
+	}
+	return int32(binary.BigEndian.Uint32(data[1:5])), true
+}
+
+func isKnownFormat(format string) bool {
+	return format == FormatConfluent || format == FormatAvro || format == FormatJSONSchema || format == FormatJSON
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Incident statuses
+const (
+	IncidentOpen     = "open"
+	IncidentResolved = "resolved"
+)
+
+// Incident groups simultaneous sensor alerts at one site into a single notification
+type Incident struct {
+	ID         string         `json:"id"`
+	Site       string         `json:"site"`
+	Status     string         `json:"status"`
+	OpenedAt   int64          `json:"opened_at"`
+	UpdatedAt  int64          `json:"updated_at"`
+	ResolvedAt int64          `json:"resolved_at,omitempty"`
+	AlertCount int            `json:"alert_count"`
+	Sensors    int            `json:"sensors"`
+	Summary    string         `json:"summary"`
+	Alerts     []*SensorAlert `json:"alerts,omitempty"`
+
+	RunbookURL  string            `json:"runbook_url,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SerializeIncident serializes an incident to JSON format
+func SerializeIncident(incident *Incident) ([]byte, error) {
+	jsonData, err := json.Marshal(incident)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal incident to JSON: %w", err)
+	}
+	return jsonData, nil
+}
+
+// DeserializeIncident deserializes JSON data to an incident
+func DeserializeIncident(data []byte) (*Incident, error) {
+	var incident Incident
+	if err := json.Unmarshal(data, &incident); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON to incident: %w", err)
+	}
+	return &incident, nil
+}
+
+// Notification types
+const (
+	NotificationAlert    = "alert"
+	NotificationIncident = "incident"
+	NotificationSummary  = "summary"
+	NotificationBudget   = "budget"
+)
+
+// Notification is a page to deliver: an uncorrelated alert, an incident, a
+// summary of alerts suppressed by the alert budget or a budget breach
+type Notification struct {
+	Type     string        `json:"type"`
+	Alert    *SensorAlert  `json:"alert,omitempty"`
+	Incident *Incident     `json:"incident,omitempty"`
+	Summary  *AlertSummary `json:"summary,omitempty"`
+	Budget   *BudgetBreach `json:"budget,omitempty"`
+}
+
+// SerializeNotification serializes a notification to JSON format
+func SerializeNotification(notification *Notification) ([]byte, error) {
+	jsonData, err := json.Marshal(notification)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal notification to JSON: %w", err)
+	}
+	return jsonData, nil
+}
+
+// DeserializeNotification deserializes JSON data to a notification
+func DeserializeNotification(data []byte) (*Notification, error) {
+	var notification Notification
+	if err := json.Unmarshal(data, &notification); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON to notification: %w", err)
+	}
+	return &notification, nil
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// SensorReadingConnectSchemaName names the reading schema in json-schema envelopes
+const SensorReadingConnectSchemaName = "iot.SensorReading"
+
+// sensorReadingConnectSchema describes a reading in the Kafka Connect schema
+// language, so JsonConverter with schemas.enable=true reads typed fields
+// without a Schema Registry. Fields added since version 1 are optional.
+var sensorReadingConnectSchema = json.RawMessage(`{"type":"struct","name":"` + SensorReadingConnectSchemaName + `",` +
+	`"version":` + strconv.Itoa(SchemaVersion) + `,"optional":false,"fields":[` +
+	`{"field":"id","type":"string","optional":false},` +
+	`{"field":"ts","type":"int64","optional":false},` +
+	`{"field":"temperature","type":"float","optional":false},` +
+	`{"field":"humidity","type":"float","optional":false},` +
+	`{"field":"site","type":"string","optional":true},` +
+	`{"field":"battery_pct","type":"float","optional":true},` +
+	`{"field":"rssi","type":"int32","optional":true},` +
+	`{"field":"zone","type":"string","optional":true},` +
+	`{"field":"location","type":"struct","optional":true,"fields":[` +
+	`{"field":"lat","type":"double","optional":false},{"field":"lon","type":"double","optional":false}]}]}`)
+
+// jsonSchemaEnvelope is a json-schema message: the schema first, then the reading
+type jsonSchemaEnvelope struct {
+	Schema  json.RawMessage `json:"schema"`
+	Payload *SensorReading  `json:"payload"`
+}
+
+// SerializeSensorReadingJSONSchema serializes a sensor reading as JSON wrapped
+// in an envelope carrying its schema
+func SerializeSensorReadingJSONSchema(reading *SensorReading) ([]byte, error) {
+	data, err := json.Marshal(jsonSchemaEnvelope{Schema: sensorReadingConnectSchema, Payload: reading})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sensor reading to JSON: %w", err)
+	}
+	return data, nil
+}
+
+// DeserializeSensorReadingJSONSchema deserializes a json-schema envelope. The
+// schema must be the reading schema, of any version: fields a version does
+// not know are optional or ignored.
+func DeserializeSensorReadingJSONSchema(data []byte) (*SensorReading, error) {
+	var envelope struct {
+		Schema *struct {
+			Name string `json:"name"`
+		} `json:"schema"`
+		Payload *SensorReading `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json-schema envelope: %w", err)
+	}
+	if envelope.Schema == nil || envelope.Payload == nil {
+		return nil, errors.New("payload is not a json-schema envelope")
+	}
+	if envelope.Schema.Name != SensorReadingConnectSchemaName {
+		return nil, fmt.Errorf("schema %q is not %s", envelope.Schema.Name, SensorReadingConnectSchemaName)
+	}
+	return envelope.Payload, nil
+}
+
+// isJSONSchemaEnvelope reports whether a JSON object starts with a schema,
+// as envelopes written by this package and by JsonConverter do
+func isJSONSchemaEnvelope(data []byte) bool {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return false
+	}
+	key, err := decoder.Token()
+	return err == nil && key == "schema"
+}
@@ -3,30 +3,91 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"unsafe"
 
 	"github.com/google/uuid"
 )
 
+// SchemaVersion is the version of the reading and alert payloads, sent in
+// the schema version header of produced messages. Bump it on changes that
+// older consumers cannot decode. Version 2 added the optional battery and
+// signal strength of readings to the Avro encoding, version 3 their site, zone
+// and location.
+const SchemaVersion = 3
+
 // SensorReading represents a reading from an IoT sensor
 type SensorReading struct {
 	ID          string  `json:"id"`
 	Timestamp   int64   `json:"ts"`
 	Temperature float32 `json:"temperature"`
 	Humidity    float32 `json:"humidity"`
+	Site        string  `json:"site,omitempty"`
+	// BatteryPct is the battery charge in % and RSSI the received signal
+	// strength in dBm; sensors that do not report them leave them nil
+	BatteryPct *float32 `json:"battery_pct,omitempty"`
+	RSSI       *int32   `json:"rssi,omitempty"`
+	// Zone locates the sensor within its site and Location on the map; both
+	// are optional
+	Zone     string    `json:"zone,omitempty"`
+	Location *GeoPoint `json:"location,omitempty"`
+}
+
+// GeoPoint is a WGS 84 position in degrees. Its JSON form is an Elasticsearch
+// geo_point.
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
 }
 
 // SensorAlert represents an alert generated from an anomalous sensor reading
 type SensorAlert struct {
-	SensorID    string  `json:"sensor_id"`
-	Timestamp   int64   `json:"ts"`
-	Reason      string  `json:"reason"`
-	Temperature float32 `json:"temperature"`
-	Humidity    float32 `json:"humidity"`
+	SensorID    string            `json:"sensor_id"`
+	Timestamp   int64             `json:"ts"`
+	Reason      string            `json:"reason"`
+	Temperature float32           `json:"temperature"`
+	Humidity    float32           `json:"humidity"`
+	Site        string            `json:"site,omitempty"`
+	Rule        string            `json:"rule,omitempty"`
+	RunbookURL  string            `json:"runbook_url,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Zone        string            `json:"zone,omitempty"`
+	Location    *GeoPoint         `json:"location,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
 }
 
-// InitSchemaRegistry is kept for backward compatibility but does nothing
+// schemaRegistry checks the schema IDs of Confluent-framed readings; nil skips the check
+var schemaRegistry *SchemaRegistry
+
+// InitSchemaRegistry sets the Schema Registry that Confluent-framed readings
+// are checked against when decoded. An empty url disables the check.
+// It must be called before readings are decoded.
 func InitSchemaRegistry(url string) {
-	// No-op in JSON implementation
+	if url == "" {
+		schemaRegistry = nil
+		return
+	}
+	schemaRegistry = NewSchemaRegistry(url)
+}
+
+// DefaultSchemaRegistry returns the registry set by InitSchemaRegistry, or nil
+func DefaultSchemaRegistry() *SchemaRegistry {
+	return schemaRegistry
+}
+
+// MemorySize estimates the bytes a decoded reading occupies, for accounting
+// the memory of readings in flight
+func (r *SensorReading) MemorySize() int {
+	size := int(unsafe.Sizeof(*r)) + len(r.ID) + len(r.Site) + len(r.Zone)
+	if r.BatteryPct != nil {
+		size += int(unsafe.Sizeof(*r.BatteryPct))
+	}
+	if r.RSSI != nil {
+		size += int(unsafe.Sizeof(*r.RSSI))
+	}
+	if r.Location != nil {
+		size += int(unsafe.Sizeof(*r.Location))
+	}
+	return size
 }
 
 // NewSensorReading creates a new sensor reading with a random UUID
@@ -47,6 +108,9 @@ func NewSensorAlert(reading *SensorReading, reason string) *SensorAlert {
 		Reason:      reason,
 		Temperature: reading.Temperature,
 		Humidity:    reading.Humidity,
+		Site:        reading.Site,
+		Zone:        reading.Zone,
+		Location:    reading.Location,
 	}
 }
 
@@ -89,11 +153,48 @@ func DeserializeSensorAlert(data []byte) (*SensorAlert, error) {
 // ValidateSensorReading checks if a sensor reading is within valid ranges
 // Returns true if valid, false if invalid
 func ValidateSensorReading(reading *SensorReading) (bool, string) {
-	if reading.Temperature > 50.0 {
-		return false, "Temperature exceeds 50°C"
+	rule, reason := CheckSensorReading(reading)
+	return rule == "", reason
+}
+
+// Default detector thresholds
+const (
+	DefaultMaxTemperature float32 = 50.0
+	DefaultMinHumidity    float32 = 10.0
+	DefaultMinBatteryPct  float32 = 15.0
+	DefaultMinRSSI        int32   = -90
+)
+
+// CheckSensorReading checks a reading against the default thresholds; see
+// CheckThresholds and CheckDeviceHealth
+func CheckSensorReading(reading *SensorReading) (string, string) {
+	if rule, reason := CheckThresholds(reading, DefaultMaxTemperature, DefaultMinHumidity); rule != "" {
+		return rule, reason
+	}
+	return CheckDeviceHealth(reading, DefaultMinBatteryPct, DefaultMinRSSI)
+}
+
+// CheckThresholds returns the name of the first rule the reading violates
+// and a human-readable reason, or empty strings if the reading is valid
+func CheckThresholds(reading *SensorReading, maxTemperature, minHumidity float32) (string, string) {
+	if reading.Temperature > maxTemperature {
+		return RuleTemperatureHigh, fmt.Sprintf("Temperature exceeds %g°C", maxTemperature)
+	}
+	if reading.Humidity < minHumidity {
+		return RuleHumidityLow, fmt.Sprintf("Humidity below %g%%", minHumidity)
+	}
+	return "", ""
+}
+
+// CheckDeviceHealth returns the name of the first rule the reading's battery
+// or signal strength violates and a human-readable reason, or empty strings.
+// Readings without the field pass, and a zero limit disables its rule.
+func CheckDeviceHealth(reading *SensorReading, minBatteryPct float32, minRSSI int32) (string, string) {
+	if minBatteryPct != 0 && reading.BatteryPct != nil && *reading.BatteryPct < minBatteryPct {
+		return RuleBatteryLow, fmt.Sprintf("Battery below %g%%", minBatteryPct)
 	}
-	if reading.Humidity < 10.0 {
-		return false, "Humidity below 10%"
+	if minRSSI != 0 && reading.RSSI != nil && *reading.RSSI < minRSSI {
+		return RuleSignalWeak, fmt.Sprintf("Signal strength below %d dBm", minRSSI)
 	}
-	return true, ""
+	return "", ""
 }
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSchemaNotFound is returned when the registry does not know a schema ID
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// SchemaRegistry is a minimal Confluent Schema Registry client. Schemas are
+// immutable once registered, so lookups are cached for the life of the process.
+type SchemaRegistry struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	schemas map[int32]string
+	checks  map[int32]error
+	// warned is when an unreachable registry was last logged
+	warned time.Time
+}
+
+// NewSchemaRegistry creates a client for the registry at url
+func NewSchemaRegistry(url string) *SchemaRegistry {
+	return &SchemaRegistry{
+		url:     strings.TrimRight(url, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		schemas: make(map[int32]string),
+		checks:  make(map[int32]error),
+	}
+}
+
+// Register registers an Avro schema under subject and returns its ID.
+// Registering a schema the subject already has returns the existing ID.
+func (r *SchemaRegistry) Register(subject, schema string) (int32, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+
+	var result struct {
+		ID int32 `json:"id"`
+	}
+	if err := r.do(http.MethodPost, "/subjects/"+subject+"/versions", body, &result); err != nil {
+		return 0, fmt.Errorf("failed to register schema for %s: %w", subject, err)
+	}
+
+	r.mu.Lock()
+	r.schemas[result.ID] = schema
+	r.mu.Unlock()
+	return result.ID, nil
+}
+
+// Schema returns the schema registered under id
+func (r *SchemaRegistry) Schema(id int32) (string, error) {
+	r.mu.Lock()
+	schema, ok := r.schemas[id]
+	r.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	var result struct {
+		Schema string `json:"schema"`
+	}
+	if err := r.do(http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &result); err != nil {
+		return "", fmt.Errorf("failed to look up schema %d: %w", id, err)
+	}
+
+	r.mu.Lock()
+	r.schemas[id] = result.Schema
+	r.mu.Unlock()
+	return result.Schema, nil
+}
+
+// CheckReadingSchema returns an error unless id names a schema with the same
+// encoding as SensorReadingAvroSchema or one of its previous versions. Results are cached. If the registry is
+// unreachable the reading is accepted, since the built-in schema can still
+// decode it, and the ID is checked again on the next call.
+func (r *SchemaRegistry) CheckReadingSchema(id int32) error {
+	r.mu.Lock()
+	err, ok := r.checks[id]
+	r.mu.Unlock()
+	if ok {
+		return err
+	}
+
+	schema, err := r.Schema(id)
+	switch {
+	case errors.Is(err, ErrSchemaNotFound):
+		err = fmt.Errorf("unknown schema ID %d", id)
+	case err != nil:
+		r.mu.Lock()
+		if time.Since(r.warned) > time.Minute {
+			r.warned = time.Now()
+			slog.Warn("Decoding readings without checking their schema ID", "error", err)
+		}
+		r.mu.Unlock()
+		return nil
+	case !sameAvroRecord(schema, SensorReadingAvroSchema) && !sameAvroRecord(schema, sensorReadingAvroSchemaV2) &&
+		!sameAvroRecord(schema, sensorReadingAvroSchemaV1):
+		err = fmt.Errorf("schema ID %d does not match the sensor reading schema", id)
+	}
+
+	r.mu.Lock()
+	r.checks[id] = err
+	r.mu.Unlock()
+	return err
+}
+
+// do sends a request to the registry and decodes the JSON response into out
+func (r *SchemaRegistry) do(method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, r.url+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrSchemaNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("schema registry responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// avroRecord is the part of an Avro record schema compared by sameAvroRecord
+type avroRecord struct {
+	Type   string `json:"type"`
+	Fields []struct {
+		Name string          `json:"name"`
+		Type json.RawMessage `json:"type"`
+	} `json:"fields"`
+}
+
+// sameAvroRecord reports whether two record schemas have the same fields of
+// the same types in the same order, i.e. the same binary encoding
+func sameAvroRecord(a, b string) bool {
+	var ra, rb avroRecord
+	if json.Unmarshal([]byte(a), &ra) != nil || json.Unmarshal([]byte(b), &rb) != nil {
+		return false
+	}
+	if ra.Type != "record" || rb.Type != "record" || len(ra.Fields) != len(rb.Fields) {
+		return false
+	}
+	for i := range ra.Fields {
+		if ra.Fields[i].Name != rb.Fields[i].Name || !sameJSON(ra.Fields[i].Type, rb.Fields[i].Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameJSON compares two JSON values ignoring formatting
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	ca, _ := json.Marshal(va)
+	cb, _ := json.Marshal(vb)
+	return bytes.Equal(ca, cb)
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Site aggregate metrics that site rules can be evaluated against
+const (
+	SiteMetricAvgTemperature   = "avg_temperature"
+	SiteMetricMaxTemperature   = "max_temperature"
+	SiteMetricAvgHumidity      = "avg_humidity"
+	SiteMetricMinHumidity      = "min_humidity"
+	SiteMetricAlertingFraction = "alerting_fraction"
+)
+
+// SiteAggregate summarizes the readings of one site over a tumbling window
+type SiteAggregate struct {
+	Site             string  `json:"site"`
+	WindowStart      int64   `json:"window_start"`
+	WindowEnd        int64   `json:"window_end"`
+	Readings         int     `json:"readings"`
+	Sensors          int     `json:"sensors"`
+	AlertingSensors  int     `json:"alerting_sensors"`
+	AvgTemperature   float64 `json:"avg_temperature"`
+	MaxTemperature   float64 `json:"max_temperature"`
+	AvgHumidity      float64 `json:"avg_humidity"`
+	MinHumidity      float64 `json:"min_humidity"`
+	AlertingFraction float64 `json:"alerting_fraction"`
+}
+
+// Metric returns the named aggregate metric
+func (a *SiteAggregate) Metric(name string) (float64, bool) {
+	switch name {
+	case SiteMetricAvgTemperature:
+		return a.AvgTemperature, true
+	case SiteMetricMaxTemperature:
+		return a.MaxTemperature, true
+	case SiteMetricAvgHumidity:
+		return a.AvgHumidity, true
+	case SiteMetricMinHumidity:
+		return a.MinHumidity, true
+	case SiteMetricAlertingFraction:
+		return a.AlertingFraction, true
+	default:
+		return 0, false
+	}
+}
+
+// SiteAlert represents a site-level alert raised by an aggregate rule
+type SiteAlert struct {
+	Rule      string  `json:"rule"`
+	Site      string  `json:"site"`
+	Timestamp int64   `json:"ts"`
+	Since     int64   `json:"since"`
+	Reason    string  `json:"reason"`
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	Value     float64 `json:"value"`
+
+	RunbookURL  string            `json:"runbook_url,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SerializeSiteAlert serializes a site alert to JSON format
+func SerializeSiteAlert(alert *SiteAlert) ([]byte, error) {
+	jsonData, err := json.Marshal(alert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal site alert to JSON: %w", err)
+	}
+	return jsonData, nil
+}
+
+// DeserializeSiteAlert deserializes JSON data to a site alert
+func DeserializeSiteAlert(data []byte) (*SiteAlert, error) {
+	var alert SiteAlert
+	if err := json.Unmarshal(data, &alert); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON to site alert: %w", err)
+	}
+	return &alert, nil
+}
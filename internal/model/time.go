@@ -0,0 +1,24 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ParseTime parses an optional RFC 3339 time or Unix timestamp in
+// milliseconds, as taken by the query API and the command-line tools; an
+// empty value is the zero time
+func ParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 or Unix milliseconds, got %q", value)
+	}
+	return t, nil
+}
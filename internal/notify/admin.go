@@ -0,0 +1,344 @@
+package notify
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+// headerActor names who changes a template, for the logs and created_by
+const headerActor = "X-Actor"
+
+// TemplateAdmin reloads the templates stored in PostgreSQL into the
+// dispatcher every interval, and serves admin endpoints managing them guarded
+// by "Authorization: Bearer <adminToken>"; they are disabled when adminToken
+// is empty:
+//
+//	GET    /admin/templates                     list the current templates
+//	GET    /admin/templates/<name>[?version=N]  get the current or a past version
+//	GET    /admin/templates/<name>/versions     list every version, newest first
+//	PUT    /admin/templates/<name>              store a new version
+//	DELETE /admin/templates/<name>              delete every version
+//	POST   /admin/templates/<name>/test         send the sample alert to a destination
+type TemplateAdmin struct {
+	store      *TemplateStore
+	dispatcher *Dispatcher
+	interval   time.Duration
+	timeout    time.Duration
+	adminToken string
+	postgres   *db.PostgresDB
+	mux        *http.ServeMux
+	logger     *slog.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewTemplateAdmin creates a template admin refreshing dispatcher from store
+// every interval; each refresh is bounded by timeout. logger may be nil.
+func NewTemplateAdmin(store *TemplateStore, dispatcher *Dispatcher, interval, timeout time.Duration, adminToken string, logger *slog.Logger) *TemplateAdmin {
+	if timeout <= 0 {
+		timeout = interval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &TemplateAdmin{
+		store:      store,
+		dispatcher: dispatcher,
+		interval:   interval,
+		timeout:    timeout,
+		adminToken: adminToken,
+		mux:        http.NewServeMux(),
+		logger:     logging.OrDefault(logger),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	a.mux.HandleFunc("GET /admin/templates", a.requireAdmin(a.list))
+	a.mux.HandleFunc("GET /admin/templates/{name}", a.requireAdmin(a.get))
+	a.mux.HandleFunc("GET /admin/templates/{name}/versions", a.requireAdmin(a.versions))
+	a.mux.HandleFunc("PUT /admin/templates/{name}", a.requireAdmin(a.put))
+	a.mux.HandleFunc("DELETE /admin/templates/{name}", a.requireAdmin(a.delete))
+	a.mux.HandleFunc("POST /admin/templates/{name}/test", a.requireAdmin(a.test))
+	return a
+}
+
+// NewTemplateAdminFromConfig connects to the template database and creates a
+// template admin. It returns nil, nil when stored templates are disabled.
+func NewTemplateAdminFromConfig(cfg *config.Config, dispatcher *Dispatcher, logger *slog.Logger) (*TemplateAdmin, error) {
+	if cfg.NotifierTemplateRefreshInterval <= 0 {
+		return nil, nil
+	}
+
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect template database: %w", err)
+	}
+
+	admin := NewTemplateAdmin(NewTemplateStore(postgres.DB()), dispatcher, cfg.NotifierTemplateRefreshInterval,
+		cfg.StoreTimeout, cfg.NotifierAdminToken, logger)
+	admin.postgres = postgres
+	return admin, nil
+}
+
+// Start loads the stored templates and refreshes them in the background
+func (a *TemplateAdmin) Start() {
+	if err := a.refresh(); err != nil {
+		a.logger.Warn("Failed to load stored templates", "error", err)
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := a.refresh(); err != nil {
+					a.logger.Warn("Failed to refresh stored templates", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing and closes the database connection if the admin owns it
+func (a *TemplateAdmin) Stop() {
+	a.cancel()
+	a.wg.Wait()
+	if a.postgres != nil {
+		a.postgres.Close()
+	}
+}
+
+// refresh reloads the templates under the admin's lifetime and timeout
+func (a *TemplateAdmin) refresh() error {
+	ctx, cancel := context.WithTimeout(a.ctx, a.timeout)
+	defer cancel()
+	return a.Refresh(ctx)
+}
+
+// Refresh loads the current stored templates into the dispatcher
+func (a *TemplateAdmin) Refresh(ctx context.Context) error {
+	templates, err := a.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	a.dispatcher.ApplyTemplates(templates)
+	return nil
+}
+
+// ServeHTTP serves the admin endpoints
+func (a *TemplateAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *TemplateAdmin) list(w http.ResponseWriter, r *http.Request) {
+	templates, err := a.store.List(r.Context())
+	if err != nil {
+		a.logger.Error("Failed to list templates", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list templates")
+		return
+	}
+	if templates == nil {
+		templates = []*StoredTemplate{}
+	}
+	writeJSON(w, http.StatusOK, templates)
+}
+
+func (a *TemplateAdmin) get(w http.ResponseWriter, r *http.Request) {
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil || version < 1 {
+			writeError(w, http.StatusBadRequest, "version must be a positive integer")
+			return
+		}
+	}
+
+	t, err := a.store.Get(r.Context(), r.PathValue("name"), version)
+	switch {
+	case errors.Is(err, ErrTemplateNotFound):
+		writeError(w, http.StatusNotFound, "template not found")
+	case err != nil:
+		a.logger.Error("Failed to load template", "template", r.PathValue("name"), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load template")
+	default:
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+func (a *TemplateAdmin) versions(w http.ResponseWriter, r *http.Request) {
+	versions, err := a.store.Versions(r.Context(), r.PathValue("name"))
+	switch {
+	case errors.Is(err, ErrTemplateNotFound):
+		writeError(w, http.StatusNotFound, "template not found")
+	case err != nil:
+		a.logger.Error("Failed to list template versions", "template", r.PathValue("name"), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list template versions")
+	default:
+		writeJSON(w, http.StatusOK, versions)
+	}
+}
+
+// put stores a new version of a template, created by the actor of X-Actor,
+// and applies it to this notifier's destinations at once
+func (a *TemplateAdmin) put(w http.ResponseWriter, r *http.Request) {
+	actor := r.Header.Get(headerActor)
+	if actor == "" {
+		writeError(w, http.StatusBadRequest, headerActor+" header is required")
+		return
+	}
+	var req struct {
+		Format string `json:"format"`
+		Body   string `json:"body"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxTemplateBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	t := &StoredTemplate{Name: r.PathValue("name"), Format: req.Format, Body: req.Body}
+	err := a.store.Save(r.Context(), t, actor)
+	switch {
+	case errors.Is(err, ErrInvalidTemplate):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, ErrTemplateConflict):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		a.logger.Error("Failed to store template", "template", t.Name, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to store template")
+		return
+	}
+
+	a.logger.Info("Stored template", "template", t.Name, "version", t.Version, "format", t.Format, "actor", actor)
+	if err := a.refresh(); err != nil {
+		a.logger.Warn("Failed to refresh stored templates", "error", err)
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// delete removes every version of a template; destinations naming it fall
+// back to their own template
+func (a *TemplateAdmin) delete(w http.ResponseWriter, r *http.Request) {
+	actor := r.Header.Get(headerActor)
+	if actor == "" {
+		writeError(w, http.StatusBadRequest, headerActor+" header is required")
+		return
+	}
+
+	err := a.store.Delete(r.Context(), r.PathValue("name"))
+	switch {
+	case errors.Is(err, ErrTemplateNotFound):
+		writeError(w, http.StatusNotFound, "template not found")
+		return
+	case err != nil:
+		a.logger.Error("Failed to delete template", "template", r.PathValue("name"), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete template")
+		return
+	}
+
+	a.logger.Info("Deleted template", "template", r.PathValue("name"), "actor", actor)
+	if err := a.refresh(); err != nil {
+		a.logger.Warn("Failed to refresh stored templates", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// test renders the current or a past version of a template with the sample
+// alert and delivers it to a destination, answering 502 when delivery fails
+func (a *TemplateAdmin) test(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Destination string `json:"destination"`
+		Version     int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Destination == "" || req.Version < 0 {
+		writeError(w, http.StatusBadRequest, "destination is required and version must not be negative")
+		return
+	}
+
+	t, err := a.store.Get(r.Context(), r.PathValue("name"), req.Version)
+	switch {
+	case errors.Is(err, ErrTemplateNotFound):
+		writeError(w, http.StatusNotFound, "template not found")
+		return
+	case err != nil:
+		a.logger.Error("Failed to load template", "template", r.PathValue("name"), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load template")
+		return
+	}
+
+	rendered, err := a.dispatcher.SendTest(r.Context(), req.Destination, t)
+	switch {
+	case errors.Is(err, ErrDestinationNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrInvalidTemplate):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result := map[string]any{
+		"template":    t.Name,
+		"version":     t.Version,
+		"format":      t.Format,
+		"destination": req.Destination,
+		"rendered":    rendered,
+		"delivered":   err == nil,
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadGateway
+		result["error"] = err.Error()
+	}
+	a.logger.Info("Sent test notification", "template", t.Name, "version", t.Version, "destination", req.Destination,
+		"delivered", err == nil, "actor", r.Header.Get(headerActor))
+	writeJSON(w, status, result)
+}
+
+// requireAdmin guards a handler with the admin bearer token
+func (a *TemplateAdmin) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if a.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
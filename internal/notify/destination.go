@@ -0,0 +1,379 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Destination types
+const (
+	TypeWebhook   = "webhook"
+	TypeSlack     = "slack"
+	TypePagerDuty = "pagerduty"
+	TypeEmail     = "email"
+)
+
+// TLS modes of email destinations
+const (
+	// TLSStartTLS upgrades the connection with STARTTLS and fails if the
+	// server does not offer it
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects over TLS, as to port 465
+	TLSImplicit = "tls"
+	// TLSNone sends in plain text; credentials are only sent to localhost
+	TLSNone = "none"
+)
+
+// RecipientsDefault is the recipients key of severities not listed
+const RecipientsDefault = "default"
+
+// DefaultPagerDutyURL is the PagerDuty Events API v2 endpoint
+const DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// DefaultTemplate renders the text of Slack messages, PagerDuty summaries,
+// emails and webhook payloads without a template of their own
+const DefaultTemplate = `[{{.Severity}}] {{.Alert.SensorID}}{{if .Alert.Site}} at {{.Alert.Site}}{{end}}: {{.Alert.Reason}}`
+
+// Destinations is the notifier configuration file
+type Destinations struct {
+	Destinations []Destination `yaml:"destinations"`
+}
+
+// Destination is a place alerts are delivered to
+type Destination struct {
+	// Name labels the destination in logs and metrics
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// URL receives the notifications; PagerDuty defaults to DefaultPagerDutyURL
+	URL string `yaml:"url"`
+	// RoutingKey is the integration key of a PagerDuty service
+	RoutingKey string `yaml:"routing_key"`
+	// Headers are added to webhook requests, e.g. for authentication
+	Headers map[string]string `yaml:"headers"`
+	// Template is a text/template executed with a Message. It renders the
+	// whole request body of a webhook, the text of Slack messages and
+	// PagerDuty summaries, and email bodies, once per alert in digests
+	// (empty uses DefaultTemplate).
+	Template string `yaml:"template"`
+	// TemplateName names a template stored through the template API, used
+	// instead of Template once loaded; Template applies until then and after
+	// the stored template is deleted
+	TemplateName string `yaml:"template_name"`
+	// Severities limits the destination to alerts of these severities (empty is all)
+	Severities []string `yaml:"severities"`
+	// Tags limits the destination to alerts of sensors carrying at least one
+	// of these tags (empty is all), e.g. coldchain for a logistics channel
+	Tags []string `yaml:"tags"`
+	// RateLimit is the notifications per minute the destination receives,
+	// up to Burst at once; alerts beyond it are dropped (0 is unlimited)
+	RateLimit float64 `yaml:"rate_limit"`
+	Burst     int     `yaml:"burst"`
+	// Retry bounds the attempts at delivering one notification
+	Retry Retry `yaml:"retry"`
+	// Timeout bounds each request (0 uses NOTIFIER_TIMEOUT)
+	Timeout time.Duration `yaml:"timeout"`
+
+	// From, the SMTP credentials and the TLS mode of email destinations,
+	// whose URL is smtp://host:port (587 by default) or smtps://host:port
+	// (465 by default, implies tls)
+	From     string `yaml:"from"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	TLS      string `yaml:"tls"`
+	// Recipients are the addresses emailed by alert severity; the
+	// RecipientsDefault list receives the severities not listed
+	Recipients map[string][]string `yaml:"recipients"`
+	// Digest sends one email per interval summarizing the alerts of each
+	// recipient list instead of one per alert (0 sends each alert)
+	Digest time.Duration `yaml:"digest"`
+
+	template *template.Template
+	// stored holds the compiled template of TemplateName once loaded; it is
+	// shared by the copies of the destination
+	stored *atomic.Pointer[compiledTemplate]
+}
+
+// Retry is the retry policy of a destination, named like the settings of
+// PRODUCER_RETRY_POLICIES; zero fields take DefaultRetry's
+type Retry struct {
+	Attempts int           `yaml:"attempts"`
+	Initial  time.Duration `yaml:"initial"`
+	Max      time.Duration `yaml:"max"`
+	Jitter   float64       `yaml:"jitter"`
+	Deadline time.Duration `yaml:"deadline"`
+}
+
+// DefaultRetry makes 5 attempts, waiting 1s, 2s, 4s then 8s ±20%, for at
+// most 5 minutes
+var DefaultRetry = Retry{
+	Attempts: 5,
+	Initial:  time.Second,
+	Max:      time.Minute,
+	Jitter:   0.2,
+	Deadline: 5 * time.Minute,
+}
+
+// Message is the data templates are executed with
+type Message struct {
+	// Kind is the notification type: model.NotificationAlert for an alert,
+	// or the type of a correlator page presented as an alert (see
+	// NotificationMessage)
+	Kind     string
+	Alert    *model.SensorAlert
+	Severity string
+	// Time is when the alerting reading was taken
+	Time time.Time
+}
+
+// NewMessage returns the template data of an alert
+func NewMessage(alert *model.SensorAlert) Message {
+	return Message{Kind: model.NotificationAlert, Alert: alert, Severity: model.AlertSeverity(alert), Time: time.UnixMilli(alert.Timestamp).UTC()}
+}
+
+// templateFuncs are available to templates; json quotes a value for
+// embedding in a JSON body
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+}
+
+// LoadDestinations reads and validates a notifier configuration file. Unknown
+// fields are rejected so typos do not silently drop a setting. ${VAR}
+// references in URLs, routing keys, headers and SMTP credentials are replaced
+// with environment variables, so secrets need not be stored in the file.
+func LoadDestinations(path string) ([]Destination, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var file Destinations
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(file.Destinations) == 0 {
+		return nil, fmt.Errorf("no destinations in %s", path)
+	}
+
+	names := make(map[string]bool)
+	for i := range file.Destinations {
+		destination := &file.Destinations[i]
+		if err := destination.validate(); err != nil {
+			return nil, fmt.Errorf("invalid destination %d of %s: %w", i+1, path, err)
+		}
+		if names[destination.Name] {
+			return nil, fmt.Errorf("duplicate destination %q in %s", destination.Name, path)
+		}
+		names[destination.Name] = true
+	}
+	return file.Destinations, nil
+}
+
+// validate checks a destination, expands its environment references and
+// parses its template
+func (d *Destination) validate() error {
+	d.URL = os.ExpandEnv(d.URL)
+	d.RoutingKey = os.ExpandEnv(d.RoutingKey)
+	d.Username = os.ExpandEnv(d.Username)
+	d.Password = os.ExpandEnv(d.Password)
+	for name, value := range d.Headers {
+		d.Headers[name] = os.ExpandEnv(value)
+	}
+
+	if d.Name == "" {
+		d.Name = d.Type
+	}
+	switch d.Type {
+	case TypeWebhook, TypeSlack:
+		if d.URL == "" {
+			return fmt.Errorf("%s: missing url", d.Name)
+		}
+	case TypePagerDuty:
+		if d.RoutingKey == "" {
+			return fmt.Errorf("%s: missing routing_key", d.Name)
+		}
+		if d.URL == "" {
+			d.URL = DefaultPagerDutyURL
+		}
+	case TypeEmail:
+		if err := d.validateEmail(); err != nil {
+			return fmt.Errorf("%s: %w", d.Name, err)
+		}
+	case "":
+		return fmt.Errorf("%s: missing type", d.Name)
+	default:
+		return fmt.Errorf("%s: unknown type %q: expected %s, %s, %s or %s", d.Name, d.Type, TypeWebhook, TypeSlack, TypePagerDuty, TypeEmail)
+	}
+
+	if d.RateLimit < 0 || d.Burst < 0 || d.Timeout < 0 || d.Digest < 0 {
+		return fmt.Errorf("%s: rate_limit, burst, timeout and digest must not be negative", d.Name)
+	}
+	if d.Retry.Attempts < 0 || d.Retry.Initial < 0 || d.Retry.Max < 0 || d.Retry.Jitter < 0 || d.Retry.Jitter > 1 || d.Retry.Deadline < 0 {
+		return fmt.Errorf("%s: retry settings must not be negative, and jitter at most 1", d.Name)
+	}
+
+	for i, tag := range d.Tags {
+		d.Tags[i] = strings.ToLower(strings.TrimSpace(tag))
+	}
+
+	text := d.Template
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New(d.Name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return fmt.Errorf("%s: invalid template: %w", d.Name, err)
+	}
+	d.template = tmpl
+
+	if d.TemplateName != "" {
+		if !templateName.MatchString(d.TemplateName) {
+			return fmt.Errorf("%s: invalid template_name %q", d.Name, d.TemplateName)
+		}
+		d.stored = new(atomic.Pointer[compiledTemplate])
+	}
+	return nil
+}
+
+// accepts reports whether the destination takes the alert of message, by
+// its severity and the tags of its sensor
+func (d *Destination) accepts(message Message) bool {
+	return d.acceptsSeverity(message.Severity) && d.acceptsTags(message.Alert.Tags)
+}
+
+func (d *Destination) acceptsSeverity(severity string) bool {
+	if len(d.Severities) == 0 {
+		return true
+	}
+	for _, accepted := range d.Severities {
+		if strings.EqualFold(accepted, severity) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Destination) acceptsTags(tags []string) bool {
+	if len(d.Tags) == 0 {
+		return true
+	}
+	for _, accepted := range d.Tags {
+		for _, tag := range tags {
+			if accepted == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// render executes the destination's template with message and returns the
+// text with its format: that of the stored template named by TemplateName
+// once loaded, otherwise FormatText
+func (d *Destination) render(message Message) (string, string, error) {
+	return d.renderWith(d.storedTemplate(), message)
+}
+
+// renderWith executes stored with message, or the destination's own
+// template when stored is nil
+func (d *Destination) renderWith(stored *compiledTemplate, message Message) (string, string, error) {
+	if stored != nil {
+		text, err := stored.render(message)
+		return text, stored.format, err
+	}
+	text, err := d.renderText(message)
+	return text, FormatText, err
+}
+
+// renderText executes the destination's own template with message
+func (d *Destination) renderText(message Message) (string, error) {
+	var buf bytes.Buffer
+	if err := d.template.Execute(&buf, message); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// storedTemplate returns the loaded stored template, or nil
+func (d *Destination) storedTemplate() *compiledTemplate {
+	if d.stored == nil {
+		return nil
+	}
+	return d.stored.Load()
+}
+
+// retryPolicy returns the destination's retry settings with defaults for unset ones
+func (d *Destination) retryPolicy() Retry {
+	retry := d.Retry
+	if retry.Attempts == 0 {
+		retry.Attempts = DefaultRetry.Attempts
+	}
+	if retry.Initial == 0 {
+		retry.Initial = DefaultRetry.Initial
+	}
+	if retry.Max == 0 {
+		retry.Max = DefaultRetry.Max
+	}
+	if retry.Jitter == 0 {
+		retry.Jitter = DefaultRetry.Jitter
+	}
+	if retry.Deadline == 0 {
+		retry.Deadline = DefaultRetry.Deadline
+	}
+	return retry
+}
+
+// limiter is a token bucket admitting rate notifications per second, up to
+// burst at once
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns a limiter for perMinute notifications, or nil when unlimited
+func newLimiter(perMinute float64, burst int) *limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{rate: perMinute / 60, burst: float64(burst), tokens: float64(burst)}
+}
+
+// allow takes a token if one is left at now
+func (l *limiter) allow(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,590 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultTimeout bounds each request when no timeout is configured
+const defaultTimeout = 10 * time.Second
+
+// Notification outcomes, the values of the outcome label
+const (
+	OutcomeSent        = "sent"
+	OutcomeFailed      = "failed"
+	OutcomeRateLimited = "rate_limited"
+	OutcomeFiltered    = "filtered"
+)
+
+// Metrics holds Prometheus metrics for notification delivery
+type Metrics struct {
+	Notifications *prometheus.CounterVec
+	Retries       *prometheus.CounterVec
+	Duration      *prometheus.HistogramVec
+	DecodeErrors  prometheus.Counter
+}
+
+// NewMetrics creates a new set of notifier metrics
+func NewMetrics(namespace, subsystem string, registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		Notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "notifications_total",
+			Help:      "Total number of alerts by destination and outcome (sent, failed, rate_limited, filtered)",
+		}, []string{"destination", "outcome"}),
+		Retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "retries_total",
+			Help:      "Total number of retried deliveries by destination",
+		}, []string{"destination"}),
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "delivery_duration_seconds",
+			Help:      "Time taken to deliver a notification, retries included, in seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+		}, []string{"destination"}),
+		DecodeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "decode_errors_total",
+			Help:      "Total number of messages skipped because they could not be decoded",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Notifications,
+		metrics.Retries,
+		metrics.Duration,
+		metrics.DecodeErrors,
+	)
+
+	return metrics
+}
+
+// ErrDestinationNotFound is returned for a destination that is not configured
+var ErrDestinationNotFound = errors.New("destination not found")
+
+// permanentError is a delivery failure that retrying cannot fix, such as a
+// rejected request
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// retryAfterError is a throttled delivery the destination asked to retry
+// after a delay
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// Dispatcher delivers alerts to every destination that accepts them, each
+// with its own template, rate limit and retries
+type Dispatcher struct {
+	destinations []*target
+	client       *http.Client
+	metrics      *Metrics
+	logger       *slog.Logger
+
+	// ctx is cancelled on Stop, ending the digest loops
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// target is a destination with its delivery state
+type target struct {
+	Destination
+	retry   Retry
+	limiter *limiter
+	// digest queues the alerts of email destinations with a digest interval
+	digest *digest
+}
+
+// NewDispatcher creates a dispatcher for destinations validated by
+// LoadDestinations. timeout bounds each request of destinations without a
+// timeout of their own (0 uses defaultTimeout). metrics and logger may be nil.
+// Email digests are sent once Start is called.
+func NewDispatcher(destinations []Destination, timeout time.Duration, metrics *Metrics, logger *slog.Logger) *Dispatcher {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		client:  &http.Client{},
+		metrics: metrics,
+		logger:  logging.OrDefault(logger),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, destination := range destinations {
+		if destination.Timeout == 0 {
+			destination.Timeout = timeout
+		}
+		d.destinations = append(d.destinations, &target{
+			Destination: destination,
+			retry:       destination.retryPolicy(),
+			limiter:     newLimiter(destination.RateLimit, destination.Burst),
+			digest:      newDigest(destination),
+		})
+	}
+	return d
+}
+
+// Start sends the digests of email destinations every digest interval
+func (d *Dispatcher) Start() {
+	for _, destination := range d.destinations {
+		if destination.digest == nil {
+			continue
+		}
+		d.wg.Add(1)
+		go func(destination *target) {
+			defer d.wg.Done()
+
+			ticker := time.NewTicker(destination.Digest)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-d.ctx.Done():
+					return
+				case <-ticker.C:
+					d.sendDigest(d.ctx, destination)
+				}
+			}
+		}(destination)
+	}
+}
+
+// Stop ends the digest loops and sends the alerts queued for digests, within
+// the retry deadline of their destination
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+	for _, destination := range d.destinations {
+		if destination.digest != nil {
+			d.sendDigest(context.Background(), destination)
+		}
+	}
+}
+
+// Dispatch delivers an alert to all destinations at once and waits for them.
+// A destination that fails every attempt is logged and counted, not
+// returned, so the others are not notified again; only a cancelled ctx is
+// returned.
+func (d *Dispatcher) Dispatch(ctx context.Context, alert *model.SensorAlert) error {
+	return d.DispatchMessage(ctx, NewMessage(alert))
+}
+
+// DispatchMessage delivers a message, such as a correlator page, like Dispatch
+func (d *Dispatcher) DispatchMessage(ctx context.Context, message Message) error {
+	var wg sync.WaitGroup
+	for _, destination := range d.destinations {
+		wg.Add(1)
+		go func(destination *target) {
+			defer wg.Done()
+			d.dispatchTo(ctx, destination, message)
+		}(destination)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// dispatchTo delivers a message to one destination unless filtered or rate limited
+func (d *Dispatcher) dispatchTo(ctx context.Context, destination *target, message Message) {
+	logger := d.logger.With("destination", destination.Name, "sensor_id", message.Alert.SensorID, "rule", message.Alert.Rule)
+	if !destination.accepts(message) {
+		d.observe(destination, OutcomeFiltered)
+		return
+	}
+	if !destination.limiter.allow(time.Now()) {
+		d.observe(destination, OutcomeRateLimited)
+		logger.Warn("Dropping notification: destination rate limit reached", "rate_limit", destination.RateLimit)
+		return
+	}
+	if destination.Type == TypeEmail {
+		d.dispatchEmail(ctx, destination, message, logger)
+		return
+	}
+
+	body, contentType, err := destination.body(message)
+	if err != nil {
+		d.observe(destination, OutcomeFailed)
+		logger.Error("Failed to build notification", "error", err)
+		return
+	}
+
+	start := time.Now()
+	err = d.deliver(ctx, destination, func(ctx context.Context) error {
+		return d.post(ctx, destination, body, contentType)
+	})
+	if err != nil {
+		d.observe(destination, OutcomeFailed)
+		logger.Error("Failed to deliver notification", "error", err)
+		return
+	}
+	if d.metrics != nil {
+		d.metrics.Duration.WithLabelValues(destination.Name).Observe(time.Since(start).Seconds())
+	}
+	d.observe(destination, OutcomeSent)
+	logger.Debug("Delivered notification")
+}
+
+// dispatchEmail emails a message to the recipients of its severity, or
+// queues it for the destination's digest
+func (d *Dispatcher) dispatchEmail(ctx context.Context, destination *target, message Message, logger *slog.Logger) {
+	to := destination.recipients(message.Severity)
+	if len(to) == 0 {
+		d.observe(destination, OutcomeFiltered)
+		return
+	}
+	if destination.digest != nil {
+		destination.digest.add(to, message)
+		return
+	}
+
+	body, format, err := destination.render(message)
+	if err != nil {
+		d.observe(destination, OutcomeFailed)
+		logger.Error("Failed to build notification", "error", err)
+		return
+	}
+
+	start := time.Now()
+	err = d.deliver(ctx, destination, func(ctx context.Context) error {
+		return destination.sendMail(ctx, to, emailSubject(message), body, format)
+	})
+	if err != nil {
+		d.observe(destination, OutcomeFailed)
+		logger.Error("Failed to deliver notification", "error", err)
+		return
+	}
+	if d.metrics != nil {
+		d.metrics.Duration.WithLabelValues(destination.Name).Observe(time.Since(start).Seconds())
+	}
+	d.observe(destination, OutcomeSent)
+	logger.Debug("Delivered notification")
+}
+
+// sendDigest emails the queued alerts of a destination, one email per
+// recipient list; each alert is counted with the outcome of its email
+func (d *Dispatcher) sendDigest(ctx context.Context, destination *target) {
+	for _, batch := range destination.digest.take() {
+		logger := d.logger.With("destination", destination.Name, "alerts", len(batch.messages))
+		outcome := OutcomeSent
+		body, format, err := destination.digestBody(batch.messages, destination.Digest)
+		if err == nil {
+			start := time.Now()
+			err = d.deliver(ctx, destination, func(ctx context.Context) error {
+				return destination.sendMail(ctx, batch.to, digestSubject(batch.messages), body, format)
+			})
+			if err == nil && d.metrics != nil {
+				d.metrics.Duration.WithLabelValues(destination.Name).Observe(time.Since(start).Seconds())
+			}
+		}
+		if err != nil {
+			outcome = OutcomeFailed
+			logger.Error("Failed to deliver digest", "error", err)
+		} else {
+			logger.Debug("Delivered digest")
+		}
+		for range batch.messages {
+			d.observe(destination, outcome)
+		}
+	}
+}
+
+// deliver makes delivery attempts at a destination, each bounded by its
+// timeout, retrying failures that are not permanent with backoff until its
+// attempts or deadline run out
+func (d *Dispatcher) deliver(ctx context.Context, destination *target, attempt func(context.Context) error) error {
+	deadline := time.Now().Add(destination.retry.Deadline)
+	for n := 0; ; n++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, destination.Timeout)
+		err := attempt(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) || n+1 >= destination.retry.Attempts {
+			return err
+		}
+		wait := backoff(destination.retry, n)
+		var throttled *retryAfterError
+		if errors.As(err, &throttled) && throttled.after > wait {
+			wait = throttled.after
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+
+		d.logger.Warn("Retrying notification", "destination", destination.Name, "attempt", n+1, "backoff", wait, "error", err)
+		if d.metrics != nil {
+			d.metrics.Retries.WithLabelValues(destination.Name).Inc()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// post makes one delivery attempt at an HTTP destination
+func (d *Dispatcher) post(ctx context.Context, destination *target, body []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, destination.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range destination.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s returned %s: %s", destination.Type, resp.Status, bytes.TrimSpace(detail))
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &retryAfterError{err: err, after: time.Duration(seconds) * time.Second}
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		return err
+	default:
+		return &permanentError{err: err}
+	}
+}
+
+// ApplyTemplates compiles the current stored templates and gives them to the
+// destinations naming them. A destination keeps its template when the stored
+// one fails to compile or has a format its type cannot send, and falls back
+// to its own template when the stored one is deleted.
+func (d *Dispatcher) ApplyTemplates(templates []*StoredTemplate) {
+	byName := make(map[string]*StoredTemplate, len(templates))
+	for _, t := range templates {
+		byName[t.Name] = t
+	}
+
+	for _, destination := range d.destinations {
+		if destination.TemplateName == "" {
+			continue
+		}
+		logger := d.logger.With("destination", destination.Name, "template", destination.TemplateName)
+		current := destination.stored.Load()
+		t := byName[destination.TemplateName]
+		switch {
+		case t == nil:
+			if current != nil {
+				destination.stored.Store(nil)
+				logger.Warn("Stored template deleted; using the destination's own template")
+			}
+		case current == nil || current.version != t.Version:
+			compiled, err := t.compile()
+			if err == nil && !compiled.supports(destination.Type) {
+				err = fmt.Errorf("%s destinations cannot send %s templates", destination.Type, t.Format)
+			}
+			if err != nil {
+				logger.Error("Failed to apply stored template", "version", t.Version, "error", err)
+				continue
+			}
+			destination.stored.Store(compiled)
+			logger.Info("Applied stored template", "version", t.Version, "format", t.Format)
+		}
+	}
+}
+
+// SendTest renders a stored template with SampleAlert and delivers it to the
+// named destination in one attempt, bypassing its filters and rate limit. It
+// returns the rendered text.
+func (d *Dispatcher) SendTest(ctx context.Context, destinationName string, t *StoredTemplate) (string, error) {
+	var destination *target
+	for _, candidate := range d.destinations {
+		if candidate.Name == destinationName {
+			destination = candidate
+		}
+	}
+	if destination == nil {
+		return "", fmt.Errorf("%w: %q", ErrDestinationNotFound, destinationName)
+	}
+	compiled, err := t.compile()
+	if err != nil {
+		return "", err
+	}
+	if !compiled.supports(destination.Type) {
+		return "", fmt.Errorf("%w: %s destinations cannot send %s templates", ErrInvalidTemplate, destination.Type, t.Format)
+	}
+
+	message := NewMessage(SampleAlert())
+	text, format, err := destination.renderWith(compiled, message)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, destination.Timeout)
+	defer cancel()
+	if destination.Type == TypeEmail {
+		to := destination.recipients(message.Severity)
+		if len(to) == 0 {
+			return text, fmt.Errorf("no recipients for %s alerts", message.Severity)
+		}
+		return text, destination.sendMail(ctx, to, "[TEST] "+emailSubject(message), text, format)
+	}
+	body, contentType, err := destination.bodyWith(compiled, message)
+	if err != nil {
+		return text, err
+	}
+	return text, d.post(ctx, destination, body, contentType)
+}
+
+// observe counts a notification outcome
+func (d *Dispatcher) observe(destination *target, outcome string) {
+	if d.metrics != nil {
+		d.metrics.Notifications.WithLabelValues(destination.Name, outcome).Inc()
+	}
+}
+
+// backoff returns the jittered wait after the failed attempt with index attempt
+func backoff(retry Retry, attempt int) time.Duration {
+	wait := retry.Initial
+	for i := 0; i < attempt && wait < retry.Max; i++ {
+		wait *= 2
+	}
+	if wait > retry.Max {
+		wait = retry.Max
+	}
+	if retry.Jitter > 0 {
+		wait = time.Duration(float64(wait) * (1 - retry.Jitter + 2*retry.Jitter*rand.Float64()))
+	}
+	return wait
+}
+
+// body returns the request body of a message for an HTTP destination and its content type
+func (d *Destination) body(message Message) ([]byte, string, error) {
+	return d.bodyWith(d.storedTemplate(), message)
+}
+
+// bodyWith returns the request body of a message rendered with stored, or
+// with the destination's own template when stored is nil
+func (d *Destination) bodyWith(stored *compiledTemplate, message Message) ([]byte, string, error) {
+	text, format, err := d.renderWith(stored, message)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch d.Type {
+	case TypeSlack:
+		slack := slackMessage{Text: text}
+		if format == FormatSlackBlocks {
+			// The text remains the fallback shown in notifications
+			if slack.Text, err = d.renderText(message); err != nil {
+				return nil, "", err
+			}
+			slack.Blocks = json.RawMessage(text)
+		}
+		data, err := json.Marshal(slack)
+		return data, "application/json", err
+	case TypePagerDuty:
+		data, err := json.Marshal(pagerDutyEvent(d.RoutingKey, text, message))
+		return data, "application/json", err
+	default:
+		if format == FormatJSON || (stored == nil && d.Template != "") {
+			return []byte(text), "application/json", nil
+		}
+		data, err := json.Marshal(webhookPayload{Alert: message.Alert, Severity: message.Severity, Text: text})
+		return data, "application/json", err
+	}
+}
+
+// webhookPayload is the body of webhooks without a template
+type webhookPayload struct {
+	Alert    *model.SensorAlert `json:"alert"`
+	Severity string             `json:"severity"`
+	Text     string             `json:"text"`
+}
+
+// slackMessage is the body of a Slack incoming webhook
+type slackMessage struct {
+	Text   string          `json:"text"`
+	Blocks json.RawMessage `json:"blocks,omitempty"`
+}
+
+// pagerDutyRequest is a PagerDuty Events API v2 event
+type pagerDutyRequest struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string             `json:"summary"`
+	Source        string             `json:"source"`
+	Severity      string             `json:"severity"`
+	Timestamp     string             `json:"timestamp"`
+	Component     string             `json:"component,omitempty"`
+	Group         string             `json:"group,omitempty"`
+	Class         string             `json:"class,omitempty"`
+	CustomDetails *model.SensorAlert `json:"custom_details"`
+}
+
+// pagerDutyEvent returns the trigger event of an alert. Alerts of one sensor
+// and rule share a dedup key, so repeats update the open PagerDuty incident
+// instead of opening new ones.
+func pagerDutyEvent(routingKey, summary string, message Message) pagerDutyRequest {
+	severity := message.Severity
+	switch severity {
+	case model.SeverityCritical, model.SeverityWarning, model.SeverityInfo, "error":
+	default:
+		severity = model.SeverityWarning
+	}
+	// PagerDuty rejects summaries longer than 1024 characters
+	if len(summary) > 1024 {
+		summary = strings.ToValidUTF8(summary[:1024], "")
+	}
+	return pagerDutyRequest{
+		RoutingKey:  routingKey,
+		EventAction: "trigger",
+		DedupKey:    message.Alert.SensorID + "/" + message.Alert.Rule,
+		Payload: pagerDutyPayload{
+			Summary:       summary,
+			Source:        message.Alert.SensorID,
+			Severity:      severity,
+			Timestamp:     message.Time.Format(time.RFC3339),
+			Component:     message.Alert.Zone,
+			Group:         message.Alert.Site,
+			Class:         message.Alert.Rule,
+			CustomDetails: message.Alert,
+		},
+	}
+}
@@ -0,0 +1,305 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// validateEmail checks the SMTP settings and recipients of an email
+// destination and fills in the default port and TLS mode
+func (d *Destination) validateEmail() error {
+	if d.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+	u, err := url.Parse(d.URL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("invalid url %q: expected smtp://host:port", d.URL)
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "smtp":
+		if port == "" {
+			port = "587"
+		}
+	case "smtps":
+		if port == "" {
+			port = "465"
+		}
+		if d.TLS == "" {
+			d.TLS = TLSImplicit
+		}
+	default:
+		return fmt.Errorf("invalid url %q: scheme must be smtp or smtps", d.URL)
+	}
+	d.URL = u.Scheme + "://" + net.JoinHostPort(u.Hostname(), port)
+
+	switch d.TLS {
+	case "":
+		d.TLS = TLSStartTLS
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return fmt.Errorf("unknown tls %q: expected %s, %s or %s", d.TLS, TLSStartTLS, TLSImplicit, TLSNone)
+	}
+
+	if _, err := mail.ParseAddress(d.From); err != nil {
+		return fmt.Errorf("invalid from %q: %w", d.From, err)
+	}
+	if len(d.Recipients) == 0 {
+		return fmt.Errorf("missing recipients")
+	}
+	for severity, addresses := range d.Recipients {
+		if len(addresses) == 0 {
+			return fmt.Errorf("no recipients for %s", severity)
+		}
+		for _, address := range addresses {
+			if _, err := mail.ParseAddress(address); err != nil {
+				return fmt.Errorf("invalid recipient %q: %w", address, err)
+			}
+		}
+	}
+	return nil
+}
+
+// recipients returns the addresses emailed alerts of severity, or nil
+func (d *Destination) recipients(severity string) []string {
+	for key, addresses := range d.Recipients {
+		if strings.EqualFold(key, severity) {
+			return addresses
+		}
+	}
+	return d.Recipients[RecipientsDefault]
+}
+
+// sendMail delivers one email over SMTP, within the deadline of ctx; the
+// body is HTML when format is FormatHTML and plain text otherwise
+func (d *Destination) sendMail(ctx context.Context, to []string, subject, body, format string) error {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	host := u.Hostname()
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	if d.TLS == TLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", u.Host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", u.Host)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return smtpError(err)
+	}
+	defer client.Close()
+
+	if d.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return &permanentError{err: fmt.Errorf("%s does not offer STARTTLS", u.Host)}
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return smtpError(err)
+		}
+	}
+	if d.Username != "" {
+		// PlainAuth refuses to send credentials unencrypted, except to localhost
+		if err := client.Auth(smtp.PlainAuth("", d.Username, d.Password, host)); err != nil {
+			return &permanentError{err: fmt.Errorf("SMTP authentication failed: %w", err)}
+		}
+	}
+
+	from, _ := mail.ParseAddress(d.From)
+	if err := client.Mail(from.Address); err != nil {
+		return smtpError(err)
+	}
+	for _, address := range to {
+		recipient, _ := mail.ParseAddress(address)
+		if err := client.Rcpt(recipient.Address); err != nil {
+			return smtpError(err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := w.Write(composeMail(d.From, to, subject, body, format, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+	return client.Quit()
+}
+
+// smtpError marks rejections with a 5xx reply as permanent; 4xx replies and
+// connection errors are retried
+func smtpError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return &permanentError{err: err}
+	}
+	return err
+}
+
+// composeMail returns a plain text or, when format is FormatHTML, an HTML
+// email with its headers
+func composeMail(from string, to []string, subject, body, format string, now time.Time) []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		// Line breaks in a value would start new headers
+		value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from)
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	if format == FormatHTML {
+		header("Content-Type", "text/html; charset=utf-8")
+	} else {
+		header("Content-Type", "text/plain; charset=utf-8")
+	}
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// emailSubject returns the subject of the email of one alert
+func emailSubject(message Message) string {
+	subject := fmt.Sprintf("[%s] %s", strings.ToUpper(message.Severity), message.Alert.SensorID)
+	if message.Alert.Site != "" {
+		subject += " at " + message.Alert.Site
+	}
+	return subject + ": " + message.Alert.Rule
+}
+
+// digest collects the alerts of an email destination between sends, by
+// recipient list
+type digest struct {
+	mu      sync.Mutex
+	batches map[string]*digestBatch
+}
+
+// digestBatch is the alerts one recipient list receives in a digest
+type digestBatch struct {
+	to       []string
+	messages []Message
+}
+
+// newDigest returns the digest of an email destination, or nil when it sends
+// each alert
+func newDigest(destination Destination) *digest {
+	if destination.Type != TypeEmail || destination.Digest <= 0 {
+		return nil
+	}
+	return &digest{batches: make(map[string]*digestBatch)}
+}
+
+// add queues a message for the recipients to
+func (g *digest) add(to []string, message Message) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := strings.Join(to, ",")
+	batch := g.batches[key]
+	if batch == nil {
+		batch = &digestBatch{to: to}
+		g.batches[key] = batch
+	}
+	batch.messages = append(batch.messages, message)
+}
+
+// take removes and returns the queued batches
+func (g *digest) take() []*digestBatch {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	batches := make([]*digestBatch, 0, len(g.batches))
+	for _, batch := range g.batches {
+		batches = append(batches, batch)
+	}
+	g.batches = make(map[string]*digestBatch)
+	sort.Slice(batches, func(i, j int) bool {
+		return strings.Join(batches[i].to, ",") < strings.Join(batches[j].to, ",")
+	})
+	return batches
+}
+
+// digestSubject summarizes the alerts of a digest by severity
+func digestSubject(messages []Message) string {
+	counts := make(map[string]int)
+	for _, message := range messages {
+		counts[message.Severity]++
+	}
+	severities := make([]string, 0, len(counts))
+	for severity := range counts {
+		severities = append(severities, severity)
+	}
+	sort.Strings(severities)
+
+	parts := make([]string, 0, len(severities))
+	for _, severity := range severities {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[severity], severity))
+	}
+	noun := "alerts"
+	if len(messages) == 1 {
+		noun = "alert"
+	}
+	return fmt.Sprintf("%d sensor %s (%s)", len(messages), noun, strings.Join(parts, ", "))
+}
+
+// digestBody renders one line per alert, oldest reading first, and returns
+// the body with its format; HTML templates render a list item per alert
+func (d *Destination) digestBody(messages []Message, interval time.Duration) (string, string, error) {
+	sorted := append([]Message(nil), messages...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	stored := d.storedTemplate()
+	html := stored != nil && stored.format == FormatHTML
+	var buf strings.Builder
+	if html {
+		fmt.Fprintf(&buf, "<p>%s over the last %s:</p>\n<ul>\n", htmltemplate.HTMLEscapeString(digestSubject(sorted)), interval)
+	} else {
+		fmt.Fprintf(&buf, "%s over the last %s:\n\n", digestSubject(sorted), interval)
+	}
+	for _, message := range sorted {
+		text, _, err := d.renderWith(stored, message)
+		if err != nil {
+			return "", "", err
+		}
+		if html {
+			fmt.Fprintf(&buf, "<li>%s %s</li>\n", message.Time.Format(time.RFC3339), text)
+		} else {
+			fmt.Fprintf(&buf, "%s  %s\n", message.Time.Format(time.RFC3339), text)
+		}
+	}
+	if html {
+		buf.WriteString("</ul>\n")
+		return buf.String(), FormatHTML, nil
+	}
+	return buf.String(), FormatText, nil
+}
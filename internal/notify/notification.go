@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Sources the notifier delivers from
+const (
+	// SourceAlerts delivers every alert of the alert topic
+	SourceAlerts = "alerts"
+	// SourceNotifications delivers the pages the correlator writes to the
+	// notification topic: uncorrelated alerts, incidents, summaries of the
+	// alerts an alert budget suppressed, and budget breaches
+	SourceNotifications = "notifications"
+)
+
+// severityRank orders severities from least to most severe
+var severityRank = map[string]int{
+	model.SeverityInfo:     1,
+	model.SeverityWarning:  2,
+	model.SeverityCritical: 3,
+}
+
+// NotificationMessage returns the template data of a correlator page. An
+// incident, summary or budget breach is presented as an alert of its site
+// whose sensor ID is the page's type and whose reason is its summary, so
+// templates and filters written for alerts apply to it. An incident takes
+// the most severe rule and every tag of its alerts; summaries and budget
+// breaches are warnings.
+func NotificationMessage(notification *model.Notification) (Message, error) {
+	switch {
+	case notification.Type == model.NotificationAlert && notification.Alert != nil:
+		return NewMessage(notification.Alert), nil
+
+	case notification.Type == model.NotificationIncident && notification.Incident != nil:
+		incident := notification.Incident
+		alert := &model.SensorAlert{
+			SensorID:    model.NotificationIncident,
+			Timestamp:   incident.UpdatedAt,
+			Reason:      incident.Summary,
+			Site:        incident.Site,
+			RunbookURL:  incident.RunbookURL,
+			Annotations: incident.Annotations,
+		}
+		severity := model.SeverityWarning
+		seen := make(map[string]bool)
+		for i, member := range incident.Alerts {
+			if memberSeverity := model.AlertSeverity(member); i == 0 || severityRank[memberSeverity] > severityRank[severity] {
+				severity, alert.Rule = memberSeverity, member.Rule
+			}
+			for _, tag := range member.Tags {
+				if !seen[tag] {
+					seen[tag] = true
+					alert.Tags = append(alert.Tags, tag)
+				}
+			}
+		}
+		if annotated := incident.Annotations[model.SeverityAnnotation]; annotated != "" {
+			severity = annotated
+		}
+		return pageMessage(model.NotificationIncident, alert, severity), nil
+
+	case notification.Type == model.NotificationSummary && notification.Summary != nil:
+		summary := notification.Summary
+		return pageMessage(model.NotificationSummary, &model.SensorAlert{
+			SensorID:  model.NotificationSummary,
+			Timestamp: summary.WindowEnd,
+			Reason:    summary.Summary,
+			Site:      summary.Site,
+		}, model.SeverityWarning), nil
+
+	case notification.Type == model.NotificationBudget && notification.Budget != nil:
+		breach := notification.Budget
+		return pageMessage(model.NotificationBudget, &model.SensorAlert{
+			SensorID:  model.NotificationBudget,
+			Timestamp: breach.Timestamp,
+			Reason:    breach.Summary,
+			Site:      breach.Site,
+		}, model.SeverityWarning), nil
+
+	default:
+		return Message{}, fmt.Errorf("unknown or empty notification of type %q", notification.Type)
+	}
+}
+
+// pageMessage returns the template data of a page presented as alert
+func pageMessage(kind string, alert *model.SensorAlert, severity string) Message {
+	return Message{Kind: kind, Alert: alert, Severity: severity, Time: time.UnixMilli(alert.Timestamp).UTC()}
+}
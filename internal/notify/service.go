@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Service consumes alerts, or the correlator's pages, and delivers them to
+// the configured destinations
+type Service struct {
+	Dispatcher *Dispatcher
+	consumer   *kafka.Consumer
+	source     string
+	metrics    *Metrics
+	logger     *slog.Logger
+
+	// Templates reloads and manages stored templates; nil when
+	// NOTIFIER_TEMPLATE_REFRESH_INTERVAL is 0
+	Templates *TemplateAdmin
+}
+
+// NewService loads the destinations of NOTIFIER_CONFIG and creates the
+// dispatcher and its consumer. Metrics are registered on registry.
+func NewService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "notifier")
+	if cfg.NotifierConfig == "" {
+		return nil, fmt.Errorf("NOTIFIER_CONFIG is required")
+	}
+	destinations, err := LoadDestinations(cfg.NotifierConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notifier destinations: %w", err)
+	}
+
+	metrics := NewMetrics("iot", "notifier", registry)
+	s := &Service{
+		Dispatcher: NewDispatcher(destinations, cfg.NotifierTimeout, metrics, logger),
+		source:     cfg.NotifierSource,
+		metrics:    metrics,
+		logger:     logger,
+	}
+
+	templates, err := NewTemplateAdminFromConfig(cfg, s.Dispatcher, logger)
+	if err != nil {
+		return nil, err
+	}
+	s.Templates = templates
+
+	// With the correlator running, its pages replace the alerts it folds
+	// into incidents and summaries
+	topic := cfg.Topic(config.TopicKeySensorAlert)
+	if s.source == SourceNotifications {
+		topic = cfg.Topic(config.TopicKeyNotification)
+	}
+
+	// Deliveries are bounded by the retry deadline of each destination
+	// rather than by CONSUMER_HANDLER_TIMEOUT
+	consumer, err := kafka.NewConsumer(
+		kafka.ConsumerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			GroupID:         cfg.NotifierGroupID,
+			Topics:          []string{topic},
+			OffsetInitial:   cfg.ConsumerOffsetInitial,
+			ReturnErrors:    cfg.ConsumerReturnErrors,
+			Metrics:         kafka.NewConsumerMetrics("iot", "notifier_consumer", registry),
+			Version:         cfg.KafkaVersion,
+			DrainTimeout:    cfg.ConsumerDrainTimeout,
+			HopHeaders:      cfg.HopHeaders,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ConsumerRetryFromConfig(cfg, config.RetryComponentNotifier),
+			LagInterval:     cfg.ConsumerLagInterval,
+			BalanceStrategy: cfg.ConsumerBalanceStrategy,
+			Schemas:         kafka.SchemaTrackerFromConfig(cfg, registry),
+			Logger:          logger,
+		},
+		s.HandleMessage,
+	)
+	if err != nil {
+		if s.Templates != nil {
+			s.Templates.Stop()
+		}
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	s.consumer = consumer
+
+	logger.Info("Loaded notifier destinations", "destinations", len(destinations), "file", cfg.NotifierConfig,
+		"source", s.source, "topic", topic)
+	return s, nil
+}
+
+// HandleMessage decodes an alert or page and waits for it to be delivered.
+// Undecodable messages are skipped.
+func (s *Service) HandleMessage(ctx context.Context, message *sarama.ConsumerMessage) error {
+	if s.source != SourceNotifications {
+		alert, err := model.DeserializeSensorAlert(message.Value)
+		if err != nil {
+			s.skip(message, err)
+			return nil
+		}
+		return s.Dispatcher.Dispatch(ctx, alert)
+	}
+
+	notification, err := model.DeserializeNotification(message.Value)
+	if err != nil {
+		s.skip(message, err)
+		return nil
+	}
+	page, err := NotificationMessage(notification)
+	if err != nil {
+		s.skip(message, err)
+		return nil
+	}
+	return s.Dispatcher.DispatchMessage(ctx, page)
+}
+
+// skip counts and logs a message that cannot be delivered
+func (s *Service) skip(message *sarama.ConsumerMessage, err error) {
+	s.metrics.DecodeErrors.Inc()
+	s.logger.With(kafka.MessageLogAttrs(message)...).Warn("Skipping undecodable message", "error", err)
+}
+
+// Start loads the stored templates, then starts the email digests and
+// consuming alerts
+func (s *Service) Start() error {
+	if s.Templates != nil {
+		s.Templates.Start()
+	}
+	s.Dispatcher.Start()
+	return s.consumer.Start()
+}
+
+// Stop stops consuming, waiting up to CONSUMER_DRAIN_TIMEOUT for the
+// notifications in flight, and then sends the pending email digests
+func (s *Service) Stop() {
+	s.consumer.Stop()
+	s.Dispatcher.Stop()
+	if s.Templates != nil {
+		s.Templates.Stop()
+	}
+}
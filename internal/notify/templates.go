@@ -0,0 +1,257 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Formats of stored templates: what the rendered text is and how it is sent
+const (
+	// FormatText renders plain text, like the template of a destination
+	FormatText = "text"
+	// FormatSlackBlocks renders a JSON array of Slack blocks
+	FormatSlackBlocks = "slack_blocks"
+	// FormatHTML renders an HTML email body, escaping the alert's values
+	FormatHTML = "html"
+	// FormatJSON renders the JSON request body of a webhook
+	FormatJSON = "json"
+)
+
+// maxTemplateBytes bounds the body of a stored template
+const maxTemplateBytes = 64 << 10
+
+var (
+	// ErrTemplateNotFound is returned for a template or version that is not stored
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrInvalidTemplate is returned for a template that cannot be stored
+	ErrInvalidTemplate = errors.New("invalid template")
+	// ErrTemplateConflict is returned when a concurrent change stored the
+	// same version first
+	ErrTemplateConflict = errors.New("template changed concurrently")
+)
+
+// templateName matches the names of stored templates
+var templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// formatTypes are the formats each destination type can send
+var formatTypes = map[string][]string{
+	TypeWebhook:   {FormatText, FormatJSON},
+	TypeSlack:     {FormatText, FormatSlackBlocks},
+	TypePagerDuty: {FormatText},
+	TypeEmail:     {FormatText, FormatHTML},
+}
+
+// StoredTemplate is one version of a template stored in PostgreSQL
+type StoredTemplate struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Format    string    `json:"format"`
+	Body      string    `json:"body"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// executor is a parsed text or HTML template
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// compiledTemplate is a stored template ready to render
+type compiledTemplate struct {
+	version  int
+	format   string
+	template executor
+}
+
+// compile parses a stored template and renders it with a sample alert, so a
+// template failing on every alert or rendering invalid JSON is rejected
+func (t *StoredTemplate) compile() (*compiledTemplate, error) {
+	if !templateName.MatchString(t.Name) {
+		return nil, fmt.Errorf("%w: name %q must be 1 to 64 lowercase letters, digits, _ . or -, starting with a letter or digit", ErrInvalidTemplate, t.Name)
+	}
+	if len(t.Body) > maxTemplateBytes {
+		return nil, fmt.Errorf("%w: body is %d bytes, at most %d allowed", ErrInvalidTemplate, len(t.Body), maxTemplateBytes)
+	}
+
+	compiled := &compiledTemplate{version: t.Version, format: t.Format}
+	var err error
+	switch t.Format {
+	case FormatText, FormatSlackBlocks, FormatJSON:
+		compiled.template, err = template.New(t.Name).Funcs(templateFuncs).Option("missingkey=zero").Parse(t.Body)
+	case FormatHTML:
+		compiled.template, err = htmltemplate.New(t.Name).Funcs(htmltemplate.FuncMap(templateFuncs)).Option("missingkey=zero").Parse(t.Body)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q: expected %s, %s, %s or %s", ErrInvalidTemplate, t.Format, FormatText, FormatSlackBlocks, FormatHTML, FormatJSON)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	text, err := compiled.render(NewMessage(SampleAlert()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	switch t.Format {
+	case FormatJSON:
+		if !json.Valid([]byte(text)) {
+			return nil, fmt.Errorf("%w: the sample alert does not render valid JSON", ErrInvalidTemplate)
+		}
+	case FormatSlackBlocks:
+		var blocks []json.RawMessage
+		if err := json.Unmarshal([]byte(text), &blocks); err != nil {
+			return nil, fmt.Errorf("%w: the sample alert does not render a JSON array of blocks: %v", ErrInvalidTemplate, err)
+		}
+	}
+	return compiled, nil
+}
+
+// render executes the template with message
+func (c *compiledTemplate) render(message Message) (string, error) {
+	var buf bytes.Buffer
+	if err := c.template.Execute(&buf, message); err != nil {
+		return "", fmt.Errorf("failed to render template version %d: %w", c.version, err)
+	}
+	return buf.String(), nil
+}
+
+// supports reports whether a destination type can send the template's format
+func (c *compiledTemplate) supports(destinationType string) bool {
+	for _, format := range formatTypes[destinationType] {
+		if format == c.format {
+			return true
+		}
+	}
+	return false
+}
+
+// SampleAlert returns the alert templates are checked and test-sent with
+func SampleAlert() *model.SensorAlert {
+	return &model.SensorAlert{
+		SensorID:    "sensor-test",
+		Timestamp:   time.Now().UnixMilli(),
+		Reason:      "Test notification: temperature 85.0°C exceeds 80.0°C",
+		Temperature: 85,
+		Humidity:    40,
+		Site:        "test-site",
+		Zone:        "test-zone",
+		Rule:        "temperature_high",
+		Tags:        []string{"test"},
+	}
+}
+
+// TemplateStore stores versioned notification templates in PostgreSQL. Each
+// change stores a new version; the newest version of a template is current.
+type TemplateStore struct {
+	db *sql.DB
+}
+
+// NewTemplateStore creates a template store on db
+func NewTemplateStore(db *sql.DB) *TemplateStore {
+	return &TemplateStore{db: db}
+}
+
+// Save checks a template and stores it as the next version of its name,
+// created by actor. Name, Format and Body must be set; the rest is filled in.
+func (s *TemplateStore) Save(ctx context.Context, t *StoredTemplate, actor string) error {
+	if t.Format == "" {
+		t.Format = FormatText
+	}
+	if _, err := t.compile(); err != nil {
+		return err
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO notification_templates (name, version, format, body, created_by, created_at)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, NOW()
+		FROM notification_templates WHERE name = $1
+		ON CONFLICT (name, version) DO NOTHING
+		RETURNING version, created_at
+	`, t.Name, t.Format, t.Body, actor).Scan(&t.Version, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTemplateConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store template: %w", err)
+	}
+	t.CreatedBy = actor
+	return nil
+}
+
+// Get returns a version of a template, the current one when version is 0
+func (s *TemplateStore) Get(ctx context.Context, name string, version int) (*StoredTemplate, error) {
+	var t StoredTemplate
+	err := s.db.QueryRowContext(ctx, `
+		SELECT name, version, format, body, created_by, created_at FROM notification_templates
+		WHERE name = $1 AND ($2 = 0 OR version = $2)
+		ORDER BY version DESC LIMIT 1
+	`, name, version).Scan(&t.Name, &t.Version, &t.Format, &t.Body, &t.CreatedBy, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %w", err)
+	}
+	return &t, nil
+}
+
+// List returns the current version of every template, ordered by name
+func (s *TemplateStore) List(ctx context.Context) ([]*StoredTemplate, error) {
+	return s.query(ctx, `
+		SELECT DISTINCT ON (name) name, version, format, body, created_by, created_at
+		FROM notification_templates ORDER BY name, version DESC
+	`)
+}
+
+// Versions returns every version of a template, newest first
+func (s *TemplateStore) Versions(ctx context.Context, name string) ([]*StoredTemplate, error) {
+	versions, err := s.query(ctx, `
+		SELECT name, version, format, body, created_by, created_at
+		FROM notification_templates WHERE name = $1 ORDER BY version DESC
+	`, name)
+	if err == nil && len(versions) == 0 {
+		return nil, ErrTemplateNotFound
+	}
+	return versions, err
+}
+
+// Delete removes every version of a template
+func (s *TemplateStore) Delete(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM notification_templates WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTemplateNotFound
+	}
+	return nil
+}
+
+// query returns the templates a statement selects
+func (s *TemplateStore) query(ctx context.Context, statement string, args ...any) ([]*StoredTemplate, error) {
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*StoredTemplate
+	for rows.Next() {
+		var t StoredTemplate
+		if err := rows.Scan(&t.Name, &t.Version, &t.Format, &t.Body, &t.CreatedBy, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
+		}
+		result = append(result, &t)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,246 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+// Annotation scopes
+const (
+	ScopeRule = "rule"
+	ScopeSite = "site"
+)
+
+// Annotation attaches a runbook link and free-form context to a rule or site
+type Annotation struct {
+	Scope       string            `json:"scope"`
+	Target      string            `json:"target"`
+	RunbookURL  string            `json:"runbook_url,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// validScope reports whether scope is a known annotation scope
+func validScope(scope string) bool {
+	return scope == ScopeRule || scope == ScopeSite
+}
+
+// SetAnnotation creates or replaces the annotation for a rule or site
+func (r *Registry) SetAnnotation(ctx context.Context, annotation *Annotation) error {
+	if !validScope(annotation.Scope) {
+		return fmt.Errorf("unknown annotation scope: %s", annotation.Scope)
+	}
+
+	annotations, err := json.Marshal(annotation.Annotations)
+	if err != nil {
+		return fmt.Errorf("failed to encode annotations: %w", err)
+	}
+
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO annotations (scope, target, runbook_url, annotations, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (scope, target) DO UPDATE
+		SET runbook_url = EXCLUDED.runbook_url, annotations = EXCLUDED.annotations, updated_at = NOW()
+		RETURNING updated_at
+	`, annotation.Scope, annotation.Target, annotation.RunbookURL, annotations).Scan(&annotation.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store annotation: %w", err)
+	}
+	return nil
+}
+
+// DeleteAnnotation removes the annotation for a rule or site
+func (r *Registry) DeleteAnnotation(ctx context.Context, scope, target string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM annotations WHERE scope = $1 AND target = $2`, scope, target)
+	if err != nil {
+		return fmt.Errorf("failed to delete annotation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListAnnotations returns every annotation
+func (r *Registry) ListAnnotations(ctx context.Context) ([]*Annotation, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT scope, target, runbook_url, annotations, updated_at FROM annotations ORDER BY scope, target
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list annotations: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*Annotation
+	for rows.Next() {
+		var annotation Annotation
+		var runbook sql.NullString
+		var annotations []byte
+		if err := rows.Scan(&annotation.Scope, &annotation.Target, &runbook, &annotations, &annotation.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan annotation: %w", err)
+		}
+		annotation.RunbookURL = runbook.String
+		if len(annotations) > 0 {
+			if err := json.Unmarshal(annotations, &annotation.Annotations); err != nil {
+				return nil, fmt.Errorf("failed to decode annotations for %s/%s: %w", annotation.Scope, annotation.Target, err)
+			}
+		}
+		result = append(result, &annotation)
+	}
+	return result, rows.Err()
+}
+
+// AnnotationCache keeps the registry annotations in memory for alert producers.
+// It implements model.Annotator.
+type AnnotationCache struct {
+	registry *Registry
+	interval time.Duration
+	timeout  time.Duration
+	postgres *db.PostgresDB
+	logger   *slog.Logger
+
+	mu    sync.RWMutex
+	rules map[string]*Annotation
+	sites map[string]*Annotation
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewAnnotationCache creates a cache refreshed from registry every interval;
+// each refresh is bounded by timeout. logger may be nil.
+func NewAnnotationCache(registry *Registry, interval, timeout time.Duration, logger *slog.Logger) *AnnotationCache {
+	if timeout <= 0 {
+		timeout = interval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &AnnotationCache{
+		registry: registry,
+		interval: interval,
+		timeout:  timeout,
+		logger:   logging.OrDefault(logger),
+		rules:    make(map[string]*Annotation),
+		sites:    make(map[string]*Annotation),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// NewAnnotationCacheFromConfig connects to the registry database and creates a cache.
+// It returns nil, nil when annotation refresh is disabled.
+func NewAnnotationCacheFromConfig(cfg *config.Config, logger *slog.Logger) (*AnnotationCache, error) {
+	if cfg.AnnotationRefreshInterval <= 0 {
+		return nil, nil
+	}
+
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect annotation database: %w", err)
+	}
+
+	cache := NewAnnotationCache(NewRegistry(postgres.DB()), cfg.AnnotationRefreshInterval, cfg.StoreTimeout, logger)
+	cache.postgres = postgres
+	return cache, nil
+}
+
+// Start loads the annotations and refreshes them in the background
+func (c *AnnotationCache) Start() {
+	if err := c.refresh(); err != nil {
+		c.logger.Warn("Failed to load annotations", "error", err)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.refresh(); err != nil {
+					c.logger.Warn("Failed to refresh annotations", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing and closes the database connection if the cache owns it
+func (c *AnnotationCache) Stop() {
+	c.cancel()
+	c.wg.Wait()
+	if c.postgres != nil {
+		c.postgres.Close()
+	}
+}
+
+// refresh reloads the annotations under the cache's lifetime and timeout
+func (c *AnnotationCache) refresh() error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	return c.Refresh(ctx)
+}
+
+// Refresh reloads every annotation from the registry
+func (c *AnnotationCache) Refresh(ctx context.Context) error {
+	annotations, err := c.registry.ListAnnotations(ctx)
+	if err != nil {
+		return err
+	}
+
+	rules := make(map[string]*Annotation)
+	sites := make(map[string]*Annotation)
+	for _, annotation := range annotations {
+		switch annotation.Scope {
+		case ScopeRule:
+			rules[annotation.Target] = annotation
+		case ScopeSite:
+			sites[annotation.Target] = annotation
+		}
+	}
+
+	c.mu.Lock()
+	c.rules, c.sites = rules, sites
+	c.mu.Unlock()
+	return nil
+}
+
+// Annotate merges the rule and site annotations. Site annotations override rule
+// annotations with the same key, and a site runbook overrides the rule runbook.
+func (c *AnnotationCache) Annotate(rule, site string) (string, map[string]string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var runbook string
+	var merged map[string]string
+	for _, annotation := range []*Annotation{c.rules[rule], c.sites[site]} {
+		if annotation == nil {
+			continue
+		}
+		if annotation.RunbookURL != "" {
+			runbook = annotation.RunbookURL
+		}
+		for k, v := range annotation.Annotations {
+			if merged == nil {
+				merged = make(map[string]string)
+			}
+			merged[k] = v
+		}
+	}
+	return runbook, merged
+}
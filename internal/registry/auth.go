@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Authenticator verifies device API keys; gateways use it to authorize ingest
+type Authenticator interface {
+	AuthenticateAPIKey(ctx context.Context, apiKey string) (*Identity, error)
+}
+
+// CredentialMetrics holds Prometheus metrics for credential rotation and authentication
+type CredentialMetrics struct {
+	Rotations          prometheus.Counter
+	DeadlinesScheduled prometheus.Counter
+	Purged             prometheus.Counter
+	AuthCache          *prometheus.CounterVec
+}
+
+// NewCredentialMetrics creates a new set of credential metrics
+func NewCredentialMetrics(namespace, subsystem string, registry prometheus.Registerer) *CredentialMetrics {
+	metrics := &CredentialMetrics{
+		Rotations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "credential_rotations_total",
+			Help:      "Total number of device credential rotations",
+		}),
+		DeadlinesScheduled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "credential_rotation_deadlines_total",
+			Help:      "Total number of stale credentials given a rotation deadline",
+		}),
+		Purged: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "credentials_purged_total",
+			Help:      "Total number of expired credentials deleted",
+		}),
+		AuthCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "auth_cache_lookups_total",
+			Help:      "Total number of API key lookups by cache result (hit, miss)",
+		}, []string{"result"}),
+	}
+
+	registry.MustRegister(
+		metrics.Rotations,
+		metrics.DeadlinesScheduled,
+		metrics.Purged,
+		metrics.AuthCache,
+	)
+
+	return metrics
+}
+
+// cachedIdentity is a positive authentication result held by CachingAuthenticator
+type cachedIdentity struct {
+	identity *Identity
+	until    time.Time
+}
+
+// CachingAuthenticator caches successful API key lookups for a short TTL.
+// An entry never outlives the credential's own expiry, so keys stop working
+// exactly when their rotation overlap window ends.
+type CachingAuthenticator struct {
+	next    Authenticator
+	ttl     time.Duration
+	metrics *CredentialMetrics
+
+	mu      sync.Mutex
+	entries map[string]cachedIdentity
+}
+
+// NewCachingAuthenticator wraps an authenticator with a TTL cache; metrics may be nil
+func NewCachingAuthenticator(next Authenticator, ttl time.Duration, metrics *CredentialMetrics) *CachingAuthenticator {
+	return &CachingAuthenticator{
+		next:    next,
+		ttl:     ttl,
+		metrics: metrics,
+		entries: make(map[string]cachedIdentity),
+	}
+}
+
+// AuthenticateAPIKey returns the cached identity or consults the wrapped authenticator
+func (c *CachingAuthenticator) AuthenticateAPIKey(ctx context.Context, apiKey string) (*Identity, error) {
+	key := hashSecret(apiKey)
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && now.After(entry.until) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.observe("hit")
+		return entry.identity, nil
+	}
+	c.observe("miss")
+
+	identity, err := c.next.AuthenticateAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	until := now.Add(c.ttl)
+	if identity.ExpiresAt != nil && identity.ExpiresAt.Before(until) {
+		until = *identity.ExpiresAt
+	}
+
+	c.mu.Lock()
+	c.entries[key] = cachedIdentity{identity: identity, until: until}
+	c.mu.Unlock()
+
+	return identity, nil
+}
+
+// Invalidate drops every cached entry for a sensor, e.g. after a rotation
+func (c *CachingAuthenticator) Invalidate(sensorID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.identity.SensorID == sensorID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *CachingAuthenticator) observe(result string) {
+	if c.metrics != nil {
+		c.metrics.AuthCache.WithLabelValues(result).Inc()
+	}
+}
@@ -0,0 +1,374 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// MaxImportSensors is the largest number of sensors one import may hold
+const MaxImportSensors = 10000
+
+// maxSensorIDLength is the width of the sensors.id column
+const maxSensorIDLength = 36
+
+// Sensor import actions
+const (
+	ImportActionCreate = "create"
+	ImportActionUpdate = "update"
+)
+
+// sensorCSVColumns are the columns of an exported CSV file; an import reads
+// the ones it knows by name and ignores the others, such as created_at
+var sensorCSVColumns = []string{"id", "hardware_id", "firmware_version", "site", "zone", "tags", "created_at"}
+
+// SensorRecord is the metadata of one sensor in a bulk import. Sensors are
+// matched by hardware ID; an empty ID is generated for a new sensor, and an
+// empty firmware version, site, zone or tag list keeps the stored one.
+type SensorRecord struct {
+	ID              string   `json:"id,omitempty"`
+	HardwareID      string   `json:"hardware_id"`
+	FirmwareVersion string   `json:"firmware_version,omitempty"`
+	Site            string   `json:"site,omitempty"`
+	Zone            string   `json:"zone,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+}
+
+// ImportResult is the outcome of importing one record
+type ImportResult struct {
+	// Row numbers the records from 1, not counting a CSV header
+	Row        int    `json:"row"`
+	HardwareID string `json:"hardware_id"`
+	SensorID   string `json:"sensor_id,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ImportReport is the outcome of a bulk import. An import with an invalid
+// record changes nothing and reports only the invalid records; a dry run
+// changes nothing either.
+type ImportReport struct {
+	DryRun  bool            `json:"dry_run"`
+	Created int             `json:"created"`
+	Updated int             `json:"updated"`
+	Invalid int             `json:"invalid"`
+	Results []*ImportResult `json:"results"`
+}
+
+// ReadSensorRecordsCSV reads sensor records from CSV with a header row naming
+// its columns; hardware_id is required, and tags are comma-separated
+func ReadSensorRecordsCSV(r io.Reader) ([]SensorRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["hardware_id"]; !ok {
+		return nil, fmt.Errorf("CSV header has no hardware_id column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var records []SensorRecord
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if len(records) == MaxImportSensors {
+			return nil, fmt.Errorf("at most %d sensors can be imported at once", MaxImportSensors)
+		}
+		records = append(records, SensorRecord{
+			ID:              field(row, "id"),
+			HardwareID:      field(row, "hardware_id"),
+			FirmwareVersion: field(row, "firmware_version"),
+			Site:            field(row, "site"),
+			Zone:            field(row, "zone"),
+			Tags:            splitTags(field(row, "tags")),
+		})
+	}
+}
+
+// splitTags splits a comma-separated tag list; an empty list is nil
+func splitTags(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// ReadSensorRecordsJSON reads sensor records from a JSON array
+func ReadSensorRecordsJSON(r io.Reader) ([]SensorRecord, error) {
+	var records []SensorRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	if len(records) > MaxImportSensors {
+		return nil, fmt.Errorf("at most %d sensors can be imported at once", MaxImportSensors)
+	}
+	for i := range records {
+		record := &records[i]
+		record.ID = strings.TrimSpace(record.ID)
+		record.HardwareID = strings.TrimSpace(record.HardwareID)
+		record.FirmwareVersion = strings.TrimSpace(record.FirmwareVersion)
+		record.Site = strings.TrimSpace(record.Site)
+		record.Zone = strings.TrimSpace(record.Zone)
+	}
+	return records, nil
+}
+
+// WriteSensorsCSV writes sensors as CSV with a header row, in the format
+// ReadSensorRecordsCSV reads
+func WriteSensorsCSV(w io.Writer, sensors []*Sensor) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(sensorCSVColumns); err != nil {
+		return err
+	}
+	for _, sensor := range sensors {
+		if err := writer.Write([]string{
+			sensor.ID,
+			sensor.HardwareID,
+			sensor.FirmwareVersion,
+			sensor.Site,
+			sensor.Zone,
+			strings.Join(sensor.Tags, ","),
+			sensor.CreatedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ListSensors returns every registered sensor ordered by hardware ID, only
+// those of site and carrying tag unless they are empty
+func (r *Registry) ListSensors(ctx context.Context, site, tag string) ([]*Sensor, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, hardware_id, firmware_version, site, zone, tags, created_at FROM sensors
+		WHERE ($1 = '' OR site = $1) AND ($2 = '' OR tags @> ARRAY[$2::TEXT])
+		ORDER BY hardware_id
+	`, site, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sensors: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*Sensor
+	for rows.Next() {
+		sensor, err := scanSensor(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sensor: %w", err)
+		}
+		result = append(result, sensor)
+	}
+	return result, rows.Err()
+}
+
+// ImportSensors registers new sensors and updates the metadata of registered
+// ones in a single transaction. Every record is checked first, against the
+// others and the registry; if any is invalid, or dryRun is set, nothing is
+// written and the report says what would have happened. Imported sensors
+// have no credentials until their device claims them with a provisioning
+// token.
+func (r *Registry) ImportSensors(ctx context.Context, records []SensorRecord, dryRun bool) (*ImportReport, error) {
+	if len(records) > MaxImportSensors {
+		return nil, fmt.Errorf("at most %d sensors can be imported at once", MaxImportSensors)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := registeredSensors(ctx, tx, records)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: dryRun, Results: make([]*ImportResult, len(records))}
+	hardwareRows := make(map[string]int, len(records))
+	idRows := make(map[string]int, len(records))
+	for i := range records {
+		record := &records[i]
+		result := &ImportResult{Row: i + 1, HardwareID: record.HardwareID}
+		report.Results[i] = result
+		if err := validateSensorRecord(record, hardwareRows, idRows); err != nil {
+			result.Error = err.Error()
+			report.Invalid++
+			continue
+		}
+		hardwareRows[record.HardwareID] = result.Row
+		if record.ID != "" {
+			idRows[record.ID] = result.Row
+		}
+
+		if id, ok := existing.byHardware[record.HardwareID]; ok {
+			if record.ID != "" && record.ID != id {
+				result.Error = fmt.Sprintf("hardware_id is registered as sensor %s", id)
+				report.Invalid++
+				continue
+			}
+			result.SensorID = id
+			result.Action = ImportActionUpdate
+			report.Updated++
+			continue
+		}
+		if hardwareID, ok := existing.byID[record.ID]; ok {
+			result.Error = fmt.Sprintf("id is registered to hardware_id %s", hardwareID)
+			report.Invalid++
+			continue
+		}
+		result.SensorID = record.ID
+		if result.SensorID == "" {
+			result.SensorID = uuid.New().String()
+		}
+		result.Action = ImportActionCreate
+		report.Created++
+	}
+	if report.Invalid > 0 {
+		// Report only the invalid records, so they can be fixed and the
+		// import run again
+		report.Created, report.Updated = 0, 0
+		invalid := make([]*ImportResult, 0, report.Invalid)
+		for _, result := range report.Results {
+			if result.Error != "" {
+				invalid = append(invalid, result)
+			}
+		}
+		report.Results = invalid
+		return report, nil
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	for i, record := range records {
+		result := report.Results[i]
+		var err error
+		if result.Action == ImportActionCreate {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO sensors (id, hardware_id, firmware_version, site, zone, tags)
+				VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6)
+			`, result.SensorID, record.HardwareID, record.FirmwareVersion, record.Site, record.Zone, pq.Array(tagsOrEmpty(record.Tags)))
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE sensors SET
+					firmware_version = COALESCE(NULLIF($2, ''), firmware_version),
+					site = COALESCE(NULLIF($3, ''), site),
+					zone = COALESCE(NULLIF($4, ''), zone),
+					tags = CASE WHEN cardinality($5::TEXT[]) > 0 THEN $5 ELSE tags END
+				WHERE id = $1
+			`, result.SensorID, record.FirmwareVersion, record.Site, record.Zone, pq.Array(tagsOrEmpty(record.Tags)))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to import sensor %s: %w", record.HardwareID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return report, nil
+}
+
+// validateSensorRecord checks a record on its own and against the hardware
+// IDs and IDs of the rows before it, and normalizes its tags
+func validateSensorRecord(record *SensorRecord, hardwareRows, idRows map[string]int) error {
+	if record.HardwareID == "" {
+		return fmt.Errorf("hardware_id is required")
+	}
+	if row, ok := hardwareRows[record.HardwareID]; ok {
+		return fmt.Errorf("hardware_id duplicates row %d", row)
+	}
+	if len(record.ID) > maxSensorIDLength {
+		return fmt.Errorf("id must be at most %d characters", maxSensorIDLength)
+	}
+	if row, ok := idRows[record.ID]; ok && record.ID != "" {
+		return fmt.Errorf("id duplicates row %d", row)
+	}
+	if len(record.Tags) > 0 {
+		tags, err := NormalizeTags(record.Tags)
+		if err != nil {
+			return err
+		}
+		record.Tags = tags
+	}
+	return nil
+}
+
+// tagsOrEmpty returns tags, or an empty list for nil, which pq.Array would
+// send as NULL
+func tagsOrEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// registeredSet is the registered sensors sharing a hardware ID or ID with an
+// import
+type registeredSet struct {
+	byHardware map[string]string
+	byID       map[string]string
+}
+
+// registeredSensors locks and returns the registered sensors whose hardware
+// ID or ID appears in records
+func registeredSensors(ctx context.Context, tx *sql.Tx, records []SensorRecord) (*registeredSet, error) {
+	hardwareIDs := make([]string, 0, len(records))
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		hardwareIDs = append(hardwareIDs, record.HardwareID)
+		if record.ID != "" {
+			ids = append(ids, record.ID)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, hardware_id FROM sensors
+		WHERE hardware_id = ANY($1) OR id = ANY($2)
+		FOR UPDATE
+	`, pq.Array(hardwareIDs), pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up registered sensors: %w", err)
+	}
+	defer rows.Close()
+
+	set := &registeredSet{byHardware: make(map[string]string), byID: make(map[string]string)}
+	for rows.Next() {
+		var id, hardwareID string
+		if err := rows.Scan(&id, &hardwareID); err != nil {
+			return nil, fmt.Errorf("failed to scan registered sensor: %w", err)
+		}
+		set.byHardware[hardwareID] = id
+		set.byID[id] = hardwareID
+	}
+	return set, rows.Err()
+}
@@ -0,0 +1,714 @@
+package registry
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+// defaultTokenTTL is used when a token request does not specify a TTL
+const defaultTokenTTL = 7 * 24 * time.Hour
+
+// HeaderAPIKey carries a device API key
+const HeaderAPIKey = "X-API-Key"
+
+// HeaderActor names who makes an admin change, for the audit log
+const HeaderActor = "X-Actor"
+
+// maxImportBytes bounds the body of a sensor import
+const maxImportBytes = 32 << 20
+
+// Threshold change listing limits
+const (
+	defaultThresholdChanges = 100
+	maxThresholdChanges     = 1000
+)
+
+// Handler exposes the registry over HTTP
+type Handler struct {
+	registry        *Registry
+	auth            *CachingAuthenticator
+	metrics         *CredentialMetrics
+	adminToken      string
+	rotationOverlap time.Duration
+	logger          *slog.Logger
+}
+
+// NewHandler creates a new HTTP handler. Admin endpoints require
+// "Authorization: Bearer <adminToken>"; they are disabled when adminToken is empty.
+// Rotations keep the previous credentials valid for rotationOverlap unless the request overrides it.
+// logger may be nil.
+func NewHandler(registry *Registry, auth *CachingAuthenticator, metrics *CredentialMetrics, adminToken string, rotationOverlap time.Duration, logger *slog.Logger) *Handler {
+	return &Handler{
+		registry:        registry,
+		auth:            auth,
+		metrics:         metrics,
+		adminToken:      adminToken,
+		rotationOverlap: rotationOverlap,
+		logger:          logging.OrDefault(logger),
+	}
+}
+
+// Register mounts the registry routes on a mux
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/provisioning/tokens", h.requireAdmin(h.createToken))
+	mux.HandleFunc("POST /api/v1/provisioning/claim", h.claim)
+	mux.HandleFunc("POST /api/v1/sensors/import", h.requireAdmin(h.importSensors))
+	mux.HandleFunc("GET /api/v1/sensors/export", h.requireAdmin(h.exportSensors))
+	mux.HandleFunc("GET /api/v1/sensors/{id}", h.requireAdmin(h.getSensor))
+	mux.HandleFunc("PUT /api/v1/sensors/{id}/tags", h.requireAdmin(h.putSensorTags))
+	mux.HandleFunc("GET /api/v1/tags", h.requireAdmin(h.listTags))
+	mux.HandleFunc("POST /api/v1/tags/{tag}/sensors", h.requireAdmin(h.tagSensors))
+	mux.HandleFunc("POST /api/v1/sensors/{id}/credentials/rotate", h.rotate)
+	mux.HandleFunc("GET /api/v1/auth/whoami", h.whoami)
+	mux.HandleFunc("POST /api/v1/auth/mqtt", h.authenticateMQTT)
+	mux.HandleFunc("GET /api/v1/annotations", h.requireAdmin(h.listAnnotations))
+	mux.HandleFunc("PUT /api/v1/annotations/{scope}/{target}", h.requireAdmin(h.putAnnotation))
+	mux.HandleFunc("DELETE /api/v1/annotations/{scope}/{target}", h.requireAdmin(h.deleteAnnotation))
+	mux.HandleFunc("GET /api/v1/partition-sites", h.requireAdmin(h.listPartitionSites))
+	mux.HandleFunc("PUT /api/v1/partition-sites/{topic}", h.requireAdmin(h.putPartitionSites))
+	mux.HandleFunc("DELETE /api/v1/partition-sites/{topic}", h.requireAdmin(h.deletePartitionSites))
+	mux.HandleFunc("GET /api/v1/thresholds", h.requireAdmin(h.listThresholds))
+	mux.HandleFunc("GET /api/v1/thresholds/changes", h.requireAdmin(h.listThresholdChanges))
+	mux.HandleFunc("PUT /api/v1/thresholds/global", h.requireAdmin(h.putGlobalThresholds))
+	mux.HandleFunc("DELETE /api/v1/thresholds/global", h.requireAdmin(h.deleteGlobalThresholds))
+	mux.HandleFunc("PUT /api/v1/thresholds/tags/{tag}", h.requireAdmin(h.putTagThresholds))
+	mux.HandleFunc("DELETE /api/v1/thresholds/tags/{tag}", h.requireAdmin(h.deleteTagThresholds))
+	mux.HandleFunc("PUT /api/v1/thresholds/sensors/{id}", h.requireAdmin(h.putSensorThresholds))
+	mux.HandleFunc("DELETE /api/v1/thresholds/sensors/{id}", h.requireAdmin(h.deleteSensorThresholds))
+	mux.HandleFunc("GET /healthz", h.healthz)
+}
+
+// healthz reports that the registry is serving requests
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// createToken issues a provisioning token
+func (h *Handler) createToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Label     string `json:"label"`
+		MaxClaims int    `json:"max_claims"`
+		TTL       string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.MaxClaims == 0 {
+		req.MaxClaims = 1
+	}
+	if req.MaxClaims < 0 {
+		writeError(w, http.StatusBadRequest, "max_claims must be positive")
+		return
+	}
+
+	ttl := defaultTokenTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+		ttl = parsed
+	}
+
+	token, err := h.registry.CreateProvisioningToken(r.Context(), req.Label, req.MaxClaims, ttl)
+	if err != nil {
+		h.logger.Error("Failed to create provisioning token", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, token)
+}
+
+// claim exchanges a provisioning token for credentials
+func (h *Handler) claim(w http.ResponseWriter, r *http.Request) {
+	var req ClaimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Token == "" || req.HardwareID == "" {
+		writeError(w, http.StatusBadRequest, "token and hardware_id are required")
+		return
+	}
+
+	creds, err := h.registry.Claim(r.Context(), req)
+	switch {
+	case errors.Is(err, ErrInvalidToken):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, ErrAlreadyClaimed):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		h.logger.Error("Failed to claim sensor", "hardware_id", req.HardwareID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to claim sensor")
+	default:
+		h.logger.Info("Provisioned sensor", "sensor_id", creds.SensorID, "hardware_id", req.HardwareID)
+		writeJSON(w, http.StatusCreated, creds)
+	}
+}
+
+// getSensor returns a registered sensor
+func (h *Handler) getSensor(w http.ResponseWriter, r *http.Request) {
+	sensor, err := h.registry.GetSensor(r.Context(), r.PathValue("id"))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "sensor not found")
+	case err != nil:
+		h.logger.Error("Failed to load sensor", "sensor_id", r.PathValue("id"), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load sensor")
+	default:
+		writeJSON(w, http.StatusOK, sensor)
+	}
+}
+
+// importSensors registers and updates sensors from a CSV body (Content-Type
+// text/csv) or a JSON array, or only checks them with dry_run=true. It
+// answers 422 with the invalid records when any record is invalid.
+func (h *Handler) importSensors(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+		dryRun = parsed
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var records []SensorRecord
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		records, err = ReadSensorRecordsCSV(body)
+	} else {
+		records, err = ReadSensorRecordsJSON(body)
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("import body must be at most %d bytes", maxImportBytes))
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case len(records) == 0:
+		writeError(w, http.StatusBadRequest, "no sensors to import")
+		return
+	}
+
+	report, err := h.registry.ImportSensors(r.Context(), records, dryRun)
+	switch {
+	case err != nil:
+		h.logger.Error("Failed to import sensors", "sensors", len(records), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to import sensors")
+	case report.Invalid > 0:
+		writeJSON(w, http.StatusUnprocessableEntity, report)
+	default:
+		if !dryRun {
+			h.logger.Info("Imported sensors", "created", report.Created, "updated", report.Updated,
+				"actor", r.Header.Get(HeaderActor))
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// exportSensors returns the registered sensors, of the site and tag query
+// parameters if set, as JSON or with format=csv as CSV that importSensors
+// accepts
+func (h *Handler) exportSensors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	tag := query.Get("tag")
+	if tag != "" {
+		var err error
+		if tag, err = NormalizeTag(tag); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	sensors, err := h.registry.ListSensors(r.Context(), query.Get("site"), tag)
+	if err != nil {
+		h.logger.Error("Failed to list sensors", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list sensors")
+		return
+	}
+
+	if format != "csv" {
+		if sensors == nil {
+			sensors = []*Sensor{}
+		}
+		writeJSON(w, http.StatusOK, sensors)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="sensors.csv"`)
+	if err := WriteSensorsCSV(w, sensors); err != nil {
+		h.logger.Warn("Failed to write sensor export", "error", err)
+	}
+}
+
+// putSensorTags replaces the tags of a sensor
+func (h *Handler) putSensorTags(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	sensor, err := h.registry.SetSensorTags(r.Context(), r.PathValue("id"), req.Tags)
+	switch {
+	case errors.Is(err, ErrInvalidTag):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "sensor not found")
+	case err != nil:
+		h.logger.Error("Failed to store sensor tags", "sensor_id", r.PathValue("id"), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to store sensor tags")
+	default:
+		h.logger.Info("Updated sensor tags", "sensor_id", sensor.ID, "tags", sensor.Tags, "actor", r.Header.Get(HeaderActor))
+		writeJSON(w, http.StatusOK, sensor)
+	}
+}
+
+// listTags returns every tag in use with the number of sensors carrying it
+func (h *Handler) listTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.registry.ListTags(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to list tags", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list tags")
+		return
+	}
+	if tags == nil {
+		tags = []*TagCount{}
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// tagSensors adds a tag to a group of sensors, answering with the IDs of
+// those that carry it and those that could not be tagged, being unknown or
+// at the tag limit
+func (h *Handler) tagSensors(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SensorIDs []string `json:"sensor_ids"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(req.SensorIDs) == 0 || len(req.SensorIDs) > MaxImportSensors {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("sensor_ids must list 1 to %d sensors", MaxImportSensors))
+		return
+	}
+
+	tagged, err := h.registry.TagSensors(r.Context(), r.PathValue("tag"), req.SensorIDs)
+	switch {
+	case errors.Is(err, ErrInvalidTag):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		h.logger.Error("Failed to tag sensors", "tag", r.PathValue("tag"), "sensors", len(req.SensorIDs), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to tag sensors")
+		return
+	}
+
+	carrying := make(map[string]bool, len(tagged))
+	for _, id := range tagged {
+		carrying[id] = true
+	}
+	untagged := []string{}
+	for _, id := range req.SensorIDs {
+		if !carrying[id] {
+			untagged = append(untagged, id)
+			carrying[id] = true
+		}
+	}
+	h.logger.Info("Tagged sensors", "tag", r.PathValue("tag"), "tagged", len(tagged), "untagged", len(untagged),
+		"actor", r.Header.Get(HeaderActor))
+	writeJSON(w, http.StatusOK, map[string][]string{"tagged": tagged, "untagged": untagged})
+}
+
+// rotate issues new credentials for a sensor. It may be called by an admin or
+// by the device itself using its current API key.
+func (h *Handler) rotate(w http.ResponseWriter, r *http.Request) {
+	sensorID := r.PathValue("id")
+	if !h.isAdmin(r) {
+		identity, err := h.auth.AuthenticateAPIKey(r.Context(), r.Header.Get(HeaderAPIKey))
+		if err != nil || identity.SensorID != sensorID {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+
+	var req struct {
+		Overlap string `json:"overlap"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+
+	overlap := h.rotationOverlap
+	if req.Overlap != "" {
+		parsed, err := time.ParseDuration(req.Overlap)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, "invalid overlap")
+			return
+		}
+		overlap = parsed
+	}
+
+	creds, err := h.registry.RotateCredentials(r.Context(), sensorID, overlap)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "sensor not found")
+	case err != nil:
+		h.logger.Error("Failed to rotate credentials", "sensor_id", sensorID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to rotate credentials")
+	default:
+		h.auth.Invalidate(sensorID)
+		if h.metrics != nil {
+			h.metrics.Rotations.Inc()
+		}
+		writeJSON(w, http.StatusCreated, creds)
+	}
+}
+
+// whoami authenticates the request API key through the auth cache
+func (h *Handler) whoami(w http.ResponseWriter, r *http.Request) {
+	identity, err := h.auth.AuthenticateAPIKey(r.Context(), r.Header.Get(HeaderAPIKey))
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case err != nil:
+		h.logger.Error("Failed to authenticate API key", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to authenticate")
+	default:
+		writeJSON(w, http.StatusOK, identity)
+	}
+}
+
+// authenticateMQTT backs MQTT broker HTTP auth plugins; it answers 200 or 401
+func (h *Handler) authenticateMQTT(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	identity, err := h.registry.AuthenticateMQTT(r.Context(), req.Username, req.Password)
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case err != nil:
+		h.logger.Error("Failed to authenticate MQTT client", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to authenticate")
+	default:
+		writeJSON(w, http.StatusOK, identity)
+	}
+}
+
+// listAnnotations returns every rule and site annotation
+func (h *Handler) listAnnotations(w http.ResponseWriter, r *http.Request) {
+	annotations, err := h.registry.ListAnnotations(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to list annotations", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list annotations")
+		return
+	}
+	if annotations == nil {
+		annotations = []*Annotation{}
+	}
+	writeJSON(w, http.StatusOK, annotations)
+}
+
+// putAnnotation sets the runbook link and annotations for a rule or site
+func (h *Handler) putAnnotation(w http.ResponseWriter, r *http.Request) {
+	annotation := &Annotation{Scope: r.PathValue("scope"), Target: r.PathValue("target")}
+	if !validScope(annotation.Scope) {
+		writeError(w, http.StatusBadRequest, "scope must be rule or site")
+		return
+	}
+
+	var req struct {
+		RunbookURL  string            `json:"runbook_url"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.RunbookURL != "" {
+		if u, err := url.Parse(req.RunbookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			writeError(w, http.StatusBadRequest, "runbook_url must be an http(s) URL")
+			return
+		}
+	}
+	annotation.RunbookURL = req.RunbookURL
+	annotation.Annotations = req.Annotations
+
+	if err := h.registry.SetAnnotation(r.Context(), annotation); err != nil {
+		h.logger.Error("Failed to store annotation", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to store annotation")
+		return
+	}
+	writeJSON(w, http.StatusOK, annotation)
+}
+
+// deleteAnnotation removes the annotation for a rule or site
+func (h *Handler) deleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	err := h.registry.DeleteAnnotation(r.Context(), r.PathValue("scope"), r.PathValue("target"))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "annotation not found")
+	case err != nil:
+		h.logger.Error("Failed to delete annotation", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete annotation")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listPartitionSites returns the site of every mapped partition
+func (h *Handler) listPartitionSites(w http.ResponseWriter, r *http.Request) {
+	sites, err := h.registry.ListPartitionSites(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to list partition sites", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list partition sites")
+		return
+	}
+	if sites == nil {
+		sites = []*PartitionSite{}
+	}
+	writeJSON(w, http.StatusOK, sites)
+}
+
+// putPartitionSites replaces the partition sites of a topic with a JSON
+// object mapping partitions to sites
+func (h *Handler) putPartitionSites(w http.ResponseWriter, r *http.Request) {
+	var sites map[int32]string
+	if err := json.NewDecoder(r.Body).Decode(&sites); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	for partition, site := range sites {
+		if partition < 0 || site == "" {
+			writeError(w, http.StatusBadRequest, "partitions must map non-negative partitions to sites")
+			return
+		}
+	}
+
+	if err := h.registry.SetPartitionSites(r.Context(), r.PathValue("topic"), sites); err != nil {
+		h.logger.Error("Failed to store partition sites", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to store partition sites")
+		return
+	}
+	writeJSON(w, http.StatusOK, sites)
+}
+
+// deletePartitionSites removes the partition sites of a topic
+func (h *Handler) deletePartitionSites(w http.ResponseWriter, r *http.Request) {
+	err := h.registry.DeletePartitionSites(r.Context(), r.PathValue("topic"))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "partition sites not found")
+	case err != nil:
+		h.logger.Error("Failed to delete partition sites", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete partition sites")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listThresholds returns the global, per-tag and per-sensor thresholds stored
+// in the registry
+func (h *Handler) listThresholds(w http.ResponseWriter, r *http.Request) {
+	thresholds, err := h.registry.ListThresholds(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to list thresholds", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list thresholds")
+		return
+	}
+	if thresholds == nil {
+		thresholds = []*ThresholdSetting{}
+	}
+	writeJSON(w, http.StatusOK, thresholds)
+}
+
+// listThresholdChanges returns the threshold audit log, newest first,
+// optionally filtered by scope and target
+func (h *Handler) listThresholdChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultThresholdChanges
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxThresholdChanges {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxThresholdChanges))
+			return
+		}
+		limit = parsed
+	}
+
+	changes, err := h.registry.ListThresholdChanges(r.Context(), query.Get("scope"), query.Get("target"), limit)
+	if err != nil {
+		h.logger.Error("Failed to list threshold changes", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list threshold changes")
+		return
+	}
+	if changes == nil {
+		changes = []*ThresholdChange{}
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// putGlobalThresholds sets the thresholds of every sensor without its own
+func (h *Handler) putGlobalThresholds(w http.ResponseWriter, r *http.Request) {
+	h.putThresholds(w, r, ThresholdScopeGlobal, "")
+}
+
+// deleteGlobalThresholds reverts every sensor without its own thresholds to
+// the detector's configured ones
+func (h *Handler) deleteGlobalThresholds(w http.ResponseWriter, r *http.Request) {
+	h.deleteThresholds(w, r, ThresholdScopeGlobal, "")
+}
+
+// putTagThresholds sets the thresholds of the sensors carrying a tag
+func (h *Handler) putTagThresholds(w http.ResponseWriter, r *http.Request) {
+	tag, err := NormalizeTag(r.PathValue("tag"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.putThresholds(w, r, ThresholdScopeTag, tag)
+}
+
+// deleteTagThresholds reverts the sensors carrying a tag to the global
+// thresholds, or those of their other tags
+func (h *Handler) deleteTagThresholds(w http.ResponseWriter, r *http.Request) {
+	tag, err := NormalizeTag(r.PathValue("tag"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.deleteThresholds(w, r, ThresholdScopeTag, tag)
+}
+
+// putSensorThresholds sets the thresholds of one sensor
+func (h *Handler) putSensorThresholds(w http.ResponseWriter, r *http.Request) {
+	h.putThresholds(w, r, ThresholdScopeSensor, r.PathValue("id"))
+}
+
+// deleteSensorThresholds reverts a sensor to the global thresholds
+func (h *Handler) deleteSensorThresholds(w http.ResponseWriter, r *http.Request) {
+	h.deleteThresholds(w, r, ThresholdScopeSensor, r.PathValue("id"))
+}
+
+// putThresholds validates and stores the thresholds of a scope, recording
+// the actor of X-Actor and the reason of the body in the audit log
+func (h *Handler) putThresholds(w http.ResponseWriter, r *http.Request, scope, target string) {
+	actor := r.Header.Get(HeaderActor)
+	if actor == "" {
+		writeError(w, http.StatusBadRequest, HeaderActor+" header is required")
+		return
+	}
+
+	var req struct {
+		ThresholdValues
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.ThresholdValues.empty() {
+		writeError(w, http.StatusBadRequest, "at least one threshold is required")
+		return
+	}
+	if err := req.ThresholdValues.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	setting := &ThresholdSetting{Scope: scope, Target: target, ThresholdValues: req.ThresholdValues}
+	if err := h.registry.SetThresholds(r.Context(), setting, actor, req.Reason); err != nil {
+		h.logger.Error("Failed to store thresholds", "scope", scope, "target", target, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to store thresholds")
+		return
+	}
+	h.logger.Info("Updated thresholds", "scope", scope, "target", target, "actor", actor)
+	writeJSON(w, http.StatusOK, setting)
+}
+
+// deleteThresholds removes the thresholds of a scope, recording the actor of
+// X-Actor and the reason query parameter in the audit log
+func (h *Handler) deleteThresholds(w http.ResponseWriter, r *http.Request, scope, target string) {
+	actor := r.Header.Get(HeaderActor)
+	if actor == "" {
+		writeError(w, http.StatusBadRequest, HeaderActor+" header is required")
+		return
+	}
+
+	err := h.registry.DeleteThresholds(r.Context(), scope, target, actor, r.URL.Query().Get("reason"))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "thresholds not found")
+	case err != nil:
+		h.logger.Error("Failed to delete thresholds", "scope", scope, "target", target, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete thresholds")
+	default:
+		h.logger.Info("Deleted thresholds", "scope", scope, "target", target, "actor", actor)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// isAdmin reports whether the request carries the admin bearer token
+func (h *Handler) isAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return h.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+}
+
+// requireAdmin guards a handler with the admin bearer token
+func (h *Handler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.isAdmin(r) {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,211 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+// PartitionSite records the site whose sensors a partition of a topic carries
+type PartitionSite struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Site      string    `json:"site"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetPartitionSites replaces the partition sites of topic with sites, which
+// maps partitions to their site
+func (r *Registry) SetPartitionSites(ctx context.Context, topic string, sites map[int32]string) error {
+	for partition, site := range sites {
+		if partition < 0 {
+			return fmt.Errorf("invalid partition: %d", partition)
+		}
+		if site == "" {
+			return fmt.Errorf("no site for partition %d", partition)
+		}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM partition_sites WHERE topic = $1`, topic); err != nil {
+		return fmt.Errorf("failed to clear partition sites: %w", err)
+	}
+	for partition, site := range sites {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO partition_sites (topic, partition, site, updated_at) VALUES ($1, $2, $3, NOW())
+		`, topic, partition, site)
+		if err != nil {
+			return fmt.Errorf("failed to store partition site: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DeletePartitionSites removes the partition sites of topic
+func (r *Registry) DeletePartitionSites(ctx context.Context, topic string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM partition_sites WHERE topic = $1`, topic)
+	if err != nil {
+		return fmt.Errorf("failed to delete partition sites: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListPartitionSites returns every partition site ordered by topic and partition
+func (r *Registry) ListPartitionSites(ctx context.Context) ([]*PartitionSite, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT topic, partition, site, updated_at FROM partition_sites ORDER BY topic, partition
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partition sites: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*PartitionSite
+	for rows.Next() {
+		var site PartitionSite
+		if err := rows.Scan(&site.Topic, &site.Partition, &site.Site, &site.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan partition site: %w", err)
+		}
+		result = append(result, &site)
+	}
+	return result, rows.Err()
+}
+
+// PartitionSiteCache keeps the registry partition sites in memory for the
+// site-affinity balance strategy
+type PartitionSiteCache struct {
+	registry *Registry
+	interval time.Duration
+	timeout  time.Duration
+	postgres *db.PostgresDB
+	logger   *slog.Logger
+
+	mu    sync.RWMutex
+	sites map[string]map[int32]string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPartitionSiteCache creates a cache refreshed from registry every
+// interval; each refresh is bounded by timeout. logger may be nil.
+func NewPartitionSiteCache(registry *Registry, interval, timeout time.Duration, logger *slog.Logger) *PartitionSiteCache {
+	if timeout <= 0 {
+		timeout = interval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &PartitionSiteCache{
+		registry: registry,
+		interval: interval,
+		timeout:  timeout,
+		logger:   logging.OrDefault(logger),
+		sites:    make(map[string]map[int32]string),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// NewPartitionSiteCacheFromConfig connects to the registry database and
+// creates a cache. It returns nil, nil when partition site refresh is
+// disabled.
+func NewPartitionSiteCacheFromConfig(cfg *config.Config, logger *slog.Logger) (*PartitionSiteCache, error) {
+	if cfg.PartitionSiteRefreshInterval <= 0 {
+		return nil, nil
+	}
+
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect partition site database: %w", err)
+	}
+
+	cache := NewPartitionSiteCache(NewRegistry(postgres.DB()), cfg.PartitionSiteRefreshInterval, cfg.StoreTimeout, logger)
+	cache.postgres = postgres
+	return cache, nil
+}
+
+// Start loads the partition sites and refreshes them in the background
+func (c *PartitionSiteCache) Start() {
+	if err := c.refresh(); err != nil {
+		c.logger.Warn("Failed to load partition sites", "error", err)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.refresh(); err != nil {
+					c.logger.Warn("Failed to refresh partition sites", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing and closes the database connection if the cache owns it
+func (c *PartitionSiteCache) Stop() {
+	c.cancel()
+	c.wg.Wait()
+	if c.postgres != nil {
+		c.postgres.Close()
+	}
+}
+
+// refresh reloads the partition sites under the cache's lifetime and timeout
+func (c *PartitionSiteCache) refresh() error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	return c.Refresh(ctx)
+}
+
+// Refresh reloads every partition site from the registry
+func (c *PartitionSiteCache) Refresh(ctx context.Context) error {
+	partitionSites, err := c.registry.ListPartitionSites(ctx)
+	if err != nil {
+		return err
+	}
+
+	sites := make(map[string]map[int32]string)
+	for _, site := range partitionSites {
+		if sites[site.Topic] == nil {
+			sites[site.Topic] = make(map[int32]string)
+		}
+		sites[site.Topic][site.Partition] = site.Site
+	}
+
+	c.mu.Lock()
+	c.sites = sites
+	c.mu.Unlock()
+	return nil
+}
+
+// Sites returns the site of each partition by topic. The map is replaced,
+// never modified, on refresh and must not be modified by the caller.
+func (c *PartitionSiteCache) Sites() map[string]map[int32]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sites
+}
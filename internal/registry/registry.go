@@ -0,0 +1,376 @@
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Registry errors
+var (
+	ErrInvalidToken   = errors.New("provisioning token is invalid, expired or exhausted")
+	ErrAlreadyClaimed = errors.New("device has already been claimed")
+	ErrNotFound       = errors.New("not found")
+	ErrUnauthorized   = errors.New("invalid or expired credentials")
+)
+
+// Sensor is a registered device. Tags group sensors for queries, thresholds
+// and alert routing.
+type Sensor struct {
+	ID              string    `json:"id"`
+	HardwareID      string    `json:"hardware_id"`
+	FirmwareVersion string    `json:"firmware_version,omitempty"`
+	Site            string    `json:"site,omitempty"`
+	Zone            string    `json:"zone,omitempty"`
+	Tags            []string  `json:"tags,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ProvisioningToken authorizes devices to claim a sensor identity
+type ProvisioningToken struct {
+	Token     string    `json:"token"`
+	Label     string    `json:"label"`
+	MaxClaims int       `json:"max_claims"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ClaimRequest is presented by a device during provisioning
+type ClaimRequest struct {
+	Token           string `json:"token"`
+	HardwareID      string `json:"hardware_id"`
+	FirmwareVersion string `json:"firmware_version"`
+}
+
+// Credentials are issued to a device when it claims an identity or rotates.
+// Secrets are only ever returned once; the registry stores their hashes.
+type Credentials struct {
+	SensorID     string `json:"sensor_id"`
+	APIKey       string `json:"api_key"`
+	MQTTUsername string `json:"mqtt_username"`
+	MQTTPassword string `json:"mqtt_password"`
+	// PreviousExpiresAt is when the credentials replaced by a rotation stop working
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+// Identity is the result of authenticating a device credential
+type Identity struct {
+	SensorID string `json:"sensor_id"`
+	// ExpiresAt is set once the credential has been superseded or must be rotated
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Registry stores sensors, provisioning tokens and device credentials in PostgreSQL.
+// Tables are created by db.PostgresDB.InitTables.
+type Registry struct {
+	db *sql.DB
+}
+
+// NewRegistry creates a new registry
+func NewRegistry(db *sql.DB) *Registry {
+	return &Registry{db: db}
+}
+
+// CreateProvisioningToken issues a token that can be used by up to maxClaims devices
+func (r *Registry) CreateProvisioningToken(ctx context.Context, label string, maxClaims int, ttl time.Duration) (*ProvisioningToken, error) {
+	if maxClaims <= 0 {
+		return nil, fmt.Errorf("max claims must be positive")
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &ProvisioningToken{
+		Token:     "pt_" + secret,
+		Label:     label,
+		MaxClaims: maxClaims,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO provisioning_tokens (token_hash, label, max_claims, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, hashSecret(token.Token), label, maxClaims, token.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store provisioning token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Claim exchanges a provisioning token for a sensor identity and credentials
+func (r *Registry) Claim(ctx context.Context, req ClaimRequest) (*Credentials, error) {
+	if req.Token == "" || req.HardwareID == "" {
+		return nil, fmt.Errorf("token and hardware_id are required")
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var tokenID int64
+	var claims, maxClaims int
+	var expiresAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, claims, max_claims, expires_at FROM provisioning_tokens
+		WHERE token_hash = $1 AND revoked = FALSE
+		FOR UPDATE
+	`, hashSecret(req.Token)).Scan(&tokenID, &claims, &maxClaims, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provisioning token: %w", err)
+	}
+	if claims >= maxClaims || time.Now().After(expiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	// A sensor imported in bulk is registered without credentials; the
+	// device claims it and keeps its sensor ID
+	var sensorID string
+	var claimed bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT s.id, s.provisioning_token_id IS NOT NULL OR EXISTS (SELECT 1 FROM sensor_credentials c WHERE c.sensor_id = s.id)
+		FROM sensors s WHERE s.hardware_id = $1
+		FOR UPDATE OF s
+	`, req.HardwareID).Scan(&sensorID, &claimed)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		sensorID = uuid.New().String()
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO sensors (id, hardware_id, firmware_version, provisioning_token_id)
+			VALUES ($1, $2, $3, $4)
+		`, sensorID, req.HardwareID, req.FirmwareVersion, tokenID); err != nil {
+			// A concurrent claim registered the hardware ID first
+			if isUniqueViolation(err) {
+				return nil, ErrAlreadyClaimed
+			}
+			return nil, fmt.Errorf("failed to register sensor: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to check hardware ID: %w", err)
+	case claimed:
+		return nil, ErrAlreadyClaimed
+	default:
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE sensors SET firmware_version = COALESCE(NULLIF($2, ''), firmware_version), provisioning_token_id = $3
+			WHERE id = $1
+		`, sensorID, req.FirmwareVersion, tokenID); err != nil {
+			return nil, fmt.Errorf("failed to register sensor: %w", err)
+		}
+	}
+
+	creds, err := issueCredentials(ctx, tx, sensorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE provisioning_tokens SET claims = claims + 1 WHERE id = $1`, tokenID,
+	); err != nil {
+		return nil, fmt.Errorf("failed to update provisioning token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit claim: %w", err)
+	}
+
+	return creds, nil
+}
+
+// isUniqueViolation reports whether err is PostgreSQL's unique_violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// GetSensor returns a registered sensor
+func (r *Registry) GetSensor(ctx context.Context, id string) (*Sensor, error) {
+	sensor, err := scanSensor(r.db.QueryRowContext(ctx, `
+		SELECT id, hardware_id, firmware_version, site, zone, tags, created_at FROM sensors WHERE id = $1
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sensor: %w", err)
+	}
+	return sensor, nil
+}
+
+// scanSensor scans id, hardware_id, firmware_version, site, zone, tags and
+// created_at into a sensor
+func scanSensor(row interface{ Scan(...interface{}) error }) (*Sensor, error) {
+	var sensor Sensor
+	var firmware, site, zone sql.NullString
+	if err := row.Scan(&sensor.ID, &sensor.HardwareID, &firmware, &site, &zone, pq.Array(&sensor.Tags), &sensor.CreatedAt); err != nil {
+		return nil, err
+	}
+	sensor.FirmwareVersion = firmware.String
+	sensor.Site = site.String
+	sensor.Zone = zone.String
+	return &sensor, nil
+}
+
+// IsRegistered reports whether a sensor ID exists; it satisfies ingest.SensorRegistry
+func (r *Registry) IsRegistered(ctx context.Context, sensorID string) (bool, error) {
+	var exists bool
+	if err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM sensors WHERE id = $1)`, sensorID,
+	).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check sensor registration: %w", err)
+	}
+	return exists, nil
+}
+
+// RotateCredentials issues new credentials for a sensor. Credentials that are
+// still active keep working for the overlap window so devices can switch over.
+func (r *Registry) RotateCredentials(ctx context.Context, sensorID string, overlap time.Duration) (*Credentials, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the sensor row so concurrent rotations are serialized
+	var id string
+	err = tx.QueryRowContext(ctx, `SELECT id FROM sensors WHERE id = $1 FOR UPDATE`, sensorID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sensor: %w", err)
+	}
+
+	previousExpiresAt := time.Now().Add(overlap).UTC()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sensor_credentials SET expires_at = $2
+		WHERE sensor_id = $1 AND (expires_at IS NULL OR expires_at > $2)
+	`, sensorID, previousExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to expire previous credentials: %w", err)
+	}
+
+	creds, err := issueCredentials(ctx, tx, sensorID)
+	if err != nil {
+		return nil, err
+	}
+	creds.PreviousExpiresAt = &previousExpiresAt
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rotation: %w", err)
+	}
+
+	return creds, nil
+}
+
+// AuthenticateAPIKey returns the identity owning an unexpired API key
+func (r *Registry) AuthenticateAPIKey(ctx context.Context, apiKey string) (*Identity, error) {
+	return r.authenticate(ctx, `
+		SELECT sensor_id, expires_at FROM sensor_credentials
+		WHERE api_key_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	`, hashSecret(apiKey))
+}
+
+// AuthenticateMQTT returns the identity owning an unexpired MQTT username and password
+func (r *Registry) AuthenticateMQTT(ctx context.Context, username, password string) (*Identity, error) {
+	return r.authenticate(ctx, `
+		SELECT sensor_id, expires_at FROM sensor_credentials
+		WHERE mqtt_username = $1 AND mqtt_password_hash = $2 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at DESC LIMIT 1
+	`, username, hashSecret(password))
+}
+
+// authenticate runs a credential lookup query returning sensor_id and expires_at
+func (r *Registry) authenticate(ctx context.Context, query string, args ...interface{}) (*Identity, error) {
+	var identity Identity
+	var expiresAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, args...).Scan(&identity.SensorID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate credentials: %w", err)
+	}
+	if expiresAt.Valid {
+		identity.ExpiresAt = &expiresAt.Time
+	}
+	return &identity, nil
+}
+
+// ScheduleStaleRotations gives credentials older than maxAge a rotation deadline
+// of grace from now. Devices that have not rotated by then are locked out.
+func (r *Registry) ScheduleStaleRotations(ctx context.Context, maxAge, grace time.Duration) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE sensor_credentials SET expires_at = $2
+		WHERE expires_at IS NULL AND created_at < $1
+	`, time.Now().Add(-maxAge), time.Now().Add(grace))
+	if err != nil {
+		return 0, fmt.Errorf("failed to schedule stale credential rotations: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// PurgeExpiredCredentials deletes credentials whose overlap window or rotation deadline has passed
+func (r *Registry) PurgeExpiredCredentials(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM sensor_credentials WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired credentials: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// issueCredentials generates and stores a new credential set for a sensor
+func issueCredentials(ctx context.Context, tx *sql.Tx, sensorID string) (*Credentials, error) {
+	apiKeySecret, err := randomSecret()
+	if err != nil {
+		return nil, err
+	}
+	mqttPassword, err := randomSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &Credentials{
+		SensorID:     sensorID,
+		APIKey:       "sk_" + apiKeySecret,
+		MQTTUsername: sensorID,
+		MQTTPassword: mqttPassword,
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO sensor_credentials (sensor_id, api_key_hash, mqtt_username, mqtt_password_hash)
+		VALUES ($1, $2, $3, $4)
+	`, creds.SensorID, hashSecret(creds.APIKey), creds.MQTTUsername, hashSecret(creds.MQTTPassword)); err != nil {
+		return nil, fmt.Errorf("failed to store credentials: %w", err)
+	}
+
+	return creds, nil
+}
+
+// randomSecret returns a URL-safe random string with 256 bits of entropy
+func randomSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashSecret hashes a secret for storage
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
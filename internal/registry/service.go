@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// credentialEnforcementJob is the scheduler job applying the credential rotation policy
+const credentialEnforcementJob = "credential-enforcement"
+
+// Service serves the sensor registry HTTP API and enforces credential rotation
+type Service struct {
+	Registry  *Registry
+	postgres  *db.PostgresDB
+	server    *http.Server
+	scheduler *runtime.Scheduler
+	logger    *slog.Logger
+}
+
+// NewService connects to PostgreSQL and prepares the registry HTTP server.
+// Metrics are registered on registry; logger may be nil.
+func NewService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "registry")
+
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect registry database: %w", err)
+	}
+
+	if cfg.RegistryAdminToken == "" {
+		logger.Warn("REGISTRY_ADMIN_TOKEN is not set; provisioning token issuance is disabled")
+	}
+
+	credentialMetrics := NewCredentialMetrics("iot", "registry", registry)
+
+	s := &Service{
+		Registry:  NewRegistry(postgres.DB()),
+		postgres:  postgres,
+		scheduler: runtime.NewScheduler(runtime.NewSchedulerMetrics("iot", "registry", registry), logger),
+		logger:    logger,
+	}
+
+	auth := NewCachingAuthenticator(s.Registry, cfg.AuthCacheTTL, credentialMetrics)
+	mux := http.NewServeMux()
+	NewHandler(s.Registry, auth, credentialMetrics, cfg.RegistryAdminToken, cfg.CredentialRotationOverlap, logger).Register(mux)
+
+	s.server = &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.RegistryPort),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Enforcement runs on one replica only
+	leader := runtime.NewLeaderElector(postgres.DB(), credentialEnforcementJob, runtime.DefaultLeaderCheckInterval,
+		runtime.NewLeaderMetrics("iot", "registry", registry), logger)
+	if err := s.scheduler.Add(runtime.Job{
+		Name:     credentialEnforcementJob,
+		Schedule: cfg.CredentialEnforceSchedule,
+		Timeout:  time.Minute,
+		Leader:   leader,
+		Run: func(ctx context.Context) error {
+			return s.enforceCredentialPolicy(ctx, cfg.CredentialMaxAge, cfg.CredentialRotationOverlap, credentialMetrics)
+		},
+	}); err != nil {
+		postgres.Close()
+		return nil, fmt.Errorf("failed to schedule credential enforcement: %w", err)
+	}
+
+	return s, nil
+}
+
+// Start starts serving the registry API and the enforcement scheduler
+func (s *Service) Start() error {
+	go func() {
+		s.logger.Info("Starting sensor registry", "addr", s.server.Addr)
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(s.logger, "Error starting sensor registry", "error", err)
+		}
+	}()
+	s.scheduler.Start()
+	return nil
+}
+
+// Stop gracefully stops the server and closes the database connection
+func (s *Service) Stop() {
+	s.scheduler.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.logger.Error("Failed to shut down sensor registry", "error", err)
+	}
+	if err := s.postgres.Close(); err != nil {
+		s.logger.Error("Failed to close registry database", "error", err)
+	}
+}
+
+// enforceCredentialPolicy sets rotation deadlines on stale credentials and
+// deletes credentials whose overlap window or deadline has passed.
+// A zero maxAge disables deadlines.
+func (s *Service) enforceCredentialPolicy(ctx context.Context, maxAge, grace time.Duration, metrics *CredentialMetrics) error {
+	if maxAge > 0 {
+		scheduled, err := s.Registry.ScheduleStaleRotations(ctx, maxAge, grace)
+		if err != nil {
+			return err
+		}
+		if scheduled > 0 {
+			s.logger.Info("Scheduled rotation deadlines for stale credentials", "credentials", scheduled)
+			metrics.DeadlinesScheduled.Add(float64(scheduled))
+		}
+	}
+
+	purged, err := s.Registry.PurgeExpiredCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	metrics.Purged.Add(float64(purged))
+	return nil
+}
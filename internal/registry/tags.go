@@ -0,0 +1,163 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// MaxSensorTags is the most tags one sensor may carry
+const MaxSensorTags = 32
+
+// maxTagLength bounds the length of one tag
+const maxTagLength = 64
+
+// ErrInvalidTag is returned for a tag NormalizeTag rejects
+var ErrInvalidTag = errors.New("invalid tag")
+
+// TagCount is a tag and how many sensors carry it
+type TagCount struct {
+	Tag     string `json:"tag"`
+	Sensors int    `json:"sensors"`
+}
+
+// NormalizeTag lowercases a tag and checks it is 1 to 64 letters, digits and
+// the characters - _ . : / =, starting with a letter or digit. Tags never
+// hold commas or spaces, so lists of them can be written comma-separated.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || len(tag) > maxTagLength {
+		return "", fmt.Errorf("%w: tags must be 1 to %d characters, got %q", ErrInvalidTag, maxTagLength, tag)
+	}
+	for i, c := range tag {
+		alphanumeric := (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+		if !alphanumeric && (i == 0 || !strings.ContainsRune("-_.:/=", c)) {
+			return "", fmt.Errorf("%w: %q must start with a letter or digit and hold only letters, digits and - _ . : / =", ErrInvalidTag, tag)
+		}
+	}
+	return tag, nil
+}
+
+// NormalizeTags normalizes each tag, drops duplicates and sorts them
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > MaxSensorTags {
+		return nil, fmt.Errorf("%w: a sensor carries at most %d tags, got %d", ErrInvalidTag, MaxSensorTags, len(normalized))
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// SetSensorTags replaces the tags of a sensor with tags, normalized by
+// NormalizeTags, and returns the sensor
+func (r *Registry) SetSensorTags(ctx context.Context, sensorID string, tags []string) (*Sensor, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	sensor, err := scanSensor(r.db.QueryRowContext(ctx, `
+		UPDATE sensors SET tags = $2 WHERE id = $1
+		RETURNING id, hardware_id, firmware_version, site, zone, tags, created_at
+	`, sensorID, pq.Array(tags)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store sensor tags: %w", err)
+	}
+	return sensor, nil
+}
+
+// TagSensors adds a tag to every sensor of sensorIDs that lacks it, in one
+// statement, and returns the IDs of the registered sensors that now carry
+// it. Sensors already carrying MaxSensorTags other tags are left out.
+func (r *Registry) TagSensors(ctx context.Context, tag string, sensorIDs []string) ([]string, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		WITH tagged AS (
+			UPDATE sensors SET tags = ARRAY(SELECT DISTINCT unnest(tags || $1::TEXT) ORDER BY 1)
+			WHERE id = ANY($2) AND NOT tags @> ARRAY[$1::TEXT] AND cardinality(tags) < $3
+			RETURNING id
+		)
+		SELECT id FROM tagged
+		UNION
+		SELECT id FROM sensors WHERE id = ANY($2) AND tags @> ARRAY[$1::TEXT]
+		ORDER BY 1
+	`, tag, pq.Array(sensorIDs), MaxSensorTags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to tag sensors: %w", err)
+	}
+	defer rows.Close()
+
+	tagged := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan tagged sensor: %w", err)
+		}
+		tagged = append(tagged, id)
+	}
+	return tagged, rows.Err()
+}
+
+// ListTags returns every tag carried by a sensor with the number of sensors
+// carrying it, ordered by tag
+func (r *Registry) ListTags(ctx context.Context) ([]*TagCount, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT tag, COUNT(*) FROM sensors, unnest(tags) AS tag
+		GROUP BY tag ORDER BY tag
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*TagCount
+	for rows.Next() {
+		var count TagCount
+		if err := rows.Scan(&count.Tag, &count.Sensors); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		result = append(result, &count)
+	}
+	return result, rows.Err()
+}
+
+// SensorTags returns the tags of every sensor that carries any, by sensor ID
+func (r *Registry) SensorTags(ctx context.Context) (map[string][]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, tags FROM sensors WHERE cardinality(tags) > 0`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sensor tags: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string][]string)
+	for rows.Next() {
+		var id string
+		var tags []string
+		if err := rows.Scan(&id, pq.Array(&tags)); err != nil {
+			return nil, fmt.Errorf("failed to scan sensor tags: %w", err)
+		}
+		result[id] = tags
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,305 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Threshold scopes
+const (
+	ThresholdScopeGlobal = "global"
+	ThresholdScopeTag    = "tag"
+	ThresholdScopeSensor = "sensor"
+)
+
+// Threshold change actions
+const (
+	ThresholdActionSet    = "set"
+	ThresholdActionDelete = "delete"
+)
+
+// Sane threshold ranges; readings outside them are physically implausible,
+// so a threshold beyond them is a typo rather than a tuning
+const (
+	thresholdTemperatureMin = -60
+	thresholdTemperatureMax = 150
+	thresholdPercentMin     = 0
+	thresholdPercentMax     = 100
+	thresholdRSSIMin        = -150
+	thresholdRSSIMax        = 0
+)
+
+// ErrInvalidThresholds is returned for thresholds outside their sane range
+var ErrInvalidThresholds = errors.New("invalid thresholds")
+
+// ThresholdValues are detector thresholds stored in the registry. A nil
+// threshold is inherited: a sensor's from the thresholds of its tags, or the
+// global thresholds when no tag sets it, the global ones from the detector's
+// configuration.
+type ThresholdValues struct {
+	MaxTemperature *float32 `json:"max_temperature,omitempty"`
+	MinHumidity    *float32 `json:"min_humidity,omitempty"`
+	MinBatteryPct  *float32 `json:"min_battery_pct,omitempty"`
+	MinRSSI        *int32   `json:"min_rssi,omitempty"`
+}
+
+// Validate checks each set threshold lies strictly between the bounds of its
+// quantity, so every threshold can both pass and fail some reading
+func (v ThresholdValues) Validate() error {
+	if v.MaxTemperature != nil && !(thresholdTemperatureMin < *v.MaxTemperature && *v.MaxTemperature < thresholdTemperatureMax) {
+		return fmt.Errorf("%w: max_temperature must be between %d and %d, got %v",
+			ErrInvalidThresholds, thresholdTemperatureMin, thresholdTemperatureMax, *v.MaxTemperature)
+	}
+	if v.MinHumidity != nil && !(thresholdPercentMin <= *v.MinHumidity && *v.MinHumidity < thresholdPercentMax) {
+		return fmt.Errorf("%w: min_humidity must be at least %d and below %d, got %v",
+			ErrInvalidThresholds, thresholdPercentMin, thresholdPercentMax, *v.MinHumidity)
+	}
+	if v.MinBatteryPct != nil && !(thresholdPercentMin <= *v.MinBatteryPct && *v.MinBatteryPct < thresholdPercentMax) {
+		return fmt.Errorf("%w: min_battery_pct must be at least %d and below %d, got %v",
+			ErrInvalidThresholds, thresholdPercentMin, thresholdPercentMax, *v.MinBatteryPct)
+	}
+	if v.MinRSSI != nil && !(thresholdRSSIMin < *v.MinRSSI && *v.MinRSSI <= thresholdRSSIMax) {
+		return fmt.Errorf("%w: min_rssi must be above %d and at most %d, got %d",
+			ErrInvalidThresholds, thresholdRSSIMin, thresholdRSSIMax, *v.MinRSSI)
+	}
+	return nil
+}
+
+// empty reports whether no threshold is set
+func (v ThresholdValues) empty() bool {
+	return v == ThresholdValues{}
+}
+
+// ThresholdSetting is the thresholds stored for the whole fleet (the global
+// scope, with an empty target), for the sensors carrying a tag, or for one
+// sensor
+type ThresholdSetting struct {
+	Scope  string `json:"scope"`
+	Target string `json:"target,omitempty"`
+	ThresholdValues
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ThresholdChange is an audit record of one change to stored thresholds
+type ThresholdChange struct {
+	ID        int64            `json:"id"`
+	Scope     string           `json:"scope"`
+	Target    string           `json:"target,omitempty"`
+	Action    string           `json:"action"`
+	Old       *ThresholdValues `json:"old,omitempty"`
+	New       *ThresholdValues `json:"new,omitempty"`
+	ChangedBy string           `json:"changed_by"`
+	Reason    string           `json:"reason,omitempty"`
+	ChangedAt time.Time        `json:"changed_at"`
+}
+
+// SetThresholds creates or replaces the thresholds of a scope and records the
+// change, made by actor for reason, in the same transaction
+func (r *Registry) SetThresholds(ctx context.Context, setting *ThresholdSetting, actor, reason string) error {
+	if setting.Scope != ThresholdScopeGlobal && setting.Scope != ThresholdScopeTag && setting.Scope != ThresholdScopeSensor {
+		return fmt.Errorf("unknown threshold scope: %s", setting.Scope)
+	}
+	if err := setting.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := loadThresholdsForUpdate(ctx, tx, setting.Scope, setting.Target)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	values := setting.ThresholdValues
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO thresholds (scope, target, max_temperature, min_humidity, min_battery_pct, min_rssi, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (scope, target) DO UPDATE
+		SET max_temperature = EXCLUDED.max_temperature, min_humidity = EXCLUDED.min_humidity,
+			min_battery_pct = EXCLUDED.min_battery_pct, min_rssi = EXCLUDED.min_rssi,
+			updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		RETURNING updated_at
+	`, setting.Scope, setting.Target, values.MaxTemperature, values.MinHumidity, values.MinBatteryPct, values.MinRSSI, actor,
+	).Scan(&setting.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store thresholds: %w", err)
+	}
+	setting.UpdatedBy = actor
+
+	if err := recordThresholdChange(ctx, tx, setting.Scope, setting.Target, ThresholdActionSet, old, &values, actor, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteThresholds removes the thresholds of a scope, so they are inherited
+// again, and records the change
+func (r *Registry) DeleteThresholds(ctx context.Context, scope, target, actor, reason string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := loadThresholdsForUpdate(ctx, tx, scope, target)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM thresholds WHERE scope = $1 AND target = $2`, scope, target); err != nil {
+		return fmt.Errorf("failed to delete thresholds: %w", err)
+	}
+
+	if err := recordThresholdChange(ctx, tx, scope, target, ThresholdActionDelete, old, nil, actor, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListThresholds returns every stored threshold setting, the global one
+// first, then those of tags and of sensors
+func (r *Registry) ListThresholds(ctx context.Context) ([]*ThresholdSetting, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT scope, target, max_temperature, min_humidity, min_battery_pct, min_rssi, updated_by, updated_at
+		FROM thresholds ORDER BY scope = 'global' DESC, scope = 'tag' DESC, target
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list thresholds: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*ThresholdSetting
+	for rows.Next() {
+		var setting ThresholdSetting
+		var maxTemperature, minHumidity, minBatteryPct sql.NullFloat64
+		var minRSSI sql.NullInt32
+		if err := rows.Scan(&setting.Scope, &setting.Target, &maxTemperature, &minHumidity, &minBatteryPct, &minRSSI,
+			&setting.UpdatedBy, &setting.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan thresholds: %w", err)
+		}
+		setting.ThresholdValues = thresholdValues(maxTemperature, minHumidity, minBatteryPct, minRSSI)
+		result = append(result, &setting)
+	}
+	return result, rows.Err()
+}
+
+// ListThresholdChanges returns the most recent changes to stored thresholds,
+// newest first, optionally only those of one scope and target
+func (r *Registry) ListThresholdChanges(ctx context.Context, scope, target string, limit int) ([]*ThresholdChange, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, scope, target, action, old_values, new_values, changed_by, reason, changed_at
+		FROM threshold_changes
+		WHERE ($1 = '' OR scope = $1) AND ($2 = '' OR target = $2)
+		ORDER BY id DESC LIMIT $3
+	`, scope, target, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list threshold changes: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*ThresholdChange
+	for rows.Next() {
+		var change ThresholdChange
+		var oldValues, newValues []byte
+		var reason sql.NullString
+		if err := rows.Scan(&change.ID, &change.Scope, &change.Target, &change.Action, &oldValues, &newValues,
+			&change.ChangedBy, &reason, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan threshold change: %w", err)
+		}
+		change.Reason = reason.String
+		if len(oldValues) > 0 {
+			if err := json.Unmarshal(oldValues, &change.Old); err != nil {
+				return nil, fmt.Errorf("failed to decode threshold change %d: %w", change.ID, err)
+			}
+		}
+		if len(newValues) > 0 {
+			if err := json.Unmarshal(newValues, &change.New); err != nil {
+				return nil, fmt.Errorf("failed to decode threshold change %d: %w", change.ID, err)
+			}
+		}
+		result = append(result, &change)
+	}
+	return result, rows.Err()
+}
+
+// loadThresholdsForUpdate locks and returns the stored thresholds of a scope,
+// or ErrNotFound
+func loadThresholdsForUpdate(ctx context.Context, tx *sql.Tx, scope, target string) (*ThresholdValues, error) {
+	var maxTemperature, minHumidity, minBatteryPct sql.NullFloat64
+	var minRSSI sql.NullInt32
+	err := tx.QueryRowContext(ctx, `
+		SELECT max_temperature, min_humidity, min_battery_pct, min_rssi FROM thresholds
+		WHERE scope = $1 AND target = $2
+		FOR UPDATE
+	`, scope, target).Scan(&maxTemperature, &minHumidity, &minBatteryPct, &minRSSI)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load thresholds: %w", err)
+	}
+	values := thresholdValues(maxTemperature, minHumidity, minBatteryPct, minRSSI)
+	return &values, nil
+}
+
+// recordThresholdChange appends a change to the threshold audit log
+func recordThresholdChange(ctx context.Context, tx *sql.Tx, scope, target, action string, oldValues, newValues *ThresholdValues, actor, reason string) error {
+	oldJSON, err := marshalThresholds(oldValues)
+	if err != nil {
+		return err
+	}
+	newJSON, err := marshalThresholds(newValues)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO threshold_changes (scope, target, action, old_values, new_values, changed_by, reason, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NOW())
+	`, scope, target, action, oldJSON, newJSON, actor, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record threshold change: %w", err)
+	}
+	return nil
+}
+
+// marshalThresholds encodes thresholds for the audit log; nil stays NULL
+func marshalThresholds(values *ThresholdValues) ([]byte, error) {
+	if values == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thresholds: %w", err)
+	}
+	return data, nil
+}
+
+// thresholdValues converts nullable threshold columns
+func thresholdValues(maxTemperature, minHumidity, minBatteryPct sql.NullFloat64, minRSSI sql.NullInt32) ThresholdValues {
+	var values ThresholdValues
+	if maxTemperature.Valid {
+		v := float32(maxTemperature.Float64)
+		values.MaxTemperature = &v
+	}
+	if minHumidity.Valid {
+		v := float32(minHumidity.Float64)
+		values.MinHumidity = &v
+	}
+	if minBatteryPct.Valid {
+		v := float32(minBatteryPct.Float64)
+		values.MinBatteryPct = &v
+	}
+	if minRSSI.Valid {
+		values.MinRSSI = &minRSSI.Int32
+	}
+	return values
+}
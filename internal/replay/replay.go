@@ -0,0 +1,166 @@
+// Package replay republishes archived readings from cold storage to a Kafka
+// topic, for backfilling a consumer or load testing with real traffic.
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// progressInterval is how many readings are replayed between progress logs
+const progressInterval = 10000
+
+// Source delivers archived readings timestamped in [from, to) in timestamp
+// order and returns the number of objects read; sink.ArchiveReader is one
+type Source interface {
+	Read(ctx context.Context, from, to time.Time, fn func(*model.SensorReading) error) (int, error)
+}
+
+// Config configures a replay
+type Config struct {
+	// From and To bound the reading timestamps replayed, To excluded
+	From time.Time
+	To   time.Time
+	// SensorID replays only the readings of this sensor (empty is every sensor)
+	SensorID string
+	// Speed paces the replay by the readings' timestamps: 1 sends them as
+	// far apart as they were taken, 2 twice as fast, and 0 as fast as the
+	// producer allows
+	Speed float64
+	// Limit stops after replaying this many readings (0 is unlimited)
+	Limit int
+	// DryRun reads the archive without sending anything
+	DryRun bool
+	// Logger receives the progress of the replay (optional)
+	Logger *slog.Logger
+}
+
+// Result counts what a replay did
+type Result struct {
+	Objects  int
+	Replayed int
+	// Skipped counts readings of other sensors than SensorID
+	Skipped int
+	// First and Last are the timestamps of the first and last reading replayed
+	First time.Time
+	Last  time.Time
+}
+
+// errLimitReached stops reading the archive once the limit is reached
+var errLimitReached = errors.New("replay limit reached")
+
+// Replayer reads archived readings and republishes them as JSON, with a new
+// trace ID each, keyed by sensor ID like the simulator's
+type Replayer struct {
+	config   Config
+	source   Source
+	producer *kafka.Producer
+
+	// start is when the first reading was sent, for pacing the others
+	start time.Time
+}
+
+// NewReplayer creates a replayer publishing with producer, which may be nil for dry runs
+func NewReplayer(config Config, source Source, producer *kafka.Producer) (*Replayer, error) {
+	if !config.To.After(config.From) {
+		return nil, fmt.Errorf("replay range must end after it starts, got %s to %s", config.From.Format(time.RFC3339), config.To.Format(time.RFC3339))
+	}
+	if config.Speed < 0 {
+		return nil, fmt.Errorf("speed must not be negative, got %g", config.Speed)
+	}
+	if producer == nil && !config.DryRun {
+		return nil, fmt.Errorf("a producer is required unless dry-running")
+	}
+
+	config.Logger = logging.OrDefault(config.Logger)
+
+	return &Replayer{config: config, source: source, producer: producer}, nil
+}
+
+// Run replays the range until it is done, ctx is done, or the limit is
+// reached. Readings sent before an interrupt stay sent.
+func (r *Replayer) Run(ctx context.Context) (Result, error) {
+	var result Result
+	objects, err := r.source.Read(ctx, r.config.From, r.config.To, func(reading *model.SensorReading) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if r.config.SensorID != "" && reading.ID != r.config.SensorID {
+			result.Skipped++
+			return nil
+		}
+		if err := r.replay(ctx, reading, &result); err != nil {
+			return err
+		}
+		if r.config.Limit > 0 && result.Replayed >= r.config.Limit {
+			return errLimitReached
+		}
+		return nil
+	})
+	result.Objects = objects
+	if errors.Is(err, errLimitReached) || (err != nil && ctx.Err() != nil) {
+		return result, nil
+	}
+	return result, err
+}
+
+// replay waits until a reading is due and sends it
+func (r *Replayer) replay(ctx context.Context, reading *model.SensorReading, result *Result) error {
+	ts := time.UnixMilli(reading.Timestamp)
+	if result.Replayed == 0 {
+		r.start = time.Now()
+		result.First = ts
+	} else if err := r.wait(ctx, ts.Sub(result.First)); err != nil {
+		return err
+	}
+
+	if !r.config.DryRun {
+		data, err := model.SerializeSensorReading(reading)
+		if err != nil {
+			return fmt.Errorf("failed to serialize reading of %s at %s: %w", reading.ID, ts.UTC().Format(time.RFC3339), err)
+		}
+		headers := []sarama.RecordHeader{
+			kafka.TraceIDHeader(kafka.NewTraceID()),
+			kafka.SchemaVersionHeader(model.SchemaVersion),
+			kafka.FormatHeader(model.FormatJSON),
+		}
+		if err := r.producer.SendMessageWithKey(ctx, reading.ID, data, headers...); err != nil {
+			return fmt.Errorf("failed to replay reading of %s at %s: %w", reading.ID, ts.UTC().Format(time.RFC3339), err)
+		}
+	}
+
+	result.Replayed++
+	result.Last = ts
+	if result.Replayed%progressInterval == 0 {
+		r.config.Logger.Info("Replay progress", "replayed", result.Replayed, "reading_time", ts.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// wait sleeps until a reading taken offset after the first one is due at the
+// configured speed; at full speed it returns at once
+func (r *Replayer) wait(ctx context.Context, offset time.Duration) error {
+	if r.config.Speed == 0 {
+		return nil
+	}
+	delay := time.Until(r.start.Add(time.Duration(float64(offset) / r.config.Speed)))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,52 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/db"
+)
+
+// ElasticsearchTarget expires the documents of an index by their ts field,
+// deleting them in batches with _delete_by_query
+type ElasticsearchTarget struct {
+	es        *db.ElasticsearchDB
+	index     string
+	batchSize int
+	timeout   time.Duration
+}
+
+// NewElasticsearchTarget creates a target deleting up to batchSize documents
+// of index per request, each bounded by timeout
+func NewElasticsearchTarget(es *db.ElasticsearchDB, index string, batchSize int, timeout time.Duration) *ElasticsearchTarget {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &ElasticsearchTarget{es: es, index: index, batchSize: batchSize, timeout: timeout}
+}
+
+// Count implements Target
+func (t *ElasticsearchTarget) Count(ctx context.Context, cutoff int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.es.CountBefore(ctx, t.index, cutoff)
+}
+
+// Purge implements Target
+func (t *ElasticsearchTarget) Purge(ctx context.Context, cutoff int64) (int64, error) {
+	var purged int64
+	for {
+		deleted, err := t.delete(ctx, cutoff)
+		purged += deleted
+		if err != nil || deleted < int64(t.batchSize) {
+			return purged, err
+		}
+	}
+}
+
+// delete deletes one batch of documents
+func (t *ElasticsearchTarget) delete(ctx context.Context, cutoff int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.es.DeleteBefore(ctx, t.index, cutoff, t.batchSize)
+}
@@ -0,0 +1,119 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Table is a PostgreSQL table whose rows expire by a column of Unix
+// milliseconds
+type Table struct {
+	// Name is the table name
+	Name string
+	// TimeColumn holds the time rows are aged by
+	TimeColumn string
+	// KeyColumns is the primary key, which batches of rows are deleted by
+	KeyColumns []string
+	// ArchivedOnly keeps rows newer than the newest reading in the cold
+	// archive catalog, so only archived readings are purged
+	ArchivedOnly bool
+}
+
+// Tables whose rows can expire
+var (
+	ReadingsTable = Table{Name: "sensor_readings", TimeColumn: "ts", KeyColumns: []string{"sensor_id", "ts"}}
+	AlertsTable   = Table{Name: "sensor_alerts", TimeColumn: "ts", KeyColumns: []string{"sensor_id", "ts"}}
+	// Aggregates expire once their whole window is older than the TTL
+	AggregatesTable = Table{Name: "sensor_aggregates", TimeColumn: "window_end", KeyColumns: []string{"sensor_id", "window_start"}}
+)
+
+// PostgresTarget expires the rows of a table in batches, each deleted in its
+// own statement so no lock is held for the whole purge
+type PostgresTarget struct {
+	db        *sql.DB
+	table     Table
+	batchSize int
+	timeout   time.Duration
+}
+
+// NewPostgresTarget creates a target deleting up to batchSize rows of table
+// per statement, each bounded by timeout
+func NewPostgresTarget(db *sql.DB, table Table, batchSize int, timeout time.Duration) *PostgresTarget {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &PostgresTarget{db: db, table: table, batchSize: batchSize, timeout: timeout}
+}
+
+// Count implements Target
+func (t *PostgresTarget) Count(ctx context.Context, cutoff int64) (int64, error) {
+	cutoff, err := t.cutoff(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	var count int64
+	err = t.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM `+t.table.Name+` WHERE `+t.table.TimeColumn+` < $1`, cutoff).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired rows of %s: %w", t.table.Name, err)
+	}
+	return count, nil
+}
+
+// Purge implements Target
+func (t *PostgresTarget) Purge(ctx context.Context, cutoff int64) (int64, error) {
+	cutoff, err := t.cutoff(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := strings.Join(t.table.KeyColumns, ", ")
+	query := `DELETE FROM ` + t.table.Name + ` WHERE (` + keys + `) IN (
+		SELECT ` + keys + ` FROM ` + t.table.Name + ` WHERE ` + t.table.TimeColumn + ` < $1 LIMIT $2)`
+
+	var purged int64
+	for {
+		deleted, err := t.delete(ctx, query, cutoff)
+		purged += deleted
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge expired rows of %s: %w", t.table.Name, err)
+		}
+		if deleted < int64(t.batchSize) {
+			return purged, nil
+		}
+	}
+}
+
+// delete deletes one batch of rows
+func (t *PostgresTarget) delete(ctx context.Context, query string, cutoff int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	result, err := t.db.ExecContext(ctx, query, cutoff, t.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// cutoff lowers a cutoff to just after the newest archived reading when only
+// archived rows may be purged
+func (t *PostgresTarget) cutoff(ctx context.Context, cutoff int64) (int64, error) {
+	if !t.table.ArchivedOnly {
+		return cutoff, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	var archived int64
+	err := t.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(max_ts) + 1, 0) FROM archive_catalog`).Scan(&archived)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archived time: %w", err)
+	}
+	return min(cutoff, archived), nil
+}
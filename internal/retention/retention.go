@@ -0,0 +1,172 @@
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Retention targets, used as the target label of the metrics
+const (
+	TargetReadings   = "readings"
+	TargetAlerts     = "alerts"
+	TargetAggregates = "aggregates"
+	TargetESReadings = "es_readings"
+	TargetESAlerts   = "es_alerts"
+)
+
+// Metrics holds Prometheus metrics for the retention jobs
+type Metrics struct {
+	Purged      *prometheus.CounterVec
+	Expired     *prometheus.GaugeVec
+	Errors      *prometheus.CounterVec
+	LastSuccess *prometheus.GaugeVec
+	RunTime     *prometheus.HistogramVec
+}
+
+// NewMetrics creates a new set of retention metrics
+func NewMetrics(namespace, subsystem string, registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		Purged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "purged_total",
+			Help:      "Total number of rows and documents deleted for being older than their TTL, by target",
+		}, []string{"target"}),
+		Expired: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "expired",
+			Help:      "Rows and documents older than their TTL found by the last run, by target; in dry-run mode they are kept",
+		}, []string{"target"}),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Total number of failed retention runs, by target",
+		}, []string{"target"}),
+		LastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful retention run, by target",
+		}, []string{"target"}),
+		RunTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "run_duration_seconds",
+			Help:      "Time taken by a retention run, by target, in seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"target"}),
+	}
+
+	registry.MustRegister(
+		metrics.Purged,
+		metrics.Expired,
+		metrics.Errors,
+		metrics.LastSuccess,
+		metrics.RunTime,
+	)
+
+	return metrics
+}
+
+// Target is a store of timestamped rows or documents that expire
+type Target interface {
+	// Count returns how many rows or documents are older than cutoff, in
+	// Unix milliseconds
+	Count(ctx context.Context, cutoff int64) (int64, error)
+	// Purge deletes the rows or documents older than cutoff and returns how
+	// many were deleted
+	Purge(ctx context.Context, cutoff int64) (int64, error)
+}
+
+// Job expires the rows or documents of a target once they are older than TTL
+type Job struct {
+	Name   string
+	TTL    time.Duration
+	Target Target
+}
+
+// PurgerConfig configures a purger
+type PurgerConfig struct {
+	// DryRun counts what would be purged instead of deleting it
+	DryRun bool
+	// Logger receives the outcome of every run (optional)
+	Logger *slog.Logger
+}
+
+// Purger runs retention jobs one after another. A failing job is logged and
+// retried at the next run without holding up the others.
+type Purger struct {
+	jobs    []Job
+	config  PurgerConfig
+	metrics *Metrics
+	logger  *slog.Logger
+}
+
+// NewPurger creates a new purger; metrics may be nil
+func NewPurger(jobs []Job, config PurgerConfig, metrics *Metrics) *Purger {
+	return &Purger{
+		jobs:    jobs,
+		config:  config,
+		metrics: metrics,
+		logger:  logging.OrDefault(config.Logger),
+	}
+}
+
+// Run runs every job once and reports whether all of them succeeded
+func (p *Purger) Run(ctx context.Context) bool {
+	ok := true
+	for _, job := range p.jobs {
+		if ctx.Err() != nil {
+			return false
+		}
+		if err := p.run(ctx, job); err != nil {
+			p.logger.Error("Retention run failed", "target", job.Name, "ttl", job.TTL, "error", err)
+			if p.metrics != nil {
+				p.metrics.Errors.WithLabelValues(job.Name).Inc()
+			}
+			ok = false
+		}
+	}
+	return ok
+}
+
+// run expires the rows or documents of one job older than its TTL
+func (p *Purger) run(ctx context.Context, job Job) error {
+	start := time.Now()
+	cutoff := start.Add(-job.TTL).UnixMilli()
+
+	var count int64
+	var err error
+	if p.config.DryRun {
+		count, err = job.Target.Count(ctx, cutoff)
+	} else {
+		count, err = job.Target.Purge(ctx, cutoff)
+	}
+	if p.metrics != nil && !p.config.DryRun {
+		// Rows deleted before a failure are gone all the same
+		p.metrics.Purged.WithLabelValues(job.Name).Add(float64(count))
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.metrics != nil {
+		p.metrics.Expired.WithLabelValues(job.Name).Set(float64(count))
+		p.metrics.LastSuccess.WithLabelValues(job.Name).Set(float64(time.Now().Unix()))
+		p.metrics.RunTime.WithLabelValues(job.Name).Observe(time.Since(start).Seconds())
+	}
+	if p.config.DryRun {
+		p.logger.Info("Dry run found expired data", "target", job.Name, "ttl", job.TTL,
+			"cutoff", time.UnixMilli(cutoff).UTC(), "expired", count)
+	} else {
+		p.logger.Info("Purged expired data", "target", job.Name, "ttl", job.TTL,
+			"cutoff", time.UnixMilli(cutoff).UTC(), "purged", count, "duration", time.Since(start))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// purgeJob is the scheduler job running the retention jobs
+const purgeJob = "retention-purge"
+
+// Service runs the retention jobs of the configured TTLs
+type Service struct {
+	Purger    *Purger
+	postgres  *db.PostgresDB
+	scheduler *runtime.Scheduler
+	logger    *slog.Logger
+}
+
+// NewService creates a job for every store with a TTL. Metrics are
+// registered on registry.
+func NewService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "retention")
+
+	readings := ReadingsTable
+	readings.ArchivedOnly = cfg.RetentionRequireArchive
+	tables := []struct {
+		name  string
+		ttl   time.Duration
+		table Table
+	}{
+		{TargetReadings, cfg.RetentionReadingsTTL, readings},
+		{TargetAlerts, cfg.RetentionAlertsTTL, AlertsTable},
+		{TargetAggregates, cfg.RetentionAggregatesTTL, AggregatesTable},
+	}
+
+	// PostgreSQL also elects the replica purging, so it is needed even when
+	// only documents expire
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect retention database: %w", err)
+	}
+
+	var jobs []Job
+	for _, t := range tables {
+		if t.ttl <= 0 {
+			continue
+		}
+		target := NewPostgresTarget(postgres.DB(), t.table, cfg.RetentionBatchSize, cfg.RetentionTimeout)
+		jobs = append(jobs, Job{Name: t.name, TTL: t.ttl, Target: target})
+	}
+
+	es := db.NewElasticsearchDB(cfg)
+	if cfg.RetentionESReadingsTTL > 0 {
+		target := NewElasticsearchTarget(es, es.Index(), cfg.RetentionBatchSize, cfg.RetentionTimeout)
+		jobs = append(jobs, Job{Name: TargetESReadings, TTL: cfg.RetentionESReadingsTTL, Target: target})
+	}
+	if cfg.RetentionESAlertsTTL > 0 {
+		target := NewElasticsearchTarget(es, es.AlertIndex(), cfg.RetentionBatchSize, cfg.RetentionTimeout)
+		jobs = append(jobs, Job{Name: TargetESAlerts, TTL: cfg.RetentionESAlertsTTL, Target: target})
+	}
+	if len(jobs) == 0 {
+		logger.Warn("No retention TTL is set; nothing will be purged")
+	}
+
+	s := &Service{
+		Purger: NewPurger(jobs, PurgerConfig{
+			DryRun: cfg.RetentionDryRun,
+			Logger: logger,
+		}, NewMetrics("iot", "retention", registry)),
+		postgres:  postgres,
+		scheduler: runtime.NewScheduler(runtime.NewSchedulerMetrics("iot", "retention", registry), logger),
+		logger:    logger,
+	}
+
+	// Purges run on one replica only
+	leader := runtime.NewLeaderElector(postgres.DB(), purgeJob, runtime.DefaultLeaderCheckInterval,
+		runtime.NewLeaderMetrics("iot", "retention", registry), logger)
+	if err := s.scheduler.Add(runtime.Job{
+		Name:     purgeJob,
+		Schedule: "@every " + cfg.RetentionInterval.String(),
+		Leader:   leader,
+		Run: func(ctx context.Context) error {
+			if !s.Purger.Run(ctx) {
+				return fmt.Errorf("a retention job failed")
+			}
+			return nil
+		},
+	}); err != nil {
+		postgres.Close()
+		return nil, fmt.Errorf("failed to schedule retention: %w", err)
+	}
+
+	return s, nil
+}
+
+// Start runs the jobs every RETENTION_INTERVAL on the elected replica
+func (s *Service) Start() {
+	s.scheduler.Start()
+}
+
+// RunOnce runs every job once and reports whether all of them succeeded
+func (s *Service) RunOnce(ctx context.Context) bool {
+	return s.Purger.Run(ctx)
+}
+
+// Stop stops the jobs and closes the database connection
+func (s *Service) Stop() {
+	s.scheduler.Stop()
+	if err := s.postgres.Close(); err != nil {
+		s.logger.Error("Failed to close retention database", "error", err)
+	}
+}
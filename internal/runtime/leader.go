@@ -0,0 +1,249 @@
+package runtime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultLeaderCheckInterval is how often leadership is re-checked when no interval is given
+const DefaultLeaderCheckInterval = 10 * time.Second
+
+// LeaderMetrics holds Prometheus metrics for leader election
+type LeaderMetrics struct {
+	IsLeader        *prometheus.GaugeVec
+	Transitions     *prometheus.CounterVec
+	AcquireAttempts *prometheus.CounterVec
+	AcquireErrors   *prometheus.CounterVec
+}
+
+// NewLeaderMetrics creates a new set of leader election metrics
+func NewLeaderMetrics(namespace, subsystem string, registry prometheus.Registerer) *LeaderMetrics {
+	metrics := &LeaderMetrics{
+		IsLeader: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "is_leader",
+			Help:      "Whether this replica currently holds leadership for the job (1 or 0)",
+		}, []string{"job"}),
+		Transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "transitions_total",
+			Help:      "Total number of leadership transitions by direction",
+		}, []string{"job", "direction"}),
+		AcquireAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "acquire_attempts_total",
+			Help:      "Total number of attempts to acquire leadership",
+		}, []string{"job"}),
+		AcquireErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "acquire_errors_total",
+			Help:      "Total number of errors while acquiring or checking leadership",
+		}, []string{"job"}),
+	}
+
+	registry.MustRegister(
+		metrics.IsLeader,
+		metrics.Transitions,
+		metrics.AcquireAttempts,
+		metrics.AcquireErrors,
+	)
+
+	return metrics
+}
+
+// LeaderElector elects a single leader among replicas using a PostgreSQL
+// session-level advisory lock. The lock is held on a dedicated connection, so
+// leadership is released automatically if the process or connection dies.
+type LeaderElector struct {
+	db       *sql.DB
+	name     string
+	lockKey  int64
+	interval time.Duration
+	metrics  *LeaderMetrics
+	logger   *slog.Logger
+
+	leader atomic.Bool
+	mu     sync.Mutex
+	conn   *sql.Conn
+}
+
+// NewLeaderElector creates a leader elector for the named job; metrics and
+// logger may be nil
+func NewLeaderElector(db *sql.DB, name string, interval time.Duration, metrics *LeaderMetrics, logger *slog.Logger) *LeaderElector {
+	if interval <= 0 {
+		interval = DefaultLeaderCheckInterval
+	}
+	return &LeaderElector{
+		db:       db,
+		name:     name,
+		lockKey:  LockKey(name),
+		interval: interval,
+		metrics:  metrics,
+		logger:   logging.OrDefault(logger).With("job", name),
+	}
+}
+
+// LockKey derives the advisory lock key for a job name
+func LockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("iot-sensor-fleet/" + name))
+	return int64(h.Sum64())
+}
+
+// IsLeader reports whether this replica currently holds leadership
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns for leadership until ctx is cancelled. Each time leadership is
+// acquired, onElected is called with a context that is cancelled when leadership
+// is lost; Run waits for onElected to return before campaigning again.
+func (e *LeaderElector) Run(ctx context.Context, onElected func(ctx context.Context)) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := e.tryAcquire(ctx)
+		if err != nil {
+			e.logger.Warn("Leader election failed", "error", err)
+			if e.metrics != nil {
+				e.metrics.AcquireErrors.WithLabelValues(e.name).Inc()
+			}
+		}
+
+		if acquired {
+			e.lead(ctx, onElected)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead runs onElected while periodically verifying the lock is still held
+func (e *LeaderElector) lead(ctx context.Context, onElected func(ctx context.Context)) {
+	e.setLeader(true)
+	e.logger.Info("Acquired leadership")
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		onElected(leaderCtx)
+	}()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-done:
+			break loop
+		case <-ticker.C:
+			if err := e.checkConn(ctx); err != nil {
+				e.logger.Warn("Lost leadership", "error", err)
+				if e.metrics != nil {
+					e.metrics.AcquireErrors.WithLabelValues(e.name).Inc()
+				}
+				break loop
+			}
+		}
+	}
+
+	cancel()
+	<-done
+	e.release()
+	e.setLeader(false)
+	e.logger.Info("Released leadership")
+}
+
+// tryAcquire attempts to take the advisory lock on a dedicated connection
+func (e *LeaderElector) tryAcquire(ctx context.Context) (bool, error) {
+	if e.metrics != nil {
+		e.metrics.AcquireAttempts.WithLabelValues(e.name).Inc()
+	}
+
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.lockKey).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+
+	e.mu.Lock()
+	e.conn = conn
+	e.mu.Unlock()
+	return true, nil
+}
+
+// checkConn verifies the connection holding the lock is still alive
+func (e *LeaderElector) checkConn(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		return fmt.Errorf("no lock connection")
+	}
+	return e.conn.PingContext(ctx)
+}
+
+// release unlocks and returns the dedicated connection
+func (e *LeaderElector) release() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := e.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", e.lockKey); err != nil {
+		e.logger.Error("Failed to release advisory lock", "error", err)
+	}
+	e.conn.Close()
+	e.conn = nil
+}
+
+// setLeader records leadership state and metrics
+func (e *LeaderElector) setLeader(leader bool) {
+	e.leader.Store(leader)
+	if e.metrics == nil {
+		return
+	}
+
+	if leader {
+		e.metrics.IsLeader.WithLabelValues(e.name).Set(1)
+		e.metrics.Transitions.WithLabelValues(e.name, "acquired").Inc()
+	} else {
+		e.metrics.IsLeader.WithLabelValues(e.name).Set(0)
+		e.metrics.Transitions.WithLabelValues(e.name, "lost").Inc()
+	}
+}
@@ -0,0 +1,370 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Skip reasons reported in the skipped_total metric
+const (
+	SkipReasonOverlap   = "overlap"
+	SkipReasonNotLeader = "not_leader"
+)
+
+// Job is a unit of scheduled work
+type Job struct {
+	// Name identifies the job in metrics, logs and the status endpoint
+	Name string
+	// Schedule is a cron expression or @every/@daily style descriptor
+	Schedule string
+	// Jitter delays each run by a random duration up to this value
+	Jitter time.Duration
+	// Timeout bounds a single run; zero means no timeout
+	Timeout time.Duration
+	// Leader restricts runs to the replica holding leadership; nil runs
+	// everywhere. The scheduler campaigns for it while started.
+	Leader *LeaderElector
+	// Run performs the work
+	Run func(ctx context.Context) error
+}
+
+// JobStatus describes the state of a scheduled job
+type JobStatus struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	Running      bool       `json:"running"`
+	NextRun      time.Time  `json:"next_run"`
+	LastStart    *time.Time `json:"last_start,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	Runs         int64      `json:"runs"`
+	Failures     int64      `json:"failures"`
+	Skipped      int64      `json:"skipped"`
+}
+
+// SchedulerMetrics holds Prometheus metrics for scheduled jobs
+type SchedulerMetrics struct {
+	Runs          *prometheus.CounterVec
+	Skipped       *prometheus.CounterVec
+	Duration      *prometheus.HistogramVec
+	Running       *prometheus.GaugeVec
+	LastSuccessTS *prometheus.GaugeVec
+}
+
+// NewSchedulerMetrics creates a new set of scheduler metrics
+func NewSchedulerMetrics(namespace, subsystem string, registry prometheus.Registerer) *SchedulerMetrics {
+	metrics := &SchedulerMetrics{
+		Runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "job_runs_total",
+			Help:      "Total number of job runs by result",
+		}, []string{"job", "result"}),
+		Skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "job_skipped_total",
+			Help:      "Total number of skipped job activations by reason",
+		}, []string{"job", "reason"}),
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "job_duration_seconds",
+			Help:      "Duration of job runs in seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"job"}),
+		Running: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "job_running",
+			Help:      "Whether the job is currently running (1 or 0)",
+		}, []string{"job"}),
+		LastSuccessTS: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "job_last_success_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful run",
+		}, []string{"job"}),
+	}
+
+	registry.MustRegister(
+		metrics.Runs,
+		metrics.Skipped,
+		metrics.Duration,
+		metrics.Running,
+		metrics.LastSuccessTS,
+	)
+
+	return metrics
+}
+
+// scheduledJob tracks the runtime state of a job
+type scheduledJob struct {
+	job      Job
+	schedule Schedule
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// Scheduler runs jobs on cron schedules, never overlapping runs of the same job
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []*scheduledJob
+	metrics *SchedulerMetrics
+	logger  *slog.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+
+	// campaigning holds the leader electors of the jobs already campaigning,
+	// so jobs sharing one campaign once
+	campaigning map[*LeaderElector]bool
+}
+
+// NewScheduler creates a new scheduler; metrics and logger may be nil
+func NewScheduler(metrics *SchedulerMetrics, logger *slog.Logger) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		metrics:     metrics,
+		logger:      logging.OrDefault(logger),
+		ctx:         ctx,
+		cancel:      cancel,
+		campaigning: make(map[*LeaderElector]bool),
+	}
+}
+
+// Add registers a job; jobs added after Start begin running immediately
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Run == nil {
+		return fmt.Errorf("job requires a name and a run function")
+	}
+
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("invalid schedule for job %s: %w", job.Name, err)
+	}
+
+	sj := &scheduledJob{
+		job:      job,
+		schedule: schedule,
+		status: JobStatus{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+		},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.jobs {
+		if existing.job.Name == job.Name {
+			return fmt.Errorf("job %s already registered", job.Name)
+		}
+	}
+	s.jobs = append(s.jobs, sj)
+
+	if s.started {
+		s.campaign(job.Leader)
+		s.wg.Add(1)
+		go s.run(sj)
+	}
+	return nil
+}
+
+// Start starts running all registered jobs
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for _, sj := range s.jobs {
+		s.campaign(sj.job.Leader)
+		s.wg.Add(1)
+		go s.run(sj)
+	}
+}
+
+// campaign runs a job's leader election until the scheduler stops; s.mu
+// must be held
+func (s *Scheduler) campaign(leader *LeaderElector) {
+	if leader == nil || s.campaigning[leader] {
+		return
+	}
+	s.campaigning[leader] = true
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		// Leadership is held until lost or the scheduler stops
+		leader.Run(s.ctx, func(ctx context.Context) { <-ctx.Done() })
+	}()
+}
+
+// Stop cancels running jobs, waits for them to return and releases
+// leadership
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// run is the activation loop of a single job
+func (s *Scheduler) run(sj *scheduledJob) {
+	defer s.wg.Done()
+
+	next := sj.schedule.Next(time.Now())
+	for {
+		if next.IsZero() {
+			s.logger.Warn("Job has no future activations, stopping", "job", sj.job.Name)
+			return
+		}
+
+		delay := time.Until(next)
+		if sj.job.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(sj.job.Jitter)))
+		}
+
+		sj.mu.Lock()
+		sj.status.NextRun = next
+		sj.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.execute(sj)
+
+		// Activations that elapsed while the job was running are skipped rather than queued
+		now := time.Now()
+		planned := next
+		next = sj.schedule.Next(planned)
+		for !next.IsZero() && !next.After(now) {
+			s.skip(sj, SkipReasonOverlap)
+			next = sj.schedule.Next(next)
+		}
+	}
+}
+
+// execute performs a single run of a job
+func (s *Scheduler) execute(sj *scheduledJob) {
+	name := sj.job.Name
+
+	if sj.job.Leader != nil && !sj.job.Leader.IsLeader() {
+		s.skip(sj, SkipReasonNotLeader)
+		return
+	}
+
+	ctx := s.ctx
+	if sj.job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sj.job.Timeout)
+		defer cancel()
+	}
+
+	startTime := time.Now()
+	sj.mu.Lock()
+	sj.status.Running = true
+	sj.status.LastStart = &startTime
+	sj.mu.Unlock()
+	if s.metrics != nil {
+		s.metrics.Running.WithLabelValues(name).Set(1)
+	}
+
+	err := runSafely(ctx, sj.job.Run)
+	duration := time.Since(startTime)
+
+	sj.mu.Lock()
+	sj.status.Running = false
+	sj.status.Runs++
+	sj.status.LastDuration = duration.String()
+	if err != nil {
+		sj.status.Failures++
+		sj.status.LastError = err.Error()
+	} else {
+		endTime := time.Now()
+		sj.status.LastError = ""
+		sj.status.LastSuccess = &endTime
+	}
+	sj.mu.Unlock()
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+		s.logger.Error("Job failed", "job", name, "duration", duration, "error", err)
+	}
+
+	if s.metrics != nil {
+		s.metrics.Running.WithLabelValues(name).Set(0)
+		s.metrics.Runs.WithLabelValues(name, result).Inc()
+		s.metrics.Duration.WithLabelValues(name).Observe(duration.Seconds())
+		if err == nil {
+			s.metrics.LastSuccessTS.WithLabelValues(name).Set(float64(time.Now().Unix()))
+		}
+	}
+}
+
+// skip records a skipped activation
+func (s *Scheduler) skip(sj *scheduledJob, reason string) {
+	sj.mu.Lock()
+	sj.status.Skipped++
+	sj.mu.Unlock()
+	if s.metrics != nil {
+		s.metrics.Skipped.WithLabelValues(sj.job.Name, reason).Inc()
+	}
+}
+
+// runSafely runs a job, converting panics into errors
+func runSafely(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+// Status returns a snapshot of every job's status, sorted by name
+func (s *Scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	jobs := append([]*scheduledJob(nil), s.jobs...)
+	s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, sj := range jobs {
+		sj.mu.Lock()
+		statuses = append(statuses, sj.status)
+		sj.mu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// StatusHandler serves the job statuses as JSON
+func (s *Scheduler) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.Status()); err != nil {
+			s.logger.Warn("Failed to encode job status", "error", err)
+		}
+	})
+}
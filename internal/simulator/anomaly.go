@@ -0,0 +1,179 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Anomaly types the simulator can inject
+const (
+	// AnomalyOverTemperature sends a reading above the default temperature threshold
+	AnomalyOverTemperature = "over_temperature"
+	// AnomalyLowHumidity sends a reading below the default humidity threshold
+	AnomalyLowHumidity = "low_humidity"
+	// AnomalyLowBattery sends a reading below the default battery threshold
+	AnomalyLowBattery = "low_battery"
+	// AnomalyWeakSignal sends a reading below the default signal strength threshold
+	AnomalyWeakSignal = "weak_signal"
+	// AnomalyNullPayload sends a message with a null value
+	AnomalyNullPayload = "null_payload"
+	// AnomalyCorruptAvro sends a truncated Avro encoding of the reading
+	AnomalyCorruptAvro = "corrupt_avro"
+	// AnomalyWrongSchemaVersion sends the reading in the Avro encoding of a
+	// newer schema version than the pipeline knows, as firmware updated
+	// ahead of the consumers would
+	AnomalyWrongSchemaVersion = "wrong_schema_version"
+	// AnomalyGarbageBytes sends random bytes that no format decodes
+	AnomalyGarbageBytes = "garbage_bytes"
+)
+
+// AnomalyTypes lists every anomaly type in the order they are documented
+var AnomalyTypes = []string{
+	AnomalyOverTemperature, AnomalyLowHumidity, AnomalyLowBattery, AnomalyWeakSignal,
+	AnomalyNullPayload, AnomalyCorruptAvro, AnomalyWrongSchemaVersion, AnomalyGarbageBytes,
+}
+
+// AnomalyMetrics holds Prometheus metrics for injected anomalies
+type AnomalyMetrics struct {
+	Injected *prometheus.CounterVec
+}
+
+// NewAnomalyMetrics creates a new set of anomaly injection metrics
+func NewAnomalyMetrics(namespace, subsystem string, registry prometheus.Registerer) *AnomalyMetrics {
+	metrics := &AnomalyMetrics{
+		Injected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "anomalies_injected_total",
+			Help:      "Total number of readings sent with an injected anomaly by type",
+		}, []string{"type"}),
+	}
+
+	registry.MustRegister(metrics.Injected)
+
+	return metrics
+}
+
+// AnomalyInjector replaces a fraction of the readings sensors send with
+// anomalies of the configured types, chosen uniformly, and counts those sent.
+// Comparing the counts with the detector's alerts and dead-lettered messages
+// verifies that every injected anomaly was caught.
+type AnomalyInjector struct {
+	rate    float64
+	types   []string
+	metrics *AnomalyMetrics
+}
+
+// NewAnomalyInjector creates an injector that makes each reading an anomaly
+// with probability rate. metrics may be nil.
+func NewAnomalyInjector(rate float64, types []string, metrics *AnomalyMetrics) (*AnomalyInjector, error) {
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("rate %g is not a probability", rate)
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no anomaly types")
+	}
+	if metrics != nil {
+		for _, anomaly := range types {
+			metrics.Injected.WithLabelValues(anomaly)
+		}
+	}
+	return &AnomalyInjector{rate: rate, types: types, metrics: metrics}, nil
+}
+
+// ParseAnomalyTypes parses a comma-separated list of anomaly types
+func ParseAnomalyTypes(spec string) ([]string, error) {
+	var types []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, anomaly := range AnomalyTypes {
+			known = known || name == anomaly
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown anomaly type %q: expected %s", name, strings.Join(AnomalyTypes, ", "))
+		}
+		types = append(types, name)
+	}
+	return types, nil
+}
+
+// next picks the anomaly of the next reading, or "" for a normal one
+func (a *AnomalyInjector) next() string {
+	if a == nil || rand.Float64() >= a.rate {
+		return ""
+	}
+	return a.types[rand.Intn(len(a.types))]
+}
+
+// injectReading changes a reading's values for anomalies carried in the values
+func injectReading(anomaly string, reading *model.SensorReading) {
+	switch anomaly {
+	case AnomalyOverTemperature:
+		reading.Temperature = model.DefaultMaxTemperature + 5 + rand.Float32()*20
+	case AnomalyLowHumidity:
+		reading.Humidity = rand.Float32() * model.DefaultMinHumidity * 0.8
+	case AnomalyLowBattery:
+		battery := rand.Float32() * model.DefaultMinBatteryPct * 0.8
+		reading.BatteryPct = &battery
+	case AnomalyWeakSignal:
+		rssi := model.DefaultMinRSSI - 5 - rand.Int31n(20)
+		reading.RSSI = &rssi
+	}
+}
+
+// injectPayload replaces the encoded reading for anomalies in the payload
+func injectPayload(anomaly string, reading *model.SensorReading, data []byte) ([]byte, error) {
+	switch anomaly {
+	case AnomalyNullPayload:
+		return nil, nil
+	case AnomalyCorruptAvro:
+		avro, err := model.SerializeSensorReadingAvro(reading)
+		if err != nil {
+			return nil, err
+		}
+		// Cutting the record short leaves a string or float unfinished
+		return avro[:len(avro)/2], nil
+	case AnomalyWrongSchemaVersion:
+		avro, err := model.SerializeSensorReadingAvro(reading)
+		if err != nil {
+			return nil, err
+		}
+		// A field appended by the next version is left over after the
+		// fields this version decodes
+		return binary.AppendVarint(avro, rand.Int63()), nil
+	case AnomalyGarbageBytes:
+		garbage := make([]byte, 16+rand.Intn(48))
+		rand.Read(garbage)
+		// A leading 0x01 is a negative Avro string length and neither a JSON
+		// object nor the Confluent magic byte, so no format can decode it
+		garbage[0] = 0x01
+		return garbage, nil
+	}
+	return data, nil
+}
+
+// injectHeaders returns the wire format and schema version declared in the
+// headers of a message carrying an anomaly, given those of the sensor
+func injectHeaders(anomaly, format string, version int) (string, int) {
+	if anomaly == AnomalyWrongSchemaVersion {
+		return model.FormatAvro, version + 1
+	}
+	return format, version
+}
+
+// record counts an anomaly that was sent
+func (a *AnomalyInjector) record(anomaly string) {
+	if a == nil || anomaly == "" || a.metrics == nil {
+		return
+	}
+	a.metrics.Injected.WithLabelValues(anomaly).Inc()
+}
@@ -0,0 +1,44 @@
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Battery and radio behaviour of virtual sensors. Batteries start between
+// 40 and 100 % and drain slowly, and signal strength stays well above the
+// detector's default limits, so a healthy fleet raises no battery or signal
+// alerts unless they are injected.
+const (
+	batteryDrainPerHour = 1.0
+	signalNoise         = 3.0
+)
+
+// deviceState is the battery and radio of a sensor, which outlive the
+// profile the sensor follows
+type deviceState struct {
+	battery float64
+	signal  float64
+	last    time.Time
+}
+
+func newDeviceState(start time.Time) *deviceState {
+	return &deviceState{
+		battery: 40 + rand.Float64()*60,
+		signal:  -75 + rand.Float64()*20,
+		last:    start,
+	}
+}
+
+// next returns the battery level in % and signal strength in dBm at at. An
+// empty battery is replaced by a full one.
+func (d *deviceState) next(at time.Time) (float32, int32) {
+	d.battery -= batteryDrainPerHour * at.Sub(d.last).Hours()
+	d.last = at
+	if d.battery <= 0 {
+		d.battery = 100
+	}
+	rssi := math.Round(d.signal + rand.NormFloat64()*signalNoise)
+	return float32(d.battery), int32(rssi)
+}
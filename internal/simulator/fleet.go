@@ -0,0 +1,292 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	sensorregistry "github.com/example/iot-sensor-fleet/internal/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SimulatorClientID names the simulator's producer in the client ID header
+const SimulatorClientID = "iot-simulator"
+
+// Fleet runs a set of virtual sensors sharing one Kafka producer
+type Fleet struct {
+	producer *kafka.Producer
+	metrics  *metrics.SensorProducerMetrics
+	sensors  []*Sensor
+	rotator  *CredentialRotator
+	schedule *Scheduler
+	logger   *slog.Logger
+	wg       sync.WaitGroup
+
+	// scenario is played against the sensors once they start (optional)
+	scenario        *Scenario
+	scenarioMetrics *ScenarioMetrics
+
+	// shutdownTimeout bounds draining the producer on Stop
+	shutdownTimeout time.Duration
+
+	// partitionSites places readings by site with PARTITION_BY_SITE
+	partitionSites *sensorregistry.PartitionSiteCache
+
+	// ctx is cancelled on Stop once the producer has drained
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewFleet creates the virtual sensors and their producer from configuration.
+// Metrics are registered on registry.
+func NewFleet(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (_ *Fleet, err error) {
+	logger = logging.OrDefault(logger)
+
+	// Send readings to the partitions the site-affinity strategy gives their site
+	var partitionSites *sensorregistry.PartitionSiteCache
+	var sites kafka.PartitionSitesFunc
+	if cfg.PartitionBySite {
+		cache, err := sensorregistry.NewPartitionSiteCacheFromConfig(cfg, logger)
+		switch {
+		case err != nil:
+			logger.Warn("Readings will be partitioned without their sites", "error", err)
+		case cache == nil:
+			logger.Warn("PARTITION_BY_SITE needs PARTITION_SITE_REFRESH_INTERVAL; partitioning readings without their sites")
+		default:
+			partitionSites, sites = cache, cache.Sites
+		}
+	}
+	defer func() {
+		if err != nil && partitionSites != nil {
+			partitionSites.Stop()
+		}
+	}()
+
+	// Create sensor producer metrics
+	sensorMetrics := metrics.NewSensorProducerMetrics(registry)
+
+	// Create Kafka producer metrics
+	producerMetrics := kafka.NewProducerMetrics("iot", "kafka_producer", registry)
+
+	// Create Kafka producer
+	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:         cfg.KafkaBrokers,
+		Topic:           cfg.Topic(config.TopicKeySensorRaw),
+		RequiredAcks:    sarama.RequiredAcks(cfg.ProducerRequiredAcks),
+		Idempotent:      cfg.ProducerIdempotent,
+		ReturnSuccesses: cfg.ProducerReturnSuccess,
+		ReturnErrors:    cfg.ProducerReturnErrors,
+		Metrics:         producerMetrics,
+		Version:         cfg.KafkaVersion,
+		SendTimeout:     cfg.ProducerSendTimeout,
+		HopHeaders:      cfg.HopHeaders,
+		ClientID:        SimulatorClientID,
+		Security:        kafka.SecurityFromConfig(cfg),
+		Retry:           kafka.ProducerRetryFromConfig(cfg, config.RetryComponentSimulator),
+		Logger:          logger,
+		PartitionSites:  sites,
+
+		ClockDiagnostics: cfg.ClockDiagnostics,
+	},
+		kafka.WithMaxMessagesPerSecond(cfg.SimulatorMaxMessagesPerSecond),
+		kafka.WithMaxBytesPerSecond(cfg.SimulatorMaxBytesPerSecond),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+
+	// One serializer is shared so the schema is registered once. Without a
+	// registry, such as in the dev profile, Confluent framing falls back to
+	// JSON carrying its schema, which Kafka Connect reads just as well.
+	format := cfg.SensorFormat
+	if format == model.FormatConfluent && model.DefaultSchemaRegistry() == nil {
+		logger.Warn("SENSOR_FORMAT needs SCHEMA_REGISTRY_URL; sending JSON Schema framing instead", "format", format, "fallback", model.FormatJSONSchema)
+		format = model.FormatJSONSchema
+	}
+	serializer, err := model.NewReadingSerializer(format, model.DefaultSchemaRegistry(), cfg.Topic(config.TopicKeySensorRaw)+"-value")
+	if err != nil {
+		producer.Close()
+		return nil, fmt.Errorf("invalid SENSOR_FORMAT: %w", err)
+	}
+
+	defaultProfile, err := ParseProfile(cfg.SensorProfile)
+	if err != nil {
+		producer.Close()
+		return nil, fmt.Errorf("invalid SENSOR_PROFILE: %w", err)
+	}
+	profiles, err := ParseProfiles(cfg.SensorProfiles)
+	if err != nil {
+		producer.Close()
+		return nil, fmt.Errorf("invalid SENSOR_PROFILES: %w", err)
+	}
+
+	var anomalies *AnomalyInjector
+	if cfg.SimulatorAnomalyRate > 0 {
+		types, err := ParseAnomalyTypes(cfg.SimulatorAnomalyTypes)
+		if err != nil {
+			producer.Close()
+			return nil, fmt.Errorf("invalid SIMULATOR_ANOMALY_TYPES: %w", err)
+		}
+		anomalies, err = NewAnomalyInjector(cfg.SimulatorAnomalyRate, types, NewAnomalyMetrics("iot", "simulator", registry))
+		if err != nil {
+			producer.Close()
+			return nil, fmt.Errorf("invalid SIMULATOR_ANOMALY_RATE: %w", err)
+		}
+	}
+
+	positions, err := ParsePositions(cfg.SimulatorPositions)
+	if err != nil {
+		producer.Close()
+		return nil, fmt.Errorf("invalid SIMULATOR_POSITIONS: %w", err)
+	}
+	area, err := ParseArea(cfg.SimulatorArea)
+	if err != nil {
+		producer.Close()
+		return nil, fmt.Errorf("invalid SIMULATOR_AREA: %w", err)
+	}
+
+	var scenario *Scenario
+	if cfg.SimulatorScenario != "" {
+		if scenario, err = LoadScenario(cfg.SimulatorScenario); err != nil {
+			producer.Close()
+			return nil, fmt.Errorf("invalid SIMULATOR_SCENARIO: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Fleet{
+		producer: producer,
+		metrics:  sensorMetrics,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+
+		shutdownTimeout: cfg.ProducerShutdownTimeout,
+		partitionSites:  partitionSites,
+	}
+	if scenario != nil {
+		f.scenario = scenario
+		f.scenarioMetrics = NewScenarioMetrics("iot", "simulator", registry)
+	}
+	// Sites are spread over the area and their sensors gathered around them
+	siteCenters := make(map[string]model.GeoPoint)
+	topics := cfg.ReadingTopics()
+	for i := 0; i < cfg.SensorCount; i++ {
+		sensor := NewSensor(
+			fmt.Sprintf("sensor-%d", i),
+			producer,
+			sensorMetrics,
+			logger,
+		)
+		sensor.Serializer = serializer
+		sensor.Profile = defaultProfile
+		sensor.Anomalies = anomalies
+		sensor.FirmwareVersion = SimulatorFirmware
+		sensor.Topic = topics[sensor.ID]
+		if profile, ok := profiles[sensor.ID]; ok {
+			sensor.Profile = profile
+		}
+		if cfg.SensorSites > 0 {
+			sensor.Site = fmt.Sprintf("site-%d", i%cfg.SensorSites)
+		}
+		if cfg.SensorZones > 0 {
+			// Spread the zones over the sensors of each site
+			sensor.Zone = fmt.Sprintf("zone-%d", i/max(cfg.SensorSites, 1)%cfg.SensorZones)
+		}
+		if positions != PositionsNone {
+			home := randomPoint(area.Center, area.RadiusKm*1000)
+			if sensor.Site != "" {
+				center, ok := siteCenters[sensor.Site]
+				if !ok {
+					center = home
+					siteCenters[sensor.Site] = center
+				}
+				home = randomPoint(center, siteRadius)
+			}
+			sensor.Home = &home
+			if positions == PositionsDrifting {
+				sensor.DriftSpeed = cfg.SimulatorDriftSpeed
+			}
+		}
+		f.sensors = append(f.sensors, sensor)
+	}
+	f.schedule = NewScheduler(f.sensors, cfg.SensorInterval, cfg.SimulatorShards, cfg.SimulatorMaxInFlight,
+		cfg.SimulatorMaxBackoff, NewSchedulerMetrics("iot", "simulator", registry), logger)
+
+	// Optionally exercise the registry credential rotation flow with a few sensors
+	if cfg.SimulatorRotationInterval > 0 {
+		if cfg.SimulatorProvisioningToken == "" {
+			logger.Warn("SIMULATOR_PROVISIONING_TOKEN is not set; skipping credential rotation exercise")
+		} else {
+			var ids []string
+			for i := 0; i < cfg.SimulatorRotationSensors && i < len(f.sensors); i++ {
+				ids = append(ids, f.sensors[i].ID)
+			}
+			f.rotator = NewCredentialRotator(
+				cfg.SimulatorRegistryURL,
+				cfg.SimulatorProvisioningToken,
+				ids,
+				cfg.SimulatorRotationInterval,
+				NewRotationMetrics("iot", "simulator", registry),
+				logger,
+			)
+		}
+	}
+
+	return f, nil
+}
+
+// Start starts scheduling the sensors' readings
+func (f *Fleet) Start() error {
+	f.logger.Info("Starting sensors", "sensors", len(f.sensors), "shards", len(f.schedule.shards))
+	f.metrics.ActiveSensors.Set(float64(len(f.sensors)))
+	if f.partitionSites != nil {
+		f.partitionSites.Start()
+	}
+	f.schedule.Start(f.ctx)
+
+	if f.scenario != nil {
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.playScenario()
+		}()
+	}
+
+	if f.rotator != nil {
+		f.rotator.Start()
+	}
+	return nil
+}
+
+// Stop stops all sensors, waits for them to exit and shuts down the producer
+func (f *Fleet) Stop() {
+	if f.rotator != nil {
+		f.rotator.Stop()
+	}
+
+	f.schedule.Stop()
+
+	// Let readings already being sent be acknowledged before abandoning them
+	ctx, cancel := context.WithTimeout(context.Background(), f.shutdownTimeout)
+	defer cancel()
+	if err := f.producer.GracefulShutdown(ctx); err != nil {
+		f.logger.Error("Error during producer shutdown", "error", err)
+	}
+
+	f.cancel()
+	f.schedule.Wait()
+	f.wg.Wait()
+	if f.partitionSites != nil {
+		f.partitionSites.Stop()
+	}
+	f.metrics.ActiveSensors.Set(0)
+}
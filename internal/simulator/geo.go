@@ -0,0 +1,150 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Position modes of virtual sensors
+const (
+	// PositionsFixed places each sensor at a fixed point near its site
+	PositionsFixed = "fixed"
+	// PositionsDrifting lets each sensor wander around its starting point,
+	// like a sensor on a vehicle or a pallet
+	PositionsDrifting = "drifting"
+	// PositionsNone sends readings without a location
+	PositionsNone = "none"
+)
+
+// Placement of virtual sensors: sites are spread over the area and their
+// sensors within siteRadius of the site, and a drifting sensor turns back
+// once it is more than driftRadius from where it started
+const (
+	metersPerDegree = 111320.0
+	siteRadius      = 500.0
+	driftRadius     = 1000.0
+)
+
+// Area is the circle virtual sensors are placed in
+type Area struct {
+	Center model.GeoPoint
+	// RadiusKm is the radius of the circle in kilometers
+	RadiusKm float64
+}
+
+// ParseArea parses an area written as "lat,lon,radius_km"
+func ParseArea(spec string) (Area, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != 3 {
+		return Area{}, fmt.Errorf("area %q is not lat,lon,radius_km", spec)
+	}
+	var values [3]float64
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return Area{}, fmt.Errorf("area %q is not lat,lon,radius_km: %w", spec, err)
+		}
+		values[i] = value
+	}
+
+	area := Area{Center: model.GeoPoint{Lat: values[0], Lon: values[1]}, RadiusKm: values[2]}
+	if area.Center.Lat < -85 || area.Center.Lat > 85 {
+		return Area{}, fmt.Errorf("area latitude %g must be between -85 and 85", area.Center.Lat)
+	}
+	if area.Center.Lon < -180 || area.Center.Lon > 180 {
+		return Area{}, fmt.Errorf("area longitude %g must be between -180 and 180", area.Center.Lon)
+	}
+	if area.RadiusKm <= 0 {
+		return Area{}, fmt.Errorf("area radius %g must be positive", area.RadiusKm)
+	}
+	return area, nil
+}
+
+// ParsePositions parses a position mode
+func ParsePositions(mode string) (string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case PositionsFixed, PositionsDrifting, PositionsNone:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown position mode %q: expected %s, %s or %s", mode,
+		PositionsFixed, PositionsDrifting, PositionsNone)
+}
+
+// randomPoint returns a point picked uniformly within radius meters of center
+func randomPoint(center model.GeoPoint, radius float64) model.GeoPoint {
+	distance := radius * math.Sqrt(rand.Float64())
+	bearing := rand.Float64() * 2 * math.Pi
+	return offset(center, distance*math.Cos(bearing), distance*math.Sin(bearing))
+}
+
+// offset moves a point north and east by the given meters, which is accurate
+// enough over the few kilometers sensors are spread
+func offset(p model.GeoPoint, north, east float64) model.GeoPoint {
+	lat := p.Lat + north/metersPerDegree
+	lon := p.Lon + east/(metersPerDegree*math.Cos(p.Lat*math.Pi/180))
+	if lon > 180 {
+		lon -= 360
+	} else if lon < -180 {
+		lon += 360
+	}
+	return model.GeoPoint{Lat: lat, Lon: lon}
+}
+
+// distance returns the distance in meters from a to b, north and east
+func distance(a, b model.GeoPoint) (float64, float64) {
+	lon := b.Lon - a.Lon
+	if lon > 180 {
+		lon -= 360
+	} else if lon < -180 {
+		lon += 360
+	}
+	return (b.Lat - a.Lat) * metersPerDegree, lon * metersPerDegree * math.Cos(a.Lat*math.Pi/180)
+}
+
+// positionState is where a sensor is; a drifting sensor walks in a random
+// direction at its speed, turning back once it strays too far from home
+type positionState struct {
+	home    *model.GeoPoint
+	current model.GeoPoint
+	speed   float64
+	heading float64
+	last    time.Time
+}
+
+// newPositionState starts a sensor at home, drifting at speed m/s. A nil
+// home sends no location.
+func newPositionState(home *model.GeoPoint, speed float64, start time.Time) *positionState {
+	state := &positionState{home: home, speed: speed, heading: rand.Float64() * 2 * math.Pi, last: start}
+	if home != nil {
+		state.current = *home
+	}
+	return state
+}
+
+// next returns the position at at, or nil if the sensor has none
+func (p *positionState) next(at time.Time) *model.GeoPoint {
+	if p.home == nil {
+		return nil
+	}
+	if p.speed > 0 {
+		step := p.speed * at.Sub(p.last).Seconds()
+		north, east := distance(*p.home, p.current)
+		if math.Hypot(north, east) > driftRadius {
+			// Head back home, give or take
+			p.heading = math.Atan2(-east, -north) + (rand.Float64()-0.5)*math.Pi/2
+		} else {
+			p.heading += rand.NormFloat64() * 0.3
+		}
+		p.current = offset(p.current, step*math.Cos(p.heading), step*math.Sin(p.heading))
+	}
+	p.last = at
+	position := p.current
+	return &position
+}
@@ -0,0 +1,226 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile parameter names, used in profile specs as name or name:value
+const (
+	ProfileUniform     = "uniform"
+	ProfileBaseline    = "baseline"
+	ProfileTemperature = "temperature"
+	ProfileHumidity    = "humidity"
+	ProfileDiurnal     = "diurnal"
+	ProfileNoise       = "noise"
+	ProfileDrift       = "drift"
+	ProfileStuck       = "stuck"
+	ProfileSpikes      = "spikes"
+)
+
+// Values of profile parameters given without one
+var profileDefaults = map[string]float64{
+	ProfileTemperature: 22,
+	ProfileHumidity:    45,
+	ProfileDiurnal:     6,
+	ProfileNoise:       0.3,
+	ProfileDrift:       0.5,
+	ProfileStuck:       0.001,
+	ProfileSpikes:      0.01,
+}
+
+// spikeTemperature is how far a spike lifts the temperature, enough to
+// cross the default threshold from a typical baseline
+const spikeTemperature = 30.0
+
+// Profile shapes the readings of a virtual sensor. A uniform profile draws
+// temperature and humidity independently at random, as the simulator always
+// has; otherwise readings follow a baseline that the enabled behaviours
+// (zero values disable them) move around.
+type Profile struct {
+	// Uniform draws temperature in [10, 60) °C and humidity in [5, 95) %
+	Uniform bool
+
+	// Temperature and Humidity are the baseline in °C and %
+	Temperature float64
+	Humidity    float64
+
+	// Diurnal is the amplitude in °C of a daily cycle peaking at 15:00 local
+	// time; humidity moves the other way by twice as much
+	Diurnal float64
+
+	// Noise is the standard deviation in °C of gaussian noise; humidity
+	// noise is twice as large
+	Noise float64
+
+	// Drift is how fast the temperature walks away from the baseline, in °C per hour
+	Drift float64
+
+	// Stuck is the probability per reading that the sensor freezes and
+	// repeats its last values for good
+	Stuck float64
+
+	// Spikes is the probability per reading of a temperature spike
+	Spikes float64
+}
+
+// UniformProfile is the profile of sensors not configured otherwise
+var UniformProfile = Profile{Uniform: true}
+
+// ParseProfile parses a comma-separated list of profile parameters, each
+// name:value or a bare name that takes its default, e.g.
+// "diurnal,noise:0.5,spikes:0.05". "uniform" selects UniformProfile and
+// "baseline" a steady baseline; the baseline is implied by every other
+// parameter.
+func ParseProfile(spec string) (Profile, error) {
+	profile := Profile{
+		Temperature: profileDefaults[ProfileTemperature],
+		Humidity:    profileDefaults[ProfileHumidity],
+	}
+	shaped := false
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(item, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case ProfileUniform:
+			if hasValue {
+				return Profile{}, fmt.Errorf("%s takes no value", name)
+			}
+			profile.Uniform = true
+			continue
+		case ProfileBaseline:
+			if hasValue {
+				return Profile{}, fmt.Errorf("%s takes no value", name)
+			}
+			shaped = true
+			continue
+		}
+
+		parsed, ok := profileDefaults[name]
+		if !ok {
+			return Profile{}, fmt.Errorf("unknown profile parameter %q", name)
+		}
+		if hasValue {
+			var err error
+			if parsed, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				return Profile{}, fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+		if parsed < 0 && name != ProfileTemperature && name != ProfileDrift {
+			return Profile{}, fmt.Errorf("invalid %s: must not be negative", name)
+		}
+		if (name == ProfileStuck || name == ProfileSpikes) && parsed > 1 {
+			return Profile{}, fmt.Errorf("invalid %s: must be a probability", name)
+		}
+
+		shaped = true
+		switch name {
+		case ProfileTemperature:
+			profile.Temperature = parsed
+		case ProfileHumidity:
+			profile.Humidity = parsed
+		case ProfileDiurnal:
+			profile.Diurnal = parsed
+		case ProfileNoise:
+			profile.Noise = parsed
+		case ProfileDrift:
+			profile.Drift = parsed
+		case ProfileStuck:
+			profile.Stuck = parsed
+		case ProfileSpikes:
+			profile.Spikes = parsed
+		}
+	}
+	if profile.Uniform {
+		if shaped {
+			return Profile{}, fmt.Errorf("%s cannot be combined with other parameters", ProfileUniform)
+		}
+		return UniformProfile, nil
+	}
+	return profile, nil
+}
+
+// ParseProfiles parses per-sensor profiles of the form
+// "sensor-1=stuck;sensor-2=diurnal:8,noise". Sensors not listed keep the
+// default profile.
+func ParseProfiles(spec string) (map[string]Profile, error) {
+	profiles := make(map[string]Profile)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		sensorID, list, ok := strings.Cut(entry, "=")
+		sensorID = strings.TrimSpace(sensorID)
+		if !ok || sensorID == "" {
+			return nil, fmt.Errorf("invalid sensor profile %q: expected sensor=name:value,...", entry)
+		}
+		profile, err := ParseProfile(list)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile for %s: %w", sensorID, err)
+		}
+		profiles[sensorID] = profile
+	}
+	return profiles, nil
+}
+
+// profileState is the evolving state of a sensor following a profile
+type profileState struct {
+	profile Profile
+	start   time.Time
+
+	stuck       bool
+	temperature float64
+	humidity    float64
+}
+
+func newProfileState(profile Profile, start time.Time) *profileState {
+	return &profileState{profile: profile, start: start}
+}
+
+// next returns the temperature and humidity of the reading taken at
+func (p *profileState) next(at time.Time) (float32, float32) {
+	profile := p.profile
+	if profile.Uniform {
+		// This will occasionally generate anomalies (>50°C, <10%)
+		return 10.0 + rand.Float32()*50.0, 5.0 + rand.Float32()*90.0
+	}
+	if p.stuck {
+		return float32(p.temperature), float32(p.humidity)
+	}
+
+	temperature := profile.Temperature
+	humidity := profile.Humidity
+	if profile.Diurnal > 0 {
+		hour := float64(at.Hour()) + float64(at.Minute())/60
+		swing := profile.Diurnal * math.Sin(2*math.Pi*(hour-9)/24)
+		temperature += swing
+		humidity -= 2 * swing
+	}
+	temperature += profile.Drift * at.Sub(p.start).Hours()
+	if profile.Noise > 0 {
+		temperature += rand.NormFloat64() * profile.Noise
+		humidity += rand.NormFloat64() * 2 * profile.Noise
+	}
+	if rand.Float64() < profile.Spikes {
+		temperature += spikeTemperature
+	}
+	humidity = math.Max(0, math.Min(100, humidity))
+
+	// A sensor that gets stuck repeats the reading it froze on
+	if rand.Float64() < profile.Stuck {
+		p.stuck = true
+	}
+	p.temperature, p.humidity = temperature, humidity
+	return float32(temperature), float32(humidity)
+}
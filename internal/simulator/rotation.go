@@ -0,0 +1,242 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/registry"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rotation checks reported in the rotation_checks_total metric
+const (
+	CheckNewKeyAccepted     = "new_key_accepted"
+	CheckOldKeyDuringWindow = "old_key_during_overlap"
+	CheckOldKeyAfterWindow  = "old_key_rejected_after_overlap"
+)
+
+// expiryMargin is added to the overlap deadline before checking the old key is rejected
+const expiryMargin = time.Second
+
+// RotationMetrics holds Prometheus metrics for the simulated credential rotation flow
+type RotationMetrics struct {
+	Rotations *prometheus.CounterVec
+	Checks    *prometheus.CounterVec
+}
+
+// NewRotationMetrics creates a new set of rotation metrics
+func NewRotationMetrics(namespace, subsystem string, registry prometheus.Registerer) *RotationMetrics {
+	metrics := &RotationMetrics{
+		Rotations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "credential_rotations_total",
+			Help:      "Total number of simulated device credential rotations by result",
+		}, []string{"result"}),
+		Checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "rotation_checks_total",
+			Help:      "Total number of gateway authentication checks around rotations by check and result",
+		}, []string{"check", "result"}),
+	}
+
+	registry.MustRegister(metrics.Rotations, metrics.Checks)
+
+	return metrics
+}
+
+// CredentialRotator provisions a handful of simulated devices through the
+// registry and periodically rotates their credentials, verifying that the
+// authentication path (including its cache) honours the overlap window
+type CredentialRotator struct {
+	baseURL   string
+	token     string
+	interval  time.Duration
+	sensorIDs []string
+	client    *http.Client
+	metrics   *RotationMetrics
+	logger    *slog.Logger
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewCredentialRotator creates a rotator for the given simulated sensors.
+// Each rotation keeps the previous key valid for half the interval. logger
+// may be nil.
+func NewCredentialRotator(registryURL, provisioningToken string, sensorIDs []string, interval time.Duration, metrics *RotationMetrics, logger *slog.Logger) *CredentialRotator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CredentialRotator{
+		baseURL:   strings.TrimRight(registryURL, "/"),
+		token:     provisioningToken,
+		interval:  interval,
+		sensorIDs: sensorIDs,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		metrics:   metrics,
+		logger:    logging.OrDefault(logger),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start claims an identity for each sensor and begins rotating
+func (r *CredentialRotator) Start() {
+	r.logger.Info("Exercising credential rotation", "sensors", len(r.sensorIDs), "interval", r.interval)
+	for _, id := range r.sensorIDs {
+		r.wg.Add(1)
+		go r.run(id)
+	}
+}
+
+// Stop stops rotating and waits for in-flight checks to finish
+func (r *CredentialRotator) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// run drives the rotation cycle for one simulated device
+func (r *CredentialRotator) run(sensorID string) {
+	defer r.wg.Done()
+
+	// A fresh hardware ID per run avoids colliding with claims from earlier runs
+	creds, err := r.claim(registry.ClaimRequest{
+		Token:           r.token,
+		HardwareID:      fmt.Sprintf("sim-%s-%s", sensorID, uuid.NewString()[:8]),
+		FirmwareVersion: SimulatorFirmware,
+	})
+	if err != nil {
+		r.logger.Error("Sensor failed to claim credentials", "sensor_id", sensorID, "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rotated, err := r.rotate(creds)
+		if err != nil {
+			r.logger.Error("Sensor failed to rotate credentials", "sensor_id", sensorID, "error", err)
+			r.metrics.Rotations.WithLabelValues("error").Inc()
+			continue
+		}
+		r.metrics.Rotations.WithLabelValues("success").Inc()
+
+		r.check(CheckNewKeyAccepted, rotated.APIKey, true)
+		r.check(CheckOldKeyDuringWindow, creds.APIKey, true)
+
+		if rotated.PreviousExpiresAt != nil {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(time.Until(*rotated.PreviousExpiresAt) + expiryMargin):
+			}
+			r.check(CheckOldKeyAfterWindow, creds.APIKey, false)
+		}
+
+		creds = rotated
+	}
+}
+
+// check authenticates an API key and records whether the outcome matched expectations
+func (r *CredentialRotator) check(name, apiKey string, wantAccepted bool) {
+	accepted, err := r.whoami(apiKey)
+	if err != nil {
+		r.logger.Error("Rotation check failed", "check", name, "error", err)
+		r.metrics.Checks.WithLabelValues(name, "error").Inc()
+		return
+	}
+
+	if accepted != wantAccepted {
+		r.logger.Error("Rotation check failed", "check", name, "accepted", accepted, "want", wantAccepted)
+		r.metrics.Checks.WithLabelValues(name, "fail").Inc()
+		return
+	}
+	r.metrics.Checks.WithLabelValues(name, "pass").Inc()
+}
+
+// claim exchanges the provisioning token for credentials
+func (r *CredentialRotator) claim(req registry.ClaimRequest) (*registry.Credentials, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds registry.Credentials
+	if err := r.do(http.MethodPost, "/api/v1/provisioning/claim", "", body, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// rotate asks the registry for new credentials using the current API key
+func (r *CredentialRotator) rotate(current *registry.Credentials) (*registry.Credentials, error) {
+	body, err := json.Marshal(map[string]string{"overlap": (r.interval / 2).String()})
+	if err != nil {
+		return nil, err
+	}
+
+	var creds registry.Credentials
+	path := "/api/v1/sensors/" + current.SensorID + "/credentials/rotate"
+	if err := r.do(http.MethodPost, path, current.APIKey, body, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// whoami reports whether the registry accepts an API key
+func (r *CredentialRotator) whoami(apiKey string) (bool, error) {
+	err := r.do(http.MethodGet, "/api/v1/auth/whoami", apiKey, nil, nil)
+	if errors.Is(err, errUnauthorized) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// errUnauthorized is returned by do for 401 responses
+var errUnauthorized = errors.New("unauthorized")
+
+// do performs a JSON request against the registry
+func (r *CredentialRotator) do(method, path, apiKey string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(r.ctx, method, r.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set(registry.HeaderAPIKey, apiKey)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errUnauthorized
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("registry returned %s for %s %s", resp.Status, method, path)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
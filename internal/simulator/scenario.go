@@ -0,0 +1,257 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario event actions
+const (
+	// ScenarioAmbient shifts the readings of the targets by Temperature and
+	// Humidity from their profile, ramping from the current shift over Over
+	ScenarioAmbient = "ambient"
+	// ScenarioOffline stops the targets sending readings, for For if set
+	ScenarioOffline = "offline"
+	// ScenarioOnline lets offline targets send readings again
+	ScenarioOnline = "online"
+	// ScenarioProfile switches the targets to the reading profile Profile
+	ScenarioProfile = "profile"
+)
+
+// Scenario is a timed sequence of events the simulator plays against the
+// fleet, for repeatable demos and tests. It is read from a YAML or JSON file:
+//
+//	name: site-3 heatwave
+//	events:
+//	  - at: 5m
+//	    action: ambient
+//	    site: site-3
+//	    temperature: 15
+//	    over: 10m
+//	  - at: 20m
+//	    action: offline
+//	    count: 50
+//	    for: 10m
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Loop replays the scenario after its last event, restoring every
+	// sensor first
+	Loop   bool            `yaml:"loop"`
+	Events []ScenarioEvent `yaml:"events"`
+}
+
+// ScenarioEvent is one change to the fleet. Its targets are the sensors of
+// Site and in Sensors, every sensor if neither is set, limited to the first
+// Count of them in fleet order if Count is set.
+type ScenarioEvent struct {
+	// At is when the event happens, relative to the start of the scenario
+	At     time.Duration `yaml:"at"`
+	Action string        `yaml:"action"`
+
+	Site    string   `yaml:"site"`
+	Sensors []string `yaml:"sensors"`
+	Count   int      `yaml:"count"`
+
+	// Temperature and Humidity are the shift of an ambient event in °C and
+	// %, relative to the profile rather than to the previous shift; 0
+	// returns to the profile
+	Temperature float64 `yaml:"temperature"`
+	Humidity    float64 `yaml:"humidity"`
+	// Over is how long an ambient event takes to reach its shift
+	Over time.Duration `yaml:"over"`
+
+	// For brings the targets of an offline event back online after it (0
+	// keeps them offline until an online event)
+	For time.Duration `yaml:"for"`
+
+	// Profile is the reading profile of a profile event (see ParseProfile)
+	Profile string `yaml:"profile"`
+
+	profile Profile
+}
+
+// LoadScenario reads and validates a scenario file. Unknown fields are
+// rejected so typos do not silently change a scenario.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	scenario := &Scenario{}
+	if err := decoder.Decode(scenario); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if scenario.Name == "" {
+		scenario.Name = path
+	}
+
+	for i := range scenario.Events {
+		event := &scenario.Events[i]
+		if err := event.validate(); err != nil {
+			return nil, fmt.Errorf("invalid event %d of %s: %w", i+1, path, err)
+		}
+	}
+	sort.SliceStable(scenario.Events, func(i, j int) bool {
+		return scenario.Events[i].At < scenario.Events[j].At
+	})
+	return scenario, nil
+}
+
+// validate checks an event and parses its profile
+func (e *ScenarioEvent) validate() error {
+	if e.At < 0 || e.Over < 0 || e.For < 0 {
+		return fmt.Errorf("at, over and for must not be negative")
+	}
+	if e.Count < 0 {
+		return fmt.Errorf("count must not be negative")
+	}
+
+	switch e.Action {
+	case ScenarioAmbient, ScenarioOffline, ScenarioOnline:
+	case ScenarioProfile:
+		profile, err := ParseProfile(e.Profile)
+		if err != nil {
+			return fmt.Errorf("invalid profile: %w", err)
+		}
+		e.profile = profile
+	case "":
+		return fmt.Errorf("missing action")
+	default:
+		return fmt.Errorf("unknown action %q: expected %s, %s, %s or %s", e.Action,
+			ScenarioAmbient, ScenarioOffline, ScenarioOnline, ScenarioProfile)
+	}
+	return nil
+}
+
+// ScenarioMetrics holds Prometheus metrics for scenario playback
+type ScenarioMetrics struct {
+	Events *prometheus.CounterVec
+	Runs   prometheus.Counter
+}
+
+// NewScenarioMetrics creates a new set of scenario metrics
+func NewScenarioMetrics(namespace, subsystem string, registry prometheus.Registerer) *ScenarioMetrics {
+	metrics := &ScenarioMetrics{
+		Events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scenario_events_total",
+			Help:      "Total number of scenario events played by action",
+		}, []string{"action"}),
+		Runs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scenario_runs_total",
+			Help:      "Total number of times the scenario was started",
+		}),
+	}
+
+	registry.MustRegister(metrics.Events, metrics.Runs)
+
+	return metrics
+}
+
+// playScenario plays the fleet's scenario until it ends or the fleet stops
+func (f *Fleet) playScenario() {
+	for {
+		start := time.Now()
+		f.scenarioMetrics.Runs.Inc()
+		f.logger.Info("Playing scenario", "scenario", f.scenario.Name, "events", len(f.scenario.Events))
+
+		for _, event := range f.scenario.Events {
+			select {
+			case <-time.After(time.Until(start.Add(event.At))):
+			case <-f.ctx.Done():
+				return
+			}
+			f.applyEvent(event)
+		}
+
+		if !f.scenario.Loop {
+			f.logger.Info("Scenario complete", "scenario", f.scenario.Name)
+			return
+		}
+		select {
+		case <-f.ctx.Done():
+			return
+		default:
+		}
+		for _, sensor := range f.sensors {
+			sensor.resetConditions()
+		}
+		f.updateActiveSensors()
+	}
+}
+
+// applyEvent applies a scenario event to its targets
+func (f *Fleet) applyEvent(event ScenarioEvent) {
+	targets := f.targets(event)
+	now := time.Now()
+	for _, sensor := range targets {
+		switch event.Action {
+		case ScenarioAmbient:
+			sensor.setAmbient(event.Temperature, event.Humidity, now, event.Over)
+		case ScenarioOffline:
+			sensor.setOffline(true)
+		case ScenarioOnline:
+			sensor.setOffline(false)
+		case ScenarioProfile:
+			sensor.setProfile(event.profile)
+		}
+	}
+
+	if event.Action == ScenarioOffline && event.For > 0 {
+		time.AfterFunc(event.For, func() {
+			for _, sensor := range targets {
+				sensor.setOffline(false)
+			}
+			f.updateActiveSensors()
+		})
+	}
+	f.updateActiveSensors()
+	f.scenarioMetrics.Events.WithLabelValues(event.Action).Inc()
+	f.logger.Info("Scenario event", "scenario", f.scenario.Name, "action", event.Action, "sensors", len(targets), "at", event.At)
+}
+
+// targets returns the sensors an event applies to
+func (f *Fleet) targets(event ScenarioEvent) []*Sensor {
+	listed := make(map[string]bool, len(event.Sensors))
+	for _, id := range event.Sensors {
+		listed[id] = true
+	}
+
+	var targets []*Sensor
+	for _, sensor := range f.sensors {
+		if event.Site != "" && sensor.Site != event.Site {
+			continue
+		}
+		if len(listed) > 0 && !listed[sensor.ID] {
+			continue
+		}
+		targets = append(targets, sensor)
+		if event.Count > 0 && len(targets) == event.Count {
+			break
+		}
+	}
+	return targets
+}
+
+// updateActiveSensors sets the active sensor gauge to the sensors online
+func (f *Fleet) updateActiveSensors() {
+	online := 0
+	for _, sensor := range f.sensors {
+		if !sensor.isOffline() {
+			online++
+		}
+	}
+	f.metrics.ActiveSensors.Set(float64(online))
+}
@@ -0,0 +1,312 @@
+package simulator
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recoveryStep is the share of the full send rate regained after every
+// interval in which sends succeeded and none failed
+const recoveryStep = 0.1
+
+// SchedulerMetrics holds Prometheus metrics for the sensor scheduler
+type SchedulerMetrics struct {
+	Backoff prometheus.Gauge
+	Lag     prometheus.Histogram
+	Skipped prometheus.Counter
+}
+
+// NewSchedulerMetrics creates a new set of scheduler metrics
+func NewSchedulerMetrics(namespace, subsystem string, registry prometheus.Registerer) *SchedulerMetrics {
+	metrics := &SchedulerMetrics{
+		Backoff: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scheduler_backoff",
+			Help:      "Factor the sensor interval is stretched by while the producer reports errors",
+		}),
+		Lag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scheduler_lag_seconds",
+			Help:      "Delay between when readings were due and when their send started",
+			Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+		}),
+		Skipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scheduler_skipped_readings_total",
+			Help:      "Total number of readings skipped because their sensor was still waiting to send a previous one",
+		}),
+	}
+	metrics.Backoff.Set(1)
+
+	registry.MustRegister(metrics.Backoff, metrics.Lag, metrics.Skipped)
+
+	return metrics
+}
+
+// Scheduler sends the readings of many sensors from a few goroutines. The
+// sensors are spread over shards, each a goroutine keeping its sensors in
+// order of their next reading, and their first readings are staggered over
+// the interval so they do not all send at once. At most maxInFlight readings
+// are sent at once; a shard waits for a free slot, so a slow producer delays
+// the readings and late ones are skipped rather than sent in a burst. While
+// the producer reports errors the interval is doubled every interval, up to
+// maxBackoff times, and shortened again once sends succeed.
+type Scheduler struct {
+	interval   time.Duration
+	maxBackoff float64
+	shards     []*shard
+	inFlight   chan struct{}
+	metrics    *SchedulerMetrics
+	logger     *slog.Logger
+
+	// mu guards the backoff and the outcome of the sends since it was last
+	// adjusted
+	mu        sync.Mutex
+	backoff   float64
+	failures  int
+	successes int
+
+	// stop ends scheduling readings; loops are the shards and the backoff
+	// adjustment, sends the readings being sent
+	stop  chan struct{}
+	loops sync.WaitGroup
+	sends sync.WaitGroup
+}
+
+// shard schedules a share of the sensors
+type shard struct {
+	schedule schedule
+	// done returns the sensors whose reading was sent; it holds every sensor
+	// of the shard so a send never waits for the shard
+	done chan *scheduled
+}
+
+// scheduled is a sensor and when its next reading is due
+type scheduled struct {
+	sensor *Sensor
+	run    *run
+	due    time.Time
+	// offset staggers the sensor's first reading within the interval
+	offset time.Duration
+}
+
+// schedule is a heap of sensors by due time
+type schedule []*scheduled
+
+func (s schedule) Len() int           { return len(s) }
+func (s schedule) Less(i, j int) bool { return s[i].due.Before(s[j].due) }
+func (s schedule) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s *schedule) Push(x any)        { *s = append(*s, x.(*scheduled)) }
+func (s *schedule) Pop() any {
+	old := *s
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*s = old[:n-1]
+	return item
+}
+
+// NewScheduler creates a scheduler sending a reading of every sensor each
+// interval from shards goroutines, with at most maxInFlight readings sent at
+// once and the interval stretched at most maxBackoff times on producer errors
+// (below 1 never stretches it); logger may be nil
+func NewScheduler(sensors []*Sensor, interval time.Duration, shards, maxInFlight int, maxBackoff float64, metrics *SchedulerMetrics, logger *slog.Logger) *Scheduler {
+	shards = max(min(shards, len(sensors)), 1)
+	s := &Scheduler{
+		interval:   interval,
+		maxBackoff: max(maxBackoff, 1),
+		inFlight:   make(chan struct{}, max(maxInFlight, 1)),
+		metrics:    metrics,
+		logger:     logging.OrDefault(logger),
+		backoff:    1,
+		stop:       make(chan struct{}),
+	}
+	for i := 0; i < shards; i++ {
+		s.shards = append(s.shards, &shard{done: make(chan *scheduled, (len(sensors)+shards-1)/shards)})
+	}
+	for i, sensor := range sensors {
+		sh := s.shards[i%shards]
+		sh.schedule = append(sh.schedule, &scheduled{
+			sensor: sensor,
+			offset: interval * time.Duration(i) / time.Duration(len(sensors)),
+		})
+	}
+	return s
+}
+
+// Start schedules the sensors' readings until Stop is called; ctx bounds
+// each send
+func (s *Scheduler) Start(ctx context.Context) {
+	now := time.Now()
+	for _, sh := range s.shards {
+		for _, item := range sh.schedule {
+			item.run = item.sensor.newRun(now)
+			item.due = now.Add(item.offset)
+		}
+		heap.Init(&sh.schedule)
+
+		s.loops.Add(1)
+		go func(sh *shard) {
+			defer s.loops.Done()
+			s.runShard(ctx, sh)
+		}(sh)
+	}
+
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		s.adjustBackoff(ctx)
+	}()
+}
+
+// Stop stops scheduling readings and waits for the shards to exit; readings
+// being sent are left to finish, see Wait
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.loops.Wait()
+}
+
+// Wait waits for the readings being sent to finish
+func (s *Scheduler) Wait() {
+	s.sends.Wait()
+}
+
+// runShard sends the readings of a shard's sensors as they fall due
+func (s *Scheduler) runShard(ctx context.Context, sh *shard) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		var due <-chan time.Time
+		if len(sh.schedule) > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(sh.schedule[0].due))
+			due = timer.C
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ctx.Done():
+			return
+		case item := <-sh.done:
+			s.reschedule(sh, item)
+		case <-due:
+			for len(sh.schedule) > 0 && !sh.schedule[0].due.After(time.Now()) {
+				if !s.dispatch(ctx, sh, heap.Pop(&sh.schedule).(*scheduled)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// dispatch sends a sensor's reading once a slot is free, returning the
+// sensor to its shard when done. It reports false when the scheduler stopped
+// while waiting for a slot.
+func (s *Scheduler) dispatch(ctx context.Context, sh *shard, item *scheduled) bool {
+	select {
+	case s.inFlight <- struct{}{}:
+	case <-s.stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+	now := time.Now()
+	s.metrics.Lag.Observe(now.Sub(item.due).Seconds())
+
+	s.sends.Add(1)
+	go func() {
+		defer s.sends.Done()
+		err := item.sensor.tick(ctx, item.run, now)
+		<-s.inFlight
+		if ctx.Err() == nil {
+			s.record(err)
+		}
+		sh.done <- item
+	}()
+	return true
+}
+
+// reschedule schedules a sensor's next reading one stretched interval after
+// its last one was due, skipping the readings it is already too late for
+func (s *Scheduler) reschedule(sh *shard, item *scheduled) {
+	s.mu.Lock()
+	step := time.Duration(float64(s.interval) * s.backoff)
+	s.mu.Unlock()
+
+	item.due = item.due.Add(step)
+	if late := time.Since(item.due); late > 0 && step > 0 {
+		skipped := late/step + 1
+		item.due = item.due.Add(skipped * step)
+		s.metrics.Skipped.Add(float64(skipped))
+	}
+	heap.Push(&sh.schedule, item)
+}
+
+// record counts the outcome of a send
+func (s *Scheduler) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failures++
+	} else {
+		s.successes++
+	}
+}
+
+// adjustBackoff doubles the backoff after every interval in which a send
+// failed, and brings the send rate back towards the full rate after every
+// interval in which sends succeeded and none failed
+func (s *Scheduler) adjustBackoff(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		previous := s.backoff
+		switch {
+		case s.failures > 0:
+			s.backoff = min(s.backoff*2, s.maxBackoff)
+		case s.successes > 0 && s.backoff > 1:
+			s.backoff = max(1/(1/s.backoff+recoveryStep), 1)
+		}
+		backoff, failures := s.backoff, s.failures
+		s.failures, s.successes = 0, 0
+		s.mu.Unlock()
+
+		if backoff == previous {
+			continue
+		}
+		s.metrics.Backoff.Set(backoff)
+		switch {
+		case backoff > previous:
+			s.logger.Warn("Producer errors, slowing down sensors", "failures", failures, "backoff", backoff,
+				"interval", time.Duration(float64(s.interval)*backoff))
+		case backoff == 1:
+			s.logger.Info("Producer recovered, sensors back at full rate", "interval", s.interval)
+		}
+	}
+}
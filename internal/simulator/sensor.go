@@ -0,0 +1,288 @@
+package simulator
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/metrics"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of simulated sensors, one trace per reading
+var tracer = otel.Tracer("github.com/example/iot-sensor-fleet/internal/simulator")
+
+// SimulatorFirmware is the firmware version simulated devices report
+const SimulatorFirmware = "simulator"
+
+// Sensor represents a virtual IoT sensor
+type Sensor struct {
+	ID       string
+	Site     string
+	Zone     string
+	Producer *kafka.Producer
+	// Topic receives the readings instead of the producer's topic when set
+	Topic   string
+	Metrics *metrics.SensorProducerMetrics
+	Logger  *slog.Logger
+	// Serializer encodes readings; nil sends JSON
+	Serializer *model.ReadingSerializer
+	// Profile shapes the readings (UniformProfile by default)
+	Profile Profile
+	// Anomalies replaces some readings with injected anomalies (optional)
+	Anomalies *AnomalyInjector
+	// Home is where the sensor is placed; nil sends readings without a location
+	Home *model.GeoPoint
+	// DriftSpeed moves the sensor around its home at that many m/s (0 keeps it still)
+	DriftSpeed float64
+	// FirmwareVersion is sent in the firmware version header of every
+	// reading (empty sends none)
+	FirmwareVersion string
+
+	// mu guards the conditions a scenario sets while the sensor runs
+	mu         sync.Mutex
+	conditions conditions
+}
+
+// conditions are the changes a scenario made to a sensor
+type conditions struct {
+	offline bool
+	// profile replaces the sensor's profile when set; generation counts
+	// the replacements so the sensor restarts its profile state
+	profile    *Profile
+	generation int
+	ambient    ramp
+}
+
+// ramp moves a temperature and humidity shift linearly from one value to
+// another between start and start+over
+type ramp struct {
+	fromTemperature, fromHumidity float64
+	toTemperature, toHumidity     float64
+	start                         time.Time
+	over                          time.Duration
+}
+
+// at returns the shift at t
+func (r ramp) at(t time.Time) (float64, float64) {
+	progress := 1.0
+	if elapsed := t.Sub(r.start); r.over > 0 && elapsed < r.over {
+		progress = float64(elapsed) / float64(r.over)
+	}
+	return r.fromTemperature + (r.toTemperature-r.fromTemperature)*progress,
+		r.fromHumidity + (r.toHumidity-r.fromHumidity)*progress
+}
+
+// send serializes a reading and sends it to Kafka, starting the trace that
+// the messages derived from it carry on. It returns the producer's error;
+// readings that fail to serialize are counted and dropped.
+func (s *Sensor) send(ctx context.Context, reading *model.SensorReading, anomaly string) error {
+	ctx, span := tracer.Start(ctx, "send reading")
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	// Serialize the reading
+	_, serializeSpan := tracer.Start(ctx, "serialize reading", trace.WithAttributes(attribute.String("format", s.format())))
+	data, err := s.serialize(reading)
+	if err == nil {
+		data, err = injectPayload(anomaly, reading, data)
+	}
+	tracing.End(serializeSpan, err)
+	if err != nil {
+		s.Logger.Error("Error serializing sensor reading", "error", err)
+		if s.Metrics != nil {
+			s.Metrics.SensorReadingErrors.Inc()
+		}
+		return nil
+	}
+
+	// Send the reading to Kafka
+	format, version := injectHeaders(anomaly, s.format(), model.SchemaVersion)
+	headers := []sarama.RecordHeader{
+		kafka.TraceIDHeader(kafka.NewTraceID()), kafka.SchemaVersionHeader(version), kafka.FormatHeader(format),
+	}
+	if s.FirmwareVersion != "" {
+		headers = append(headers, kafka.FirmwareVersionHeader(s.FirmwareVersion))
+	}
+	if reading.Site != "" {
+		headers = append(headers, kafka.SiteHeader(reading.Site))
+	}
+	startTime := time.Now()
+	if s.Topic != "" {
+		err = s.Producer.SendMessageToTopic(ctx, s.Topic, []byte(reading.ID), data, headers...)
+	} else {
+		err = s.Producer.SendMessageWithKey(ctx, reading.ID, data, headers...)
+	}
+	if err != nil {
+		s.Logger.Error("Error sending sensor reading", "error", err)
+		if s.Metrics != nil {
+			s.Metrics.SensorReadingErrors.Inc()
+		}
+		return err
+	}
+	s.Anomalies.record(anomaly)
+
+	// Update metrics
+	if s.Metrics != nil {
+		s.Metrics.SensorReadingsTotal.Inc()
+		s.Metrics.SensorReadingBytes.Add(float64(len(data)))
+		s.Metrics.SensorReadingLatency.Observe(time.Since(startTime).Seconds())
+	}
+	return nil
+}
+
+// NewSensor creates a new virtual sensor; logger may be nil
+func NewSensor(id string, producer *kafka.Producer, metrics *metrics.SensorProducerMetrics, logger *slog.Logger) *Sensor {
+	return &Sensor{
+		ID:       id,
+		Producer: producer,
+		Metrics:  metrics,
+		Logger:   logging.OrDefault(logger).With("sensor_id", id),
+		Profile:  UniformProfile,
+	}
+}
+
+// run is the state a sensor's readings evolve from while it is scheduled
+type run struct {
+	state      *profileState
+	device     *deviceState
+	position   *positionState
+	generation int
+}
+
+// newRun starts the sensor's profile, device and position at now
+func (s *Sensor) newRun(now time.Time) *run {
+	return &run{
+		state:    newProfileState(s.Profile, now),
+		device:   newDeviceState(now),
+		position: newPositionState(s.Home, s.DriftSpeed, now),
+	}
+}
+
+// tick sends the sensor's next reading at now, unless a scenario took it
+// offline; ctx bounds the send. It returns the producer's error.
+func (s *Sensor) tick(ctx context.Context, r *run, now time.Time) error {
+	current := s.currentConditions()
+	if current.offline {
+		return nil
+	}
+	if current.generation != r.generation {
+		profile := s.Profile
+		if current.profile != nil {
+			profile = *current.profile
+		}
+		r.state, r.generation = newProfileState(profile, now), current.generation
+	}
+
+	// Generate the next reading of the sensor's profile
+	reading := s.generateReading(r.state, r.device, r.position, current.ambient, now)
+	anomaly := s.Anomalies.next()
+	injectReading(anomaly, reading)
+	return s.send(ctx, reading, anomaly)
+}
+
+// format returns the wire format the sensor's readings are encoded in
+func (s *Sensor) format() string {
+	if s.Serializer == nil {
+		return model.FormatJSON
+	}
+	return s.Serializer.Format()
+}
+
+// serialize encodes a reading with the sensor's serializer
+func (s *Sensor) serialize(reading *model.SensorReading) ([]byte, error) {
+	if s.Serializer == nil {
+		return model.SerializeSensorReading(reading)
+	}
+	return s.Serializer.Serialize(reading)
+}
+
+// generateReading generates a sensor reading following the profile state,
+// shifted by the ambient ramp, with the device's battery, signal strength and
+// position
+func (s *Sensor) generateReading(state *profileState, device *deviceState, position *positionState, ambient ramp, now time.Time) *model.SensorReading {
+	temperature, humidity := state.next(now)
+	shiftTemperature, shiftHumidity := ambient.at(now)
+	temperature += float32(shiftTemperature)
+	humidity += float32(shiftHumidity)
+	if humidity < 0 {
+		humidity = 0
+	} else if humidity > 100 {
+		humidity = 100
+	}
+
+	reading := model.NewSensorReading(
+		now.UnixMilli(),
+		temperature,
+		humidity,
+	)
+	// Readings carry the ID of their sensor, which consumers key per-sensor
+	// state, thresholds and rows by
+	reading.ID = s.ID
+	reading.Site = s.Site
+	reading.Zone = s.Zone
+	reading.Location = position.next(now)
+	battery, rssi := device.next(now)
+	reading.BatteryPct, reading.RSSI = &battery, &rssi
+	return reading
+}
+
+// currentConditions returns the conditions set by a scenario
+func (s *Sensor) currentConditions() conditions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conditions
+}
+
+// setOffline stops or resumes sending readings
+func (s *Sensor) setOffline(offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conditions.offline = offline
+}
+
+// isOffline reports whether a scenario took the sensor offline
+func (s *Sensor) isOffline() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conditions.offline
+}
+
+// setProfile replaces the sensor's profile until the conditions are reset
+func (s *Sensor) setProfile(profile Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conditions.profile = &profile
+	s.conditions.generation++
+}
+
+// setAmbient ramps the shift of the readings from its current value to the
+// given one over a duration starting at now
+func (s *Sensor) setAmbient(temperature, humidity float64, now time.Time, over time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fromTemperature, fromHumidity := s.conditions.ambient.at(now)
+	s.conditions.ambient = ramp{
+		fromTemperature: fromTemperature,
+		fromHumidity:    fromHumidity,
+		toTemperature:   temperature,
+		toHumidity:      humidity,
+		start:           now,
+		over:            over,
+	}
+}
+
+// resetConditions undoes every change a scenario made
+func (s *Sensor) resetConditions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conditions = conditions{generation: s.conditions.generation + 1}
+}
@@ -0,0 +1,418 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/example/iot-sensor-fleet/internal/storage/parquet"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of archive objects
+const (
+	CompressionZstd   = "zstd"
+	CompressionGzip   = "gzip"
+	CompressionSnappy = "snappy"
+)
+
+// Formats of archive objects
+const (
+	ArchiveFormatJSON    = "json"
+	ArchiveFormatParquet = "parquet"
+)
+
+// ArchiveManifestVersion is the version of the manifest format written
+const ArchiveManifestVersion = 1
+
+// archiveManifestDir holds the manifests under the prefix. Spark, Trino and
+// Hive skip directories starting with an underscore, so manifests do not
+// show up as data when the prefix is read as a table.
+const archiveManifestDir = "_manifests"
+
+// ArchiveConfig configures a cold-storage archive sink
+type ArchiveConfig struct {
+	// Prefix is the key prefix objects are written under
+	Prefix string
+	// Tenant selects the archive encryption key
+	Tenant string
+	// Format is ArchiveFormatJSON (default), compressed NDJSON objects, or
+	// ArchiveFormatParquet, Parquet objects with compressed column chunks
+	Format string
+	// Compression is CompressionZstd (default) or CompressionGzip, or for
+	// Parquet also CompressionSnappy
+	Compression string
+	// RowGroupSize is the number of readings per Parquet row group
+	RowGroupSize int
+	// BatchSize is the number of readings that triggers an upload
+	BatchSize int
+	// FlushInterval bounds how long a reading waits for its batch to fill
+	FlushInterval time.Duration
+	// Timeout bounds the uploads of one batch
+	Timeout time.Duration
+	// Logger receives uploaded objects and failed batches (optional)
+	Logger *slog.Logger
+}
+
+// ArchiveRecord is a reading to archive with the Kafka message it came from
+type ArchiveRecord struct {
+	Reading   *model.SensorReading
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// ArchiveManifest describes the objects one batch was uploaded as. It is
+// written once every object of the batch is uploaded, so an object no
+// manifest lists was left behind by a failed upload, and a restore can check
+// each object against its checksum and count and each partition's offset
+// range for gaps.
+type ArchiveManifest struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Compression string    `json:"compression"`
+	Encryption  string    `json:"encryption"`
+	// Count is the number of readings in the batch
+	Count      int                     `json:"count"`
+	Objects    []ArchiveObject         `json:"objects"`
+	Partitions []ArchivePartitionRange `json:"partitions"`
+}
+
+// ArchiveObject is one object of a manifest
+type ArchiveObject struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+	// Bytes and SHA256 are the size and hex SHA-256 of the object as stored,
+	// after compression and encryption
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+	// MinTimestamp and MaxTimestamp bound the readings' timestamps in Unix ms
+	MinTimestamp int64 `json:"min_ts"`
+	MaxTimestamp int64 `json:"max_ts"`
+	// Sensors is the sorted IDs of the sensors with readings in the object
+	Sensors []string `json:"sensors,omitempty"`
+}
+
+// ArchivePartitionRange is the offsets of a partition a batch archived.
+// Count is below the size of the range when messages in it were not
+// archived, such as undecodable ones.
+type ArchivePartitionRange struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Count     int    `json:"count"`
+	MinOffset int64  `json:"min_offset"`
+	MaxOffset int64  `json:"max_offset"`
+}
+
+// Verify checks an object's stored body against its size and checksum
+func (o ArchiveObject) Verify(body []byte) error {
+	if len(body) != o.Bytes {
+		return fmt.Errorf("object %s is %d bytes, expected %d", o.Key, len(body), o.Bytes)
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != o.SHA256 {
+		return fmt.Errorf("object %s does not match its SHA-256 checksum", o.Key)
+	}
+	return nil
+}
+
+// ArchiveSink writes readings to an object store as zstd- or gzip-compressed
+// NDJSON or as Parquet, one object per batch and hour, under Hive-style
+// partitions of the reading time:
+// <prefix>/dt=YYYY-MM-DD/hour=HH/readings-<uuid>.ndjson.zst or .parquet.
+// Each batch is described by a manifest written after its objects, under
+// <prefix>/_manifests/ by the hour of the upload. Like the other sinks, Write
+// blocks until the reading's batch is uploaded. A batch that fails is retried
+// as a whole, so a reading can be archived more than once.
+type ArchiveSink struct {
+	store     storage.ObjectStore
+	encryptor *encryption.Encryptor
+	config    ArchiveConfig
+	metrics   *Metrics
+	batcher   *batcher[ArchiveRecord]
+	zstd      *zstd.Encoder
+
+	// catalog optionally records every uploaded batch
+	catalog ArchiveCatalog
+	// table optionally maintains the archive's table metadata
+	table *archiveTableWriter
+}
+
+// NewArchiveSink creates a new archive sink; metrics may be nil
+func NewArchiveSink(store storage.ObjectStore, encryptor *encryption.Encryptor, config ArchiveConfig, metrics *Metrics) (*ArchiveSink, error) {
+	if config.Format == "" {
+		config.Format = ArchiveFormatJSON
+	}
+	if config.Compression == "" {
+		config.Compression = CompressionZstd
+	}
+	switch {
+	case config.Format == ArchiveFormatParquet:
+		if config.Compression != CompressionZstd && config.Compression != CompressionGzip && config.Compression != CompressionSnappy {
+			return nil, fmt.Errorf("unknown compression %q for parquet: expected %s, %s or %s",
+				config.Compression, CompressionZstd, CompressionGzip, CompressionSnappy)
+		}
+	case config.Format != ArchiveFormatJSON:
+		return nil, fmt.Errorf("unknown archive format %q: expected %s or %s", config.Format, ArchiveFormatJSON, ArchiveFormatParquet)
+	case config.Compression != CompressionZstd && config.Compression != CompressionGzip:
+		return nil, fmt.Errorf("unknown compression %q: expected %s or %s", config.Compression, CompressionZstd, CompressionGzip)
+	}
+	if config.RowGroupSize <= 0 {
+		config.RowGroupSize = parquet.DefaultRowGroupSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Minute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Minute
+	}
+	config.Logger = logging.OrDefault(config.Logger)
+
+	s := &ArchiveSink{
+		store:     store,
+		encryptor: encryptor,
+		config:    config,
+		metrics:   metrics,
+	}
+	if config.Format == ArchiveFormatJSON && config.Compression == CompressionZstd {
+		// EncodeAll is safe for concurrent use, so one encoder serves every batch
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		s.zstd = encoder
+	}
+	s.batcher = newBatcher(config.BatchSize, config.FlushInterval, s.flush)
+	return s, nil
+}
+
+// Start starts the batching loop
+func (s *ArchiveSink) Start() {
+	s.batcher.start()
+}
+
+// Stop uploads the pending batch and stops the batching loop
+func (s *ArchiveSink) Stop() {
+	s.batcher.stop()
+}
+
+// SetCatalog records every batch in catalog once its manifest is uploaded.
+// A batch that cannot be recorded fails and is uploaded again.
+func (s *ArchiveSink) SetCatalog(catalog ArchiveCatalog) {
+	s.catalog = catalog
+}
+
+// Write queues a reading and waits until its batch has been uploaded or ctx is done
+func (s *ArchiveSink) Write(ctx context.Context, record ArchiveRecord) error {
+	return s.batcher.write(ctx, record)
+}
+
+// flush uploads a batch as one object per hour and records the result
+func (s *ArchiveSink) flush(batch []ArchiveRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.upload(ctx, batch)
+	s.metrics.observeFlush(start, len(batch), int64(len(batch)), err)
+	if err != nil {
+		s.config.Logger.Error("Failed to archive batch", "readings", len(batch), "error", err)
+	}
+	return err
+}
+
+// upload groups a batch by the hour of each reading, writes one object per
+// hour and then the batch's manifest
+func (s *ArchiveSink) upload(ctx context.Context, batch []ArchiveRecord) error {
+	hours := make(map[time.Time][]*model.SensorReading)
+	for _, record := range batch {
+		hour := time.UnixMilli(record.Reading.Timestamp).UTC().Truncate(time.Hour)
+		hours[hour] = append(hours[hour], record.Reading)
+	}
+
+	keys := make([]time.Time, 0, len(hours))
+	for hour := range hours {
+		keys = append(keys, hour)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Before(keys[j]) })
+
+	manifest := &ArchiveManifest{
+		Version:     ArchiveManifestVersion,
+		Compression: s.config.Compression,
+		Encryption:  s.encryptor.Mode(),
+		Count:       len(batch),
+		Partitions:  partitionRanges(batch),
+	}
+	for _, hour := range keys {
+		object, err := s.put(ctx, hour, hours[hour])
+		if err != nil {
+			return err
+		}
+		manifest.Objects = append(manifest.Objects, object)
+	}
+	if err := s.publishTable(ctx); err != nil {
+		return err
+	}
+	return s.putManifest(ctx, manifest)
+}
+
+// put encodes, compresses, encrypts and uploads the readings of one hour
+func (s *ArchiveSink) put(ctx context.Context, hour time.Time, readings []*model.SensorReading) (ArchiveObject, error) {
+	object := ArchiveObject{Count: len(readings), MinTimestamp: readings[0].Timestamp, MaxTimestamp: readings[0].Timestamp}
+	sensors := make(map[string]struct{})
+	for _, reading := range readings {
+		object.MinTimestamp = min(object.MinTimestamp, reading.Timestamp)
+		object.MaxTimestamp = max(object.MaxTimestamp, reading.Timestamp)
+		sensors[reading.ID] = struct{}{}
+	}
+	for id := range sensors {
+		object.Sensors = append(object.Sensors, id)
+	}
+	sort.Strings(object.Sensors)
+
+	compressed, contentType, extension, err := s.encode(readings)
+	if err != nil {
+		return object, err
+	}
+
+	body, headers, err := s.encryptor.Encrypt(ctx, s.config.Tenant, compressed)
+	if err != nil {
+		return object, fmt.Errorf("failed to encrypt archive: %w", err)
+	}
+	if s.encryptor.Mode() == encryption.ModeEnvelope {
+		headers["Content-Type"] = "application/octet-stream"
+	} else {
+		headers["Content-Type"] = contentType
+	}
+
+	object.Key = ArchiveKey(s.config.Prefix, hour, "readings-"+uuid.NewString()+extension)
+	if err := s.store.Put(ctx, object.Key, body, headers); err != nil {
+		return object, err
+	}
+	sum := sha256.Sum256(body)
+	object.Bytes, object.SHA256 = len(body), hex.EncodeToString(sum[:])
+
+	s.config.Logger.Info("Archived readings", "readings", len(readings), "key", object.Key)
+	return object, nil
+}
+
+// encode encodes readings in the configured format and compression and
+// returns them with their content type and file extension
+func (s *ArchiveSink) encode(readings []*model.SensorReading) ([]byte, string, string, error) {
+	if s.config.Format == ArchiveFormatParquet {
+		data, err := parquet.Encode(readings, parquet.Config{Compression: s.config.Compression, RowGroupSize: s.config.RowGroupSize})
+		if err != nil {
+			return nil, "", "", fmt.Errorf("failed to encode parquet archive: %w", err)
+		}
+		return data, parquet.ContentType, parquet.Extension, nil
+	}
+
+	var ndjson bytes.Buffer
+	encoder := json.NewEncoder(&ndjson)
+	for _, reading := range readings {
+		if err := encoder.Encode(reading); err != nil {
+			return nil, "", "", fmt.Errorf("failed to encode reading %s: %w", reading.ID, err)
+		}
+	}
+	compressed, contentType, extension, err := s.compress(ndjson.Bytes())
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to compress archive: %w", err)
+	}
+	return compressed, contentType, extension, nil
+}
+
+// compress compresses NDJSON with the configured compression and returns it
+// with its content type and file extension
+func (s *ArchiveSink) compress(data []byte) ([]byte, string, string, error) {
+	if s.zstd != nil {
+		return s.zstd.EncodeAll(data, nil), "application/zstd", ".ndjson.zst", nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, "", "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", "", err
+	}
+	return buf.Bytes(), "application/gzip", ".ndjson.gz", nil
+}
+
+// putManifest uploads the manifest of a batch whose objects are uploaded and
+// records it in the catalog
+func (s *ArchiveSink) putManifest(ctx context.Context, manifest *ArchiveManifest) error {
+	manifest.CreatedAt = time.Now().UTC()
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode archive manifest: %w", err)
+	}
+
+	key := ArchiveManifestKey(s.config.Prefix, manifest.CreatedAt, "manifest-"+uuid.NewString()+".json")
+	if err := s.store.Put(ctx, key, body, map[string]string{"Content-Type": "application/json"}); err != nil {
+		return fmt.Errorf("failed to upload archive manifest: %w", err)
+	}
+	if s.catalog != nil {
+		return s.catalog.Record(ctx, key, manifest)
+	}
+	return nil
+}
+
+// partitionRanges returns the offset range of every partition in a batch,
+// ordered by topic and partition
+func partitionRanges(batch []ArchiveRecord) []ArchivePartitionRange {
+	type partition struct {
+		topic string
+		id    int32
+	}
+	ranges := make(map[partition]*ArchivePartitionRange)
+	for _, record := range batch {
+		key := partition{record.Topic, record.Partition}
+		r, ok := ranges[key]
+		if !ok {
+			r = &ArchivePartitionRange{Topic: record.Topic, Partition: record.Partition, MinOffset: record.Offset, MaxOffset: record.Offset}
+			ranges[key] = r
+		}
+		r.Count++
+		r.MinOffset = min(r.MinOffset, record.Offset)
+		r.MaxOffset = max(r.MaxOffset, record.Offset)
+	}
+
+	sorted := make([]ArchivePartitionRange, 0, len(ranges))
+	for _, r := range ranges {
+		sorted = append(sorted, *r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Topic != sorted[j].Topic {
+			return sorted[i].Topic < sorted[j].Topic
+		}
+		return sorted[i].Partition < sorted[j].Partition
+	})
+	return sorted
+}
+
+// ArchiveKey returns the key of an archive object for the given hour
+func ArchiveKey(prefix string, hour time.Time, name string) string {
+	hour = hour.UTC()
+	return path.Join(prefix, "dt="+hour.Format("2006-01-02"), "hour="+hour.Format("15"), name)
+}
+
+// ArchiveManifestKey returns the key of a manifest written at the given time
+func ArchiveManifestKey(prefix string, at time.Time, name string) string {
+	return ArchiveKey(path.Join(prefix, archiveManifestDir), at, name)
+}
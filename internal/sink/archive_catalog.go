@@ -0,0 +1,248 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/lib/pq"
+)
+
+// ArchiveCatalog records the manifests of uploaded batches
+type ArchiveCatalog interface {
+	Record(ctx context.Context, manifestKey string, manifest *ArchiveManifest) error
+}
+
+// PostgresArchiveCatalog keeps the archive catalog in PostgreSQL: every
+// object with its checksum in archive_catalog, the sensors with readings in
+// each object in archive_catalog_sensors, and the Kafka offsets every batch
+// covered in archive_catalog_offsets. Archived data can then be checked for
+// corruption and gaps, and located by sensor and time, without listing the
+// bucket.
+type PostgresArchiveCatalog struct {
+	db *sql.DB
+}
+
+// NewPostgresArchiveCatalog creates a catalog on db
+func NewPostgresArchiveCatalog(db *sql.DB) *PostgresArchiveCatalog {
+	return &PostgresArchiveCatalog{db: db}
+}
+
+// CatalogObject is an object recorded in the catalog
+type CatalogObject struct {
+	ArchiveObject
+	ManifestKey string
+	Compression string
+	Encryption  string
+}
+
+// Record adds a batch's objects and offset ranges. Recording a manifest
+// again changes nothing.
+func (c *PostgresArchiveCatalog) Record(ctx context.Context, manifestKey string, manifest *ArchiveManifest) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin catalog transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, object := range manifest.Objects {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO archive_catalog (object_key, manifest_key, readings, bytes, sha256, min_ts, max_ts, compression, encryption)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (object_key) DO NOTHING
+		`, object.Key, manifestKey, object.Count, object.Bytes, object.SHA256, object.MinTimestamp, object.MaxTimestamp,
+			manifest.Compression, manifest.Encryption); err != nil {
+			return fmt.Errorf("failed to record archive object %s: %w", object.Key, err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO archive_catalog_sensors (object_key, sensor_id)
+			SELECT $1, unnest($2::TEXT[])
+			ON CONFLICT (sensor_id, object_key) DO NOTHING
+		`, object.Key, pq.Array(object.Sensors)); err != nil {
+			return fmt.Errorf("failed to record sensors of archive object %s: %w", object.Key, err)
+		}
+	}
+	for _, r := range manifest.Partitions {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO archive_catalog_offsets (manifest_key, topic, partition, readings, min_offset, max_offset)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (manifest_key, topic, partition) DO NOTHING
+		`, manifestKey, r.Topic, r.Partition, r.Count, r.MinOffset, r.MaxOffset); err != nil {
+			return fmt.Errorf("failed to record offsets of %s/%d: %w", r.Topic, r.Partition, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit catalog transaction: %w", err)
+	}
+	return nil
+}
+
+// Objects returns the objects holding readings timestamped in [from, to),
+// oldest first; a zero time leaves that end open
+func (c *PostgresArchiveCatalog) Objects(ctx context.Context, from, to time.Time) ([]CatalogObject, error) {
+	query := `
+		SELECT object_key, manifest_key, readings, bytes, sha256, min_ts, max_ts, compression, encryption
+		FROM archive_catalog
+		WHERE ($1::BIGINT IS NULL OR max_ts >= $1) AND ($2::BIGINT IS NULL OR min_ts < $2)
+		ORDER BY min_ts, object_key`
+	rows, err := c.db.QueryContext(ctx, query, unixMilliOrNull(from), unixMilliOrNull(to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archive catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var objects []CatalogObject
+	for rows.Next() {
+		var o CatalogObject
+		if err := rows.Scan(&o.Key, &o.ManifestKey, &o.Count, &o.Bytes, &o.SHA256, &o.MinTimestamp, &o.MaxTimestamp,
+			&o.Compression, &o.Encryption); err != nil {
+			return nil, fmt.Errorf("failed to scan archive object: %w", err)
+		}
+		objects = append(objects, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive catalog: %w", err)
+	}
+	return objects, nil
+}
+
+// Ranges returns every recorded offset range, by topic, partition and offset
+func (c *PostgresArchiveCatalog) Ranges(ctx context.Context) ([]ArchivePartitionRange, error) {
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT topic, partition, readings, min_offset, max_offset
+		FROM archive_catalog_offsets
+		ORDER BY topic, partition, min_offset
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archive offsets: %w", err)
+	}
+	defer rows.Close()
+
+	var ranges []ArchivePartitionRange
+	for rows.Next() {
+		var r ArchivePartitionRange
+		if err := rows.Scan(&r.Topic, &r.Partition, &r.Count, &r.MinOffset, &r.MaxOffset); err != nil {
+			return nil, fmt.Errorf("failed to scan archive offsets: %w", err)
+		}
+		ranges = append(ranges, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive offsets: %w", err)
+	}
+	return ranges, nil
+}
+
+// unixMilliOrNull returns t in Unix milliseconds, or nil for the zero time
+func unixMilliOrNull(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+// ArchiveProblem is an object that failed verification
+type ArchiveProblem struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// OffsetGap is a run of offsets of a partition that no batch archived,
+// From and To included
+type OffsetGap struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+}
+
+// ArchiveVerification is the outcome of VerifyArchive
+type ArchiveVerification struct {
+	Objects  int   `json:"objects"`
+	Readings int   `json:"readings"`
+	Bytes    int64 `json:"bytes"`
+	// Missing objects are in the catalog but not in the bucket, and corrupt
+	// ones do not match their size or checksum
+	Missing []ArchiveProblem `json:"missing,omitempty"`
+	Corrupt []ArchiveProblem `json:"corrupt,omitempty"`
+	Gaps    []OffsetGap      `json:"gaps,omitempty"`
+}
+
+// OK reports whether verification found no problem
+func (v *ArchiveVerification) OK() bool {
+	return len(v.Missing) == 0 && len(v.Corrupt) == 0 && len(v.Gaps) == 0
+}
+
+// VerifyArchive downloads and re-hashes every cataloged object with readings
+// in [from, to), and looks for offsets between the first and last archived
+// offset of each partition that no batch covered. Gaps are checked over the
+// whole catalog, since offsets are not tied to reading times. A gap is either
+// lost data or messages the archiver skipped as undecodable, which the
+// detector dead-lettered.
+func VerifyArchive(ctx context.Context, catalog *PostgresArchiveCatalog, store storage.ObjectReader, from, to time.Time) (*ArchiveVerification, error) {
+	objects, err := catalog.Objects(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ArchiveVerification{}
+	for _, object := range objects {
+		body, err := store.Get(ctx, object.Key)
+		if errors.Is(err, storage.ErrNotFound) {
+			result.Missing = append(result.Missing, ArchiveProblem{Key: object.Key, Reason: "object not found"})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", object.Key, err)
+		}
+
+		result.Objects++
+		result.Bytes += int64(len(body))
+		if err := object.Verify(body); err != nil {
+			result.Corrupt = append(result.Corrupt, ArchiveProblem{Key: object.Key, Reason: err.Error()})
+			continue
+		}
+		result.Readings += object.Count
+	}
+
+	ranges, err := catalog.Ranges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.Gaps = offsetGaps(ranges)
+	return result, nil
+}
+
+// offsetGaps returns the offsets between ranges of the same partition that
+// none of them covers. Retried batches make ranges overlap, which is fine.
+func offsetGaps(ranges []ArchivePartitionRange) []OffsetGap {
+	sort.SliceStable(ranges, func(i, j int) bool {
+		a, b := ranges[i], ranges[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		return a.MinOffset < b.MinOffset
+	})
+
+	var gaps []OffsetGap
+	// covered is the highest offset of the partition any range so far covers
+	var covered int64
+	for i, r := range ranges {
+		if i == 0 || r.Topic != ranges[i-1].Topic || r.Partition != ranges[i-1].Partition {
+			covered = r.MaxOffset
+			continue
+		}
+		if r.MinOffset > covered+1 {
+			gaps = append(gaps, OffsetGap{Topic: r.Topic, Partition: r.Partition, From: covered + 1, To: r.MinOffset - 1})
+		}
+		covered = max(covered, r.MaxOffset)
+	}
+	return gaps
+}
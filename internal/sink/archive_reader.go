@@ -0,0 +1,150 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/example/iot-sensor-fleet/internal/storage/parquet"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveSource lists and downloads the objects of an archive
+type ArchiveSource interface {
+	storage.ObjectLister
+	// GetWithHeaders downloads an object, sending headers, and returns it
+	// with its response headers
+	GetWithHeaders(ctx context.Context, key string, headers map[string]string) ([]byte, map[string]string, error)
+}
+
+// ArchiveReader reads archived readings back from the object store by
+// listing the hourly partitions, without the archive catalog
+type ArchiveReader struct {
+	source    ArchiveSource
+	encryptor *encryption.Encryptor
+	prefix    string
+	tenant    string
+	zstd      *zstd.Decoder
+}
+
+// NewArchiveReader creates a reader of the archive under prefix. encryptor
+// decrypts envelope-encrypted objects and supplies the SSE-C key of tenant.
+func NewArchiveReader(source ArchiveSource, encryptor *encryption.Encryptor, prefix, tenant string) (*ArchiveReader, error) {
+	// DecodeAll is safe for concurrent use, so one decoder serves every object
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+	return &ArchiveReader{
+		source:    source,
+		encryptor: encryptor,
+		prefix:    prefix,
+		tenant:    tenant,
+		zstd:      decoder,
+	}, nil
+}
+
+// Close releases the reader's decoder
+func (r *ArchiveReader) Close() {
+	r.zstd.Close()
+}
+
+// Read calls fn with every archived reading timestamped in [from, to), an
+// hour at a time. Objects are partitioned by reading time, so sorting each
+// hour's readings by timestamp delivers the whole range in order while only
+// one hour is held in memory. Readings archived twice are delivered twice,
+// as redelivered messages are. It returns the number of objects read.
+func (r *ArchiveReader) Read(ctx context.Context, from, to time.Time, fn func(*model.SensorReading) error) (int, error) {
+	objects := 0
+	for hour := from.UTC().Truncate(time.Hour); hour.Before(to); hour = hour.Add(time.Hour) {
+		keys, err := r.source.List(ctx, ArchiveKey(r.prefix, hour, "")+"/")
+		if err != nil {
+			return objects, err
+		}
+
+		var readings []*model.SensorReading
+		for _, key := range keys {
+			if !strings.HasSuffix(key, ".ndjson.zst") && !strings.HasSuffix(key, ".ndjson.gz") && !strings.HasSuffix(key, parquet.Extension) {
+				continue
+			}
+			objectReadings, err := r.ReadObject(ctx, key)
+			if err != nil {
+				return objects, err
+			}
+			objects++
+			for _, reading := range objectReadings {
+				if ts := time.UnixMilli(reading.Timestamp); !ts.Before(from) && ts.Before(to) {
+					readings = append(readings, reading)
+				}
+			}
+		}
+
+		sort.SliceStable(readings, func(i, j int) bool { return readings[i].Timestamp < readings[j].Timestamp })
+		for _, reading := range readings {
+			if err := fn(reading); err != nil {
+				return objects, err
+			}
+		}
+	}
+	return objects, nil
+}
+
+// ReadObject downloads, decrypts, decompresses and decodes an archive object
+func (r *ArchiveReader) ReadObject(ctx context.Context, key string) ([]*model.SensorReading, error) {
+	headers, err := r.encryptor.SSECHeaders(r.tenant)
+	if err != nil {
+		return nil, err
+	}
+	body, metadata, err := r.source.GetWithHeaders(ctx, key, headers)
+	if err != nil {
+		return nil, err
+	}
+	body, err = r.encryptor.Decrypt(ctx, body, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+	}
+
+	if strings.HasSuffix(key, parquet.Extension) {
+		readings, err := parquet.Read(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+		}
+		return readings, nil
+	}
+
+	var ndjson io.Reader
+	if strings.HasSuffix(key, ".ndjson.zst") {
+		data, err := r.zstd.DecodeAll(body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", key, err)
+		}
+		ndjson = bytes.NewReader(data)
+	} else {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", key, err)
+		}
+		defer zr.Close()
+		ndjson = zr
+	}
+
+	var readings []*model.SensorReading
+	decoder := json.NewDecoder(ndjson)
+	for decoder.More() {
+		var reading model.SensorReading
+		if err := decoder.Decode(&reading); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+		}
+		readings = append(readings, &reading)
+	}
+	return readings, nil
+}
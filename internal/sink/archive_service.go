@@ -0,0 +1,182 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ArchiveService consumes raw readings and archives them to MinIO
+type ArchiveService struct {
+	Sink     *ArchiveSink
+	consumer *kafka.Consumer
+	decoder  *model.ReadingDecoder
+	metrics  *Metrics
+	logger   *slog.Logger
+
+	// dltProducer sends readings that failed every attempt to the DLT
+	// when CONSUMER_DEAD_LETTER is set
+	dltProducer *kafka.Producer
+
+	// postgres holds the archive catalog when ARCHIVE_CATALOG_ENABLED is set
+	postgres *db.PostgresDB
+}
+
+// NewArchiveService creates the archive sink and its consumer.
+// Metrics are registered on registry.
+func NewArchiveService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*ArchiveService, error) {
+	logger = logging.OrDefault(logger).With("component", "cold_archiver")
+	decoder, err := newReadingDecoder(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := storage.NewS3StoreFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := encryption.NewEncryptorFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive encryption configuration: %w", err)
+	}
+
+	metrics := NewMetrics("iot", "cold_archiver", registry)
+	archive, err := NewArchiveSink(store, encryptor, ArchiveConfig{
+		Prefix:        cfg.ArchivePrefix,
+		Tenant:        cfg.ArchiveDefaultTenant,
+		Format:        cfg.ArchiveFormat,
+		Compression:   cfg.ArchiveCompression,
+		RowGroupSize:  cfg.ArchiveParquetRowGroupSize,
+		BatchSize:     cfg.ArchiveBatchSize,
+		FlushInterval: cfg.ArchiveFlushInterval,
+		Timeout:       cfg.StoreTimeout,
+		Logger:        logger,
+	}, metrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive sink: %w", err)
+	}
+	archive.SetTable(cfg.MinioBucket, store)
+	s := &ArchiveService{
+		Sink:    archive,
+		decoder: decoder,
+		metrics: metrics,
+		logger:  logger,
+	}
+	if cfg.ArchiveCatalogEnabled {
+		if s.postgres, err = db.NewPostgresDB(cfg); err != nil {
+			return nil, fmt.Errorf("failed to connect catalog database: %w", err)
+		}
+		archive.SetCatalog(NewPostgresArchiveCatalog(s.postgres.DB()))
+	}
+
+	// Handlers wait for their batch to be uploaded, which can take up to the
+	// flush interval, so the handler and drain timeouts must leave room for it
+	handlerTimeout := cfg.ConsumerHandlerTimeout
+	if minimum := cfg.ArchiveFlushInterval + cfg.StoreTimeout; handlerTimeout > 0 && handlerTimeout < minimum {
+		handlerTimeout = minimum
+	}
+	drainTimeout := cfg.ConsumerDrainTimeout
+	if minimum := cfg.ArchiveFlushInterval + cfg.StoreTimeout; drainTimeout > 0 && drainTimeout < minimum {
+		drainTimeout = minimum
+	}
+
+	// Send readings that failed every attempt to the DLT when enabled
+	deadLetter, dltProducer, err := kafka.NewDeadLetterFromConfig(cfg, []string{config.TopicKeySensorRaw}, config.RetryComponentColdArchiver, registry, logger)
+	if err != nil {
+		if s.postgres != nil {
+			s.postgres.Close()
+		}
+		return nil, err
+	}
+	s.dltProducer = dltProducer
+
+	consumer, err := kafka.NewConsumer(
+		kafka.ConsumerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			GroupID:         cfg.ArchiveGroupID,
+			Topics:          cfg.RawTopics(),
+			TopicWeights:    cfg.RawTopicWeights(),
+			OffsetInitial:   cfg.ConsumerOffsetInitial,
+			ReturnErrors:    cfg.ConsumerReturnErrors,
+			Metrics:         kafka.NewConsumerMetrics("iot", "cold_archiver_consumer", registry),
+			Version:         cfg.KafkaVersion,
+			HandlerTimeout:  handlerTimeout,
+			DrainTimeout:    drainTimeout,
+			HopHeaders:      cfg.HopHeaders,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ConsumerRetryFromConfig(cfg, config.RetryComponentColdArchiver),
+			DeadLetter:      deadLetter,
+			LagInterval:     cfg.ConsumerLagInterval,
+			BalanceStrategy: cfg.ConsumerBalanceStrategy,
+			WorkerPoolSize:  cfg.ArchiveBatchSize,
+			InflightBudget:  kafka.InflightBudgetFromConfig(cfg, registry),
+			Schemas:         kafka.SchemaTrackerFromConfig(cfg, registry),
+			Logger:          logger,
+		},
+		s.HandleMessage,
+	)
+	if err != nil {
+		if dltProducer != nil {
+			dltProducer.Close()
+		}
+		if s.postgres != nil {
+			s.postgres.Close()
+		}
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	s.consumer = consumer
+
+	return s, nil
+}
+
+// HandleMessage decodes a raw reading and waits for it to be archived.
+// Undecodable messages are skipped; the detector routes them to the DLT.
+func (s *ArchiveService) HandleMessage(ctx context.Context, message *sarama.ConsumerMessage) error {
+	reading, _, err := s.decoder.DecodeFormat(message.Topic, message.Value, kafka.PayloadFormat(message))
+	if err != nil {
+		s.metrics.DecodeErrors.Inc()
+		s.logger.With(kafka.MessageLogAttrs(message)...).Warn("Skipping undecodable reading", "error", err)
+		return nil
+	}
+	kafka.AddInflightBytes(ctx, reading.MemorySize())
+
+	record := ArchiveRecord{Reading: reading, Topic: message.Topic, Partition: message.Partition, Offset: message.Offset}
+	if err := s.Sink.Write(ctx, record); err != nil {
+		return fmt.Errorf("failed to archive reading %s: %w", reading.ID, err)
+	}
+
+	if hops := kafka.HopsFromContext(ctx); len(hops) > 0 {
+		s.metrics.Latency.Observe(time.Since(hops[0].At).Seconds())
+	}
+	return nil
+}
+
+// Start starts the sink and its consumer
+func (s *ArchiveService) Start() error {
+	s.Sink.Start()
+	return s.consumer.Start()
+}
+
+// Stop stops consuming, uploads the pending batch and closes the catalog
+// database
+func (s *ArchiveService) Stop() {
+	s.consumer.Stop()
+	s.Sink.Stop()
+	if s.dltProducer != nil {
+		s.dltProducer.Close()
+	}
+	if s.postgres != nil {
+		s.postgres.Close()
+	}
+}
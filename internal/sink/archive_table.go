@@ -0,0 +1,428 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+)
+
+// ArchiveTableVersion is the version of the table metadata format written
+const ArchiveTableVersion = 1
+
+// archiveTableDir holds the table metadata under the prefix; like the
+// manifests, it is skipped by query engines reading the prefix
+const archiveTableDir = "_table"
+
+// Query engines the archive table DDL is written for
+const (
+	EngineAthena = "athena"
+	EngineTrino  = "trino"
+)
+
+// ArchiveColumn is a column of the archive table with its Hive type
+type ArchiveColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ArchiveSchema is a version of the archive table's columns
+type ArchiveSchema struct {
+	ID        int             `json:"schema_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Columns   []ArchiveColumn `json:"columns"`
+}
+
+// ArchiveTable describes the archive as an external table partitioned by dt
+// and hour, so Athena and Trino can query it in place: the location, format
+// and partitioning, and every schema the readings have had. Schemas only
+// grow: a field added to the readings adds a column, and a removed field
+// keeps its column, since older objects still hold it.
+type ArchiveTable struct {
+	Version     int       `json:"version"`
+	Location    string    `json:"location"`
+	Format      string    `json:"format"`
+	Compression string    `json:"compression"`
+	Encryption  string    `json:"encryption"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// PartitionColumns are the Hive-style partitions of the object keys
+	PartitionColumns []ArchiveColumn `json:"partition_columns"`
+	CurrentSchemaID  int             `json:"current_schema_id"`
+	Schemas          []ArchiveSchema `json:"schemas"`
+}
+
+// ArchiveTableKey returns the key of the table metadata under prefix
+func ArchiveTableKey(prefix string) string {
+	return path.Join(prefix, archiveTableDir, "metadata.json")
+}
+
+// ReadingColumns returns the columns of archived readings, derived from the
+// JSON encoding of model.SensorReading so they follow the reading schema
+func ReadingColumns() []ArchiveColumn {
+	t := reflect.TypeOf(model.SensorReading{})
+	columns := make([]ArchiveColumn, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		columns = append(columns, ArchiveColumn{Name: name, Type: hiveType(field.Type)})
+	}
+	return columns
+}
+
+// hiveType returns the Hive type of a field's JSON encoding
+func hiveType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int64:
+		return "bigint"
+	case reflect.Int, reflect.Int32:
+		return "int"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	case reflect.Slice:
+		return "array<" + hiveType(t.Elem()) + ">"
+	case reflect.Struct:
+		fields := make([]string, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if field.IsExported() && name != "-" && name != "" {
+				fields = append(fields, name+":"+hiveType(field.Type))
+			}
+		}
+		return "struct<" + strings.Join(fields, ",") + ">"
+	}
+	return "string"
+}
+
+// CurrentSchema returns the schema with the current schema ID
+func (t *ArchiveTable) CurrentSchema() *ArchiveSchema {
+	for i := range t.Schemas {
+		if t.Schemas[i].ID == t.CurrentSchemaID {
+			return &t.Schemas[i]
+		}
+	}
+	return nil
+}
+
+// Evolve adds a schema for columns the current schema lacks and reports
+// whether it did. Columns may only be added: a column whose type changed
+// would make the objects written before unreadable, and is an error.
+func (t *ArchiveTable) Evolve(columns []ArchiveColumn, now time.Time) (bool, error) {
+	current := t.CurrentSchema()
+	if current == nil {
+		t.Schemas = append(t.Schemas, ArchiveSchema{ID: 1, CreatedAt: now, Columns: columns})
+		t.CurrentSchemaID = 1
+		return true, nil
+	}
+
+	types := make(map[string]string, len(current.Columns))
+	for _, column := range current.Columns {
+		types[column.Name] = column.Type
+	}
+	next := append([]ArchiveColumn(nil), current.Columns...)
+	for _, column := range columns {
+		existing, ok := types[column.Name]
+		switch {
+		case !ok:
+			next = append(next, column)
+		case existing != column.Type:
+			return false, fmt.Errorf("column %s changed type from %s to %s", column.Name, existing, column.Type)
+		}
+	}
+	if len(next) == len(current.Columns) {
+		return false, nil
+	}
+
+	t.CurrentSchemaID = current.ID + 1
+	t.Schemas = append(t.Schemas, ArchiveSchema{ID: t.CurrentSchemaID, CreatedAt: now, Columns: next})
+	return true, nil
+}
+
+// AddedColumns returns the columns of the current schema that schema
+// sinceID did not have
+func (t *ArchiveTable) AddedColumns(sinceID int) ([]ArchiveColumn, error) {
+	var since *ArchiveSchema
+	for i := range t.Schemas {
+		if t.Schemas[i].ID == sinceID {
+			since = &t.Schemas[i]
+		}
+	}
+	current := t.CurrentSchema()
+	if since == nil || current == nil {
+		return nil, fmt.Errorf("unknown schema %d", sinceID)
+	}
+
+	known := make(map[string]bool, len(since.Columns))
+	for _, column := range since.Columns {
+		known[column.Name] = true
+	}
+	var added []ArchiveColumn
+	for _, column := range current.Columns {
+		if !known[column.Name] {
+			added = append(added, column)
+		}
+	}
+	return added, nil
+}
+
+// CreateDDL returns the statement creating the table as name in engine.
+// Partitions are projected from the key layout, from the day of from (or of
+// the table's creation if zero) until now, so new hours are queryable
+// without registering them.
+func (t *ArchiveTable) CreateDDL(engine, name string, from time.Time) (string, error) {
+	current := t.CurrentSchema()
+	if current == nil {
+		return "", errors.New("table has no schema")
+	}
+	if from.IsZero() {
+		from = t.CreatedAt
+	}
+	firstDay := from.UTC().Format("2006-01-02")
+
+	var b strings.Builder
+	switch engine {
+	case EngineAthena:
+		fmt.Fprintf(&b, "CREATE EXTERNAL TABLE IF NOT EXISTS %s (\n", name)
+		for i, column := range current.Columns {
+			fmt.Fprintf(&b, "  `%s` %s%s\n", column.Name, column.Type, separator(i, len(current.Columns)))
+		}
+		b.WriteString(")\nPARTITIONED BY (`dt` string, `hour` string)\n")
+		if t.Format == ArchiveFormatParquet {
+			b.WriteString("STORED AS PARQUET\n")
+		} else {
+			b.WriteString("ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'\n")
+		}
+		fmt.Fprintf(&b, "LOCATION '%s/'\n", t.Location)
+		b.WriteString("TBLPROPERTIES (\n")
+		b.WriteString("  'projection.enabled' = 'true',\n")
+		b.WriteString("  'projection.dt.type' = 'date',\n")
+		b.WriteString("  'projection.dt.format' = 'yyyy-MM-dd',\n")
+		fmt.Fprintf(&b, "  'projection.dt.range' = '%s,NOW',\n", firstDay)
+		b.WriteString("  'projection.dt.interval' = '1',\n")
+		b.WriteString("  'projection.dt.interval.unit' = 'DAYS',\n")
+		b.WriteString("  'projection.hour.type' = 'integer',\n")
+		b.WriteString("  'projection.hour.range' = '0,23',\n")
+		b.WriteString("  'projection.hour.digits' = '2'\n")
+		b.WriteString(");\n")
+	case EngineTrino:
+		fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s (\n", name)
+		for _, column := range current.Columns {
+			trino, err := trinoType(column.Type)
+			if err != nil {
+				return "", fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			fmt.Fprintf(&b, "  %s %s,\n", column.Name, trino)
+		}
+		b.WriteString("  dt varchar WITH (partition_projection_type = 'date', partition_projection_format = 'yyyy-MM-dd',\n")
+		fmt.Fprintf(&b, "    partition_projection_range = ARRAY['%s', 'NOW'], partition_projection_interval = 1,\n", firstDay)
+		b.WriteString("    partition_projection_interval_unit = 'DAYS'),\n")
+		b.WriteString("  hour varchar WITH (partition_projection_type = 'integer', partition_projection_range = ARRAY['0', '23'],\n")
+		b.WriteString("    partition_projection_digits = 2)\n")
+		b.WriteString(") WITH (\n")
+		fmt.Fprintf(&b, "  format = '%s',\n", strings.ToUpper(t.Format))
+		fmt.Fprintf(&b, "  external_location = '%s',\n", t.Location)
+		b.WriteString("  partitioned_by = ARRAY['dt', 'hour'],\n")
+		b.WriteString("  partition_projection_enabled = true\n")
+		b.WriteString(");\n")
+	default:
+		return "", fmt.Errorf("unknown engine %q: expected %s or %s", engine, EngineAthena, EngineTrino)
+	}
+	return b.String(), nil
+}
+
+// AlterDDL returns the statements adding to table name in engine the
+// columns added since schema sinceID
+func (t *ArchiveTable) AlterDDL(engine, name string, sinceID int) ([]string, error) {
+	added, err := t.AddedColumns(sinceID)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make([]string, 0, len(added))
+	for _, column := range added {
+		switch engine {
+		case EngineAthena:
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMNS (`%s` %s);", name, column.Name, column.Type))
+		case EngineTrino:
+			trino, err := trinoType(column.Type)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", name, column.Name, trino))
+		default:
+			return nil, fmt.Errorf("unknown engine %q: expected %s or %s", engine, EngineAthena, EngineTrino)
+		}
+	}
+	return statements, nil
+}
+
+// trinoType converts a Hive type to the Trino type of the same column
+func trinoType(hive string) (string, error) {
+	switch hive {
+	case "string":
+		return "varchar", nil
+	case "boolean", "bigint", "double":
+		return hive, nil
+	case "int":
+		return "integer", nil
+	case "float":
+		return "real", nil
+	}
+
+	if inner, ok := strings.CutPrefix(hive, "array<"); ok && strings.HasSuffix(inner, ">") {
+		element, err := trinoType(strings.TrimSuffix(inner, ">"))
+		if err != nil {
+			return "", err
+		}
+		return "array(" + element + ")", nil
+	}
+	if inner, ok := strings.CutPrefix(hive, "struct<"); ok && strings.HasSuffix(inner, ">") {
+		var fields []string
+		for _, field := range splitTopLevel(strings.TrimSuffix(inner, ">")) {
+			name, fieldType, ok := strings.Cut(field, ":")
+			if !ok {
+				return "", fmt.Errorf("invalid struct field %q", field)
+			}
+			trino, err := trinoType(fieldType)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, name+" "+trino)
+		}
+		return "row(" + strings.Join(fields, ", ") + ")", nil
+	}
+	return "", fmt.Errorf("unsupported type %q", hive)
+}
+
+// splitTopLevel splits the fields of a struct type at the commas that are
+// not inside a nested type
+func splitTopLevel(fields string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range fields {
+		switch c {
+		case '<':
+			depth++
+		case '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, fields[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, fields[start:])
+}
+
+// separator returns the comma ending every column definition but the last
+func separator(i, n int) string {
+	if i < n-1 {
+		return ","
+	}
+	return ""
+}
+
+// LoadArchiveTable reads the table metadata under prefix, returning
+// storage.ErrNotFound if none was written yet
+func LoadArchiveTable(ctx context.Context, reader storage.ObjectReader, prefix string) (*ArchiveTable, error) {
+	body, err := reader.Get(ctx, ArchiveTableKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+	var table ArchiveTable
+	if err := json.Unmarshal(body, &table); err != nil {
+		return nil, fmt.Errorf("failed to decode archive table metadata: %w", err)
+	}
+	return &table, nil
+}
+
+// SetTable makes the sink maintain the table metadata of the archive, stored
+// in bucket and read back through reader. It is brought up to date with the
+// reading columns before the first batch's manifest, which fails the batch
+// if it cannot be.
+func (s *ArchiveSink) SetTable(bucket string, reader storage.ObjectReader) {
+	s.table = &archiveTableWriter{bucket: bucket, reader: reader}
+}
+
+// archiveTableWriter keeps the table metadata of an archive sink
+type archiveTableWriter struct {
+	bucket string
+	reader storage.ObjectReader
+	// written is set once the metadata is up to date; batches are flushed
+	// one at a time, so it needs no lock
+	written bool
+}
+
+// publishTable brings the table metadata up to date with the sink's
+// settings and the reading columns, once per process
+func (s *ArchiveSink) publishTable(ctx context.Context) error {
+	if s.table == nil || s.table.written {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	table, err := LoadArchiveTable(ctx, s.table.reader, s.config.Prefix)
+	if errors.Is(err, storage.ErrNotFound) {
+		table = &ArchiveTable{
+			Version:          ArchiveTableVersion,
+			Format:           s.config.Format,
+			CreatedAt:        now,
+			PartitionColumns: []ArchiveColumn{{Name: "dt", Type: "string"}, {Name: "hour", Type: "string"}},
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read archive table metadata: %w", err)
+	}
+	if table.Format != s.config.Format {
+		// One table cannot read objects of both formats
+		return fmt.Errorf("archive prefix %s holds %s objects; archive %s objects under another prefix",
+			s.config.Prefix, table.Format, s.config.Format)
+	}
+
+	evolved, err := table.Evolve(ReadingColumns(), now)
+	if err != nil {
+		return fmt.Errorf("failed to evolve archive table schema: %w", err)
+	}
+	location := "s3://" + path.Join(s.table.bucket, s.config.Prefix)
+	if !evolved && table.Location == location && table.Compression == s.config.Compression &&
+		table.Encryption == s.encryptor.Mode() {
+		s.table.written = true
+		return nil
+	}
+
+	table.Location, table.Compression, table.Encryption = location, s.config.Compression, s.encryptor.Mode()
+	table.UpdatedAt = now
+	body, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode archive table metadata: %w", err)
+	}
+	key := ArchiveTableKey(s.config.Prefix)
+	if err := s.store.Put(ctx, key, body, map[string]string{"Content-Type": "application/json"}); err != nil {
+		return fmt.Errorf("failed to upload archive table metadata: %w", err)
+	}
+	if evolved {
+		s.config.Logger.Info("Archive table schema written", "schema_id", table.CurrentSchemaID, "key", key)
+	}
+	s.table.written = true
+	return nil
+}
@@ -0,0 +1,147 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrClosed is returned by Write once the sink is stopped
+var ErrClosed = errors.New("sink: closed")
+
+// maxBatchSize bounds the items a sink buffers for one flush
+const maxBatchSize = 10000
+
+// pending is an item waiting for its batch to be flushed
+type pending[T any] struct {
+	item T
+	done chan error
+}
+
+// batcher collects items written concurrently into batches, flushed when a
+// batch is full or its oldest item has waited for the flush interval. Writers
+// block until their batch is flushed and receive the flush result.
+type batcher[T any] struct {
+	size     int
+	interval time.Duration
+	flushFn  func(items []T) error
+	queue    chan pending[T]
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// newBatcher creates a batcher calling flush with up to size items at a time
+func newBatcher[T any](size int, interval time.Duration, flush func(items []T) error) *batcher[T] {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &batcher[T]{
+		size:     size,
+		interval: interval,
+		flushFn:  flush,
+		queue:    make(chan pending[T], size),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// start starts the batching loop
+func (b *batcher[T]) start() {
+	go b.run()
+}
+
+// stop flushes the pending batch and stops the batching loop
+func (b *batcher[T]) stop() {
+	b.cancel()
+	<-b.done
+}
+
+// write queues an item and waits until its batch has been flushed or ctx is done
+func (b *batcher[T]) write(ctx context.Context, item T) error {
+	p := pending[T]{item: item, done: make(chan error, 1)}
+
+	select {
+	case b.queue <- p:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.ctx.Done():
+		return ErrClosed
+	}
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *batcher[T]) run() {
+	defer close(b.done)
+
+	batch := make([]pending[T], 0, b.size)
+	timer := time.NewTimer(b.interval)
+	stopTimer(timer)
+
+	for {
+		select {
+		case p := <-b.queue:
+			if len(batch) == 0 {
+				timer.Reset(b.interval)
+			}
+			batch = append(batch, p)
+			if len(batch) >= b.size {
+				stopTimer(timer)
+				batch = b.flush(batch)
+			}
+		case <-timer.C:
+			batch = b.flush(batch)
+		case <-b.ctx.Done():
+			stopTimer(timer)
+			// Items queued before the stop are still flushed
+		drain:
+			for {
+				select {
+				case p := <-b.queue:
+					batch = append(batch, p)
+					if len(batch) >= b.size {
+						batch = b.flush(batch)
+					}
+				default:
+					break drain
+				}
+			}
+			b.flush(batch)
+			return
+		}
+	}
+}
+
+// flush hands a batch to flushFn, reports the result to every writer and
+// returns the emptied batch
+func (b *batcher[T]) flush(batch []pending[T]) []pending[T] {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	items := make([]T, len(batch))
+	for i, p := range batch {
+		items[i] = p.item
+	}
+	err := b.flushFn(items)
+
+	for _, p := range batch {
+		p.done <- err
+	}
+	return batch[:0]
+}
+
+// stopTimer stops a timer and drains a pending fire so it can be reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
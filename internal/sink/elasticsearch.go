@@ -0,0 +1,117 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// ElasticsearchConfig configures an Elasticsearch sink
+type ElasticsearchConfig struct {
+	// BatchSize is the number of documents that triggers a bulk request
+	BatchSize int
+	// FlushInterval bounds how long a document waits for its batch to fill
+	FlushInterval time.Duration
+	// Timeout bounds each bulk request
+	Timeout time.Duration
+	// Logger receives failed bulk requests (optional)
+	Logger *slog.Logger
+}
+
+// ElasticsearchSink batches documents into _bulk requests. Like PostgresSink,
+// Write blocks until the document's batch is indexed. Documents are created
+// with deterministic IDs, and documents that already exist are skipped, so
+// redelivered messages are not indexed twice.
+type ElasticsearchSink struct {
+	es      *db.ElasticsearchDB
+	config  ElasticsearchConfig
+	metrics *Metrics
+	batcher *batcher[db.BulkDocument]
+}
+
+// NewElasticsearchSink creates a new Elasticsearch sink; metrics may be nil
+func NewElasticsearchSink(es *db.ElasticsearchDB, config ElasticsearchConfig, metrics *Metrics) *ElasticsearchSink {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+	if config.BatchSize > maxBatchSize {
+		config.BatchSize = maxBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	config.Logger = logging.OrDefault(config.Logger)
+
+	s := &ElasticsearchSink{
+		es:      es,
+		config:  config,
+		metrics: metrics,
+	}
+	s.batcher = newBatcher(config.BatchSize, config.FlushInterval, s.flush)
+	return s
+}
+
+// Start starts the batching loop
+func (s *ElasticsearchSink) Start() {
+	s.batcher.start()
+}
+
+// Stop flushes the pending batch and stops the batching loop
+func (s *ElasticsearchSink) Stop() {
+	s.batcher.stop()
+}
+
+// WriteReading indexes a reading under its sensor ID and timestamp
+func (s *ElasticsearchSink) WriteReading(ctx context.Context, reading *model.SensorReading) error {
+	body, err := model.SerializeSensorReading(reading)
+	if err != nil {
+		return err
+	}
+	return s.batcher.write(ctx, db.BulkDocument{Index: s.es.Index(), ID: readingID(reading), Body: body})
+}
+
+// WriteAlert indexes an alert under an ID derived from its reading and reason
+func (s *ElasticsearchSink) WriteAlert(ctx context.Context, alert *model.SensorAlert) error {
+	body, err := model.SerializeSensorAlert(alert)
+	if err != nil {
+		return err
+	}
+	return s.batcher.write(ctx, db.BulkDocument{Index: s.es.AlertIndex(), ID: alertID(alert), Body: body})
+}
+
+// readingID identifies a reading by its sensor and timestamp, since readings
+// carry the ID of their sensor
+func readingID(reading *model.SensorReading) string {
+	return fmt.Sprintf("%s-%d", reading.ID, reading.Timestamp)
+}
+
+// alertID identifies an alert by the reading that raised it and its reason,
+// since one reading can raise several alerts
+func alertID(alert *model.SensorAlert) string {
+	sum := sha256.Sum256([]byte(alert.Reason))
+	return fmt.Sprintf("%s-%d-%s", alert.SensorID, alert.Timestamp, hex.EncodeToString(sum[:8]))
+}
+
+// flush sends a batch in one bulk request and records the result
+func (s *ElasticsearchSink) flush(batch []db.BulkDocument) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	created, err := s.es.Bulk(ctx, batch)
+	s.metrics.observeFlush(start, len(batch), int64(created), err)
+	if err != nil {
+		s.config.Logger.Error("Failed to index batch", "documents", len(batch), "error", err)
+	}
+	return err
+}
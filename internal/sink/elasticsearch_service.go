@@ -0,0 +1,153 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ElasticsearchService consumes raw readings and alerts and indexes them in Elasticsearch
+type ElasticsearchService struct {
+	Sink       *ElasticsearchSink
+	consumer   *kafka.Consumer
+	decoder    *model.ReadingDecoder
+	alertTopic string
+	metrics    *Metrics
+	logger     *slog.Logger
+
+	// dltProducer sends readings that failed every attempt to the DLT
+	// when CONSUMER_DEAD_LETTER is set
+	dltProducer *kafka.Producer
+}
+
+// NewElasticsearchService creates the indexes if needed and creates the sink
+// and its consumer. Metrics are registered on registry.
+func NewElasticsearchService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*ElasticsearchService, error) {
+	logger = logging.OrDefault(logger).With("component", "es_sink")
+	decoder, err := newReadingDecoder(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	es := db.NewElasticsearchDB(cfg)
+	if err := es.InitIndex(); err != nil {
+		return nil, fmt.Errorf("failed to initialize Elasticsearch: %w", err)
+	}
+
+	metrics := NewMetrics("iot", "es_sink", registry)
+	s := &ElasticsearchService{
+		Sink: NewElasticsearchSink(es, ElasticsearchConfig{
+			BatchSize:     cfg.ESSinkBatchSize,
+			FlushInterval: cfg.ESSinkFlushInterval,
+			Timeout:       cfg.StoreTimeout,
+			Logger:        logger,
+		}, metrics),
+		decoder:    decoder,
+		alertTopic: cfg.Topic(config.TopicKeySensorAlert),
+		metrics:    metrics,
+		logger:     logger,
+	}
+
+	// Send readings that failed every attempt to the DLT when enabled
+	deadLetter, dltProducer, err := kafka.NewDeadLetterFromConfig(cfg, []string{config.TopicKeySensorRaw, config.TopicKeySensorAlert}, config.RetryComponentESSink, registry, logger)
+	if err != nil {
+		return nil, err
+	}
+	s.dltProducer = dltProducer
+
+	// Handle as many messages at once as fit in a batch so batches can fill
+	consumer, err := kafka.NewConsumer(
+		kafka.ConsumerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			GroupID:         cfg.ESSinkGroupID,
+			Topics:          append(cfg.RawTopics(), cfg.Topic(config.TopicKeySensorAlert)),
+			TopicWeights:    cfg.RawTopicWeights(),
+			OffsetInitial:   cfg.ConsumerOffsetInitial,
+			ReturnErrors:    cfg.ConsumerReturnErrors,
+			Metrics:         kafka.NewConsumerMetrics("iot", "es_sink_consumer", registry),
+			Version:         cfg.KafkaVersion,
+			HandlerTimeout:  cfg.ConsumerHandlerTimeout,
+			DrainTimeout:    cfg.ConsumerDrainTimeout,
+			HopHeaders:      cfg.HopHeaders,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ConsumerRetryFromConfig(cfg, config.RetryComponentESSink),
+			DeadLetter:      deadLetter,
+			LagInterval:     cfg.ConsumerLagInterval,
+			BalanceStrategy: cfg.ConsumerBalanceStrategy,
+			WorkerPoolSize:  cfg.ESSinkBatchSize,
+			InflightBudget:  kafka.InflightBudgetFromConfig(cfg, registry),
+			Schemas:         kafka.SchemaTrackerFromConfig(cfg, registry),
+			Logger:          logger,
+		},
+		s.HandleMessage,
+	)
+	if err != nil {
+		if dltProducer != nil {
+			dltProducer.Close()
+		}
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	s.consumer = consumer
+
+	return s, nil
+}
+
+// HandleMessage decodes a reading or alert and waits for it to be indexed.
+// Undecodable messages are skipped.
+func (s *ElasticsearchService) HandleMessage(ctx context.Context, message *sarama.ConsumerMessage) error {
+	if message.Topic == s.alertTopic {
+		alert, err := model.DeserializeSensorAlert(message.Value)
+		if err != nil {
+			s.skip(message, err)
+			return nil
+		}
+		if err := s.Sink.WriteAlert(ctx, alert); err != nil {
+			return fmt.Errorf("failed to index alert for %s: %w", alert.SensorID, err)
+		}
+	} else {
+		reading, _, err := s.decoder.DecodeFormat(message.Topic, message.Value, kafka.PayloadFormat(message))
+		if err != nil {
+			s.skip(message, err)
+			return nil
+		}
+		kafka.AddInflightBytes(ctx, reading.MemorySize())
+		if err := s.Sink.WriteReading(ctx, reading); err != nil {
+			return fmt.Errorf("failed to index reading %s: %w", reading.ID, err)
+		}
+	}
+
+	if hops := kafka.HopsFromContext(ctx); len(hops) > 0 {
+		s.metrics.Latency.Observe(time.Since(hops[0].At).Seconds())
+	}
+	return nil
+}
+
+// skip counts and logs an undecodable message
+func (s *ElasticsearchService) skip(message *sarama.ConsumerMessage, err error) {
+	s.metrics.DecodeErrors.Inc()
+	s.logger.With(kafka.MessageLogAttrs(message)...).Warn("Skipping undecodable message", "error", err)
+}
+
+// Start starts the sink and its consumer
+func (s *ElasticsearchService) Start() error {
+	s.Sink.Start()
+	return s.consumer.Start()
+}
+
+// Stop stops consuming and flushes the pending batch
+func (s *ElasticsearchService) Stop() {
+	s.consumer.Stop()
+	s.Sink.Stop()
+	if s.dltProducer != nil {
+		s.dltProducer.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds Prometheus metrics for a sink
+type Metrics struct {
+	Written       prometheus.Counter
+	Duplicates    prometheus.Counter
+	Batches       prometheus.Counter
+	BatchErrors   prometheus.Counter
+	DecodeErrors  prometheus.Counter
+	BatchSize     prometheus.Histogram
+	FlushDuration prometheus.Histogram
+	Latency       prometheus.Histogram
+}
+
+// NewMetrics creates a new set of sink metrics
+func NewMetrics(namespace, subsystem string, registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		Written: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "written_total",
+			Help:      "Total number of records stored",
+		}),
+		Duplicates: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "duplicates_total",
+			Help:      "Total number of redelivered records that were already stored",
+		}),
+		Batches: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "batches_total",
+			Help:      "Total number of batches committed",
+		}),
+		BatchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "batch_errors_total",
+			Help:      "Total number of batches that failed to commit",
+		}),
+		DecodeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "decode_errors_total",
+			Help:      "Total number of messages skipped because they could not be decoded",
+		}),
+		BatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "batch_size",
+			Help:      "Number of records per batch",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+		}),
+		FlushDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "flush_duration_seconds",
+			Help:      "Time taken to write one batch in seconds",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		Latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "end_to_end_latency_seconds",
+			Help:      "Time from the first recorded hop of a message until it was persisted, in seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.Written,
+		metrics.Duplicates,
+		metrics.Batches,
+		metrics.BatchErrors,
+		metrics.DecodeErrors,
+		metrics.BatchSize,
+		metrics.FlushDuration,
+		metrics.Latency,
+	)
+
+	return metrics
+}
+
+// observeFlush records the outcome of writing a batch of size records, of
+// which written were not already stored; m may be nil
+func (m *Metrics) observeFlush(start time.Time, size int, written int64, err error) {
+	if m == nil {
+		return
+	}
+	m.FlushDuration.Observe(time.Since(start).Seconds())
+	m.BatchSize.Observe(float64(size))
+	if err != nil {
+		m.BatchErrors.Inc()
+		return
+	}
+	m.Batches.Inc()
+	m.Written.Add(float64(written))
+	m.Duplicates.Add(float64(int64(size) - written))
+}
@@ -0,0 +1,171 @@
+package sink
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/example/iot-sensor-fleet/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the sinks' writes
+var tracer = otel.Tracer("github.com/example/iot-sensor-fleet/internal/sink")
+
+// PostgresConfig configures a PostgreSQL sink
+type PostgresConfig struct {
+	// BatchSize is the number of readings that triggers a flush
+	BatchSize int
+	// FlushInterval bounds how long a reading waits for its batch to fill
+	FlushInterval time.Duration
+	// Timeout bounds each batch insert
+	Timeout time.Duration
+	// Method is how batches are inserted, db.InsertMethodCopy or
+	// db.InsertMethodValues (empty uses copy)
+	Method string
+	// Logger receives failed inserts (optional)
+	Logger *slog.Logger
+	// Columns encrypts the sensitive columns of readings (optional)
+	Columns *encryption.Columns
+}
+
+// PostgresSink batches readings into bulk inserts on sensor_readings.
+// Sensitive columns are encrypted when PostgresConfig.Columns is set, the
+// location into location_enc instead of latitude and longitude.
+// Write blocks until the reading's batch has committed, so a consumer only
+// marks a message once it is stored. Inserts skip readings that are already
+// stored, which makes redelivery after a rebalance or restart harmless.
+type PostgresSink struct {
+	postgres *db.PostgresDB
+	config   PostgresConfig
+	metrics  *Metrics
+	batcher  *batcher[tracedReading]
+}
+
+// tracedReading is a queued reading with the span of the message it came
+// from. A batch insert serves many messages, so its span links to theirs
+// rather than having one parent.
+type tracedReading struct {
+	reading *model.SensorReading
+	link    trace.Link
+}
+
+// NewPostgresSink creates a new PostgreSQL sink; metrics may be nil
+func NewPostgresSink(postgres *db.PostgresDB, config PostgresConfig, metrics *Metrics) *PostgresSink {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+	if config.BatchSize > maxBatchSize {
+		config.BatchSize = maxBatchSize
+	}
+	if config.Method == "" {
+		config.Method = db.InsertMethodCopy
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	config.Logger = logging.OrDefault(config.Logger)
+
+	s := &PostgresSink{
+		postgres: postgres,
+		config:   config,
+		metrics:  metrics,
+	}
+	s.batcher = newBatcher(config.BatchSize, config.FlushInterval, s.flush)
+	return s
+}
+
+// Start starts the batching loop
+func (s *PostgresSink) Start() {
+	s.batcher.start()
+}
+
+// Stop flushes the pending batch and stops the batching loop
+func (s *PostgresSink) Stop() {
+	s.batcher.stop()
+}
+
+// Write queues a reading and waits until its batch has committed or ctx is done
+func (s *PostgresSink) Write(ctx context.Context, reading *model.SensorReading) error {
+	return s.batcher.write(ctx, tracedReading{reading: reading, link: trace.LinkFromContext(ctx)})
+}
+
+// flush inserts a batch and records the result
+func (s *PostgresSink) flush(batch []tracedReading) error {
+	start := time.Now()
+	written, err := s.insert(batch)
+	s.metrics.observeFlush(start, len(batch), written, err)
+	if err != nil {
+		s.config.Logger.Error("Failed to insert batch", "readings", len(batch), "error", err)
+	}
+	return err
+}
+
+// insert writes a batch in one transaction and returns the number of new rows
+func (s *PostgresSink) insert(batch []tracedReading) (written int64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+
+	links := make([]trace.Link, 0, len(batch))
+	readings := make([]*model.SensorReading, len(batch))
+	for i, queued := range batch {
+		if queued.link.SpanContext.IsValid() {
+			links = append(links, queued.link)
+		}
+		readings[i] = queued.reading
+	}
+	operation := "INSERT"
+	if s.config.Method == db.InsertMethodCopy {
+		operation = "COPY"
+	}
+	ctx, span := tracer.Start(ctx, operation+" sensor_readings",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName("sensor_readings"),
+			attribute.Int("db.operation.batch.size", len(batch)),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	return s.postgres.InsertReadingsBatch(ctx, readings, db.ReadingBatchOptions{
+		Method:  s.config.Method,
+		Columns: s.config.Columns,
+	})
+}
+
+// WriteAlert stores an alert in sensor_alerts. Alerts are rare next to
+// readings, so each is inserted on its own rather than batched.
+func (s *PostgresSink) WriteAlert(ctx context.Context, alert *model.SensorAlert) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	ctx, span := tracer.Start(ctx, "INSERT sensor_alerts",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName("INSERT"),
+			semconv.DBCollectionName("sensor_alerts"),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	inserted, err := s.postgres.InsertAlert(ctx, alert, s.config.Columns)
+	var written int64
+	if inserted {
+		written = 1
+	}
+	s.metrics.observeFlush(start, 1, written, err)
+	return err
+}
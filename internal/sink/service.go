@@ -0,0 +1,183 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Service consumes raw readings, and alerts when POSTGRES_SINK_ALERTS is
+// set, and stores them in PostgreSQL
+type Service struct {
+	Sink     *PostgresSink
+	consumer *kafka.Consumer
+	decoder  *model.ReadingDecoder
+	postgres *db.PostgresDB
+	metrics  *Metrics
+	logger   *slog.Logger
+
+	// alertTopic is the alert topic when alerts are stored, or else empty
+	alertTopic string
+
+	// dltProducer sends readings that failed every attempt to the DLT
+	// when CONSUMER_DEAD_LETTER is set
+	dltProducer *kafka.Producer
+}
+
+// NewService connects to PostgreSQL and creates the sink and its consumer.
+// Metrics are registered on registry.
+func NewService(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) (*Service, error) {
+	logger = logging.OrDefault(logger).With("component", "postgres_sink")
+	decoder, err := newReadingDecoder(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := encryption.NewColumnsFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load column encryption keys: %w", err)
+	}
+
+	postgres, err := db.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect sink database: %w", err)
+	}
+
+	metrics := NewMetrics("iot", "postgres_sink", registry)
+	s := &Service{
+		Sink: NewPostgresSink(postgres, PostgresConfig{
+			BatchSize:     cfg.PostgresSinkBatchSize,
+			FlushInterval: cfg.PostgresSinkFlushInterval,
+			Timeout:       cfg.StoreTimeout,
+			Method:        cfg.PostgresSinkInsertMethod,
+			Logger:        logger,
+			Columns:       columns,
+		}, metrics),
+		decoder:  decoder,
+		postgres: postgres,
+		metrics:  metrics,
+		logger:   logger,
+	}
+
+	topics, topicKeys := cfg.RawTopics(), []string{config.TopicKeySensorRaw}
+	if cfg.PostgresSinkAlerts {
+		s.alertTopic = cfg.Topic(config.TopicKeySensorAlert)
+		topics, topicKeys = append(topics, s.alertTopic), append(topicKeys, config.TopicKeySensorAlert)
+	}
+
+	// Send messages that failed every attempt to the DLT when enabled
+	deadLetter, dltProducer, err := kafka.NewDeadLetterFromConfig(cfg, topicKeys, config.RetryComponentPostgresSink, registry, logger)
+	if err != nil {
+		postgres.Close()
+		return nil, err
+	}
+	s.dltProducer = dltProducer
+
+	// Handle as many messages at once as fit in a batch so batches can fill
+	consumer, err := kafka.NewConsumer(
+		kafka.ConsumerConfig{
+			Brokers:         cfg.KafkaBrokers,
+			GroupID:         cfg.PostgresSinkGroupID,
+			Topics:          topics,
+			TopicWeights:    cfg.RawTopicWeights(),
+			OffsetInitial:   cfg.ConsumerOffsetInitial,
+			ReturnErrors:    cfg.ConsumerReturnErrors,
+			Metrics:         kafka.NewConsumerMetrics("iot", "postgres_sink_consumer", registry),
+			Version:         cfg.KafkaVersion,
+			HandlerTimeout:  cfg.ConsumerHandlerTimeout,
+			DrainTimeout:    cfg.ConsumerDrainTimeout,
+			HopHeaders:      cfg.HopHeaders,
+			Security:        kafka.SecurityFromConfig(cfg),
+			Retry:           kafka.ConsumerRetryFromConfig(cfg, config.RetryComponentPostgresSink),
+			DeadLetter:      deadLetter,
+			LagInterval:     cfg.ConsumerLagInterval,
+			BalanceStrategy: cfg.ConsumerBalanceStrategy,
+			WorkerPoolSize:  cfg.PostgresSinkBatchSize,
+			InflightBudget:  kafka.InflightBudgetFromConfig(cfg, registry),
+			Schemas:         kafka.SchemaTrackerFromConfig(cfg, registry),
+			Logger:          logger,
+		},
+		s.HandleMessage,
+	)
+	if err != nil {
+		if dltProducer != nil {
+			dltProducer.Close()
+		}
+		postgres.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	s.consumer = consumer
+
+	return s, nil
+}
+
+// newReadingDecoder creates the per-topic reading decoder
+func newReadingDecoder(cfg *config.Config) (*model.ReadingDecoder, error) {
+	decoder, err := model.NewReadingDecoder(cfg.TopicFormats())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reading decoder: %w", err)
+	}
+	return decoder, nil
+}
+
+// HandleMessage decodes a raw reading or alert and waits for it to be
+// stored. Undecodable messages are skipped; the detector routes undecodable
+// readings to the DLT.
+func (s *Service) HandleMessage(ctx context.Context, message *sarama.ConsumerMessage) error {
+	if s.alertTopic != "" && message.Topic == s.alertTopic {
+		alert, err := model.DeserializeSensorAlert(message.Value)
+		if err != nil {
+			s.metrics.DecodeErrors.Inc()
+			s.logger.With(kafka.MessageLogAttrs(message)...).Warn("Skipping undecodable alert", "error", err)
+			return nil
+		}
+		if err := s.Sink.WriteAlert(ctx, alert); err != nil {
+			return fmt.Errorf("failed to store alert for %s: %w", alert.SensorID, err)
+		}
+	} else {
+		reading, _, err := s.decoder.DecodeFormat(message.Topic, message.Value, kafka.PayloadFormat(message))
+		if err != nil {
+			s.metrics.DecodeErrors.Inc()
+			s.logger.With(kafka.MessageLogAttrs(message)...).Warn("Skipping undecodable reading", "error", err)
+			return nil
+		}
+		kafka.AddInflightBytes(ctx, reading.MemorySize())
+
+		if err := s.Sink.Write(ctx, reading); err != nil {
+			return fmt.Errorf("failed to store reading %s: %w", reading.ID, err)
+		}
+	}
+
+	if hops := kafka.HopsFromContext(ctx); len(hops) > 0 {
+		s.metrics.Latency.Observe(time.Since(hops[0].At).Seconds())
+	}
+	return nil
+}
+
+// Start starts the sink and its consumer
+func (s *Service) Start() error {
+	s.Sink.Start()
+	return s.consumer.Start()
+}
+
+// Stop stops consuming, flushes the pending batch and closes the database connection
+func (s *Service) Stop() {
+	s.consumer.Stop()
+	s.Sink.Stop()
+	if s.dltProducer != nil {
+		s.dltProducer.Close()
+	}
+	if err := s.postgres.Close(); err != nil {
+		s.logger.Error("Failed to close sink database", "error", err)
+	}
+}
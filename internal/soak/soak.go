@@ -0,0 +1,328 @@
+// Package soak runs a service for a fixed duration while sampling its own
+// resource usage, then reports how the usage evolved so leaks that only show
+// under sustained load, such as goroutines or memory that keep growing, are
+// caught before they reach production.
+package soak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/example/iot-sensor-fleet/internal/storage"
+)
+
+// warmupFraction of the samples is skipped when judging growth, so caches
+// and pools filling up at startup are not mistaken for leaks
+const warmupFraction = 0.1
+
+// Growth over the steady state above which a resource is reported as a
+// suspected leak; small absolute growth is ignored
+const (
+	goroutineGrowthLimit = 0.5
+	goroutineGrowthFloor = 20
+	memoryGrowthLimit    = 0.5
+	memoryGrowthFloor    = 32 << 20
+)
+
+// Config configures a soak run
+type Config struct {
+	// Service names the service in the report and its file name
+	Service string
+	// Duration is how long the service runs before it is stopped
+	Duration time.Duration
+	// SampleInterval is how often resource usage is sampled
+	SampleInterval time.Duration
+	// ReportDir is the directory the report is written to (empty skips the file)
+	ReportDir string
+	// Store and UploadPrefix upload the report under the prefix (optional)
+	Store        storage.ObjectStore
+	UploadPrefix string
+	// Logger receives the progress and summary of the run (optional)
+	Logger *slog.Logger
+}
+
+// Sample is the resource usage of the process at one time
+type Sample struct {
+	At         time.Time `json:"at"`
+	RSSBytes   uint64    `json:"rss_bytes"`
+	HeapBytes  uint64    `json:"heap_bytes"`
+	Goroutines int       `json:"goroutines"`
+	NumGC      uint32    `json:"num_gc"`
+	// GCPause is the total time spent in stop-the-world GC pauses
+	GCPause time.Duration `json:"gc_pause_ns"`
+}
+
+// Growth compares the steady state after warmup with the end of the run
+type Growth struct {
+	Steady float64 `json:"steady"`
+	Final  float64 `json:"final"`
+	Max    float64 `json:"max"`
+	// Ratio is (Final - Steady) / Steady
+	Ratio float64 `json:"ratio"`
+}
+
+// Report is the outcome of a soak run
+type Report struct {
+	Service  string        `json:"service"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration_ns"`
+
+	Goroutines Growth `json:"goroutines"`
+	RSSBytes   Growth `json:"rss_bytes"`
+	HeapBytes  Growth `json:"heap_bytes"`
+	GCCycles   uint32 `json:"gc_cycles"`
+	// GCPause is the time spent in GC pauses during the run
+	GCPause time.Duration `json:"gc_pause_ns"`
+
+	// AfterShutdown is sampled once the service stopped; goroutines still
+	// running then were not cleaned up
+	AfterShutdown Sample `json:"after_shutdown"`
+
+	// Suspects describes resources that kept growing
+	Suspects []string `json:"suspects,omitempty"`
+	Samples  []Sample `json:"samples"`
+}
+
+// Run samples resource usage until the soak duration elapses
+type Run struct {
+	config Config
+	start  time.Time
+	done   chan struct{}
+
+	mu      sync.Mutex
+	samples []Sample
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewFromConfig creates a soak run of service from SOAK_* settings, or
+// returns nil if soak mode is disabled
+func NewFromConfig(service string, cfg *config.Config, logger *slog.Logger) (*Run, error) {
+	if cfg.SoakDuration <= 0 {
+		return nil, nil
+	}
+
+	soakConfig := Config{
+		Service:        service,
+		Duration:       cfg.SoakDuration,
+		SampleInterval: cfg.SoakSampleInterval,
+		ReportDir:      cfg.SoakReportDir,
+		UploadPrefix:   cfg.SoakUploadPrefix,
+		Logger:         logger,
+	}
+	if cfg.SoakUploadPrefix != "" {
+		store, err := storage.NewS3StoreFromConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create soak report store: %w", err)
+		}
+		soakConfig.Store = store
+	}
+	return Start(soakConfig)
+}
+
+// Start begins sampling; Done is closed once the duration elapsed
+func Start(config Config) (*Run, error) {
+	if config.Duration <= 0 {
+		return nil, fmt.Errorf("soak duration must be positive")
+	}
+	if config.SampleInterval <= 0 {
+		return nil, fmt.Errorf("soak sample interval must be positive")
+	}
+
+	config.Logger = logging.OrDefault(config.Logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Run{
+		config: config,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	r.record(takeSample())
+
+	r.wg.Add(1)
+	go r.sample()
+	config.Logger.Info("Soak mode", "duration", config.Duration, "sample_interval", config.SampleInterval)
+	return r, nil
+}
+
+// Done is closed when the soak duration has elapsed; it never is for a nil
+// run, so callers can select on it whether soak mode is enabled or not
+func (r *Run) Done() <-chan struct{} {
+	if r == nil {
+		return nil
+	}
+	return r.done
+}
+
+// sample records resource usage until the run finishes
+func (r *Run) sample() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.config.SampleInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(r.config.Duration)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.record(takeSample())
+		case <-deadline.C:
+			r.record(takeSample())
+			close(r.done)
+			return
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Run) record(sample Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, sample)
+}
+
+// Finish stops sampling and writes the report. Call it after the service
+// stopped, so the report shows what the shutdown left behind.
+func (r *Run) Finish(ctx context.Context) (*Report, error) {
+	r.cancel()
+	r.wg.Wait()
+
+	// Give goroutines that were told to stop a moment to exit
+	runtime.GC()
+	time.Sleep(100 * time.Millisecond)
+
+	r.mu.Lock()
+	report := buildReport(r.config.Service, r.start, r.samples, takeSample())
+	r.mu.Unlock()
+
+	r.config.Logger.Info("Soak report",
+		"duration", report.Duration.Round(time.Second),
+		"goroutines_steady", report.Goroutines.Steady, "goroutines_final", report.Goroutines.Final,
+		"goroutines_after_shutdown", report.AfterShutdown.Goroutines,
+		"rss_steady_mib", report.RSSBytes.Steady/(1<<20), "rss_final_mib", report.RSSBytes.Final/(1<<20),
+		"gc_cycles", report.GCCycles)
+	for _, suspect := range report.Suspects {
+		r.config.Logger.Warn("Suspected leak", "suspect", suspect)
+	}
+
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return report, fmt.Errorf("failed to encode soak report: %w", err)
+	}
+	name := fmt.Sprintf("soak-%s-%s.json", r.config.Service, report.Start.UTC().Format("20060102T150405Z"))
+
+	if r.config.ReportDir != "" {
+		path := filepath.Join(r.config.ReportDir, name)
+		if err := os.WriteFile(path, body, 0o644); err != nil {
+			return report, fmt.Errorf("failed to write soak report: %w", err)
+		}
+		r.config.Logger.Info("Soak report written", "path", path)
+	}
+	if r.config.Store != nil {
+		key := strings.TrimSuffix(r.config.UploadPrefix, "/") + "/" + name
+		if err := r.config.Store.Put(ctx, key, body, map[string]string{"Content-Type": "application/json"}); err != nil {
+			return report, fmt.Errorf("failed to upload soak report: %w", err)
+		}
+		r.config.Logger.Info("Soak report uploaded", "key", key)
+	}
+	return report, nil
+}
+
+// buildReport summarizes the samples of a run
+func buildReport(service string, start time.Time, samples []Sample, after Sample) *Report {
+	report := &Report{
+		Service:       service,
+		Start:         start,
+		End:           after.At,
+		Duration:      after.At.Sub(start),
+		AfterShutdown: after,
+		Samples:       samples,
+	}
+	if len(samples) == 0 {
+		return report
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	report.GCCycles = last.NumGC - first.NumGC
+	report.GCPause = last.GCPause - first.GCPause
+
+	steady := samples[int(float64(len(samples)-1)*warmupFraction)]
+	report.Goroutines = growth(samples, float64(steady.Goroutines), func(s Sample) float64 { return float64(s.Goroutines) })
+	report.RSSBytes = growth(samples, float64(steady.RSSBytes), func(s Sample) float64 { return float64(s.RSSBytes) })
+	report.HeapBytes = growth(samples, float64(steady.HeapBytes), func(s Sample) float64 { return float64(s.HeapBytes) })
+
+	if g := report.Goroutines; g.Ratio > goroutineGrowthLimit && g.Final-g.Steady > goroutineGrowthFloor {
+		report.Suspects = append(report.Suspects, fmt.Sprintf("goroutines grew from %.0f to %.0f", g.Steady, g.Final))
+	}
+	if m := report.RSSBytes; m.Ratio > memoryGrowthLimit && m.Final-m.Steady > memoryGrowthFloor {
+		report.Suspects = append(report.Suspects, fmt.Sprintf("RSS grew from %.1f to %.1f MiB", m.Steady/(1<<20), m.Final/(1<<20)))
+	}
+	if m := report.HeapBytes; m.Ratio > memoryGrowthLimit && m.Final-m.Steady > memoryGrowthFloor {
+		report.Suspects = append(report.Suspects, fmt.Sprintf("heap grew from %.1f to %.1f MiB", m.Steady/(1<<20), m.Final/(1<<20)))
+	}
+	return report
+}
+
+// growth compares the steady value with the last and largest samples
+func growth(samples []Sample, steady float64, value func(Sample) float64) Growth {
+	g := Growth{Steady: steady, Final: value(samples[len(samples)-1])}
+	for _, sample := range samples {
+		if v := value(sample); v > g.Max {
+			g.Max = v
+		}
+	}
+	if steady > 0 {
+		g.Ratio = (g.Final - steady) / steady
+	}
+	return g
+}
+
+// takeSample reads the current resource usage of the process
+func takeSample() Sample {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return Sample{
+		At:         time.Now(),
+		RSSBytes:   residentSetSize(),
+		HeapBytes:  stats.HeapAlloc,
+		Goroutines: runtime.NumGoroutine(),
+		NumGC:      stats.NumGC,
+		GCPause:    time.Duration(stats.PauseTotalNs),
+	}
+}
+
+// residentSetSize returns the resident memory of the process from procfs,
+// or 0 where procfs is not available
+func residentSetSize() uint64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
@@ -0,0 +1,87 @@
+package startup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewWaiterFromConfig creates a waiter with the configured backoff. Metrics
+// are registered on registry.
+func NewWaiterFromConfig(cfg *config.Config, registry prometheus.Registerer, logger *slog.Logger) *Waiter {
+	return NewWaiter(Backoff{
+		Initial: cfg.StartupBackoffInitial,
+		Max:     cfg.StartupBackoffMax,
+	}, NewMetrics("iot", "startup", registry), logger)
+}
+
+// KafkaDependency waits for the configured brokers and, when enabled, creates
+// the configured topics that are missing
+func KafkaDependency(cfg *config.Config) Dependency {
+	security := kafka.SecurityFromConfig(cfg)
+	return Dependency{
+		Name: "kafka",
+		Check: func(ctx context.Context) error {
+			if err := kafka.Ping(cfg.KafkaBrokers, cfg.KafkaVersion, security); err != nil {
+				return err
+			}
+			if !cfg.KafkaCreateTopics {
+				return nil
+			}
+			return kafka.EnsureTopics(cfg.KafkaBrokers, cfg.KafkaVersion, security, kafka.TopicsFromConfig(cfg))
+		},
+		Timeout: cfg.StartupKafkaTimeout,
+	}
+}
+
+// PostgresDependency waits for the configured PostgreSQL database
+func PostgresDependency(cfg *config.Config) Dependency {
+	return Dependency{
+		Name: "postgres",
+		Check: func(ctx context.Context) error {
+			return db.PingPostgres(ctx, cfg)
+		},
+		Timeout: cfg.StartupPostgresTimeout,
+	}
+}
+
+// ElasticsearchDependency waits for the configured Elasticsearch cluster
+func ElasticsearchDependency(cfg *config.Config) Dependency {
+	es := db.NewElasticsearchDB(cfg)
+	return Dependency{
+		Name:    "elasticsearch",
+		Check:   es.Ping,
+		Timeout: cfg.StartupElasticsearchTimeout,
+	}
+}
+
+// RegistryDependency waits for the sensor registry used by the simulator
+func RegistryDependency(cfg *config.Config) Dependency {
+	url := strings.TrimSuffix(cfg.SimulatorRegistryURL, "/") + "/healthz"
+	return Dependency{
+		Name: "registry",
+		Check: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create request: %w", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("failed to reach registry: %w", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("registry responded with %s", resp.Status)
+			}
+			return nil
+		},
+		Timeout: cfg.StartupRegistryTimeout,
+	}
+}
@@ -0,0 +1,177 @@
+package startup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Check outcomes
+const (
+	ResultReady       = "ready"
+	ResultUnavailable = "unavailable"
+)
+
+// checkTimeout bounds a single reachability check
+const checkTimeout = 5 * time.Second
+
+// processStart approximates when the process started, for the startup duration metric
+var processStart = time.Now()
+
+// Check reports whether a dependency is reachable
+type Check func(ctx context.Context) error
+
+// Dependency is a service that must be reachable before a service starts
+type Dependency struct {
+	Name  string
+	Check Check
+	// Timeout bounds the wait; 0 checks once without retrying
+	Timeout time.Duration
+}
+
+// Backoff configures the delay between failed checks of a dependency. The delay
+// starts at Initial and doubles after each failure up to Max, with jitter.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Metrics holds Prometheus metrics for the startup phase
+type Metrics struct {
+	Duration     prometheus.Gauge
+	WaitDuration *prometheus.GaugeVec
+	Checks       *prometheus.CounterVec
+}
+
+// NewMetrics creates a new set of startup metrics
+func NewMetrics(namespace, subsystem string, registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		Duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "duration_seconds",
+			Help:      "Time from process start until the service was ready, in seconds",
+		}),
+		WaitDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dependency_wait_seconds",
+			Help:      "Time spent waiting for a dependency to become reachable at startup, in seconds",
+		}, []string{"dependency"}),
+		Checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dependency_checks_total",
+			Help:      "Total number of startup dependency checks by result",
+		}, []string{"dependency", "result"}),
+	}
+
+	registry.MustRegister(
+		metrics.Duration,
+		metrics.WaitDuration,
+		metrics.Checks,
+	)
+
+	return metrics
+}
+
+// Waiter holds a service back until its dependencies are reachable, so that a
+// service started alongside its dependencies waits for them instead of
+// crash-looping
+type Waiter struct {
+	backoff Backoff
+	metrics *Metrics
+	logger  *slog.Logger
+}
+
+// NewWaiter creates a new waiter; metrics and logger may be nil
+func NewWaiter(backoff Backoff, metrics *Metrics, logger *slog.Logger) *Waiter {
+	if backoff.Initial <= 0 {
+		backoff.Initial = 500 * time.Millisecond
+	}
+	if backoff.Max < backoff.Initial {
+		backoff.Max = backoff.Initial
+	}
+	return &Waiter{backoff: backoff, metrics: metrics, logger: logging.OrDefault(logger)}
+}
+
+// Wait waits for each dependency in turn and returns an error naming the first
+// one that is still unreachable when its timeout expires
+func (w *Waiter) Wait(ctx context.Context, dependencies ...Dependency) error {
+	for _, dependency := range dependencies {
+		if err := w.wait(ctx, dependency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Waiter) wait(ctx context.Context, dependency Dependency) error {
+	start := time.Now()
+	deadline := start.Add(dependency.Timeout)
+	delay := w.backoff.Initial
+
+	for attempt := 1; ; attempt++ {
+		err := w.check(ctx, dependency)
+		if err == nil {
+			if w.metrics != nil {
+				w.metrics.WaitDuration.WithLabelValues(dependency.Name).Set(time.Since(start).Seconds())
+			}
+			if attempt > 1 {
+				w.logger.Info("Dependency is ready", "dependency", dependency.Name, "elapsed", time.Since(start).Round(time.Millisecond), "attempts", attempt)
+			} else {
+				w.logger.Info("Dependency is ready", "dependency", dependency.Name)
+			}
+			return nil
+		}
+
+		// Jitter keeps replicas started together from retrying in lockstep
+		sleep := time.Duration(float64(delay) * (0.8 + 0.4*rand.Float64()))
+		if remaining := time.Until(deadline); remaining < sleep {
+			return fmt.Errorf("dependency %s is not reachable after %s (%d attempts): %w",
+				dependency.Name, time.Since(start).Round(time.Millisecond), attempt, err)
+		}
+		w.logger.Warn("Waiting for dependency", "dependency", dependency.Name, "attempt", attempt, "retry_in", sleep.Round(time.Millisecond), "error", err)
+
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		delay *= 2
+		if delay > w.backoff.Max {
+			delay = w.backoff.Max
+		}
+	}
+}
+
+// check runs one bounded check and records its result
+func (w *Waiter) check(ctx context.Context, dependency Dependency) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	err := dependency.Check(ctx)
+	if w.metrics != nil {
+		result := ResultReady
+		if err != nil {
+			result = ResultUnavailable
+		}
+		w.metrics.Checks.WithLabelValues(dependency.Name, result).Inc()
+	}
+	return err
+}
+
+// Ready records that the service has started
+func (w *Waiter) Ready() {
+	elapsed := time.Since(processStart)
+	if w.metrics != nil {
+		w.metrics.Duration.Set(elapsed.Seconds())
+	}
+	w.logger.Info("Service ready", "elapsed", elapsed.Round(time.Millisecond))
+}
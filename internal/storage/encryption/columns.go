@@ -0,0 +1,192 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Sensitive columns that can be encrypted in PostgreSQL
+const (
+	ColumnLocation = "location"
+	ColumnSite     = "site"
+	ColumnZone     = "zone"
+)
+
+// columnPrefix starts every encrypted column value, followed by the key ID
+// and the base64 nonce and ciphertext
+const columnPrefix = "enc:v1:"
+
+// vaultTimeout bounds reading the column keys from Vault
+const vaultTimeout = 10 * time.Second
+
+// IsEncrypted reports whether a column value was produced by Columns.Seal
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, columnPrefix)
+}
+
+// Columns encrypts sensitive column values with AES-256-GCM before they are
+// stored and decrypts them when read. Values are sealed with the active key
+// and opened with the key they name, so keys rotate by making a new key
+// active and keeping the old ones until no row uses them. Each value is bound
+// to its column, so a ciphertext copied into another column does not open.
+// Values that are not encrypted are read as they are, and a nil *Columns
+// stores every column in plaintext.
+type Columns struct {
+	active    string
+	keys      map[string]cipher.AEAD
+	encrypted map[string]bool
+}
+
+// NewColumns creates column encryption sealing columns with the active key
+// of keys, which maps key IDs to 256-bit keys
+func NewColumns(keys map[string][]byte, active string, columns []string) (*Columns, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("no column key %q", active)
+	}
+
+	c := &Columns{
+		active:    active,
+		keys:      make(map[string]cipher.AEAD, len(keys)),
+		encrypted: make(map[string]bool, len(columns)),
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("column key %s must be 32 bytes, got %d", id, len(key))
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		c.keys[id] = gcm
+	}
+	for _, column := range columns {
+		switch column {
+		case ColumnLocation, ColumnSite, ColumnZone:
+			c.encrypted[column] = true
+		default:
+			return nil, fmt.Errorf("unknown encrypted column: %s", column)
+		}
+	}
+	return c, nil
+}
+
+// NewColumnsFromConfig creates column encryption from COLUMN_ENCRYPTION_KEYS,
+// or from the Vault secret at COLUMN_ENCRYPTION_VAULT_PATH when set. It
+// returns nil, nil when no keys are configured.
+func NewColumnsFromConfig(cfg *config.Config) (*Columns, error) {
+	spec := cfg.ColumnEncryptionKeys
+	if cfg.ColumnEncryptionVaultPath != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+		defer cancel()
+		secret, err := ReadVaultSecret(ctx, cfg.VaultAddr, cfg.VaultToken, cfg.ColumnEncryptionVaultPath)
+		if err != nil {
+			return nil, err
+		}
+		if spec = secret["keys"]; spec == "" {
+			return nil, fmt.Errorf("vault secret %s has no keys", cfg.ColumnEncryptionVaultPath)
+		}
+	}
+	if spec == "" {
+		return nil, nil
+	}
+
+	keys, err := ParseTenantKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	// The first key listed is the active one
+	active, _, _ := strings.Cut(strings.TrimSpace(strings.Split(spec, ",")[0]), ":")
+	return NewColumns(keys, active, cfg.ColumnEncryptionColumns)
+}
+
+// Encrypts reports whether column is encrypted
+func (c *Columns) Encrypts(column string) bool {
+	return c != nil && c.encrypted[column]
+}
+
+// Seal encrypts value if column is encrypted. Empty values stay empty so
+// they are still stored as NULL.
+func (c *Columns) Seal(column, value string) (string, error) {
+	if !c.Encrypts(column) || value == "" {
+		return value, nil
+	}
+
+	gcm := c.keys[c.active]
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to encrypt %s: %w", column, err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(column))
+	return columnPrefix + c.active + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts value if it is encrypted and returns it unchanged otherwise
+func (c *Columns) Open(column, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", fmt.Errorf("%s is encrypted but no column keys are configured", column)
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, columnPrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted %s", column)
+	}
+	gcm, ok := c.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with unknown column key %s", column, id)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted %s: %w", column, err)
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", column, err)
+	}
+	return string(plaintext), nil
+}
+
+// SealLocation encrypts a location as "lat,lon". It returns "" when the
+// location is nil or not encrypted.
+func (c *Columns) SealLocation(location *model.GeoPoint) (string, error) {
+	if location == nil || !c.Encrypts(ColumnLocation) {
+		return "", nil
+	}
+	return c.Seal(ColumnLocation, strconv.FormatFloat(location.Lat, 'g', -1, 64)+","+strconv.FormatFloat(location.Lon, 'g', -1, 64))
+}
+
+// OpenLocation decrypts a location sealed by SealLocation, or returns nil for
+// an empty value
+func (c *Columns) OpenLocation(value string) (*model.GeoPoint, error) {
+	if value == "" {
+		return nil, nil
+	}
+	plaintext, err := c.Open(ColumnLocation, value)
+	if err != nil {
+		return nil, err
+	}
+	lat, lon, ok := strings.Cut(plaintext, ",")
+	latitude, latErr := strconv.ParseFloat(lat, 64)
+	longitude, lonErr := strconv.ParseFloat(lon, 64)
+	if !ok || latErr != nil || lonErr != nil {
+		return nil, fmt.Errorf("malformed location: %q", plaintext)
+	}
+	return &model.GeoPoint{Lat: latitude, Lon: longitude}, nil
+}
@@ -0,0 +1,27 @@
+package encryption
+
+import (
+	"github.com/example/iot-sensor-fleet/internal/config"
+)
+
+// NewEncryptorFromConfig creates an encryptor from the archive encryption settings.
+// ARCHIVE_TENANT_KEYS holds master keys in envelope mode and customer keys in SSE-C mode.
+func NewEncryptorFromConfig(cfg *config.Config) (*Encryptor, error) {
+	keys, err := ParseTenantKeys(cfg.ArchiveTenantKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.ArchiveEncryptionMode {
+	case ModeEnvelope:
+		provider, err := NewStaticKeyProvider(keys)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptor(ModeEnvelope, provider, nil)
+	case ModeSSEC:
+		return NewEncryptor(ModeSSEC, nil, keys)
+	default:
+		return NewEncryptor(cfg.ArchiveEncryptionMode, nil, nil)
+	}
+}
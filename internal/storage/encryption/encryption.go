@@ -0,0 +1,289 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Supported encryption modes for archived objects
+const (
+	ModeNone     = "none"
+	ModeSSEC     = "sse-c"
+	ModeEnvelope = "envelope"
+)
+
+// Object metadata keys used to store envelope encryption parameters
+const (
+	MetaKeyID      = "X-Amz-Meta-Enc-Key-Id"
+	MetaWrappedKey = "X-Amz-Meta-Enc-Wrapped-Key"
+	MetaAlgorithm  = "X-Amz-Meta-Enc-Algorithm"
+	MetaTenant     = "X-Amz-Meta-Enc-Tenant"
+
+	envelopeAlgorithm = "AES256-GCM"
+)
+
+// ErrUnknownTenant is returned when no key is configured for a tenant
+var ErrUnknownTenant = errors.New("no encryption key configured for tenant")
+
+// KeyProvider is the KMS hook used for envelope encryption.
+// Implementations generate per-object data keys and unwrap them on read.
+type KeyProvider interface {
+	// GenerateDataKey returns a fresh plaintext data key, the same key wrapped
+	// by the tenant's master key, and the ID of the master key used
+	GenerateDataKey(ctx context.Context, tenant string) (plaintext, wrapped []byte, keyID string, err error)
+	// Decrypt unwraps a data key previously returned by GenerateDataKey
+	Decrypt(ctx context.Context, tenant, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider backed by per-tenant master keys held in memory.
+// It is intended for deployments without an external KMS.
+type StaticKeyProvider struct {
+	keys map[string][]byte
+}
+
+// NewStaticKeyProvider creates a key provider from a tenant to 256-bit master key map
+func NewStaticKeyProvider(keys map[string][]byte) (*StaticKeyProvider, error) {
+	for tenant, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key for tenant %s must be 32 bytes, got %d", tenant, len(key))
+		}
+	}
+	return &StaticKeyProvider{keys: keys}, nil
+}
+
+// GenerateDataKey creates a random data key and wraps it with the tenant master key
+func (s *StaticKeyProvider) GenerateDataKey(ctx context.Context, tenant string) ([]byte, []byte, string, error) {
+	master, ok := s.keys[tenant]
+	if !ok {
+		return nil, nil, "", fmt.Errorf("%w: %s", ErrUnknownTenant, tenant)
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := seal(master, dataKey)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return dataKey, wrapped, "static/" + tenant, nil
+}
+
+// Decrypt unwraps a data key with the tenant master key
+func (s *StaticKeyProvider) Decrypt(ctx context.Context, tenant, keyID string, wrapped []byte) ([]byte, error) {
+	master, ok := s.keys[tenant]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, tenant)
+	}
+	if keyID != "static/"+tenant {
+		return nil, fmt.Errorf("key ID %s does not belong to tenant %s", keyID, tenant)
+	}
+
+	dataKey, err := open(master, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// Encryptor applies the configured per-tenant encryption to archive objects
+type Encryptor struct {
+	mode        string
+	provider    KeyProvider
+	customerKey map[string][]byte
+}
+
+// NewEncryptor creates a new encryptor.
+// For ModeEnvelope, provider must be set; for ModeSSEC, customerKeys holds the
+// 256-bit key each tenant's objects are encrypted with by the object store.
+func NewEncryptor(mode string, provider KeyProvider, customerKeys map[string][]byte) (*Encryptor, error) {
+	switch mode {
+	case ModeNone:
+	case ModeEnvelope:
+		if provider == nil {
+			return nil, errors.New("envelope encryption requires a key provider")
+		}
+	case ModeSSEC:
+		for tenant, key := range customerKeys {
+			if len(key) != 32 {
+				return nil, fmt.Errorf("SSE-C key for tenant %s must be 32 bytes, got %d", tenant, len(key))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown archive encryption mode: %s", mode)
+	}
+
+	return &Encryptor{
+		mode:        mode,
+		provider:    provider,
+		customerKey: customerKeys,
+	}, nil
+}
+
+// Mode returns the encryption mode
+func (e *Encryptor) Mode() string {
+	return e.mode
+}
+
+// Encrypt prepares an object body for upload.
+// It returns the (possibly encrypted) body and the headers to send with the PUT request.
+func (e *Encryptor) Encrypt(ctx context.Context, tenant string, body []byte) ([]byte, map[string]string, error) {
+	switch e.mode {
+	case ModeEnvelope:
+		dataKey, wrapped, keyID, err := e.provider.GenerateDataKey(ctx, tenant)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ciphertext, err := seal(dataKey, body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encrypt object: %w", err)
+		}
+
+		return ciphertext, map[string]string{
+			MetaKeyID:      keyID,
+			MetaWrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+			MetaAlgorithm:  envelopeAlgorithm,
+			MetaTenant:     tenant,
+		}, nil
+
+	case ModeSSEC:
+		headers, err := e.SSECHeaders(tenant)
+		if err != nil {
+			return nil, nil, err
+		}
+		return body, headers, nil
+
+	default:
+		return body, map[string]string{}, nil
+	}
+}
+
+// Decrypt reverses Encrypt for a downloaded object given its response headers.
+// For SSE-C objects the store has already decrypted the body, provided the
+// request carried the headers returned by SSECHeaders.
+func (e *Encryptor) Decrypt(ctx context.Context, body []byte, headers map[string]string) ([]byte, error) {
+	keyID := lookup(headers, MetaKeyID)
+	if keyID == "" {
+		return body, nil
+	}
+
+	if alg := lookup(headers, MetaAlgorithm); alg != envelopeAlgorithm {
+		return nil, fmt.Errorf("unsupported envelope algorithm: %s", alg)
+	}
+	if e.provider == nil {
+		return nil, errors.New("object is envelope encrypted but no key provider is configured")
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(lookup(headers, MetaWrappedKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped key: %w", err)
+	}
+
+	dataKey, err := e.provider.Decrypt(ctx, lookup(headers, MetaTenant), keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := open(dataKey, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt object: %w", err)
+	}
+	return plaintext, nil
+}
+
+// SSECHeaders returns the S3 SSE-C request headers for a tenant
+func (e *Encryptor) SSECHeaders(tenant string) (map[string]string, error) {
+	if e.mode != ModeSSEC {
+		return map[string]string{}, nil
+	}
+
+	key, ok := e.customerKey[tenant]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, tenant)
+	}
+
+	sum := md5.Sum(key)
+	return map[string]string{
+		"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+		"X-Amz-Server-Side-Encryption-Customer-Key":       base64.StdEncoding.EncodeToString(key),
+		"X-Amz-Server-Side-Encryption-Customer-Key-Md5":   base64.StdEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// ParseTenantKeys parses a "tenant:base64key,tenant:base64key" list into a key map
+func ParseTenantKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	if spec == "" {
+		return keys, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		tenant, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || tenant == "" {
+			return nil, fmt.Errorf("invalid tenant key entry: %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for tenant %s: %w", tenant, err)
+		}
+		keys[tenant] = key
+	}
+	return keys, nil
+}
+
+// seal encrypts plaintext with AES-GCM, prefixing the random nonce
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts data produced by seal
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// lookup finds a header value case-insensitively
+func lookup(headers map[string]string, key string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ReadVaultSecret reads a secret from a HashiCorp Vault KV version 2 engine.
+// path is the API path below /v1/, such as secret/data/iot-sensor-fleet, and
+// string fields of the secret are returned.
+func ReadVaultSecret(ctx context.Context, addr, token, path string) (map[string]string, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("no Vault address for secret %s", path)
+	}
+	url := strings.TrimRight(addr, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("failed to read Vault secret %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("failed to decode Vault secret %s: %w", path, err)
+	}
+
+	fields := make(map[string]string, len(secret.Data.Data))
+	for key, value := range secret.Data.Data {
+		if s, ok := value.(string); ok {
+			fields[key] = s
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,644 @@
+// Package parquet writes sensor readings as Apache Parquet files for the cold
+// archive and reads them back. Each reading field is a typed column: strings
+// are UTF-8 byte arrays, the temperature, humidity and battery charge 32-bit
+// floats, the signal strength a 32-bit integer, the location a group of two
+// doubles and the timestamp a 64-bit integer of Unix milliseconds, the same
+// bigint the archive table declares for JSON objects. Fields readings leave
+// unset are nulls. Values are PLAIN-encoded in one page per column chunk,
+// which is compressed with the configured codec and carries min/max
+// statistics so query engines can skip row groups.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs of the column chunks
+const (
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+)
+
+// DefaultRowGroupSize is the number of readings per row group when the
+// configuration does not set one
+const DefaultRowGroupSize = 10000
+
+// ContentType is the media type of Parquet files
+const ContentType = "application/vnd.apache.parquet"
+
+// Extension ends the name of a Parquet file
+const Extension = ".parquet"
+
+// createdBy names the writer in the file metadata
+const createdBy = "iot-sensor-fleet parquet"
+
+// magic starts and ends every Parquet file
+var magic = []byte("PAR1")
+
+// Parquet physical types
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeFloat     = 4
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// Parquet repetition types
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+)
+
+// Parquet encodings, page types, codecs and converted types
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+	pageTypeData  = 0
+	codecNone     = 0
+	codecSnappy   = 1
+	codecGzip     = 2
+	codecZstd     = 6
+	convertedUTF8 = 0
+)
+
+// formatVersion is the Parquet format version written
+const formatVersion = 1
+
+// levelLengthBytes is the size of the length preceding the definition levels
+// of a v1 data page
+const levelLengthBytes = 4
+
+// codecs maps compression names to Parquet codecs
+var codecs = map[string]int32{
+	CompressionNone:   codecNone,
+	CompressionSnappy: codecSnappy,
+	CompressionGzip:   codecGzip,
+	CompressionZstd:   codecZstd,
+}
+
+// Shared zstd coders; EncodeAll and DecodeAll are safe for concurrent use
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+// Config configures a Parquet writer
+type Config struct {
+	// Compression is the codec of the column chunks: CompressionZstd
+	// (default), CompressionSnappy, CompressionGzip or CompressionNone
+	Compression string
+	// RowGroupSize is the number of readings per row group (default
+	// DefaultRowGroupSize)
+	RowGroupSize int
+}
+
+// column is a leaf column of the reading schema
+type column struct {
+	// path is the column's name within its groups
+	path []string
+	typ  int32
+	// optional columns hold nulls, as do the required children of an
+	// optional group
+	optional bool
+	utf8     bool
+
+	// The getter of the column's physical type returns its value in a
+	// reading and whether it is set
+	int32Of  func(*model.SensorReading) (int32, bool)
+	int64Of  func(*model.SensorReading) (int64, bool)
+	floatOf  func(*model.SensorReading) (float32, bool)
+	doubleOf func(*model.SensorReading) (float64, bool)
+	stringOf func(*model.SensorReading) (string, bool)
+}
+
+// locationGroup is the optional group holding the latitude and longitude
+const locationGroup = "location"
+
+// columns are the leaf columns of readings in schema order
+var columns = []column{
+	{path: []string{"id"}, typ: typeByteArray, utf8: true,
+		stringOf: func(r *model.SensorReading) (string, bool) { return r.ID, true }},
+	{path: []string{"ts"}, typ: typeInt64,
+		int64Of: func(r *model.SensorReading) (int64, bool) { return r.Timestamp, true }},
+	{path: []string{"temperature"}, typ: typeFloat,
+		floatOf: func(r *model.SensorReading) (float32, bool) { return r.Temperature, true }},
+	{path: []string{"humidity"}, typ: typeFloat,
+		floatOf: func(r *model.SensorReading) (float32, bool) { return r.Humidity, true }},
+	{path: []string{"site"}, typ: typeByteArray, optional: true, utf8: true,
+		stringOf: func(r *model.SensorReading) (string, bool) { return r.Site, r.Site != "" }},
+	{path: []string{"battery_pct"}, typ: typeFloat, optional: true,
+		floatOf: func(r *model.SensorReading) (float32, bool) {
+			if r.BatteryPct == nil {
+				return 0, false
+			}
+			return *r.BatteryPct, true
+		}},
+	{path: []string{"rssi"}, typ: typeInt32, optional: true,
+		int32Of: func(r *model.SensorReading) (int32, bool) {
+			if r.RSSI == nil {
+				return 0, false
+			}
+			return *r.RSSI, true
+		}},
+	{path: []string{"zone"}, typ: typeByteArray, optional: true, utf8: true,
+		stringOf: func(r *model.SensorReading) (string, bool) { return r.Zone, r.Zone != "" }},
+	{path: []string{locationGroup, "lat"}, typ: typeDouble, optional: true,
+		doubleOf: func(r *model.SensorReading) (float64, bool) {
+			if r.Location == nil {
+				return 0, false
+			}
+			return r.Location.Lat, true
+		}},
+	{path: []string{locationGroup, "lon"}, typ: typeDouble, optional: true,
+		doubleOf: func(r *model.SensorReading) (float64, bool) {
+			if r.Location == nil {
+				return 0, false
+			}
+			return r.Location.Lon, true
+		}},
+}
+
+// Writer writes readings to a Parquet file, a row group at a time. Close
+// must be called to write the remaining readings and the file footer.
+type Writer struct {
+	w      io.Writer
+	config Config
+	codec  int32
+
+	offset    int64
+	pending   []*model.SensorReading
+	rowGroups []rowGroup
+	rows      int64
+	closed    bool
+}
+
+// rowGroup is the metadata of a written row group
+type rowGroup struct {
+	rows              int64
+	offset            int64
+	uncompressedBytes int64
+	compressedBytes   int64
+	chunks            []columnChunk
+}
+
+// columnChunk is the metadata of a written column chunk
+type columnChunk struct {
+	offset            int64
+	values            int64
+	uncompressedBytes int64
+	compressedBytes   int64
+	stats             statistics
+}
+
+// statistics are the null count and PLAIN-encoded bounds of a column chunk;
+// min and max are nil when the chunk has no comparable value
+type statistics struct {
+	nulls    int64
+	min, max []byte
+}
+
+// NewWriter creates a writer of a Parquet file to w
+func NewWriter(w io.Writer, config Config) (*Writer, error) {
+	if config.Compression == "" {
+		config.Compression = CompressionZstd
+	}
+	codec, ok := codecs[config.Compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown parquet compression %q: expected %s, %s, %s or %s",
+			config.Compression, CompressionZstd, CompressionSnappy, CompressionGzip, CompressionNone)
+	}
+	if config.RowGroupSize <= 0 {
+		config.RowGroupSize = DefaultRowGroupSize
+	}
+	if _, err := w.Write(magic); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, config: config, codec: codec, offset: int64(len(magic))}, nil
+}
+
+// Encode returns readings as a Parquet file
+func Encode(readings []*model.SensorReading, config Config) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Write(readings...); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write adds readings to the file, writing a row group each time enough
+// readings are pending
+func (w *Writer) Write(readings ...*model.SensorReading) error {
+	if w.closed {
+		return fmt.Errorf("parquet writer is closed")
+	}
+	for _, reading := range readings {
+		w.pending = append(w.pending, reading)
+		if len(w.pending) == w.config.RowGroupSize {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close writes the pending readings and the file footer. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if len(w.pending) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	footer := w.footer()
+	var tail [4]byte
+	binary.LittleEndian.PutUint32(tail[:], uint32(len(footer)))
+	for _, part := range [][]byte{footer, tail[:], magic} {
+		if _, err := w.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush writes the pending readings as a row group
+func (w *Writer) flush() error {
+	group := rowGroup{rows: int64(len(w.pending)), offset: w.offset}
+	for i := range columns {
+		chunk, err := w.writeChunk(&columns[i], w.pending)
+		if err != nil {
+			return fmt.Errorf("failed to write column %s: %w", columnName(&columns[i]), err)
+		}
+		group.uncompressedBytes += chunk.uncompressedBytes
+		group.compressedBytes += chunk.compressedBytes
+		group.chunks = append(group.chunks, chunk)
+	}
+	w.rowGroups = append(w.rowGroups, group)
+	w.rows += group.rows
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// writeChunk writes a column of readings as a chunk of one data page
+func (w *Writer) writeChunk(col *column, readings []*model.SensorReading) (columnChunk, error) {
+	levels, values, stats := encodeColumn(col, readings)
+
+	var page []byte
+	if col.optional {
+		var length [levelLengthBytes]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(levels)))
+		page = append(append(length[:], levels...), values...)
+	} else {
+		page = values
+	}
+	compressed, err := compress(w.config.Compression, page)
+	if err != nil {
+		return columnChunk{}, err
+	}
+
+	header := newThriftWriter()
+	header.i32(1, pageTypeData)
+	header.i32(2, int32(len(page)))
+	header.i32(3, int32(len(compressed)))
+	header.structField(5, func() {
+		header.i32(1, int32(len(readings)))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		writeStatistics(header, 5, stats)
+	})
+	headerBytes := header.finish()
+
+	chunk := columnChunk{
+		offset:            w.offset,
+		values:            int64(len(readings)),
+		uncompressedBytes: int64(len(headerBytes) + len(page)),
+		compressedBytes:   int64(len(headerBytes) + len(compressed)),
+		stats:             stats,
+	}
+	for _, part := range [][]byte{headerBytes, compressed} {
+		if _, err := w.w.Write(part); err != nil {
+			return columnChunk{}, err
+		}
+	}
+	w.offset += chunk.compressedBytes
+	return chunk, nil
+}
+
+// encodeColumn returns the RLE definition levels, PLAIN values and
+// statistics of a column of readings. Levels are only returned for
+// optional columns.
+func encodeColumn(col *column, readings []*model.SensorReading) ([]byte, []byte, statistics) {
+	var stats statistics
+	var values []byte
+	defined := make([]bool, len(readings))
+	bounds := newBounds(col.typ)
+	for i, reading := range readings {
+		var ok bool
+		switch col.typ {
+		case typeInt32:
+			var v int32
+			if v, ok = col.int32Of(reading); ok {
+				values = binary.LittleEndian.AppendUint32(values, uint32(v))
+				bounds.observe(float64(v), nil)
+			}
+		case typeInt64:
+			var v int64
+			if v, ok = col.int64Of(reading); ok {
+				values = binary.LittleEndian.AppendUint64(values, uint64(v))
+				bounds.observeInt64(v)
+			}
+		case typeFloat:
+			var v float32
+			if v, ok = col.floatOf(reading); ok {
+				values = binary.LittleEndian.AppendUint32(values, math.Float32bits(v))
+				bounds.observe(float64(v), nil)
+			}
+		case typeDouble:
+			var v float64
+			if v, ok = col.doubleOf(reading); ok {
+				values = binary.LittleEndian.AppendUint64(values, math.Float64bits(v))
+				bounds.observe(v, nil)
+			}
+		case typeByteArray:
+			var v string
+			if v, ok = col.stringOf(reading); ok {
+				values = binary.LittleEndian.AppendUint32(values, uint32(len(v)))
+				values = append(values, v...)
+				bounds.observe(0, []byte(v))
+			}
+		}
+		defined[i] = ok
+		if !ok {
+			stats.nulls++
+		}
+	}
+	stats.min, stats.max = bounds.encode()
+
+	if !col.optional {
+		return nil, values, stats
+	}
+	return encodeLevels(defined), values, stats
+}
+
+// encodeLevels encodes definition levels of bit width 1 as RLE runs of the
+// RLE/bit-packing hybrid encoding
+func encodeLevels(defined []bool) []byte {
+	var levels []byte
+	for i := 0; i < len(defined); {
+		j := i
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		levels = binary.AppendUvarint(levels, uint64(j-i)<<1)
+		if defined[i] {
+			levels = append(levels, 1)
+		} else {
+			levels = append(levels, 0)
+		}
+		i = j
+	}
+	return levels
+}
+
+// bounds tracks the minimum and maximum of a column chunk's values
+type bounds struct {
+	typ      int32
+	set      bool
+	nan      bool
+	min, max float64
+	// minInt and maxInt hold INT64 bounds exactly
+	minInt, maxInt int64
+	// minBytes and maxBytes hold BYTE_ARRAY bounds, compared as unsigned
+	// bytes
+	minBytes, maxBytes []byte
+}
+
+func newBounds(typ int32) *bounds {
+	return &bounds{typ: typ}
+}
+
+func (b *bounds) observe(v float64, s []byte) {
+	if b.typ == typeByteArray {
+		if !b.set || bytes.Compare(s, b.minBytes) < 0 {
+			b.minBytes = s
+		}
+		if !b.set || bytes.Compare(s, b.maxBytes) > 0 {
+			b.maxBytes = s
+		}
+		b.set = true
+		return
+	}
+	if math.IsNaN(v) {
+		// NaN has no place in the order, so the bounds are left out
+		b.nan = true
+		return
+	}
+	if !b.set || v < b.min {
+		b.min = v
+	}
+	if !b.set || v > b.max {
+		b.max = v
+	}
+	b.set = true
+}
+
+func (b *bounds) observeInt64(v int64) {
+	if !b.set || v < b.minInt {
+		b.minInt = v
+	}
+	if !b.set || v > b.maxInt {
+		b.maxInt = v
+	}
+	b.set = true
+}
+
+// encode returns the bounds PLAIN-encoded, or nil if there are none
+func (b *bounds) encode() ([]byte, []byte) {
+	if !b.set || b.nan {
+		return nil, nil
+	}
+	switch b.typ {
+	case typeInt32:
+		return binary.LittleEndian.AppendUint32(nil, uint32(int32(b.min))),
+			binary.LittleEndian.AppendUint32(nil, uint32(int32(b.max)))
+	case typeInt64:
+		return binary.LittleEndian.AppendUint64(nil, uint64(b.minInt)),
+			binary.LittleEndian.AppendUint64(nil, uint64(b.maxInt))
+	case typeFloat:
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(b.min))),
+			binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(b.max)))
+	case typeDouble:
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(b.min)),
+			binary.LittleEndian.AppendUint64(nil, math.Float64bits(b.max))
+	default:
+		return append([]byte{}, b.minBytes...), append([]byte{}, b.maxBytes...)
+	}
+}
+
+// writeStatistics writes the statistics struct field id
+func writeStatistics(w *thriftWriter, id int16, stats statistics) {
+	w.structField(id, func() {
+		w.i64(3, stats.nulls)
+		if stats.min != nil {
+			w.binary(5, stats.max)
+			w.binary(6, stats.min)
+		}
+	})
+}
+
+// footer returns the encoded file metadata
+func (w *Writer) footer() []byte {
+	t := newThriftWriter()
+	t.i32(1, formatVersion)
+	t.structList(2, len(schemaElements), func(i int) { schemaElements[i].write(t) })
+	t.i64(3, w.rows)
+	t.structList(4, len(w.rowGroups), func(i int) {
+		group := &w.rowGroups[i]
+		t.structList(1, len(group.chunks), func(j int) {
+			chunk := &group.chunks[j]
+			col := &columns[j]
+			t.i64(2, chunk.offset)
+			t.structField(3, func() {
+				t.i32(1, col.typ)
+				t.i32List(2, []int32{encodingPlain, encodingRLE})
+				t.stringList(3, col.path)
+				t.i32(4, w.codec)
+				t.i64(5, chunk.values)
+				t.i64(6, chunk.uncompressedBytes)
+				t.i64(7, chunk.compressedBytes)
+				t.i64(9, chunk.offset)
+				writeStatistics(t, 12, chunk.stats)
+			})
+		})
+		t.i64(2, group.uncompressedBytes)
+		t.i64(3, group.rows)
+		t.i64(5, group.offset)
+		t.i64(6, group.compressedBytes)
+		t.field(7, thriftI16)
+		t.buf = binary.AppendVarint(t.buf, int64(i))
+	})
+	t.string(6, createdBy)
+	// Every column is ordered by its type, so readers trust min and max
+	t.structList(7, len(columns), func(int) {
+		t.structField(1, func() {})
+	})
+	return t.finish()
+}
+
+// schemaElement is an element of the flattened schema tree
+type schemaElement struct {
+	name       string
+	typ        int32
+	repetition int32
+	children   int32
+	utf8       bool
+}
+
+// schemaElements is the schema of readings in depth-first order: the root,
+// then the leaf columns with the location group before its children
+var schemaElements = buildSchema()
+
+func buildSchema() []schemaElement {
+	elements := []schemaElement{{name: "schema", repetition: -1}}
+	for i := range columns {
+		col := &columns[i]
+		if len(col.path) == 2 {
+			if col.path[1] == "lat" {
+				elements = append(elements, schemaElement{name: col.path[0], repetition: repetitionOptional, children: 2})
+			}
+			elements = append(elements, schemaElement{name: col.path[1], typ: col.typ, repetition: repetitionRequired})
+		} else {
+			repetition := int32(repetitionRequired)
+			if col.optional {
+				repetition = repetitionOptional
+			}
+			elements = append(elements, schemaElement{name: col.path[0], typ: col.typ, repetition: repetition, utf8: col.utf8})
+		}
+		if len(col.path) == 1 || col.path[1] == "lat" {
+			elements[0].children++
+		}
+	}
+	return elements
+}
+
+func (e *schemaElement) write(t *thriftWriter) {
+	leaf := e.children == 0 && e.repetition >= 0
+	if leaf {
+		t.i32(1, e.typ)
+	}
+	if e.repetition >= 0 {
+		t.i32(3, e.repetition)
+	}
+	t.string(4, e.name)
+	if !leaf {
+		t.i32(5, e.children)
+	}
+	if e.utf8 {
+		t.i32(6, convertedUTF8)
+		// LogicalType STRING
+		t.structField(10, func() {
+			t.structField(1, func() {})
+		})
+	}
+}
+
+// columnName returns the dotted path of a column
+func columnName(col *column) string {
+	name := col.path[0]
+	for _, part := range col.path[1:] {
+		name += "." + part
+	}
+	return name
+}
+
+// compress compresses a page with a codec
+func compress(compression string, page []byte) ([]byte, error) {
+	switch compression {
+	case CompressionSnappy:
+		return snappy.Encode(nil, page), nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(page); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		return encoder.EncodeAll(page, nil), nil
+	default:
+		return page, nil
+	}
+}
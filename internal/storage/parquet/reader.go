@@ -0,0 +1,291 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/klauspost/compress/snappy"
+)
+
+// ErrInvalid is returned for data that is not a Parquet file of readings
+var ErrInvalid = errors.New("invalid parquet file")
+
+// Read decodes the readings of a Parquet file. It reads the files Writer
+// writes, and others with the same columns in uncompressed, snappy, gzip or
+// zstd PLAIN-encoded data pages; columns it does not know are skipped.
+func Read(data []byte) ([]*model.SensorReading, error) {
+	if len(data) < 2*len(magic)+4 || !bytes.Equal(data[:len(magic)], magic) || !bytes.Equal(data[len(data)-len(magic):], magic) {
+		return nil, fmt.Errorf("%w: missing magic number", ErrInvalid)
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-len(magic)-4:]))
+	footerStart := len(data) - len(magic) - 4 - footerLength
+	if footerLength <= 0 || footerStart < len(magic) {
+		return nil, fmt.Errorf("%w: footer length %d out of range", ErrInvalid, footerLength)
+	}
+	footer := &thriftReader{data: data[footerStart : len(data)-len(magic)-4]}
+	metadata, err := footer.readStruct(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode footer: %v", ErrInvalid, err)
+	}
+
+	rows, _ := metadata.int(3)
+	if rows < 0 || rows > int64(len(data)) {
+		// Every row takes at least a byte, so more rows than bytes is corrupt
+		return nil, fmt.Errorf("%w: %d rows out of range", ErrInvalid, rows)
+	}
+	readings := make([]*model.SensorReading, 0, rows)
+	for _, group := range metadata.list(4) {
+		group, ok := group.(thriftFields)
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed row group", ErrInvalid)
+		}
+		groupRows, _ := group.int(3)
+		if groupRows < 0 || int64(len(readings))+groupRows > rows {
+			return nil, fmt.Errorf("%w: row group of %d rows out of range", ErrInvalid, groupRows)
+		}
+		groupReadings := make([]*model.SensorReading, groupRows)
+		for i := range groupReadings {
+			groupReadings[i] = &model.SensorReading{}
+		}
+		for _, chunk := range group.list(1) {
+			chunk, ok := chunk.(thriftFields)
+			if !ok {
+				return nil, fmt.Errorf("%w: malformed column chunk", ErrInvalid)
+			}
+			if err := readChunk(data[:footerStart], chunk.strct(3), groupReadings); err != nil {
+				return nil, err
+			}
+		}
+		readings = append(readings, groupReadings...)
+	}
+	return readings, nil
+}
+
+// readChunk decodes the data pages of a column chunk into readings
+func readChunk(data []byte, meta thriftFields, readings []*model.SensorReading) error {
+	if meta == nil {
+		return fmt.Errorf("%w: column chunk has no metadata", ErrInvalid)
+	}
+	var path []string
+	for _, part := range meta.list(3) {
+		part, _ := part.([]byte)
+		path = append(path, string(part))
+	}
+	name := strings.Join(path, ".")
+	col := columnByName(name)
+	if col == nil {
+		return nil
+	}
+	if typ, _ := meta.int(1); int32(typ) != col.typ {
+		return fmt.Errorf("%w: column %s has type %d, expected %d", ErrInvalid, name, typ, col.typ)
+	}
+	codec, _ := meta.int(4)
+	if _, ok := meta.int(11); ok {
+		return fmt.Errorf("%w: column %s is dictionary-encoded, which is not supported", ErrInvalid, name)
+	}
+
+	offset, _ := meta.int(9)
+	row := 0
+	for row < len(readings) {
+		if offset < 0 || offset >= int64(len(data)) {
+			return fmt.Errorf("%w: column %s page offset %d out of range", ErrInvalid, name, offset)
+		}
+		header := &thriftReader{data: data[offset:]}
+		page, err := header.readStruct(0)
+		if err != nil {
+			return fmt.Errorf("%w: failed to decode page header of column %s: %v", ErrInvalid, name, err)
+		}
+		size, _ := page.int(3)
+		start := offset + int64(header.pos)
+		if size < 0 || start+size > int64(len(data)) {
+			return fmt.Errorf("%w: column %s page of %d bytes out of range", ErrInvalid, name, size)
+		}
+		offset = start + size
+
+		if typ, _ := page.int(1); typ != pageTypeData {
+			return fmt.Errorf("%w: column %s has page type %d, only v1 data pages are supported", ErrInvalid, name, typ)
+		}
+		dataPage := page.strct(5)
+		values, _ := dataPage.int(1)
+		if encoding, _ := dataPage.int(2); encoding != encodingPlain {
+			return fmt.Errorf("%w: column %s has encoding %d, only PLAIN is supported", ErrInvalid, name, encoding)
+		}
+		if values < 0 || row+int(values) > len(readings) {
+			return fmt.Errorf("%w: column %s page of %d values out of range", ErrInvalid, name, values)
+		}
+		uncompressedSize, _ := page.int(2)
+		body, err := decompress(codec, data[start:offset], uncompressedSize)
+		if err != nil {
+			return fmt.Errorf("%w: failed to decompress column %s: %v", ErrInvalid, name, err)
+		}
+		if err := decodePage(col, body, readings[row:row+int(values)]); err != nil {
+			return fmt.Errorf("%w: column %s: %v", ErrInvalid, name, err)
+		}
+		row += int(values)
+	}
+	return nil
+}
+
+// decodePage decodes the definition levels and PLAIN values of a page into
+// readings
+func decodePage(col *column, body []byte, readings []*model.SensorReading) error {
+	defined := make([]bool, len(readings))
+	if col.optional {
+		if len(body) < levelLengthBytes {
+			return io.ErrUnexpectedEOF
+		}
+		length := int(binary.LittleEndian.Uint32(body))
+		if length < 0 || levelLengthBytes+length > len(body) {
+			return io.ErrUnexpectedEOF
+		}
+		if err := decodeLevels(body[levelLengthBytes:levelLengthBytes+length], defined); err != nil {
+			return err
+		}
+		body = body[levelLengthBytes+length:]
+	} else {
+		for i := range defined {
+			defined[i] = true
+		}
+	}
+
+	for i, reading := range readings {
+		if !defined[i] {
+			continue
+		}
+		var size int
+		switch col.typ {
+		case typeInt32, typeFloat:
+			size = 4
+		case typeInt64, typeDouble:
+			size = 8
+		case typeByteArray:
+			if len(body) < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			size = 4 + int(binary.LittleEndian.Uint32(body))
+		}
+		if size < 0 || len(body) < size {
+			return io.ErrUnexpectedEOF
+		}
+		setValue(col, reading, body[:size])
+		body = body[size:]
+	}
+	return nil
+}
+
+// setValue sets the field of a reading a column holds to a PLAIN value
+func setValue(col *column, reading *model.SensorReading, value []byte) {
+	switch columnName(col) {
+	case "id":
+		reading.ID = string(value[4:])
+	case "ts":
+		reading.Timestamp = int64(binary.LittleEndian.Uint64(value))
+	case "temperature":
+		reading.Temperature = math.Float32frombits(binary.LittleEndian.Uint32(value))
+	case "humidity":
+		reading.Humidity = math.Float32frombits(binary.LittleEndian.Uint32(value))
+	case "site":
+		reading.Site = string(value[4:])
+	case "battery_pct":
+		v := math.Float32frombits(binary.LittleEndian.Uint32(value))
+		reading.BatteryPct = &v
+	case "rssi":
+		v := int32(binary.LittleEndian.Uint32(value))
+		reading.RSSI = &v
+	case "zone":
+		reading.Zone = string(value[4:])
+	case "location.lat", "location.lon":
+		if reading.Location == nil {
+			reading.Location = &model.GeoPoint{}
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(value))
+		if col.path[1] == "lat" {
+			reading.Location.Lat = v
+		} else {
+			reading.Location.Lon = v
+		}
+	}
+}
+
+// decodeLevels decodes definition levels of bit width 1 in the RLE/bit-
+// packing hybrid encoding
+func decodeLevels(data []byte, defined []bool) error {
+	i := 0
+	for i < len(defined) {
+		header, n := binary.Uvarint(data)
+		if n <= 0 {
+			return io.ErrUnexpectedEOF
+		}
+		data = data[n:]
+		if header&1 == 0 {
+			// RLE run of one byte-wide value
+			if len(data) < 1 {
+				return io.ErrUnexpectedEOF
+			}
+			count := int(header >> 1)
+			if count > len(defined)-i {
+				count = len(defined) - i
+			}
+			for j := 0; j < count; j++ {
+				defined[i+j] = data[0] != 0
+			}
+			data = data[1:]
+			i += count
+			continue
+		}
+		// Bit-packed run of groups of eight values, least significant bit
+		// first
+		groups := int(header >> 1)
+		if len(data) < groups {
+			return io.ErrUnexpectedEOF
+		}
+		for bit := 0; bit < groups*8 && i < len(defined); bit++ {
+			defined[i] = data[bit/8]>>(bit%8)&1 != 0
+			i++
+		}
+		data = data[groups:]
+	}
+	return nil
+}
+
+// decompress decompresses a page with a Parquet codec
+func decompress(codec int64, page []byte, uncompressedSize int64) ([]byte, error) {
+	switch codec {
+	case codecNone:
+		return page, nil
+	case codecSnappy:
+		return snappy.Decode(nil, page)
+	case codecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(io.LimitReader(zr, uncompressedSize))
+	case codecZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(page, nil)
+	default:
+		return nil, fmt.Errorf("unsupported codec %d", codec)
+	}
+}
+
+// columnByName returns the column with a dotted path, or nil
+func columnByName(name string) *column {
+	for i := range columns {
+		if columnName(&columns[i]) == name {
+			return &columns[i]
+		}
+	}
+	return nil
+}
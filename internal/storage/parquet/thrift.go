@@ -0,0 +1,281 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Thrift compact protocol types
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+)
+
+// maxThriftDepth bounds the nesting of decoded structs, so a corrupt footer
+// cannot exhaust the stack
+const maxThriftDepth = 32
+
+var errThriftTruncated = errors.New("truncated thrift data")
+
+// thriftWriter encodes Thrift structs with the compact protocol. Fields must
+// be written in increasing ID order within each struct.
+type thriftWriter struct {
+	buf []byte
+	// last holds the ID of the last field written in each open struct
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+// finish ends the top-level struct and returns its encoding
+func (w *thriftWriter) finish() []byte {
+	w.buf = append(w.buf, 0)
+	return w.buf
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.buf = binary.AppendVarint(w.buf, int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *thriftWriter) binary(id int16, v []byte) {
+	w.field(id, thriftBinary)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *thriftWriter) string(id int16, v string) {
+	w.binary(id, []byte(v))
+}
+
+// structField writes a struct field whose fields are written by fn
+func (w *thriftWriter) structField(id int16, fn func()) {
+	w.field(id, thriftStruct)
+	w.structBody(fn)
+}
+
+func (w *thriftWriter) structBody(fn func()) {
+	w.last = append(w.last, 0)
+	fn()
+	w.buf = append(w.buf, 0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) listHeader(id int16, elem byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|elem)
+	} else {
+		w.buf = append(w.buf, 0xf0|elem)
+		w.buf = binary.AppendUvarint(w.buf, uint64(n))
+	}
+}
+
+// structList writes a list of n structs, the fields of the i-th written by fn
+func (w *thriftWriter) structList(id int16, n int, fn func(i int)) {
+	w.listHeader(id, thriftStruct, n)
+	for i := 0; i < n; i++ {
+		w.structBody(func() { fn(i) })
+	}
+}
+
+func (w *thriftWriter) i32List(id int16, values []int32) {
+	w.listHeader(id, thriftI32, len(values))
+	for _, v := range values {
+		w.buf = binary.AppendVarint(w.buf, int64(v))
+	}
+}
+
+func (w *thriftWriter) stringList(id int16, values []string) {
+	w.listHeader(id, thriftBinary, len(values))
+	for _, v := range values {
+		w.buf = binary.AppendUvarint(w.buf, uint64(len(v)))
+		w.buf = append(w.buf, v...)
+	}
+}
+
+// thriftFields is a decoded Thrift struct: its fields by ID, holding int64
+// for integers, bool, float64, []byte, thriftFields or []any for lists
+type thriftFields map[int16]any
+
+func (s thriftFields) int(id int16) (int64, bool) {
+	v, ok := s[id].(int64)
+	return v, ok
+}
+
+func (s thriftFields) strct(id int16) thriftFields {
+	v, _ := s[id].(thriftFields)
+	return v
+}
+
+func (s thriftFields) list(id int16) []any {
+	v, _ := s[id].([]any)
+	return v
+}
+
+// thriftReader decodes compact protocol Thrift structs
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+// readStruct decodes the struct at the reader's position
+func (r *thriftReader) readStruct(depth int) (thriftFields, error) {
+	if depth > maxThriftDepth {
+		return nil, errors.New("thrift data nested too deeply")
+	}
+	s := make(thriftFields)
+	var last int16
+	for {
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if header == 0 {
+			return s, nil
+		}
+
+		typ := header & 0x0f
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		last = id
+
+		var value any
+		switch typ {
+		case thriftBoolTrue:
+			value = true
+		case thriftBoolFalse:
+			value = false
+		default:
+			value, err = r.readValue(typ, depth)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s[id] = value
+	}
+}
+
+// readValue decodes a value of a type other than a bool field
+func (r *thriftReader) readValue(typ byte, depth int) (any, error) {
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+		// Bools inside lists take a byte each
+		b, err := r.byte()
+		return b == thriftBoolTrue, err
+	case thriftByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.varint()
+	case thriftDouble:
+		if len(r.data)-r.pos < 8 {
+			return nil, errThriftTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case thriftBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(r.data)-r.pos) < n {
+			return nil, errThriftTruncated
+		}
+		v := r.data[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return v, nil
+	case thriftList, thriftSet:
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(header >> 4)
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		// Every element takes at least a byte
+		if uint64(len(r.data)-r.pos) < n {
+			return nil, errThriftTruncated
+		}
+		values := make([]any, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v, err := r.readValue(header&0x0f, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case thriftStruct:
+		return r.readStruct(depth + 1)
+	default:
+		return nil, fmt.Errorf("unsupported thrift type %d", typ)
+	}
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errThriftTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	r.pos += n
+	return v, nil
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+)
+
+// s3Region is the signing region; MinIO accepts us-east-1 unless configured otherwise
+const s3Region = "us-east-1"
+
+// ObjectStore stores objects by key
+type ObjectStore interface {
+	// Put writes an object; headers carry metadata and encryption parameters
+	Put(ctx context.Context, key string, body []byte, headers map[string]string) error
+}
+
+// ObjectReader reads objects by key
+type ObjectReader interface {
+	// Get reads an object, returning ErrNotFound if it does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// ObjectLister lists the keys of stored objects
+type ObjectLister interface {
+	// List returns the keys of every object whose key starts with prefix, in
+	// lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// S3Store is a minimal S3-compatible object store client for MinIO using
+// path-style requests signed with AWS Signature Version 4
+type S3Store struct {
+	endpoint  *url.URL
+	accessKey string
+	secretKey string
+	bucket    string
+	client    *http.Client
+}
+
+// NewS3Store creates a client for bucket at endpoint. An endpoint without a
+// scheme, such as "localhost:9000", is reached over plain HTTP.
+func NewS3Store(endpoint, accessKey, secretKey, bucket string) (*S3Store, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid object store endpoint %q", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("object store bucket is not set")
+	}
+
+	return &S3Store{
+		endpoint:  u,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		bucket:    bucket,
+		client:    &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// NewS3StoreFromConfig creates a client for the configured MinIO bucket
+func NewS3StoreFromConfig(cfg *config.Config) (*S3Store, error) {
+	return NewS3Store(cfg.MinioEndpoint, cfg.MinioAccessKey, cfg.MinioSecretKey, cfg.MinioBucket)
+}
+
+// Put uploads an object
+func (s *S3Store) Put(ctx context.Context, key string, body []byte, headers map[string]string) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, nil, body, headers)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to put object %s: %s: %s", key, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Get downloads an object
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	body, _, err := s.GetWithHeaders(ctx, key, nil)
+	return body, err
+}
+
+// GetWithHeaders downloads an object, sending headers such as SSE-C keys,
+// and returns it with its response headers, which carry its metadata
+func (s *S3Store) GetWithHeaders(ctx context.Context, key string, headers map[string]string) ([]byte, map[string]string, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil, nil, headers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, nil, fmt.Errorf("failed to get object %s: %s: %s", key, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read object %s: %w", key, err)
+	}
+	metadata := make(map[string]string, len(resp.Header))
+	for name := range resp.Header {
+		metadata[name] = resp.Header.Get(name)
+	}
+	return body, metadata, nil
+}
+
+// List lists the keys under prefix with ListObjectsV2, a page at a time
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+		}
+		var result struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+		}
+		if resp.StatusCode/100 != 2 {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to list objects under %s: %s: %s", prefix, resp.Status, bytes.TrimSpace(msg))
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object list: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// newRequest builds a signed path-style request for an object, or for the
+// bucket when key is empty
+func (s *S3Store) newRequest(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) (*http.Request, error) {
+	path := "/" + s.bucket
+	if key != "" {
+		path += "/" + strings.TrimPrefix(key, "/")
+	}
+	u := *s.endpoint
+	u.Path = path
+	u.RawPath = s3EscapePath(path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object request: %w", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	s.sign(req, body, time.Now().UTC())
+	return req, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign the host and every x-amz-* header, including metadata and SSE-C keys
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s3Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s3Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath URI-encodes every byte of a path except unreserved characters and '/'
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes a query string as Signature Version 4 expects:
+// sorted by name, with spaces as %20 rather than '+'
+func s3CanonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,79 @@
+// Package tracing exports OpenTelemetry spans over OTLP/HTTP and holds the
+// helpers the services use to record them.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/example/iot-sensor-fleet/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Provider exports the spans of a service until it is shut down
+type Provider struct {
+	provider *sdktrace.TracerProvider
+}
+
+// NewFromConfig sets up tracing for service when OTEL_EXPORTER_OTLP_ENDPOINT
+// is set, or returns nil. It installs the global tracer provider, which the
+// tracers of every package follow, and the W3C trace context propagator.
+// The exporter reads its endpoint, headers and timeouts from the standard
+// OTEL_EXPORTER_OTLP_* variables, and OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES override the resource.
+func NewFromConfig(service string, cfg *config.Config) (*Provider, error) {
+	if cfg.OTLPEndpoint == "" {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName(service)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tracing resource: %w", err)
+	}
+
+	// Traces started upstream are kept or dropped as their producer decided
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return &Provider{provider: provider}, nil
+}
+
+// Shutdown exports the buffered spans and stops the exporter. It does
+// nothing on a nil provider, so services can call it unconditionally.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	return p.provider.Shutdown(ctx)
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
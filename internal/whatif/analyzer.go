@@ -0,0 +1,243 @@
+package whatif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/aggregate"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// defaultTopSensors bounds the per-sensor breakdown of a report
+const defaultTopSensors = 20
+
+// ErrInvalidRange is returned when a scan range is empty or reversed
+var ErrInvalidRange = errors.New("whatif: invalid time range")
+
+// Scenario is a set of thresholds and site rules to replay history against
+type Scenario struct {
+	MaxTemperature float32              `json:"max_temperature"`
+	MinHumidity    float32              `json:"min_humidity"`
+	SiteRules      []aggregate.SiteRule `json:"-"`
+}
+
+// check returns the sensor rule a reading violates under the scenario
+func (s Scenario) check(reading *model.SensorReading) string {
+	rule, _ := model.CheckThresholds(reading, s.MaxTemperature, s.MinHumidity)
+	return rule
+}
+
+// Outcome counts the alerts one scenario would have raised
+type Outcome struct {
+	SensorAlerts int            `json:"sensor_alerts"`
+	SiteAlerts   int            `json:"site_alerts"`
+	ByRule       map[string]int `json:"by_rule"`
+	BySite       map[string]int `json:"by_site"`
+	Sensors      int            `json:"alerting_sensors"`
+}
+
+// SiteCount compares alert counts for one site
+type SiteCount struct {
+	Site     string `json:"site"`
+	Baseline int    `json:"baseline"`
+	Proposed int    `json:"proposed"`
+}
+
+// SensorCount compares alert counts for one sensor
+type SensorCount struct {
+	SensorID string `json:"sensor_id"`
+	Site     string `json:"site,omitempty"`
+	Baseline int    `json:"baseline"`
+	Proposed int    `json:"proposed"`
+}
+
+// Report is the result of replaying history against a baseline and a proposed scenario
+type Report struct {
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	Readings int           `json:"readings"`
+	Windows  int           `json:"windows"`
+	Baseline Outcome       `json:"baseline"`
+	Proposed Outcome       `json:"proposed"`
+	Sites    []SiteCount   `json:"sites"`
+	Sensors  []SensorCount `json:"top_sensors"`
+}
+
+// Analyzer replays historical readings through the sensor thresholds and
+// site rules without raising any alerts
+type Analyzer struct {
+	source ReadingSource
+	window time.Duration
+	top    int
+}
+
+// NewAnalyzer creates a new analyzer; window is the site aggregation window and
+// top bounds the per-sensor breakdown (0 uses the default)
+func NewAnalyzer(source ReadingSource, window time.Duration, top int) *Analyzer {
+	if top <= 0 {
+		top = defaultTopSensors
+	}
+	return &Analyzer{
+		source: source,
+		window: window,
+		top:    top,
+	}
+}
+
+// evaluation is the replay state of one scenario
+type evaluation struct {
+	scenario Scenario
+	acc      *aggregate.Accumulator
+	engine   *aggregate.RuleEngine
+	outcome  Outcome
+	sensors  map[string]int
+}
+
+func newEvaluation(scenario Scenario) *evaluation {
+	return &evaluation{
+		scenario: scenario,
+		acc:      aggregate.NewAccumulator(),
+		engine:   aggregate.NewDryRunEngine(scenario.SiteRules),
+		outcome:  Outcome{ByRule: make(map[string]int), BySite: make(map[string]int)},
+		sensors:  make(map[string]int),
+	}
+}
+
+// add applies the scenario's thresholds to a reading and returns whether it alerted
+func (e *evaluation) add(reading *model.SensorReading) bool {
+	e.acc.AddReading(reading)
+
+	rule := e.scenario.check(reading)
+	if rule == "" {
+		return false
+	}
+	e.acc.AddAlert(&model.SensorAlert{SensorID: reading.ID, Site: reading.Site})
+	e.outcome.SensorAlerts++
+	e.outcome.ByRule[rule]++
+	if reading.Site != "" {
+		e.outcome.BySite[reading.Site]++
+	}
+	e.sensors[reading.ID]++
+	return true
+}
+
+// flush evaluates the site rules over a closed window
+func (e *evaluation) flush(ctx context.Context, start, end time.Time) {
+	for _, agg := range e.acc.Flush(start, end) {
+		for _, alert := range e.engine.Evaluate(ctx, agg) {
+			e.outcome.SiteAlerts++
+			e.outcome.ByRule[alert.Rule]++
+			e.outcome.BySite[alert.Site]++
+		}
+	}
+}
+
+// Analyze replays the readings in [from, to) against both scenarios
+func (a *Analyzer) Analyze(ctx context.Context, from, to time.Time, baseline, proposed Scenario) (*Report, error) {
+	if !to.After(from) {
+		return nil, ErrInvalidRange
+	}
+
+	report := &Report{From: from, To: to}
+	evaluations := []*evaluation{newEvaluation(baseline), newEvaluation(proposed)}
+	sites := make(map[string]string)
+
+	// Windows are aligned to the start of the range so both scenarios see identical aggregates
+	windowStart := from
+	flush := func(until time.Time) {
+		for !windowStart.Add(a.window).After(until) {
+			end := windowStart.Add(a.window)
+			for _, e := range evaluations {
+				e.flush(ctx, windowStart, end)
+			}
+			report.Windows++
+			windowStart = end
+		}
+	}
+
+	err := a.source.Scan(ctx, from, to, func(reading *model.SensorReading) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		flush(time.UnixMilli(reading.Timestamp))
+
+		report.Readings++
+		for _, e := range evaluations {
+			if e.add(reading) && reading.Site != "" {
+				sites[reading.ID] = reading.Site
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay readings: %w", err)
+	}
+	flush(to)
+
+	for _, e := range evaluations {
+		e.outcome.Sensors = len(e.sensors)
+	}
+	report.Baseline = evaluations[0].outcome
+	report.Proposed = evaluations[1].outcome
+	report.Sites = compareSites(report.Baseline.BySite, report.Proposed.BySite)
+	report.Sensors = topSensors(evaluations[0].sensors, evaluations[1].sensors, sites, a.top)
+	return report, nil
+}
+
+// compareSites merges per-site counts sorted by site
+func compareSites(baseline, proposed map[string]int) []SiteCount {
+	merged := make(map[string]*SiteCount)
+	for site, n := range baseline {
+		merged[site] = &SiteCount{Site: site, Baseline: n}
+	}
+	for site, n := range proposed {
+		if c, ok := merged[site]; ok {
+			c.Proposed = n
+		} else {
+			merged[site] = &SiteCount{Site: site, Proposed: n}
+		}
+	}
+
+	counts := make([]SiteCount, 0, len(merged))
+	for _, c := range merged {
+		counts = append(counts, *c)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Site < counts[j].Site })
+	return counts
+}
+
+// topSensors returns the sensors whose alert count changes most between the scenarios
+func topSensors(baseline, proposed map[string]int, sites map[string]string, top int) []SensorCount {
+	counts := make([]SensorCount, 0, len(baseline))
+	for id, n := range baseline {
+		counts = append(counts, SensorCount{SensorID: id, Site: sites[id], Baseline: n, Proposed: proposed[id]})
+	}
+	for id, n := range proposed {
+		if _, ok := baseline[id]; !ok {
+			counts = append(counts, SensorCount{SensorID: id, Site: sites[id], Proposed: n})
+		}
+	}
+
+	delta := func(c SensorCount) int {
+		if c.Proposed > c.Baseline {
+			return c.Proposed - c.Baseline
+		}
+		return c.Baseline - c.Proposed
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if di, dj := delta(counts[i]), delta(counts[j]); di != dj {
+			return di > dj
+		}
+		if counts[i].Proposed != counts[j].Proposed {
+			return counts[i].Proposed > counts[j].Proposed
+		}
+		return counts[i].SensorID < counts[j].SensorID
+	})
+	if len(counts) > top {
+		counts = counts[:top]
+	}
+	return counts
+}
@@ -0,0 +1,109 @@
+package whatif
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/aggregate"
+	"github.com/example/iot-sensor-fleet/internal/logging"
+)
+
+// defaultLookback is the scan range when a request does not specify one
+const defaultLookback = 24 * time.Hour
+
+// Request is a what-if query; unset thresholds and rules fall back to the baseline
+type Request struct {
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	MaxTemperature *float32  `json:"max_temperature,omitempty"`
+	MinHumidity    *float32  `json:"min_humidity,omitempty"`
+	SiteRules      *string   `json:"site_rules,omitempty"`
+}
+
+// Scenario resolves the request against a baseline scenario
+func (r *Request) Scenario(baseline Scenario) (Scenario, error) {
+	proposed := baseline
+	if r.MaxTemperature != nil {
+		proposed.MaxTemperature = *r.MaxTemperature
+	}
+	if r.MinHumidity != nil {
+		proposed.MinHumidity = *r.MinHumidity
+	}
+	if r.SiteRules != nil {
+		rules, err := aggregate.ParseSiteRules(*r.SiteRules)
+		if err != nil {
+			return Scenario{}, err
+		}
+		proposed.SiteRules = rules
+	}
+	return proposed, nil
+}
+
+// Handler exposes the analyzer over HTTP
+type Handler struct {
+	analyzer *Analyzer
+	baseline Scenario
+	logger   *slog.Logger
+}
+
+// NewHandler creates a new HTTP handler comparing proposals against baseline;
+// logger may be nil
+func NewHandler(analyzer *Analyzer, baseline Scenario, logger *slog.Logger) *Handler {
+	return &Handler{
+		analyzer: analyzer,
+		baseline: baseline,
+		logger:   logging.OrDefault(logger),
+	}
+}
+
+// Register mounts the what-if routes on a mux
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/whatif", h.analyze)
+}
+
+// analyze replays history against the proposed thresholds
+func (h *Handler) analyze(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.From.IsZero() {
+		req.From = req.To.Add(-defaultLookback)
+	}
+
+	proposed, err := req.Scenario(h.baseline)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := h.analyzer.Analyze(r.Context(), req.From, req.To, h.baseline, proposed)
+	switch {
+	case errors.Is(err, ErrInvalidRange):
+		writeError(w, http.StatusBadRequest, "to must be after from")
+	case err != nil:
+		h.logger.Error("What-if analysis failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "analysis failed")
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
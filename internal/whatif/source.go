@@ -0,0 +1,63 @@
+package whatif
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/db"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/example/iot-sensor-fleet/internal/storage/encryption"
+)
+
+// ReadingSource streams historical readings in timestamp order.
+// Postgres is the only source today; cold archives can be added behind the same interface.
+type ReadingSource interface {
+	Scan(ctx context.Context, from, to time.Time, fn func(*model.SensorReading) error) error
+}
+
+// PostgresSource reads historical readings from the sensor_readings table,
+// on a read replica when one is healthy
+type PostgresSource struct {
+	postgres *db.PostgresDB
+	columns  *encryption.Columns
+}
+
+// NewPostgresSource creates a new Postgres reading source; columns decrypts
+// encrypted sites and may be nil when no column keys are configured
+func NewPostgresSource(postgres *db.PostgresDB, columns *encryption.Columns) *PostgresSource {
+	return &PostgresSource{postgres: postgres, columns: columns}
+}
+
+// Scan calls fn for every reading in [from, to) ordered by timestamp
+func (s *PostgresSource) Scan(ctx context.Context, from, to time.Time, fn func(*model.SensorReading) error) error {
+	rows, err := s.postgres.ReadDB().QueryContext(ctx, `
+		SELECT sensor_id, ts, temperature, humidity, COALESCE(site, '')
+		FROM sensor_readings
+		WHERE ts >= $1 AND ts < $2
+		ORDER BY ts
+	`, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to query readings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reading model.SensorReading
+		if err := rows.Scan(&reading.ID, &reading.Timestamp, &reading.Temperature, &reading.Humidity, &reading.Site); err != nil {
+			return fmt.Errorf("failed to scan reading: %w", err)
+		}
+		site, err := s.columns.Open(encryption.ColumnSite, reading.Site)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt reading: %w", err)
+		}
+		reading.Site = site
+		if err := fn(&reading); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read readings: %w", err)
+	}
+	return nil
+}
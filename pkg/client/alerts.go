@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/api/grpc/alertspb"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// AlertFilter selects the live alerts of a subscription
+type AlertFilter struct {
+	// SensorIDs restricts alerts to these sensors (empty for every sensor)
+	SensorIDs []string
+	// Reason is a case-insensitive substring of the alert reason
+	Reason string
+}
+
+// SubscribeAlerts calls fn for every live alert matching filter until ctx is
+// done, fn returns an error, or the stream fails for good. Interrupted
+// streams are resubscribed with the client's retry policy; the attempts
+// start over once an alert arrives. Alerts raised while no stream is open
+// are not delivered, since the service only pushes new alerts.
+func (c *Client) SubscribeAlerts(ctx context.Context, filter AlertFilter, fn func(*model.SensorAlert) error) error {
+	conn, err := c.grpcClient()
+	if err != nil {
+		return err
+	}
+	service := alertspb.NewAlertServiceClient(conn)
+	request := &alertspb.SubscribeAlertsRequest{SensorIds: filter.SensorIDs, Reason: filter.Reason}
+
+	delay := c.initialBackoff
+	for attempt := 1; ; attempt++ {
+		received, err := c.subscribe(ctx, service, request, fn)
+		if received {
+			attempt, delay = 1, c.initialBackoff
+		}
+
+		var stop *callbackError
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &stop):
+			return stop.err
+		case attempt >= c.maxAttempts || !retryableStream(err):
+			return fmt.Errorf("alert subscription failed: %w", err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+		if delay > c.maxBackoff {
+			delay = c.maxBackoff
+		}
+	}
+}
+
+// callbackError carries an error returned by the subscription callback
+type callbackError struct {
+	err error
+}
+
+func (e *callbackError) Error() string {
+	return e.err.Error()
+}
+
+// subscribe runs one stream and reports whether it delivered any alert
+func (c *Client) subscribe(ctx context.Context, service alertspb.AlertServiceClient, request *alertspb.SubscribeAlertsRequest, fn func(*model.SensorAlert) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := service.SubscribeAlerts(ctx, request)
+	if err != nil {
+		return false, err
+	}
+	received := false
+	for {
+		alert, err := stream.Recv()
+		if err != nil {
+			return received, err
+		}
+		received = true
+		if err := fn(fromProto(alert)); err != nil {
+			return received, &callbackError{err: err}
+		}
+	}
+}
+
+// retryableStream reports whether a subscription may succeed when reopened:
+// after the server went away or shut down, or the stream ended unexpectedly
+func retryableStream(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// grpcClient returns the gRPC connection, opening it on first use
+func (c *Client) grpcClient() (*grpc.ClientConn, error) {
+	c.grpcMu.Lock()
+	defer c.grpcMu.Unlock()
+	if c.grpcConn != nil {
+		return c.grpcConn, nil
+	}
+	if c.grpcAddr == "" {
+		return nil, fmt.Errorf("no gRPC address configured for live alerts")
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if c.token != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{source: c.token}))
+	}
+	conn, err := grpc.NewClient(c.grpcAddr, append(opts, c.grpcOpts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", c.grpcAddr, err)
+	}
+	c.grpcConn = conn
+	return conn, nil
+}
+
+// tokenCredentials sends the bearer token as gRPC metadata
+type tokenCredentials struct {
+	source TokenSource
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := t.source(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+	if token == "" {
+		return nil, nil
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity allows tokens over plaintext connections, which
+// the API uses inside the cluster; pass TLS credentials with
+// WithGRPCDialOptions to protect them elsewhere
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// fromProto converts a wire alert to the model
+func fromProto(alert *alertspb.Alert) *model.SensorAlert {
+	return &model.SensorAlert{
+		SensorID:    alert.GetSensorId(),
+		Timestamp:   alert.GetTs(),
+		Reason:      alert.GetReason(),
+		Temperature: alert.GetTemperature(),
+		Humidity:    alert.GetHumidity(),
+		Site:        alert.GetSite(),
+		Rule:        alert.GetRule(),
+		RunbookURL:  alert.GetRunbookUrl(),
+		Annotations: alert.GetAnnotations(),
+	}
+}
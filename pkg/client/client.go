@@ -0,0 +1,301 @@
+// Package client is a Go client for the fleet query API. It wraps the REST
+// endpoints of the api-server with typed methods and the live alert gRPC
+// service with a callback subscription, retrying transient failures and
+// attaching credentials to every call.
+//
+//	c, err := client.New("http://api-server:8092",
+//		client.WithToken(os.Getenv("FLEET_API_TOKEN")),
+//		client.WithGRPCAddress("api-server:8093"))
+//	if err != nil { ... }
+//	defer c.Close()
+//	summary, err := c.FleetSummary(ctx)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Default retry policy
+const (
+	DefaultMaxAttempts    = 3
+	DefaultInitialBackoff = 200 * time.Millisecond
+	DefaultMaxBackoff     = 5 * time.Second
+	// DefaultTimeout bounds each call returning JSON, including retries
+	DefaultTimeout = 30 * time.Second
+)
+
+// ErrNotFound matches errors for resources the API does not have, such as
+// the latest reading of a sensor that never reported
+var ErrNotFound = errors.New("not found")
+
+// APIError is returned for responses with an error status
+type APIError struct {
+	StatusCode int
+	// Message is the error message of the response body, if it had one
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("fleet API returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("fleet API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Is lets errors.Is(err, ErrNotFound) match 404 responses
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// TokenSource returns the bearer token for a call. It is called for every
+// attempt, so rotated tokens are picked up without recreating the client.
+type TokenSource func(ctx context.Context) (string, error)
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client of REST calls, e.g. to configure TLS
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.http = httpClient
+	}
+}
+
+// WithToken authenticates every call with a static bearer token
+func WithToken(token string) Option {
+	return WithTokenSource(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// WithTimeout bounds each call returning JSON, including its retries, on top
+// of the caller's deadline (0 leaves only the caller's deadline). Streams
+// are bounded by the caller's context only.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithTokenSource authenticates every call with a bearer token from source
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) {
+		c.token = source
+	}
+}
+
+// WithRetry sets how many times a call is attempted and the exponential
+// backoff between attempts. Only connection failures and 429, 502, 503 and
+// 504 responses are retried; maxAttempts of 1 disables retries.
+func WithRetry(maxAttempts int, initialBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxAttempts = maxAttempts
+		c.initialBackoff = initialBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithGRPCAddress sets the host:port of the live alert gRPC service, which
+// SubscribeAlerts requires
+func WithGRPCAddress(addr string) Option {
+	return func(c *Client) {
+		c.grpcAddr = addr
+	}
+}
+
+// WithGRPCDialOptions adds options to the gRPC connection, e.g. transport
+// credentials; without them the connection is plaintext
+func WithGRPCDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *Client) {
+		c.grpcOpts = append(c.grpcOpts, opts...)
+	}
+}
+
+// Client calls the fleet query API. It is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	token   TokenSource
+	timeout time.Duration
+
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	grpcAddr string
+	grpcOpts []grpc.DialOption
+	grpcMu   sync.Mutex
+	grpcConn *grpc.ClientConn
+}
+
+// New creates a client of the API served at baseURL, e.g. http://localhost:8092
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: expected http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:        u,
+		http:           &http.Client{},
+		timeout:        DefaultTimeout,
+		maxAttempts:    DefaultMaxAttempts,
+		initialBackoff: DefaultInitialBackoff,
+		maxBackoff:     DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxAttempts < 1 {
+		c.maxAttempts = 1
+	}
+	return c, nil
+}
+
+// Close closes the gRPC connection, if one was opened
+func (c *Client) Close() error {
+	c.grpcMu.Lock()
+	defer c.grpcMu.Unlock()
+	if c.grpcConn == nil {
+		return nil
+	}
+	err := c.grpcConn.Close()
+	c.grpcConn = nil
+	return err
+}
+
+// getJSON decodes the JSON body of a GET request into v
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	resp, err := c.get(ctx, path, query, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// get sends a GET request, retrying transient failures, and returns the
+// response if its status is 200. The caller closes the body.
+func (c *Client) get(ctx context.Context, path string, query url.Values, accept string) (*http.Response, error) {
+	u := c.baseURL.JoinPath(path)
+	u.RawQuery = query.Encode()
+
+	delay := c.initialBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(ctx, u.String(), accept)
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		wait := delay
+		if err == nil {
+			err = responseError(resp)
+			if after := retryAfter(resp); after > 0 {
+				wait = after
+			}
+		}
+		if attempt >= c.maxAttempts || !retryable(ctx, err) {
+			return nil, err
+		}
+
+		// Jitter keeps clients that failed together from retrying in lockstep
+		wait = time.Duration(float64(wait) * (0.8 + 0.4*rand.Float64()))
+		if wait > c.maxBackoff {
+			wait = c.maxBackoff
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		delay *= 2
+		if delay > c.maxBackoff {
+			delay = c.maxBackoff
+		}
+	}
+}
+
+// attempt sends one GET request with credentials
+func (c *Client) attempt(ctx context.Context, target, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get API token: %w", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return c.http.Do(req)
+}
+
+// responseError reads the error of a response and closes its body
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil {
+		apiErr.Message = body.Error
+	}
+	return apiErr
+}
+
+// retryable reports whether a failed attempt may succeed when repeated
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr)
+}
+
+// retryAfter returns the delay a response asks for in its Retry-After header
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/example/iot-sensor-fleet/internal/api"
+	"github.com/example/iot-sensor-fleet/internal/model"
+)
+
+// Response types of the REST API
+type (
+	FleetSummary      = api.FleetSummary
+	Series            = api.Series
+	Point             = api.Point
+	ReadingPage       = api.Page[*model.SensorReading]
+	AlertPage         = api.Page[*model.SensorAlert]
+	ArchiveObject     = api.ArchiveObject
+	ArchiveObjectPage = api.Page[*api.ArchiveObject]
+)
+
+// PageQuery selects a page of a list; zero fields use the API defaults.
+// Continue with the NextCursor of the previous page in Cursor.
+type PageQuery struct {
+	// From and To bound the timestamps to [From, To); zero times leave the range open
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Cursor string
+}
+
+// values encodes the query parameters
+func (q PageQuery) values() url.Values {
+	values := url.Values{}
+	if !q.From.IsZero() {
+		values.Set("from", strconv.FormatInt(q.From.UnixMilli(), 10))
+	}
+	if !q.To.IsZero() {
+		values.Set("to", strconv.FormatInt(q.To.UnixMilli(), 10))
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		values.Set("cursor", q.Cursor)
+	}
+	return values
+}
+
+// Health checks that the API is serving requests
+func (c *Client) Health(ctx context.Context) error {
+	var status map[string]string
+	return c.getJSON(ctx, "/healthz", nil, &status)
+}
+
+// FleetSummary returns the sensors, readings and alerts of the fleet over
+// the API's summary window
+func (c *Client) FleetSummary(ctx context.Context) (*FleetSummary, error) {
+	summary := &FleetSummary{}
+	if err := c.getJSON(ctx, "/api/v1/fleet/summary", nil, summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// LatestReading returns the most recent reading of a sensor. The error
+// matches ErrNotFound if the sensor has no readings.
+func (c *Client) LatestReading(ctx context.Context, sensorID string) (*model.SensorReading, error) {
+	reading := &model.SensorReading{}
+	if err := c.getJSON(ctx, "/api/v1/sensors/"+url.PathEscape(sensorID)+"/readings/latest", nil, reading); err != nil {
+		return nil, err
+	}
+	return reading, nil
+}
+
+// ListReadings returns a page of a sensor's readings, newest first
+func (c *Client) ListReadings(ctx context.Context, sensorID string, query PageQuery) (*ReadingPage, error) {
+	page := &ReadingPage{}
+	if err := c.getJSON(ctx, readingsPath(sensorID), query.values(), page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// StreamReadings calls fn for every reading of a sensor matching query,
+// newest first, as the API streams them. Every matching reading is streamed
+// unless query sets a limit. An error from fn stops the stream and is returned.
+func (c *Client) StreamReadings(ctx context.Context, sensorID string, query PageQuery, fn func(*model.SensorReading) error) error {
+	values := query.values()
+	values.Set("format", "ndjson")
+	resp, err := c.get(ctx, readingsPath(sensorID), values, "application/x-ndjson")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		reading := &model.SensorReading{}
+		if err := json.Unmarshal(scanner.Bytes(), reading); err != nil {
+			return fmt.Errorf("failed to decode streamed reading: %w", err)
+		}
+		if err := fn(reading); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stream ended early: %w", err)
+	}
+	return nil
+}
+
+// DownsampleReadings returns a sensor's readings in [from, to) averaged into
+// at most points buckets; zero times extend the range to the sensor's first
+// and last reading
+func (c *Client) DownsampleReadings(ctx context.Context, sensorID string, from, to time.Time, points int) (*Series, error) {
+	values := PageQuery{From: from, To: to}.values()
+	values.Set("downsample", strconv.Itoa(points))
+	series := &Series{}
+	if err := c.getJSON(ctx, readingsPath(sensorID), values, series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// ListAlerts returns a page of alerts, newest first, of one sensor or of
+// every sensor if sensorID is empty
+func (c *Client) ListAlerts(ctx context.Context, sensorID string, query PageQuery) (*AlertPage, error) {
+	values := query.values()
+	if sensorID != "" {
+		values.Set("sensor_id", sensorID)
+	}
+	page := &AlertPage{}
+	if err := c.getJSON(ctx, "/api/v1/alerts", values, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// ActiveAlerts returns the alerts raised within the API's active alert window
+func (c *Client) ActiveAlerts(ctx context.Context) ([]*model.SensorAlert, error) {
+	var alerts []*model.SensorAlert
+	if err := c.getJSON(ctx, "/api/v1/alerts/active", nil, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// ListArchiveObjects returns a page of the cold-storage objects holding
+// readings in the query's range, newest first, of one sensor or of every
+// sensor if sensorID is empty
+func (c *Client) ListArchiveObjects(ctx context.Context, sensorID string, query PageQuery) (*ArchiveObjectPage, error) {
+	values := query.values()
+	if sensorID != "" {
+		values.Set("sensor_id", sensorID)
+	}
+	page := &ArchiveObjectPage{}
+	if err := c.getJSON(ctx, "/api/v1/archive/objects", values, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func readingsPath(sensorID string) string {
+	return "/api/v1/sensors/" + url.PathEscape(sensorID) + "/readings"
+}
@@ -0,0 +1,85 @@
+// Package detector is the public API of the fleet's anomaly checks, for
+// services outside this repository that flag readings the same way the
+// anomaly detector does. It re-exports the stable parts of
+// internal/detector: the per-sensor threshold Validator and the statistical
+// StatsEngine, both of which are Checkers.
+//
+// The package follows the module's semantic version: within a major version
+// its identifiers are only added, never removed or changed incompatibly, and
+// the rule names returned by checkers keep their values. Types are aliases
+// of the internal ones, so values pass freely between this package and the
+// services of this repository.
+//
+//	validator := detector.NewValidator(detector.DefaultThresholds, nil)
+//	if rule, reason := validator.Check(reading); rule != "" { ... }
+package detector
+
+import (
+	"github.com/example/iot-sensor-fleet/internal/detector"
+	"github.com/example/iot-sensor-fleet/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Checker types
+type (
+	// Checker flags anomalous readings
+	Checker = detector.Checker
+	// Thresholds are the limits a reading is checked against
+	Thresholds = detector.Thresholds
+	// Validator checks readings against default thresholds, overridden per sensor
+	Validator = detector.Validator
+	// StatsConfig configures statistical anomaly detection
+	StatsConfig = detector.StatsConfig
+	// StatsEngine flags readings deviating from the sensor's rolling mean
+	StatsEngine = detector.StatsEngine
+	// StatsMetrics holds Prometheus metrics for statistical anomaly detection
+	StatsMetrics = detector.StatsMetrics
+)
+
+// Rules a checker reports a reading as violating
+const (
+	RuleTemperatureHigh      = model.RuleTemperatureHigh
+	RuleHumidityLow          = model.RuleHumidityLow
+	RuleTemperatureDeviation = model.RuleTemperatureDeviation
+	RuleHumidityDeviation    = model.RuleHumidityDeviation
+	RuleBatteryLow           = model.RuleBatteryLow
+	RuleSignalWeak           = model.RuleSignalWeak
+)
+
+// Threshold names used in override specs
+const (
+	ThresholdMaxTemperature = detector.ThresholdMaxTemperature
+	ThresholdMinHumidity    = detector.ThresholdMinHumidity
+	ThresholdMinBatteryPct  = detector.ThresholdMinBatteryPct
+	ThresholdMinRSSI        = detector.ThresholdMinRSSI
+)
+
+// DefaultThresholds are the detector's thresholds when none are configured
+var DefaultThresholds = Thresholds{
+	MaxTemperature: model.DefaultMaxTemperature,
+	MinHumidity:    model.DefaultMinHumidity,
+	MinBatteryPct:  model.DefaultMinBatteryPct,
+	MinRSSI:        model.DefaultMinRSSI,
+}
+
+// NewValidator creates a validator; overrides maps sensor IDs to their thresholds
+func NewValidator(defaults Thresholds, overrides map[string]Thresholds) *Validator {
+	return detector.NewValidator(defaults, overrides)
+}
+
+// ParseThresholdOverrides parses per-sensor overrides of the form
+// "sensor=max_temperature:45,min_humidity:5;sensor=max_temperature:60".
+// Thresholds a sensor does not override keep their default.
+func ParseThresholdOverrides(spec string, defaults Thresholds) (map[string]Thresholds, error) {
+	return detector.ParseThresholdOverrides(spec, defaults)
+}
+
+// NewStatsEngine creates a new stats engine; metrics may be nil
+func NewStatsEngine(config StatsConfig, metrics *StatsMetrics) (*StatsEngine, error) {
+	return detector.NewStatsEngine(config, metrics)
+}
+
+// NewStatsMetrics creates and registers the stats engine metrics
+func NewStatsMetrics(namespace, subsystem string, registry prometheus.Registerer) *StatsMetrics {
+	return detector.NewStatsMetrics(namespace, subsystem, registry)
+}
@@ -0,0 +1,157 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+)
+
+// Message header keys
+const (
+	HeaderSentAt            = kafka.HeaderSentAt
+	HeaderHop               = kafka.HeaderHop
+	HeaderDLTReason         = kafka.HeaderDLTReason
+	HeaderDLTSource         = kafka.HeaderDLTSource
+	HeaderDLTFailedAt       = kafka.HeaderDLTFailedAt
+	HeaderReplayCount       = kafka.HeaderReplayCount
+	HeaderTraceID           = kafka.HeaderTraceID
+	HeaderSchemaVersion     = kafka.HeaderSchemaVersion
+	HeaderFormat            = kafka.HeaderFormat
+	HeaderClientID          = kafka.HeaderClientID
+	HeaderFirmwareVersion   = kafka.HeaderFirmwareVersion
+	HeaderRetryCount        = kafka.HeaderRetryCount
+	HeaderOriginalTopic     = kafka.HeaderOriginalTopic
+	HeaderOriginalPartition = kafka.HeaderOriginalPartition
+	HeaderOriginalOffset    = kafka.HeaderOriginalOffset
+)
+
+// Pipeline stages recorded in hop headers
+const (
+	HopProduced  = kafka.HopProduced
+	HopConsumed  = kafka.HopConsumed
+	HopDetected  = kafka.HopDetected
+	HopPersisted = kafka.HopPersisted
+)
+
+// Header types
+type (
+	// Hop is one pipeline stage a message passed through
+	Hop = kafka.Hop
+	// Origin is where a dead-lettered message was consumed from
+	Origin = kafka.Origin
+)
+
+// Header returns the value of a message's last header with key
+func Header(message *sarama.ConsumerMessage, key string) (string, bool) {
+	return kafka.Header(message, key)
+}
+
+// ContextWithHeaders returns a context whose sends carry the given headers
+func ContextWithHeaders(ctx context.Context, headers ...sarama.RecordHeader) context.Context {
+	return kafka.ContextWithHeaders(ctx, headers...)
+}
+
+// NewTraceID returns a new random trace ID
+func NewTraceID() string {
+	return kafka.NewTraceID()
+}
+
+// ContextWithTraceID returns a context whose sends carry the trace ID
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return kafka.ContextWithTraceID(ctx, traceID)
+}
+
+// TraceIDFromContext returns the trace ID carried by ctx
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	return kafka.TraceIDFromContext(ctx)
+}
+
+// TraceIDHeader returns the header carrying a trace ID
+func TraceIDHeader(traceID string) sarama.RecordHeader {
+	return kafka.TraceIDHeader(traceID)
+}
+
+// TraceID returns the trace ID of a message
+func TraceID(message *sarama.ConsumerMessage) (string, bool) {
+	return kafka.TraceID(message)
+}
+
+// SchemaVersionHeader returns the header carrying a payload schema version
+func SchemaVersionHeader(version int) sarama.RecordHeader {
+	return kafka.SchemaVersionHeader(version)
+}
+
+// FormatHeader returns the header naming a payload's wire format
+func FormatHeader(format string) sarama.RecordHeader {
+	return kafka.FormatHeader(format)
+}
+
+// PayloadFormat returns the wire format a message's producer declared, or ""
+func PayloadFormat(message *sarama.ConsumerMessage) string {
+	return kafka.PayloadFormat(message)
+}
+
+// SchemaVersion returns the payload schema version of a message
+func SchemaVersion(message *sarama.ConsumerMessage) (int, bool) {
+	return kafka.SchemaVersion(message)
+}
+
+// FirmwareVersionHeader returns the header carrying a device firmware version
+func FirmwareVersionHeader(version string) sarama.RecordHeader {
+	return kafka.FirmwareVersionHeader(version)
+}
+
+// FirmwareVersion returns the firmware of the device a message comes from
+func FirmwareVersion(message *sarama.ConsumerMessage) (string, bool) {
+	return kafka.FirmwareVersion(message)
+}
+
+// ClientID returns the client that produced a message
+func ClientID(message *sarama.ConsumerMessage) (string, bool) {
+	return kafka.ClientID(message)
+}
+
+// RetryCountFromContext returns how many times the handler already retried
+// the message being handled
+func RetryCountFromContext(ctx context.Context) int {
+	return kafka.RetryCountFromContext(ctx)
+}
+
+// RetryCount returns how many times a message was retried before it was
+// dead-lettered
+func RetryCount(message *sarama.ConsumerMessage) int {
+	return kafka.RetryCount(message)
+}
+
+// MessageOrigin returns where a dead-lettered message was originally consumed from
+func MessageOrigin(message *sarama.ConsumerMessage) (Origin, bool) {
+	return kafka.MessageOrigin(message)
+}
+
+// DLTHeaders returns the headers of a message sent to a dead-letter topic
+// because handling it failed with reason
+func DLTHeaders(ctx context.Context, message *sarama.ConsumerMessage, reason error, at time.Time) []sarama.RecordHeader {
+	return kafka.DLTHeaders(ctx, message, reason, at)
+}
+
+// Hops returns the pipeline stages recorded on a message
+func Hops(message *sarama.ConsumerMessage) []Hop {
+	return kafka.Hops(message)
+}
+
+// HopsFromContext returns the hops of the message being handled
+func HopsFromContext(ctx context.Context) []Hop {
+	return kafka.HopsFromContext(ctx)
+}
+
+// FormatHops renders hops with the latency between stages
+func FormatHops(hops []Hop) string {
+	return kafka.FormatHops(hops)
+}
+
+// InTransaction reports whether messages sent with ctx join a transaction
+func InTransaction(ctx context.Context) bool {
+	return kafka.InTransaction(ctx)
+}
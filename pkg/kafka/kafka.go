@@ -0,0 +1,169 @@
+// Package kafka is the public API of the fleet's Kafka producer and consumer
+// wrappers, for services outside this repository that read or write the
+// fleet's topics. It re-exports the stable parts of internal/kafka: the
+// Producer and Consumer with their metrics, security and transaction
+// settings, the sarama option helpers and the standard message headers.
+//
+// The package follows the module's semantic version: within a major version
+// its identifiers are only added, never removed or changed incompatibly.
+// Types are aliases of the internal ones, so values pass freely between
+// this package and the services of this repository.
+//
+//	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+//		Brokers: []string{"kafka:9092"},
+//		Topic:   "sensor.reading",
+//		Version: kafka.DefaultKafkaVersion,
+//	})
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/IBM/sarama"
+	"github.com/example/iot-sensor-fleet/internal/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Producer and consumer types
+type (
+	// Producer sends messages to Kafka; see NewProducer
+	Producer = kafka.Producer
+	// ProducerConfig holds configuration for the producer
+	ProducerConfig = kafka.ProducerConfig
+	// ProducerMetrics holds Prometheus metrics for the producer
+	ProducerMetrics = kafka.ProducerMetrics
+
+	// Consumer handles the messages of a consumer group; see NewConsumer
+	Consumer = kafka.Consumer
+	// ConsumerConfig holds configuration for the consumer
+	ConsumerConfig = kafka.ConsumerConfig
+	// ConsumerMetrics holds Prometheus metrics for the consumer
+	ConsumerMetrics = kafka.ConsumerMetrics
+	// ClockMetrics records clock deltas from messages carrying send timestamps
+	ClockMetrics = kafka.ClockMetrics
+	// MessageHandler handles one consumed message; an error retries the message
+	MessageHandler = kafka.MessageHandler
+
+	// SecurityConfig holds the TLS and SASL settings of broker connections
+	SecurityConfig = kafka.SecurityConfig
+	// RetryPolicy bounds the attempts at a send or at handling a message
+	RetryPolicy = kafka.RetryPolicy
+	// DeadLetterFunc forwards a message whose handler failed every attempt;
+	// see DeadLetterTo
+	DeadLetterFunc = kafka.DeadLetterFunc
+	// TransactionConfig makes a consumer handle each message in a Kafka
+	// transaction that also commits its offset
+	TransactionConfig = kafka.TransactionConfig
+	// InflightBudget caps the memory of messages consumers took and have not
+	// handled yet; see NewInflightBudget
+	InflightBudget = kafka.InflightBudget
+	// InflightMetrics holds Prometheus metrics for an in-flight budget
+	InflightMetrics = kafka.InflightMetrics
+
+	// OptionFunc configures the sarama client of a publisher or consumer
+	OptionFunc = kafka.OptionFunc
+)
+
+// Default configuration values
+const (
+	DefaultKafkaVersion   = kafka.DefaultKafkaVersion
+	DefaultWorkerPoolSize = kafka.DefaultWorkerPoolSize
+)
+
+// ErrProducerClosed is returned for sends after a producer began shutting down
+var ErrProducerClosed = kafka.ErrProducerClosed
+
+// ErrNoDeadLetterTopic is returned by dead-letter hooks for messages whose
+// topic has no dead-letter topic
+var ErrNoDeadLetterTopic = kafka.ErrNoDeadLetterTopic
+
+// DefaultRetryPolicy is used by producers and consumers configured without a retry policy
+var DefaultRetryPolicy = kafka.DefaultRetryPolicy
+
+// NewProducer creates a producer
+func NewProducer(config ProducerConfig) (*Producer, error) {
+	return kafka.NewProducer(config)
+}
+
+// NewConsumer creates a consumer that calls handler for every message of
+// the configured topics
+func NewConsumer(config ConsumerConfig, handler MessageHandler) (*Consumer, error) {
+	return kafka.NewConsumer(config, handler)
+}
+
+// DeadLetterTo returns a hook sending failed messages through producer,
+// with their headers and the dead-letter headers, to the topic topics maps
+// their topic to or else to the producer's topic
+func DeadLetterTo(producer *Producer, topics map[string]string) DeadLetterFunc {
+	return kafka.DeadLetterTo(producer, topics)
+}
+
+// NewProducerMetrics creates and registers the producer metrics
+func NewProducerMetrics(namespace, subsystem string, registry prometheus.Registerer) *ProducerMetrics {
+	return kafka.NewProducerMetrics(namespace, subsystem, registry)
+}
+
+// NewConsumerMetrics creates and registers the consumer metrics
+func NewConsumerMetrics(namespace, subsystem string, registry prometheus.Registerer) *ConsumerMetrics {
+	return kafka.NewConsumerMetrics(namespace, subsystem, registry)
+}
+
+// NewClockMetrics creates and registers the clock delta metrics
+func NewClockMetrics(namespace, subsystem string, registry prometheus.Registerer) *ClockMetrics {
+	return kafka.NewClockMetrics(namespace, subsystem, registry)
+}
+
+// NewInflightBudget creates a budget of limit bytes, shared by the consumers
+// configured with it. metrics may be nil.
+func NewInflightBudget(limit int64, metrics *InflightMetrics) *InflightBudget {
+	return kafka.NewInflightBudget(limit, metrics)
+}
+
+// NewInflightMetrics creates and registers the in-flight budget metrics
+func NewInflightMetrics(namespace, subsystem string, registry prometheus.Registerer) *InflightMetrics {
+	return kafka.NewInflightMetrics(namespace, subsystem, registry)
+}
+
+// NewTLSConfig builds a client TLS configuration. certFile and keyFile enable
+// mutual TLS when both are set; caFile replaces the system roots when set.
+func NewTLSConfig(certFile, keyFile, caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	return kafka.NewTLSConfig(certFile, keyFile, caFile, insecureSkipVerify)
+}
+
+// ParseSASLMechanism validates a SASL mechanism name (case-insensitive)
+func ParseSASLMechanism(name string) (sarama.SASLMechanism, error) {
+	return kafka.ParseSASLMechanism(name)
+}
+
+// Ping connects to the cluster and fetches its metadata, returning an error
+// if no broker is reachable
+func Ping(brokers []string, version string, security SecurityConfig) error {
+	return kafka.Ping(brokers, version, security)
+}
+
+// WithKafkaVersion sets the protocol version spoken to the brokers
+func WithKafkaVersion(version string) OptionFunc {
+	return kafka.WithKafkaVersion(version)
+}
+
+// WithTLS encrypts broker connections
+func WithTLS(tlsConfig *tls.Config) OptionFunc {
+	return kafka.WithTLS(tlsConfig)
+}
+
+// WithSASL authenticates broker connections
+func WithSASL(mechanism sarama.SASLMechanism, username, password string) OptionFunc {
+	return kafka.WithSASL(mechanism, username, password)
+}
+
+// WithIdempotence writes every message once per partition despite retries
+func WithIdempotence() OptionFunc {
+	return kafka.WithIdempotence()
+}
+
+// AddInflightBytes accounts n more bytes, such as the decoded form of the
+// payload, to the message handled with ctx until it is done with
+func AddInflightBytes(ctx context.Context, n int) {
+	kafka.AddInflightBytes(ctx, n)
+}
@@ -0,0 +1,148 @@
+// Package model is the public API of the fleet's message payloads and their
+// serializers, for services outside this repository that produce or consume
+// readings and alerts. It re-exports the stable parts of internal/model: the
+// reading and alert types, their JSON, Avro and Confluent wire formats, the
+// format-sniffing decoder and the Schema Registry client.
+//
+// The package follows the module's semantic version: within a major version
+// its identifiers are only added, never removed or changed incompatibly, and
+// SchemaVersion only grows with payload changes old readers can ignore.
+// Types are aliases of the internal ones, so values pass freely between this
+// package and the services of this repository.
+package model
+
+import "github.com/example/iot-sensor-fleet/internal/model"
+
+// Payload types
+type (
+	// SensorReading represents a reading from an IoT sensor
+	SensorReading = model.SensorReading
+	// SensorAlert represents an alert generated from an anomalous sensor reading
+	SensorAlert = model.SensorAlert
+	// GeoPoint is the location of a sensor
+	GeoPoint = model.GeoPoint
+)
+
+// SerDe types
+type (
+	// ReadingDecoder decodes sensor readings by sniffing the payload against
+	// an ordered, per-topic list of formats
+	ReadingDecoder = model.ReadingDecoder
+	// ReadingSerializer encodes sensor readings in one wire format
+	ReadingSerializer = model.ReadingSerializer
+	// SchemaRegistry is a minimal Confluent Schema Registry client
+	SchemaRegistry = model.SchemaRegistry
+)
+
+// Wire formats a sensor reading can be encoded in
+const (
+	FormatConfluent  = model.FormatConfluent
+	FormatAvro       = model.FormatAvro
+	FormatJSONSchema = model.FormatJSONSchema
+	FormatJSON       = model.FormatJSON
+)
+
+// SchemaVersion is the version of the payload schemas, sent in the
+// x-schema-version header
+const SchemaVersion = model.SchemaVersion
+
+// SensorReadingAvroSchema is the Avro schema of sensor readings
+const SensorReadingAvroSchema = model.SensorReadingAvroSchema
+
+// Errors returned by the decoder and the Schema Registry client
+var (
+	ErrUnrecognizedFormat = model.ErrUnrecognizedFormat
+	ErrSchemaNotFound     = model.ErrSchemaNotFound
+)
+
+// NewSensorReading creates a new sensor reading with a random UUID
+func NewSensorReading(timestamp int64, temperature, humidity float32) *SensorReading {
+	return model.NewSensorReading(timestamp, temperature, humidity)
+}
+
+// NewSensorAlert creates a new sensor alert from a sensor reading
+func NewSensorAlert(reading *SensorReading, reason string) *SensorAlert {
+	return model.NewSensorAlert(reading, reason)
+}
+
+// SerializeSensorReading serializes a sensor reading to JSON format
+func SerializeSensorReading(reading *SensorReading) ([]byte, error) {
+	return model.SerializeSensorReading(reading)
+}
+
+// DeserializeSensorReading deserializes JSON data to a sensor reading
+func DeserializeSensorReading(data []byte) (*SensorReading, error) {
+	return model.DeserializeSensorReading(data)
+}
+
+// SerializeSensorReadingAvro serializes a sensor reading to Avro binary encoding
+func SerializeSensorReadingAvro(reading *SensorReading) ([]byte, error) {
+	return model.SerializeSensorReadingAvro(reading)
+}
+
+// DeserializeSensorReadingAvro deserializes Avro binary data to a sensor reading
+func DeserializeSensorReadingAvro(data []byte) (*SensorReading, error) {
+	return model.DeserializeSensorReadingAvro(data)
+}
+
+// SerializeSensorReadingJSONSchema serializes a sensor reading as JSON wrapped
+// in an envelope carrying its schema
+func SerializeSensorReadingJSONSchema(reading *SensorReading) ([]byte, error) {
+	return model.SerializeSensorReadingJSONSchema(reading)
+}
+
+// DeserializeSensorReadingJSONSchema deserializes a json-schema envelope
+func DeserializeSensorReadingJSONSchema(data []byte) (*SensorReading, error) {
+	return model.DeserializeSensorReadingJSONSchema(data)
+}
+
+// SerializeSensorReadingConfluent serializes a sensor reading to the
+// Confluent wire format: magic byte, big-endian schema ID, then the Avro body
+func SerializeSensorReadingConfluent(reading *SensorReading, schemaID int32) ([]byte, error) {
+	return model.SerializeSensorReadingConfluent(reading, schemaID)
+}
+
+// DeserializeSensorReadingConfluent deserializes a Confluent wire-format reading
+func DeserializeSensorReadingConfluent(data []byte) (*SensorReading, error) {
+	return model.DeserializeSensorReadingConfluent(data)
+}
+
+// ConfluentSchemaID returns the schema ID of a Confluent wire-format message
+func ConfluentSchemaID(data []byte) (int32, bool) {
+	return model.ConfluentSchemaID(data)
+}
+
+// SerializeSensorAlert serializes a sensor alert to JSON format
+func SerializeSensorAlert(alert *SensorAlert) ([]byte, error) {
+	return model.SerializeSensorAlert(alert)
+}
+
+// DeserializeSensorAlert deserializes JSON data to a sensor alert
+func DeserializeSensorAlert(data []byte) (*SensorAlert, error) {
+	return model.DeserializeSensorAlert(data)
+}
+
+// NewReadingDecoder creates a decoder from a per-topic format list. Topics
+// missing from the map are sniffed as Confluent, Avro, then JSON.
+func NewReadingDecoder(topics map[string][]string) (*ReadingDecoder, error) {
+	return model.NewReadingDecoder(topics)
+}
+
+// NewReadingSerializer creates a serializer for format. The Confluent format
+// needs a registry and the subject to register the schema under, by
+// convention "<topic>-value".
+func NewReadingSerializer(format string, registry *SchemaRegistry, subject string) (*ReadingSerializer, error) {
+	return model.NewReadingSerializer(format, registry, subject)
+}
+
+// NewSchemaRegistry creates a client for the registry at url
+func NewSchemaRegistry(url string) *SchemaRegistry {
+	return model.NewSchemaRegistry(url)
+}
+
+// InitSchemaRegistry sets the Schema Registry that Confluent-framed readings
+// are checked against when decoded. An empty url disables the check. It must
+// be called before readings are decoded.
+func InitSchemaRegistry(url string) {
+	model.InitSchemaRegistry(url)
+}
@@ -0,0 +1,48 @@
+#!/bin/bash
+
+# api-check.sh - Compare the public API of the pkg/ packages with the
+# snapshot in pkg/api.txt, which changes only with a deliberate version bump.
+# The snapshot holds the declarations of every exported identifier, including
+# the fields and methods of the internal types the packages alias, without
+# their doc comments.
+#
+# Usage: scripts/api-check.sh          fail if the API differs from the snapshot
+#        scripts/api-check.sh -update  rewrite the snapshot
+
+set -euo pipefail
+
+cd "$(dirname "$0")/.."
+SNAPSHOT=pkg/api.txt
+
+# declarations prints go doc output without the package doc and doc comments
+declarations() {
+  go doc -all "$1" | awk '/^package / { skip = 1; print; next } /^[A-Z]+$/ { skip = 0 } !skip' |
+    grep -v '^    \|^	*//\|^$' || true
+}
+
+snapshot() {
+  for pkg in $(go list ./pkg/...); do
+    echo "== $pkg"
+    declarations "$pkg"
+    # Aliased internal types are part of the API; resolve each to its package
+    go doc -all "$pkg" | sed -n 's/^type \([A-Za-z]*\) = \([a-z]*\)\.\([A-Za-z]*\).*/\2 \3/p' | sort -u |
+      while read -r name symbol; do
+        target=$(go list -f '{{join .Imports "\n"}}' "$pkg" | grep "/internal/.*$name\$" | head -n 1)
+        echo "== $target.$symbol"
+        declarations "$target.$symbol"
+      done
+  done
+}
+
+if [ "${1:-}" = "-update" ]; then
+  snapshot > "$SNAPSHOT"
+  echo "Updated $SNAPSHOT"
+  exit 0
+fi
+
+if ! diff -u "$SNAPSHOT" <(snapshot); then
+  echo "The public API in pkg/ changed. Removing or changing identifiers needs a new major version;"
+  echo "after adding identifiers, run 'make api-update' and bump the minor version."
+  exit 1
+fi
+echo "Public API matches $SNAPSHOT"
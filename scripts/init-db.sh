@@ -14,11 +14,12 @@ echo "Creating tables in PostgreSQL..."
 PGPASSWORD=$POSTGRES_PASSWORD psql -h $POSTGRES_HOST -U $POSTGRES_USER -d $POSTGRES_DB << EOF
 -- Create sensor_readings table if it doesn't exist
 CREATE TABLE IF NOT EXISTS sensor_readings (
-  id VARCHAR(36) PRIMARY KEY,
+  sensor_id VARCHAR(36) NOT NULL,
   ts BIGINT NOT NULL,
   temperature REAL NOT NULL,
   humidity REAL NOT NULL,
-  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (sensor_id, ts)
 );
 
 -- Create sensor_alerts table if it doesn't exist